@@ -0,0 +1,171 @@
+package agent
+
+import (
+	"context"
+
+	"github.com/agentplexus/omnivoice-examples/kit/llm"
+)
+
+// Agent decides what to say (and do) in reply to the caller.
+//
+// OnUserTurn returns a channel of responses that is closed once the agent
+// has finished the turn. Agents should stop early and close the channel
+// when ctx is cancelled, e.g. because the caller barged in. A single Agent
+// serves every session concurrently.
+type Agent interface {
+	OnUserTurn(ctx context.Context, turn Turn) (<-chan Response, error)
+}
+
+// Greeter is implemented by agents that open the conversation themselves.
+type Greeter interface {
+	Greeting(sessionID string) string
+}
+
+// SessionEnder is implemented by agents that keep per-session state; the
+// host calls EndSession when the call ends.
+type SessionEnder interface {
+	EndSession(sessionID string)
+}
+
+// Resumer is implemented by agents that keep a conversation history per
+// session. The host uses it to carry a call's conversation over to a new
+// session, e.g. when the call's stream reconnects to another instance.
+type Resumer interface {
+	// History returns the session's conversation so far.
+	History(sessionID string) []llm.Message
+	// Resume starts the session with an earlier session's history.
+	Resume(sessionID string, history []llm.Message)
+}
+
+// Interrupter is implemented by agents that keep a conversation history
+// per session. When the caller barges in on a reply, the host reports what
+// they heard of it, so the history doesn't claim the agent said what the
+// caller never heard.
+type Interrupter interface {
+	// Interrupted reports that the reply to turn was cut off after heard,
+	// the utterances the caller heard in full.
+	Interrupted(sessionID string, turn int, heard string)
+}
+
+// Recorder is implemented by agents that keep a conversation history per
+// session. When the host answers a turn itself, without the agent, e.g.
+// with a canned reply, it records the exchange so the agent's later
+// replies follow on from it.
+type Recorder interface {
+	// Answered records that the host answered turn with reply.
+	Answered(turn Turn, reply string)
+}
+
+// Briefer is implemented by agents that can be told about the caller
+// before the conversation starts, e.g. their customer profile from a CRM.
+type Briefer interface {
+	// Brief adds brief to the instructions the agent follows for the
+	// session, replacing any earlier brief.
+	Brief(sessionID, brief string)
+}
+
+// Tasker is implemented by agents that can be partway through a task, e.g.
+// collecting a value the caller is spelling. The host confirms a goodbye
+// said mid-task before hanging up.
+type Tasker interface {
+	// MidTask reports whether the session is partway through a task.
+	MidTask(sessionID string) bool
+}
+
+// Turn is one complete utterance from the caller.
+type Turn struct {
+	SessionID string
+	// Index counts the caller's turns in the session, starting at 1.
+	Index int
+	// Text is what the caller said, as the host hands it on: usually with
+	// numbers, dates and email addresses written out ("555-1212",
+	// "2025-03-03") by speech.Denormalize. It is empty if the caller said
+	// nothing before the host gave up waiting, as session.Options.NoInput
+	// does.
+	Text string
+	// Spoken, if set, is the transcript before it was rewritten into
+	// Text, word for word as recognized.
+	Spoken string
+	// DTMF is set when Text is keys the caller pressed, e.g. "7", rather
+	// than what they said.
+	DTMF bool
+	// Attempt is 0 for the first try and counts up when the host retries
+	// the turn, e.g. because speaking the reply failed. Agents must treat
+	// a retry as replacing the earlier attempt, not as a new turn.
+	Attempt int
+	// Metadata is the call's context (stream parameters, SIP headers).
+	Metadata map[string]string
+	// MaxSentences, if positive, asks for a reply of at most that many
+	// sentences, e.g. while the host is degrading service. Agents that
+	// can't shorten their replies may ignore it.
+	MaxSentences int
+}
+
+// spoken returns the transcript as recognized.
+func (t Turn) spoken() string {
+	if t.Spoken != "" {
+		return t.Spoken
+	}
+	return t.Text
+}
+
+// Response is one item of an agent's reply: text to speak, an action for
+// the host to take, or both (the text is spoken first).
+type Response struct {
+	Text   string
+	Action *Action
+	// Specialist, set by a Team, names the specialist who answered.
+	Specialist string
+	// Err, when set, is the last response: the reply failed after it had
+	// started.
+	Err error
+}
+
+// ActionKind identifies a call action.
+type ActionKind string
+
+const (
+	// ActionHangup ends the call once everything queued has been spoken.
+	ActionHangup ActionKind = "hangup"
+	// ActionTransfer hands the caller to a human. Target, if set, is the
+	// number or SIP URI to dial instead of the host's default.
+	ActionTransfer ActionKind = "transfer"
+	// ActionPlay plays the host's prerecorded prompt named Target, in turn
+	// with the text around it.
+	ActionPlay ActionKind = "play"
+)
+
+// Action asks the host to change the call.
+type Action struct {
+	Kind   ActionKind
+	Target string
+}
+
+// Say returns a response that speaks text.
+func Say(text string) Response {
+	return Response{Text: text}
+}
+
+// Do returns a response that takes an action.
+func Do(kind ActionKind, target string) Response {
+	return Response{Action: &Action{Kind: kind, Target: target}}
+}
+
+// Reply returns a closed channel holding responses, for agents that
+// answer without streaming.
+func Reply(responses ...Response) <-chan Response {
+	ch := make(chan Response, len(responses))
+	for _, r := range responses {
+		ch <- r
+	}
+	close(ch)
+	return ch
+}
+
+// Func adapts a function to the Agent interface.
+type Func func(ctx context.Context, turn Turn) (<-chan Response, error)
+
+// OnUserTurn calls f.
+func (f Func) OnUserTurn(ctx context.Context, turn Turn) (<-chan Response, error) {
+	return f(ctx, turn)
+}
@@ -0,0 +1,24 @@
+// Package agent defines the "brain" of a voice agent behind a single
+// interface, so examples share the telephony and speech plumbing and differ
+// only in which Agent they construct.
+//
+// The host calls OnUserTurn with each complete caller utterance and speaks
+// the responses as they arrive on the returned channel:
+//
+//	responses, err := a.OnUserTurn(ctx, agent.Turn{SessionID: id, Index: n, Text: text})
+//	for r := range responses {
+//		speak(r.Text)
+//	}
+//
+// Echo is a canned-response bot, LLM streams replies from any kit/llm
+// provider a chunk at a time, and Script walks a fixed call flow.
+// Guardrails protect an LLM from callers' prompt injection attempts.
+// Team puts several LLM specialists, each with its own prompt and tools,
+// behind one agent and lets them hand the call to each other mid-call.
+//
+// Readback collects a value the caller spells out, such as a confirmation
+// code or an email address, reading it back with the phonetic alphabet
+// for the caller to confirm and asking about letters the recognizer may
+// have confused. Script steps run it when Collect is set, and
+// ReadbackTool gives a language model the same phonetic readback.
+package agent
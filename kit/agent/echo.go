@@ -0,0 +1,50 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Echo is a demo agent with a few canned responses that otherwise repeats
+// what the caller said.
+type Echo struct{}
+
+// NewEcho returns an echo agent.
+func NewEcho() *Echo {
+	return &Echo{}
+}
+
+// OnUserTurn answers with a single response.
+func (e *Echo) OnUserTurn(_ context.Context, turn Turn) (<-chan Response, error) {
+	return Reply(Say(e.respond(turn.Text))), nil
+}
+
+func (e *Echo) respond(input string) string {
+	input = strings.ToLower(input)
+
+	switch {
+	case strings.Contains(input, "hello") || strings.Contains(input, "hi"):
+		return "Hello! It's nice to hear from you. What would you like to talk about?"
+
+	case strings.Contains(input, "how are you"):
+		return "I'm doing great, thank you for asking! I'm here and ready to help you with anything you need."
+
+	case strings.Contains(input, "goodbye") || strings.Contains(input, "bye"):
+		return "Goodbye! It was nice talking with you. Have a wonderful day!"
+
+	case strings.Contains(input, "help"):
+		return "I can help you with various tasks. Just tell me what you need, and I'll do my best to assist you."
+
+	case strings.Contains(input, "weather"):
+		return "I don't have access to real-time weather data, but you could try asking a weather service for accurate forecasts."
+
+	case strings.Contains(input, "time"):
+		return fmt.Sprintf("The current time is %s.", time.Now().Format("3:04 PM"))
+
+	default:
+		// Echo back with acknowledgment
+		return fmt.Sprintf("I heard you say: %s. Is there anything specific you'd like me to help you with?", input)
+	}
+}
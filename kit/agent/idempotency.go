@@ -0,0 +1,51 @@
+package agent
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+)
+
+type (
+	idempotencyKeyContextKey struct{}
+	sessionIDContextKey      struct{}
+)
+
+// IdempotencyKey returns the key of the tool call being run, for passing to
+// APIs that deduplicate requests (payment and booking APIs usually do). It
+// is the same on every attempt of a turn for the same call and arguments.
+func IdempotencyKey(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(idempotencyKeyContextKey{}).(string)
+	return key, ok
+}
+
+// SessionID returns the session a tool call is being run for, so a tool
+// can act on the call it was made in, such as texting the caller.
+func SessionID(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(sessionIDContextKey{}).(string)
+	return id, ok
+}
+
+// toolCallKey derives a tool call's idempotency key from the session, the
+// turn, the tool and its arguments. Provider call IDs differ between
+// attempts, so they are not used.
+func toolCallKey(turn Turn, name string, args json.RawMessage) string {
+	sum := sha256.Sum256(canonicalJSON(args))
+	return fmt.Sprintf("%s/%d/%s/%s", turn.SessionID, turn.Index, name, hex.EncodeToString(sum[:8]))
+}
+
+// canonicalJSON re-encodes JSON with sorted keys and no insignificant
+// whitespace, so equivalent arguments hash alike.
+func canonicalJSON(data json.RawMessage) []byte {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		return data
+	}
+	out, err := json.Marshal(v)
+	if err != nil {
+		return data
+	}
+	return out
+}
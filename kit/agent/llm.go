@@ -0,0 +1,457 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/agentplexus/omnivoice-examples/kit/llm"
+	"github.com/agentplexus/omnivoice-examples/kit/speech"
+)
+
+// maxToolRounds bounds how many times a turn goes back to the model with
+// tool results before giving up.
+const maxToolRounds = 4
+
+// Built-in tools that let the model change the call.
+const (
+	toolEndCall  = "end_call"
+	toolTransfer = "transfer_to_human"
+)
+
+// Tool is a function the model may call while answering a turn. Its
+// result is passed back to the model.
+//
+// Unless Idempotent is set, a tool runs at most once per turn for the same
+// arguments: when the host retries a turn, the recorded result is replayed
+// to the model instead of calling the tool again. Call can read the call's
+// key with IdempotencyKey to extend the guarantee to external APIs, and
+// the session it runs for with SessionID.
+type Tool struct {
+	llm.Tool
+	Call func(ctx context.Context, args json.RawMessage) (string, error)
+	// Idempotent tools (lookups) are safe to run again on a retry.
+	Idempotent bool
+	// EndsTurn tools end the turn once they have run: the model isn't
+	// asked to reply to their result, e.g. because another agent answers.
+	EndsTurn bool
+}
+
+// LLM is an agent backed by a language model. Replies are streamed to the
+// host a chunk at a time, split by a speech.Chunker at sentence ends, list
+// items and (to start speaking sooner) clause breaks, so speech starts
+// before the model finishes.
+// Each session keeps its own conversation history.
+//
+// Besides any tools passed to NewLLM, the model can call end_call and
+// transfer_to_human, which become ActionHangup and ActionTransfer.
+type LLM struct {
+	provider llm.Provider
+	system   string
+	greeting string
+	tools    map[string]Tool
+	defs     []llm.Tool
+	// guardrails, if set, protect the model from prompt injection.
+	guardrails *Guardrails
+
+	mu       sync.Mutex
+	sessions map[string]*llmSession
+}
+
+// llmSession is one call's conversation and the turn in progress.
+type llmSession struct {
+	history []llm.Message
+	// turn and attempt identify the only attempt allowed to add to history;
+	// a superseded attempt still winding down must not.
+	turn, attempt int
+	// turnStart is the history length before the turn's user message.
+	turnStart int
+	// journal holds this turn's non-idempotent tool results by key.
+	journal map[string]string
+	// heard, if set, is what the caller heard of the turn's reply before
+	// barging in, for the reply to be cut to once committed.
+	heard *string
+	// brief describes the caller, following the system prompt.
+	brief string
+}
+
+// DefaultSystemPrompt keeps LLM replies short and speakable.
+const DefaultSystemPrompt = "You are a friendly voice assistant answering a phone call. " +
+	"Reply in one or two short spoken sentences, without lists, markdown or emoji."
+
+// NewLLM returns an agent that prompts provider with system. greeting, if
+// set, is spoken when the call starts and recorded in the history.
+func NewLLM(provider llm.Provider, system, greeting string, tools ...Tool) *LLM {
+	a := &LLM{
+		provider: provider,
+		system:   system,
+		greeting: greeting,
+		tools:    make(map[string]Tool),
+		sessions: make(map[string]*llmSession),
+		defs: []llm.Tool{
+			{
+				Name:        toolEndCall,
+				Description: "Hang up the phone call. Use only after saying goodbye, when the caller has nothing else to ask.",
+				Parameters:  json.RawMessage(`{"type":"object","properties":{}}`),
+			},
+			{
+				Name:        toolTransfer,
+				Description: "Transfer the caller to a human agent, when they ask for a person or you cannot help them.",
+				Parameters:  json.RawMessage(`{"type":"object","properties":{}}`),
+			},
+		},
+	}
+	for _, t := range tools {
+		a.tools[t.Name] = t
+		a.defs = append(a.defs, t.Tool)
+	}
+	return a
+}
+
+// WithGuardrails protects the model from callers' prompt injection
+// attempts with g, and returns a.
+func (a *LLM) WithGuardrails(g *Guardrails) *LLM {
+	a.guardrails = g
+	return a
+}
+
+// Provider returns the name of the language model provider, e.g.
+// "anthropic".
+func (a *LLM) Provider() string { return a.provider.Name() }
+
+// Greeting returns the configured greeting.
+func (a *LLM) Greeting(sessionID string) string {
+	if a.greeting != "" {
+		a.mu.Lock()
+		s := a.session(sessionID)
+		s.history = append(s.history, llm.Message{Role: llm.RoleAssistant, Content: a.greeting})
+		a.mu.Unlock()
+	}
+	return a.greeting
+}
+
+// OnUserTurn streams the model's reply chunk by chunk, running any
+// tools it calls. If the turn is cancelled, only the part already spoken
+// is kept in the history, or what the caller heard of it if the host
+// reports that with Interrupted. A retried turn replaces the earlier attempt in
+// the history and replays its non-idempotent tool results.
+func (a *LLM) OnUserTurn(ctx context.Context, turn Turn) (<-chan Response, error) {
+	history, brief := a.beginTurn(turn)
+
+	ch := make(chan Response)
+	go func() {
+		defer close(ch)
+
+		var spoken strings.Builder
+		send := func(r Response) bool {
+			select {
+			case ch <- r:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		say := func(text string) bool {
+			if text = strings.TrimSpace(text); text == "" {
+				return true
+			}
+			if !send(Say(text)) {
+				return false
+			}
+			spoken.WriteString(text + " ")
+			return true
+		}
+
+		system := a.system
+		if brief != "" {
+			system += "\n\n" + brief
+		}
+		if turn.MaxSentences > 0 {
+			system += fmt.Sprintf("\n\nKeep this reply to at most %d short sentence(s).", turn.MaxSentences)
+		}
+
+		// A turn attempting an injection is offered only tools that don't
+		// act on anything
+		messages, defs, restricted := history, a.defs, false
+		if a.guardrails != nil {
+			messages, restricted = a.guardrails.guard(turn, history)
+			if restricted {
+				defs = a.passiveTools()
+			}
+		}
+		var actions []Response
+		completed := false
+		for round := 0; round < maxToolRounds && !completed; round++ {
+			chunker := speech.NewChunker()
+			cancelled := false
+			resp, err := a.provider.Stream(ctx, llm.Request{
+				System:   system,
+				Messages: messages,
+				Tools:    defs,
+			}, func(text string) {
+				if cancelled {
+					return
+				}
+				for _, chunk := range chunker.Write(text) {
+					if !say(chunk) {
+						cancelled = true
+						return
+					}
+				}
+			})
+			if err != nil {
+				if ctx.Err() == nil {
+					send(Response{Err: err})
+				}
+				break
+			}
+			if cancelled || !say(chunker.Flush()) {
+				break
+			}
+
+			messages = append(messages, resp.Message())
+			if len(resp.ToolCalls) == 0 {
+				completed = true
+				break
+			}
+			ended := false
+			for _, call := range resp.ToolCalls {
+				result, action := a.runTool(ctx, turn, call, restricted)
+				if action != nil {
+					actions = append(actions, *action)
+				}
+				messages = append(messages, llm.Message{Role: llm.RoleTool, ToolCallID: call.ID, Content: result})
+				ended = ended || a.tools[call.Name].EndsTurn
+			}
+			// The call is ending, moving to a human or changing hands;
+			// nothing more to say.
+			completed = len(actions) > 0 || ended
+		}
+
+		if completed {
+			a.commit(turn, messages[len(history):]...)
+		} else if text := strings.TrimSpace(spoken.String()); text != "" {
+			a.commit(turn, llm.Message{Role: llm.RoleAssistant, Content: text})
+		}
+		for _, action := range actions {
+			if !send(action) {
+				return
+			}
+		}
+	}()
+	return ch, nil
+}
+
+// runTool runs one tool call, returning the result for the model and the
+// action it requests, if any. A restricted turn runs only tools that
+// don't act on anything.
+func (a *LLM) runTool(ctx context.Context, turn Turn, call llm.ToolCall, restricted bool) (string, *Response) {
+	switch call.Name {
+	case toolEndCall:
+		r := Do(ActionHangup, "")
+		return "The call will end after your reply is spoken.", &r
+	case toolTransfer:
+		r := Do(ActionTransfer, "")
+		return "The caller is being transferred.", &r
+	}
+
+	tool, ok := a.tools[call.Name]
+	if !ok {
+		return fmt.Sprintf("Unknown tool %q.", call.Name), nil
+	}
+	if restricted && !tool.Idempotent {
+		return fmt.Sprintf("Tool %q is not available for this request.", call.Name), nil
+	}
+
+	key := toolCallKey(turn, call.Name, call.Arguments)
+	if !tool.Idempotent {
+		if result, ok := a.recorded(turn.SessionID, key); ok {
+			return result, nil
+		}
+	}
+	ctx = context.WithValue(ctx, sessionIDContextKey{}, turn.SessionID)
+	result, err := tool.Call(context.WithValue(ctx, idempotencyKeyContextKey{}, key), call.Arguments)
+	if err != nil {
+		// The tool may have acted before failing, so the error is
+		// recorded like any result.
+		result = "Error: " + err.Error()
+	}
+	if !tool.Idempotent {
+		a.record(turn.SessionID, key, result)
+	}
+	return result, nil
+}
+
+// passiveTools returns the definitions of the built-in tools and those
+// marked Idempotent.
+func (a *LLM) passiveTools() []llm.Tool {
+	var defs []llm.Tool
+	for _, def := range a.defs {
+		if t, ok := a.tools[def.Name]; !ok || t.Idempotent {
+			defs = append(defs, def)
+		}
+	}
+	return defs
+}
+
+// EndSession discards the session's history.
+func (a *LLM) EndSession(sessionID string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	delete(a.sessions, sessionID)
+}
+
+// History returns a copy of the session's conversation so far.
+func (a *LLM) History(sessionID string) []llm.Message {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s, ok := a.sessions[sessionID]
+	if !ok {
+		return nil
+	}
+	return append([]llm.Message(nil), s.history...)
+}
+
+// Resume starts the session with history, replacing any it has.
+func (a *LLM) Resume(sessionID string, history []llm.Message) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s := a.session(sessionID)
+	s.history = append([]llm.Message(nil), history...)
+	s.turnStart = len(s.history)
+}
+
+// Brief adds brief to the system prompt for the session's turns.
+func (a *LLM) Brief(sessionID, brief string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.session(sessionID).brief = strings.TrimSpace(brief)
+}
+
+// session returns a session's state, creating it. a.mu must be held.
+func (a *LLM) session(sessionID string) *llmSession {
+	s, ok := a.sessions[sessionID]
+	if !ok {
+		s = &llmSession{}
+		a.sessions[sessionID] = s
+	}
+	return s
+}
+
+// beginTurn starts an attempt at a turn and returns the history to send,
+// ending with the caller's message, and the session's brief. A retry first
+// rewinds the history to before the turn but keeps its tool journal.
+func (a *LLM) beginTurn(turn Turn) ([]llm.Message, string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s := a.session(turn.SessionID)
+	if turn.Attempt > 0 && s.turn == turn.Index {
+		s.history = s.history[:s.turnStart]
+	} else {
+		s.turnStart = len(s.history)
+		s.journal = make(map[string]string)
+	}
+	s.turn, s.attempt = turn.Index, turn.Attempt
+	s.heard = nil
+	s.history = append(s.history, llm.Message{Role: llm.RoleUser, Content: turn.Text})
+	return append([]llm.Message(nil), s.history...), s.brief
+}
+
+// Answered adds a turn the host answered itself to the history, as though
+// the model had replied. Like a reply, it can be cut short by Interrupted.
+func (a *LLM) Answered(turn Turn, reply string) {
+	a.beginTurn(turn)
+	a.commit(turn, llm.Message{Role: llm.RoleAssistant, Content: reply})
+}
+
+// commit adds a finished attempt's messages to the history, unless the
+// attempt has since been superseded.
+func (a *LLM) commit(turn Turn, messages ...llm.Message) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s, ok := a.sessions[turn.SessionID]
+	if !ok || s.turn != turn.Index || s.attempt != turn.Attempt {
+		return
+	}
+	s.history = append(s.history, messages...)
+	s.cut()
+}
+
+// Interrupted cuts the reply to turn in the history down to what the
+// caller heard, now or once the turn is committed. Only a reply that is a
+// single message is cut; one around tool calls is kept as it is.
+func (a *LLM) Interrupted(sessionID string, turn int, heard string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s, ok := a.sessions[sessionID]
+	if !ok || s.turn != turn {
+		return
+	}
+	s.heard = &heard
+	s.cut()
+}
+
+// cut cuts the turn's committed reply to what was heard, marking where the
+// caller barged in.
+func (s *llmSession) cut() {
+	if s.heard == nil || len(s.history) != s.turnStart+2 {
+		return
+	}
+	reply := &s.history[len(s.history)-1]
+	if reply.Role != llm.RoleAssistant || len(reply.ToolCalls) > 0 {
+		return
+	}
+	reply.Content = strings.TrimSpace(*s.heard + " …")
+	s.heard = nil
+}
+
+// conversation returns the session's history before turn, as the spoken
+// conversation alone: tool calls and their results are left out, since
+// another agent may not have the same tools.
+func (a *LLM) conversation(sessionID string, turn int) []llm.Message {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s, ok := a.sessions[sessionID]
+	if !ok {
+		return nil
+	}
+	history := s.history
+	if s.turn == turn {
+		history = history[:s.turnStart]
+	}
+	return spokenOnly(history)
+}
+
+// spokenOnly returns the caller's and the agent's words in history.
+func spokenOnly(history []llm.Message) []llm.Message {
+	var spoken []llm.Message
+	for _, m := range history {
+		if (m.Role == llm.RoleUser || m.Role == llm.RoleAssistant) && strings.TrimSpace(m.Content) != "" {
+			spoken = append(spoken, llm.Message{Role: m.Role, Content: m.Content})
+		}
+	}
+	return spoken
+}
+
+// recorded returns the journaled result of a tool call made this turn.
+func (a *LLM) recorded(sessionID, key string) (string, bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	s, ok := a.sessions[sessionID]
+	if !ok {
+		return "", false
+	}
+	result, ok := s.journal[key]
+	return result, ok
+}
+
+// record journals a tool call's result for replay on a retry.
+func (a *LLM) record(sessionID, key, result string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if s, ok := a.sessions[sessionID]; ok && s.journal != nil {
+		s.journal[key] = result
+	}
+}
@@ -0,0 +1,261 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/agentplexus/omnivoice-examples/kit/llm"
+	"github.com/agentplexus/omnivoice-examples/kit/speech"
+)
+
+// Collect has a step collect a value the caller spells out, such as a
+// confirmation code or an email address. The step's Say asks for it;
+// what the caller spells is read back with the phonetic alphabet ("B as
+// in bravo") for them to confirm, asking first about any letter the
+// recognizer may have misheard. The step moves to Next once the value is
+// confirmed.
+type Collect struct {
+	Kind speech.SpellingKind
+	// Name is what the value is called in prompts, e.g. "booking
+	// reference"; by default "email address" or "code".
+	Name string
+	// Valid, if set, rejects values that can't be right, e.g. of the
+	// wrong length, and the caller is asked to spell it again.
+	Valid func(value string) bool
+	// MaxAttempts is how many times the caller may spell the value, or
+	// fail to answer a question about it, before the step gives up and
+	// moves to Fallback. 0 means 3.
+	MaxAttempts int
+	// Fallback is the step entered on giving up, e.g. a transfer; when
+	// empty, Next.
+	Fallback string
+	// OnConfirmed, if set, is called with each session's confirmed value.
+	OnConfirmed func(sessionID, value string)
+}
+
+// ReadbackState is how far a Readback has got.
+type ReadbackState int
+
+const (
+	// ReadbackListening waits for the caller to spell the value.
+	ReadbackListening ReadbackState = iota
+	// ReadbackResolving waits for the caller to say which of the letters
+	// that sound alike they meant.
+	ReadbackResolving
+	// ReadbackConfirming waits for the caller to confirm the value read
+	// back.
+	ReadbackConfirming
+	// ReadbackConfirmed has the caller's confirmed value.
+	ReadbackConfirmed
+	// ReadbackFailed gave up after too many attempts.
+	ReadbackFailed
+)
+
+// Readback is the confirm-and-readback loop of one Collect, for one
+// session. Script runs it for steps with Collect set; other agents can
+// run it themselves, passing it each caller turn until it is done.
+type Readback struct {
+	collect  Collect
+	state    ReadbackState
+	value    speech.Spelling
+	attempts int
+	// last is what was last said, for a retried turn.
+	last string
+}
+
+// Prompts a Readback says; %s is the value's name.
+const (
+	readbackAgain   = "Sorry, I didn't catch that. Please spell your %s again, one letter at a time."
+	readbackInvalid = "That doesn't sound like a valid %s. Please spell it again, one letter at a time."
+	readbackRetry   = "Let's try again. Please spell your %s using words for the letters, like B as in bravo."
+	readbackConfirm = "I have %s. Is that right?"
+)
+
+// NewReadback starts collecting a value, listening for the caller to
+// spell it.
+func NewReadback(c Collect) *Readback {
+	if c.Name == "" {
+		c.Name = "code"
+		if c.Kind == speech.Email {
+			c.Name = "email address"
+		}
+	}
+	if c.MaxAttempts <= 0 {
+		c.MaxAttempts = 3
+	}
+	return &Readback{collect: c}
+}
+
+// State returns how far the readback has got.
+func (r *Readback) State() ReadbackState { return r.state }
+
+// Value returns the value as understood so far; once confirmed, the
+// caller's value.
+func (r *Readback) Value() string { return r.value.Value }
+
+// Last returns what the readback last said, to say again when the host
+// retries a turn.
+func (r *Readback) Last() string { return r.last }
+
+// Hear takes the caller's reply and returns what to say next. Nothing is
+// said once the value is confirmed or the readback has failed.
+func (r *Readback) Hear(text string) string {
+	r.last = r.hear(text)
+	return r.last
+}
+
+func (r *Readback) hear(text string) string {
+	switch r.state {
+	case ReadbackResolving:
+		c, ok, answered := r.resolve(text)
+		if ok {
+			u := r.value.Unsure[0]
+			if r.collect.Kind == speech.Email {
+				c = unicode.ToLower(c)
+			}
+			r.value.Value = r.value.Value[:u.Index] + string(c) + r.value.Value[u.Index+1:]
+			r.value.Unsure = r.value.Unsure[1:]
+			return r.next()
+		}
+		if answered {
+			r.attempts++
+			return r.giveUpOr(r.question())
+		}
+		// Spelling it again instead of answering
+		r.state = ReadbackListening
+
+	case ReadbackConfirming:
+		words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+			return !unicode.IsLetter(r) && r != '\''
+		})
+		switch {
+		case containsAny(words, []string{"no", "nope", "wrong", "incorrect", "not right"}):
+			r.state = ReadbackListening
+			return r.giveUpOr(fmt.Sprintf(readbackRetry, r.collect.Name))
+		case containsAny(words, []string{"yes", "yeah", "yep", "yup", "correct", "right", "exactly", "perfect"}):
+			r.state = ReadbackConfirmed
+			return ""
+		}
+		// Spelling it again instead of answering
+		r.state = ReadbackListening
+	}
+
+	r.attempts++
+	r.value = speech.ParseSpelling(text, r.collect.Kind)
+	if r.value.Value == "" {
+		return r.giveUpOr(fmt.Sprintf(readbackAgain, r.collect.Name))
+	}
+	return r.next()
+}
+
+// next asks about the next unsure letter, or reads the value back once
+// there are none left.
+func (r *Readback) next() string {
+	if len(r.value.Unsure) > 0 {
+		r.state = ReadbackResolving
+		return r.question()
+	}
+	if r.collect.Valid != nil && !r.collect.Valid(r.value.Value) {
+		r.state = ReadbackListening
+		return r.giveUpOr(fmt.Sprintf(readbackInvalid, r.collect.Name))
+	}
+	r.state = ReadbackConfirming
+	return fmt.Sprintf(readbackConfirm, speech.Spell(r.value.Value))
+}
+
+// giveUpOr returns say or, once the attempts are used up, gives up and
+// returns nothing.
+func (r *Readback) giveUpOr(say string) string {
+	if r.attempts >= r.collect.MaxAttempts {
+		r.state = ReadbackFailed
+		return ""
+	}
+	return say
+}
+
+// question asks which of the first unsure letter's candidates the caller
+// meant: "Was the 3rd character B as in bravo, or D as in delta?"
+func (r *Readback) question() string {
+	u := r.value.Unsure[0]
+	options := make([]string, len(u.Candidates))
+	for i, c := range u.Candidates {
+		options[i] = speech.Spell(string(c))
+	}
+	list := strings.Join(options[:len(options)-1], ", ") + ", or " + options[len(options)-1]
+	position := len([]rune(r.value.Value[:u.Index])) + 1
+	return fmt.Sprintf("Was the %d%s character %s?", position, ordinalSuffix(position), list)
+}
+
+// resolve reads which candidate the caller meant from their answer: one
+// they name, or the one in the position they say ("the second"). A yes
+// is the first, the one heard. answered is false if the caller spelled
+// something else instead, starting over.
+func (r *Readback) resolve(text string) (c rune, ok, answered bool) {
+	u := r.value.Unsure[0]
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+	for i, ordinal := range []string{"first", "second", "third"} {
+		if i < len(u.Candidates) && containsAny(words, []string{ordinal}) {
+			return u.Candidates[i], true, true
+		}
+	}
+
+	// The last candidate named counts: "not B, D as in delta"
+	value := []rune(strings.ToUpper(speech.ParseSpelling(text, r.collect.Kind).Value))
+	for _, v := range value {
+		if !slices.Contains(u.Candidates, v) {
+			return 0, false, len(value) <= 1
+		}
+	}
+	if len(value) > 0 {
+		return value[len(value)-1], true, true
+	}
+	if containsAny(words, []string{"yes", "yeah", "yep", "right", "correct"}) {
+		return u.Candidates[0], true, true
+	}
+	return 0, false, true
+}
+
+func ordinalSuffix(n int) string {
+	if n%100 >= 11 && n%100 <= 13 {
+		return "th"
+	}
+	switch n % 10 {
+	case 1:
+		return "st"
+	case 2:
+		return "nd"
+	case 3:
+		return "rd"
+	default:
+		return "th"
+	}
+}
+
+// ReadbackTool returns a tool that spells a value out with the phonetic
+// alphabet, for a language model to read back codes and email addresses
+// the caller gave.
+func ReadbackTool() Tool {
+	return Tool{
+		Tool: llm.Tool{
+			Name:        "spell_out",
+			Description: "Spell a confirmation code, reference number or email address out with the phonetic alphabet (\"B as in bravo\"), to read it back to the caller. Say the result word for word, then ask whether it is right.",
+			Parameters:  json.RawMessage(`{"type":"object","properties":{"value":{"type":"string","description":"The value to spell out, exactly as written."}},"required":["value"]}`),
+		},
+		Call: func(_ context.Context, args json.RawMessage) (string, error) {
+			var params struct {
+				Value string `json:"value"`
+			}
+			if err := json.Unmarshal(args, &params); err != nil {
+				return "", err
+			}
+			return speech.Spell(params.Value), nil
+		},
+		Idempotent: true,
+	}
+}
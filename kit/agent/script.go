@@ -0,0 +1,222 @@
+package agent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Step is one node of a scripted call flow.
+type Step struct {
+	ID string
+	// Say is spoken on entering the step.
+	Say string
+	// Action, if set, is taken after Say, e.g. to hang up at the end.
+	Action *Action
+	// Branches choose the next step from the caller's reply; the first
+	// whose keywords match wins.
+	Branches []Branch
+	// Next is the step entered when no branch matches. When empty, the
+	// step is repeated.
+	Next string
+	// Collect, if set, has the step collect a value the caller spells
+	// out, confirmed by reading it back, before moving to Next. Branches
+	// are ignored.
+	Collect *Collect
+}
+
+// Branch leads to Next when the caller's reply contains any keyword
+// (matched as whole words, case-insensitively).
+type Branch struct {
+	Keywords []string
+	Next     string
+}
+
+// Script is an agent that walks a fixed call flow, such as an IVR menu or
+// a survey. The first step is the greeting.
+type Script struct {
+	steps map[string]Step
+	first string
+
+	mu      sync.Mutex
+	current map[string]string
+	// readbacks are the sessions collecting a value in their current step.
+	readbacks map[string]*Readback
+}
+
+// NewScript returns a script agent, checking that every referenced step
+// exists.
+func NewScript(steps []Step) (*Script, error) {
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("script has no steps")
+	}
+	s := &Script{
+		steps:     make(map[string]Step, len(steps)),
+		first:     steps[0].ID,
+		current:   make(map[string]string),
+		readbacks: make(map[string]*Readback),
+	}
+	for _, step := range steps {
+		if _, dup := s.steps[step.ID]; dup {
+			return nil, fmt.Errorf("duplicate step %q", step.ID)
+		}
+		s.steps[step.ID] = step
+	}
+	for _, step := range steps {
+		next := []string{step.Next}
+		if step.Collect != nil {
+			next = append(next, step.Collect.Fallback)
+		}
+		for _, b := range step.Branches {
+			next = append(next, b.Next)
+		}
+		for _, id := range next {
+			if _, ok := s.steps[id]; id != "" && !ok {
+				return nil, fmt.Errorf("step %q leads to unknown step %q", step.ID, id)
+			}
+		}
+	}
+	return s, nil
+}
+
+// Greeting enters the first step and returns what it says.
+func (s *Script) Greeting(sessionID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.enter(sessionID, s.first)
+	return s.steps[s.first].Say
+}
+
+// OnUserTurn moves to the step chosen by the caller's reply, or carries
+// on collecting the step's value. A retried turn repeats what was last
+// said rather than moving on again.
+func (s *Script) OnUserTurn(_ context.Context, turn Turn) (<-chan Response, error) {
+	s.mu.Lock()
+	id, ok := s.current[turn.SessionID]
+	if !ok {
+		id = s.first
+	}
+	step := s.steps[id]
+	readback := s.readbacks[turn.SessionID]
+	if step.Collect != nil && readback == nil {
+		// The session started without a greeting
+		readback = NewReadback(*step.Collect)
+		s.readbacks[turn.SessionID] = readback
+	}
+	if turn.Attempt > 0 {
+		s.mu.Unlock()
+		if readback != nil && readback.Last() != "" {
+			return Reply(Say(readback.Last())), nil
+		}
+		return Reply(stepResponses(step)...), nil
+	}
+
+	next := step.Next
+	var confirmed func()
+	switch {
+	case readback != nil:
+		// Letters are read from the words as recognized
+		say := readback.Hear(turn.spoken())
+		switch readback.State() {
+		case ReadbackConfirmed:
+			if on := step.Collect.OnConfirmed; on != nil {
+				value := readback.Value()
+				confirmed = func() { on(turn.SessionID, value) }
+			}
+		case ReadbackFailed:
+			if step.Collect.Fallback != "" {
+				next = step.Collect.Fallback
+			}
+		default:
+			s.mu.Unlock()
+			return Reply(Say(say)), nil
+		}
+	default:
+		// Keywords may match either way it was written ("one" or "1")
+		words := strings.FieldsFunc(strings.ToLower(turn.Text+" "+turn.Spoken), func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+		})
+		for _, b := range step.Branches {
+			if containsAny(words, b.Keywords) {
+				next = b.Next
+				break
+			}
+		}
+	}
+	if next == "" {
+		next = id
+	}
+	s.enter(turn.SessionID, next)
+	step = s.steps[next]
+	s.mu.Unlock()
+
+	if confirmed != nil {
+		confirmed()
+	}
+	return Reply(stepResponses(step)...), nil
+}
+
+// enter moves a session to step id, starting its readback if it collects
+// a value. s.mu must be held.
+func (s *Script) enter(sessionID, id string) {
+	s.current[sessionID] = id
+	delete(s.readbacks, sessionID)
+	if c := s.steps[id].Collect; c != nil {
+		s.readbacks[sessionID] = NewReadback(*c)
+	}
+}
+
+// stepResponses is what entering a step says and does.
+func stepResponses(step Step) []Response {
+	var responses []Response
+	if step.Say != "" {
+		responses = append(responses, Say(step.Say))
+	}
+	if step.Action != nil {
+		responses = append(responses, Response{Action: step.Action})
+	}
+	return responses
+}
+
+// MidTask reports whether the session is collecting a value that hasn't
+// been confirmed yet.
+func (s *Script) MidTask(sessionID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r := s.readbacks[sessionID]
+	return r != nil && r.State() < ReadbackConfirmed
+}
+
+// EndSession forgets the session's position in the script.
+func (s *Script) EndSession(sessionID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.current, sessionID)
+	delete(s.readbacks, sessionID)
+}
+
+// containsAny reports whether any keyword (possibly several words) occurs
+// in words.
+func containsAny(words, keywords []string) bool {
+	for _, kw := range keywords {
+		phrase := strings.Fields(strings.ToLower(kw))
+		if len(phrase) == 0 {
+			continue
+		}
+		for i := 0; i+len(phrase) <= len(words); i++ {
+			match := true
+			for j, w := range phrase {
+				if words[i+j] != w {
+					match = false
+					break
+				}
+			}
+			if match {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,312 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/agentplexus/omnivoice-examples/kit/llm"
+)
+
+// toolHandOff is the built-in tool a team's specialists pass the call to
+// each other with.
+const toolHandOff = "hand_off"
+
+// maxHandoffs bounds how many times one turn changes hands, so two
+// specialists can't pass the caller back and forth for ever.
+const maxHandoffs = 2
+
+// Specialist is one member of a Team.
+type Specialist struct {
+	// Name identifies the specialist to the others and in logs, e.g.
+	// "billing".
+	Name string
+	// Description tells the others what it handles, so they know when to
+	// hand the call to it.
+	Description string
+	// System is its system prompt.
+	System string
+	// Tools are the tools it may call besides the built-in ones.
+	Tools []Tool
+}
+
+// Team is an agent made of specialists, each a language model with its
+// own prompt and tools, that hand the call to one another mid-call. The
+// first specialist answers the call, typically a router that finds out
+// what the caller needs; each can call hand_off to pass the call to
+// another, which takes over at once and answers the same turn, carrying
+// on from the conversation so far. Only what was said is carried over,
+// not the tool calls behind it.
+type Team struct {
+	members map[string]*LLM
+	first   string
+	// OnHandoff, if set, is called each time a session changes hands.
+	OnHandoff func(sessionID, from, to, reason string)
+
+	mu       sync.Mutex
+	sessions map[string]*teamSession
+}
+
+// teamSession is one call's place in the team.
+type teamSession struct {
+	active string
+	// route lists the specialists the call has been with, in order.
+	route []string
+	// brief describes the caller, for every specialist the call reaches.
+	brief string
+	// handoff is requested by the specialist answering the turn.
+	handoff *handoff
+}
+
+type handoff struct {
+	to, reason string
+}
+
+// NewTeam returns a team of specialists prompting provider. The first
+// answers the call.
+func NewTeam(provider llm.Provider, specialists ...Specialist) (*Team, error) {
+	if len(specialists) == 0 {
+		return nil, errors.New("team has no specialists")
+	}
+	t := &Team{
+		members:  make(map[string]*LLM, len(specialists)),
+		first:    specialists[0].Name,
+		sessions: make(map[string]*teamSession),
+	}
+	for _, s := range specialists {
+		if s.Name == "" {
+			return nil, errors.New("team specialist has no name")
+		}
+		if _, ok := t.members[s.Name]; ok {
+			return nil, fmt.Errorf("team specialist %q appears twice", s.Name)
+		}
+		t.members[s.Name] = nil
+	}
+	for _, s := range specialists {
+		var others []Specialist
+		for _, o := range specialists {
+			if o.Name != s.Name {
+				others = append(others, o)
+			}
+		}
+		system, tools := s.System, s.Tools
+		if len(others) > 0 {
+			system += "\n\n" + teamPrompt(s.Name, others)
+			tools = append(slices.Clone(tools), t.handOffTool(others))
+		}
+		t.members[s.Name] = NewLLM(provider, system, "", tools...)
+	}
+	return t, nil
+}
+
+// teamPrompt tells a specialist who else is on the team.
+func teamPrompt(name string, others []Specialist) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "You are %s, one of a team of assistants answering this call. Only help with what you handle; when the caller needs something another assistant handles, hand the call to them with hand_off instead of answering yourself. The others are:", name)
+	for _, o := range others {
+		fmt.Fprintf(&b, "\n- %s: %s", o.Name, o.Description)
+	}
+	return b.String()
+}
+
+// handOffTool returns the hand_off tool for passing the call to one of
+// others.
+func (t *Team) handOffTool(others []Specialist) Tool {
+	names := make([]string, len(others))
+	for i, o := range others {
+		names[i] = o.Name
+	}
+	enum, _ := json.Marshal(names)
+	return Tool{
+		Tool: llm.Tool{
+			Name:        toolHandOff,
+			Description: "Hand the call to the assistant who handles what the caller needs. They take over at once and answer the caller, so say at most a short sentence before calling this.",
+			Parameters:  json.RawMessage(`{"type":"object","properties":{"to":{"type":"string","enum":` + string(enum) + `},"reason":{"type":"string","description":"What the caller needs, for the assistant taking over."}},"required":["to","reason"]}`),
+		},
+		Call: func(ctx context.Context, args json.RawMessage) (string, error) {
+			var params struct {
+				To     string `json:"to"`
+				Reason string `json:"reason"`
+			}
+			if err := json.Unmarshal(args, &params); err != nil {
+				return "", err
+			}
+			if !slices.Contains(names, params.To) {
+				return "", fmt.Errorf("no assistant named %q", params.To)
+			}
+			sessionID, _ := SessionID(ctx)
+			t.mu.Lock()
+			defer t.mu.Unlock()
+			t.session(sessionID).handoff = &handoff{to: params.To, reason: strings.TrimSpace(params.Reason)}
+			return "The call is being handed to " + params.To + ".", nil
+		},
+		// Handing off changes nothing outside the team, and the assistant
+		// taking over answers the turn
+		Idempotent: true,
+		EndsTurn:   true,
+	}
+}
+
+// Provider returns the name of the language model provider, e.g.
+// "anthropic".
+func (t *Team) Provider() string { return t.members[t.first].Provider() }
+
+// WithGuardrails protects every specialist with g, and returns t.
+func (t *Team) WithGuardrails(g *Guardrails) *Team {
+	for _, m := range t.members {
+		m.WithGuardrails(g)
+	}
+	return t
+}
+
+// Greeting returns the first specialist's greeting.
+func (t *Team) Greeting(sessionID string) string {
+	return t.members[t.first].Greeting(sessionID)
+}
+
+// OnUserTurn has the specialist the call is with answer the turn. If it
+// hands the call off, the specialist taking over answers the same turn.
+func (t *Team) OnUserTurn(ctx context.Context, turn Turn) (<-chan Response, error) {
+	ch := make(chan Response)
+	go func() {
+		defer close(ch)
+		for hops := 0; ; hops++ {
+			from := t.active(turn.SessionID)
+			responses, err := t.members[from].OnUserTurn(ctx, turn)
+			if err != nil {
+				select {
+				case ch <- Response{Err: err}:
+				case <-ctx.Done():
+				}
+				return
+			}
+			for r := range responses {
+				r.Specialist = from
+				select {
+				case ch <- r:
+				case <-ctx.Done():
+				}
+			}
+			// A turn cut short keeps the call where it is
+			next, ok := t.takeHandoff(turn.SessionID)
+			if !ok || ctx.Err() != nil || hops == maxHandoffs {
+				return
+			}
+			t.handOver(turn, from, next)
+		}
+	}()
+	return ch, nil
+}
+
+// takeHandoff returns and clears the handoff requested this turn.
+func (t *Team) takeHandoff(sessionID string) (handoff, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s := t.session(sessionID)
+	h := s.handoff
+	s.handoff = nil
+	if h == nil {
+		return handoff{}, false
+	}
+	return *h, true
+}
+
+// handOver moves a session from one specialist to another, which starts
+// from the conversation before turn and is told why it was handed the
+// call.
+func (t *Team) handOver(turn Turn, from string, h handoff) {
+	to := t.members[h.to]
+	to.Resume(turn.SessionID, t.members[from].conversation(turn.SessionID, turn.Index))
+
+	t.mu.Lock()
+	s := t.session(turn.SessionID)
+	s.active = h.to
+	s.route = append(s.route, h.to)
+	brief := fmt.Sprintf("%s handed the call to you: %s. Carry on the conversation without greeting the caller again.", from, h.reason)
+	if s.brief != "" {
+		brief = s.brief + "\n\n" + brief
+	}
+	t.mu.Unlock()
+
+	to.Brief(turn.SessionID, brief)
+	if t.OnHandoff != nil {
+		t.OnHandoff(turn.SessionID, from, h.to, h.reason)
+	}
+}
+
+// active returns the specialist a session is with.
+func (t *Team) active(sessionID string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.session(sessionID).active
+}
+
+// Route returns the specialists a session has been with, in order,
+// starting with the first.
+func (t *Team) Route(sessionID string) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return slices.Clone(t.session(sessionID).route)
+}
+
+// Brief briefs the specialist the session is with, and those it is handed
+// to later.
+func (t *Team) Brief(sessionID, brief string) {
+	t.mu.Lock()
+	s := t.session(sessionID)
+	s.brief = strings.TrimSpace(brief)
+	active := s.active
+	t.mu.Unlock()
+	t.members[active].Brief(sessionID, brief)
+}
+
+// Interrupted reports a barge-in to the specialist the session is with.
+func (t *Team) Interrupted(sessionID string, turn int, heard string) {
+	t.members[t.active(sessionID)].Interrupted(sessionID, turn, heard)
+}
+
+// Answered records a turn the host answered itself with the specialist
+// the session is with.
+func (t *Team) Answered(turn Turn, reply string) {
+	t.members[t.active(turn.SessionID)].Answered(turn, reply)
+}
+
+// History returns the conversation of the specialist the session is with.
+func (t *Team) History(sessionID string) []llm.Message {
+	return t.members[t.active(sessionID)].History(sessionID)
+}
+
+// Resume starts the session with the first specialist, carrying on from
+// the spoken conversation in history.
+func (t *Team) Resume(sessionID string, history []llm.Message) {
+	t.mu.Lock()
+	s := t.session(sessionID)
+	s.active, s.route = t.first, []string{t.first}
+	t.mu.Unlock()
+	t.members[t.first].Resume(sessionID, spokenOnly(history))
+}
+
+// EndSession discards the session's state in every specialist.
+func (t *Team) EndSession(sessionID string) {
+	t.mu.Lock()
+	delete(t.sessions, sessionID)
+	t.mu.Unlock()
+	for _, m := range t.members {
+		m.EndSession(sessionID)
+	}
+}
+
+// session returns a session's state, creating it with the first
+// specialist. t.mu must be held.
+func (t *Team) session(sessionID string) *teamSession {
+	s, ok := t.sessions[sessionID]
+	if !ok {
+		s = &teamSession{active: t.first, route: []string{t.first}}
+		t.sessions[sessionID] = s
+	}
+	return s
+}
@@ -0,0 +1,82 @@
+package audio
+
+import "slices"
+
+// alawSegmentEnds are the upper bounds of each A-law segment for 13-bit input.
+var alawSegmentEnds = [8]int{0x1F, 0x3F, 0x7F, 0xFF, 0x1FF, 0x3FF, 0x7FF, 0xFFF}
+
+// AlawEncode converts 16-bit linear PCM to G.711 A-law.
+func AlawEncode(samples []int16) []byte {
+	return AppendAlawEncode(make([]byte, 0, len(samples)), samples)
+}
+
+// AppendAlawEncode converts 16-bit linear PCM to G.711 A-law, appending
+// the bytes to dst and returning the extended slice.
+func AppendAlawEncode(dst []byte, samples []int16) []byte {
+	table := alawEncodeTable()
+	dst = slices.Grow(dst, len(samples))
+	out := dst[len(dst) : len(dst)+len(samples)]
+	for i, s := range samples {
+		out[i] = table[uint16(s)>>3]
+	}
+	return dst[:len(dst)+len(samples)]
+}
+
+// AlawDecode converts G.711 A-law to 16-bit linear PCM.
+func AlawDecode(data []byte) []int16 {
+	return AppendAlawDecode(make([]int16, 0, len(data)), data)
+}
+
+// AppendAlawDecode converts G.711 A-law to 16-bit linear PCM, appending the
+// samples to dst and returning the extended slice.
+func AppendAlawDecode(dst []int16, data []byte) []int16 {
+	dst = slices.Grow(dst, len(data))
+	out := dst[len(dst) : len(dst)+len(data)]
+	for i, a := range data {
+		out[i] = alawDecodeTable[a]
+	}
+	return dst[:len(dst)+len(data)]
+}
+
+func linearToAlaw(sample int16) byte {
+	s := int(sample) >> 3
+	mask := 0xD5
+	if s < 0 {
+		mask = 0x55
+		s = -s - 1
+	}
+
+	segment := 0
+	for segment < len(alawSegmentEnds) && s > alawSegmentEnds[segment] {
+		segment++
+	}
+	if segment >= len(alawSegmentEnds) {
+		return byte(0x7F ^ mask)
+	}
+
+	a := segment << 4
+	if segment < 2 {
+		a |= (s >> 1) & 0x0F
+	} else {
+		a |= (s >> segment) & 0x0F
+	}
+	return byte(a ^ mask)
+}
+
+func alawToLinear(a byte) int16 {
+	a ^= 0x55
+	t := int(a&0x0F) << 4
+	switch segment := int(a&0x70) >> 4; segment {
+	case 0:
+		t += 8
+	case 1:
+		t += 0x108
+	default:
+		t += 0x108
+		t <<= segment - 1
+	}
+	if a&0x80 != 0 {
+		return int16(t)
+	}
+	return int16(-t)
+}
@@ -0,0 +1,146 @@
+package audio
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// Codec identifies a telephony wire format.
+type Codec string
+
+const (
+	// CodecMulaw is G.711 mu-law at 8kHz, used in North America and Japan
+	// and by Twilio Media Streams.
+	CodecMulaw Codec = "mulaw"
+	// CodecAlaw is G.711 A-law at 8kHz, used by European and most
+	// international trunks.
+	CodecAlaw Codec = "alaw"
+	// CodecG722 is G.722 wideband at 16kHz and 64 kbit/s.
+	CodecG722 Codec = "g722"
+)
+
+// ParseCodec parses a codec name, accepting common aliases such as "ulaw",
+// "pcmu" and "pcma".
+func ParseCodec(name string) (Codec, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "mulaw", "ulaw", "pcmu", "g711u":
+		return CodecMulaw, nil
+	case "alaw", "pcma", "g711a":
+		return CodecAlaw, nil
+	case "g722":
+		return CodecG722, nil
+	default:
+		return "", fmt.Errorf("unsupported codec %q (want mulaw, alaw or g722)", name)
+	}
+}
+
+// SampleRate returns the audio sample rate the codec carries.
+func (c Codec) SampleRate() int {
+	if c == CodecG722 {
+		return 16000
+	}
+	return 8000
+}
+
+// BytesPerSecond returns the wire bitrate in bytes. All three codecs run at
+// 64 kbit/s, so a 20ms frame is always 160 bytes.
+func (c Codec) BytesPerSecond() int {
+	return 8000
+}
+
+// Silence returns n bytes of silence in the codec's wire format.
+func (c Codec) Silence(n int) []byte {
+	switch c {
+	case CodecMulaw:
+		return bytes.Repeat([]byte{0xFF}, n)
+	case CodecAlaw:
+		return bytes.Repeat([]byte{0xD5}, n)
+	default:
+		// Each G.722 byte carries two samples
+		return c.NewEncoder().Encode(make([]int16, 2*n))
+	}
+}
+
+// Encoder converts 16-bit PCM at the codec's sample rate to wire format.
+type Encoder interface {
+	Encode(pcm []int16) []byte
+}
+
+// Decoder converts wire format to 16-bit PCM at the codec's sample rate.
+type Decoder interface {
+	Decode(data []byte) []int16
+}
+
+// AppendEncoder is an Encoder that can append to a buffer the caller
+// reuses, so a stream is encoded frame by frame without allocating. The
+// encoders NewEncoder returns implement it.
+type AppendEncoder interface {
+	Encoder
+	AppendEncode(dst []byte, pcm []int16) []byte
+}
+
+// AppendDecoder is a Decoder that can append to a buffer the caller
+// reuses. The decoders NewDecoder returns implement it.
+type AppendDecoder interface {
+	Decoder
+	AppendDecode(dst []int16, data []byte) []int16
+}
+
+// AppendEncode appends pcm encoded by e to dst and returns the extended
+// slice, without allocating if e is an AppendEncoder and dst has room.
+func AppendEncode(e Encoder, dst []byte, pcm []int16) []byte {
+	if a, ok := e.(AppendEncoder); ok {
+		return a.AppendEncode(dst, pcm)
+	}
+	return append(dst, e.Encode(pcm)...)
+}
+
+// AppendDecode appends data decoded by d to dst and returns the extended
+// slice, without allocating if d is an AppendDecoder and dst has room.
+func AppendDecode(d Decoder, dst []int16, data []byte) []int16 {
+	if a, ok := d.(AppendDecoder); ok {
+		return a.AppendDecode(dst, data)
+	}
+	return append(dst, d.Decode(data)...)
+}
+
+// NewEncoder returns an encoder for the codec. G.722 encoders are stateful,
+// so use one per stream.
+func (c Codec) NewEncoder() Encoder {
+	switch c {
+	case CodecAlaw:
+		return encoderFunc(AppendAlawEncode)
+	case CodecG722:
+		return NewG722Encoder()
+	default:
+		return encoderFunc(AppendMulawEncode)
+	}
+}
+
+// NewDecoder returns a decoder for the codec. G.722 decoders are stateful,
+// so use one per stream.
+func (c Codec) NewDecoder() Decoder {
+	switch c {
+	case CodecAlaw:
+		return decoderFunc(AppendAlawDecode)
+	case CodecG722:
+		return NewG722Decoder()
+	default:
+		return decoderFunc(AppendMulawDecode)
+	}
+}
+
+// encoderFunc is a stateless encoder, such as AppendMulawEncode.
+type encoderFunc func(dst []byte, pcm []int16) []byte
+
+func (f encoderFunc) Encode(pcm []int16) []byte { return f(make([]byte, 0, len(pcm)), pcm) }
+
+func (f encoderFunc) AppendEncode(dst []byte, pcm []int16) []byte { return f(dst, pcm) }
+
+// decoderFunc is a stateless decoder, such as AppendMulawDecode.
+type decoderFunc func(dst []int16, data []byte) []int16
+
+func (f decoderFunc) Decode(data []byte) []int16 { return f(make([]int16, 0, len(data)), data) }
+
+func (f decoderFunc) AppendDecode(dst []int16, data []byte) []int16 { return f(dst, data) }
@@ -0,0 +1,37 @@
+// Package audio provides audio utilities shared by the OmniVoice examples:
+// sample-rate conversion, 16-bit PCM helpers, telephony codecs (G.711
+// mu-law and A-law, G.722), and WAV file reading and writing.
+//
+// Telephony transports such as Twilio Media Streams carry 8kHz mu-law, while
+// many TTS providers only emit 16/24/48kHz linear PCM. A typical outbound
+// path is therefore:
+//
+//	pcm := audio.PCM16FromBytes(ttsChunk)
+//	ulaw := audio.MulawEncode(resampler.Process(pcm))
+//
+// Trunks outside North America often use A-law or G.722 instead; Codec
+// selects the matching stateful Encoder and Decoder by name.
+//
+// Inbound audio arrives as one base64 payload per 20ms frame, so at high
+// call counts per-frame allocations add up. A FramePool decodes each
+// payload into reused buffers:
+//
+//	f := pool.Get()
+//	err := f.DecodeMulawBase64(payload)
+//	// use f.PCM
+//	pool.Put(f)
+//
+// The same goes for every other conversion: each has an Append variant
+// (AppendMulawEncode, AppendPCM16FromBytes, Resampler.AppendProcess, and
+// AppendEncode and AppendDecode for a Codec's Encoder and Decoder) that
+// writes into a buffer the caller reuses from frame to frame.
+//
+// G.711 is converted through lookup tables, a whole frame at a time, with
+// the ITU per-sample arithmetic kept as the reference they are built from.
+// go test in this package checks the tables against it on every input, and
+// go test -bench G711 compares their speed.
+//
+// Built with GOEXPERIMENT=simd on amd64, mu-law decoding uses AVX2 when
+// the CPU has it; SIMD reports which path is active. Run
+// go test -bench MediaDecode in this package to compare the paths.
+package audio
@@ -0,0 +1,140 @@
+package audio
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// echoTrackWindow is how far either side of the last detected delay is
+// searched before falling back to a full search.
+const echoTrackWindow = 2 * time.Millisecond
+
+// EchoDetector recognises outbound audio coming back on the inbound path,
+// such as TTS leaking from a caller's speakerphone into their microphone.
+//
+// Outbound audio is fed in with Reference as it is played; each inbound
+// frame is then compared against recent reference audio at every delay up
+// to the maximum, using normalized cross-correlation. Once an echo is found
+// its delay is tracked, so steady-state checks only search a narrow window.
+//
+// An EchoDetector is safe for concurrent use: Reference is typically called
+// from the outbound writer and IsEcho from the inbound reader.
+type EchoDetector struct {
+	sampleRate int
+	threshold  float64
+	maxDelay   int // samples
+	track      int // samples searched either side of the tracked delay
+
+	mu     sync.Mutex
+	ref    []float32 // ring buffer of recent outbound audio
+	pos    int       // next write index in ref
+	filled int
+	delay  int // last detected echo delay in samples, -1 when unknown
+}
+
+// NewEchoDetector creates a detector for audio at sampleRate that reports
+// an echo when the correlation reaches threshold (0-1). maxDelay bounds the
+// round trip from playback to the echo arriving back.
+func NewEchoDetector(sampleRate int, maxDelay time.Duration, threshold float64) *EchoDetector {
+	delay := int(maxDelay.Seconds() * float64(sampleRate))
+	return &EchoDetector{
+		sampleRate: sampleRate,
+		threshold:  threshold,
+		maxDelay:   delay,
+		track:      max(1, int(echoTrackWindow.Seconds()*float64(sampleRate))),
+		// Room for the maximum delay plus a generous inbound frame.
+		ref:   make([]float32, delay+sampleRate/10),
+		delay: -1,
+	}
+}
+
+// Reference records outbound audio as it is played.
+func (d *EchoDetector) Reference(pcm []int16) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, s := range pcm {
+		d.ref[d.pos] = float32(s)
+		d.pos = (d.pos + 1) % len(d.ref)
+	}
+	d.filled = min(d.filled+len(pcm), len(d.ref))
+}
+
+// Reset forgets the reference audio and the tracked delay.
+func (d *EchoDetector) Reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	clear(d.ref)
+	d.pos, d.filled, d.delay = 0, 0, -1
+}
+
+// IsEcho reports whether an inbound frame is an echo of recent reference audio.
+func (d *EchoDetector) IsEcho(pcm []int16) bool {
+	score, _ := d.Correlate(pcm)
+	return score >= d.threshold
+}
+
+// Correlate returns the best normalized correlation between an inbound
+// frame and the reference, and the delay at which it was found.
+func (d *EchoDetector) Correlate(pcm []int16) (float64, time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	n := len(pcm)
+	if n == 0 || d.filled < n {
+		return 0, 0
+	}
+	maxDelay := min(d.maxDelay, d.filled-n)
+
+	var energy float64
+	for _, s := range pcm {
+		energy += float64(s) * float64(s)
+	}
+	if energy == 0 {
+		return 0, 0
+	}
+
+	best, bestDelay := 0.0, -1
+	search := func(lo, hi, step int) {
+		for delay := max(lo, 0); delay <= min(hi, maxDelay); delay += step {
+			if score := d.correlateAt(pcm, energy, delay); score > best {
+				best, bestDelay = score, delay
+			}
+		}
+	}
+
+	if d.delay >= 0 {
+		search(d.delay-d.track, d.delay+d.track, 1)
+	}
+	if best < d.threshold {
+		// Coarse search over every delay, then refine around the peak.
+		search(0, maxDelay, 2)
+		if bestDelay >= 0 {
+			search(bestDelay-1, bestDelay+1, 1)
+		}
+	}
+
+	if best >= d.threshold {
+		d.delay = bestDelay
+	}
+	return best, time.Duration(max(bestDelay, 0)) * time.Second / time.Duration(d.sampleRate)
+}
+
+// correlateAt returns |NCC| between pcm and the reference ending delay
+// samples before the newest one.
+func (d *EchoDetector) correlateAt(pcm []int16, energy float64, delay int) float64 {
+	start := d.pos - delay - len(pcm)
+	for start < 0 {
+		start += len(d.ref)
+	}
+	var dot, refEnergy float64
+	for i, s := range pcm {
+		r := float64(d.ref[(start+i)%len(d.ref)])
+		dot += float64(s) * r
+		refEnergy += r * r
+	}
+	if refEnergy == 0 {
+		return 0
+	}
+	return math.Abs(dot) / math.Sqrt(energy*refEnergy)
+}
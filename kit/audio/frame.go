@@ -0,0 +1,70 @@
+package audio
+
+import (
+	"encoding/base64"
+	"slices"
+	"sync"
+)
+
+// FrameSize is the size of one 20ms frame of 8kHz G.711, the unit Twilio
+// Media Streams sends audio in.
+const FrameSize = 160
+
+// maxPooledFrame bounds the buffers a FramePool keeps, so one oversized
+// message doesn't pin its memory for the life of the pool.
+const maxPooledFrame = 16 * FrameSize
+
+// Frame is one frame of telephony audio, as received and decoded.
+type Frame struct {
+	// Payload is the codec data, e.g. the base64-decoded payload of a
+	// Twilio "media" message.
+	Payload []byte
+	// PCM is Payload decoded to 16-bit linear PCM.
+	PCM []int16
+}
+
+// DecodeMulawBase64 fills f from a base64 payload of mu-law audio, reusing
+// f's buffers. On error f is left empty.
+func (f *Frame) DecodeMulawBase64(payload []byte) error {
+	n := base64.StdEncoding.DecodedLen(len(payload))
+	f.Payload = slices.Grow(f.Payload[:0], n)[:n]
+	n, err := base64.StdEncoding.Decode(f.Payload, payload)
+	if err != nil {
+		f.Payload, f.PCM = f.Payload[:0], f.PCM[:0]
+		return err
+	}
+	f.Payload = f.Payload[:n]
+	f.PCM = AppendMulawDecode(f.PCM[:0], f.Payload)
+	return nil
+}
+
+// FramePool recycles Frames, so a transport decoding every media message
+// it receives doesn't allocate per frame. It is safe for concurrent use.
+type FramePool struct {
+	pool sync.Pool
+}
+
+// NewFramePool creates a pool of frames sized for FrameSize samples.
+func NewFramePool() *FramePool {
+	return &FramePool{pool: sync.Pool{New: func() any {
+		return &Frame{
+			Payload: make([]byte, 0, FrameSize),
+			PCM:     make([]int16, 0, FrameSize),
+		}
+	}}}
+}
+
+// Get returns an empty frame.
+func (p *FramePool) Get() *Frame {
+	f := p.pool.Get().(*Frame)
+	f.Payload, f.PCM = f.Payload[:0], f.PCM[:0]
+	return f
+}
+
+// Put returns f to the pool. f must not be used afterwards.
+func (p *FramePool) Put(f *Frame) {
+	if cap(f.Payload) > maxPooledFrame || cap(f.PCM) > maxPooledFrame {
+		return
+	}
+	p.pool.Put(f)
+}
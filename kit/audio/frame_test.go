@@ -0,0 +1,180 @@
+package audio
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"slices"
+	"strconv"
+	"testing"
+)
+
+// framesPerSecond is the rate Twilio sends 20ms frames at.
+const framesPerSecond = 50
+
+// mediaMessage is the part of a Twilio "media" message the tests read.
+type mediaMessage struct {
+	Event     string `json:"event"`
+	StreamSid string `json:"streamSid"`
+	Media     struct {
+		Track     string `json:"track"`
+		Chunk     string `json:"chunk"`
+		Timestamp string `json:"timestamp"`
+		Payload   string `json:"payload"`
+	} `json:"media"`
+}
+
+// mediaMessages returns n consecutive media messages carrying a 440Hz
+// tone.
+func mediaMessages(n int) [][]byte {
+	pcm := tone(8000, 440, float64(n)/framesPerSecond)
+	msgs := make([][]byte, n)
+	for i := range msgs {
+		var m mediaMessage
+		m.Event = "media"
+		m.StreamSid = "MZ00000000000000000000000000000000"
+		m.Media.Track = "inbound"
+		m.Media.Chunk = strconv.Itoa(i + 1)
+		m.Media.Timestamp = strconv.Itoa(i * 20)
+		m.Media.Payload = base64.StdEncoding.EncodeToString(MulawEncode(pcm[i*FrameSize : (i+1)*FrameSize]))
+		msgs[i], _ = json.Marshal(m)
+	}
+	return msgs
+}
+
+// decodeNaive decodes a message the straightforward way, allocating at
+// every step.
+func decodeNaive(msg []byte) []int16 {
+	var m mediaMessage
+	if err := json.Unmarshal(msg, &m); err != nil {
+		return nil
+	}
+	ulaw, err := base64.StdEncoding.DecodeString(m.Media.Payload)
+	if err != nil {
+		return nil
+	}
+	return MulawDecode(ulaw)
+}
+
+var payloadKey = []byte(`"payload":"`)
+
+// mediaPayload finds the base64 payload of a media message without
+// decoding the rest of it. Base64 needs no JSON escaping, so a payload
+// containing a backslash is left to the full decoder.
+func mediaPayload(msg []byte) ([]byte, bool) {
+	_, rest, ok := bytes.Cut(msg, payloadKey)
+	if !ok {
+		return nil, false
+	}
+	payload, _, ok := bytes.Cut(rest, []byte{'"'})
+	if !ok || bytes.IndexByte(payload, '\\') >= 0 {
+		return nil, false
+	}
+	return payload, true
+}
+
+func TestFrameDecodeMulawBase64(t *testing.T) {
+	pool := NewFramePool()
+	for i, msg := range mediaMessages(8) {
+		payload, ok := mediaPayload(msg)
+		if !ok {
+			t.Fatalf("message %d: no payload found", i)
+		}
+		f := pool.Get()
+		if err := f.DecodeMulawBase64(payload); err != nil {
+			t.Fatal(err)
+		}
+		if !slices.Equal(f.PCM, decodeNaive(msg)) {
+			t.Errorf("message %d: pooled frame differs from the naive decode", i)
+		}
+		pool.Put(f)
+	}
+
+	f := pool.Get()
+	if err := f.DecodeMulawBase64([]byte("not base64!")); err == nil || len(f.Payload) != 0 || len(f.PCM) != 0 {
+		t.Errorf("invalid payload: err %v, frame of %d bytes and %d samples", err, len(f.Payload), len(f.PCM))
+	}
+}
+
+func TestFrameReusesBuffers(t *testing.T) {
+	f := NewFramePool().Get()
+	payload, _ := mediaPayload(mediaMessages(1)[0])
+	allocs := testing.AllocsPerRun(100, func() {
+		_ = f.DecodeMulawBase64(payload)
+	})
+	if allocs != 0 {
+		t.Errorf("%.1f allocations per frame, want none", allocs)
+	}
+}
+
+// BenchmarkMediaDecode measures the cost of decoding inbound Media Streams
+// audio per 20ms frame: each stage on its own, then the naive path against
+// the pooled one on every CPU at once (vary with -cpu). calls is the
+// number of calls, at 50 frames a second, the process keeps up with. Build
+// with GOEXPERIMENT=simd to use the vector mu-law decoder on amd64.
+func BenchmarkMediaDecode(b *testing.B) {
+	msgs := mediaMessages(64)
+	payloads := make([][]byte, len(msgs))
+	ulaw := make([][]byte, len(msgs))
+	for i, msg := range msgs {
+		payloads[i], _ = mediaPayload(msg)
+		ulaw[i], _ = base64.StdEncoding.DecodeString(string(payloads[i]))
+	}
+	buf := make([]byte, FrameSize)
+	pcm := make([]int16, 0, FrameSize)
+
+	for _, s := range []struct {
+		name string
+		run  func(i int)
+	}{
+		{"stage=json.Unmarshal", func(i int) {
+			var m mediaMessage
+			_ = json.Unmarshal(msgs[i%len(msgs)], &m)
+		}},
+		{"stage=payload-scan", func(i int) { _, _ = mediaPayload(msgs[i%len(msgs)]) }},
+		{"stage=base64.DecodeString", func(i int) { _, _ = base64.StdEncoding.DecodeString(string(payloads[i%len(payloads)])) }},
+		{"stage=base64.Decode-reused", func(i int) { _, _ = base64.StdEncoding.Decode(buf, payloads[i%len(payloads)]) }},
+		{"stage=MulawDecode", func(i int) { _ = MulawDecode(ulaw[i%len(ulaw)]) }},
+		{"stage=AppendMulawDecode-reused", func(i int) { pcm = AppendMulawDecode(pcm[:0], ulaw[i%len(ulaw)]) }},
+	} {
+		b.Run(s.name, func(b *testing.B) {
+			b.ReportAllocs()
+			i := 0
+			for b.Loop() {
+				s.run(i)
+				i++
+			}
+		})
+	}
+
+	pool := NewFramePool()
+	for _, p := range []struct {
+		name   string
+		decode func(msg []byte)
+	}{
+		{"path=naive", func(msg []byte) { _ = decodeNaive(msg) }},
+		{"path=pooled", func(msg []byte) {
+			payload, ok := mediaPayload(msg)
+			if !ok {
+				_ = decodeNaive(msg)
+				return
+			}
+			f := pool.Get()
+			_ = f.DecodeMulawBase64(payload)
+			pool.Put(f)
+		}},
+	} {
+		b.Run(p.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				i := 0
+				for pb.Next() {
+					p.decode(msgs[i%len(msgs)])
+					i++
+				}
+			})
+			perFrame := float64(b.Elapsed().Nanoseconds()) / float64(b.N)
+			b.ReportMetric(1e9/perFrame/framesPerSecond, "calls")
+		})
+	}
+}
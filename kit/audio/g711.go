@@ -0,0 +1,41 @@
+package audio
+
+import "sync"
+
+// G.711 conversions run over whole frames through lookup tables, so their
+// inner loops are a load and a store per sample, with no branches and, as
+// the indexes can't go out of range, no bounds checks. Decoding indexes a
+// 256-entry table by code. Encoding indexes a table by the sample's bits:
+// all 16 of them for mu-law, whose bias carries into the low bits, and the
+// top 13 for A-law, which ignores the rest. The tables are built from the
+// per-sample arithmetic in mulaw.go and alaw.go, which stays the reference.
+
+var (
+	mulawDecodeTable = decodeTable(mulawToLinear)
+	alawDecodeTable  = decodeTable(alawToLinear)
+
+	// The encode tables (64KB and 8KB) are built on first use, so programs
+	// that never encode don't pay for them.
+	mulawEncodeTable = sync.OnceValue(func() *[1 << 16]byte {
+		var t [1 << 16]byte
+		for i := range t {
+			t[i] = linearToMulaw(int16(uint16(i)))
+		}
+		return &t
+	})
+	alawEncodeTable = sync.OnceValue(func() *[1 << 13]byte {
+		var t [1 << 13]byte
+		for i := range t {
+			t[i] = linearToAlaw(int16(uint16(i) << 3))
+		}
+		return &t
+	})
+)
+
+func decodeTable(decode func(byte) int16) *[256]int16 {
+	var t [256]int16
+	for i := range t {
+		t[i] = decode(byte(i))
+	}
+	return &t
+}
@@ -0,0 +1,306 @@
+package audio
+
+// G.722 wideband codec at 64 kbit/s: 16kHz 16-bit PCM is split by a QMF
+// into low and high sub-bands, coded with 6-bit and 2-bit ADPCM, and packed
+// into one byte per pair of input samples. The implementation follows the
+// ITU-T G.722 reference algorithm (as in spandsp).
+
+var (
+	g722QMFCoeffs = [12]int{3, -11, 12, 32, -210, 951, 3876, -805, 362, -156, 53, -11}
+
+	g722Q6   = [32]int{0, 35, 72, 110, 150, 190, 233, 276, 323, 370, 422, 473, 530, 587, 650, 714, 786, 858, 940, 1023, 1121, 1219, 1339, 1458, 1612, 1765, 1980, 2195, 2557, 2919, 0, 0}
+	g722ILN  = [32]int{0, 63, 62, 31, 30, 29, 28, 27, 26, 25, 24, 23, 22, 21, 20, 19, 18, 17, 16, 15, 14, 13, 12, 11, 10, 9, 8, 7, 6, 5, 4, 0}
+	g722ILP  = [32]int{0, 61, 60, 59, 58, 57, 56, 55, 54, 53, 52, 51, 50, 49, 48, 47, 46, 45, 44, 43, 42, 41, 40, 39, 38, 37, 36, 35, 34, 33, 32, 0}
+	g722WL   = [8]int{-60, -30, 58, 172, 334, 538, 1198, 3042}
+	g722RL42 = [16]int{0, 7, 6, 5, 4, 3, 2, 1, 7, 6, 5, 4, 3, 2, 1, 0}
+	g722ILB  = [32]int{
+		2048, 2093, 2139, 2186, 2233, 2282, 2332, 2383, 2435, 2489, 2543, 2599, 2656, 2714, 2774, 2834,
+		2896, 2960, 3025, 3091, 3158, 3228, 3298, 3371, 3444, 3520, 3597, 3676, 3756, 3838, 3922, 4008,
+	}
+	g722QM4 = [16]int{0, -20456, -12896, -8968, -6288, -4240, -2584, -1200, 20456, 12896, 8968, 6288, 4240, 2584, 1200, 0}
+	g722QM6 = [64]int{
+		-136, -136, -136, -136, -24808, -21904, -19008, -16704,
+		-14984, -13512, -12280, -11192, -10232, -9360, -8576, -7856,
+		-7192, -6576, -6000, -5456, -4944, -4464, -4008, -3576,
+		-3168, -2776, -2400, -2032, -1688, -1360, -1040, -728,
+		24808, 21904, 19008, 16704, 14984, 13512, 12280, 11192,
+		10232, 9360, 8576, 7856, 7192, 6576, 6000, 5456,
+		4944, 4464, 4008, 3576, 3168, 2776, 2400, 2032,
+		1688, 1360, 1040, 728, 432, 136, -432, -136,
+	}
+	g722QM2 = [4]int{-7408, -1616, 7408, 1616}
+	g722IHN = [3]int{0, 1, 0}
+	g722IHP = [3]int{0, 3, 2}
+	g722WH  = [3]int{0, -214, 798}
+	g722RH2 = [4]int{2, 1, 2, 1}
+)
+
+// g722Band is the ADPCM predictor state of one sub-band.
+type g722Band struct {
+	s, sp, sz int
+	r, a, ap  [3]int
+	p         [3]int
+	d, b, bp  [7]int
+	sg        [7]int
+	nb, det   int
+}
+
+// G722Encoder encodes 16kHz PCM to G.722. It is stateful and not safe for
+// concurrent use.
+type G722Encoder struct {
+	x       [24]int
+	band    [2]g722Band
+	pending int16 // odd trailing sample awaiting its pair
+	held    bool  // whether pending holds a sample
+}
+
+// NewG722Encoder creates an encoder.
+func NewG722Encoder() *G722Encoder {
+	e := &G722Encoder{}
+	e.band[0].det = 32
+	e.band[1].det = 8
+	return e
+}
+
+// Encode converts 16kHz PCM into G.722 bytes, one per two samples. An odd
+// trailing sample is held until the next call.
+func (e *G722Encoder) Encode(pcm []int16) []byte {
+	return e.AppendEncode(make([]byte, 0, (len(pcm)+1)/2), pcm)
+}
+
+// AppendEncode is Encode appending to dst, returning the extended slice.
+func (e *G722Encoder) AppendEncode(dst []byte, pcm []int16) []byte {
+	if e.held && len(pcm) > 0 {
+		dst = append(dst, e.encodePair(e.pending, pcm[0]))
+		pcm = pcm[1:]
+		e.held = false
+	}
+	if len(pcm)%2 == 1 {
+		e.pending, e.held = pcm[len(pcm)-1], true
+		pcm = pcm[:len(pcm)-1]
+	}
+	for j := 0; j < len(pcm); j += 2 {
+		dst = append(dst, e.encodePair(pcm[j], pcm[j+1]))
+	}
+	return dst
+}
+
+// encodePair encodes two consecutive samples as one byte.
+func (e *G722Encoder) encodePair(s0, s1 int16) byte {
+	// Transmit QMF: split into low and high bands.
+	copy(e.x[:22], e.x[2:])
+	e.x[22] = int(s0)
+	e.x[23] = int(s1)
+	var sumEven, sumOdd int
+	for i := range 12 {
+		sumOdd += e.x[2*i] * g722QMFCoeffs[i]
+		sumEven += e.x[2*i+1] * g722QMFCoeffs[11-i]
+	}
+	xlow := (sumEven + sumOdd) >> 14
+	xhigh := (sumEven - sumOdd) >> 14
+
+	// Low band: 6-bit ADPCM.
+	low := &e.band[0]
+	el := saturate16(xlow - low.s)
+	wd := el
+	if el < 0 {
+		wd = -(el + 1)
+	}
+	i := 1
+	for ; i < 30; i++ {
+		if wd < (g722Q6[i]*low.det)>>12 {
+			break
+		}
+	}
+	ilow := g722ILP[i]
+	if el < 0 {
+		ilow = g722ILN[i]
+	}
+	ril := ilow >> 2
+	dlow := (low.det * g722QM4[ril]) >> 15
+	low.nb = clampInt((low.nb*127)>>7+g722WL[g722RL42[ril]], 0, 18432)
+	low.det = g722Scale(low.nb, 8)
+	low.update(dlow)
+
+	// High band: 2-bit ADPCM.
+	high := &e.band[1]
+	eh := saturate16(xhigh - high.s)
+	wd = eh
+	if eh < 0 {
+		wd = -(eh + 1)
+	}
+	mih := 1
+	if wd >= (564*high.det)>>12 {
+		mih = 2
+	}
+	ihigh := g722IHP[mih]
+	if eh < 0 {
+		ihigh = g722IHN[mih]
+	}
+	dhigh := (high.det * g722QM2[ihigh]) >> 15
+	high.nb = clampInt((high.nb*127)>>7+g722WH[g722RH2[ihigh]], 0, 22528)
+	high.det = g722Scale(high.nb, 10)
+	high.update(dhigh)
+
+	return byte(ihigh<<6 | ilow)
+}
+
+// G722Decoder decodes G.722 to 16kHz PCM. It is stateful and not safe for
+// concurrent use.
+type G722Decoder struct {
+	x    [24]int
+	band [2]g722Band
+}
+
+// NewG722Decoder creates a decoder.
+func NewG722Decoder() *G722Decoder {
+	d := &G722Decoder{}
+	d.band[0].det = 32
+	d.band[1].det = 8
+	return d
+}
+
+// Decode converts G.722 bytes into 16kHz PCM, two samples per byte.
+func (d *G722Decoder) Decode(data []byte) []int16 {
+	return d.AppendDecode(make([]int16, 0, 2*len(data)), data)
+}
+
+// AppendDecode is Decode appending to dst, returning the extended slice.
+func (d *G722Decoder) AppendDecode(dst []int16, data []byte) []int16 {
+	out := dst
+	for _, code := range data {
+		ilow := int(code) & 0x3F
+		ihigh := int(code>>6) & 0x03
+
+		// Low band.
+		low := &d.band[0]
+		rlow := clampInt(low.s+(low.det*g722QM6[ilow])>>15, -16384, 16383)
+		ril := ilow >> 2
+		dlow := (low.det * g722QM4[ril]) >> 15
+		low.nb = clampInt((low.nb*127)>>7+g722WL[g722RL42[ril]], 0, 18432)
+		low.det = g722Scale(low.nb, 8)
+		low.update(dlow)
+
+		// High band.
+		high := &d.band[1]
+		dhigh := (high.det * g722QM2[ihigh]) >> 15
+		rhigh := clampInt(dhigh+high.s, -16384, 16383)
+		high.nb = clampInt((high.nb*127)>>7+g722WH[g722RH2[ihigh]], 0, 22528)
+		high.det = g722Scale(high.nb, 10)
+		high.update(dhigh)
+
+		// Receive QMF: recombine the bands.
+		copy(d.x[:22], d.x[2:])
+		d.x[22] = rlow + rhigh
+		d.x[23] = rlow - rhigh
+		var xout1, xout2 int
+		for i := range 12 {
+			xout2 += d.x[2*i] * g722QMFCoeffs[i]
+			xout1 += d.x[2*i+1] * g722QMFCoeffs[11-i]
+		}
+		out = append(out, int16(saturate16(xout1>>11)), int16(saturate16(xout2>>11)))
+	}
+	return out
+}
+
+// g722Scale computes the quantizer scale factor from the log scale factor nb.
+func g722Scale(nb, shift int) int {
+	wd1 := (nb >> 6) & 31
+	wd2 := shift - (nb >> 11)
+	var wd3 int
+	if wd2 < 0 {
+		wd3 = g722ILB[wd1] << -wd2
+	} else {
+		wd3 = g722ILB[wd1] >> wd2
+	}
+	return wd3 << 2
+}
+
+// update runs the adaptive predictor (block 4) with the quantized difference d.
+func (b *g722Band) update(d int) {
+	// RECONS and PARREC
+	b.d[0] = d
+	b.r[0] = saturate16(b.s + d)
+	b.p[0] = saturate16(b.sz + d)
+
+	// UPPOL2
+	for i := range 3 {
+		b.sg[i] = b.p[i] >> 15
+	}
+	wd1 := saturate16(b.a[1] << 2)
+	wd2 := wd1
+	if b.sg[0] == b.sg[1] {
+		wd2 = -wd1
+	}
+	wd2 = min(wd2, 32767)
+	wd3 := wd2 >> 7
+	if b.sg[0] == b.sg[2] {
+		wd3 += 128
+	} else {
+		wd3 -= 128
+	}
+	wd3 += (b.a[2] * 32512) >> 15
+	b.ap[2] = clampInt(wd3, -12288, 12288)
+
+	// UPPOL1
+	b.sg[0] = b.p[0] >> 15
+	b.sg[1] = b.p[1] >> 15
+	wd1 = -192
+	if b.sg[0] == b.sg[1] {
+		wd1 = 192
+	}
+	wd2 = (b.a[1] * 32640) >> 15
+	b.ap[1] = saturate16(wd1 + wd2)
+	wd3 = saturate16(15360 - b.ap[2])
+	b.ap[1] = clampInt(b.ap[1], -wd3, wd3)
+
+	// UPZERO
+	wd1 = 0
+	if d != 0 {
+		wd1 = 128
+	}
+	b.sg[0] = d >> 15
+	for i := 1; i < 7; i++ {
+		b.sg[i] = b.d[i] >> 15
+		wd2 = -wd1
+		if b.sg[i] == b.sg[0] {
+			wd2 = wd1
+		}
+		wd3 = (b.b[i] * 32640) >> 15
+		b.bp[i] = saturate16(wd2 + wd3)
+	}
+
+	// DELAYA
+	for i := 6; i > 0; i-- {
+		b.d[i] = b.d[i-1]
+		b.b[i] = b.bp[i]
+	}
+	for i := 2; i > 0; i-- {
+		b.r[i] = b.r[i-1]
+		b.p[i] = b.p[i-1]
+		b.a[i] = b.ap[i]
+	}
+
+	// FILTEP
+	wd1 = (b.a[1] * saturate16(b.r[1]+b.r[1])) >> 15
+	wd2 = (b.a[2] * saturate16(b.r[2]+b.r[2])) >> 15
+	b.sp = saturate16(wd1 + wd2)
+
+	// FILTEZ
+	b.sz = 0
+	for i := 6; i > 0; i-- {
+		b.sz += (b.b[i] * saturate16(b.d[i]+b.d[i])) >> 15
+	}
+	b.sz = saturate16(b.sz)
+
+	// PREDIC
+	b.s = saturate16(b.sp + b.sz)
+}
+
+func saturate16(v int) int {
+	return clampInt(v, -32768, 32767)
+}
+
+func clampInt(v, lo, hi int) int {
+	return max(lo, min(v, hi))
+}
@@ -0,0 +1,79 @@
+package audio
+
+import (
+	"bytes"
+	"math"
+	"slices"
+	"testing"
+)
+
+// g722Delay is the delay in samples through the encoder's and decoder's
+// QMFs together.
+const g722Delay = 22
+
+// TestG722RoundTrip checks that a tone comes back from the codec as the
+// same tone, delayed by the QMFs. The high band, above 4kHz, has only 2
+// bits a sample, so comes back noisier.
+func TestG722RoundTrip(t *testing.T) {
+	for _, tt := range []struct{ hz, minSNR float64 }{
+		{300, 30},
+		{1000, 30},
+		{3000, 30},
+		{6000, 20},
+	} {
+		hz := tt.hz
+		in := tone(16000, hz, 1)
+		encoded := NewG722Encoder().Encode(in)
+		if len(encoded) != len(in)/2 {
+			t.Fatalf("%.0f Hz: %d bytes, want one per two samples", hz, len(encoded))
+		}
+		out := NewG722Decoder().Decode(encoded)
+		if len(out) != len(in) {
+			t.Fatalf("%.0f Hz: %d samples, want %d", hz, len(out), len(in))
+		}
+		// Skip the ADPCM predictors settling
+		var signal, noise float64
+		for i := 1600; i+g722Delay < len(out); i++ {
+			d := float64(out[i+g722Delay]) - float64(in[i])
+			signal += float64(in[i]) * float64(in[i])
+			noise += d * d
+		}
+		if snr := 10 * math.Log10(signal/max(noise, 1e-9)); snr < tt.minSNR {
+			t.Errorf("%.0f Hz: SNR %.1f dB, want at least %.0f", hz, snr, tt.minSNR)
+		}
+	}
+}
+
+// TestG722Chunks checks that a stream coded a chunk at a time, odd
+// lengths included, comes out as it does in one go.
+func TestG722Chunks(t *testing.T) {
+	in := tone(16000, 1000, 0.5)
+	whole := NewG722Encoder().Encode(in)
+
+	e := NewG722Encoder()
+	var encoded []byte
+	for chunk := range slices.Chunk(in, 161) {
+		encoded = e.AppendEncode(encoded, chunk)
+	}
+	if !bytes.Equal(encoded, whole) {
+		t.Fatal("chunked encoding differs from whole")
+	}
+
+	d := NewG722Decoder()
+	var decoded []int16
+	for chunk := range slices.Chunk(encoded, 37) {
+		decoded = d.AppendDecode(decoded, chunk)
+	}
+	if !slices.Equal(decoded, NewG722Decoder().Decode(whole)) {
+		t.Fatal("chunked decoding differs from whole")
+	}
+}
+
+func TestG722Silence(t *testing.T) {
+	out := NewG722Decoder().Decode(NewG722Encoder().Encode(make([]int16, 1600)))
+	for i, s := range out {
+		if s < -16 || s > 16 {
+			t.Fatalf("sample %d of silence decoded as %d", i, s)
+		}
+	}
+}
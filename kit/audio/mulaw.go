@@ -0,0 +1,75 @@
+package audio
+
+import "slices"
+
+const (
+	mulawBias = 0x84
+	mulawClip = 32635
+)
+
+// MulawEncode converts 16-bit linear PCM to G.711 mu-law.
+func MulawEncode(samples []int16) []byte {
+	return AppendMulawEncode(make([]byte, 0, len(samples)), samples)
+}
+
+// AppendMulawEncode converts 16-bit linear PCM to G.711 mu-law, appending
+// the bytes to dst and returning the extended slice.
+func AppendMulawEncode(dst []byte, samples []int16) []byte {
+	table := mulawEncodeTable()
+	dst = slices.Grow(dst, len(samples))
+	out := dst[len(dst) : len(dst)+len(samples)]
+	for i, s := range samples {
+		out[i] = table[uint16(s)]
+	}
+	return dst[:len(dst)+len(samples)]
+}
+
+// MulawDecode converts G.711 mu-law to 16-bit linear PCM.
+func MulawDecode(data []byte) []int16 {
+	return AppendMulawDecode(make([]int16, 0, len(data)), data)
+}
+
+// AppendMulawDecode converts G.711 mu-law to 16-bit linear PCM, appending
+// the samples to dst and returning the extended slice. Passing a reused
+// buffer as dst[:0] decodes without allocating.
+func AppendMulawDecode(dst []int16, data []byte) []int16 {
+	dst = slices.Grow(dst, len(data))
+	out := dst[len(dst) : len(dst)+len(data)]
+	n := mulawDecodeBlocks(out, data)
+	for i, u := range data[n:] {
+		out[n+i] = mulawDecodeTable[u]
+	}
+	return dst[:len(dst)+len(data)]
+}
+
+func linearToMulaw(sample int16) byte {
+	s := int(sample)
+	sign := 0
+	if s < 0 {
+		s = -s
+		sign = 0x80
+	}
+	if s > mulawClip {
+		s = mulawClip
+	}
+	s += mulawBias
+
+	exponent := 7
+	for mask := 0x4000; s&mask == 0 && exponent > 0; mask >>= 1 {
+		exponent--
+	}
+	mantissa := (s >> (exponent + 3)) & 0x0F
+	return ^byte(sign | exponent<<4 | mantissa)
+}
+
+func mulawToLinear(u byte) int16 {
+	u = ^u
+	exponent := int(u>>4) & 0x07
+	mantissa := int(u & 0x0F)
+	s := ((mantissa << 3) + mulawBias) << exponent
+	s -= mulawBias
+	if u&0x80 != 0 {
+		return int16(-s)
+	}
+	return int16(s)
+}
@@ -0,0 +1,12 @@
+//go:build !(goexperiment.simd && amd64)
+
+package audio
+
+// SIMD names the vector instructions used to convert whole frames, or is
+// empty if every conversion is scalar. Vector paths are only built with
+// GOEXPERIMENT=simd, and only used if the CPU supports them.
+const SIMD = ""
+
+func mulawDecodeBlocks(out []int16, data []byte) int {
+	return 0
+}
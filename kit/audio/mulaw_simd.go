@@ -0,0 +1,50 @@
+//go:build goexperiment.simd && amd64
+
+package audio
+
+import "simd/archsimd"
+
+// SIMD names the vector instructions used to convert whole frames, or is
+// empty if every conversion is scalar. Vector paths are only built with
+// GOEXPERIMENT=simd, and only used if the CPU supports them.
+var SIMD = func() string {
+	if archsimd.X86.AVX2() {
+		return "avx2"
+	}
+	return ""
+}()
+
+// mulawPow2 maps a mu-law exponent to its scale, 1<<exponent.
+var mulawPow2 = [16]uint8{1, 2, 4, 8, 16, 32, 64, 128}
+
+// mulawDecodeBlocks decodes data to out sixteen samples at a time with
+// AVX2, returning how many samples it decoded. The rest are left to the
+// scalar loop. out must be at least as long as data.
+func mulawDecodeBlocks(out []int16, data []byte) int {
+	if SIMD == "" {
+		return 0
+	}
+	ones := archsimd.BroadcastUint8x16(0xFF)
+	expMask := archsimd.BroadcastUint8x16(0x07)
+	pow2 := archsimd.LoadUint8x16Array(&mulawPow2)
+	mantMask := archsimd.BroadcastUint16x16(0x0F)
+	bias := archsimd.BroadcastUint16x16(mulawBias)
+	zero := archsimd.BroadcastUint16x16(0)
+
+	n := len(data) &^ 15
+	for i := 0; i < n; i += 16 {
+		// Same arithmetic as mulawToLinear, with the variable shift by
+		// the exponent done as a multiply by 1<<exponent.
+		u := archsimd.LoadUint8x16(data[i:]).Xor(ones)
+		exponent := u.ReshapeToUint16s().ShiftAllRight(4).ReshapeToUint8s().And(expMask)
+		scale := pow2.PermuteOrZero(exponent.BitsToInt8()).ExtendToUint16()
+
+		u16 := u.ExtendToUint16()
+		s := u16.And(mantMask).ShiftAllLeft(3).Add(bias).Mul(scale).Sub(bias)
+
+		// Negate where the sign bit is set: (s ^ -1) - -1 == -s.
+		neg := zero.Sub(u16.ShiftAllRight(7))
+		s.Xor(neg).Sub(neg).BitsToInt16().Store(out[i:])
+	}
+	return n
+}
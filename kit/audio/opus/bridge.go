@@ -0,0 +1,54 @@
+package opus
+
+import (
+	"github.com/agentplexus/omnivoice-examples/kit/audio"
+)
+
+// webRTCSampleRate is the clock rate Opus uses on WebRTC links.
+const webRTCSampleRate = 48000
+
+// MulawBridge converts mono audio between 48kHz Opus (WebRTC) and 8kHz
+// mu-law (PSTN), so a browser or LiveKit participant can be connected to
+// the same session code as a Twilio caller. It is not safe for concurrent
+// use; create one per direction pair per call.
+type MulawBridge struct {
+	encoder *Encoder
+	decoder *Decoder
+	up      *audio.Resampler // 8kHz → 48kHz
+	down    *audio.Resampler // 48kHz → 8kHz
+}
+
+// NewMulawBridge creates a bridge. opts configure the Opus encoder.
+func NewMulawBridge(quality audio.Quality, opts ...EncoderOption) (*MulawBridge, error) {
+	encoder, err := NewEncoder(webRTCSampleRate, 1, opts...)
+	if err != nil {
+		return nil, err
+	}
+	decoder, err := NewDecoder(webRTCSampleRate, 1)
+	if err != nil {
+		return nil, err
+	}
+	up, err := audio.NewResampler(8000, webRTCSampleRate, quality)
+	if err != nil {
+		return nil, err
+	}
+	down, err := audio.NewResampler(webRTCSampleRate, 8000, quality)
+	if err != nil {
+		return nil, err
+	}
+	return &MulawBridge{encoder: encoder, decoder: decoder, up: up, down: down}, nil
+}
+
+// MulawToOpus converts telephony audio into Opus packets.
+func (b *MulawBridge) MulawToOpus(ulaw []byte) ([][]byte, error) {
+	return b.encoder.Encode(b.up.Process(audio.MulawDecode(ulaw)))
+}
+
+// OpusToMulaw converts one Opus packet into telephony audio.
+func (b *MulawBridge) OpusToMulaw(packet []byte) ([]byte, error) {
+	pcm, err := b.decoder.Decode(packet)
+	if err != nil {
+		return nil, err
+	}
+	return audio.MulawEncode(b.down.Process(pcm)), nil
+}
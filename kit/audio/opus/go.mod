@@ -0,0 +1,12 @@
+// Opus support lives in its own module because it needs cgo and libopus;
+// examples that don't use WebRTC-facing transports don't pay for it.
+module github.com/agentplexus/omnivoice-examples/kit/audio/opus
+
+go 1.24.11
+
+require (
+	github.com/agentplexus/omnivoice-examples/kit v0.0.0
+	gopkg.in/hraban/opus.v2 v2.0.0-20230925203106-0188a62cb302
+)
+
+replace github.com/agentplexus/omnivoice-examples/kit => ../..
@@ -0,0 +1,209 @@
+// Package opus provides the Opus encode/decode path shared by the
+// WebRTC-facing examples (browser, LiveKit, Discord), plus a bridge to the
+// 8kHz mu-law used by telephony transports.
+//
+// It wraps libopus through gopkg.in/hraban/opus.v2, so building it requires
+// cgo and the libopus/libopusfile development headers:
+//
+//	apt-get install pkg-config libopus-dev libopusfile-dev   # Debian/Ubuntu
+//	brew install pkg-config opus opusfile                     # macOS
+//
+// Nothing here reads Ogg files, so building with -tags nolibopusfile needs
+// only libopus.
+package opus
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/hraban/opus.v2"
+)
+
+// Application tunes the encoder for a use case.
+type Application = opus.Application
+
+const (
+	// AppVoIP favours speech intelligibility. It is the default.
+	AppVoIP = opus.AppVoIP
+	// AppAudio favours fidelity for music and mixed content.
+	AppAudio = opus.AppAudio
+	// AppLowDelay minimizes algorithmic delay at some cost in quality.
+	AppLowDelay = opus.AppRestrictedLowdelay
+)
+
+// maxFrameDuration is the longest frame an Opus packet can carry.
+const maxFrameDuration = 120 * time.Millisecond
+
+// EncoderOption configures an Encoder.
+type EncoderOption func(*encoderOptions)
+
+type encoderOptions struct {
+	application   Application
+	bitrate       int
+	frameDuration time.Duration
+	lossPercent   int
+}
+
+// WithApplication sets the encoder application. Defaults to AppVoIP.
+func WithApplication(app Application) EncoderOption {
+	return func(o *encoderOptions) {
+		o.application = app
+	}
+}
+
+// WithBitrate sets the target bitrate in bits per second. Defaults to the
+// libopus automatic bitrate.
+func WithBitrate(bps int) EncoderOption {
+	return func(o *encoderOptions) {
+		o.bitrate = bps
+	}
+}
+
+// WithFrameDuration sets the packet duration: 2.5, 5, 10, 20, 40 or 60ms.
+// Defaults to 20ms, the WebRTC norm.
+func WithFrameDuration(d time.Duration) EncoderOption {
+	return func(o *encoderOptions) {
+		o.frameDuration = d
+	}
+}
+
+// WithFEC enables in-band forward error correction tuned for the expected
+// packet loss percentage.
+func WithFEC(lossPercent int) EncoderOption {
+	return func(o *encoderOptions) {
+		o.lossPercent = lossPercent
+	}
+}
+
+// Encoder encodes 16-bit PCM into Opus packets of a fixed duration. Input
+// of any length is buffered until a full frame is available, so TTS chunks
+// can be passed straight through. It is not safe for concurrent use.
+type Encoder struct {
+	enc       *opus.Encoder
+	channels  int
+	frameSize int // samples per frame, all channels
+
+	pending []int16
+	packet  []byte
+}
+
+// NewEncoder creates an Encoder. sampleRate must be 8000, 12000, 16000,
+// 24000 or 48000 Hz.
+func NewEncoder(sampleRate, channels int, opts ...EncoderOption) (*Encoder, error) {
+	cfg := &encoderOptions{
+		application:   AppVoIP,
+		frameDuration: 20 * time.Millisecond,
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	switch cfg.frameDuration {
+	case 2500 * time.Microsecond, 5 * time.Millisecond, 10 * time.Millisecond,
+		20 * time.Millisecond, 40 * time.Millisecond, 60 * time.Millisecond:
+	default:
+		return nil, fmt.Errorf("invalid Opus frame duration %s", cfg.frameDuration)
+	}
+
+	enc, err := opus.NewEncoder(sampleRate, channels, cfg.application)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Opus encoder: %w", err)
+	}
+	if cfg.bitrate > 0 {
+		if err := enc.SetBitrate(cfg.bitrate); err != nil {
+			return nil, fmt.Errorf("failed to set Opus bitrate: %w", err)
+		}
+	}
+	if cfg.lossPercent > 0 {
+		if err := enc.SetInBandFEC(true); err != nil {
+			return nil, fmt.Errorf("failed to enable Opus FEC: %w", err)
+		}
+		if err := enc.SetPacketLossPerc(cfg.lossPercent); err != nil {
+			return nil, fmt.Errorf("failed to set Opus packet loss: %w", err)
+		}
+	}
+
+	return &Encoder{
+		enc:       enc,
+		channels:  channels,
+		frameSize: int(time.Duration(sampleRate) * cfg.frameDuration / time.Second * time.Duration(channels)),
+		packet:    make([]byte, 4000), // recommended maximum packet size
+	}, nil
+}
+
+// Encode buffers pcm (interleaved if multi-channel) and returns a packet
+// for every complete frame.
+func (e *Encoder) Encode(pcm []int16) ([][]byte, error) {
+	e.pending = append(e.pending, pcm...)
+
+	var packets [][]byte
+	for len(e.pending) >= e.frameSize {
+		n, err := e.enc.Encode(e.pending[:e.frameSize], e.packet)
+		if err != nil {
+			return packets, fmt.Errorf("opus encode failed: %w", err)
+		}
+		packets = append(packets, append([]byte(nil), e.packet[:n]...))
+		e.pending = e.pending[e.frameSize:]
+	}
+
+	// Compact so the buffer doesn't grow across a long stream.
+	if len(e.pending) > 0 {
+		e.pending = append(e.pending[:0:0], e.pending...)
+	} else {
+		e.pending = e.pending[:0]
+	}
+	return packets, nil
+}
+
+// Flush pads any buffered partial frame with silence and encodes it.
+func (e *Encoder) Flush() ([][]byte, error) {
+	if len(e.pending) == 0 {
+		return nil, nil
+	}
+	pad := make([]int16, e.frameSize-len(e.pending))
+	return e.Encode(pad)
+}
+
+// Decoder decodes Opus packets into 16-bit PCM. It is not safe for
+// concurrent use.
+type Decoder struct {
+	dec        *opus.Decoder
+	sampleRate int
+	channels   int
+	pcm        []int16
+}
+
+// NewDecoder creates a Decoder producing PCM at sampleRate (8000, 12000,
+// 16000, 24000 or 48000 Hz), regardless of the rate the packets were encoded at.
+func NewDecoder(sampleRate, channels int) (*Decoder, error) {
+	dec, err := opus.NewDecoder(sampleRate, channels)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Opus decoder: %w", err)
+	}
+	maxSamples := int(time.Duration(sampleRate)*maxFrameDuration/time.Second) * channels
+	return &Decoder{
+		dec:        dec,
+		sampleRate: sampleRate,
+		channels:   channels,
+		pcm:        make([]int16, maxSamples),
+	}, nil
+}
+
+// Decode decodes one packet. The returned slice is owned by the caller.
+func (d *Decoder) Decode(packet []byte) ([]int16, error) {
+	n, err := d.dec.Decode(packet, d.pcm)
+	if err != nil {
+		return nil, fmt.Errorf("opus decode failed: %w", err)
+	}
+	return append([]int16(nil), d.pcm[:n*d.channels]...), nil
+}
+
+// Conceal synthesizes audio for a lost packet of the given duration using
+// packet loss concealment, keeping playout timing intact.
+func (d *Decoder) Conceal(duration time.Duration) ([]int16, error) {
+	pcm := make([]int16, int(time.Duration(d.sampleRate)*duration/time.Second)*d.channels)
+	if err := d.dec.DecodePLC(pcm); err != nil {
+		return nil, fmt.Errorf("opus concealment failed: %w", err)
+	}
+	return pcm, nil
+}
@@ -0,0 +1,170 @@
+package opus
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/audio"
+)
+
+// tone returns d of a sine at hz, sampled at rate.
+func tone(rate int, hz float64, d time.Duration) []int16 {
+	samples := make([]int16, int(time.Duration(rate)*d/time.Second))
+	for i := range samples {
+		samples[i] = int16(8000 * math.Sin(2*math.Pi*hz*float64(i)/float64(rate)))
+	}
+	return samples
+}
+
+// power returns the power of samples at hz (the Goertzel algorithm).
+func power(samples []int16, rate int, hz float64) float64 {
+	coeff := 2 * math.Cos(2*math.Pi*hz/float64(rate))
+	var s1, s2 float64
+	for _, x := range samples {
+		s1, s2 = float64(x)+coeff*s1-s2, s1
+	}
+	return s1*s1 + s2*s2 - coeff*s1*s2
+}
+
+func rms(samples []int16) float64 {
+	var sum float64
+	for _, x := range samples {
+		sum += float64(x) * float64(x)
+	}
+	return math.Sqrt(sum / float64(len(samples)))
+}
+
+// checkTone checks that out, decoded audio of a 440Hz tone, still is one.
+// The start is skipped while the codec settles.
+func checkTone(t *testing.T, name string, out []int16, rate int) {
+	t.Helper()
+	settled := out[len(out)/4:]
+	if level := rms(settled); level < 8000/math.Sqrt2/2 || level > 8000/math.Sqrt2*2 {
+		t.Errorf("%s: level %.0f, want about %.0f", name, level, 8000/math.Sqrt2)
+	}
+	if tone, other := power(settled, rate, 440), power(settled, rate, 1000); tone < 20*other {
+		t.Errorf("%s: 440Hz at %.3g, 1kHz at %.3g: tone lost", name, tone, other)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, rate := range []int{8000, 16000, 48000} {
+		enc, err := NewEncoder(rate, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		dec, err := NewDecoder(rate, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		in := tone(rate, 440, time.Second)
+
+		// Passed in uneven chunks, as TTS audio arrives
+		var packets [][]byte
+		for rest := in; len(rest) > 0; {
+			n := min(len(rest), 333)
+			p, err := enc.Encode(rest[:n])
+			if err != nil {
+				t.Fatal(err)
+			}
+			packets = append(packets, p...)
+			rest = rest[n:]
+		}
+		if len(packets) != 50 {
+			t.Errorf("%dHz: %d packets for a second, want 50 of 20ms", rate, len(packets))
+		}
+
+		var out []int16
+		for _, p := range packets {
+			pcm, err := dec.Decode(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out = append(out, pcm...)
+		}
+		if len(out) != len(in) {
+			t.Errorf("%dHz: decoded %d samples, want %d", rate, len(out), len(in))
+		}
+		checkTone(t, "round trip", out, rate)
+	}
+}
+
+func TestFlush(t *testing.T) {
+	enc, err := NewEncoder(48000, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dec, err := NewDecoder(48000, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	packets, err := enc.Encode(tone(48000, 440, 30*time.Millisecond))
+	if err != nil || len(packets) != 1 {
+		t.Fatalf("Encode(30ms) = %d packets, %v; want 1", len(packets), err)
+	}
+	// The 10ms left is padded to a whole frame
+	flushed, err := enc.Flush()
+	if err != nil || len(flushed) != 1 {
+		t.Fatalf("Flush = %d packets, %v; want 1", len(flushed), err)
+	}
+	if again, err := enc.Flush(); err != nil || len(again) != 0 {
+		t.Errorf("second Flush = %d packets, %v; want none", len(again), err)
+	}
+	for _, p := range append(packets, flushed...) {
+		pcm, err := dec.Decode(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pcm) != 960 {
+			t.Errorf("decoded %d samples, want 960", len(pcm))
+		}
+	}
+
+	// A lost packet is concealed with as much audio as it carried
+	pcm, err := dec.Conceal(20 * time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pcm) != 960 {
+		t.Errorf("Conceal(20ms) = %d samples, want 960", len(pcm))
+	}
+}
+
+func TestNewEncoderRejects(t *testing.T) {
+	if _, err := NewEncoder(48000, 1, WithFrameDuration(30*time.Millisecond)); err == nil {
+		t.Error("NewEncoder accepted a 30ms frame")
+	}
+	if _, err := NewEncoder(44100, 1); err == nil {
+		t.Error("NewEncoder accepted 44.1kHz")
+	}
+}
+
+func TestMulawBridge(t *testing.T) {
+	b, err := NewMulawBridge(audio.QualitySinc, WithBitrate(32000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ulaw := audio.MulawEncode(tone(8000, 440, time.Second))
+
+	// A caller's 20ms frames become WebRTC packets and back
+	var out []byte
+	for rest := ulaw; len(rest) > 0; rest = rest[160:] {
+		packets, err := b.MulawToOpus(rest[:160])
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, p := range packets {
+			frame, err := b.OpusToMulaw(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out = append(out, frame...)
+		}
+	}
+	// The encoder holds back up to a frame, and the resamplers their delay
+	if len(out) < len(ulaw)-2*160 || len(out) > len(ulaw) {
+		t.Errorf("%d bytes back for %d", len(out), len(ulaw))
+	}
+	checkTone(t, "bridge", audio.MulawDecode(out), 8000)
+}
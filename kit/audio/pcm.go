@@ -0,0 +1,52 @@
+package audio
+
+import (
+	"encoding/binary"
+	"math"
+	"slices"
+)
+
+// PCM16FromBytes decodes little-endian 16-bit PCM. A trailing odd byte is ignored.
+func PCM16FromBytes(b []byte) []int16 {
+	return AppendPCM16FromBytes(make([]int16, 0, len(b)/2), b)
+}
+
+// AppendPCM16FromBytes decodes little-endian 16-bit PCM, appending the
+// samples to dst and returning the extended slice. A trailing odd byte is
+// ignored.
+func AppendPCM16FromBytes(dst []int16, b []byte) []int16 {
+	n := len(b) / 2
+	dst = slices.Grow(dst, n)
+	out := dst[len(dst) : len(dst)+n]
+	for i := range out {
+		out[i] = int16(binary.LittleEndian.Uint16(b[2*i:]))
+	}
+	return dst[:len(dst)+n]
+}
+
+// PCM16ToBytes encodes samples as little-endian 16-bit PCM.
+func PCM16ToBytes(samples []int16) []byte {
+	return AppendPCM16ToBytes(make([]byte, 0, 2*len(samples)), samples)
+}
+
+// AppendPCM16ToBytes encodes samples as little-endian 16-bit PCM, appending
+// them to dst and returning the extended slice.
+func AppendPCM16ToBytes(dst []byte, samples []int16) []byte {
+	for _, s := range samples {
+		dst = binary.LittleEndian.AppendUint16(dst, uint16(s))
+	}
+	return dst
+}
+
+// LevelDBFS returns the RMS level of samples in dB relative to full scale.
+// Silence returns -inf.
+func LevelDBFS(samples []int16) float64 {
+	if len(samples) == 0 {
+		return math.Inf(-1)
+	}
+	var sum float64
+	for _, s := range samples {
+		sum += float64(s) * float64(s)
+	}
+	return 10 * math.Log10(sum/float64(len(samples))/(math.MaxInt16*math.MaxInt16))
+}
@@ -0,0 +1,269 @@
+package audio
+
+import (
+	"fmt"
+	"math"
+)
+
+// Quality selects the interpolation kernel used by a Resampler.
+type Quality int
+
+const (
+	// QualityLinear interpolates between neighbouring samples. It is cheap
+	// but does not band-limit, so downsampling folds high frequencies back
+	// into the audible band.
+	QualityLinear Quality = iota
+	// QualitySinc applies a Kaiser-windowed sinc low-pass filter. It costs
+	// more CPU but keeps aliasing well below the mu-law noise floor.
+	QualitySinc
+)
+
+const (
+	// sincZeroCrossings is the number of sinc lobes on each side of the
+	// kernel at the filter cutoff.
+	sincZeroCrossings = 16
+	// kaiserBeta trades transition width for stopband attenuation (~85dB).
+	kaiserBeta = 8.6
+)
+
+// String returns the quality name.
+func (q Quality) String() string {
+	switch q {
+	case QualityLinear:
+		return "linear"
+	case QualitySinc:
+		return "sinc"
+	default:
+		return fmt.Sprintf("Quality(%d)", int(q))
+	}
+}
+
+// ParseQuality parses a quality name ("linear" or "sinc").
+func ParseQuality(s string) (Quality, error) {
+	switch s {
+	case "linear":
+		return QualityLinear, nil
+	case "sinc":
+		return QualitySinc, nil
+	default:
+		return 0, fmt.Errorf("unknown resampler quality %q", s)
+	}
+}
+
+// Resampler converts mono 16-bit PCM between sample rates using a polyphase
+// filter, so any pair of integer rates (8k↔16k↔24k↔48k, 44.1k→8k, ...) is
+// supported exactly.
+//
+// A Resampler is stateful: successive Process calls are treated as one
+// continuous stream, so chunk boundaries don't produce clicks. It is not
+// safe for concurrent use.
+type Resampler struct {
+	fromRate int
+	toRate   int
+	quality  Quality
+
+	up   int         // interpolation factor L
+	down int         // decimation factor M
+	half int         // kernel half-width in input samples
+	taps [][]float32 // one row of 2*half taps per phase
+
+	buf   []float32 // pending input, including left context
+	pos   int       // input index of the next output sample
+	phase int       // sub-sample phase of the next output, in 1/up units
+}
+
+// NewResampler creates a Resampler from fromRate to toRate Hz.
+func NewResampler(fromRate, toRate int, quality Quality) (*Resampler, error) {
+	if fromRate <= 0 || toRate <= 0 {
+		return nil, fmt.Errorf("invalid sample rates %d → %d", fromRate, toRate)
+	}
+
+	g := gcd(fromRate, toRate)
+	up, down := toRate/g, fromRate/g
+
+	// Cutoff relative to the input Nyquist frequency: when downsampling the
+	// filter must remove everything above the output Nyquist.
+	cutoff := 1.0
+	if up < down {
+		cutoff = float64(up) / float64(down)
+	}
+
+	var half int
+	var kernel func(x float64) float64
+	switch quality {
+	case QualityLinear:
+		half = 1
+		kernel = func(x float64) float64 {
+			return max(0, 1-math.Abs(x))
+		}
+	case QualitySinc:
+		half = int(math.Ceil(sincZeroCrossings / cutoff))
+		norm := besselI0(kaiserBeta)
+		kernel = func(x float64) float64 {
+			r := x / float64(half)
+			if r <= -1 || r >= 1 {
+				return 0
+			}
+			window := besselI0(kaiserBeta*math.Sqrt(1-r*r)) / norm
+			return cutoff * sinc(cutoff*x) * window
+		}
+	default:
+		return nil, fmt.Errorf("unknown resampler quality %d", quality)
+	}
+
+	// Precompute one filter per phase, normalized to unity DC gain so that
+	// a constant input produces a constant output.
+	taps := make([][]float32, up)
+	weights := make([]float64, 2*half)
+	for p := range up {
+		frac := float64(p) / float64(up)
+		var sum float64
+		for j := range weights {
+			weights[j] = kernel(float64(j-half+1) - frac)
+			sum += weights[j]
+		}
+		row := make([]float32, len(weights))
+		for j, w := range weights {
+			row[j] = float32(w / sum)
+		}
+		taps[p] = row
+	}
+
+	r := &Resampler{
+		fromRate: fromRate,
+		toRate:   toRate,
+		quality:  quality,
+		up:       up,
+		down:     down,
+		half:     half,
+		taps:     taps,
+	}
+	r.Reset()
+	return r, nil
+}
+
+// FromRate returns the input sample rate.
+func (r *Resampler) FromRate() int { return r.fromRate }
+
+// ToRate returns the output sample rate.
+func (r *Resampler) ToRate() int { return r.toRate }
+
+// Quality returns the interpolation quality.
+func (r *Resampler) Quality() Quality { return r.quality }
+
+// Latency returns the delay between input and the output it produces.
+func (r *Resampler) Latency() float64 {
+	return float64(r.half) / float64(r.fromRate)
+}
+
+// Reset discards buffered input, e.g. after a barge-in, so the next Process
+// call starts a fresh stream.
+func (r *Resampler) Reset() {
+	// Left context for the first output sample is silence.
+	r.buf = make([]float32, r.half-1, 4*r.half)
+	r.pos = r.half - 1
+	r.phase = 0
+}
+
+// Process resamples the next chunk of a stream. Output lags input by
+// Latency; use Flush at the end of the stream to drain it.
+func (r *Resampler) Process(in []int16) []int16 {
+	return r.AppendProcess(make([]int16, 0, len(in)*r.up/r.down+1), in)
+}
+
+// AppendProcess is Process appending the output to dst, returning the
+// extended slice. Passing a reused buffer as dst[:0] resamples a stream
+// without allocating per chunk.
+func (r *Resampler) AppendProcess(dst, in []int16) []int16 {
+	for _, s := range in {
+		r.buf = append(r.buf, float32(s))
+	}
+
+	out := dst
+	for r.pos+r.half < len(r.buf) {
+		row := r.taps[r.phase]
+		window := r.buf[r.pos-r.half+1 : r.pos+r.half+1]
+		var acc float32
+		for j, t := range row {
+			acc += window[j] * t
+		}
+		out = append(out, clampInt16(acc))
+
+		r.phase += r.down
+		r.pos += r.phase / r.up
+		r.phase %= r.up
+	}
+
+	// Keep only the left context the next output sample needs. Decimating
+	// with a short kernel can step past the input buffered so far, in which
+	// case the position carries into the next chunk.
+	if drop := min(r.pos-r.half+1, len(r.buf)); drop > 0 {
+		n := copy(r.buf, r.buf[drop:])
+		r.buf = r.buf[:n]
+		r.pos -= drop
+	}
+	return out
+}
+
+// Flush drains the samples still held back by the filter and resets the
+// Resampler for a new stream.
+func (r *Resampler) Flush() []int16 {
+	out := r.Process(make([]int16, r.half))
+	r.Reset()
+	return out
+}
+
+// Resample converts a complete buffer from one sample rate to another.
+func Resample(in []int16, fromRate, toRate int, quality Quality) ([]int16, error) {
+	r, err := NewResampler(fromRate, toRate, quality)
+	if err != nil {
+		return nil, err
+	}
+	out := r.Process(in)
+	out = append(out, r.Flush()...)
+
+	// Trim to the exact expected length; Flush pads with silence.
+	if n := len(in) * r.up / r.down; len(out) > n {
+		out = out[:n]
+	}
+	return out, nil
+}
+
+func clampInt16(v float32) int16 {
+	switch {
+	case v >= math.MaxInt16:
+		return math.MaxInt16
+	case v <= math.MinInt16:
+		return math.MinInt16
+	case v < 0:
+		return int16(v - 0.5)
+	default:
+		return int16(v + 0.5)
+	}
+}
+
+func sinc(x float64) float64 {
+	if x == 0 {
+		return 1
+	}
+	return math.Sin(math.Pi*x) / (math.Pi * x)
+}
+
+// besselI0 is the zeroth-order modified Bessel function of the first kind,
+// used by the Kaiser window.
+func besselI0(x float64) float64 {
+	sum, term := 1.0, 1.0
+	for k := 1; term > 1e-12*sum; k++ {
+		t := x / (2 * float64(k))
+		term *= t * t
+		sum += term
+	}
+	return sum
+}
+
+func gcd(a, b int) int {
+	for b != 0 {
+		a, b = b, a%b
+	}
+	return a
+}
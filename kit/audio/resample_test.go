@@ -0,0 +1,146 @@
+package audio
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"testing"
+)
+
+// resampleConversions are the conversions the examples need.
+var resampleConversions = [][2]int{
+	{24000, 8000},
+	{16000, 8000},
+	{48000, 8000},
+	{8000, 16000},
+	{8000, 24000},
+	{16000, 24000},
+	{24000, 48000},
+}
+
+const (
+	testToneHz        = 1000.0
+	testToneAmplitude = 8000.0
+)
+
+// TestResampleQuality checks each conversion by the SNR of a resampled
+// in-band tone against an ideal tone at the output rate and, for the sinc
+// kernel when downsampling, by the level of the alias an out-of-band tone
+// folds into.
+func TestResampleQuality(t *testing.T) {
+	minSNR := map[Quality]float64{QualityLinear: 20, QualitySinc: 55}
+	const maxAliasDB = -80
+
+	for _, c := range resampleConversions {
+		from, to := c[0], c[1]
+		for _, q := range []Quality{QualityLinear, QualitySinc} {
+			out, err := Resample(tone(from, testToneHz, 1), from, to, q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ideal := tone(to, testToneHz, 1)
+			if len(out) != len(ideal) {
+				t.Fatalf("%d→%d %s: %d samples, want %d", from, to, q, len(out), len(ideal))
+			}
+			// Skip the filter's start-up transient
+			skip := to / 100
+			var signal, noise float64
+			for i := skip; i < len(out); i++ {
+				d := float64(out[i]) - float64(ideal[i])
+				signal += float64(ideal[i]) * float64(ideal[i])
+				noise += d * d
+			}
+			if snr := 10 * math.Log10(signal/max(noise, 1e-9)); snr < minSNR[q] {
+				t.Errorf("%d→%d %s: SNR %.1f dB, want at least %.0f", from, to, q, snr, minSNR[q])
+			}
+
+			if to >= from || q != QualitySinc {
+				continue
+			}
+			// A tone above the output Nyquist should be removed, not
+			// folded back onto testToneHz
+			out, err = Resample(tone(from, float64(to)-testToneHz, 1), from, to, q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			level := goertzel(out[skip:], to, testToneHz)
+			if alias := 20 * math.Log10(max(level, 1e-9)/testToneAmplitude); alias > maxAliasDB {
+				t.Errorf("%d→%d %s: alias at %.1f dB, want below %d", from, to, q, alias, maxAliasDB)
+			}
+		}
+	}
+}
+
+// TestResamplerChunks checks that a stream resampled a chunk at a time
+// comes out as it does in one go, with no clicks at chunk boundaries.
+func TestResamplerChunks(t *testing.T) {
+	for _, c := range resampleConversions {
+		in := tone(c[0], testToneHz, 0.5)
+		r, err := NewResampler(c[0], c[1], QualitySinc)
+		if err != nil {
+			t.Fatal(err)
+		}
+		whole := append(r.Process(in), r.Flush()...)
+
+		var chunked []int16
+		for chunk := range slices.Chunk(in, 37) {
+			chunked = r.AppendProcess(chunked, chunk)
+		}
+		chunked = append(chunked, r.Flush()...)
+		if !slices.Equal(chunked, whole) {
+			t.Errorf("%d→%d: chunked output differs from whole", c[0], c[1])
+		}
+	}
+}
+
+func TestNewResamplerRejectsRates(t *testing.T) {
+	for _, rates := range [][2]int{{0, 8000}, {8000, 0}, {-8000, 16000}} {
+		if _, err := NewResampler(rates[0], rates[1], QualitySinc); err == nil {
+			t.Errorf("NewResampler(%d, %d) succeeded", rates[0], rates[1])
+		}
+	}
+}
+
+// BenchmarkResample reports the CPU cost of each kernel per output sample
+// and as a multiple of real time.
+func BenchmarkResample(b *testing.B) {
+	for _, c := range resampleConversions {
+		for _, q := range []Quality{QualityLinear, QualitySinc} {
+			b.Run(fmt.Sprintf("%d-%d/%s", c[0], c[1], q), func(b *testing.B) {
+				in := tone(c[0], testToneHz, 1)
+				r, err := NewResampler(c[0], c[1], q)
+				if err != nil {
+					b.Fatal(err)
+				}
+				var out []int16
+				b.ReportAllocs()
+				for b.Loop() {
+					out = r.AppendProcess(out[:0], in)
+				}
+				b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*c[1]), "ns/sample")
+				b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "x-realtime")
+			})
+		}
+	}
+}
+
+// tone returns seconds of a sine at hz, at rate samples a second.
+func tone(rate int, hz, seconds float64) []int16 {
+	out := make([]int16, int(float64(rate)*seconds))
+	for i := range out {
+		out[i] = int16(testToneAmplitude * math.Sin(2*math.Pi*hz*float64(i)/float64(rate)))
+	}
+	return out
+}
+
+// goertzel returns the amplitude of the given frequency in samples.
+func goertzel(samples []int16, rate int, hz float64) float64 {
+	coeff := 2 * math.Cos(2*math.Pi*hz/float64(rate))
+	var s1, s2 float64
+	for _, x := range samples {
+		s0 := float64(x) + coeff*s1 - s2
+		s2, s1 = s1, s0
+	}
+	power := s1*s1 + s2*s2 - coeff*s1*s2
+	return 2 * math.Sqrt(max(power, 0)) / float64(len(samples))
+}
@@ -0,0 +1,130 @@
+package audio
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// WAV format tags.
+const (
+	wavPCM   = 1
+	wavAlaw  = 6
+	wavMulaw = 7
+)
+
+// ReadWAV decodes a WAV file of 16-bit PCM, mu-law or A-law samples and
+// returns its samples as 16-bit PCM, with several channels mixed down to
+// one, and its sample rate.
+func ReadWAV(r io.Reader) (samples []int16, sampleRate int, err error) {
+	var header [12]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, 0, fmt.Errorf("reading WAV header: %w", err)
+	}
+	if string(header[0:4]) != "RIFF" || string(header[8:12]) != "WAVE" {
+		return nil, 0, errors.New("not a WAV file")
+	}
+
+	var format, channels, bits int
+	for {
+		var chunk [8]byte
+		if _, err := io.ReadFull(r, chunk[:]); err != nil {
+			return nil, 0, errors.New("WAV file has no data chunk")
+		}
+		id, size := string(chunk[0:4]), int64(binary.LittleEndian.Uint32(chunk[4:8]))
+		switch id {
+		case "fmt ":
+			if size < 16 {
+				return nil, 0, errors.New("invalid WAV fmt chunk")
+			}
+			var fmtChunk [16]byte
+			if _, err := io.ReadFull(r, fmtChunk[:]); err != nil {
+				return nil, 0, err
+			}
+			format = int(binary.LittleEndian.Uint16(fmtChunk[0:2]))
+			channels = int(binary.LittleEndian.Uint16(fmtChunk[2:4]))
+			sampleRate = int(binary.LittleEndian.Uint32(fmtChunk[4:8]))
+			bits = int(binary.LittleEndian.Uint16(fmtChunk[14:16]))
+			if _, err := io.CopyN(io.Discard, r, size-16+size%2); err != nil {
+				return nil, 0, err
+			}
+		case "data":
+			if format == 0 {
+				return nil, 0, errors.New("WAV data chunk before fmt chunk")
+			}
+			// Recorders that stop abruptly leave the size too large, so
+			// read up to it
+			data, err := io.ReadAll(io.LimitReader(r, size))
+			if err != nil {
+				return nil, 0, err
+			}
+			samples, err := decodeWAV(data, format, bits)
+			if err != nil {
+				return nil, 0, err
+			}
+			if channels <= 0 || sampleRate <= 0 {
+				return nil, 0, errors.New("invalid WAV channel count or sample rate")
+			}
+			return mixDown(samples, channels), sampleRate, nil
+		default:
+			if _, err := io.CopyN(io.Discard, r, size+size%2); err != nil {
+				return nil, 0, err
+			}
+		}
+	}
+}
+
+// decodeWAV decodes WAV sample data.
+func decodeWAV(data []byte, format, bits int) ([]int16, error) {
+	switch {
+	case format == wavPCM && bits == 16:
+		return PCM16FromBytes(data), nil
+	case format == wavMulaw && bits == 8:
+		return MulawDecode(data), nil
+	case format == wavAlaw && bits == 8:
+		return AlawDecode(data), nil
+	default:
+		return nil, fmt.Errorf("unsupported WAV encoding (format %d, %d bits); want 16-bit PCM, mu-law or A-law", format, bits)
+	}
+}
+
+// mixDown averages interleaved channels into one.
+func mixDown(samples []int16, channels int) []int16 {
+	if channels == 1 {
+		return samples
+	}
+	mono := make([]int16, len(samples)/channels)
+	for i := range mono {
+		var sum int
+		for _, s := range samples[i*channels : (i+1)*channels] {
+			sum += int(s)
+		}
+		mono[i] = int16(sum / channels)
+	}
+	return mono
+}
+
+// WriteWAV writes mono 16-bit PCM samples as a WAV file.
+func WriteWAV(w io.Writer, samples []int16, sampleRate int) error {
+	dataSize := 2 * len(samples)
+	header := make([]byte, 44)
+	copy(header[0:4], "RIFF")
+	binary.LittleEndian.PutUint32(header[4:8], uint32(36+dataSize))
+	copy(header[8:12], "WAVE")
+	copy(header[12:16], "fmt ")
+	binary.LittleEndian.PutUint32(header[16:20], 16)
+	binary.LittleEndian.PutUint16(header[20:22], wavPCM)
+	binary.LittleEndian.PutUint16(header[22:24], 1)
+	binary.LittleEndian.PutUint32(header[24:28], uint32(sampleRate))
+	binary.LittleEndian.PutUint32(header[28:32], uint32(2*sampleRate))
+	binary.LittleEndian.PutUint16(header[32:34], 2)
+	binary.LittleEndian.PutUint16(header[34:36], 16)
+	copy(header[36:40], "data")
+	binary.LittleEndian.PutUint32(header[40:44], uint32(dataSize))
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(PCM16ToBytes(samples))
+	return err
+}
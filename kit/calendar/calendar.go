@@ -0,0 +1,231 @@
+// Package calendar books appointments: it finds the free slots within
+// opening hours and creates events, on a Google Calendar or in memory.
+//
+//	hours, _ := calendar.ParseHours("Mon-Fri 09:00-17:00", loc)
+//	busy, err := cal.Busy(ctx, dayStart, dayEnd)
+//	free := calendar.FreeSlots(busy, hours, dayStart, dayEnd, 30*time.Minute)
+//	id, err := calendar.Book(ctx, cal, calendar.Event{
+//		Summary: "Appointment",
+//		Start:   free[0].Start,
+//		End:     free[0].End,
+//	})
+//
+// Times are kept as time.Time throughout; opening hours are in the
+// business's time zone, so slots are right across daylight saving
+// changes whichever zone the caller is in.
+package calendar
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	// Opening hours need the zone database even on minimal images.
+	_ "time/tzdata"
+)
+
+// ErrSlotTaken is returned by Book when the event's time is no longer
+// free.
+var ErrSlotTaken = errors.New("calendar: the time is no longer free")
+
+// Slot is a span of time.
+type Slot struct {
+	Start, End time.Time
+}
+
+// overlaps reports whether s and o share any time.
+func (s Slot) overlaps(o Slot) bool {
+	return s.Start.Before(o.End) && o.Start.Before(s.End)
+}
+
+// Event is an appointment on a calendar.
+type Event struct {
+	// ID, if set, is the event's ID, so creating it again (e.g. when a
+	// request is retried) doesn't book it twice. Google Calendar needs 5
+	// to 1024 characters of lowercase a-v and digits.
+	ID          string
+	Summary     string
+	Description string
+	Start, End  time.Time
+}
+
+// Calendar is where appointments are booked.
+type Calendar interface {
+	// Busy returns the busy times between from and to.
+	Busy(ctx context.Context, from, to time.Time) ([]Slot, error)
+	// Create adds an event and returns its ID. Creating an event with the
+	// ID of one that exists returns the ID without adding another.
+	Create(ctx context.Context, e Event) (string, error)
+}
+
+// Book creates e if its time is still free, returning ErrSlotTaken
+// otherwise. Two bookings racing for the same slot may both succeed
+// unless the calendar itself refuses overlapping events.
+func Book(ctx context.Context, c Calendar, e Event) (string, error) {
+	busy, err := c.Busy(ctx, e.Start, e.End)
+	if err != nil {
+		return "", err
+	}
+	slot := Slot{e.Start, e.End}
+	if slices.ContainsFunc(busy, slot.overlaps) {
+		return "", ErrSlotTaken
+	}
+	return c.Create(ctx, e)
+}
+
+// Hours are when appointments may be booked.
+type Hours struct {
+	// Location is the business's time zone.
+	Location *time.Location
+	// Open and Close bound each day as times of day since midnight.
+	Open, Close time.Duration
+	// Days are the weekdays appointments are taken on.
+	Days []time.Weekday
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// ParseHours reads opening hours in loc, written as days then times, e.g.
+// "Mon-Fri 09:00-17:00" or "Mon,Wed,Sat 10:00-14:30".
+func ParseHours(s string, loc *time.Location) (Hours, error) {
+	h := Hours{Location: loc}
+	days, times, ok := strings.Cut(strings.TrimSpace(s), " ")
+	if !ok {
+		return h, fmt.Errorf("calendar: invalid hours %q: want e.g. Mon-Fri 09:00-17:00", s)
+	}
+	for _, part := range strings.Split(days, ",") {
+		first, last, isRange := strings.Cut(part, "-")
+		from, ok1 := weekdays[strings.ToLower(strings.TrimSpace(first))]
+		to, ok2 := from, true
+		if isRange {
+			to, ok2 = weekdays[strings.ToLower(strings.TrimSpace(last))]
+		}
+		if !ok1 || !ok2 {
+			return h, fmt.Errorf("calendar: invalid days %q", part)
+		}
+		for d := from; ; d = (d + 1) % 7 {
+			if !slices.Contains(h.Days, d) {
+				h.Days = append(h.Days, d)
+			}
+			if d == to {
+				break
+			}
+		}
+	}
+	opens, closes, ok := strings.Cut(strings.TrimSpace(times), "-")
+	if !ok {
+		return h, fmt.Errorf("calendar: invalid times %q", times)
+	}
+	var err error
+	if h.Open, err = timeOfDay(opens); err != nil {
+		return h, err
+	}
+	if h.Close, err = timeOfDay(closes); err != nil {
+		return h, err
+	}
+	if h.Close <= h.Open {
+		return h, fmt.Errorf("calendar: hours %q close before they open", s)
+	}
+	return h, nil
+}
+
+func timeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("calendar: invalid time %q: want HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Contains reports whether the whole of s falls within opening hours on a
+// single day.
+func (h Hours) Contains(s Slot) bool {
+	start, end := s.Start.In(h.Location), s.End.In(h.Location)
+	if !slices.Contains(h.Days, start.Weekday()) {
+		return false
+	}
+	opens, closes := h.day(start)
+	return !start.Before(opens) && !end.After(closes)
+}
+
+// day returns when t's day opens and closes.
+func (h Hours) day(t time.Time) (opens, closes time.Time) {
+	y, m, d := t.In(h.Location).Date()
+	at := func(since time.Duration) time.Time {
+		return time.Date(y, m, d, int(since/time.Hour), int(since%time.Hour/time.Minute), 0, 0, h.Location)
+	}
+	return at(h.Open), at(h.Close)
+}
+
+// FreeSlots returns the slots of length within opening hours between from
+// and to that don't overlap busy, starting when each day opens and every
+// length after that.
+func FreeSlots(busy []Slot, h Hours, from, to time.Time, length time.Duration) []Slot {
+	var free []Slot
+	day := from.In(h.Location)
+	day = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, h.Location)
+	for ; day.Before(to); day = day.AddDate(0, 0, 1) {
+		if !slices.Contains(h.Days, day.Weekday()) {
+			continue
+		}
+		opens, closes := h.day(day)
+		for start := opens; !start.Add(length).After(closes); start = start.Add(length) {
+			slot := Slot{start, start.Add(length)}
+			if start.Before(from) || slot.End.After(to) || slices.ContainsFunc(busy, slot.overlaps) {
+				continue
+			}
+			free = append(free, slot)
+		}
+	}
+	return free
+}
+
+// Memory is a calendar held in memory, for trying bookings out offline.
+type Memory struct {
+	mu     sync.Mutex
+	events []Event
+}
+
+// Busy returns the events between from and to.
+func (m *Memory) Busy(_ context.Context, from, to time.Time) ([]Slot, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var busy []Slot
+	window := Slot{from, to}
+	for _, e := range m.events {
+		if s := (Slot{e.Start, e.End}); s.overlaps(window) {
+			busy = append(busy, s)
+		}
+	}
+	return busy, nil
+}
+
+// Create adds e, numbering it if it has no ID.
+func (m *Memory) Create(_ context.Context, e Event) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if e.ID == "" {
+		e.ID = fmt.Sprintf("event%d", len(m.events)+1)
+	}
+	for _, existing := range m.events {
+		if existing.ID == e.ID {
+			return e.ID, nil
+		}
+	}
+	m.events = append(m.events, e)
+	return e.ID, nil
+}
+
+// Events returns the events created so far.
+func (m *Memory) Events() []Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return slices.Clone(m.events)
+}
@@ -0,0 +1,155 @@
+package calendar
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/googleauth"
+)
+
+// GoogleBaseURL is the Google Calendar API root.
+const GoogleBaseURL = "https://www.googleapis.com/calendar/v3"
+
+// GoogleScope is the OAuth scope Google needs to read free/busy times and
+// create events.
+const GoogleScope = "https://www.googleapis.com/auth/calendar"
+
+// Google is a Google Calendar, reached with a service account's
+// credentials. Share the calendar with the service account's email address
+// ("Make changes to events") for it to book appointments.
+type Google struct {
+	// CalendarID is the calendar's ID, e.g. "abc123@group.calendar.google.com".
+	CalendarID string
+	// Token returns an access token for each request.
+	Token func(ctx context.Context) (string, error)
+	// BaseURL defaults to GoogleBaseURL.
+	BaseURL string
+	// Client defaults to one with a 10 second timeout.
+	Client *http.Client
+}
+
+var defaultHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// NewGoogle returns the Google Calendar calendarID, reached with the
+// service account whose JSON key file is at keyFile.
+func NewGoogle(calendarID, keyFile string) (*Google, error) {
+	account, err := googleauth.LoadServiceAccount(keyFile, GoogleScope)
+	if err != nil {
+		return nil, err
+	}
+	return &Google{CalendarID: calendarID, Token: account.Token}, nil
+}
+
+// Busy asks Google for the calendar's busy times between from and to.
+func (g *Google) Busy(ctx context.Context, from, to time.Time) ([]Slot, error) {
+	req := map[string]any{
+		"timeMin": from.UTC().Format(time.RFC3339),
+		"timeMax": to.UTC().Format(time.RFC3339),
+		"items":   []map[string]string{{"id": g.CalendarID}},
+	}
+	var resp struct {
+		Calendars map[string]struct {
+			Busy []struct {
+				Start time.Time `json:"start"`
+				End   time.Time `json:"end"`
+			} `json:"busy"`
+			Errors []struct {
+				Reason string `json:"reason"`
+			} `json:"errors"`
+		} `json:"calendars"`
+	}
+	if _, err := g.do(ctx, "/freeBusy", req, &resp); err != nil {
+		return nil, err
+	}
+	cal, ok := resp.Calendars[g.CalendarID]
+	if !ok {
+		return nil, fmt.Errorf("calendar: Google Calendar: no free/busy times for %s", g.CalendarID)
+	}
+	if len(cal.Errors) > 0 {
+		return nil, fmt.Errorf("calendar: Google Calendar: %s: %s", g.CalendarID, cal.Errors[0].Reason)
+	}
+	busy := make([]Slot, len(cal.Busy))
+	for i, b := range cal.Busy {
+		busy[i] = Slot{b.Start, b.End}
+	}
+	return busy, nil
+}
+
+// Create adds e to the calendar. An event whose ID already exists is
+// taken to be e, created by an earlier attempt.
+func (g *Google) Create(ctx context.Context, e Event) (string, error) {
+	type when struct {
+		DateTime string `json:"dateTime"`
+		TimeZone string `json:"timeZone,omitempty"`
+	}
+	zone := e.Start.Location().String()
+	if zone == "Local" {
+		zone = ""
+	}
+	req := map[string]any{
+		"summary":     e.Summary,
+		"description": e.Description,
+		"start":       when{e.Start.Format(time.RFC3339), zone},
+		"end":         when{e.End.Format(time.RFC3339), zone},
+	}
+	if e.ID != "" {
+		req["id"] = e.ID
+	}
+	var resp struct {
+		ID string `json:"id"`
+	}
+	status, err := g.do(ctx, "/calendars/"+url.PathEscape(g.CalendarID)+"/events", req, &resp)
+	if status == http.StatusConflict && e.ID != "" {
+		return e.ID, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return resp.ID, nil
+}
+
+// do posts body to path as JSON and decodes the response into out,
+// returning the response's status.
+func (g *Google) do(ctx context.Context, path string, body, out any) (int, error) {
+	token, err := g.Token(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("calendar: Google access token: %w", err)
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return 0, err
+	}
+	base := g.BaseURL
+	if base == "" {
+		base = GoogleBaseURL
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, base+path, bytes.NewReader(data))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Content-Type", "application/json")
+	client := g.Client
+	if client == nil {
+		client = defaultHTTPClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("calendar: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return resp.StatusCode, fmt.Errorf("calendar: Google Calendar API: %s: %s", resp.Status, msg)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(out); err != nil {
+		return resp.StatusCode, fmt.Errorf("calendar: Google Calendar API: %w", err)
+	}
+	return resp.StatusCode, nil
+}
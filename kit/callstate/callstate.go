@@ -0,0 +1,170 @@
+// Package callstate keeps the state of calls in progress in Redis, keyed
+// by call SID, so that a server can run as several instances behind a load
+// balancer: whichever instance a call's stream reaches finds the call's
+// metadata, and a stream that reconnects (to any instance) picks up the
+// conversation where it left off.
+//
+//	store, err := callstate.Open("redis://redis:6379/0")
+//	err = store.AppendTranscript(ctx, callSID, callstate.Line{Speaker: "caller", Text: text})
+//	lines, err := store.Transcript(ctx, callSID)
+//
+// Each call's state expires TTL after its last update, so calls that end
+// without a chance to clean up don't leave state behind.
+package callstate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/llm"
+)
+
+// Line is one line of a call's transcript.
+type Line struct {
+	At      time.Time `json:"at"`
+	Speaker string    `json:"speaker"`
+	Text    string    `json:"text"`
+	// Target is the leg a whispered line was played to, if any.
+	Target string `json:"target,omitempty"`
+}
+
+// Store keeps call state in Redis.
+type Store struct {
+	client *client
+
+	// Prefix starts every key, so several deployments can share a
+	// database. Open sets it to "omnivoice:".
+	Prefix string
+	// TTL is how long a call's state is kept after its last update. Open
+	// sets it to one hour.
+	TTL time.Duration
+}
+
+// Open returns a store for the Redis server at rawURL, of the form
+// redis://[[user]:password@]host[:port][/db] (rediss:// for TLS).
+// Connections are made when first needed.
+func Open(rawURL string) (*Store, error) {
+	c, err := newClient(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Redis URL: %w", err)
+	}
+	return &Store{client: c, Prefix: "omnivoice:", TTL: time.Hour}, nil
+}
+
+// Close closes the store's connections.
+func (s *Store) Close() error {
+	return s.client.close()
+}
+
+// Ping checks that Redis is reachable.
+func (s *Store) Ping(ctx context.Context) error {
+	_, err := s.client.do(ctx, "PING")
+	return err
+}
+
+// key names one part of a call's state. The call SID is a hash tag, so on
+// Redis Cluster a call's keys share a slot.
+func (s *Store) key(callSID, part string) string {
+	return s.Prefix + "call:{" + callSID + "}:" + part
+}
+
+func (s *Store) ttlSeconds() string {
+	return fmt.Sprint(max(int64(s.TTL/time.Second), 1))
+}
+
+// PutMetadata stores the call's metadata, as stream parameters.
+func (s *Store) PutMetadata(ctx context.Context, callSID string, params map[string]string) error {
+	return s.putJSON(ctx, s.key(callSID, "metadata"), params)
+}
+
+// Metadata returns the call's metadata, or nil if none is stored.
+func (s *Store) Metadata(ctx context.Context, callSID string) (map[string]string, error) {
+	var params map[string]string
+	return params, s.getJSON(ctx, s.key(callSID, "metadata"), &params)
+}
+
+// PutHistory replaces the call's conversation history.
+func (s *Store) PutHistory(ctx context.Context, callSID string, history []llm.Message) error {
+	return s.putJSON(ctx, s.key(callSID, "history"), history)
+}
+
+// History returns the call's conversation history, or nil if none is
+// stored.
+func (s *Store) History(ctx context.Context, callSID string) ([]llm.Message, error) {
+	var history []llm.Message
+	return history, s.getJSON(ctx, s.key(callSID, "history"), &history)
+}
+
+// AppendTranscript adds lines to the end of the call's transcript.
+func (s *Store) AppendTranscript(ctx context.Context, callSID string, lines ...Line) error {
+	if len(lines) == 0 {
+		return nil
+	}
+	key := s.key(callSID, "transcript")
+	args := []string{"RPUSH", key}
+	for _, line := range lines {
+		data, err := json.Marshal(line)
+		if err != nil {
+			return err
+		}
+		args = append(args, string(data))
+	}
+	if _, err := s.client.do(ctx, args...); err != nil {
+		return err
+	}
+	_, err := s.client.do(ctx, "EXPIRE", key, s.ttlSeconds())
+	return err
+}
+
+// Transcript returns the call's transcript, oldest line first.
+func (s *Store) Transcript(ctx context.Context, callSID string) ([]Line, error) {
+	reply, err := s.client.do(ctx, "LRANGE", s.key(callSID, "transcript"), "0", "-1")
+	if err != nil {
+		return nil, err
+	}
+	items, _ := reply.([]any)
+	lines := make([]Line, 0, len(items))
+	for _, item := range items {
+		data, ok := item.(string)
+		if !ok {
+			return nil, fmt.Errorf("redis: unexpected transcript entry %T", item)
+		}
+		var line Line
+		if err := json.Unmarshal([]byte(data), &line); err != nil {
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+// Delete removes the call's state.
+func (s *Store) Delete(ctx context.Context, callSID string) error {
+	_, err := s.client.do(ctx, "DEL", s.key(callSID, "metadata"), s.key(callSID, "history"), s.key(callSID, "transcript"))
+	return err
+}
+
+func (s *Store) putJSON(ctx context.Context, key string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = s.client.do(ctx, "SET", key, string(data), "EX", s.ttlSeconds())
+	return err
+}
+
+// getJSON decodes the value at key into v, leaving v alone if the key
+// doesn't exist.
+func (s *Store) getJSON(ctx context.Context, key string, v any) error {
+	reply, err := s.client.do(ctx, "GET", key)
+	if err != nil || reply == nil {
+		return err
+	}
+	data, ok := reply.(string)
+	if !ok {
+		return fmt.Errorf("redis: unexpected reply %T for %s", reply, key)
+	}
+	return json.Unmarshal([]byte(data), v)
+}
@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// accountSID is the account the simulated calls claim to belong to.
+const accountSID = "AC00000000000000000000000000000000"
+
+// maxReplyGap is the longest pause in the agent's audio still counted as
+// part of one reply; frames of silence within a reply are underruns.
+const maxReplyGap = 500 * time.Millisecond
+
+// simulatedCall is the caller's side of a Media Stream.
+type simulatedCall struct {
+	ws        *websocket.Conn
+	callSID   string
+	streamSID string
+
+	writeMu  sync.Mutex
+	sequence int
+
+	// Agent audio waiting to be played, and marks to echo once playback
+	// passes their position in it.
+	mu       sync.Mutex
+	playback []byte
+	marks    []pendingMark
+	received int
+
+	// awaiting is when the caller finished the utterance the agent hasn't
+	// answered yet, or zero.
+	awaiting   time.Time
+	latencies  []time.Duration
+	unanswered int
+
+	// replying is whether the agent is mid-reply; dry counts the frames
+	// since its audio last ran out.
+	replying    bool
+	dry         int
+	underruns   int
+	agentFrames int
+	lateFrames  int
+}
+
+type pendingMark struct {
+	name string
+	at   int // bytes of playback before the mark
+}
+
+// mediaMessage is a Media Streams message, in either direction.
+type mediaMessage struct {
+	Event          string        `json:"event"`
+	SequenceNumber string        `json:"sequenceNumber,omitempty"`
+	StreamSID      string        `json:"streamSid,omitempty"`
+	Protocol       string        `json:"protocol,omitempty"`
+	Version        string        `json:"version,omitempty"`
+	Start          *startPayload `json:"start,omitempty"`
+	Media          *mediaPayload `json:"media,omitempty"`
+	Mark           *markPayload  `json:"mark,omitempty"`
+	Stop           *stopPayload  `json:"stop,omitempty"`
+}
+
+type startPayload struct {
+	StreamSID        string            `json:"streamSid"`
+	AccountSID       string            `json:"accountSid"`
+	CallSID          string            `json:"callSid"`
+	Tracks           []string          `json:"tracks"`
+	CustomParameters map[string]string `json:"customParameters"`
+	MediaFormat      struct {
+		Encoding   string `json:"encoding"`
+		SampleRate int    `json:"sampleRate"`
+		Channels   int    `json:"channels"`
+	} `json:"mediaFormat"`
+}
+
+type mediaPayload struct {
+	Track     string `json:"track,omitempty"`
+	Chunk     string `json:"chunk,omitempty"`
+	Timestamp string `json:"timestamp,omitempty"`
+	Payload   string `json:"payload"`
+}
+
+type markPayload struct {
+	Name string `json:"name"`
+}
+
+type stopPayload struct {
+	AccountSID string `json:"accountSid"`
+	CallSID    string `json:"callSid"`
+}
+
+// send writes a message, numbering it.
+func (c *simulatedCall) send(m mediaMessage) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if m.Event != "connected" {
+		c.sequence++
+		m.SequenceNumber = strconv.Itoa(c.sequence)
+		m.StreamSID = c.streamSID
+	}
+	_ = c.ws.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return c.ws.WriteJSON(m)
+}
+
+// start sends the "connected" and "start" messages.
+func (c *simulatedCall) start(params map[string]string) error {
+	if err := c.send(mediaMessage{Event: "connected", Protocol: "Call", Version: "1.0.0"}); err != nil {
+		return err
+	}
+	start := &startPayload{
+		StreamSID:        c.streamSID,
+		AccountSID:       accountSID,
+		CallSID:          c.callSID,
+		Tracks:           []string{"inbound"},
+		CustomParameters: params,
+	}
+	start.MediaFormat.Encoding = "audio/x-mulaw"
+	start.MediaFormat.SampleRate = 8000
+	start.MediaFormat.Channels = 1
+	return c.send(mediaMessage{Event: "start", Start: start})
+}
+
+// stop sends the "stop" message and closes the stream, as Twilio does
+// when the caller hangs up.
+func (c *simulatedCall) stop() error {
+	err := c.send(mediaMessage{Event: "stop", Stop: &stopPayload{AccountSID: accountSID, CallSID: c.callSID}})
+	c.writeMu.Lock()
+	_ = c.ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(writeTimeout))
+	c.writeMu.Unlock()
+	return err
+}
+
+// play streams the caller's utterances in 20ms frames, gap apart, then
+// silence for tail, then hangs up, returning what the caller heard of the
+// agent. It returns early, with endedByServer set, if the server ends the
+// call, and hangs up early if ctx is cancelled.
+func (c *simulatedCall) play(ctx context.Context, utterances [][]byte, gap, tail time.Duration) (heard []byte, endedByServer bool, err error) {
+	readErr := make(chan error, 1)
+	go func() { readErr <- c.read() }()
+
+	// Lay out the call: each utterance, then silence
+	silence := bytes.Repeat([]byte{mulawSilence}, frameSize)
+	var frames [][]byte
+	ends := make(map[int]bool) // frames that end an utterance
+	for i, u := range utterances {
+		for start := 0; start < len(u); start += frameSize {
+			frames = append(frames, u[start:min(start+frameSize, len(u))])
+		}
+		ends[len(frames)-1] = true
+		pause := gap
+		if i == len(utterances)-1 {
+			pause = tail
+		}
+		for range int(pause / frameInterval) {
+			frames = append(frames, silence)
+		}
+	}
+
+	begin := time.Now()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	for n, frame := range frames {
+		// Frames go out on a fixed schedule; one due more than a frame ago
+		// is late, as a congested caller's would be
+		due := begin.Add(time.Duration(n) * frameInterval)
+		if wait := time.Until(due); wait > 0 {
+			timer.Reset(wait)
+			select {
+			case err := <-readErr:
+				c.finishTurn()
+				if err != nil && !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					return heard, true, err
+				}
+				return heard, true, nil
+			case <-ctx.Done():
+				c.finishTurn()
+				return heard, false, c.stop()
+			case <-timer.C:
+			}
+		} else if -wait > frameInterval {
+			c.mu.Lock()
+			c.lateFrames++
+			c.mu.Unlock()
+		}
+
+		media := &mediaPayload{
+			Track:     "inbound",
+			Chunk:     strconv.Itoa(n + 1),
+			Timestamp: strconv.Itoa(n * int(frameInterval/time.Millisecond)),
+			Payload:   base64.StdEncoding.EncodeToString(frame),
+		}
+		if err := c.send(mediaMessage{Event: "media", Media: media}); err != nil {
+			return heard, false, err
+		}
+		if ends[n] {
+			c.endUtterance()
+		}
+		played, marks := c.playFrame()
+		heard = append(heard, played...)
+		for _, name := range marks {
+			if err := c.send(mediaMessage{Event: "mark", Mark: &markPayload{Name: name}}); err != nil {
+				return heard, false, err
+			}
+		}
+	}
+	c.finishTurn()
+	return heard, false, c.stop()
+}
+
+// endUtterance starts timing the agent's reply, counting the previous
+// utterance unanswered if the agent never replied to it.
+func (c *simulatedCall) endUtterance() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.awaiting.IsZero() {
+		c.unanswered++
+	}
+	c.awaiting = time.Now()
+}
+
+// finishTurn counts a last utterance still awaiting a reply as unanswered.
+func (c *simulatedCall) finishTurn() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.awaiting.IsZero() {
+		c.unanswered++
+		c.awaiting = time.Time{}
+	}
+}
+
+// read handles the agent's messages until the stream closes.
+func (c *simulatedCall) read() error {
+	for {
+		var m mediaMessage
+		if err := c.ws.ReadJSON(&m); err != nil {
+			return err
+		}
+		c.mu.Lock()
+		switch m.Event {
+		case "media":
+			if m.Media != nil {
+				data, err := base64.StdEncoding.DecodeString(m.Media.Payload)
+				if err != nil {
+					c.mu.Unlock()
+					return fmt.Errorf("invalid media payload: %w", err)
+				}
+				if !c.awaiting.IsZero() {
+					c.latencies = append(c.latencies, time.Since(c.awaiting))
+					c.awaiting = time.Time{}
+				}
+				c.playback = append(c.playback, data...)
+				c.received += len(data)
+			}
+		case "mark":
+			if m.Mark != nil {
+				c.marks = append(c.marks, pendingMark{name: m.Mark.Name, at: len(c.playback)})
+			}
+		case "clear":
+			// Unplayed audio is dropped; its marks are echoed at once
+			c.playback = c.playback[:0]
+			for i := range c.marks {
+				c.marks[i].at = 0
+			}
+			c.replying, c.dry = false, 0
+		}
+		c.mu.Unlock()
+	}
+}
+
+// playFrame plays 20ms of the agent's audio, silence if there is none, and
+// returns it with the names of the marks playback has reached.
+func (c *simulatedCall) playFrame() ([]byte, []string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	frame := bytes.Repeat([]byte{mulawSilence}, frameSize)
+	n := copy(frame, c.playback)
+	c.playback = c.playback[n:]
+
+	// Silence between bursts of one reply is audio that arrived too late
+	// to play
+	switch {
+	case n > 0:
+		if c.replying {
+			c.underruns += c.dry
+		}
+		c.replying, c.dry = true, 0
+		c.agentFrames++
+	case c.replying:
+		c.dry++
+		if time.Duration(c.dry)*frameInterval >= maxReplyGap {
+			c.replying, c.dry = false, 0
+		}
+	}
+
+	var reached []string
+	kept := c.marks[:0]
+	for _, m := range c.marks {
+		if m.at <= n {
+			reached = append(reached, m.name)
+			continue
+		}
+		m.at -= n
+		kept = append(kept, m)
+	}
+	c.marks = kept
+	return frame, reached
+}
+
+// callStats are a call's measurements.
+type callStats struct {
+	// AgentBytes is how much audio the agent sent.
+	AgentBytes int
+	// Latencies are the times from the end of each answered utterance to
+	// the first audio of the agent's reply.
+	Latencies  []time.Duration
+	Unanswered int
+	// AgentFrames counts frames of the agent's audio played; Underruns
+	// counts frames of silence within a reply, where its audio arrived
+	// too late. LateFrames counts caller frames sent more than a frame
+	// late.
+	AgentFrames int
+	Underruns   int
+	LateFrames  int
+}
+
+// stats returns the call's measurements so far.
+func (c *simulatedCall) stats() callStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return callStats{
+		AgentBytes:  c.received,
+		Latencies:   append([]time.Duration(nil), c.latencies...),
+		Unanswered:  c.unanswered,
+		AgentFrames: c.agentFrames,
+		Underruns:   c.underruns,
+		LateFrames:  c.lateFrames,
+	}
+}
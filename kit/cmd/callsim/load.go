@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"sync"
+	"time"
+)
+
+// progressInterval is how often a load test reports progress.
+const progressInterval = 10 * time.Second
+
+// loadStats accumulates a load test's results across callers.
+type loadStats struct {
+	mu         sync.Mutex
+	active     int
+	completed  int
+	failed     int
+	errors     map[string]int
+	latencies  []time.Duration
+	utterances int
+	unanswered int
+
+	agentFrames int
+	underruns   int
+	lateFrames  int
+}
+
+func (s *loadStats) begin() {
+	s.mu.Lock()
+	s.active++
+	s.mu.Unlock()
+}
+
+// end records a finished call. result is nil if it never connected.
+func (s *loadStats) end(result *callResult, utterances int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active--
+	if err != nil {
+		s.failed++
+		s.errors[err.Error()]++
+	} else {
+		s.completed++
+	}
+	if result == nil {
+		return
+	}
+	s.latencies = append(s.latencies, result.stats.Latencies...)
+	s.utterances += utterances
+	s.unanswered += result.stats.Unanswered
+	s.agentFrames += result.stats.AgentFrames
+	s.underruns += result.stats.Underruns
+	s.lateFrames += result.stats.LateFrames
+}
+
+func (s *loadStats) progress() (active, completed, failed int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.active, s.completed, s.failed
+}
+
+// runLoad runs opts.calls simulated callers at once, started over
+// opts.ramp, each redialling until opts.duration has passed, then reports
+// what they measured alongside the server's own stats.
+func runLoad(ctx context.Context, sc *scenario, opts options) error {
+	stats := &loadStats{errors: make(map[string]int)}
+	begin := time.Now()
+	deadline := begin.Add(opts.duration)
+	fmt.Printf("load test: %d caller(s) against %s, ramping over %s", opts.calls, sc.streamURL, opts.ramp)
+	if opts.duration > 0 {
+		fmt.Printf(", for %s", opts.duration)
+	}
+	fmt.Println()
+
+	var wg sync.WaitGroup
+	for i := range opts.calls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// Callers start evenly over the ramp
+			if opts.calls > 1 {
+				delay := opts.ramp * time.Duration(i) / time.Duration(opts.calls-1)
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(delay):
+				}
+			}
+			for {
+				stats.begin()
+				result, err := sc.placeCall(ctx, newCallSID())
+				if ctx.Err() != nil {
+					// Interrupted calls are neither completed nor failed
+					stats.mu.Lock()
+					stats.active--
+					stats.mu.Unlock()
+					return
+				}
+				stats.end(result, len(sc.utterances), err)
+				if opts.duration <= 0 || time.Now().After(deadline) {
+					return
+				}
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() { wg.Wait(); close(done) }()
+	ticker := time.NewTicker(progressInterval)
+	defer ticker.Stop()
+wait:
+	for {
+		select {
+		case <-done:
+			break wait
+		case <-ctx.Done():
+			fmt.Println("interrupted, hanging up")
+			<-done
+			break wait
+		case <-ticker.C:
+			active, completed, failed := stats.progress()
+			fmt.Printf("%s: %d active, %d completed, %d failed\n", time.Since(begin).Round(time.Second), active, completed, failed)
+		}
+	}
+
+	stats.report(time.Since(begin))
+	reportServerStats(sc.adminURL)
+	if stats.failed > 0 {
+		return fmt.Errorf("%d call(s) failed", stats.failed)
+	}
+	return nil
+}
+
+// report prints what the callers measured.
+func (s *loadStats) report(elapsed time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	fmt.Printf("\n%d call(s) in %s: %d completed, %d failed\n", s.completed+s.failed, elapsed.Round(time.Second), s.completed, s.failed)
+
+	messages := make([]string, 0, len(s.errors))
+	for msg := range s.errors {
+		messages = append(messages, msg)
+	}
+	sort.Slice(messages, func(i, j int) bool { return s.errors[messages[i]] > s.errors[messages[j]] })
+	for _, msg := range messages {
+		fmt.Printf("  %dx %s\n", s.errors[msg], msg)
+	}
+
+	fmt.Printf("reply latency over %d of %d utterance(s), %d unanswered:\n", len(s.latencies), s.utterances, s.unanswered)
+	if len(s.latencies) > 0 {
+		sorted := slices.Clone(s.latencies)
+		slices.Sort(sorted)
+		fmt.Printf("  p50 %s  p90 %s  p95 %s  p99 %s  max %s\n",
+			percentile(sorted, 0.50), percentile(sorted, 0.90), percentile(sorted, 0.95), percentile(sorted, 0.99), sorted[len(sorted)-1].Round(time.Millisecond))
+	}
+
+	fmt.Printf("agent audio: %d frame(s) played, %d underrun(s)", s.agentFrames, s.underruns)
+	if s.agentFrames > 0 {
+		fmt.Printf(" (%.2f%%)", 100*float64(s.underruns)/float64(s.agentFrames+s.underruns))
+	}
+	fmt.Println()
+	fmt.Printf("caller audio: %d frame(s) sent late\n", s.lateFrames)
+}
+
+// percentile returns the nearest-rank percentile of sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := min(int(p*float64(len(sorted))), len(sorted)-1)
+	return sorted[i].Round(time.Millisecond)
+}
+
+// reportServerStats prints the server's per-stage latency percentiles and
+// objectives, if it serves them.
+func reportServerStats(adminURL string) {
+	var latency struct {
+		Turns  int `json:"turns"`
+		Stages map[string]struct {
+			Count int     `json:"count"`
+			P50   float64 `json:"p50_ms"`
+			P90   float64 `json:"p90_ms"`
+			P99   float64 `json:"p99_ms"`
+		} `json:"stages"`
+	}
+	if err := getJSON(adminURL+"/stats/latency", &latency); err != nil {
+		fmt.Printf("server latency stats unavailable: %v\n", err)
+	} else if len(latency.Stages) > 0 {
+		fmt.Printf("server turn latency over %d turn(s):\n", latency.Turns)
+		stages := make([]string, 0, len(latency.Stages))
+		for stage := range latency.Stages {
+			stages = append(stages, stage)
+		}
+		sort.Strings(stages)
+		for _, stage := range stages {
+			p := latency.Stages[stage]
+			fmt.Printf("  %-10s p50 %6.0fms  p90 %6.0fms  p99 %6.0fms\n", stage, p.P50, p.P90, p.P99)
+		}
+	}
+
+	var slo struct {
+		Objectives []struct {
+			Name     string   `json:"name"`
+			Window   string   `json:"window"`
+			Samples  int      `json:"samples"`
+			Value    *float64 `json:"value"`
+			Unit     string   `json:"unit"`
+			Breached bool     `json:"breached"`
+		} `json:"objectives"`
+	}
+	// The server may run without objectives
+	if err := getJSON(adminURL+"/stats/slo", &slo); err != nil || len(slo.Objectives) == 0 {
+		return
+	}
+	fmt.Println("server objectives:")
+	for _, o := range slo.Objectives {
+		value := "too few samples"
+		switch {
+		case o.Value == nil:
+		case o.Unit == "ratio":
+			value = fmt.Sprintf("%.2f%%", 100*(*o.Value))
+		default:
+			value = fmt.Sprintf("%.0fms", *o.Value)
+		}
+		state := "met"
+		if o.Breached {
+			state = "BREACHED"
+		}
+		fmt.Printf("  %-24s %s over %s (%d sample(s)): %s\n", o.Name, value, o.Window, o.Samples, state)
+	}
+}
+
+// getJSON fetches and decodes a JSON document.
+func getJSON(url string, v any) error {
+	client := &http.Client{Timeout: writeTimeout}
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,390 @@
+// Command callsim is a fake caller for end-to-end and load tests. It
+// connects to a voice agent's /media-stream endpoint speaking the Twilio
+// Media Streams protocol, plays WAV files as the caller's utterances,
+// records what the agent says to a WAV file, and checks the call's
+// transcript against expected patterns, so changes can be tested without
+// placing a real call.
+//
+// It behaves like Twilio playing the call to a listener: the agent's audio
+// is played out at real time, marks are echoed back once playback reaches
+// them, and a clear drops whatever hasn't been played yet. The recording
+// is what the caller heard, time-aligned with the call.
+//
+// Each -wav is one utterance, played in the order given with -gap of
+// silence after each for the agent to reply, and -tail after the last.
+// The time from the end of each utterance to the first audio of the reply
+// is measured, as are underruns: frames of silence within a reply, where
+// the agent's audio arrived too late to play.
+//
+// The transcript is read from the agent's admin API, so -admin-token must
+// match the server's ADMIN_TOKEN for -expect to be checked. Each -expect
+// is a regular expression that must match a line of the transcript,
+// formatted "speaker: text", in the order given. With the server running
+// with OFFLINE=1 no API keys are needed at all (the mock STT hears its
+// script, not the WAV).
+//
+// With -calls or -duration, callsim is a load generator instead: -calls
+// simulated callers start over -ramp, each placing the same call, and
+// redialling until -duration has passed if it is set. It reports the
+// reply latency distribution, underruns, late caller frames and failed
+// calls across all of them, with the server's own latency percentiles and
+// objectives from /stats/latency and /stats/slo. Calls in a load test
+// have random call SIDs, and -out is not written.
+//
+// The stream's custom parameters are those the examples' TwiML passes:
+// callSid, caller, called and, with -auth-token, streamToken. The same
+// token signs the handshake, for servers validating Twilio signatures.
+//
+// Usage:
+//
+//	go run ./cmd/callsim -wav caller.wav [-wav more.wav ...] [-url ws://localhost:8080/media-stream]
+//		[-out agent.wav] [-gap 3s] [-tail 5s] [-from +15555550100] [-to +15555550199]
+//		[-admin-token TOKEN] [-expect 'agent: (?i)hello'] [-expect 'caller: .*order']
+//		[-auth-token TOKEN] [-call-sid CA...] [-param name=value]
+//		[-calls 50 -ramp 1m -duration 10m]
+//
+// It exits 1 if a call fails or an expectation isn't met.
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/audio"
+	"github.com/agentplexus/omnivoice-examples/kit/twilioauth"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// frameSize is 20ms of 8kHz mu-law, the frame Twilio sends.
+	frameSize     = 160
+	frameInterval = 20 * time.Millisecond
+	// mulawSilence is a mu-law sample of silence.
+	mulawSilence = 0xFF
+
+	// sessionLookupTimeout bounds the wait for the server to list the call.
+	sessionLookupTimeout = 5 * time.Second
+	// transcriptTimeout bounds the wait for the transcript once the caller
+	// has hung up.
+	transcriptTimeout = 10 * time.Second
+	writeTimeout      = 5 * time.Second
+)
+
+// stringList is a repeatable flag.
+type stringList []string
+
+func (l *stringList) String() string     { return strings.Join(*l, ", ") }
+func (l *stringList) Set(v string) error { *l = append(*l, v); return nil }
+
+// options are the command-line flags.
+type options struct {
+	streamURL  string
+	wavPaths   stringList
+	outPath    string
+	gap, tail  time.Duration
+	callSID    string
+	from, to   string
+	authToken  string
+	adminURL   string
+	adminToken string
+	params     stringList
+	expects    stringList
+
+	calls    int
+	ramp     time.Duration
+	duration time.Duration
+}
+
+func main() {
+	var opts options
+	flag.StringVar(&opts.streamURL, "url", "ws://localhost:8080/media-stream", "the agent's Media Streams endpoint")
+	flag.Var(&opts.wavPaths, "wav", "a caller utterance: a WAV file of 16-bit PCM, mu-law or A-law, resampled to 8kHz (repeatable, played in order)")
+	flag.StringVar(&opts.outPath, "out", "", "write the agent's audio, as the caller heard it, to this WAV file")
+	flag.DurationVar(&opts.gap, "gap", 3*time.Second, "silence after each utterance but the last, for the agent to reply")
+	flag.DurationVar(&opts.tail, "tail", 5*time.Second, "how long to stay on the line once the last utterance ends")
+	flag.StringVar(&opts.callSID, "call-sid", "", "call SID to present (default random)")
+	flag.StringVar(&opts.from, "from", "+15555550100", "caller's number")
+	flag.StringVar(&opts.to, "to", "", "number called")
+	flag.StringVar(&opts.authToken, "auth-token", "", "Twilio auth token, to sign the handshake and pass a stream token")
+	flag.StringVar(&opts.adminURL, "admin-url", "", "the server's base URL for the admin API and stats (default: from -url)")
+	flag.StringVar(&opts.adminToken, "admin-token", "", "admin API token, to read the call's transcript")
+	flag.Var(&opts.params, "param", "extra custom parameter as name=value (repeatable)")
+	flag.Var(&opts.expects, "expect", `regular expression a transcript line ("speaker: text") must match, in order (repeatable)`)
+	flag.IntVar(&opts.calls, "calls", 1, "load test: how many simulated callers to run at once")
+	flag.DurationVar(&opts.ramp, "ramp", 0, "load test: how long to take starting the callers")
+	flag.DurationVar(&opts.duration, "duration", 0, "load test: keep redialling until this long after the first call (default: one call each)")
+	flag.Parse()
+
+	if len(opts.wavPaths) == 0 {
+		fmt.Fprintln(os.Stderr, "callsim: -wav is required")
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// Ctrl-C ends a load test early, still reporting
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := run(ctx, opts); err != nil {
+		fmt.Fprintln(os.Stderr, "callsim:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, opts options) error {
+	sc, err := newScenario(opts)
+	if err != nil {
+		return err
+	}
+	if opts.calls > 1 || opts.duration > 0 {
+		if opts.outPath != "" || opts.callSID != "" {
+			return errors.New("-out and -call-sid apply to a single call, not a load test")
+		}
+		return runLoad(ctx, sc, opts)
+	}
+	return runCall(ctx, sc, opts)
+}
+
+// runCall places one call, reporting as it goes.
+func runCall(ctx context.Context, sc *scenario, opts options) error {
+	callSID := opts.callSID
+	if callSID == "" {
+		callSID = newCallSID()
+	}
+	fmt.Printf("calling %s as %s\n", sc.streamURL, callSID)
+	result, err := sc.placeCall(ctx, callSID)
+	if result == nil {
+		return err
+	}
+	if result.endedByServer {
+		fmt.Println("server ended the call")
+	}
+	fmt.Printf("call ended: caller spoke %s, agent %s\n", audioDuration(sc.callerBytes()), audioDuration(result.stats.AgentBytes))
+	for i, d := range result.stats.Latencies {
+		fmt.Printf("  reply %d after %s\n", i+1, d.Round(time.Millisecond))
+	}
+	if result.stats.Unanswered > 0 {
+		fmt.Printf("  %d utterance(s) unanswered\n", result.stats.Unanswered)
+	}
+	if result.stats.Underruns > 0 {
+		fmt.Printf("  %d frame(s) of the agent's audio arrived too late to play\n", result.stats.Underruns)
+	}
+
+	if opts.outPath != "" {
+		if err := writeRecording(opts.outPath, result.heard); err != nil {
+			return err
+		}
+		fmt.Printf("agent audio written to %s\n", opts.outPath)
+	}
+	if result.transcript != nil {
+		fmt.Println("transcript:")
+		for _, line := range result.transcript {
+			fmt.Println("  " + line)
+		}
+	}
+	return err
+}
+
+// scenario is what every simulated call does.
+type scenario struct {
+	streamURL  *url.URL
+	utterances [][]byte
+	gap, tail  time.Duration
+	from, to   string
+	authToken  string
+	params     map[string]string
+	// adminURL is the server's HTTP base URL; adminToken, if set, reads
+	// each call's transcript, for expects.
+	adminURL   string
+	adminToken string
+	expects    []*regexp.Regexp
+}
+
+// newScenario checks the options and loads the caller's audio.
+func newScenario(opts options) (*scenario, error) {
+	sc := &scenario{gap: opts.gap, tail: opts.tail, from: opts.from, to: opts.to, authToken: opts.authToken, adminToken: opts.adminToken}
+	u, err := url.Parse(opts.streamURL)
+	if err != nil || (u.Scheme != "ws" && u.Scheme != "wss") {
+		return nil, fmt.Errorf("invalid -url %q (want ws:// or wss://)", opts.streamURL)
+	}
+	sc.streamURL = u
+	sc.adminURL = opts.adminURL
+	if sc.adminURL == "" {
+		sc.adminURL = adminBaseURL(u)
+	}
+	for _, path := range opts.wavPaths {
+		utterance, err := loadCallerAudio(path)
+		if err != nil {
+			return nil, err
+		}
+		sc.utterances = append(sc.utterances, utterance)
+	}
+	for _, expr := range opts.expects {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -expect %q: %w", expr, err)
+		}
+		sc.expects = append(sc.expects, re)
+	}
+	if len(sc.expects) > 0 && opts.adminToken == "" {
+		return nil, errors.New("-expect needs -admin-token to read the transcript")
+	}
+	sc.params = make(map[string]string)
+	for _, p := range opts.params {
+		name, value, ok := strings.Cut(p, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid -param %q (want name=value)", p)
+		}
+		sc.params[name] = value
+	}
+	if opts.calls < 1 || opts.ramp < 0 || opts.duration < 0 {
+		return nil, errors.New("-calls must be positive, and -ramp and -duration not negative")
+	}
+	return sc, nil
+}
+
+// callerBytes returns how much audio the caller speaks in each call.
+func (sc *scenario) callerBytes() int {
+	n := 0
+	for _, u := range sc.utterances {
+		n += len(u)
+	}
+	return n
+}
+
+// customParameters returns a call's stream custom parameters.
+func (sc *scenario) customParameters(callSID string) map[string]string {
+	params := map[string]string{"callSid": callSID, "caller": sc.from}
+	if sc.to != "" {
+		params["called"] = sc.to
+	}
+	if sc.authToken != "" {
+		params["streamToken"] = twilioauth.StreamToken(sc.authToken, callSID, time.Now().Add(twilioauth.StreamTokenTTL))
+	}
+	for name, value := range sc.params {
+		params[name] = value
+	}
+	return params
+}
+
+// callResult is what a call measured and heard.
+type callResult struct {
+	stats         callStats
+	heard         []byte
+	transcript    []string
+	endedByServer bool
+}
+
+// placeCall places one call and plays it through. The result is nil if the
+// call never connected; otherwise it is returned even when the call fails
+// part way or its transcript doesn't meet the expectations.
+func (sc *scenario) placeCall(ctx context.Context, callSID string) (*callResult, error) {
+	// Twilio signs the handshake URL as it requested it, over TLS
+	header := http.Header{}
+	if sc.authToken != "" {
+		header.Set(twilioauth.SignatureHeader, twilioauth.Signature(sc.authToken, "wss://"+sc.streamURL.Host+sc.streamURL.RequestURI(), nil))
+	}
+	ws, resp, err := websocket.DefaultDialer.DialContext(ctx, sc.streamURL.String(), header)
+	if err != nil {
+		if resp != nil {
+			return nil, fmt.Errorf("connecting to %s: %w (HTTP %s)", sc.streamURL, err, resp.Status)
+		}
+		return nil, fmt.Errorf("connecting to %s: %w", sc.streamURL, err)
+	}
+	defer ws.Close()
+
+	call := &simulatedCall{ws: ws, callSID: callSID, streamSID: "MZ" + randomHex(16)}
+	if err := call.start(sc.customParameters(callSID)); err != nil {
+		return nil, err
+	}
+
+	// Follow the transcript from the admin API while the call is up
+	var transcript *transcriptReader
+	if sc.adminToken != "" {
+		transcript, err = watchTranscript(ctx, sc.adminURL, sc.adminToken, callSID)
+		if err != nil {
+			_ = call.stop()
+			return nil, err
+		}
+	}
+
+	result := &callResult{}
+	result.heard, result.endedByServer, err = call.play(ctx, sc.utterances, sc.gap, sc.tail)
+	result.stats = call.stats()
+	if err != nil {
+		return result, err
+	}
+	if transcript == nil {
+		return result, nil
+	}
+	result.transcript = transcript.wait(transcriptTimeout)
+	return result, checkTranscript(result.transcript, sc.expects)
+}
+
+// loadCallerAudio reads a WAV file as 8kHz mu-law.
+func loadCallerAudio(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	samples, rate, err := audio.ReadWAV(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if rate != 8000 {
+		if samples, err = audio.Resample(samples, rate, 8000, audio.QualitySinc); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	return audio.MulawEncode(samples), nil
+}
+
+// adminBaseURL returns the HTTP base URL of the server at a stream URL.
+func adminBaseURL(stream *url.URL) string {
+	scheme := "http"
+	if stream.Scheme == "wss" {
+		scheme = "https"
+	}
+	return scheme + "://" + stream.Host
+}
+
+// audioDuration returns the length of 8kHz mu-law audio.
+func audioDuration(n int) time.Duration {
+	return (time.Duration(n) * time.Second / 8000).Round(10 * time.Millisecond)
+}
+
+// writeRecording writes what the caller heard as a 16-bit PCM WAV file.
+func writeRecording(path string, heard []byte) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := audio.WriteWAV(f, audio.MulawDecode(heard), 8000); err != nil {
+		_ = f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// newCallSID returns a random call SID.
+func newCallSID() string {
+	return "CA" + randomHex(16)
+}
+
+// randomHex returns n random bytes in hex, for SIDs.
+func randomHex(n int) string {
+	b := make([]byte, n)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
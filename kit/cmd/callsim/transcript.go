@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// transcriptReader collects a call's transcript from the admin API's
+// monitor stream.
+type transcriptReader struct {
+	mu    sync.Mutex
+	lines []string
+	done  chan struct{}
+}
+
+// monitorEvent is the part of a monitor event callsim reads.
+type monitorEvent struct {
+	Kind    string `json:"kind"`
+	Speaker string `json:"speaker"`
+	Text    string `json:"text"`
+	Target  string `json:"target"`
+}
+
+// watchTranscript finds the call among the server's sessions and follows
+// its transcript.
+func watchTranscript(ctx context.Context, adminURL, token, callSID string) (*transcriptReader, error) {
+	sessionID, err := findSession(ctx, adminURL, token, callSID)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(adminURL + "/admin/sessions/" + url.PathEscape(sessionID) + "/monitor")
+	if err != nil {
+		return nil, err
+	}
+	u.Scheme = strings.Replace(u.Scheme, "http", "ws", 1)
+	ws, _, err := websocket.DefaultDialer.DialContext(ctx, u.String(), http.Header{"Authorization": {"Bearer " + token}})
+	if err != nil {
+		return nil, fmt.Errorf("connecting to the call monitor: %w", err)
+	}
+
+	t := &transcriptReader{done: make(chan struct{})}
+	go func() {
+		defer close(t.done)
+		defer ws.Close()
+		for {
+			var event monitorEvent
+			if err := ws.ReadJSON(&event); err != nil {
+				return
+			}
+			switch event.Kind {
+			case "transcript":
+				line := event.Speaker + ": " + event.Text
+				if event.Target != "" {
+					line = event.Speaker + " (to " + event.Target + "): " + event.Text
+				}
+				t.mu.Lock()
+				t.lines = append(t.lines, line)
+				t.mu.Unlock()
+			case "end":
+				return
+			}
+		}
+	}()
+	return t, nil
+}
+
+// findSession returns the ID of the session serving callSID, waiting for
+// the server to start it.
+func findSession(ctx context.Context, adminURL, token, callSID string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, sessionLookupTimeout)
+	defer cancel()
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, adminURL+"/admin/sessions", nil)
+		if err != nil {
+			return "", err
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return "", fmt.Errorf("listing sessions: %w", err)
+		}
+		var list struct {
+			Sessions []struct {
+				ID      string `json:"id"`
+				CallSID string `json:"call_sid"`
+			} `json:"sessions"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&list)
+		_ = resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("listing sessions: HTTP %s", resp.Status)
+		}
+		if err != nil {
+			return "", fmt.Errorf("listing sessions: %w", err)
+		}
+		for _, s := range list.Sessions {
+			if s.CallSID == callSID {
+				return s.ID, nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return "", fmt.Errorf("call %s not among the server's sessions", callSID)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// wait returns the transcript once the call has ended on the server, or
+// after timeout.
+func (t *transcriptReader) wait(timeout time.Duration) []string {
+	select {
+	case <-t.done:
+	case <-time.After(timeout):
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string(nil), t.lines...)
+}
+
+// checkTranscript reports the expectations not met, in order, by lines.
+func checkTranscript(lines []string, expects []*regexp.Regexp) error {
+	i := 0
+	for _, line := range lines {
+		if i < len(expects) && expects[i].MatchString(line) {
+			i++
+		}
+	}
+	if i == len(expects) {
+		return nil
+	}
+	var missing []string
+	for _, re := range expects[i:] {
+		missing = append(missing, re.String())
+	}
+	return fmt.Errorf("transcript did not match, in order: %s", strings.Join(missing, ", "))
+}
@@ -0,0 +1,279 @@
+// Command replay re-runs stored call transcripts against the current agent
+// configuration and diffs the agent's replies with what it said on the
+// call, so a prompt or model change can be checked against real
+// conversations before it reaches callers.
+//
+// Each of the caller's turns is put to the agent in the context of the
+// call as it really went: the agent is resumed with the recorded
+// conversation up to that turn, so one changed reply doesn't send the
+// rest of the replay down a different path. Agents that don't keep a
+// history (see agent.Resumer) hear the caller's turns in order instead.
+//
+// Transcripts are JSON files holding an array of lines, {"speaker",
+// "text"}, as the admin API's /admin/sessions/{id} returns under
+// "transcript" (the whole response can be given as it is), or are read
+// from the Redis call state with -redis and -call while it hasn't expired.
+// A directory argument replays every .json file in it.
+//
+// The agent is built as the voice agent builds it: from CONFIG_FILE (or
+// -config) and the environment, e.g. LLM_PROVIDER, LLM_MODEL,
+// LLM_SYSTEM_PROMPT and the provider's API key, for the number -tenant
+// if given. -system-file tries a prompt without editing the
+// configuration. With no LLM provider configured the echo agent answers.
+//
+// Replies are compared word by word; a turn whose replayed reply is less
+// similar than -threshold to the recorded one is reported as changed,
+// with both replies. -v shows every turn.
+//
+// Usage:
+//
+//	go run ./cmd/replay [-config config.yaml] [-tenant +15555550199] [-system-file prompt.txt]
+//		[-threshold 0.6] [-parallel 4] [-v] transcript.json [dir ...]
+//	go run ./cmd/replay -redis redis://localhost:6379/0 -call CA...
+//
+// It exits 1 if any turn changed or a transcript couldn't be replayed.
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/agent"
+	"github.com/agentplexus/omnivoice-examples/kit/config"
+	"github.com/agentplexus/omnivoice-examples/kit/llm"
+)
+
+// options are the command-line flags.
+type options struct {
+	configPath string
+	tenant     string
+	systemFile string
+	threshold  float64
+	parallel   int
+	timeout    time.Duration
+	verbose    bool
+	redisURL   string
+	callSID    string
+}
+
+func main() {
+	var opts options
+	flag.StringVar(&opts.configPath, "config", os.Getenv("CONFIG_FILE"), "configuration file, as the voice agent's CONFIG_FILE")
+	flag.StringVar(&opts.tenant, "tenant", "", "replay against this number's tenant agent, as written in the configuration")
+	flag.StringVar(&opts.systemFile, "system-file", "", "use this file's contents as the system prompt")
+	flag.Float64Var(&opts.threshold, "threshold", 0.6, "word similarity (0-1) below which a reply counts as changed")
+	flag.IntVar(&opts.parallel, "parallel", 4, "how many transcripts to replay at once")
+	flag.DurationVar(&opts.timeout, "timeout", time.Minute, "how long each reply may take")
+	flag.BoolVar(&opts.verbose, "v", false, "show every turn, not only changed ones")
+	flag.StringVar(&opts.redisURL, "redis", os.Getenv("REDIS_URL"), "Redis URL of the call state, for -call")
+	flag.StringVar(&opts.callSID, "call", "", "replay this call's transcript from the Redis call state")
+	flag.Parse()
+
+	if flag.NArg() == 0 && opts.callSID == "" {
+		fmt.Fprintln(os.Stderr, "replay: give transcript files or directories, or -call")
+		flag.Usage()
+		os.Exit(2)
+	}
+	if opts.threshold < 0 || opts.threshold > 1 || opts.parallel < 1 {
+		fmt.Fprintln(os.Stderr, "replay: -threshold must be 0-1 and -parallel at least 1")
+		os.Exit(2)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := run(ctx, opts, flag.Args()); err != nil {
+		fmt.Fprintln(os.Stderr, "replay:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, opts options, paths []string) error {
+	brain, err := newAgent(opts)
+	if err != nil {
+		return err
+	}
+	transcripts, err := loadTranscripts(ctx, paths, opts)
+	if err != nil {
+		return err
+	}
+
+	// Transcripts replay concurrently, each in its own session, and are
+	// reported in the order given
+	reports := make([]*bytes.Buffer, len(transcripts))
+	results := make([]replayResult, len(transcripts))
+	sem := make(chan struct{}, opts.parallel)
+	var wg sync.WaitGroup
+	for i, t := range transcripts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			reports[i] = &bytes.Buffer{}
+			results[i] = replay(ctx, brain, t, fmt.Sprintf("replay-%d", i+1), opts, reports[i])
+		}()
+	}
+	wg.Wait()
+
+	var total replayResult
+	for i, report := range reports {
+		_, _ = report.WriteTo(os.Stdout)
+		total.turns += results[i].turns
+		total.changed += results[i].changed
+		if results[i].err != nil {
+			total.failed++
+		}
+	}
+	fmt.Printf("\n%d transcript(s), %d turn(s): %d changed (similarity below %.2f)", len(transcripts), total.turns, total.changed, opts.threshold)
+	if total.failed > 0 {
+		fmt.Printf(", %d transcript(s) failed", total.failed)
+	}
+	fmt.Println()
+	if total.changed > 0 || total.failed > 0 {
+		return errors.New("replies changed")
+	}
+	return nil
+}
+
+// newAgent builds the agent the voice agent would answer with.
+func newAgent(opts options) (agent.Agent, error) {
+	cfg, err := config.Load(opts.configPath)
+	if err != nil {
+		return nil, err
+	}
+	model, system := cfg.LLM, cfg.Prompts.System
+	if opts.tenant != "" {
+		t, ok := cfg.TenantFor(opts.tenant)
+		if !ok {
+			return nil, fmt.Errorf("no tenant for %s in the configuration", opts.tenant)
+		}
+		model, system = t.LLM, t.Prompts.System
+	}
+	if opts.systemFile != "" {
+		data, err := os.ReadFile(opts.systemFile)
+		if err != nil {
+			return nil, err
+		}
+		system = strings.TrimSpace(string(data))
+	}
+	if system == "" {
+		system = agent.DefaultSystemPrompt
+	}
+
+	if model.Provider == "" {
+		fmt.Println("no LLM provider configured, replaying against the echo agent")
+		return agent.NewEcho(), nil
+	}
+	provider, err := llm.FromEnv(model.Provider, model.Model)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Printf("replaying against %s %s\n", model.Provider, model.Model)
+	// With the voice agent's tools and guardrails, so replies can differ
+	// only by prompt and model
+	brain := agent.NewLLM(provider, system, "", agent.ReadbackTool())
+	if cfg.Features.Guardrails {
+		brain.WithGuardrails(agent.DefaultGuardrails())
+	}
+	return brain, nil
+}
+
+// replayResult counts one transcript's turns.
+type replayResult struct {
+	turns   int
+	changed int
+	failed  int
+	err     error
+}
+
+// replay puts each of the transcript's caller turns to the agent, writing
+// the turns that changed, or all with -v, to w.
+func replay(ctx context.Context, brain agent.Agent, t transcript, sessionID string, opts options, w *bytes.Buffer) replayResult {
+	var result replayResult
+	turns := t.turns()
+	fmt.Fprintf(w, "\n== %s: %d turn(s)\n", t.name, len(turns))
+	if ender, ok := brain.(agent.SessionEnder); ok {
+		defer ender.EndSession(sessionID)
+	}
+	resumer, resumable := brain.(agent.Resumer)
+
+	for i, turn := range turns {
+		if resumable {
+			resumer.Resume(sessionID, turn.history)
+		}
+		reply, actions, err := ask(ctx, brain, agent.Turn{SessionID: sessionID, Index: i + 1, Text: turn.caller}, opts.timeout)
+		if err != nil {
+			fmt.Fprintf(w, "turn %d: %v\n", i+1, err)
+			result.err = err
+			return result
+		}
+		result.turns++
+
+		similarity := wordSimilarity(normalizeWords(turn.agent), normalizeWords(reply))
+		changed := similarity < opts.threshold
+		if changed {
+			result.changed++
+		}
+		if !changed && !opts.verbose {
+			continue
+		}
+		state := "same"
+		if changed {
+			state = "CHANGED"
+		}
+		fmt.Fprintf(w, "turn %d (%s, similarity %.2f)\n", i+1, state, similarity)
+		fmt.Fprintf(w, "  caller:   %s\n", turn.caller)
+		fmt.Fprintf(w, "  - agent:  %s\n", orNothing(turn.agent))
+		fmt.Fprintf(w, "  + agent:  %s\n", orNothing(reply))
+		for _, action := range actions {
+			fmt.Fprintf(w, "  + action: %s %s\n", action.Kind, action.Target)
+		}
+	}
+	if result.changed == 0 {
+		fmt.Fprintln(w, "no replies changed")
+	}
+	return result
+}
+
+// ask puts one turn to the agent and returns its whole reply and any
+// actions it took.
+func ask(ctx context.Context, brain agent.Agent, turn agent.Turn, timeout time.Duration) (string, []agent.Action, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	responses, err := brain.OnUserTurn(ctx, turn)
+	if err != nil {
+		return "", nil, err
+	}
+	var reply []string
+	var actions []agent.Action
+	for r := range responses {
+		if r.Err != nil {
+			return "", nil, r.Err
+		}
+		if r.Text != "" {
+			reply = append(reply, r.Text)
+		}
+		if r.Action != nil {
+			actions = append(actions, *r.Action)
+		}
+	}
+	if err := ctx.Err(); err != nil {
+		return "", nil, err
+	}
+	return strings.Join(reply, " "), actions, nil
+}
+
+func orNothing(s string) string {
+	if s == "" {
+		return "(nothing)"
+	}
+	return s
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/agentplexus/omnivoice-examples/kit/callstate"
+	"github.com/agentplexus/omnivoice-examples/kit/llm"
+)
+
+// Transcript speakers, as the voice agent records them.
+const (
+	speakerCaller = "caller"
+	speakerAgent  = "agent"
+)
+
+// transcript is a stored call's lines.
+type transcript struct {
+	name  string
+	lines []callstate.Line
+}
+
+// replayTurn is one caller turn with what the agent said in reply and the
+// conversation before it.
+type replayTurn struct {
+	caller  string
+	agent   string
+	history []llm.Message
+}
+
+// turns splits the transcript into the caller's turns. Consecutive lines
+// of one speaker are one message, and lines whispered to a single leg
+// aren't part of the conversation.
+func (t transcript) turns() []replayTurn {
+	var messages []llm.Message
+	for _, line := range t.lines {
+		if line.Target != "" || strings.TrimSpace(line.Text) == "" {
+			continue
+		}
+		var role llm.Role
+		switch line.Speaker {
+		case speakerCaller:
+			role = llm.RoleUser
+		case speakerAgent:
+			role = llm.RoleAssistant
+		default:
+			continue
+		}
+		if n := len(messages); n > 0 && messages[n-1].Role == role {
+			messages[n-1].Content += " " + line.Text
+			continue
+		}
+		messages = append(messages, llm.Message{Role: role, Content: line.Text})
+	}
+
+	var turns []replayTurn
+	for i, m := range messages {
+		if m.Role != llm.RoleUser {
+			continue
+		}
+		turn := replayTurn{caller: m.Content, history: messages[:i:i]}
+		if i+1 < len(messages) {
+			turn.agent = messages[i+1].Content
+		}
+		turns = append(turns, turn)
+	}
+	return turns
+}
+
+// loadTranscripts reads the transcripts named by paths, expanding
+// directories, and the call from Redis if opts names one.
+func loadTranscripts(ctx context.Context, paths []string, opts options) ([]transcript, error) {
+	var transcripts []transcript
+	for _, path := range paths {
+		files := []string{path}
+		if info, err := os.Stat(path); err != nil {
+			return nil, err
+		} else if info.IsDir() {
+			if files, err = filepath.Glob(filepath.Join(path, "*.json")); err != nil {
+				return nil, err
+			}
+		}
+		for _, file := range files {
+			lines, err := readTranscript(file)
+			if err != nil {
+				return nil, err
+			}
+			transcripts = append(transcripts, transcript{name: file, lines: lines})
+		}
+	}
+
+	if opts.callSID != "" {
+		if opts.redisURL == "" {
+			return nil, errors.New("-call needs -redis or REDIS_URL")
+		}
+		store, err := callstate.Open(opts.redisURL)
+		if err != nil {
+			return nil, err
+		}
+		defer store.Close()
+		lines, err := store.Transcript(ctx, opts.callSID)
+		if err != nil {
+			return nil, fmt.Errorf("call %s: %w", opts.callSID, err)
+		}
+		if len(lines) == 0 {
+			return nil, fmt.Errorf("call %s: no transcript stored (it may have expired)", opts.callSID)
+		}
+		transcripts = append(transcripts, transcript{name: opts.callSID, lines: lines})
+	}
+	return transcripts, nil
+}
+
+// readTranscript reads a JSON array of lines, or an object with them
+// under "transcript".
+func readTranscript(path string) ([]callstate.Line, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var lines []callstate.Line
+	if err := json.Unmarshal(data, &lines); err != nil {
+		var session struct {
+			Transcript []callstate.Line `json:"transcript"`
+		}
+		if err := json.Unmarshal(data, &session); err != nil {
+			return nil, fmt.Errorf("%s: want an array of {\"speaker\", \"text\"} lines or an object with them under \"transcript\": %w", path, err)
+		}
+		lines = session.Transcript
+	}
+	return lines, nil
+}
+
+// normalizeWords lowercases text and splits it into words, ignoring
+// punctuation.
+func normalizeWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	})
+}
+
+// wordSimilarity is 1 minus the word-level edit distance between a and b
+// over the longer length: 1 for identical replies, 0 for nothing in
+// common.
+func wordSimilarity(a, b []string) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return 1 - float64(prev[len(b)])/float64(max(len(a), len(b)))
+}
@@ -0,0 +1,506 @@
+// Package config loads the settings shared by the OmniVoice examples:
+// provider credentials, voices and models, prompts, timeouts, feature
+// flags, per-number tenants and A/B experiments.
+//
+// Settings come from an optional YAML file, with environment variables
+// taking precedence, so a deployment can keep one file per environment and
+// still override single values (typically secrets) from the environment:
+//
+//	cfg, err := config.Load(os.Getenv("CONFIG_FILE"))
+//
+// Every field names its environment variable in an env tag. Unknown keys
+// in the file are an error, so a misspelled setting doesn't go unnoticed.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the complete configuration of an example. Examples ignore the
+// sections they have no use for.
+type Config struct {
+	Server     Server     `yaml:"server"`
+	Twilio     Twilio     `yaml:"twilio"`
+	Deepgram   Deepgram   `yaml:"deepgram"`
+	ElevenLabs ElevenLabs `yaml:"elevenlabs"`
+	LLM        LLM        `yaml:"llm"`
+	Prompts    Prompts    `yaml:"prompts"`
+	Timeouts   Timeouts   `yaml:"timeouts"`
+	Features   Features   `yaml:"features"`
+	DoubleTalk DoubleTalk `yaml:"double_talk"`
+	Telemetry  Telemetry  `yaml:"telemetry"`
+
+	// Tenants gives calls to particular numbers an agent of their own, so
+	// one server can host several branded agents. It is keyed by the
+	// number called, in E.164, and can only be set in the file.
+	Tenants map[string]Tenant `yaml:"tenants"`
+
+	// Experiments split calls between variants of the agent to compare
+	// them. They can only be set in the file.
+	Experiments []Experiment `yaml:"experiments"`
+
+	// Team answers calls with specialist agents that hand the call to
+	// each other, instead of a single agent. The first specialist answers.
+	// It can only be set in the file.
+	Team []Specialist `yaml:"team"`
+
+	// Emotions are the tags the model may use with features.emotion_tags,
+	// keyed by name, and how each is spoken. Empty uses the example's
+	// own. They can only be set in the file.
+	Emotions map[string]Emotion `yaml:"emotions"`
+
+	// Pronunciations correct how the TTS provider says brand, product and
+	// other names, keyed by the name as written. They can only be set in
+	// the file.
+	Pronunciations map[string]Pronunciation `yaml:"pronunciations"`
+}
+
+// Server configures the HTTP server that answers Twilio.
+type Server struct {
+	// Addr is the address to listen on.
+	Addr string `yaml:"addr" env:"LISTEN_ADDR"`
+	// PublicHost is the host Twilio reaches the server on, when it differs
+	// from the Host header of incoming webhooks.
+	PublicHost string `yaml:"public_host" env:"PUBLIC_HOST"`
+	// AdminToken enables the admin API. Empty disables it.
+	AdminToken string `yaml:"admin_token" env:"ADMIN_TOKEN"`
+}
+
+// Twilio holds the credentials of the Twilio account.
+type Twilio struct {
+	AccountSID string `yaml:"account_sid" env:"TWILIO_ACCOUNT_SID"`
+	AuthToken  string `yaml:"auth_token" env:"TWILIO_AUTH_TOKEN"`
+	// ValidateSignatures rejects webhooks and Media Streams not signed by
+	// Twilio. Turn it off only to call the server by hand in development.
+	ValidateSignatures bool `yaml:"validate_signatures" env:"TWILIO_VALIDATE_SIGNATURES"`
+	// ConnectMessage is said by Twilio while a call connects to the
+	// agent. Empty uses the example's own.
+	ConnectMessage string `yaml:"connect_message" env:"TWILIO_CONNECT_MESSAGE"`
+	// SayVoice and SayLanguage are the voice and language Twilio reads
+	// its messages in, e.g. "Polly.Joanna" and "en-GB". Empty uses
+	// Twilio's defaults.
+	SayVoice    string `yaml:"say_voice" env:"TWILIO_SAY_VOICE"`
+	SayLanguage string `yaml:"say_language" env:"TWILIO_SAY_LANGUAGE"`
+	// StreamParameters are passed to every call's Media Stream as custom
+	// parameters. In the environment they are name=value pairs separated
+	// by commas.
+	StreamParameters map[string]string `yaml:"stream_parameters" env:"TWILIO_STREAM_PARAMETERS"`
+}
+
+// Deepgram configures speech-to-text.
+type Deepgram struct {
+	APIKey   string `yaml:"api_key" env:"DEEPGRAM_API_KEY"`
+	Model    string `yaml:"model" env:"DEEPGRAM_MODEL"`
+	Language string `yaml:"language" env:"DEEPGRAM_LANGUAGE"`
+
+	// SmartFormat writes numbers, dates, amounts and the like as they are
+	// usually written. Numerals writes numbers as digits. ProfanityFilter
+	// masks profanity. FillerWords keeps "uh" and "um" in transcripts.
+	SmartFormat     bool `yaml:"smart_format" env:"DEEPGRAM_SMART_FORMAT"`
+	Numerals        bool `yaml:"numerals" env:"DEEPGRAM_NUMERALS"`
+	ProfanityFilter bool `yaml:"profanity_filter" env:"DEEPGRAM_PROFANITY_FILTER"`
+	FillerWords     bool `yaml:"filler_words" env:"DEEPGRAM_FILLER_WORDS"`
+}
+
+// ElevenLabs configures text-to-speech.
+type ElevenLabs struct {
+	APIKey  string `yaml:"api_key" env:"ELEVENLABS_API_KEY"`
+	VoiceID string `yaml:"voice_id" env:"ELEVENLABS_VOICE_ID"`
+	Model   string `yaml:"model" env:"ELEVENLABS_MODEL"`
+	// PCMSampleRate, if set, requests linear PCM at this rate instead of
+	// a telephony format, to be resampled locally.
+	PCMSampleRate int `yaml:"pcm_sample_rate" env:"TTS_PCM_SAMPLE_RATE"`
+	// VoiceSettings tunes how the voice sounds.
+	VoiceSettings VoiceSettings `yaml:"voice_settings"`
+}
+
+// VoiceSettings are ElevenLabs' voice_settings. With none set the voice's
+// own saved settings are used; once any is, those left unset take
+// ElevenLabs' defaults.
+type VoiceSettings struct {
+	// Stability, 0 to 1, trades emotional range for consistency.
+	Stability *float64 `yaml:"stability" env:"ELEVENLABS_STABILITY"`
+	// SimilarityBoost, 0 to 1, is how closely to keep to the original
+	// voice.
+	SimilarityBoost *float64 `yaml:"similarity_boost" env:"ELEVENLABS_SIMILARITY_BOOST"`
+	// Style, 0 to 1, exaggerates the original speaker's style.
+	Style *float64 `yaml:"style" env:"ELEVENLABS_STYLE"`
+	// SpeakerBoost boosts similarity to the original speaker.
+	SpeakerBoost *bool `yaml:"use_speaker_boost" env:"ELEVENLABS_SPEAKER_BOOST"`
+	// Speed, 0.25 to 4, is the speaking rate; 1 is normal.
+	Speed *float64 `yaml:"speed" env:"ELEVENLABS_SPEED"`
+}
+
+// LLM selects the language model that answers callers. Credentials come
+// from the provider's conventional environment variables (see llm.FromEnv).
+type LLM struct {
+	// Provider is anthropic, openai, gemini or ollama. Empty answers
+	// without a language model.
+	Provider string `yaml:"provider" env:"LLM_PROVIDER"`
+	// Model is the provider's model name. Empty selects its default.
+	Model string `yaml:"model" env:"LLM_MODEL"`
+}
+
+// Prompts holds what the agent is told and what it says unprompted. Empty
+// prompts fall back to the example's own.
+type Prompts struct {
+	System   string `yaml:"system" env:"LLM_SYSTEM_PROMPT"`
+	Greeting string `yaml:"greeting" env:"GREETING"`
+}
+
+// Timeouts bounds how long the server waits on callers and calls.
+type Timeouts struct {
+	// Drain is how long calls in progress get to end on shutdown.
+	Drain time.Duration `yaml:"drain" env:"DRAIN_TIMEOUT"`
+	// GreetingSilence is how long to wait for a caller who is expected to
+	// speak first before greeting anyway.
+	GreetingSilence time.Duration `yaml:"greeting_silence" env:"GREETING_SILENCE_TIMEOUT"`
+	// SilencePrompt is how long a caller may stay silent before they are
+	// asked whether they're still there; 0 never asks.
+	SilencePrompt time.Duration `yaml:"silence_prompt" env:"SILENCE_PROMPT_TIMEOUT"`
+	// SilenceHangup is how long a caller may stay silent before the call
+	// is ended; 0 never ends it.
+	SilenceHangup time.Duration `yaml:"silence_hangup" env:"SILENCE_HANGUP_TIMEOUT"`
+	// MaxCall is the longest a call may last; 0 is unlimited.
+	MaxCall time.Duration `yaml:"max_call" env:"MAX_CALL_DURATION"`
+}
+
+// Features turns optional behavior on and off.
+type Features struct {
+	// EchoGuard suppresses the agent's own voice picked up by the caller's
+	// microphone.
+	EchoGuard bool `yaml:"echo_guard" env:"ECHO_GUARD"`
+	// Coaching keeps the agent listening after a transfer to coach the
+	// human who took the call.
+	Coaching bool `yaml:"coaching" env:"COACHING"`
+	// GoodbyeHangup ends the call once the closing line has played
+	// instead of waiting for the caller to hang up.
+	GoodbyeHangup bool `yaml:"goodbye_hangup" env:"GOODBYE_HANGUP"`
+	// Guardrails protect the language model from callers' prompt
+	// injection attempts.
+	Guardrails bool `yaml:"guardrails" env:"LLM_GUARDRAILS"`
+	// EmotionTags has the language model tag its replies with emotions,
+	// such as [cheerful] or [apologetic], that the TTS provider is asked
+	// to speak them with.
+	EmotionTags bool `yaml:"emotion_tags" env:"EMOTION_TAGS"`
+	// ComfortNoise plays low-level noise while the agent isn't speaking,
+	// so callers don't take the silence for a dropped call.
+	ComfortNoise bool `yaml:"comfort_noise" env:"COMFORT_NOISE"`
+}
+
+// DoubleTalk is what the agent does when the caller talks over it.
+type DoubleTalk struct {
+	// Policy is stop (cut the agent off), duck (carry on more quietly
+	// until the caller stops) or finish-sentence (finish the sentence
+	// being spoken, then stop).
+	Policy string `yaml:"policy" env:"DOUBLE_TALK"`
+	// DuckDB is the gain, in dB, the agent's voice is lowered by under
+	// duck.
+	DuckDB float64 `yaml:"duck_db" env:"DOUBLE_TALK_DUCK_DB"`
+}
+
+// Telemetry configures anonymous feature-usage reporting (see package
+// telemetry). It is off unless Mode is set.
+type Telemetry struct {
+	// Mode is off, local (only write File) or remote (also send the
+	// summary to Endpoint).
+	Mode string `yaml:"mode" env:"TELEMETRY"`
+	// File is where the usage summary is written.
+	File string `yaml:"file" env:"TELEMETRY_FILE"`
+	// Endpoint is the collector the summary is sent to in remote mode.
+	Endpoint string `yaml:"endpoint" env:"TELEMETRY_ENDPOINT"`
+	// Interval is how often the summary is written and sent.
+	Interval time.Duration `yaml:"interval" env:"TELEMETRY_INTERVAL"`
+}
+
+// Tenant is the agent for calls to one number. Empty fields keep the
+// top-level setting.
+type Tenant struct {
+	// Name identifies the tenant in logs and call records.
+	Name string `yaml:"name"`
+	// VoiceID and TTSModel choose the ElevenLabs voice.
+	VoiceID  string `yaml:"voice_id"`
+	TTSModel string `yaml:"tts_model"`
+	// STTModel and Language configure Deepgram.
+	STTModel string `yaml:"stt_model"`
+	Language string `yaml:"language"`
+	// LLM replaces the top-level model as a whole when its provider is
+	// set, so an empty model selects that provider's default.
+	LLM     LLM     `yaml:"llm"`
+	Prompts Prompts `yaml:"prompts"`
+	// DoubleTalk is what the tenant's agent does when the caller talks
+	// over it.
+	DoubleTalk DoubleTalk `yaml:"double_talk"`
+	// Pronunciations are added to the top-level ones, replacing those of
+	// the same names.
+	Pronunciations map[string]Pronunciation `yaml:"pronunciations"`
+}
+
+// Pronunciation is how a name is said: respelled in ordinary letters,
+// e.g. "nuh-WIN", or in IPA phonemes, or both. A plain string in the file
+// is a respelling.
+type Pronunciation struct {
+	Respelling string `yaml:"respelling"`
+	IPA        string `yaml:"ipa"`
+}
+
+// UnmarshalYAML reads a pronunciation given as a respelling alone, or in
+// full.
+func (p *Pronunciation) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		return value.Decode(&p.Respelling)
+	}
+	type pronunciation Pronunciation
+	return value.Decode((*pronunciation)(p))
+}
+
+// Experiment splits calls between variants of the agent.
+type Experiment struct {
+	// Name identifies the experiment in logs, call records and results.
+	Name string `yaml:"name"`
+	// Numbers limits the experiment to calls to these numbers, in E.164.
+	// Empty includes every call.
+	Numbers []string `yaml:"numbers"`
+	// Variants share the experiment's calls in proportion to their
+	// weights. The first is the control the others are compared with.
+	Variants []Variant `yaml:"variants"`
+}
+
+// Specialist is one member of an agent team.
+type Specialist struct {
+	// Name identifies the specialist to the others and in logs and call
+	// records, e.g. "billing".
+	Name string `yaml:"name"`
+	// Description tells the others what it handles, so they know when to
+	// hand the call to it.
+	Description string `yaml:"description"`
+	// Prompt is its system prompt. Empty uses the top-level one.
+	Prompt string `yaml:"prompt"`
+	// Tools names the example's tools it may call. Empty allows them all.
+	Tools []string `yaml:"tools"`
+	// VoiceID is the voice it speaks in. Empty uses the call's voice.
+	VoiceID string `yaml:"voice_id"`
+}
+
+// Emotion is how replies tagged with an emotion are spoken. Each TTS
+// provider takes the fields it understands; empty fields change nothing.
+type Emotion struct {
+	// Stability and Style replace the ElevenLabs voice settings of the
+	// same names, 0 to 1: lower stability is more expressive, higher
+	// style exaggerates the speaker's style.
+	Stability *float64 `yaml:"stability"`
+	Style     *float64 `yaml:"style"`
+	// Rate, Pitch and Volume are SSML prosody for providers that read
+	// SSML, e.g. "95%", "+5%" and "soft".
+	Rate   string `yaml:"rate"`
+	Pitch  string `yaml:"pitch"`
+	Volume string `yaml:"volume"`
+}
+
+// Variant is one arm of an experiment: changes to the agent a call would
+// otherwise get. Empty fields change nothing.
+type Variant struct {
+	Name string `yaml:"name"`
+	// Weight is the variant's share of calls relative to the others';
+	// 0 counts as 1.
+	Weight int `yaml:"weight"`
+
+	VoiceID  string  `yaml:"voice_id"`
+	TTSModel string  `yaml:"tts_model"`
+	STTModel string  `yaml:"stt_model"`
+	Language string  `yaml:"language"`
+	LLM      LLM     `yaml:"llm"`
+	Prompts  Prompts `yaml:"prompts"`
+}
+
+// TenantFor returns the agent configuration for calls to number: its
+// tenant's, with empty fields filled in from the top level and its
+// pronunciations added to the top-level ones. Numbers without a tenant
+// get the top-level configuration and ok false.
+func (c Config) TenantFor(number string) (t Tenant, ok bool) {
+	t, ok = c.Tenants[number]
+	if t.VoiceID == "" {
+		t.VoiceID = c.ElevenLabs.VoiceID
+	}
+	if t.TTSModel == "" {
+		t.TTSModel = c.ElevenLabs.Model
+	}
+	if t.STTModel == "" {
+		t.STTModel = c.Deepgram.Model
+	}
+	if t.Language == "" {
+		t.Language = c.Deepgram.Language
+	}
+	if t.LLM.Provider == "" {
+		t.LLM = c.LLM
+	}
+	if t.Prompts.System == "" {
+		t.Prompts.System = c.Prompts.System
+	}
+	if t.Prompts.Greeting == "" {
+		t.Prompts.Greeting = c.Prompts.Greeting
+	}
+	if t.DoubleTalk.Policy == "" {
+		t.DoubleTalk.Policy = c.DoubleTalk.Policy
+	}
+	if t.DoubleTalk.DuckDB == 0 {
+		t.DoubleTalk.DuckDB = c.DoubleTalk.DuckDB
+	}
+	if len(t.Pronunciations) > 0 {
+		merged := maps.Clone(c.Pronunciations)
+		if merged == nil {
+			merged = make(map[string]Pronunciation, len(t.Pronunciations))
+		}
+		maps.Copy(merged, t.Pronunciations)
+		t.Pronunciations = merged
+	} else {
+		t.Pronunciations = c.Pronunciations
+	}
+	return t, ok
+}
+
+// Default returns the configuration used for settings that are neither in
+// the file nor in the environment.
+func Default() Config {
+	return Config{
+		Server:     Server{Addr: ":8080"},
+		Twilio:     Twilio{ValidateSignatures: true},
+		Deepgram:   Deepgram{Model: "nova-2", Language: "en-US", SmartFormat: true},
+		ElevenLabs: ElevenLabs{VoiceID: "Rachel", Model: "eleven_turbo_v2_5"},
+		Timeouts: Timeouts{
+			Drain:           5 * time.Minute,
+			GreetingSilence: 3 * time.Second,
+			SilencePrompt:   10 * time.Second,
+			SilenceHangup:   25 * time.Second,
+			MaxCall:         time.Hour,
+		},
+		Features:   Features{EchoGuard: true, Coaching: true, GoodbyeHangup: true, Guardrails: true},
+		DoubleTalk: DoubleTalk{Policy: "stop", DuckDB: -12},
+		Telemetry:  Telemetry{Mode: "off", File: "telemetry.json", Interval: 24 * time.Hour},
+	}
+}
+
+// Load returns the defaults overridden by the YAML file at path, if path
+// is not empty, and then by the environment. Environment variables that
+// are set but empty are ignored.
+func Load(path string) (Config, error) {
+	cfg := Default()
+	if path != "" {
+		if err := loadFile(path, &cfg); err != nil {
+			return cfg, err
+		}
+	}
+	if err := applyEnv(reflect.ValueOf(&cfg).Elem()); err != nil {
+		return cfg, err
+	}
+	return cfg, nil
+}
+
+func loadFile(path string, cfg *Config) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	dec := yaml.NewDecoder(f)
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// applyEnv sets every field of v that has an env tag and a non-empty
+// environment variable, descending into nested structs.
+func applyEnv(v reflect.Value) error {
+	t := v.Type()
+	for i := range t.NumField() {
+		field, value := t.Field(i), v.Field(i)
+		if field.Type.Kind() == reflect.Struct {
+			if err := applyEnv(value); err != nil {
+				return err
+			}
+			continue
+		}
+		name := field.Tag.Get("env")
+		if name == "" {
+			continue
+		}
+		s := os.Getenv(name)
+		if s == "" {
+			continue
+		}
+		if err := setValue(value, s); err != nil {
+			return fmt.Errorf("invalid %s: %q (%w)", name, s, err)
+		}
+	}
+	return nil
+}
+
+// setValue parses s into v according to v's type.
+func setValue(v reflect.Value, s string) error {
+	if v.Type() == reflect.TypeFor[time.Duration]() {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return errors.New("want a duration such as 30s")
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+	switch v.Kind() {
+	case reflect.Pointer:
+		p := reflect.New(v.Type().Elem())
+		if err := setValue(p.Elem(), s); err != nil {
+			return err
+		}
+		v.Set(p)
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return errors.New("want true or false")
+		}
+		v.SetBool(b)
+	case reflect.Int:
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			return errors.New("want an integer")
+		}
+		v.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return errors.New("want a number")
+		}
+		v.SetFloat(f)
+	case reflect.Map:
+		if v.Type() != reflect.TypeFor[map[string]string]() {
+			return fmt.Errorf("unsupported type %s", v.Type())
+		}
+		m := make(map[string]string)
+		for _, pair := range strings.Split(s, ",") {
+			name, value, ok := strings.Cut(pair, "=")
+			name = strings.TrimSpace(name)
+			if !ok || name == "" {
+				return errors.New("want name=value pairs separated by commas")
+			}
+			m[name] = strings.TrimSpace(value)
+		}
+		v.Set(reflect.ValueOf(m))
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+// Package crm looks up who is calling before the agent answers, so it can
+// greet customers by name and treat them according to their account.
+//
+// A Directory finds a customer's Profile by the number they call from.
+// Profiles can be held in memory, read from a JSON file, or fetched from
+// a CRM behind an HTTP API:
+//
+//	dir := &crm.HTTPDirectory{URL: "https://crm.example.com/lookup"}
+//	profile, err := dir.Lookup(ctx, "+15551230001")
+//	if err == nil && profile != nil {
+//		brief := profile.Brief() // "You are speaking with Jane Doe, a premium customer. ..."
+//	}
+//
+// Caller ID can be spoofed. A profile found by number alone is a hint
+// about who is likely calling, not proof: don't give the agent anything
+// it mustn't tell whoever is on the line.
+package crm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/phone"
+)
+
+// Profile is what the CRM knows about a customer.
+type Profile struct {
+	Name string `json:"name"`
+	// Tier is the customer's standing, e.g. "premium".
+	Tier      string `json:"tier,omitempty"`
+	AccountID string `json:"account_id,omitempty"`
+	// Notes are passed to the agent as they are, e.g. "Has an open
+	// support ticket about a late delivery."
+	Notes string `json:"notes,omitempty"`
+}
+
+// Brief describes the customer to the agent, for its system prompt.
+func (p Profile) Brief() string {
+	var b strings.Builder
+	b.WriteString("You are speaking with ")
+	b.WriteString(firstNonEmpty(p.Name, "a known customer"))
+	if p.Tier != "" {
+		fmt.Fprintf(&b, ", %s %s customer", article(p.Tier), p.Tier)
+	}
+	if p.AccountID != "" {
+		fmt.Fprintf(&b, " (account %s)", p.AccountID)
+	}
+	b.WriteString(".")
+	if p.Notes != "" {
+		b.WriteString(" " + strings.TrimSpace(p.Notes))
+	}
+	b.WriteString(" They were identified by caller ID only; confirm who they are before discussing their account.")
+	return b.String()
+}
+
+// Directory finds customers by phone number.
+type Directory interface {
+	// Lookup returns the profile of the customer calling from number, or
+	// nil if there is none.
+	Lookup(ctx context.Context, number string) (*Profile, error)
+}
+
+// Key reduces a phone number to the form directories are keyed by: E.164
+// where it parses as a number with a country code, otherwise the number as
+// written, trimmed.
+func Key(number string) string {
+	number = strings.TrimSpace(number)
+	if n, err := (phone.DialPlan{}).Parse(number); err == nil {
+		return n.Address()
+	}
+	return number
+}
+
+// Map is a directory held in memory, keyed by Key.
+type Map map[string]Profile
+
+// Lookup returns the profile for number.
+func (m Map) Lookup(_ context.Context, number string) (*Profile, error) {
+	p, ok := m[Key(number)]
+	if !ok {
+		return nil, nil
+	}
+	return &p, nil
+}
+
+// LoadFile reads a directory from a JSON file mapping phone numbers to
+// profiles:
+//
+//	{"+15551230001": {"name": "Jane Doe", "tier": "premium"}}
+func LoadFile(path string) (Map, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var profiles map[string]Profile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	m := make(Map, len(profiles))
+	for number, p := range profiles {
+		key := Key(number)
+		if key == "" {
+			return nil, fmt.Errorf("%s: not a phone number: %q", path, number)
+		}
+		m[key] = p
+	}
+	return m, nil
+}
+
+// HTTPDirectory is a CRM behind an API, or a webhook of your own in front
+// of one. Lookup sends GET URL?number=... with the number in E.164 and
+// expects a Profile as JSON, or 404 Not Found for unknown numbers.
+type HTTPDirectory struct {
+	URL string
+	// Header is added to every request, e.g. for an API key.
+	Header http.Header
+	// Client defaults to one with a 5 second timeout.
+	Client *http.Client
+}
+
+var defaultHTTPClient = &http.Client{Timeout: 5 * time.Second}
+
+// Lookup asks the API for number's profile.
+func (d *HTTPDirectory) Lookup(ctx context.Context, number string) (*Profile, error) {
+	u, err := url.Parse(d.URL)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("number", Key(number))
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	for name, values := range d.Header {
+		req.Header[name] = values
+	}
+	client := d.Client
+	if client == nil {
+		client = defaultHTTPClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, nil
+	default:
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("CRM API: %s: %s", resp.Status, body)
+	}
+	var p Profile
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&p); err != nil {
+		return nil, fmt.Errorf("CRM API: %w", err)
+	}
+	if p == (Profile{}) {
+		return nil, errors.New("CRM API: empty profile")
+	}
+	return &p, nil
+}
+
+// Directories combines directories: the first to know number answers.
+type Directories []Directory
+
+// Lookup asks each directory in turn, stopping at the first that finds
+// number or fails.
+func (d Directories) Lookup(ctx context.Context, number string) (*Profile, error) {
+	for _, dir := range d {
+		p, err := dir.Lookup(ctx, number)
+		if err != nil || p != nil {
+			return p, err
+		}
+	}
+	return nil, nil
+}
+
+func article(word string) string {
+	if strings.ContainsRune("aeiouAEIOU", rune(word[0])) {
+		return "an"
+	}
+	return "a"
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
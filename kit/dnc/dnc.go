@@ -0,0 +1,120 @@
+// Package dnc enforces do-not-call rules before an example dials out: a
+// do-not-call list, jurisdiction-aware calling hours, and an audit trail of
+// every attempt that was suppressed.
+//
+// Lists can come from a file, a database or an API, and several can be
+// combined:
+//
+//	file, err := dnc.LoadFile("dnc.txt")
+//	gate := &dnc.Gate{
+//		List:  dnc.Lists{file, &dnc.HTTPList{URL: "https://dnc.example.com/check"}},
+//		Hours: dnc.DefaultCallingHours(),
+//		Audit: auditFile,
+//	}
+//	if err := gate.Check(ctx, number, "transfer", callSID); err != nil {
+//		// don't dial
+//	}
+//
+// A failed list lookup suppresses the call: when in doubt, don't dial.
+package dnc
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/agentplexus/omnivoice-examples/kit/phone"
+)
+
+// List reports whether a number must not be called.
+type List interface {
+	Listed(ctx context.Context, number string) (bool, error)
+}
+
+// Normalize reduces a phone number to the form lists are keyed by: a
+// leading + and digits, with spaces, punctuation and any extension removed.
+// Numbers without a country code keep just their digits; read them with a
+// phone.DialPlan first to match them to E.164 entries. Anything that isn't
+// a phone number, such as a SIP URI, is returned unchanged.
+func Normalize(number string) string {
+	number = strings.TrimSpace(number)
+	if n, err := (phone.DialPlan{}).Parse(number); err == nil {
+		return n.Address()
+	}
+	if strings.Contains(number, ":") || strings.Contains(number, "@") {
+		return number
+	}
+	var b strings.Builder
+	for i, r := range number {
+		switch {
+		case r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case r == '+' && i == 0:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// Set is a list held in memory.
+type Set map[string]struct{}
+
+// NewSet returns a list of numbers.
+func NewSet(numbers ...string) Set {
+	s := make(Set, len(numbers))
+	for _, n := range numbers {
+		s[Normalize(n)] = struct{}{}
+	}
+	return s
+}
+
+// Listed reports whether number is in the set.
+func (s Set) Listed(_ context.Context, number string) (bool, error) {
+	_, ok := s[Normalize(number)]
+	return ok, nil
+}
+
+// LoadFile reads a list with one number per line. Blank lines and lines
+// starting with # are ignored.
+func LoadFile(path string) (Set, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := make(Set)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		number := Normalize(text)
+		if number == "" {
+			return nil, fmt.Errorf("%s:%d: not a phone number: %q", path, line, text)
+		}
+		s[number] = struct{}{}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Lists combines lists: a number is listed if any of them lists it.
+type Lists []List
+
+// Listed checks each list in turn, stopping at the first that lists
+// number or fails.
+func (l Lists) Listed(ctx context.Context, number string) (bool, error) {
+	for _, list := range l {
+		listed, err := list.Listed(ctx, number)
+		if err != nil || listed {
+			return listed, err
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,99 @@
+package dnc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrListed suppresses calls to numbers on the do-not-call list.
+	ErrListed = errors.New("number is on the do-not-call list")
+	// ErrOutsideCallingHours suppresses calls outside the called party's
+	// calling hours.
+	ErrOutsideCallingHours = errors.New("outside calling hours")
+)
+
+// Suppression reasons in audit records.
+const (
+	ReasonListed       = "listed"
+	ReasonCallingHours = "calling_hours"
+	ReasonLookupFailed = "lookup_failed"
+)
+
+// Suppression is the audit record of a call that was not placed.
+type Suppression struct {
+	At     time.Time `json:"at"`
+	Number string    `json:"number"`
+	// Purpose is why the call was to be placed, e.g. "transfer".
+	Purpose string `json:"purpose"`
+	// Ref ties the attempt to what prompted it, e.g. a call SID.
+	Ref          string `json:"ref,omitempty"`
+	Reason       string `json:"reason"`
+	Jurisdiction string `json:"jurisdiction,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// Gate decides whether a number may be dialed. The zero Gate allows every
+// call.
+type Gate struct {
+	// List, if set, holds numbers that must not be called.
+	List List
+	// Hours, if set, restricts when numbers may be called.
+	Hours CallingHours
+	// Audit, if set, receives a JSON line for every suppressed attempt.
+	Audit io.Writer
+	// Now defaults to time.Now.
+	Now func() time.Time
+
+	mu sync.Mutex // serializes audit writes
+}
+
+// Check returns nil if number may be dialed now for purpose. Otherwise it
+// records the attempt in the audit trail and returns why it was suppressed:
+// ErrListed, ErrOutsideCallingHours, or the error of a failed list lookup.
+func (g *Gate) Check(ctx context.Context, number, purpose, ref string) error {
+	now := time.Now
+	if g.Now != nil {
+		now = g.Now
+	}
+	at := now()
+	record := Suppression{At: at.UTC(), Number: Normalize(number), Purpose: purpose, Ref: ref}
+
+	if g.List != nil {
+		listed, err := g.List.Listed(ctx, number)
+		if err != nil {
+			record.Reason, record.Error = ReasonLookupFailed, err.Error()
+			g.audit(record)
+			return fmt.Errorf("do-not-call lookup failed: %w", err)
+		}
+		if listed {
+			record.Reason = ReasonListed
+			g.audit(record)
+			return ErrListed
+		}
+	}
+	if ok, jurisdiction := g.Hours.Allowed(number, at); !ok {
+		record.Reason, record.Jurisdiction = ReasonCallingHours, jurisdiction
+		g.audit(record)
+		return fmt.Errorf("%w for %s", ErrOutsideCallingHours, jurisdiction)
+	}
+	return nil
+}
+
+func (g *Gate) audit(record Suppression) {
+	if g.Audit == nil {
+		return
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	_, _ = g.Audit.Write(append(line, '\n'))
+}
@@ -0,0 +1,105 @@
+package dnc
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	// Calling hours need the zone database even on minimal images.
+	_ "time/tzdata"
+)
+
+// Window is when numbers starting with Prefix may be called.
+type Window struct {
+	// Jurisdiction names the rule in audit records, e.g. "US/CA".
+	Jurisdiction string
+	// Prefix is the E.164 prefix the window applies to, e.g. "+1".
+	Prefix string
+	// Locations are the time zones the called party may be in. A call
+	// must fall within the window in every one of them.
+	Locations []*time.Location
+	// Start and End bound the window as times of day since midnight.
+	Start, End time.Duration
+	// Days are the weekdays calls may be placed on. Empty allows any day.
+	Days []time.Weekday
+}
+
+// Contains reports whether t falls within the window in every location.
+func (w Window) Contains(t time.Time) bool {
+	for _, loc := range w.Locations {
+		local := t.In(loc)
+		if len(w.Days) > 0 && !slices.Contains(w.Days, local.Weekday()) {
+			return false
+		}
+		midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, loc)
+		if since := local.Sub(midnight); since < w.Start || since >= w.End {
+			return false
+		}
+	}
+	return true
+}
+
+// CallingHours is the calling windows of each jurisdiction. The window
+// with the longest matching prefix applies; numbers matching none may be
+// called at any time.
+type CallingHours []Window
+
+// Lookup returns the window that applies to number.
+func (h CallingHours) Lookup(number string) (Window, bool) {
+	number = Normalize(number)
+	var best Window
+	found := false
+	for _, w := range h {
+		if strings.HasPrefix(number, w.Prefix) && (!found || len(w.Prefix) > len(best.Prefix)) {
+			best, found = w, true
+		}
+	}
+	return best, found
+}
+
+// Allowed reports whether number may be called at t, and the jurisdiction
+// whose window decided it, if any.
+func (h CallingHours) Allowed(number string, t time.Time) (bool, string) {
+	w, ok := h.Lookup(number)
+	if !ok {
+		return true, ""
+	}
+	return w.Contains(t), w.Jurisdiction
+}
+
+// DefaultCallingHours returns conservative windows for the United States
+// and Canada (8am to 9pm in every continental time zone, after the TCPA)
+// and the United Kingdom (8am to 9pm). They are a starting point, not
+// legal advice; add windows for the jurisdictions you call.
+func DefaultCallingHours() CallingHours {
+	return CallingHours{
+		{
+			Jurisdiction: "US/CA",
+			Prefix:       "+1",
+			Locations:    locations("America/New_York", "America/Chicago", "America/Denver", "America/Phoenix", "America/Los_Angeles"),
+			Start:        8 * time.Hour,
+			End:          21 * time.Hour,
+		},
+		{
+			Jurisdiction: "UK",
+			Prefix:       "+44",
+			Locations:    locations("Europe/London"),
+			Start:        8 * time.Hour,
+			End:          21 * time.Hour,
+		},
+	}
+}
+
+// locations loads zones from the embedded database, so it can't fail for
+// valid names.
+func locations(names ...string) []*time.Location {
+	locs := make([]*time.Location, len(names))
+	for i, name := range names {
+		loc, err := time.LoadLocation(name)
+		if err != nil {
+			panic(err)
+		}
+		locs[i] = loc
+	}
+	return locs
+}
@@ -0,0 +1,90 @@
+package dnc
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// SQLList is a list kept in a database. The caller opens DB with the
+// driver of their choice.
+type SQLList struct {
+	DB *sql.DB
+	// Query selects a row if its only argument, the normalized number, is
+	// listed, e.g. "SELECT 1 FROM do_not_call WHERE number = $1".
+	Query string
+}
+
+// Listed reports whether Query returns a row for number.
+func (l *SQLList) Listed(ctx context.Context, number string) (bool, error) {
+	var discard any
+	err := l.DB.QueryRowContext(ctx, l.Query, Normalize(number)).Scan(&discard)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return false, nil
+	case err != nil:
+		return false, err
+	default:
+		return true, nil
+	}
+}
+
+// HTTPList is a list behind an API. Listed sends GET URL?number=... and
+// expects a JSON response of the form {"listed": true}.
+type HTTPList struct {
+	URL string
+	// Header is added to every request, e.g. for an API key.
+	Header http.Header
+	// Client defaults to one with a 5 second timeout.
+	Client *http.Client
+}
+
+var defaultHTTPClient = &http.Client{Timeout: 5 * time.Second}
+
+// Listed asks the API whether number is listed.
+func (l *HTTPList) Listed(ctx context.Context, number string) (bool, error) {
+	u, err := url.Parse(l.URL)
+	if err != nil {
+		return false, err
+	}
+	q := u.Query()
+	q.Set("number", Normalize(number))
+	u.RawQuery = q.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return false, err
+	}
+	for name, values := range l.Header {
+		req.Header[name] = values
+	}
+	client := l.Client
+	if client == nil {
+		client = defaultHTTPClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return false, fmt.Errorf("do-not-call API: %s: %s", resp.Status, body)
+	}
+	var result struct {
+		Listed *bool `json:"listed"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, fmt.Errorf("do-not-call API: %w", err)
+	}
+	if result.Listed == nil {
+		return false, errors.New(`do-not-call API: response has no "listed" field`)
+	}
+	return *result.Listed, nil
+}
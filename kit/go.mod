@@ -0,0 +1,13 @@
+// Shared building blocks for the OmniVoice examples.
+//
+// Examples reference this module through a replace directive so that the
+// kit can evolve alongside them without being published separately.
+module github.com/agentplexus/omnivoice-examples/kit
+
+go 1.24.11
+
+require (
+	github.com/agentplexus/omnivoice v0.2.0
+	github.com/gorilla/websocket v1.5.3
+	gopkg.in/yaml.v3 v3.0.1
+)
@@ -0,0 +1,92 @@
+// Package golden checks what examples render, such as TwiML documents,
+// request bodies and results, against snapshot files kept under a test's
+// testdata. Tests pass update, from an -update flag of their own, to
+// rewrite the files instead once a change is intended:
+//
+//	var update = flag.Bool("update", false, "rewrite golden files")
+//
+//	func TestGolden(t *testing.T) {
+//		golden.Check(t, "testdata/golden", map[string]string{
+//			"connect.xml": connectTwiML(...),
+//		}, *update)
+//	}
+package golden
+
+import (
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// Check compares each file's content with the file of that name in dir,
+// or rewrites the files when update is set. Files in dir that aren't in
+// files are reported too, or removed, so a renderer that is taken out
+// can't leave a stale snapshot behind.
+func Check(t testing.TB, dir string, files map[string]string, update bool) {
+	t.Helper()
+	if update {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(files)) {
+		path := filepath.Join(dir, name)
+		if update {
+			if err := os.WriteFile(path, []byte(files[name]), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		want, err := os.ReadFile(path)
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if diff := Diff(string(want), files[name]); diff != "" {
+			t.Errorf("%s: %s (run with -update if the change is intended)", name, diff)
+		}
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if _, ok := files[e.Name()]; ok || e.IsDir() {
+			continue
+		}
+		if update {
+			if err := os.Remove(filepath.Join(dir, e.Name())); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		t.Errorf("%s: nothing renders this file (run with -update to remove it)", e.Name())
+	}
+}
+
+// Diff describes the first line where got differs from want, or returns
+// "" if they are identical.
+func Diff(want, got string) string {
+	if want == got {
+		return ""
+	}
+	wantLines, gotLines := strings.Split(want, "\n"), strings.Split(got, "\n")
+	for i := range max(len(wantLines), len(gotLines)) {
+		var w, g string
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if w != g || i >= len(wantLines) || i >= len(gotLines) {
+			return fmt.Sprintf("line %d differs\n    want: %q\n    got:  %q", i+1, w, g)
+		}
+	}
+	return "differs"
+}
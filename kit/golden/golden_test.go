@@ -0,0 +1,40 @@
+package golden
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDiff(t *testing.T) {
+	tests := []struct {
+		want, got string
+		line      string
+	}{
+		{"a\nb\n", "a\nb\n", ""},
+		{"a\nb\n", "a\nc\n", "line 2"},
+		{"a\n", "a\nb\n", "line 2"},
+		{"a\nb", "a", "line 2"},
+	}
+	for _, tt := range tests {
+		diff := Diff(tt.want, tt.got)
+		if (tt.line == "") != (diff == "") || !strings.HasPrefix(diff, tt.line) {
+			t.Errorf("Diff(%q, %q) = %q, want %q...", tt.want, tt.got, diff, tt.line)
+		}
+	}
+}
+
+func TestCheckUpdate(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "golden")
+	Check(t, dir, map[string]string{"a.txt": "A", "b.txt": "B"}, true)
+	Check(t, dir, map[string]string{"a.txt": "A2"}, true)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].Name() != "a.txt" {
+		t.Fatalf("update left %v, want only a.txt", entries)
+	}
+	Check(t, dir, map[string]string{"a.txt": "A2"}, false)
+}
@@ -0,0 +1,180 @@
+package googleauth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testScope = "https://www.googleapis.com/auth/devstorage.read_write"
+
+// writeKeyFile writes a service account key file for key, as the Google
+// Cloud console downloads it, and returns its path.
+func writeKeyFile(t *testing.T, key *rsa.PrivateKey, tokenURI string) string {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(map[string]string{
+		"type":         "service_account",
+		"client_email": "archive@example.iam.gserviceaccount.com",
+		"private_key":  string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":    tokenURI,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "key.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// checkAssertion verifies a JWT assertion's signature with key and
+// returns its claims.
+func checkAssertion(t *testing.T, key *rsa.PublicKey, assertion string) map[string]any {
+	t.Helper()
+	parts := strings.Split(assertion, ".")
+	if len(parts) != 3 {
+		t.Fatalf("assertion has %d parts, want 3", len(parts))
+	}
+	enc := base64.RawURLEncoding
+	header, err := enc.DecodeString(parts[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(header) != `{"alg":"RS256","typ":"JWT"}` {
+		t.Errorf("header = %s", header)
+	}
+	sig, err := enc.DecodeString(parts[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, sum[:], sig); err != nil {
+		t.Errorf("signature doesn't verify: %v", err)
+	}
+	payload, err := enc.DecodeString(parts[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var claims map[string]any
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		t.Fatal(err)
+	}
+	return claims
+}
+
+func TestAssertion(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	account, err := LoadServiceAccount(writeKeyFile(t, key, ""), testScope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if account.TokenURL != TokenURL {
+		t.Errorf("TokenURL = %q, want %q", account.TokenURL, TokenURL)
+	}
+
+	now := time.Date(2025, time.March, 15, 12, 0, 0, 0, time.UTC)
+	assertion, err := account.assertion(now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := checkAssertion(t, &key.PublicKey, assertion)
+	for name, want := range map[string]any{
+		"iss":   "archive@example.iam.gserviceaccount.com",
+		"scope": testScope,
+		"aud":   TokenURL,
+		"iat":   float64(now.Unix()),
+		"exp":   float64(now.Add(time.Hour).Unix()),
+	} {
+		if claims[name] != want {
+			t.Errorf("claim %s = %v, want %v", name, claims[name], want)
+		}
+	}
+
+	// Signed with another key, it doesn't verify
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(assertion, ".")
+	sig, _ := base64.RawURLEncoding.DecodeString(parts[2])
+	sum := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if rsa.VerifyPKCS1v15(&other.PublicKey, crypto.SHA256, sum[:], sig) == nil {
+		t.Error("assertion verifies with another key")
+	}
+}
+
+func TestToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if got := r.FormValue("grant_type"); got != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+			t.Errorf("grant_type = %q", got)
+		}
+		claims := checkAssertion(t, &key.PublicKey, r.FormValue("assertion"))
+		if claims["aud"] != "http://"+r.Host+"/token" {
+			t.Errorf("aud = %v, want the key file's token_uri", claims["aud"])
+		}
+		fmt.Fprintf(w, `{"access_token":"token-%d","expires_in":3600}`, requests)
+	}))
+	defer srv.Close()
+	account, err := LoadServiceAccount(writeKeyFile(t, key, srv.URL+"/token"), testScope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 2 {
+		token, err := account.Token(t.Context())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "token-1" {
+			t.Errorf("Token = %q, want token-1", token)
+		}
+	}
+	if requests != 1 {
+		t.Errorf("%d token requests, want the token cached after 1", requests)
+	}
+}
+
+func TestLoadServiceAccountRejects(t *testing.T) {
+	dir := t.TempDir()
+	for _, tt := range []struct {
+		name, data string
+	}{
+		{"not JSON", "key"},
+		{"user credentials", `{"type":"authorized_user","client_email":"me@example.com"}`},
+		{"no private key", `{"type":"service_account","client_email":"archive@example.com","private_key":""}`},
+	} {
+		path := filepath.Join(dir, "key.json")
+		if err := os.WriteFile(path, []byte(tt.data), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := LoadServiceAccount(path, testScope); err == nil {
+			t.Errorf("%s: LoadServiceAccount succeeded", tt.name)
+		}
+	}
+}
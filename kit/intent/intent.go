@@ -0,0 +1,172 @@
+// Package intent recognizes common requests in what a caller says, such
+// as asking for the opening hours or the address, or to talk to a person,
+// so a host can answer them at once with a canned reply instead of waiting
+// on a language model.
+//
+// A Classifier names the intent of an utterance: Rules matching keywords,
+// a Model asking a small, fast language model, or several tried in turn:
+//
+//	intents := []intent.Intent{
+//		{Name: "hours", Description: "asks when the business is open", Keywords: []string{"opening hours", "when are you open"}},
+//		{Name: "human", Description: "asks to talk to a person", Keywords: []string{"real person", "talk to a human"}},
+//	}
+//	classifier := intent.Classifiers{intent.NewRules(intents), intent.NewModel(provider, intents)}
+//	name, err := classifier.Classify(ctx, utterance)
+//
+// An utterance with none of the intents, or with more to it than one of
+// them, is classified as "" and left for the agent.
+package intent
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/agentplexus/omnivoice-examples/kit/llm"
+)
+
+// Intent is a request callers commonly make.
+type Intent struct {
+	// Name identifies the intent, e.g. "hours".
+	Name string
+	// Description tells a Model what the intent covers.
+	Description string
+	// Keywords are words and phrases Rules recognize the intent by.
+	Keywords []string
+}
+
+// Classifier names the intent of an utterance, or returns "" if it has
+// none of the intents it knows.
+type Classifier interface {
+	Classify(ctx context.Context, text string) (string, error)
+}
+
+// Classifiers tries classifiers in order, returning the first intent one
+// of them finds. It stops at the first that fails.
+type Classifiers []Classifier
+
+// Classify returns the first intent found.
+func (c Classifiers) Classify(ctx context.Context, text string) (string, error) {
+	for _, classifier := range c {
+		name, err := classifier.Classify(ctx, text)
+		if err != nil || name != "" {
+			return name, err
+		}
+	}
+	return "", nil
+}
+
+// DefaultMaxWords is the longest utterance Rules classify by default.
+const DefaultMaxWords = 12
+
+// Rules recognizes intents by their keywords, without any network calls.
+// A keyword matches when its words appear together in the utterance,
+// ignoring case and punctuation.
+type Rules struct {
+	Intents []Intent
+	// MaxWords is the longest utterance classified: a caller who says
+	// more likely wants more than a canned answer. 0 is unlimited.
+	MaxWords int
+}
+
+// NewRules returns rules for intents, classifying utterances of up to
+// DefaultMaxWords words.
+func NewRules(intents []Intent) *Rules {
+	return &Rules{Intents: intents, MaxWords: DefaultMaxWords}
+}
+
+// Classify returns the intent with the most keywords in text, the first
+// listed if several tie.
+func (r *Rules) Classify(_ context.Context, text string) (string, error) {
+	said := words(text)
+	if len(said) == 0 || (r.MaxWords > 0 && len(said) > r.MaxWords) {
+		return "", nil
+	}
+	name, best := "", 0
+	for _, in := range r.Intents {
+		matched := 0
+		for _, kw := range in.Keywords {
+			if containsWords(said, words(kw)) {
+				matched++
+			}
+		}
+		if matched > best {
+			name, best = in.Name, matched
+		}
+	}
+	return name, nil
+}
+
+// words splits text into lowercase words.
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	})
+}
+
+// containsWords reports whether phrase appears in words.
+func containsWords(words, phrase []string) bool {
+	if len(phrase) == 0 {
+		return false
+	}
+	for i := 0; i+len(phrase) <= len(words); i++ {
+		match := true
+		for j, w := range phrase {
+			if words[i+j] != w {
+				match = false
+				break
+			}
+		}
+		if match {
+			return true
+		}
+	}
+	return false
+}
+
+// Model asks a language model, ideally a small and fast one, which intent
+// an utterance has, going by their descriptions. It catches the phrasings
+// keywords miss, at the cost of a request per utterance.
+type Model struct {
+	Provider llm.Provider
+	Intents  []Intent
+}
+
+// NewModel returns a classifier asking provider which of intents an
+// utterance has.
+func NewModel(provider llm.Provider, intents []Intent) *Model {
+	return &Model{Provider: provider, Intents: intents}
+}
+
+// Classify asks the model for text's intent. A reply that isn't one of the
+// intents' names is taken as none of them.
+func (m *Model) Classify(ctx context.Context, text string) (string, error) {
+	zero := 0.0
+	resp, err := llm.Chat(ctx, m.Provider, llm.Request{
+		System:      m.prompt(),
+		Messages:    []llm.Message{{Role: llm.RoleUser, Content: text}},
+		MaxTokens:   16,
+		Temperature: &zero,
+	})
+	if err != nil {
+		return "", fmt.Errorf("intent: %w", err)
+	}
+	reply := strings.Trim(strings.TrimSpace(resp.Text), `"'.`)
+	for _, in := range m.Intents {
+		if strings.EqualFold(reply, in.Name) {
+			return in.Name, nil
+		}
+	}
+	return "", nil
+}
+
+// prompt asks the model to name the intent and nothing else.
+func (m *Model) prompt() string {
+	var b strings.Builder
+	b.WriteString("You classify what a caller said on a phone call. Reply with the name of the one intent below that covers everything they said, and nothing else. If none does, or they asked for anything more, reply none.\n\nIntents:")
+	for _, in := range m.Intents {
+		fmt.Fprintf(&b, "\n- %s: %s", in.Name, in.Description)
+	}
+	return b.String()
+}
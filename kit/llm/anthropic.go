@@ -0,0 +1,199 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Anthropic is the Anthropic Messages API.
+type Anthropic struct {
+	APIKey string
+	Model  string
+	// BaseURL defaults to https://api.anthropic.com.
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+// NewAnthropic returns an Anthropic provider using model by default.
+func NewAnthropic(apiKey, model string) *Anthropic {
+	return &Anthropic{APIKey: apiKey, Model: model, BaseURL: "https://api.anthropic.com"}
+}
+
+// Name returns "anthropic".
+func (p *Anthropic) Name() string { return "anthropic" }
+
+type anthropicBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   string          `json:"content,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    string           `json:"role"`
+	Content []anthropicBlock `json:"content"`
+}
+
+type anthropicTool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+type anthropicRequest struct {
+	Model       string             `json:"model"`
+	MaxTokens   int                `json:"max_tokens"`
+	System      string             `json:"system,omitempty"`
+	Messages    []anthropicMessage `json:"messages"`
+	Tools       []anthropicTool    `json:"tools,omitempty"`
+	Temperature *float64           `json:"temperature,omitempty"`
+	Stream      bool               `json:"stream"`
+}
+
+type anthropicUsage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type anthropicEvent struct {
+	Type    string `json:"type"`
+	Index   int    `json:"index"`
+	Message struct {
+		Usage anthropicUsage `json:"usage"`
+	} `json:"message"`
+	ContentBlock anthropicBlock `json:"content_block"`
+	Delta        struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJSON string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage anthropicUsage `json:"usage"`
+	Error struct {
+		Type    string `json:"type"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// Stream sends a streaming Messages request.
+func (p *Anthropic) Stream(ctx context.Context, req Request, onText func(string)) (*Response, error) {
+	body := anthropicRequest{
+		Model:       firstNonEmpty(req.Model, p.Model),
+		MaxTokens:   req.maxTokens(),
+		System:      req.System,
+		Messages:    anthropicMessages(req.Messages),
+		Temperature: req.Temperature,
+		Stream:      true,
+	}
+	for _, t := range req.Tools {
+		body.Tools = append(body.Tools, anthropicTool{Name: t.Name, Description: t.Description, InputSchema: rawArguments(t.Parameters)})
+	}
+
+	header := http.Header{}
+	header.Set("X-Api-Key", p.APIKey)
+	header.Set("Anthropic-Version", "2023-06-01")
+	resp, err := postJSON(ctx, p.HTTPClient, p.Name(), strings.TrimSuffix(p.BaseURL, "/")+"/v1/messages", header, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var out Response
+	var text strings.Builder
+	toolInput := make(map[int]*strings.Builder)
+	toolIndex := make(map[int]int)
+	err = readSSE(resp.Body, func(_, data string) error {
+		var ev anthropicEvent
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			return err
+		}
+		switch ev.Type {
+		case "message_start":
+			out.Usage.InputTokens = ev.Message.Usage.InputTokens
+			out.Usage.OutputTokens = ev.Message.Usage.OutputTokens
+		case "content_block_start":
+			if ev.ContentBlock.Type == "tool_use" {
+				toolIndex[ev.Index] = len(out.ToolCalls)
+				toolInput[ev.Index] = &strings.Builder{}
+				out.ToolCalls = append(out.ToolCalls, ToolCall{ID: ev.ContentBlock.ID, Name: ev.ContentBlock.Name})
+			}
+		case "content_block_delta":
+			switch ev.Delta.Type {
+			case "text_delta":
+				text.WriteString(ev.Delta.Text)
+				if onText != nil {
+					onText(ev.Delta.Text)
+				}
+			case "input_json_delta":
+				if b, ok := toolInput[ev.Index]; ok {
+					b.WriteString(ev.Delta.PartialJSON)
+				}
+			}
+		case "content_block_stop":
+			if b, ok := toolInput[ev.Index]; ok {
+				out.ToolCalls[toolIndex[ev.Index]].Arguments = rawArguments(json.RawMessage(b.String()))
+			}
+		case "message_delta":
+			out.StopReason = ev.Delta.StopReason
+			if ev.Usage.OutputTokens > 0 {
+				out.Usage.OutputTokens = ev.Usage.OutputTokens
+			}
+		case "error":
+			return errors.New("anthropic: " + ev.Error.Type + ": " + ev.Error.Message)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	out.Text = text.String()
+	return &out, nil
+}
+
+// anthropicMessages converts a conversation. Tool results are sent as
+// user messages, merged so roles keep alternating.
+func anthropicMessages(messages []Message) []anthropicMessage {
+	var out []anthropicMessage
+	for _, m := range messages {
+		var msg anthropicMessage
+		switch m.Role {
+		case RoleTool:
+			msg.Role = string(RoleUser)
+			msg.Content = []anthropicBlock{{Type: "tool_result", ToolUseID: m.ToolCallID, Content: m.Content}}
+		case RoleAssistant:
+			msg.Role = string(RoleAssistant)
+			if m.Content != "" {
+				msg.Content = append(msg.Content, anthropicBlock{Type: "text", Text: m.Content})
+			}
+			for _, call := range m.ToolCalls {
+				msg.Content = append(msg.Content, anthropicBlock{Type: "tool_use", ID: call.ID, Name: call.Name, Input: rawArguments(call.Arguments)})
+			}
+		default:
+			msg.Role = string(RoleUser)
+			msg.Content = []anthropicBlock{{Type: "text", Text: m.Content}}
+		}
+
+		if n := len(out); n > 0 && out[n-1].Role == msg.Role {
+			out[n-1].Content = append(out[n-1].Content, msg.Content...)
+			continue
+		}
+		out = append(out, msg)
+	}
+	return out
+}
+
+// firstNonEmpty returns the first non-empty value.
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,252 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of a Breaker's circuit.
+type BreakerState int
+
+const (
+	// BreakerClosed sends requests to the primary.
+	BreakerClosed BreakerState = iota
+	// BreakerOpen sends requests to the fallback until the cooldown ends.
+	BreakerOpen
+	// BreakerHalfOpen lets one request through to the primary to see
+	// whether it has recovered; the rest go to the fallback meanwhile.
+	BreakerHalfOpen
+)
+
+func (s BreakerState) String() string {
+	switch s {
+	case BreakerOpen:
+		return "open"
+	case BreakerHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// BreakerConfig sets when a Breaker opens and how long it stays open.
+type BreakerConfig struct {
+	// MaxP95 is the highest acceptable 95th percentile time to first
+	// token (or to the whole response, for one without text) over the
+	// last Window primary requests, once there are MinSamples of them.
+	MaxP95     time.Duration
+	Window     int
+	MinSamples int
+	// MaxErrors is how many primary requests in a row may fail.
+	MaxErrors int
+	// Cooldown is how long the circuit stays open before the primary is
+	// tried again.
+	Cooldown time.Duration
+}
+
+// DefaultBreakerConfig opens the circuit when the p95 time to first token
+// of the last 20 requests exceeds 2s, or 3 requests in a row fail, for
+// 30s at a time.
+func DefaultBreakerConfig() BreakerConfig {
+	return BreakerConfig{
+		MaxP95:     2 * time.Second,
+		Window:     20,
+		MinSamples: 5,
+		MaxErrors:  3,
+		Cooldown:   30 * time.Second,
+	}
+}
+
+// Breaker is a Provider that sends requests to a primary model until it
+// gets slow or keeps failing, then to a fallback (typically a smaller,
+// faster model) until the primary recovers. A request the primary fails
+// before producing any text is answered by the fallback instead.
+//
+// Requests sent to the fallback leave Request.Model empty, so it answers
+// with its own model.
+type Breaker struct {
+	Primary, Fallback Provider
+	cfg               BreakerConfig
+	now               func() time.Time
+
+	// OnStateChange, if set, is called when the circuit changes state,
+	// with the reason.
+	OnStateChange func(from, to BreakerState, reason string)
+
+	mu        sync.Mutex
+	state     BreakerState
+	openedAt  time.Time
+	latencies []time.Duration // ring of the last cfg.Window primary requests
+	next      int
+	errors    int // primary failures in a row
+}
+
+var _ Provider = (*Breaker)(nil)
+
+// NewBreaker returns a breaker between primary and fallback.
+func NewBreaker(primary, fallback Provider, cfg BreakerConfig) *Breaker {
+	return &Breaker{Primary: primary, Fallback: fallback, cfg: cfg, now: time.Now}
+}
+
+// Name returns the primary's name.
+func (b *Breaker) Name() string { return b.Primary.Name() }
+
+// State returns the circuit's state.
+func (b *Breaker) State() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// Stream sends req to the primary while the circuit is closed, or while
+// probing it half-open, and otherwise to the fallback.
+func (b *Breaker) Stream(ctx context.Context, req Request, onText func(text string)) (*Response, error) {
+	primary, probe := b.route()
+	if !primary {
+		return b.Fallback.Stream(ctx, fallbackRequest(req), onText)
+	}
+
+	start := b.now()
+	var firstToken time.Duration
+	texted := false
+	resp, err := b.Primary.Stream(ctx, req, func(text string) {
+		if !texted {
+			texted, firstToken = true, b.now().Sub(start)
+		}
+		if onText != nil {
+			onText(text)
+		}
+	})
+	switch {
+	case err != nil && ctx.Err() != nil:
+		// Cancelled by the caller, not the primary's fault
+		b.abandon(probe)
+		return resp, err
+	case err != nil:
+		b.failure(probe, err)
+		if texted {
+			// Part of the reply is out; the fallback can't take it over
+			return resp, err
+		}
+		return b.Fallback.Stream(ctx, fallbackRequest(req), onText)
+	}
+	if !texted {
+		firstToken = b.now().Sub(start)
+	}
+	b.success(probe, firstToken)
+	return resp, nil
+}
+
+// fallbackRequest is req for the fallback, with its own model.
+func fallbackRequest(req Request) Request {
+	req.Model = ""
+	return req
+}
+
+// route reports whether a request goes to the primary, and whether it is
+// the half-open circuit's probe.
+func (b *Breaker) route() (primary, probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case BreakerClosed:
+		return true, false
+	case BreakerOpen:
+		if b.now().Sub(b.openedAt) < b.cfg.Cooldown {
+			return false, false
+		}
+		b.state = BreakerHalfOpen
+		return true, true
+	default:
+		// A probe is already out
+		return false, false
+	}
+}
+
+// success records a primary request that answered after latency.
+func (b *Breaker) success(probe bool, latency time.Duration) {
+	b.mu.Lock()
+	b.errors = 0
+	if probe {
+		if latency > b.cfg.MaxP95 {
+			reason := fmt.Sprintf("probe took %s", latency.Round(time.Millisecond))
+			change := b.moveTo(BreakerOpen, reason)
+			b.mu.Unlock()
+			change()
+			return
+		}
+		// Start the window afresh, so the slow requests that opened the
+		// circuit don't open it again
+		b.latencies, b.next = nil, 0
+		b.latencies = append(b.latencies, latency)
+		change := b.moveTo(BreakerClosed, fmt.Sprintf("probe took %s", latency.Round(time.Millisecond)))
+		b.mu.Unlock()
+		change()
+		return
+	}
+
+	if len(b.latencies) < b.cfg.Window {
+		b.latencies = append(b.latencies, latency)
+	} else {
+		b.latencies[b.next] = latency
+		b.next = (b.next + 1) % len(b.latencies)
+	}
+	change := func() {}
+	if b.state == BreakerClosed && len(b.latencies) >= b.cfg.MinSamples {
+		if p95 := b.p95(); p95 > b.cfg.MaxP95 {
+			change = b.moveTo(BreakerOpen, fmt.Sprintf("p95 time to first token %s over %s", p95.Round(time.Millisecond), b.cfg.MaxP95))
+		}
+	}
+	b.mu.Unlock()
+	change()
+}
+
+// failure records a failed primary request.
+func (b *Breaker) failure(probe bool, err error) {
+	b.mu.Lock()
+	b.errors++
+	change := func() {}
+	switch {
+	case probe:
+		change = b.moveTo(BreakerOpen, fmt.Sprintf("probe failed: %v", err))
+	case b.state == BreakerClosed && b.errors >= b.cfg.MaxErrors:
+		change = b.moveTo(BreakerOpen, fmt.Sprintf("%d requests in a row failed, last: %v", b.errors, err))
+	}
+	b.mu.Unlock()
+	change()
+}
+
+// abandon records a primary request cancelled by the caller: a probe
+// proved nothing, so the next request probes again.
+func (b *Breaker) abandon(probe bool) {
+	if !probe {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.state = BreakerOpen
+}
+
+// moveTo changes state, returning a func that reports the change. b.mu
+// must be held; the func is called without it.
+func (b *Breaker) moveTo(state BreakerState, reason string) func() {
+	from := b.state
+	b.state = state
+	if state == BreakerOpen {
+		b.openedAt = b.now()
+	}
+	if from == state || b.OnStateChange == nil {
+		return func() {}
+	}
+	return func() { b.OnStateChange(from, state, reason) }
+}
+
+// p95 returns the 95th percentile of the window. b.mu must be held.
+func (b *Breaker) p95() time.Duration {
+	sorted := slices.Clone(b.latencies)
+	slices.Sort(sorted)
+	return sorted[min(len(sorted)*95/100, len(sorted)-1)]
+}
@@ -0,0 +1,21 @@
+// Package llm is a provider-agnostic client for chat language models,
+// covering what a voice agent needs: streamed text (so speech can start
+// early), tool calls, and token usage.
+//
+// Anthropic, OpenAI, Gemini and Ollama are supported over their HTTP APIs
+// using only the standard library:
+//
+//	p := llm.NewAnthropic(os.Getenv("ANTHROPIC_API_KEY"), "claude-sonnet-4-5")
+//	resp, err := p.Stream(ctx, llm.Request{
+//		System:   "You are a helpful phone assistant.",
+//		Messages: []llm.Message{{Role: llm.RoleUser, Content: "Hi!"}},
+//	}, func(text string) { fmt.Print(text) })
+//
+// Tool calls come back on the Response; append them and their results to
+// the conversation and call Stream again to continue.
+//
+// Breaker and Hedged keep response times predictable when a provider
+// slows down: Breaker moves requests to a faster fallback model while the
+// primary's time to first token is too high or it keeps failing, and
+// Hedged races a backup against a primary that is slow to start.
+package llm
@@ -0,0 +1,54 @@
+package llm
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// FromEnv returns the named provider ("anthropic", "openai", "gemini" or
+// "ollama") configured from its conventional environment variables:
+// ANTHROPIC_API_KEY, OPENAI_API_KEY (and optional OPENAI_BASE_URL),
+// GEMINI_API_KEY, or OLLAMA_HOST. An empty model selects a default.
+func FromEnv(name, model string) (Provider, error) {
+	switch name {
+	case "anthropic":
+		key, err := requireEnv("ANTHROPIC_API_KEY")
+		if err != nil {
+			return nil, err
+		}
+		return NewAnthropic(key, firstNonEmpty(model, "claude-sonnet-4-5")), nil
+	case "openai":
+		key, err := requireEnv("OPENAI_API_KEY")
+		if err != nil {
+			return nil, err
+		}
+		p := NewOpenAI(key, firstNonEmpty(model, "gpt-4o-mini"))
+		if base := os.Getenv("OPENAI_BASE_URL"); base != "" {
+			p.BaseURL = base
+		}
+		return p, nil
+	case "gemini":
+		key, err := requireEnv("GEMINI_API_KEY")
+		if err != nil {
+			return nil, err
+		}
+		return NewGemini(key, firstNonEmpty(model, "gemini-2.5-flash")), nil
+	case "ollama":
+		host := os.Getenv("OLLAMA_HOST")
+		if host != "" && !strings.Contains(host, "://") {
+			host = "http://" + host
+		}
+		return NewOllama(host, firstNonEmpty(model, "llama3.2")), nil
+	default:
+		return nil, fmt.Errorf("unknown LLM provider %q (want anthropic, openai, gemini or ollama)", name)
+	}
+}
+
+func requireEnv(name string) (string, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return "", fmt.Errorf("%s environment variable required", name)
+	}
+	return v, nil
+}
@@ -0,0 +1,196 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Gemini is the Google Gemini API.
+type Gemini struct {
+	APIKey string
+	Model  string
+	// BaseURL defaults to https://generativelanguage.googleapis.com/v1beta.
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+// NewGemini returns a Gemini provider using model by default.
+func NewGemini(apiKey, model string) *Gemini {
+	return &Gemini{APIKey: apiKey, Model: model, BaseURL: "https://generativelanguage.googleapis.com/v1beta"}
+}
+
+// Name returns "gemini".
+func (p *Gemini) Name() string { return "gemini" }
+
+type geminiFunctionCall struct {
+	ID   string          `json:"id,omitempty"`
+	Name string          `json:"name"`
+	Args json.RawMessage `json:"args,omitempty"`
+}
+
+type geminiFunctionResponse struct {
+	ID       string          `json:"id,omitempty"`
+	Name     string          `json:"name"`
+	Response json.RawMessage `json:"response"`
+}
+
+type geminiPart struct {
+	Text             string                  `json:"text,omitempty"`
+	FunctionCall     *geminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *geminiFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+type geminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []geminiPart `json:"parts"`
+}
+
+type geminiFunctionDeclaration struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+}
+
+type geminiRequest struct {
+	Contents          []geminiContent `json:"contents"`
+	SystemInstruction *geminiContent  `json:"systemInstruction,omitempty"`
+	Tools             []struct {
+		FunctionDeclarations []geminiFunctionDeclaration `json:"functionDeclarations"`
+	} `json:"tools,omitempty"`
+	GenerationConfig struct {
+		MaxOutputTokens int      `json:"maxOutputTokens,omitempty"`
+		Temperature     *float64 `json:"temperature,omitempty"`
+	} `json:"generationConfig"`
+}
+
+type geminiChunk struct {
+	Candidates []struct {
+		Content      geminiContent `json:"content"`
+		FinishReason string        `json:"finishReason"`
+	} `json:"candidates"`
+	UsageMetadata struct {
+		PromptTokenCount     int `json:"promptTokenCount"`
+		CandidatesTokenCount int `json:"candidatesTokenCount"`
+	} `json:"usageMetadata"`
+}
+
+// Stream sends a streamGenerateContent request.
+func (p *Gemini) Stream(ctx context.Context, req Request, onText func(string)) (*Response, error) {
+	var body geminiRequest
+	body.Contents = geminiContents(req.Messages)
+	if req.System != "" {
+		body.SystemInstruction = &geminiContent{Parts: []geminiPart{{Text: req.System}}}
+	}
+	if len(req.Tools) > 0 {
+		var decls []geminiFunctionDeclaration
+		for _, t := range req.Tools {
+			decls = append(decls, geminiFunctionDeclaration{Name: t.Name, Description: t.Description, Parameters: t.Parameters})
+		}
+		body.Tools = append(body.Tools, struct {
+			FunctionDeclarations []geminiFunctionDeclaration `json:"functionDeclarations"`
+		}{decls})
+	}
+	body.GenerationConfig.MaxOutputTokens = req.MaxTokens
+	body.GenerationConfig.Temperature = req.Temperature
+
+	model := firstNonEmpty(req.Model, p.Model)
+	endpoint := fmt.Sprintf("%s/models/%s:streamGenerateContent?alt=sse", strings.TrimSuffix(p.BaseURL, "/"), url.PathEscape(model))
+	header := http.Header{}
+	header.Set("X-Goog-Api-Key", p.APIKey)
+	resp, err := postJSON(ctx, p.HTTPClient, p.Name(), endpoint, header, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var out Response
+	var text strings.Builder
+	err = readSSE(resp.Body, func(_, data string) error {
+		var chunk geminiChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return err
+		}
+		if chunk.UsageMetadata.PromptTokenCount > 0 || chunk.UsageMetadata.CandidatesTokenCount > 0 {
+			out.Usage = Usage{InputTokens: chunk.UsageMetadata.PromptTokenCount, OutputTokens: chunk.UsageMetadata.CandidatesTokenCount}
+		}
+		for _, candidate := range chunk.Candidates {
+			for _, part := range candidate.Content.Parts {
+				if part.Text != "" {
+					text.WriteString(part.Text)
+					if onText != nil {
+						onText(part.Text)
+					}
+				}
+				if fc := part.FunctionCall; fc != nil {
+					// Older models don't return call IDs; the name stands in.
+					out.ToolCalls = append(out.ToolCalls, ToolCall{ID: firstNonEmpty(fc.ID, fc.Name), Name: fc.Name, Arguments: rawArguments(fc.Args)})
+				}
+			}
+			if candidate.FinishReason != "" {
+				out.StopReason = candidate.FinishReason
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	out.Text = text.String()
+	return &out, nil
+}
+
+// geminiContents converts a conversation. Gemini calls the assistant
+// "model" and carries tool results as function responses from the user.
+func geminiContents(messages []Message) []geminiContent {
+	var out []geminiContent
+	for _, m := range messages {
+		var c geminiContent
+		switch m.Role {
+		case RoleAssistant:
+			c.Role = "model"
+			if m.Content != "" {
+				c.Parts = append(c.Parts, geminiPart{Text: m.Content})
+			}
+			for _, call := range m.ToolCalls {
+				id := call.ID
+				if id == call.Name {
+					id = ""
+				}
+				c.Parts = append(c.Parts, geminiPart{FunctionCall: &geminiFunctionCall{ID: id, Name: call.Name, Args: rawArguments(call.Arguments)}})
+			}
+		case RoleTool:
+			c.Role = "user"
+			name := firstNonEmpty(toolName(messages, m.ToolCallID), m.ToolCallID)
+			id := m.ToolCallID
+			if id == name {
+				id = ""
+			}
+			c.Parts = []geminiPart{{FunctionResponse: &geminiFunctionResponse{ID: id, Name: name, Response: geminiToolResult(m.Content)}}}
+		default:
+			c.Role = "user"
+			c.Parts = []geminiPart{{Text: m.Content}}
+		}
+
+		if n := len(out); n > 0 && out[n-1].Role == c.Role {
+			out[n-1].Parts = append(out[n-1].Parts, c.Parts...)
+			continue
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// geminiToolResult wraps a tool result in the object Gemini requires,
+// passing JSON objects through unchanged.
+func geminiToolResult(content string) json.RawMessage {
+	trimmed := strings.TrimSpace(content)
+	if strings.HasPrefix(trimmed, "{") && json.Valid([]byte(trimmed)) {
+		return json.RawMessage(trimmed)
+	}
+	data, _ := json.Marshal(map[string]string{"content": content})
+	return data
+}
@@ -0,0 +1,143 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// Hedged is a Provider that sends each request to Primary and, if it has
+// not started answering within Delay, to Backup as well, taking whichever
+// answers first and cancelling the other. A request Primary fails before
+// answering goes to Backup at once. Hedging trades the cost of some
+// duplicate requests for a bounded wait on a slow primary.
+//
+// Requests sent to Backup leave Request.Model empty, so it answers with
+// its own model.
+type Hedged struct {
+	Primary, Backup Provider
+	// Delay is how long Primary has to start answering; 0 sends every
+	// request to both at once.
+	Delay time.Duration
+
+	// OnHedge, if set, is called for each request sent to Backup once it
+	// is known which answered, reporting whether Backup did.
+	OnHedge func(backupWon bool)
+}
+
+var _ Provider = (*Hedged)(nil)
+
+// NewHedged returns a provider hedging primary's requests with backup
+// after delay.
+func NewHedged(primary, backup Provider, delay time.Duration) *Hedged {
+	return &Hedged{Primary: primary, Backup: backup, Delay: delay}
+}
+
+// Name returns the primary's name.
+func (h *Hedged) Name() string { return h.Primary.Name() }
+
+// hedgeResult is one provider's outcome.
+type hedgeResult struct {
+	backup bool
+	resp   *Response
+	err    error
+}
+
+// Stream races the providers. Only the winner's text reaches onText: the
+// first to produce text, or to finish, wins.
+func (h *Hedged) Stream(ctx context.Context, req Request, onText func(text string)) (*Response, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var mu sync.Mutex
+	winner := -1 // 0 primary, 1 backup
+	var cancels [2]context.CancelFunc
+	// claim makes i the winner if there is none yet, cancelling the other.
+	claim := func(i int) bool {
+		mu.Lock()
+		defer mu.Unlock()
+		if winner < 0 {
+			winner = i
+			if c := cancels[1-i]; c != nil {
+				c()
+			}
+		}
+		return winner == i
+	}
+
+	results := make(chan hedgeResult, 2)
+	start := func(i int) {
+		p, r := h.Primary, req
+		if i == 1 {
+			p, r = h.Backup, fallbackRequest(req)
+		}
+		pctx, pcancel := context.WithCancel(ctx)
+		mu.Lock()
+		cancels[i] = pcancel
+		mu.Unlock()
+		go func() {
+			defer pcancel()
+			resp, err := p.Stream(pctx, r, func(text string) {
+				if claim(i) && onText != nil {
+					onText(text)
+				}
+			})
+			results <- hedgeResult{backup: i == 1, resp: resp, err: err}
+		}()
+	}
+
+	start(0)
+	pending, hedged := 1, false
+	hedge := func() {
+		start(1)
+		pending++
+		hedged = true
+	}
+	timer := time.NewTimer(h.Delay)
+	defer timer.Stop()
+
+	var errs []error
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			mu.Lock()
+			undecided := winner < 0
+			mu.Unlock()
+			if undecided && !hedged {
+				hedge()
+			}
+		case r := <-results:
+			pending--
+			i := 0
+			if r.backup {
+				i = 1
+			}
+			if r.err == nil && claim(i) {
+				h.reportHedge(hedged, r.backup)
+				return r.resp, nil
+			}
+			mu.Lock()
+			won := winner == i
+			mu.Unlock()
+			if won {
+				// Failed partway through its answer; the other can't take over
+				h.reportHedge(hedged, r.backup)
+				return r.resp, r.err
+			}
+			if r.err != nil {
+				errs = append(errs, r.err)
+			}
+			if !hedged && ctx.Err() == nil {
+				hedge()
+			}
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+func (h *Hedged) reportHedge(hedged, backupWon bool) {
+	if hedged && h.OnHedge != nil {
+		h.OnHedge(backupWon)
+	}
+}
@@ -0,0 +1,204 @@
+package llm
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// defaultMaxTokens caps replies when a request doesn't set MaxTokens. Spoken
+// replies are short; some APIs require a limit.
+const defaultMaxTokens = 1024
+
+// Role is the author of a message.
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleAssistant Role = "assistant"
+	// RoleTool messages carry the result of a tool call.
+	RoleTool Role = "tool"
+)
+
+// Message is one entry of a conversation.
+type Message struct {
+	Role    Role
+	Content string
+	// ToolCalls are the calls an assistant message made.
+	ToolCalls []ToolCall
+	// ToolCallID identifies the call a RoleTool message answers.
+	ToolCallID string
+}
+
+// Tool describes a function the model may call.
+type Tool struct {
+	Name        string
+	Description string
+	// Parameters is a JSON Schema object for the arguments.
+	Parameters json.RawMessage
+}
+
+// ToolCall is a model's request to call a tool.
+type ToolCall struct {
+	ID        string
+	Name      string
+	Arguments json.RawMessage
+}
+
+// Request is a chat request.
+type Request struct {
+	// Model overrides the provider's default model.
+	Model       string
+	System      string
+	Messages    []Message
+	Tools       []Tool
+	MaxTokens   int
+	Temperature *float64
+}
+
+// Usage is the tokens a request consumed.
+type Usage struct {
+	InputTokens  int
+	OutputTokens int
+}
+
+// Response is a complete reply.
+type Response struct {
+	Text      string
+	ToolCalls []ToolCall
+	Usage     Usage
+	// StopReason is the provider's reason for stopping, e.g. "end_turn",
+	// "stop" or "tool_use".
+	StopReason string
+}
+
+// Message returns the response as an assistant message, for appending to
+// the conversation.
+func (r *Response) Message() Message {
+	return Message{Role: RoleAssistant, Content: r.Text, ToolCalls: r.ToolCalls}
+}
+
+// Provider is a chat model API.
+type Provider interface {
+	// Name identifies the provider ("anthropic", "openai", ...).
+	Name() string
+	// Stream sends a request, calling onText (if non-nil) with each piece
+	// of text as it is generated, and returns the complete response.
+	Stream(ctx context.Context, req Request, onText func(text string)) (*Response, error)
+}
+
+// Chat sends a request and waits for the complete response.
+func Chat(ctx context.Context, p Provider, req Request) (*Response, error) {
+	return p.Stream(ctx, req, nil)
+}
+
+// APIError is an error response from a provider.
+type APIError struct {
+	Provider   string
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("%s: HTTP %d: %s", e.Provider, e.StatusCode, e.Message)
+}
+
+// postJSON sends body as JSON and returns the response, converting non-2xx
+// statuses to an APIError.
+func postJSON(ctx context.Context, client *http.Client, provider, url string, header http.Header, body any) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, &APIError{Provider: provider, StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(msg))}
+	}
+	return resp, nil
+}
+
+// readSSE calls fn with the event name and data of each Server-Sent Event.
+func readSSE(r io.Reader, fn func(event, data string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+
+	var event string
+	var data strings.Builder
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if data.Len() > 0 {
+				if err := fn(event, data.String()); err != nil {
+					return err
+				}
+			}
+			event = ""
+			data.Reset()
+		case strings.HasPrefix(line, "event:"):
+			event = strings.TrimSpace(line[len("event:"):])
+		case strings.HasPrefix(line, "data:"):
+			if data.Len() > 0 {
+				data.WriteByte('\n')
+			}
+			data.WriteString(strings.TrimPrefix(line[len("data:"):], " "))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if data.Len() > 0 {
+		return fn(event, data.String())
+	}
+	return nil
+}
+
+// maxTokens returns the request's token limit or the default.
+func (r Request) maxTokens() int {
+	if r.MaxTokens > 0 {
+		return r.MaxTokens
+	}
+	return defaultMaxTokens
+}
+
+// rawArguments returns args, or an empty object when a tool takes none.
+func rawArguments(args json.RawMessage) json.RawMessage {
+	if len(bytes.TrimSpace(args)) == 0 {
+		return json.RawMessage("{}")
+	}
+	return args
+}
+
+// toolName finds the name of the tool call with id in the conversation.
+func toolName(messages []Message, id string) string {
+	for _, m := range messages {
+		for _, call := range m.ToolCalls {
+			if call.ID == id {
+				return call.Name
+			}
+		}
+	}
+	return ""
+}
@@ -0,0 +1,131 @@
+package llm
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// Ollama is a local Ollama server's chat API.
+type Ollama struct {
+	Model string
+	// BaseURL defaults to http://localhost:11434.
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+// NewOllama returns an Ollama provider using model by default.
+func NewOllama(baseURL, model string) *Ollama {
+	return &Ollama{Model: model, BaseURL: firstNonEmpty(baseURL, "http://localhost:11434")}
+}
+
+// Name returns "ollama".
+func (p *Ollama) Name() string { return "ollama" }
+
+type ollamaToolCall struct {
+	Function struct {
+		Name      string          `json:"name"`
+		Arguments json.RawMessage `json:"arguments"`
+	} `json:"function"`
+}
+
+type ollamaMessage struct {
+	Role      string           `json:"role"`
+	Content   string           `json:"content"`
+	ToolCalls []ollamaToolCall `json:"tool_calls,omitempty"`
+	ToolName  string           `json:"tool_name,omitempty"`
+}
+
+type ollamaRequest struct {
+	Model    string          `json:"model"`
+	Messages []ollamaMessage `json:"messages"`
+	Tools    []openAITool    `json:"tools,omitempty"`
+	Stream   bool            `json:"stream"`
+	Options  struct {
+		NumPredict  int      `json:"num_predict,omitempty"`
+		Temperature *float64 `json:"temperature,omitempty"`
+	} `json:"options"`
+}
+
+type ollamaChunk struct {
+	Message         ollamaMessage `json:"message"`
+	Done            bool          `json:"done"`
+	DoneReason      string        `json:"done_reason"`
+	PromptEvalCount int           `json:"prompt_eval_count"`
+	EvalCount       int           `json:"eval_count"`
+	Error           string        `json:"error"`
+}
+
+// Stream sends a streaming chat request. Ollama streams newline-delimited
+// JSON rather than Server-Sent Events.
+func (p *Ollama) Stream(ctx context.Context, req Request, onText func(string)) (*Response, error) {
+	body := ollamaRequest{
+		Model:  firstNonEmpty(req.Model, p.Model),
+		Tools:  openAITools(req.Tools),
+		Stream: true,
+	}
+	body.Options.NumPredict = req.MaxTokens
+	body.Options.Temperature = req.Temperature
+	if req.System != "" {
+		body.Messages = append(body.Messages, ollamaMessage{Role: "system", Content: req.System})
+	}
+	for _, m := range req.Messages {
+		msg := ollamaMessage{Role: string(m.Role), Content: m.Content}
+		if m.Role == RoleTool {
+			msg.ToolName = toolName(req.Messages, m.ToolCallID)
+		}
+		for _, call := range m.ToolCalls {
+			var tc ollamaToolCall
+			tc.Function.Name = call.Name
+			tc.Function.Arguments = rawArguments(call.Arguments)
+			msg.ToolCalls = append(msg.ToolCalls, tc)
+		}
+		body.Messages = append(body.Messages, msg)
+	}
+
+	resp, err := postJSON(ctx, p.HTTPClient, p.Name(), strings.TrimSuffix(p.BaseURL, "/")+"/api/chat", nil, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var out Response
+	var text strings.Builder
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var chunk ollamaChunk
+		if err := json.Unmarshal([]byte(line), &chunk); err != nil {
+			return nil, err
+		}
+		if chunk.Error != "" {
+			return nil, errors.New("ollama: " + chunk.Error)
+		}
+		if c := chunk.Message.Content; c != "" {
+			text.WriteString(c)
+			if onText != nil {
+				onText(c)
+			}
+		}
+		for _, tc := range chunk.Message.ToolCalls {
+			// Ollama doesn't assign call IDs; the name stands in.
+			out.ToolCalls = append(out.ToolCalls, ToolCall{ID: tc.Function.Name, Name: tc.Function.Name, Arguments: rawArguments(tc.Function.Arguments)})
+		}
+		if chunk.Done {
+			out.StopReason = chunk.DoneReason
+			out.Usage = Usage{InputTokens: chunk.PromptEvalCount, OutputTokens: chunk.EvalCount}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	out.Text = text.String()
+	return &out, nil
+}
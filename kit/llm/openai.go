@@ -0,0 +1,199 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// OpenAI is the OpenAI Chat Completions API, or any compatible endpoint
+// (set BaseURL).
+type OpenAI struct {
+	APIKey string
+	Model  string
+	// BaseURL defaults to https://api.openai.com/v1.
+	BaseURL    string
+	HTTPClient *http.Client
+}
+
+// NewOpenAI returns an OpenAI provider using model by default.
+func NewOpenAI(apiKey, model string) *OpenAI {
+	return &OpenAI{APIKey: apiKey, Model: model, BaseURL: "https://api.openai.com/v1"}
+}
+
+// Name returns "openai".
+func (p *OpenAI) Name() string { return "openai" }
+
+type openAIFunctionCall struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments,omitempty"`
+}
+
+type openAIToolCall struct {
+	ID       string             `json:"id,omitempty"`
+	Type     string             `json:"type,omitempty"`
+	Function openAIFunctionCall `json:"function"`
+}
+
+type openAIMessage struct {
+	Role       string           `json:"role"`
+	Content    string           `json:"content"`
+	ToolCalls  []openAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+type openAITool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string          `json:"name"`
+		Description string          `json:"description,omitempty"`
+		Parameters  json.RawMessage `json:"parameters"`
+	} `json:"function"`
+}
+
+type openAIRequest struct {
+	Model         string          `json:"model"`
+	Messages      []openAIMessage `json:"messages"`
+	Tools         []openAITool    `json:"tools,omitempty"`
+	MaxTokens     int             `json:"max_completion_tokens,omitempty"`
+	Temperature   *float64        `json:"temperature,omitempty"`
+	Stream        bool            `json:"stream"`
+	StreamOptions struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options"`
+}
+
+type openAIChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content   string `json:"content"`
+			ToolCalls []struct {
+				Index int `json:"index"`
+				openAIToolCall
+			} `json:"tool_calls"`
+		} `json:"delta"`
+		FinishReason string `json:"finish_reason"`
+	} `json:"choices"`
+	Usage *struct {
+		PromptTokens     int `json:"prompt_tokens"`
+		CompletionTokens int `json:"completion_tokens"`
+	} `json:"usage"`
+}
+
+// Stream sends a streaming Chat Completions request.
+func (p *OpenAI) Stream(ctx context.Context, req Request, onText func(string)) (*Response, error) {
+	body := openAIRequest{
+		Model:       firstNonEmpty(req.Model, p.Model),
+		Messages:    openAIMessages(req.System, req.Messages),
+		Tools:       openAITools(req.Tools),
+		MaxTokens:   req.MaxTokens,
+		Temperature: req.Temperature,
+		Stream:      true,
+	}
+	body.StreamOptions.IncludeUsage = true
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+p.APIKey)
+	resp, err := postJSON(ctx, p.HTTPClient, p.Name(), strings.TrimSuffix(p.BaseURL, "/")+"/chat/completions", header, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var out Response
+	var text strings.Builder
+	calls := make(map[int]*ToolCall)
+	args := make(map[int]*strings.Builder)
+	err = readSSE(resp.Body, func(_, data string) error {
+		if data == "[DONE]" {
+			return nil
+		}
+		var chunk openAIChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return err
+		}
+		if chunk.Usage != nil {
+			out.Usage = Usage{InputTokens: chunk.Usage.PromptTokens, OutputTokens: chunk.Usage.CompletionTokens}
+		}
+		for _, choice := range chunk.Choices {
+			if choice.Delta.Content != "" {
+				text.WriteString(choice.Delta.Content)
+				if onText != nil {
+					onText(choice.Delta.Content)
+				}
+			}
+			for _, tc := range choice.Delta.ToolCalls {
+				call, ok := calls[tc.Index]
+				if !ok {
+					call = &ToolCall{}
+					calls[tc.Index] = call
+					args[tc.Index] = &strings.Builder{}
+				}
+				if tc.ID != "" {
+					call.ID = tc.ID
+				}
+				if tc.Function.Name != "" {
+					call.Name = tc.Function.Name
+				}
+				args[tc.Index].WriteString(tc.Function.Arguments)
+			}
+			if choice.FinishReason != "" {
+				out.StopReason = choice.FinishReason
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	indexes := make([]int, 0, len(calls))
+	for i := range calls {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	for _, i := range indexes {
+		call := *calls[i]
+		call.Arguments = rawArguments(json.RawMessage(args[i].String()))
+		out.ToolCalls = append(out.ToolCalls, call)
+	}
+	out.Text = text.String()
+	return &out, nil
+}
+
+// openAIMessages converts a conversation, with the system prompt first.
+func openAIMessages(system string, messages []Message) []openAIMessage {
+	var out []openAIMessage
+	if system != "" {
+		out = append(out, openAIMessage{Role: "system", Content: system})
+	}
+	for _, m := range messages {
+		msg := openAIMessage{Role: string(m.Role), Content: m.Content, ToolCallID: m.ToolCallID}
+		for _, call := range m.ToolCalls {
+			msg.ToolCalls = append(msg.ToolCalls, openAIToolCall{
+				ID:       call.ID,
+				Type:     "function",
+				Function: openAIFunctionCall{Name: call.Name, Arguments: string(rawArguments(call.Arguments))},
+			})
+		}
+		out = append(out, msg)
+	}
+	return out
+}
+
+// openAITools converts tool definitions to the function-calling format,
+// which Ollama also uses.
+func openAITools(tools []Tool) []openAITool {
+	var out []openAITool
+	for _, t := range tools {
+		var tool openAITool
+		tool.Type = "function"
+		tool.Function.Name = t.Name
+		tool.Function.Description = t.Description
+		tool.Function.Parameters = rawArguments(t.Parameters)
+		out = append(out, tool)
+	}
+	return out
+}
@@ -0,0 +1,217 @@
+// Package mail sends plain-text email through an SMTP server or the
+// SendGrid API, e.g. a call's summary and transcript once it ends:
+//
+//	var sender mail.Sender = &mail.SMTP{Addr: "smtp.example.com:587", Username: user, Password: pass}
+//	err := sender.Send(ctx, mail.Message{
+//		From:    "Voice Agent <agent@example.com>",
+//		To:      []string{"jane@example.com"},
+//		Subject: "Your call with Example Co",
+//		Text:    body,
+//	})
+//
+// Addresses are checked with net/mail before anything is sent, and header
+// values can't contain line breaks, so an address collected from a caller
+// can't add recipients or headers of its own.
+package mail
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/http"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// Message is a plain-text email.
+type Message struct {
+	// From is the sender, e.g. "Voice Agent <agent@example.com>".
+	From string
+	To   []string
+	// Subject may be any text; it is encoded as headers require.
+	Subject string
+	Text    string
+}
+
+// Sender sends email.
+type Sender interface {
+	Send(ctx context.Context, m Message) error
+}
+
+// ParseAddress reads a single email address, e.g. one a caller spelled
+// out, returning it without any display name.
+func ParseAddress(s string) (string, error) {
+	if strings.ContainsAny(s, "\r\n") {
+		return "", fmt.Errorf("invalid email address %q", s)
+	}
+	a, err := mail.ParseAddress(strings.TrimSpace(s))
+	if err != nil {
+		return "", fmt.Errorf("invalid email address %q", s)
+	}
+	return a.Address, nil
+}
+
+// parsed is a message with its addresses checked.
+type parsed struct {
+	from *mail.Address
+	to   []*mail.Address
+	Message
+}
+
+func (m Message) parse() (*parsed, error) {
+	if len(m.To) == 0 {
+		return nil, errors.New("mail: no recipients")
+	}
+	if strings.ContainsAny(m.From+m.Subject, "\r\n") || strings.ContainsAny(strings.Join(m.To, ""), "\r\n") {
+		return nil, errors.New("mail: line break in a header")
+	}
+	from, err := mail.ParseAddress(m.From)
+	if err != nil {
+		return nil, fmt.Errorf("mail: invalid From address %q: %w", m.From, err)
+	}
+	p := &parsed{from: from, Message: m}
+	for _, to := range m.To {
+		a, err := mail.ParseAddress(to)
+		if err != nil {
+			return nil, fmt.Errorf("mail: invalid To address %q: %w", to, err)
+		}
+		p.to = append(p.to, a)
+	}
+	return p, nil
+}
+
+// SMTP sends email through an SMTP server, upgrading the connection with
+// STARTTLS when the server offers it.
+type SMTP struct {
+	// Addr is the server's host:port, e.g. "smtp.example.com:587".
+	Addr string
+	// Username and Password, if set, authenticate with PLAIN, which
+	// net/smtp only allows over TLS or to localhost.
+	Username, Password string
+}
+
+// Send sends m. The context bounds connecting to the server.
+func (s *SMTP) Send(ctx context.Context, m Message) error {
+	p, err := m.parse()
+	if err != nil {
+		return err
+	}
+	host, _, err := net.SplitHostPort(s.Addr)
+	if err != nil {
+		return fmt.Errorf("mail: invalid SMTP address %q: %w", s.Addr, err)
+	}
+	var auth smtp.Auth
+	if s.Username != "" {
+		auth = smtp.PlainAuth("", s.Username, s.Password, host)
+	}
+	to := make([]string, len(p.to))
+	for i, a := range p.to {
+		to[i] = a.Address
+	}
+
+	// smtp.SendMail can't be cancelled, so it runs until it finishes or
+	// the context ends, whichever is first
+	done := make(chan error, 1)
+	go func() { done <- smtp.SendMail(s.Addr, auth, p.from.Address, to, p.rfc5322(time.Now())) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("mail: %w", err)
+		}
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("mail: %w", ctx.Err())
+	}
+}
+
+// rfc5322 renders the message with its headers, quoted-printable encoded.
+func (p *parsed) rfc5322(date time.Time) []byte {
+	to := make([]string, len(p.to))
+	for i, a := range p.to {
+		to[i] = a.String()
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", p.from)
+	fmt.Fprintf(&b, "To: %s\r\n", strings.Join(to, ", "))
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", p.Subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+	w := quotedprintable.NewWriter(&b)
+	_, _ = io.WriteString(w, strings.ReplaceAll(p.Text, "\n", "\r\n"))
+	_ = w.Close()
+	return b.Bytes()
+}
+
+// SendGridURL is the SendGrid v3 endpoint for sending mail.
+const SendGridURL = "https://api.sendgrid.com/v3/mail/send"
+
+// SendGrid sends email through the SendGrid API.
+type SendGrid struct {
+	APIKey string
+	// URL defaults to SendGridURL.
+	URL string
+	// Client defaults to one with a 10 second timeout.
+	Client *http.Client
+}
+
+var defaultHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+// Send sends m.
+func (s *SendGrid) Send(ctx context.Context, m Message) error {
+	p, err := m.parse()
+	if err != nil {
+		return err
+	}
+	type address struct {
+		Email string `json:"email"`
+		Name  string `json:"name,omitempty"`
+	}
+	var to []address
+	for _, a := range p.to {
+		to = append(to, address{Email: a.Address, Name: a.Name})
+	}
+	body, err := json.Marshal(map[string]any{
+		"personalizations": []map[string]any{{"to": to}},
+		"from":             address{Email: p.from.Address, Name: p.from.Name},
+		"subject":          m.Subject,
+		"content":          []map[string]string{{"type": "text/plain", "value": m.Text}},
+	})
+	if err != nil {
+		return err
+	}
+
+	url := s.URL
+	if url == "" {
+		url = SendGridURL
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.APIKey)
+	req.Header.Set("Content-Type", "application/json")
+	client := s.Client
+	if client == nil {
+		client = defaultHTTPClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("mail: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("mail: SendGrid API: %s: %s", resp.Status, msg)
+	}
+	return nil
+}
@@ -0,0 +1,321 @@
+// Package mediastream serves Twilio Media Streams through an
+// omnivoice-twilio transport, and gives sessions each stream's "start"
+// message: its call and stream SIDs and the custom parameters passed with
+// <Parameter> in the TwiML that started it.
+//
+// omnivoice-twilio parses the start message but keeps only the SIDs, and
+// hands a connection over before its start message has arrived. A Server
+// reads the start message off the wire as the transport receives it, and
+// hands each connection over once the transport has read it:
+//
+//	streams, err := mediastream.NewServer(ctx, twilioTransport, "/media-stream")
+//	http.Handle("/media-stream", streams)
+//	for conn := range streams.Connections() {
+//		params := conn.(*mediastream.Conn).CustomParameters()
+//		...
+//	}
+package mediastream
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"maps"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/agentplexus/omnivoice/transport"
+)
+
+// StartTimeout is how long a stream has to send its start message once
+// connected. Twilio sends it straight away.
+const StartTimeout = 10 * time.Second
+
+// maxBuffered bounds the bytes held while looking for the start message.
+const maxBuffered = 64 << 10
+
+// Transport is the part of an omnivoice-twilio transport a Server uses.
+type Transport interface {
+	Listen(ctx context.Context, path string) (<-chan transport.Connection, error)
+	HandleWebSocket(w http.ResponseWriter, r *http.Request, path string) error
+}
+
+// Start is a Media Stream's start message.
+type Start struct {
+	StreamSID        string            `json:"streamSid"`
+	AccountSID       string            `json:"accountSid"`
+	CallSID          string            `json:"callSid"`
+	Tracks           []string          `json:"tracks"`
+	MediaFormat      MediaFormat       `json:"mediaFormat"`
+	CustomParameters map[string]string `json:"customParameters"`
+}
+
+// MediaFormat is the audio format of a stream.
+type MediaFormat struct {
+	Encoding   string `json:"encoding"`
+	SampleRate int    `json:"sampleRate"`
+	Channels   int    `json:"channels"`
+}
+
+// Conn is a Media Streams connection whose stream has started.
+type Conn struct {
+	transport.Connection
+	start Start
+}
+
+// Start returns the stream's start message.
+func (c *Conn) Start() Start { return c.start }
+
+// ID returns the stream SID.
+func (c *Conn) ID() string { return c.start.StreamSID }
+
+// CallSID returns the SID of the call the stream belongs to.
+func (c *Conn) CallSID() string { return c.start.CallSID }
+
+// CustomParameters returns the parameters the stream was started with.
+func (c *Conn) CustomParameters() map[string]string { return maps.Clone(c.start.CustomParameters) }
+
+// Clear drops the audio sent to the caller that hasn't played yet, as a
+// barge-in does. The transport's own Clear would be hidden by the wrapping.
+func (c *Conn) Clear() error {
+	if cl, ok := c.Connection.(interface{ Clear() error }); ok {
+		return cl.Clear()
+	}
+	return errors.ErrUnsupported
+}
+
+// Server upgrades Media Streams requests through a transport and delivers
+// their connections, as *Conn, once their streams have started.
+type Server struct {
+	ctx       context.Context
+	transport Transport
+	path      string
+	accepted  <-chan transport.Connection
+	conns     chan transport.Connection
+
+	// mu serializes upgrades, so the connection the transport delivers
+	// after one is that request's.
+	mu sync.Mutex
+}
+
+// NewServer listens on path of t. Connections are delivered until ctx is
+// done.
+func NewServer(ctx context.Context, t Transport, path string) (*Server, error) {
+	accepted, err := t.Listen(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return &Server{
+		ctx:       ctx,
+		transport: t,
+		path:      path,
+		accepted:  accepted,
+		conns:     make(chan transport.Connection),
+	}, nil
+}
+
+// Connections returns the channel started streams are delivered on.
+func (s *Server) Connections() <-chan transport.Connection {
+	return s.conns
+}
+
+// ServeHTTP upgrades a Media Streams request and waits for its stream to
+// start. A stream that doesn't start in time is closed.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	wire := &startReader{started: make(chan struct{})}
+	s.mu.Lock()
+	err := s.transport.HandleWebSocket(&hijacker{ResponseWriter: w, conn: wire}, r, s.path)
+	var conn transport.Connection
+	if err == nil {
+		select {
+		case conn = <-s.accepted:
+		default:
+		}
+	}
+	s.mu.Unlock()
+	if err != nil {
+		slog.Error("WebSocket handling failed", "error", err)
+		return
+	}
+	if conn == nil {
+		// The transport had no listener, or dropped it over its backlog
+		return
+	}
+
+	timer := time.NewTimer(StartTimeout)
+	defer timer.Stop()
+	select {
+	case <-wire.started:
+	case <-timer.C:
+	case <-s.ctx.Done():
+	}
+	start := wire.Start()
+	if start == nil {
+		slog.Warn("closing Media Stream that never started", "remote_addr", r.RemoteAddr)
+		_ = conn.Close()
+		return
+	}
+	select {
+	case s.conns <- &Conn{Connection: conn, start: *start}:
+	case <-s.ctx.Done():
+		_ = conn.Close()
+	}
+}
+
+// hijacker hands the transport the hijacked connection wrapped in a
+// startReader.
+type hijacker struct {
+	http.ResponseWriter
+	conn *startReader
+}
+
+func (h *hijacker) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := h.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("mediastream: response does not implement http.Hijacker")
+	}
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	h.conn.Conn = conn
+	return h.conn, brw, nil
+}
+
+// startReader is a stream's WebSocket connection, watching the frames the
+// transport reads for the start message. Only the transport's read loop
+// reads from it.
+type startReader struct {
+	net.Conn
+
+	buf     []byte // the start of a frame not yet read in full
+	message []byte // a fragmented message so far
+	done    bool   // the start message was found, or won't be
+
+	mu    sync.Mutex
+	start *Start
+
+	// started is closed once the transport has read the start message, or
+	// the stream has ended or broken protocol without one.
+	started chan struct{}
+	once    sync.Once
+}
+
+// Start returns the start message, or nil if there was none.
+func (c *startReader) Start() *Start {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.start
+}
+
+func (c *startReader) Read(p []byte) (int, error) {
+	// The transport reads again only once it has handled every message
+	// it was given, the start message among them
+	if c.done {
+		c.signal()
+	}
+	n, err := c.Conn.Read(p)
+	if !c.done && n > 0 {
+		c.scan(p[:n])
+	}
+	if err != nil {
+		c.signal()
+	}
+	return n, err
+}
+
+func (c *startReader) signal() {
+	c.once.Do(func() { close(c.started) })
+}
+
+// scan looks for the start message in the frames read so far. Twilio sends
+// "connected" and then "start"; any other message first means there will
+// be no start message.
+func (c *startReader) scan(b []byte) {
+	c.buf = append(c.buf, b...)
+	for !c.done {
+		payload, opcode, fin, n := parseFrame(c.buf)
+		if n == 0 {
+			break
+		}
+		c.buf = c.buf[n:]
+		if opcode >= 0x8 {
+			// Control frames come between a message's fragments
+			continue
+		}
+		c.message = append(c.message, payload...)
+		if !fin {
+			continue
+		}
+		var msg struct {
+			Event string `json:"event"`
+			Start *Start `json:"start"`
+		}
+		err := json.Unmarshal(c.message, &msg)
+		c.message = nil
+		switch {
+		case err == nil && msg.Event == "connected":
+		case err == nil && msg.Event == "start" && msg.Start != nil:
+			c.mu.Lock()
+			c.start = msg.Start
+			c.mu.Unlock()
+			c.done = true
+		default:
+			c.done = true
+		}
+	}
+	if len(c.buf)+len(c.message) > maxBuffered {
+		c.done = true
+	}
+	if c.done {
+		c.buf, c.message = nil, nil
+	}
+}
+
+// parseFrame parses the WebSocket frame at the start of b, unmasking its
+// payload. n is 0 if b doesn't hold the whole frame yet.
+func parseFrame(b []byte) (payload []byte, opcode byte, fin bool, n int) {
+	if len(b) < 2 {
+		return nil, 0, false, 0
+	}
+	fin = b[0]&0x80 != 0
+	opcode = b[0] & 0x0f
+	masked := b[1]&0x80 != 0
+	length := uint64(b[1] & 0x7f)
+	i := 2
+	switch length {
+	case 126:
+		if len(b) < 4 {
+			return nil, 0, false, 0
+		}
+		length, i = uint64(binary.BigEndian.Uint16(b[2:])), 4
+	case 127:
+		if len(b) < 10 {
+			return nil, 0, false, 0
+		}
+		length, i = binary.BigEndian.Uint64(b[2:]), 10
+	}
+	var mask []byte
+	if masked {
+		if len(b) < i+4 {
+			return nil, 0, false, 0
+		}
+		mask, i = b[i:i+4], i+4
+	}
+	if uint64(len(b)-i) < length {
+		return nil, 0, false, 0
+	}
+	payload = make([]byte, length)
+	copy(payload, b[i:])
+	if masked {
+		for j := range payload {
+			payload[j] ^= mask[j%4]
+		}
+	}
+	return payload, opcode, fin, i + int(length)
+}
@@ -0,0 +1,184 @@
+package mediastream
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/agentplexus/omnivoice/transport"
+	"github.com/gorilla/websocket"
+)
+
+// fakeTransport upgrades and reads streams the way omnivoice-twilio does:
+// it hands the connection over straight away and keeps only the SIDs of
+// the start message.
+type fakeTransport struct {
+	mu       sync.Mutex
+	listener chan transport.Connection
+}
+
+func (t *fakeTransport) Listen(ctx context.Context, path string) (<-chan transport.Connection, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.listener = make(chan transport.Connection, 10)
+	return t.listener, nil
+}
+
+func (t *fakeTransport) HandleWebSocket(w http.ResponseWriter, r *http.Request, path string) error {
+	ws, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+	if err != nil {
+		return err
+	}
+	conn := &fakeConn{ws: ws, events: make(chan transport.Event, 10)}
+	go conn.readLoop()
+	select {
+	case t.listener <- conn:
+	default:
+	}
+	return nil
+}
+
+type fakeConn struct {
+	ws     *websocket.Conn
+	events chan transport.Event
+
+	mu        sync.Mutex
+	streamSID string
+	cleared   int
+}
+
+func (c *fakeConn) readLoop() {
+	defer close(c.events)
+	for {
+		var msg struct {
+			Event string `json:"event"`
+			Start *Start `json:"start"`
+		}
+		if err := c.ws.ReadJSON(&msg); err != nil {
+			return
+		}
+		if msg.Event == "start" {
+			c.mu.Lock()
+			c.streamSID = msg.Start.StreamSID
+			c.mu.Unlock()
+		}
+	}
+}
+
+func (c *fakeConn) ID() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.streamSID
+}
+func (c *fakeConn) AudioIn() io.WriteCloser        { return nil }
+func (c *fakeConn) AudioOut() io.Reader            { return nil }
+func (c *fakeConn) Events() <-chan transport.Event { return c.events }
+func (c *fakeConn) Close() error                   { return c.ws.Close() }
+func (c *fakeConn) RemoteAddr() net.Addr           { return c.ws.RemoteAddr() }
+
+func (c *fakeConn) Clear() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.cleared++
+	return nil
+}
+
+func newTestServer(t *testing.T) (*Server, string) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	s, err := NewServer(ctx, &fakeTransport{}, "/media-stream")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := httptest.NewServer(s)
+	t.Cleanup(hs.Close)
+	return s, "ws" + strings.TrimPrefix(hs.URL, "http") + "/media-stream"
+}
+
+func dial(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+	ws, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = ws.Close() })
+	return ws
+}
+
+func TestServerDeliversStart(t *testing.T) {
+	s, url := newTestServer(t)
+	ws := dial(t, url)
+
+	for _, msg := range []string{
+		`{"event":"connected","protocol":"Call","version":"1.0.0"}`,
+		`{"event":"start","sequenceNumber":"1","start":{"streamSid":"MZ1","accountSid":"AC1","callSid":"CA1","tracks":["inbound"],` +
+			`"mediaFormat":{"encoding":"audio/x-mulaw","sampleRate":8000,"channels":1},` +
+			`"customParameters":{"accountId":"42","campaign":"` + strings.Repeat("x", 300) + `"}},"streamSid":"MZ1"}`,
+		`{"event":"media","media":{"payload":"AAAA"},"streamSid":"MZ1"}`,
+	} {
+		if err := ws.WriteMessage(websocket.TextMessage, []byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case c := <-s.Connections():
+		conn := c.(*Conn)
+		if conn.CallSID() != "CA1" || conn.ID() != "MZ1" {
+			t.Errorf("SIDs = %q, %q, want CA1, MZ1", conn.CallSID(), conn.ID())
+		}
+		if got := conn.CustomParameters()["accountId"]; got != "42" {
+			t.Errorf("accountId = %q, want 42", got)
+		}
+		if got := conn.Start().MediaFormat.SampleRate; got != 8000 {
+			t.Errorf("sample rate = %d, want 8000", got)
+		}
+		if got := conn.Connection.ID(); got != "MZ1" {
+			t.Errorf("transport hadn't read the start message: ID = %q", got)
+		}
+		if err := conn.Clear(); err != nil || conn.Connection.(*fakeConn).cleared != 1 {
+			t.Errorf("Clear = %v, didn't reach the transport's connection", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no connection delivered")
+	}
+}
+
+func TestServerClosesStreamWithoutStart(t *testing.T) {
+	s, url := newTestServer(t)
+	ws := dial(t, url)
+
+	if err := ws.WriteMessage(websocket.TextMessage, []byte(`{"event":"media","media":{"payload":"AAAA"}}`)); err != nil {
+		t.Fatal(err)
+	}
+	_ = ws.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := ws.ReadMessage(); err == nil {
+		t.Error("stream without a start message wasn't closed")
+	}
+	select {
+	case <-s.Connections():
+		t.Error("stream without a start message was delivered")
+	default:
+	}
+}
+
+func TestParseFrameFragments(t *testing.T) {
+	// A masked "hello" split across reads
+	frame := []byte{0x81, 0x85, 0x37, 0xfa, 0x21, 0x3d, 0x7f, 0x9f, 0x4d, 0x51, 0x58}
+	for i := 0; i < len(frame); i++ {
+		if _, _, _, n := parseFrame(frame[:i]); n != 0 {
+			t.Fatalf("parsed a frame from %d of %d bytes", i, len(frame))
+		}
+	}
+	payload, opcode, fin, n := parseFrame(frame)
+	if string(payload) != "Hello" || opcode != 1 || !fin || n != len(frame) {
+		t.Errorf("parseFrame = %q, %d, %v, %d", payload, opcode, fin, n)
+	}
+}
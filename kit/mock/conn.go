@@ -0,0 +1,172 @@
+package mock
+
+import (
+	"io"
+	"maps"
+	"net"
+	"sync"
+
+	"github.com/agentplexus/omnivoice/transport"
+)
+
+// Conn is an in-memory transport connection. The agent session uses it as
+// a transport.Connection; the simulated caller speaks with Send, presses
+// keys with Press, hears the agent through Received, and ends the call
+// with Hangup.
+type Conn struct {
+	id     string
+	params map[string]string
+
+	// Caller audio flows through a pipe, so Send paces itself to the
+	// session reading it. Agent audio is buffered, so a caller that
+	// doesn't listen never stalls the session.
+	callerR *io.PipeReader
+	callerW *io.PipeWriter
+	agent   *audioBuffer
+
+	events     chan transport.Event
+	done       chan struct{}
+	closeOnce  sync.Once
+	hangupOnce sync.Once
+}
+
+var _ transport.Connection = (*Conn)(nil)
+
+// NewConn returns a connection with the given ID and custom parameters,
+// as a Media Streams "start" message would carry them.
+func NewConn(id string, params map[string]string) *Conn {
+	c := &Conn{
+		id:     id,
+		params: maps.Clone(params),
+		agent:  newAudioBuffer(),
+		events: make(chan transport.Event, 1),
+		done:   make(chan struct{}),
+	}
+	c.callerR, c.callerW = io.Pipe()
+	return c
+}
+
+// ID returns the connection ID.
+func (c *Conn) ID() string { return c.id }
+
+// CustomParameters returns the parameters the connection was created with.
+func (c *Conn) CustomParameters() map[string]string { return maps.Clone(c.params) }
+
+// AudioIn is where the session writes what the agent says.
+func (c *Conn) AudioIn() io.WriteCloser { return agentWriter{c.agent} }
+
+// AudioOut is where the session reads what the caller says.
+func (c *Conn) AudioOut() io.Reader { return c.callerR }
+
+// Events reports the caller pressing keys and hanging up.
+func (c *Conn) Events() <-chan transport.Event { return c.events }
+
+// RemoteAddr returns nil: the caller is in the same process.
+func (c *Conn) RemoteAddr() net.Addr { return nil }
+
+// Close ends the connection from the session's side. The caller's Send
+// and Received see the call end.
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() {
+		_ = c.callerR.Close()
+		c.agent.Close()
+		close(c.done)
+	})
+	return nil
+}
+
+// Done is closed once the session has closed the connection.
+func (c *Conn) Done() <-chan struct{} { return c.done }
+
+// Send plays caller audio to the session, in the call's wire format. It
+// blocks until the session has read it.
+func (c *Conn) Send(audio []byte) error {
+	_, err := c.callerW.Write(audio)
+	return err
+}
+
+// Press presses keys on the caller's keypad, each reported as Twilio
+// reports DTMF: an event whose Data is the key, e.g. "7" or "#". It blocks
+// until the session has taken them, or the connection is closed.
+func (c *Conn) Press(keys string) {
+	for _, key := range keys {
+		select {
+		case c.events <- transport.Event{Type: transport.EventDTMF, Data: string(key)}:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// Received returns the agent's audio, as the session wrote it. Reads block
+// until there is audio, and return io.EOF once the connection is closed
+// and everything written has been read.
+func (c *Conn) Received() io.Reader { return c.agent }
+
+// Hangup ends the call from the caller's side, as Twilio reports a caller
+// hanging up: a disconnected event, and the end of the caller's audio.
+func (c *Conn) Hangup() {
+	c.hangupOnce.Do(func() {
+		select {
+		case c.events <- transport.Event{Type: transport.EventDisconnected}:
+		default:
+		}
+		_ = c.callerW.Close()
+	})
+}
+
+// agentWriter is the session's side of the agent audio. Closing it is a
+// no-op; the buffer closes with the connection.
+type agentWriter struct {
+	buf *audioBuffer
+}
+
+func (w agentWriter) Write(p []byte) (int, error) { return w.buf.Write(p) }
+func (w agentWriter) Close() error                { return nil }
+
+// audioBuffer is an unbounded buffer whose reads block until data arrives
+// or it is closed.
+type audioBuffer struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	data   []byte
+	closed bool
+}
+
+func newAudioBuffer() *audioBuffer {
+	b := &audioBuffer{}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+func (b *audioBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return 0, io.ErrClosedPipe
+	}
+	b.data = append(b.data, p...)
+	b.cond.Broadcast()
+	return len(p), nil
+}
+
+func (b *audioBuffer) Read(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for len(b.data) == 0 && !b.closed {
+		b.cond.Wait()
+	}
+	if len(b.data) == 0 {
+		return 0, io.EOF
+	}
+	n := copy(p, b.data)
+	b.data = b.data[n:]
+	return n, nil
+}
+
+func (b *audioBuffer) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.closed = true
+	b.cond.Broadcast()
+}
@@ -0,0 +1,23 @@
+// Package mock provides offline stand-ins for the services a voice agent
+// depends on, so that an example's full session logic can run, and be
+// integration-tested, without API keys, a network connection or a phone:
+//
+//   - STT "hears" a script of caller utterances on a timer, whatever audio
+//     it is sent.
+//   - ReplaySTT replays a real recognizer's events, captured by Recorder,
+//     in step with the recorded audio they were heard in.
+//   - TTS "speaks" a sine tone, or silence, lasting about as long as saying
+//     the text would, in the format and sample rate requested.
+//   - Conn is an in-memory transport connection whose far end is a
+//     simulated caller.
+//
+// Wiring them into a session in place of the real providers:
+//
+//	sttProvider := &mock.STT{Script: []string{"Hi there.", "Goodbye."}}
+//	ttsProvider := &mock.TTS{Tone: 440}
+//	conn := mock.NewConn("CA0001", map[string]string{"callSid": "CA0001"})
+//	go handleSession(ctx, conn) // with the providers above
+//	<-conn.Done()               // the session hung up
+//
+// The examples use them when run with OFFLINE=1.
+package mock
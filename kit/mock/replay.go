@@ -0,0 +1,259 @@
+package mock
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/agentplexus/omnivoice/stt"
+)
+
+// RecordedEvent is a recognizer event and how far into the stream's audio
+// it arrived.
+type RecordedEvent struct {
+	// AtMS is the offset in milliseconds of audio written to the stream.
+	AtMS int64 `json:"at_ms"`
+	// Type is "speech_start", "transcript" or "speech_end".
+	Type  stt.StreamEventType `json:"type"`
+	Text  string              `json:"text,omitempty"`
+	Final bool                `json:"final,omitempty"`
+}
+
+func (e RecordedEvent) event() stt.StreamEvent {
+	switch e.Type {
+	case stt.EventSpeechStart:
+		return stt.StreamEvent{Type: e.Type, SpeechStarted: true}
+	case stt.EventSpeechEnd:
+		return stt.StreamEvent{Type: e.Type, SpeechEnded: true}
+	default:
+		return stt.StreamEvent{Type: e.Type, Transcript: e.Text, IsFinal: e.Final}
+	}
+}
+
+// ReplaySTT is a streaming speech-to-text provider that replays a real
+// recognizer's events, recorded with Recorder, against the same audio:
+// each event is emitted once the stream has been written as much audio as
+// the recognizer had heard when it sent it. Replaying a recorded call
+// through a session this way is deterministic, and keeps the recognizer's
+// timing relative to the caller's audio, so endpointing and barge-in
+// behave as they did on the call.
+type ReplaySTT struct {
+	// Events are what the recognizer sent, in order.
+	Events []RecordedEvent
+
+	streams atomic.Int64
+}
+
+var _ stt.StreamingProvider = (*ReplaySTT)(nil)
+
+// Name returns "replay".
+func (p *ReplaySTT) Name() string { return "replay" }
+
+// Streams returns how many streams have been opened.
+func (p *ReplaySTT) Streams() int { return int(p.streams.Load()) }
+
+// Transcribe returns the final transcripts joined.
+func (p *ReplaySTT) Transcribe(ctx context.Context, audio []byte, config stt.TranscriptionConfig) (*stt.TranscriptionResult, error) {
+	var text []byte
+	for _, e := range p.Events {
+		if e.Type == stt.EventTranscript && e.Final {
+			if len(text) > 0 {
+				text = append(text, ' ')
+			}
+			text = append(text, e.Text...)
+		}
+	}
+	return &stt.TranscriptionResult{Text: string(text), Language: config.Language}, nil
+}
+
+// TranscribeFile is like Transcribe; the file isn't read.
+func (p *ReplaySTT) TranscribeFile(ctx context.Context, filePath string, config stt.TranscriptionConfig) (*stt.TranscriptionResult, error) {
+	return p.Transcribe(ctx, nil, config)
+}
+
+// TranscribeURL is like Transcribe; the URL isn't fetched.
+func (p *ReplaySTT) TranscribeURL(ctx context.Context, url string, config stt.TranscriptionConfig) (*stt.TranscriptionResult, error) {
+	return p.Transcribe(ctx, nil, config)
+}
+
+// TranscribeStream opens a stream that replays the events from the start.
+// The stream ends when it is closed or ctx is cancelled.
+func (p *ReplaySTT) TranscribeStream(ctx context.Context, config stt.TranscriptionConfig) (io.WriteCloser, <-chan stt.StreamEvent, error) {
+	rate := bytesPerSecond(config)
+	if rate == 0 {
+		return nil, nil, errors.New("mock: replay needs the stream's encoding and sample rate")
+	}
+	p.streams.Add(1)
+	s := &replayStream{
+		pending: p.Events,
+		rate:    rate,
+		events:  make(chan stt.StreamEvent, 16),
+		written: make(chan struct{}, 1),
+		done:    make(chan struct{}),
+	}
+	go s.run(ctx)
+	return s, s.events, nil
+}
+
+// bytesPerSecond returns the audio rate of a stream, or 0 if its encoding
+// is unknown. A stream that doesn't give its format is taken to be 8kHz
+// mu-law, as Twilio sends.
+func bytesPerSecond(config stt.TranscriptionConfig) int64 {
+	channels := int64(max(config.Channels, 1))
+	rate := int64(config.SampleRate)
+	if rate == 0 {
+		rate = 8000
+	}
+	switch config.Encoding {
+	case "", "mulaw", "alaw":
+		return rate * channels
+	case "linear16":
+		return 2 * rate * channels
+	}
+	return 0
+}
+
+// replayStream emits recorded events as the audio written reaches them.
+type replayStream struct {
+	pending []RecordedEvent
+	rate    int64
+	events  chan stt.StreamEvent
+	// written is signalled after each write.
+	written chan struct{}
+	done    chan struct{}
+
+	bytes     atomic.Int64
+	closeOnce sync.Once
+	closed    atomic.Bool
+}
+
+func (s *replayStream) run(ctx context.Context) {
+	defer close(s.events)
+	for {
+		heard := time.Duration(s.bytes.Load()) * time.Second / time.Duration(s.rate)
+		for len(s.pending) > 0 && time.Duration(s.pending[0].AtMS)*time.Millisecond <= heard {
+			select {
+			case s.events <- s.pending[0].event():
+			case <-ctx.Done():
+				return
+			case <-s.done:
+				return
+			}
+			s.pending = s.pending[1:]
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.done:
+			return
+		case <-s.written:
+		}
+	}
+}
+
+// Write counts audio toward the next event.
+func (s *replayStream) Write(b []byte) (int, error) {
+	if s.closed.Load() {
+		return 0, io.ErrClosedPipe
+	}
+	s.bytes.Add(int64(len(b)))
+	select {
+	case s.written <- struct{}{}:
+	default:
+	}
+	return len(b), nil
+}
+
+// Close ends the stream; its events channel is closed shortly after.
+func (s *replayStream) Close() error {
+	s.closeOnce.Do(func() {
+		s.closed.Store(true)
+		close(s.done)
+	})
+	return nil
+}
+
+// Recorder wraps a streaming speech-to-text provider, recording the
+// events of the streams it opens with how far into the stream's audio each
+// arrived, for ReplaySTT to replay.
+type Recorder struct {
+	stt.StreamingProvider
+
+	mu     sync.Mutex
+	events []RecordedEvent
+}
+
+var _ stt.StreamingProvider = (*Recorder)(nil)
+
+// NewRecorder returns a recorder of provider's streams.
+func NewRecorder(provider stt.StreamingProvider) *Recorder {
+	return &Recorder{StreamingProvider: provider}
+}
+
+// Events returns the events recorded so far, from every stream in the
+// order they arrived. Errors aren't recorded.
+func (r *Recorder) Events() []RecordedEvent {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]RecordedEvent(nil), r.events...)
+}
+
+// TranscribeStream opens a stream on the wrapped provider, recording its
+// events as they are passed on.
+func (r *Recorder) TranscribeStream(ctx context.Context, config stt.TranscriptionConfig) (io.WriteCloser, <-chan stt.StreamEvent, error) {
+	rate := bytesPerSecond(config)
+	if rate == 0 {
+		return nil, nil, errors.New("mock: recording needs the stream's encoding and sample rate")
+	}
+	w, events, err := r.StreamingProvider.TranscribeStream(ctx, config)
+	if err != nil {
+		return nil, nil, err
+	}
+	counted := &countingWriter{WriteCloser: w}
+	out := make(chan stt.StreamEvent, 16)
+	go func() {
+		defer close(out)
+		for event := range events {
+			if recorded, ok := recordable(event); ok {
+				recorded.AtMS = counted.n.Load() * 1000 / rate
+				r.mu.Lock()
+				r.events = append(r.events, recorded)
+				r.mu.Unlock()
+			}
+			select {
+			case out <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return counted, out, nil
+}
+
+// recordable converts an event for recording, reporting false for errors.
+func recordable(event stt.StreamEvent) (RecordedEvent, bool) {
+	switch {
+	case event.Type == stt.EventSpeechStart || event.SpeechStarted:
+		return RecordedEvent{Type: stt.EventSpeechStart}, true
+	case event.Type == stt.EventSpeechEnd || event.SpeechEnded:
+		return RecordedEvent{Type: stt.EventSpeechEnd}, true
+	case event.Type == stt.EventTranscript:
+		return RecordedEvent{Type: stt.EventTranscript, Text: event.Transcript, Final: event.IsFinal}, true
+	}
+	return RecordedEvent{}, false
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	io.WriteCloser
+	n atomic.Int64
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.WriteCloser.Write(b)
+	w.n.Add(int64(n))
+	return n, err
+}
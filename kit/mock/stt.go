@@ -0,0 +1,145 @@
+package mock
+
+import (
+	"context"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/agentplexus/omnivoice/stt"
+)
+
+// DefaultScript is what STT hears when it has no Script: a short call that
+// exercises a couple of turns and ends with a goodbye.
+var DefaultScript = []string{
+	"Hi, I'm calling about my order.",
+	"It was supposed to arrive yesterday but it hasn't shown up.",
+	"Okay, thanks for your help. Goodbye.",
+}
+
+// defaultInterval is how often STT hears a line unless Interval is set.
+const defaultInterval = 4 * time.Second
+
+// STT is a streaming speech-to-text provider that hears a script instead of
+// audio. Every Interval after a stream opens it recognizes the next line of
+// Script: speech starts, an interim transcript of the first words, speech
+// ends, and the final transcript, as endpointing STT delivers them. Once
+// the script runs out the stream
+// hears nothing more. Audio written to a stream is discarded.
+type STT struct {
+	// Script is what the caller says, one utterance per line. Default
+	// DefaultScript.
+	Script []string
+	// Interval is the time between utterances. Default 4 seconds.
+	Interval time.Duration
+
+	streams atomic.Int64
+}
+
+var _ stt.StreamingProvider = (*STT)(nil)
+
+// Name returns "mock".
+func (p *STT) Name() string { return "mock" }
+
+// Streams returns how many streams have been opened, e.g. to check a
+// session didn't reconnect.
+func (p *STT) Streams() int { return int(p.streams.Load()) }
+
+func (p *STT) script() []string {
+	if len(p.Script) == 0 {
+		return DefaultScript
+	}
+	return p.Script
+}
+
+// Transcribe returns the whole script as one transcript.
+func (p *STT) Transcribe(ctx context.Context, audio []byte, config stt.TranscriptionConfig) (*stt.TranscriptionResult, error) {
+	return &stt.TranscriptionResult{Text: strings.Join(p.script(), " "), Language: config.Language}, nil
+}
+
+// TranscribeFile is like Transcribe; the file isn't read.
+func (p *STT) TranscribeFile(ctx context.Context, filePath string, config stt.TranscriptionConfig) (*stt.TranscriptionResult, error) {
+	return p.Transcribe(ctx, nil, config)
+}
+
+// TranscribeURL is like Transcribe; the URL isn't fetched.
+func (p *STT) TranscribeURL(ctx context.Context, url string, config stt.TranscriptionConfig) (*stt.TranscriptionResult, error) {
+	return p.Transcribe(ctx, nil, config)
+}
+
+// TranscribeStream opens a stream that hears the script from its first
+// line. The stream ends when it is closed or ctx is cancelled.
+func (p *STT) TranscribeStream(ctx context.Context, config stt.TranscriptionConfig) (io.WriteCloser, <-chan stt.StreamEvent, error) {
+	p.streams.Add(1)
+	interval := p.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+	s := &sttStream{events: make(chan stt.StreamEvent, 16), done: make(chan struct{})}
+	go s.run(ctx, p.script(), interval)
+	return s, s.events, nil
+}
+
+// sttStream emits a script's events until it is closed.
+type sttStream struct {
+	events chan stt.StreamEvent
+	done   chan struct{}
+
+	closeOnce sync.Once
+	closed    atomic.Bool
+}
+
+func (s *sttStream) run(ctx context.Context, script []string, interval time.Duration) {
+	defer close(s.events)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for _, line := range script {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		words := strings.Fields(line)
+		interim := strings.Join(words[:(len(words)+1)/2], " ")
+		for _, event := range []stt.StreamEvent{
+			{Type: stt.EventSpeechStart, SpeechStarted: true},
+			{Type: stt.EventTranscript, Transcript: interim},
+			{Type: stt.EventSpeechEnd, SpeechEnded: true},
+			{Type: stt.EventTranscript, Transcript: line, IsFinal: true},
+		} {
+			select {
+			case s.events <- event:
+			case <-ctx.Done():
+				return
+			case <-s.done:
+				return
+			}
+		}
+	}
+	// The stream stays open, hearing silence, until the session closes it
+	select {
+	case <-ctx.Done():
+	case <-s.done:
+	}
+}
+
+// Write discards audio.
+func (s *sttStream) Write(b []byte) (int, error) {
+	if s.closed.Load() {
+		return 0, io.ErrClosedPipe
+	}
+	return len(b), nil
+}
+
+// Close ends the stream; its events channel is closed shortly after.
+func (s *sttStream) Close() error {
+	s.closeOnce.Do(func() {
+		s.closed.Store(true)
+		close(s.done)
+	})
+	return nil
+}
@@ -0,0 +1,150 @@
+package mock
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/audio"
+	"github.com/agentplexus/omnivoice/tts"
+)
+
+const (
+	// defaultPerChar is how long each character takes to say unless
+	// PerChar is set: about 150 words a minute.
+	defaultPerChar = 60 * time.Millisecond
+	// ttsChunk is how much audio each stream chunk carries.
+	ttsChunk = 100 * time.Millisecond
+	// ttsFrame is what synthesized audio is padded to, so a pacer sending
+	// whole frames sends all of it.
+	ttsFrame = 20 * time.Millisecond
+	// toneLevel is the tone's amplitude, well below full scale.
+	toneLevel = 0.25 * math.MaxInt16
+)
+
+// TTS is a text-to-speech provider that speaks a sine tone, or silence,
+// lasting about as long as saying the text would. It produces mu-law
+// ("ulaw", "ulaw_8000"), A-law ("alaw", "alaw_8000") or little-endian
+// 16-bit PCM ("pcm", "pcm_16000", ...) at the requested sample rate.
+type TTS struct {
+	// Tone is the tone's frequency in Hz; 0 speaks silence.
+	Tone float64
+	// PerChar is how long each character of text takes to say. Default
+	// 60ms.
+	PerChar time.Duration
+}
+
+var _ tts.StreamingProvider = (*TTS)(nil)
+
+// Name returns "mock".
+func (p *TTS) Name() string { return "mock" }
+
+// Synthesize returns the audio for text.
+func (p *TTS) Synthesize(ctx context.Context, text string, config tts.SynthesisConfig) (*tts.SynthesisResult, error) {
+	data, rate, _, err := p.synthesize(text, config)
+	if err != nil {
+		return nil, err
+	}
+	return &tts.SynthesisResult{Audio: data, Format: config.OutputFormat, SampleRate: rate, CharacterCount: len(text)}, nil
+}
+
+// ListVoices returns the one mock voice.
+func (p *TTS) ListVoices(ctx context.Context) ([]tts.Voice, error) {
+	return []tts.Voice{{ID: "mock", Name: "Mock", Provider: "mock"}}, nil
+}
+
+// GetVoice returns a mock voice with any ID, so configured voice IDs work
+// unchanged.
+func (p *TTS) GetVoice(ctx context.Context, voiceID string) (*tts.Voice, error) {
+	return &tts.Voice{ID: voiceID, Name: "Mock", Provider: "mock"}, nil
+}
+
+// SynthesizeStream streams the audio for text in 100ms chunks.
+func (p *TTS) SynthesizeStream(ctx context.Context, text string, config tts.SynthesisConfig) (<-chan tts.StreamChunk, error) {
+	data, _, bytesPerSecond, err := p.synthesize(text, config)
+	if err != nil {
+		return nil, err
+	}
+	chunkSize := max(int(int64(bytesPerSecond)*int64(ttsChunk)/int64(time.Second)), 1)
+	chunks := make(chan tts.StreamChunk)
+	go func() {
+		defer close(chunks)
+		for len(data) > 0 {
+			n := min(len(data), chunkSize)
+			select {
+			case chunks <- tts.StreamChunk{Audio: data[:n], IsFinal: n == len(data)}:
+			case <-ctx.Done():
+				return
+			}
+			data = data[n:]
+		}
+	}()
+	return chunks, nil
+}
+
+// SynthesizeFromReader reads all the text, then streams it like
+// SynthesizeStream.
+func (p *TTS) SynthesizeFromReader(ctx context.Context, reader io.Reader, config tts.SynthesisConfig) (<-chan tts.StreamChunk, error) {
+	text, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	return p.SynthesizeStream(ctx, string(text), config)
+}
+
+// synthesize returns the audio for text in config's format, its sample
+// rate, and how many bytes make a second of it.
+func (p *TTS) synthesize(text string, config tts.SynthesisConfig) (data []byte, rate, bytesPerSecond int, err error) {
+	encode, rate, err := outputFormat(config)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return encode(p.samples(text, rate)), rate, rate * len(encode([]int16{0})), nil
+}
+
+// samples returns the tone for text at rate, padded to whole frames.
+func (p *TTS) samples(text string, rate int) []int16 {
+	perChar := p.PerChar
+	if perChar <= 0 {
+		perChar = defaultPerChar
+	}
+	d := time.Duration(len([]rune(strings.TrimSpace(text)))) * perChar
+	d = (d + ttsFrame - 1) / ttsFrame * ttsFrame
+	samples := make([]int16, int64(rate)*int64(d)/int64(time.Second))
+	if p.Tone > 0 {
+		step := 2 * math.Pi * p.Tone / float64(rate)
+		for i := range samples {
+			samples[i] = int16(toneLevel * math.Sin(step*float64(i)))
+		}
+	}
+	return samples
+}
+
+// outputFormat returns the encoder and sample rate for a synthesis config.
+// The rate is the config's, else the one in the format name, else 8kHz.
+func outputFormat(config tts.SynthesisConfig) (encode func([]int16) []byte, rate int, err error) {
+	name, suffix, _ := strings.Cut(strings.ToLower(config.OutputFormat), "_")
+	rate = config.SampleRate
+	if rate <= 0 && suffix != "" {
+		if rate, err = strconv.Atoi(suffix); err != nil || rate <= 0 {
+			return nil, 0, fmt.Errorf("mock TTS: invalid output format %q", config.OutputFormat)
+		}
+	}
+	switch name {
+	case "ulaw", "mulaw":
+		return audio.MulawEncode, 8000, nil
+	case "alaw":
+		return audio.AlawEncode, 8000, nil
+	case "", "pcm", "linear16":
+		if rate <= 0 {
+			rate = 8000
+		}
+		return audio.PCM16ToBytes, rate, nil
+	default:
+		return nil, 0, fmt.Errorf("mock TTS: unsupported output format %q", config.OutputFormat)
+	}
+}
@@ -0,0 +1,214 @@
+// Package moderation checks what is said on a call, in both directions:
+// the caller's transcripts before an agent sees them, and an agent's
+// replies before they are synthesized.
+//
+// A Checker flags text: a Wordlist of profanity held in memory, the
+// OpenAI moderation API, or several combined. A Policy decides what
+// happens to flagged text, to allow it, rewrite it with the flagged words
+// masked, or block it:
+//
+//	m := &moderation.Moderator{
+//		Checker: moderation.Checkers{moderation.DefaultWordlist(), moderation.NewOpenAI(apiKey)},
+//		Policy: func(ctx context.Context, e moderation.Event) moderation.Decision {
+//			if e.Direction == moderation.Caller {
+//				return moderation.Rewrite
+//			}
+//			return moderation.Block
+//		},
+//	}
+//	outcome, err := m.Moderate(ctx, moderation.Agent, sessionID, reply)
+//
+// A failed check allows the text: a moderation outage doesn't silence
+// calls.
+package moderation
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Direction is who said the text being checked.
+type Direction string
+
+const (
+	// Caller is a caller's transcript.
+	Caller Direction = "caller"
+	// Agent is an agent's reply.
+	Agent Direction = "agent"
+)
+
+// Result is what a Checker found in some text.
+type Result struct {
+	Flagged bool
+	// Categories are what the text was flagged for, e.g. "profanity" or
+	// "harassment".
+	Categories []string
+	// Terms are the words that were flagged, where the checker knows
+	// them.
+	Terms []string
+}
+
+// Checker flags text that breaks a content policy.
+type Checker interface {
+	Check(ctx context.Context, text string) (Result, error)
+}
+
+// Checkers combines checkers: text is flagged if any of them flags it.
+type Checkers []Checker
+
+// Check runs every checker and merges what they found, stopping at the
+// first that fails.
+func (c Checkers) Check(ctx context.Context, text string) (Result, error) {
+	var merged Result
+	for _, checker := range c {
+		r, err := checker.Check(ctx, text)
+		if err != nil {
+			return merged, err
+		}
+		merged.Flagged = merged.Flagged || r.Flagged
+		for _, category := range r.Categories {
+			if !slices.Contains(merged.Categories, category) {
+				merged.Categories = append(merged.Categories, category)
+			}
+		}
+		for _, term := range r.Terms {
+			if !slices.Contains(merged.Terms, term) {
+				merged.Terms = append(merged.Terms, term)
+			}
+		}
+	}
+	return merged, nil
+}
+
+// Decision is what is done with flagged text.
+type Decision int
+
+const (
+	// Allow passes the text on as it is; it is still flagged in logs.
+	Allow Decision = iota
+	// Rewrite masks the flagged words ("f***") and passes the rest on.
+	// Text flagged without words to mask is blocked.
+	Rewrite
+	// Block drops the text.
+	Block
+)
+
+var decisionNames = [...]string{Allow: "allow", Rewrite: "rewrite", Block: "block"}
+
+func (d Decision) String() string {
+	if int(d) < len(decisionNames) {
+		return decisionNames[d]
+	}
+	return fmt.Sprintf("Decision(%d)", int(d))
+}
+
+// ParseDecision parses a decision name: allow, rewrite or block.
+func ParseDecision(s string) (Decision, error) {
+	for d, name := range decisionNames {
+		if strings.EqualFold(strings.TrimSpace(s), name) {
+			return Decision(d), nil
+		}
+	}
+	return Allow, fmt.Errorf("unknown moderation decision %q (want allow, rewrite or block)", s)
+}
+
+// Event is flagged text for a Policy to decide on.
+type Event struct {
+	Direction Direction
+	SessionID string
+	Text      string
+	Result    Result
+}
+
+// Policy decides what to do with flagged text.
+type Policy func(ctx context.Context, e Event) Decision
+
+// Fixed returns a policy that decides by direction alone.
+func Fixed(caller, agent Decision) Policy {
+	return func(_ context.Context, e Event) Decision {
+		if e.Direction == Caller {
+			return caller
+		}
+		return agent
+	}
+}
+
+// Redacted stands in for blocked text that had no words to mask, in
+// transcripts and logs.
+const Redacted = "[removed]"
+
+// Moderator checks text and applies a policy to what is flagged.
+type Moderator struct {
+	Checker Checker
+	// Policy defaults to rewriting callers and blocking agents.
+	Policy Policy
+	// Timeout, if positive, bounds each check; a check that takes longer
+	// fails, and the text is allowed.
+	Timeout time.Duration
+}
+
+// Outcome is what became of some text.
+type Outcome struct {
+	// Text is the text to pass on: as it was, or rewritten. For blocked
+	// text it is what may be recorded in its place, with the flagged
+	// words masked, or Redacted.
+	Text     string
+	Decision Decision
+	Result   Result
+}
+
+// Moderate checks text said in direction dir and applies the policy. A
+// check that fails allows the text, and returns the error for logging.
+func (m *Moderator) Moderate(ctx context.Context, dir Direction, sessionID, text string) (Outcome, error) {
+	if m.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.Timeout)
+		defer cancel()
+	}
+	result, err := m.Checker.Check(ctx, text)
+	if err != nil || !result.Flagged {
+		return Outcome{Text: text, Decision: Allow, Result: result}, err
+	}
+
+	policy := m.Policy
+	if policy == nil {
+		policy = Fixed(Rewrite, Block)
+	}
+	out := Outcome{Text: text, Result: result}
+	out.Decision = policy(ctx, Event{Direction: dir, SessionID: sessionID, Text: text, Result: result})
+	if out.Decision == Allow {
+		return out, nil
+	}
+	out.Text = Mask(text, result.Terms)
+	if out.Text == text {
+		// Nothing to mask
+		out.Decision = Block
+		out.Text = Redacted
+	}
+	return out, nil
+}
+
+// Mask masks every whole-word occurrence of terms in text, whatever its
+// case, keeping the first letter: "damn" becomes "d***".
+func Mask(text string, terms []string) string {
+	for _, term := range terms {
+		if term == "" {
+			continue
+		}
+		re := regexp.MustCompile(`(?i)\b` + regexp.QuoteMeta(term) + `\b`)
+		text = re.ReplaceAllStringFunc(text, func(s string) string {
+			r := []rune(s)
+			for i := 1; i < len(r); i++ {
+				if r[i] != ' ' {
+					r[i] = '*'
+				}
+			}
+			return string(r)
+		})
+	}
+	return text
+}
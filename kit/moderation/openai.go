@@ -0,0 +1,83 @@
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// OpenAI checks text with the OpenAI moderation API, which flags
+// categories such as harassment, hate and violence but not the words
+// responsible: flagged text can be allowed or blocked, not rewritten.
+type OpenAI struct {
+	APIKey string
+	// Model defaults to omni-moderation-latest.
+	Model string
+	// BaseURL defaults to https://api.openai.com/v1.
+	BaseURL string
+	// Client defaults to one with a 5 second timeout.
+	Client *http.Client
+}
+
+// NewOpenAI returns a checker using the OpenAI moderation API.
+func NewOpenAI(apiKey string) *OpenAI {
+	return &OpenAI{APIKey: apiKey, Model: "omni-moderation-latest", BaseURL: "https://api.openai.com/v1"}
+}
+
+var defaultHTTPClient = &http.Client{Timeout: 5 * time.Second}
+
+// Check asks the API whether text is flagged.
+func (o *OpenAI) Check(ctx context.Context, text string) (Result, error) {
+	body, err := json.Marshal(map[string]string{"model": o.Model, "input": text})
+	if err != nil {
+		return Result{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(o.BaseURL, "/")+"/moderations", bytes.NewReader(body))
+	if err != nil {
+		return Result{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+o.APIKey)
+	client := o.Client
+	if client == nil {
+		client = defaultHTTPClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return Result{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return Result{}, fmt.Errorf("openai moderation: %s: %s", resp.Status, data)
+	}
+
+	var out struct {
+		Results []struct {
+			Flagged    bool            `json:"flagged"`
+			Categories map[string]bool `json:"categories"`
+		} `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return Result{}, fmt.Errorf("openai moderation: %w", err)
+	}
+	if len(out.Results) == 0 {
+		return Result{}, errors.New("openai moderation: response has no results")
+	}
+	var r Result
+	r.Flagged = out.Results[0].Flagged
+	for category, flagged := range out.Results[0].Categories {
+		if flagged {
+			r.Categories = append(r.Categories, category)
+		}
+	}
+	sort.Strings(r.Categories)
+	return r, nil
+}
@@ -0,0 +1,128 @@
+package moderation
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// Wordlist flags text containing any of a list of words or phrases, each
+// with the category it is flagged for. Matching is on whole words,
+// whatever their case.
+type Wordlist struct {
+	// terms maps each term, in lower case, to its category.
+	terms map[string]string
+}
+
+// NewWordlist returns a list of terms flagged for category.
+func NewWordlist(category string, terms ...string) *Wordlist {
+	w := &Wordlist{terms: make(map[string]string)}
+	w.Add(category, terms...)
+	return w
+}
+
+// Add adds terms flagged for category.
+func (w *Wordlist) Add(category string, terms ...string) {
+	for _, term := range terms {
+		if term = strings.Join(words(term), " "); term != "" {
+			w.terms[term] = category
+		}
+	}
+}
+
+// defaultProfanity is the common English profanity callers use, in the
+// forms a recognizer writes it.
+var defaultProfanity = []string{
+	"fuck", "fucking", "fucked", "fucker", "fuckers", "motherfucker", "motherfucking",
+	"shit", "shitty", "bullshit", "bitch", "bitches", "bastard", "bastards",
+	"asshole", "assholes", "dickhead", "prick", "cunt", "piss off", "pissed off",
+	"goddamn", "goddamned", "damn it", "son of a bitch", "wanker", "twat",
+}
+
+// DefaultWordlist returns a list of common English profanity, flagged as
+// "profanity". Add to it, or load a list of your own with LoadWordlist.
+func DefaultWordlist() *Wordlist {
+	return NewWordlist("profanity", defaultProfanity...)
+}
+
+// LoadWordlist reads a list with one term per line, flagged as
+// "profanity", or as the category before a colon ("harassment: shut up").
+// Blank lines and lines starting with # are ignored.
+func LoadWordlist(path string) (*Wordlist, error) {
+	return LoadWordlistAs(path, "profanity")
+}
+
+// LoadWordlistAs reads a list as LoadWordlist does, flagging terms with no
+// category of their own as defaultCategory.
+func LoadWordlistAs(path, defaultCategory string) (*Wordlist, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	w := NewWordlist(defaultCategory)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		category, term, ok := strings.Cut(text, ":")
+		if !ok {
+			category, term = defaultCategory, text
+		}
+		category = strings.TrimSpace(category)
+		if len(words(term)) == 0 || category == "" {
+			return nil, fmt.Errorf("%s:%d: invalid term: %q", path, line, text)
+		}
+		w.Add(category, term)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Merge adds the terms of other to the list.
+func (w *Wordlist) Merge(other *Wordlist) {
+	for term, category := range other.terms {
+		w.terms[term] = category
+	}
+}
+
+// Check flags the terms of the list found in text.
+func (w *Wordlist) Check(_ context.Context, text string) (Result, error) {
+	var r Result
+	padded := " " + strings.Join(words(text), " ") + " "
+	for term, category := range w.terms {
+		if !strings.Contains(padded, " "+term+" ") {
+			continue
+		}
+		r.Flagged = true
+		r.Terms = append(r.Terms, term)
+		if !slices.Contains(r.Categories, category) {
+			r.Categories = append(r.Categories, category)
+		}
+	}
+	// Longer terms first, so a phrase is masked before a word in it
+	slices.SortFunc(r.Terms, func(a, b string) int {
+		if len(a) != len(b) {
+			return len(b) - len(a)
+		}
+		return strings.Compare(a, b)
+	})
+	slices.Sort(r.Categories)
+	return r, nil
+}
+
+// words splits text into lower-case words.
+func words(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	})
+}
@@ -0,0 +1,339 @@
+// Package pacing decides how fast an outbound campaign dialer may place
+// calls: how many may be ringing at once, given how many agents are free
+// to take the calls that are answered, without abandoning more answered
+// calls than regulators allow.
+//
+// Progressive pacing places one call per free agent, so no answered call
+// waits for an agent. Predictive pacing places more, using the recent
+// answer rate to keep agents busy, and backs off to progressive as the
+// abandon rate nears its cap:
+//
+//	pacer, err := pacing.New(pacing.Config{
+//		Mode:           pacing.Predictive,
+//		Agents:         10,
+//		MaxConcurrent:  30,
+//		MaxAbandonRate: 0.03,
+//	})
+//	for range pacer.Ready() {
+//		attempt, ok := pacer.Dial()
+//		if !ok {
+//			break
+//		}
+//		go place(next(), attempt) // attempt.Answered, NoAnswer, Ended, ...
+//	}
+//
+// A call answered when no agent is free is abandoned: the dialer must play
+// a short message and hang up. Rules differ by jurisdiction (the FCC and
+// Ofcom cap abandoned calls at 3% of live answers); set MaxAbandonRate and
+// Window for the ones you dial into.
+//
+// The Twilio + Deepgram + ElevenLabs example's campaign runner
+// (CAMPAIGN_FILE) paces its calls with this package.
+package pacing
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mode is how aggressively a campaign dials.
+type Mode string
+
+const (
+	// Progressive places a call only when an agent is free for it.
+	Progressive Mode = "progressive"
+	// Predictive places more calls than there are free agents, expecting
+	// some not to be answered.
+	Predictive Mode = "predictive"
+)
+
+// ParseMode parses a mode name.
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(strings.ToLower(strings.TrimSpace(s))); m {
+	case Progressive, Predictive:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown pacing mode %q (want progressive or predictive)", s)
+	}
+}
+
+// Config configures the pacing of one campaign.
+type Config struct {
+	Mode Mode
+	// Agents is how many answered calls the campaign can handle at once;
+	// for a voice agent, the sessions it may run.
+	Agents int
+	// MaxConcurrent caps the campaign's calls in progress, ringing or
+	// connected. 0 means no cap beyond what the mode allows.
+	MaxConcurrent int
+	// MaxAbandonRate caps abandoned calls as a fraction of answered calls
+	// over Window, e.g. 0.03. Predictive pacing falls back to progressive
+	// at the cap. 0 disables the cap.
+	MaxAbandonRate float64
+	// Window is the period the answer and abandon rates are measured over.
+	// Default 24 hours.
+	Window time.Duration
+	// MaxRatio caps predictive dialing at this many calls per free agent.
+	// Default 3.
+	MaxRatio float64
+	// MinAnswered is how many calls must be answered within Window before
+	// predictive pacing trusts the answer rate; until then it paces
+	// progressively. Default 20.
+	MinAnswered int
+	// Now defaults to time.Now.
+	Now func() time.Time
+}
+
+// Stats is a snapshot of a campaign's pacing.
+type Stats struct {
+	Ringing   int `json:"ringing"`
+	Connected int `json:"connected"`
+	// Attempts, Answered and Abandoned count calls within the window.
+	Attempts    int     `json:"attempts"`
+	Answered    int     `json:"answered"`
+	Abandoned   int     `json:"abandoned"`
+	AnswerRate  float64 `json:"answer_rate"`
+	AbandonRate float64 `json:"abandon_rate"`
+	// Ratio is how many calls are placed per free agent.
+	Ratio float64 `json:"ratio"`
+}
+
+// outcome is a finished attempt, kept for the window's rates.
+type outcome struct {
+	at        time.Time
+	answered  bool
+	abandoned bool
+}
+
+// Pacer paces one campaign. It is safe for concurrent use.
+type Pacer struct {
+	cfg Config
+
+	mu        sync.Mutex
+	ringing   int
+	connected int
+	outcomes  []outcome
+}
+
+// New returns a pacer for cfg.
+func New(cfg Config) (*Pacer, error) {
+	if cfg.Mode == "" {
+		cfg.Mode = Progressive
+	}
+	if _, err := ParseMode(string(cfg.Mode)); err != nil {
+		return nil, err
+	}
+	if cfg.Agents <= 0 {
+		return nil, errors.New("pacing: Agents must be positive")
+	}
+	if cfg.MaxConcurrent < 0 || cfg.MaxAbandonRate < 0 || cfg.MaxAbandonRate >= 1 {
+		return nil, errors.New("pacing: MaxConcurrent and MaxAbandonRate must be non-negative, and MaxAbandonRate below 1")
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = 24 * time.Hour
+	}
+	if cfg.MaxRatio < 1 {
+		cfg.MaxRatio = 3
+	}
+	if cfg.MinAnswered <= 0 {
+		cfg.MinAnswered = 20
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+	return &Pacer{cfg: cfg}, nil
+}
+
+// Ready returns how many calls may be placed now.
+func (p *Pacer) Ready() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.ready()
+}
+
+// ready is Ready with p.mu held.
+func (p *Pacer) ready() int {
+	free := p.cfg.Agents - p.connected
+	if free <= 0 {
+		return 0
+	}
+	n := int(math.Ceil(float64(free)*p.ratio())) - p.ringing
+	if p.cfg.MaxConcurrent > 0 {
+		n = min(n, p.cfg.MaxConcurrent-p.ringing-p.connected)
+	}
+	return max(n, 0)
+}
+
+// ratio returns how many calls to have ringing per free agent. p.mu must
+// be held.
+func (p *Pacer) ratio() float64 {
+	if p.cfg.Mode != Predictive {
+		return 1
+	}
+	s := p.window()
+	if s.Answered < p.cfg.MinAnswered || s.AnswerRate == 0 {
+		return 1
+	}
+	ratio := min(1/s.AnswerRate, p.cfg.MaxRatio)
+	if p.cfg.MaxAbandonRate > 0 {
+		// Overdial less the closer the abandon rate is to its cap
+		headroom := 1 - s.AbandonRate/p.cfg.MaxAbandonRate
+		if headroom <= 0 {
+			return 1
+		}
+		ratio = 1 + (ratio-1)*headroom
+	}
+	return ratio
+}
+
+// window drops outcomes older than the window and returns its counts and
+// rates. p.mu must be held.
+func (p *Pacer) window() Stats {
+	cutoff := p.cfg.Now().Add(-p.cfg.Window)
+	i := 0
+	for i < len(p.outcomes) && p.outcomes[i].at.Before(cutoff) {
+		i++
+	}
+	p.outcomes = p.outcomes[i:]
+
+	var s Stats
+	for _, o := range p.outcomes {
+		s.Attempts++
+		if o.answered {
+			s.Answered++
+		}
+		if o.abandoned {
+			s.Abandoned++
+		}
+	}
+	if s.Attempts > 0 {
+		s.AnswerRate = float64(s.Answered) / float64(s.Attempts)
+	}
+	if s.Answered > 0 {
+		s.AbandonRate = float64(s.Abandoned) / float64(s.Answered)
+	}
+	return s
+}
+
+// Stats returns a snapshot of the campaign's pacing.
+func (p *Pacer) Stats() Stats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	s := p.window()
+	s.Ringing, s.Connected = p.ringing, p.connected
+	s.Ratio = p.ratio()
+	return s
+}
+
+// Dial reserves a place for one call, if the pacing allows one now. The
+// dialer reports how the call went through the returned attempt.
+func (p *Pacer) Dial() (*Attempt, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.ready() <= 0 {
+		return nil, false
+	}
+	p.ringing++
+	return &Attempt{pacer: p}, true
+}
+
+// Attempt is one call placed by a campaign. Exactly one of Answered,
+// NoAnswer or Failed must be called when the call stops ringing, and
+// Ended once a call that was connected to an agent ends.
+type Attempt struct {
+	pacer *Pacer
+
+	mu        sync.Mutex
+	stopped   bool // stopped ringing
+	connected bool
+	done      bool
+}
+
+// Answered records that a person answered and returns whether an agent is
+// free to take the call. If not, the call is abandoned: play the
+// campaign's abandon message and hang up.
+func (a *Attempt) Answered() bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.stopRinging() {
+		return false
+	}
+	p := a.pacer
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.connected >= p.cfg.Agents {
+		p.record(true, true)
+		a.done = true
+		return false
+	}
+	p.connected++
+	p.record(true, false)
+	a.connected = true
+	return true
+}
+
+// NoAnswer records a call that wasn't answered by a person: it rang out,
+// was busy, or reached voicemail.
+func (a *Attempt) NoAnswer() {
+	a.finish(true)
+}
+
+// Failed records a call that could not be placed. It doesn't count
+// against the answer rate.
+func (a *Attempt) Failed() {
+	a.finish(false)
+}
+
+// Ended frees the agent of a connected call.
+func (a *Attempt) Ended() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.connected || a.done {
+		return
+	}
+	a.done = true
+	p := a.pacer
+	p.mu.Lock()
+	p.connected--
+	p.mu.Unlock()
+}
+
+// finish ends an attempt that never reached an agent, counting it as an
+// attempt if counted is set.
+func (a *Attempt) finish(counted bool) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if !a.stopRinging() {
+		return
+	}
+	a.done = true
+	if counted {
+		p := a.pacer
+		p.mu.Lock()
+		p.record(false, false)
+		p.mu.Unlock()
+	}
+}
+
+// stopRinging releases the attempt's ringing place, reporting false if it
+// already stopped ringing. a.mu must be held.
+func (a *Attempt) stopRinging() bool {
+	if a.stopped {
+		return false
+	}
+	a.stopped = true
+	p := a.pacer
+	p.mu.Lock()
+	p.ringing--
+	p.mu.Unlock()
+	return true
+}
+
+// record adds a finished attempt to the window. p.mu must be held.
+func (p *Pacer) record(answered, abandoned bool) {
+	p.outcomes = append(p.outcomes, outcome{at: p.cfg.Now(), answered: answered, abandoned: abandoned})
+}
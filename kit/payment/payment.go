@@ -0,0 +1,300 @@
+// Package payment takes card payments over the phone: it checks the card
+// details a caller keyed in and charges them through a Processor, a test
+// processor kept in memory or a gateway reached over HTTP.
+//
+//	card := payment.Card{Number: number, Expiry: "0428", CVC: cvc}
+//	if err := card.Validate(time.Now()); err != nil {
+//		// ask the caller to key it in again
+//	}
+//	receipt, err := processor.Charge(ctx, payment.Charge{
+//		Card:      card,
+//		Amount:    2500,
+//		Currency:  "USD",
+//		Reference: idempotencyKey,
+//	})
+//
+// Card details should only ever be keyed in, never spoken: see the voice
+// agent example for keeping the caller's audio out of speech recognition
+// and recordings while they are entered. Nothing in this package logs or
+// returns them, apart from a card's last four digits.
+package payment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Errors Validate returns for details that can't be charged.
+var (
+	ErrInvalidNumber = errors.New("payment: invalid card number")
+	ErrInvalidExpiry = errors.New("payment: invalid expiry date")
+	ErrExpired       = errors.New("payment: card has expired")
+	ErrInvalidCVC    = errors.New("payment: invalid security code")
+)
+
+// ErrDeclined is returned, wrapped with the processor's reason, when a card
+// is declined.
+var ErrDeclined = errors.New("payment: card declined")
+
+// Card is a payment card's details.
+type Card struct {
+	// Number is the card number, digits only.
+	Number string
+	// Expiry is the expiry date as MMYY.
+	Expiry string
+	// CVC is the security code.
+	CVC string
+}
+
+// Last4 returns the card number's last four digits, the only part of it
+// that may be read back or logged.
+func (c Card) Last4() string {
+	if len(c.Number) < 4 {
+		return ""
+	}
+	return c.Number[len(c.Number)-4:]
+}
+
+// ExpiryDate returns the card's expiry month and four-digit year.
+func (c Card) ExpiryDate() (month, year int, err error) {
+	if len(c.Expiry) != 4 || !digits(c.Expiry) {
+		return 0, 0, ErrInvalidExpiry
+	}
+	month, _ = strconv.Atoi(c.Expiry[:2])
+	year, _ = strconv.Atoi(c.Expiry[2:])
+	if month < 1 || month > 12 {
+		return 0, 0, ErrInvalidExpiry
+	}
+	return month, 2000 + year, nil
+}
+
+// Validate checks the card's number, expiry date and security code as of
+// now. Cards expire at the end of their expiry month.
+func (c Card) Validate(now time.Time) error {
+	if !ValidNumber(c.Number) {
+		return ErrInvalidNumber
+	}
+	if err := ValidExpiry(c.Expiry, now); err != nil {
+		return err
+	}
+	if !ValidCVC(c.CVC) {
+		return ErrInvalidCVC
+	}
+	return nil
+}
+
+// ValidExpiry checks an expiry date written as MMYY, returning
+// ErrInvalidExpiry if it isn't one and ErrExpired if it is past as of now.
+func ValidExpiry(mmyy string, now time.Time) error {
+	month, year, err := Card{Expiry: mmyy}.ExpiryDate()
+	if err != nil {
+		return err
+	}
+	if !now.Before(time.Date(year, time.Month(month)+1, 1, 0, 0, 0, 0, time.UTC)) {
+		return ErrExpired
+	}
+	return nil
+}
+
+// ValidCVC reports whether cvc is a security code of 3 or 4 digits.
+func ValidCVC(cvc string) bool {
+	return len(cvc) >= 3 && len(cvc) <= 4 && digits(cvc)
+}
+
+// ValidNumber reports whether number is 12 to 19 digits that pass the Luhn
+// check, catching most mistyped card numbers before they are charged.
+func ValidNumber(number string) bool {
+	if len(number) < 12 || len(number) > 19 || !digits(number) {
+		return false
+	}
+	return Luhn(number)
+}
+
+// Luhn reports whether a string of digits passes the Luhn (mod 10) check.
+func Luhn(number string) bool {
+	sum := 0
+	double := false
+	for i := len(number) - 1; i >= 0; i-- {
+		if number[i] < '0' || number[i] > '9' {
+			return false
+		}
+		d := int(number[i] - '0')
+		if double {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		double = !double
+	}
+	return number != "" && sum%10 == 0
+}
+
+func digits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// Charge is a payment to take from a card.
+type Charge struct {
+	Card Card
+	// Amount is in the currency's minor unit, e.g. cents.
+	Amount int64
+	// Currency is an ISO 4217 code, e.g. "USD".
+	Currency    string
+	Description string
+	// Reference identifies the charge: a processor given the same
+	// reference again returns the first charge's receipt rather than
+	// charging twice.
+	Reference string
+}
+
+// Receipt is a charge the processor took.
+type Receipt struct {
+	// ID is the processor's reference for the charge.
+	ID string
+}
+
+// Processor charges cards.
+type Processor interface {
+	// Charge takes a payment, returning an error wrapping ErrDeclined if
+	// the card was declined.
+	Charge(ctx context.Context, c Charge) (Receipt, error)
+}
+
+// TestDeclinedNumber is a card number the Test processor declines.
+const TestDeclinedNumber = "4000000000000002"
+
+// Test is a processor kept in memory, for trying payments out without a
+// gateway. It approves every valid card except TestDeclinedNumber.
+type Test struct {
+	mu      sync.Mutex
+	n       int
+	charges map[string]Receipt
+}
+
+// Charge approves c unless its card is TestDeclinedNumber.
+func (t *Test) Charge(_ context.Context, c Charge) (Receipt, error) {
+	if err := c.Card.Validate(time.Now()); err != nil {
+		return Receipt{}, err
+	}
+	if c.Card.Number == TestDeclinedNumber {
+		return Receipt{}, fmt.Errorf("%w: test card", ErrDeclined)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if r, ok := t.charges[c.Reference]; ok {
+		return r, nil
+	}
+	t.n++
+	r := Receipt{ID: fmt.Sprintf("test_%d", t.n)}
+	if c.Reference != "" {
+		if t.charges == nil {
+			t.charges = make(map[string]Receipt)
+		}
+		t.charges[c.Reference] = r
+	}
+	return r, nil
+}
+
+// HTTP charges cards through a payment gateway, or a service of your own
+// in front of one, by posting each charge as JSON:
+//
+//	{"reference": "...", "amount": 2500, "currency": "USD", "description": "...",
+//	 "card": {"number": "4242424242424242", "exp_month": 4, "exp_year": 2028, "cvc": "123"}}
+//
+// A 2xx response with {"id": "..."} is a receipt; 402 Payment Required
+// with {"reason": "..."} is a decline. The reference is also sent as the
+// Idempotency-Key header. The endpoint receives full card details, so it
+// must be served over HTTPS and be in scope for PCI DSS.
+type HTTP struct {
+	URL string
+	// Token, if set, is sent as a bearer token.
+	Token string
+	// Client defaults to one with a 20 second timeout.
+	Client *http.Client
+}
+
+var defaultHTTPClient = &http.Client{Timeout: 20 * time.Second}
+
+// Charge posts c to the endpoint.
+func (h *HTTP) Charge(ctx context.Context, c Charge) (Receipt, error) {
+	month, year, err := c.Card.ExpiryDate()
+	if err != nil {
+		return Receipt{}, err
+	}
+	body, err := json.Marshal(map[string]any{
+		"reference":   c.Reference,
+		"amount":      c.Amount,
+		"currency":    c.Currency,
+		"description": c.Description,
+		"card": map[string]any{
+			"number":    c.Card.Number,
+			"exp_month": month,
+			"exp_year":  year,
+			"cvc":       c.Card.CVC,
+		},
+	})
+	if err != nil {
+		return Receipt{}, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return Receipt{}, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.Token)
+	}
+	if c.Reference != "" {
+		req.Header.Set("Idempotency-Key", c.Reference)
+	}
+	client := h.Client
+	if client == nil {
+		client = defaultHTTPClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		// The error names the URL, never the body
+		return Receipt{}, fmt.Errorf("payment: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		ID     string `json:"id"`
+		Reason string `json:"reason"`
+	}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	_ = json.Unmarshal(data, &result)
+	switch {
+	case resp.StatusCode == http.StatusPaymentRequired:
+		return Receipt{}, fmt.Errorf("%w: %s", ErrDeclined, firstNonEmpty(result.Reason, "no reason given"))
+	case resp.StatusCode/100 != 2:
+		return Receipt{}, fmt.Errorf("payment: gateway: %s: %.512s", resp.Status, strings.TrimSpace(string(data)))
+	case result.ID == "":
+		return Receipt{}, errors.New("payment: gateway: no charge ID in response")
+	}
+	return Receipt{ID: result.ID}, nil
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,91 @@
+package payment
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLuhn(t *testing.T) {
+	for _, tt := range []struct {
+		number string
+		want   bool
+	}{
+		{"4242424242424242", true},
+		{"4242424242424241", false},
+		{"79927398713", true},
+		{"79927398710", false},
+		{"0", true},
+		{"", false},
+		{"4242 4242 4242 4242", false},
+	} {
+		if got := Luhn(tt.number); got != tt.want {
+			t.Errorf("Luhn(%q) = %v, want %v", tt.number, got, tt.want)
+		}
+	}
+}
+
+func TestValidNumber(t *testing.T) {
+	for _, tt := range []struct {
+		number string
+		want   bool
+	}{
+		{"4242424242424242", true},
+		{TestDeclinedNumber, true},
+		// Passes the Luhn check, but is too short to be a card number
+		{"79927398713", false},
+		{"4242424242424241", false},
+		{"42424242424242424242", false},
+	} {
+		if got := ValidNumber(tt.number); got != tt.want {
+			t.Errorf("ValidNumber(%q) = %v, want %v", tt.number, got, tt.want)
+		}
+	}
+}
+
+func TestValidExpiry(t *testing.T) {
+	now := time.Date(2025, time.March, 15, 12, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		mmyy string
+		want error
+	}{
+		{"0325", nil},
+		{"1299", nil},
+		{"0225", ErrExpired},
+		{"1224", ErrExpired},
+		{"0025", ErrInvalidExpiry},
+		{"1325", ErrInvalidExpiry},
+		{"325", ErrInvalidExpiry},
+		{"03/25", ErrInvalidExpiry},
+	} {
+		if err := ValidExpiry(tt.mmyy, now); !errors.Is(err, tt.want) {
+			t.Errorf("ValidExpiry(%q) = %v, want %v", tt.mmyy, err, tt.want)
+		}
+	}
+
+	// A card is good until the end of its expiry month
+	if err := ValidExpiry("0325", time.Date(2025, time.March, 31, 23, 59, 59, 0, time.UTC)); err != nil {
+		t.Errorf("last moment of the expiry month: %v", err)
+	}
+	if err := ValidExpiry("0325", time.Date(2025, time.April, 1, 0, 0, 0, 0, time.UTC)); !errors.Is(err, ErrExpired) {
+		t.Errorf("first moment after the expiry month: %v", err)
+	}
+}
+
+func TestCardValidate(t *testing.T) {
+	now := time.Date(2025, time.March, 15, 12, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		card Card
+		want error
+	}{
+		{Card{Number: "4242424242424242", Expiry: "0427", CVC: "123"}, nil},
+		{Card{Number: "4242424242424242", Expiry: "0427", CVC: "1234"}, nil},
+		{Card{Number: "4242424242424241", Expiry: "0427", CVC: "123"}, ErrInvalidNumber},
+		{Card{Number: "4242424242424242", Expiry: "0125", CVC: "123"}, ErrExpired},
+		{Card{Number: "4242424242424242", Expiry: "0427", CVC: "12"}, ErrInvalidCVC},
+	} {
+		if err := tt.card.Validate(now); !errors.Is(err, tt.want) {
+			t.Errorf("Validate(%s, %s) = %v, want %v", tt.card.Last4(), tt.card.Expiry, err, tt.want)
+		}
+	}
+}
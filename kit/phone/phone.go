@@ -0,0 +1,185 @@
+// Package phone parses the phone numbers the examples dial and match
+// callers against. Numbers arrive in many shapes: E.164 from Twilio,
+// national or internationally-prefixed numbers typed into configuration,
+// extensions, tel: and SIP URIs. A DialPlan reads them all into a Number,
+// whose E.164 form is what the examples compare and dial:
+//
+//	plan := phone.PlanFor("44")
+//	n, err := plan.Parse("020 7946 0000 ext. 12")
+//	// n.E164 == "+442079460000", n.Extension == "12"
+//
+// Numbers that already carry a country code parse the same under every
+// plan.
+package phone
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ErrInvalid is returned for strings that aren't phone numbers.
+var ErrInvalid = errors.New("not a phone number")
+
+// Number is a parsed phone number, or a SIP URI.
+type Number struct {
+	// E164 is the number with a leading + and its country code, e.g.
+	// "+15551230001". It is empty for SIP URIs.
+	E164 string
+	// Extension, if set, is dialed as digits once the call connects.
+	Extension string
+	// SIP is set instead of E164 for SIP URIs, e.g. "sip:desk@pbx.example.com".
+	SIP string
+}
+
+// IsZero reports whether n is the zero Number.
+func (n Number) IsZero() bool {
+	return n == Number{}
+}
+
+// IsSIP reports whether n is a SIP URI.
+func (n Number) IsSIP() bool {
+	return n.SIP != ""
+}
+
+// Address returns what is dialed to reach n: its E.164 number or SIP URI,
+// without the extension.
+func (n Number) Address() string {
+	if n.IsSIP() {
+		return n.SIP
+	}
+	return n.E164
+}
+
+// String returns n in E.164, with an extension in the form of RFC 3966
+// (";ext=12"), or the SIP URI.
+func (n Number) String() string {
+	if n.Extension != "" && !n.IsSIP() {
+		return n.E164 + ";ext=" + n.Extension
+	}
+	return n.Address()
+}
+
+// DialPlan says how numbers written for dialing within one country are
+// read.
+type DialPlan struct {
+	// CountryCode is assumed for national numbers, e.g. "1" or "44". When
+	// empty, only numbers with a country code can be parsed.
+	CountryCode string
+	// TrunkPrefix precedes national numbers dialed in full and is dropped,
+	// e.g. "0" in the UK ("020 ...") or "1" in North America ("1-555-...").
+	TrunkPrefix string
+	// InternationalPrefixes precede a country code when dialing abroad,
+	// e.g. "011" in North America or "00" in most other countries.
+	InternationalPrefixes []string
+	// NationalDigits, if set, is the length of every national number
+	// without the trunk prefix, e.g. 10 in North America.
+	NationalDigits int
+}
+
+// NANP is the North American Numbering Plan (United States, Canada and
+// much of the Caribbean).
+var NANP = DialPlan{CountryCode: "1", TrunkPrefix: "1", InternationalPrefixes: []string{"011"}, NationalDigits: 10}
+
+// PlanFor returns the dial plan of the country with countryCode: NANP for
+// "1", and otherwise the ITU recommendation followed by most countries,
+// trunk prefix 0 and international prefix 00. An empty countryCode
+// returns a plan that only accepts numbers with a country code.
+func PlanFor(countryCode string) DialPlan {
+	countryCode = strings.TrimPrefix(strings.TrimSpace(countryCode), "+")
+	switch countryCode {
+	case "":
+		return DialPlan{}
+	case "1":
+		return NANP
+	default:
+		return DialPlan{CountryCode: countryCode, TrunkPrefix: "0", InternationalPrefixes: []string{"00"}}
+	}
+}
+
+// extension matches a trailing extension: "x12", "ext. 12", ";ext=12",
+// "#12", or digits after pauses (",,12", "ww12").
+var extension = regexp.MustCompile(`(?i)^(.*?)\s*(?:;ext=|ext\.?|x|#|[,;wp]+)\s*(\d+)#?$`)
+
+// Parse reads s as a phone number. Accepted forms include E.164 with any
+// spacing and punctuation ("+1 (555) 123-0001"), national numbers with or
+// without the trunk prefix, numbers after an international prefix, tel:
+// URIs and trailing extensions. SIP URIs are returned as they are.
+func (p DialPlan) Parse(s string) (Number, error) {
+	s = strings.TrimSpace(s)
+	lower := strings.ToLower(s)
+	if strings.HasPrefix(lower, "sip:") || strings.HasPrefix(lower, "sips:") {
+		return Number{SIP: s}, nil
+	}
+
+	var n Number
+	if strings.HasPrefix(lower, "tel:") {
+		s = s[len("tel:"):]
+		// Keep the extension, drop other parameters such as phone-context
+		params := strings.Split(s, ";")
+		s = params[0]
+		for _, param := range params[1:] {
+			if ext, ok := strings.CutPrefix(strings.ToLower(param), "ext="); ok {
+				n.Extension = ext
+			}
+		}
+	}
+	if m := extension.FindStringSubmatch(s); m != nil && n.Extension == "" {
+		s, n.Extension = m[1], m[2]
+	}
+
+	international := strings.HasPrefix(s, "+")
+	var digits strings.Builder
+	for i, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == '+' && i == 0:
+		case strings.ContainsRune(" -.()/", r):
+		default:
+			return Number{}, fmt.Errorf("%w: %q", ErrInvalid, s)
+		}
+	}
+	d := digits.String()
+
+	if !international {
+		for _, prefix := range p.InternationalPrefixes {
+			if rest, ok := strings.CutPrefix(d, prefix); ok {
+				d, international = rest, true
+				break
+			}
+		}
+	}
+	if !international {
+		if p.CountryCode == "" {
+			return Number{}, fmt.Errorf("%w: %q has no country code", ErrInvalid, s)
+		}
+		if p.TrunkPrefix != "" {
+			d = strings.TrimPrefix(d, p.TrunkPrefix)
+		}
+		if d == "" || d[0] == '0' || p.NationalDigits != 0 && len(d) != p.NationalDigits {
+			return Number{}, fmt.Errorf("%w: %q", ErrInvalid, s)
+		}
+		d = p.CountryCode + d
+	}
+
+	// E.164 allows at most 15 digits, and no country code starts with 0
+	if len(d) < 7 || len(d) > 15 || d[0] == '0' {
+		return Number{}, fmt.Errorf("%w: %q", ErrInvalid, s)
+	}
+	n.E164 = "+" + d
+	return n, nil
+}
+
+// Normalize returns the form numbers are matched by: the E.164 number or
+// SIP URI, without any extension. Caller IDs that aren't numbers, such as
+// "anonymous" or "client:alice", are returned trimmed but otherwise
+// unchanged, so they still match themselves.
+func (p DialPlan) Normalize(s string) string {
+	n, err := p.Parse(s)
+	if err != nil {
+		return strings.TrimSpace(s)
+	}
+	return n.Address()
+}
@@ -0,0 +1,214 @@
+// Package prompts keeps a library of named prompts, the lines an agent
+// says word for word on every call ("Please key in your account number
+// followed by the hash key"), rendered to 8kHz mu-law audio files ahead of
+// time. Rendered prompts play without waiting on a TTS provider, and go on
+// playing while it is down.
+//
+// Prompts are listed in a YAML file, by name:
+//
+//	ask_account_number: Please key in your account number, followed by the hash key.
+//	one_moment: One moment while I look that up.
+//
+// Render synthesizes the prompts into a directory, once: a prompt whose
+// text, voice and model haven't changed since it was last rendered is
+// read back from its file.
+//
+//	list, err := prompts.Load("prompts.yaml")
+//	lib, err := prompts.Render(ctx, provider, list, "prompts", prompts.Voice{ID: voiceID})
+//	ulaw, ok := lib.Audio("ask_account_number")
+package prompts
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/agentplexus/omnivoice/tts"
+	"gopkg.in/yaml.v3"
+)
+
+// Prompt is a line said word for word.
+type Prompt struct {
+	// Name identifies the prompt, in lowercase letters, digits and
+	// underscores.
+	Name string
+	Text string
+}
+
+// Voice is what prompts are rendered in.
+type Voice struct {
+	ID    string
+	Model string
+	// Settings identifies anything else that changes how the voice
+	// sounds, such as its provider's voice settings, so that prompts are
+	// rendered again when it changes. It is only identified, not passed
+	// to the provider.
+	Settings string
+}
+
+var validName = regexp.MustCompile(`^[a-z0-9_]+$`)
+
+// Load reads the prompts in a YAML file mapping names to text, sorted by
+// name.
+func Load(path string) ([]Prompt, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var byName map[string]string
+	if err := yaml.Unmarshal(data, &byName); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	list := make([]Prompt, 0, len(byName))
+	for name, text := range byName {
+		if !validName.MatchString(name) {
+			return nil, fmt.Errorf("%s: invalid prompt name %q (want lowercase letters, digits and underscores)", path, name)
+		}
+		if strings.TrimSpace(text) == "" {
+			return nil, fmt.Errorf("%s: prompt %q has no text", path, name)
+		}
+		list = append(list, Prompt{Name: name, Text: strings.TrimSpace(text)})
+	}
+	slices.SortFunc(list, func(a, b Prompt) int { return strings.Compare(a.Name, b.Name) })
+	return list, nil
+}
+
+// Library holds rendered prompts. It is safe for concurrent use.
+type Library struct {
+	text  map[string]string
+	audio map[string][]byte
+}
+
+// Render returns a library of list rendered in voice by provider, as 8kHz
+// mu-law files in a directory named after the provider in dir, created if
+// need be. Prompts already rendered with the same text and voice are read
+// from their files, so only new and changed prompts are synthesized, and
+// stale renderings are removed. Prompts that can't be rendered are left
+// out of the library, with an error naming them, but the rest are still
+// returned: a host can fall back to synthesizing those live.
+func Render(ctx context.Context, provider tts.Provider, list []Prompt, dir string, voice Voice) (*Library, error) {
+	dir = filepath.Join(dir, fileName(provider.Name()))
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	lib := &Library{text: make(map[string]string, len(list)), audio: make(map[string][]byte, len(list))}
+	var errs []error
+	for _, p := range list {
+		lib.text[p.Name] = p.Text
+		path := filepath.Join(dir, p.Name+"-"+renderKey(p.Text, voice)+".ulaw")
+		audio, err := os.ReadFile(path)
+		if err != nil {
+			audio, err = render(ctx, provider, p.Text, voice)
+			if err == nil {
+				err = writeFile(path, audio)
+			}
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("prompt %s: %w", p.Name, err))
+			continue
+		}
+		lib.audio[p.Name] = audio
+		removeStale(dir, p.Name, path)
+	}
+	return lib, errors.Join(errs...)
+}
+
+// renderKey identifies the audio of text in voice.
+func renderKey(text string, voice Voice) string {
+	key := voice.ID + "\x00" + voice.Model + "\x00" + text
+	if voice.Settings != "" {
+		key += "\x00" + voice.Settings
+	}
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// fileName makes name safe to use as a file name.
+func fileName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if validName.MatchString(string(r)) || r == '-' {
+			return r
+		}
+		return '_'
+	}, strings.ToLower(name))
+	if name == "" {
+		return "tts"
+	}
+	return name
+}
+
+// render synthesizes text as 8kHz mu-law.
+func render(ctx context.Context, provider tts.Provider, text string, voice Voice) ([]byte, error) {
+	result, err := provider.Synthesize(ctx, text, tts.SynthesisConfig{
+		VoiceID:      voice.ID,
+		Model:        voice.Model,
+		OutputFormat: "ulaw",
+		SampleRate:   8000,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(result.Audio) == 0 {
+		return nil, errors.New("no audio")
+	}
+	return result.Audio, nil
+}
+
+// writeFile writes a rendering whole or not at all, so an interrupted
+// render isn't mistaken for a finished one.
+func writeFile(path string, data []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// removeStale removes name's renderings in dir other than current.
+func removeStale(dir, name, current string) {
+	matches, _ := filepath.Glob(filepath.Join(dir, name+"-*.ulaw"))
+	for _, m := range matches {
+		if m != current {
+			_ = os.Remove(m)
+		}
+	}
+}
+
+// Text returns a prompt's text, whether or not it was rendered.
+func (l *Library) Text(name string) (string, bool) {
+	if l == nil {
+		return "", false
+	}
+	text, ok := l.text[name]
+	return text, ok
+}
+
+// Audio returns a rendered prompt as 8kHz mu-law. The caller must not
+// modify it.
+func (l *Library) Audio(name string) ([]byte, bool) {
+	if l == nil {
+		return nil, false
+	}
+	audio, ok := l.audio[name]
+	return audio, ok
+}
+
+// Names returns the prompts' names, sorted.
+func (l *Library) Names() []string {
+	if l == nil {
+		return nil
+	}
+	names := make([]string, 0, len(l.text))
+	for name := range l.text {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
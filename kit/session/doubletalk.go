@@ -0,0 +1,126 @@
+package session
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+
+	"github.com/agentplexus/omnivoice-examples/kit/audio"
+	"github.com/agentplexus/omnivoice/transport"
+)
+
+// DoubleTalk is what a session does when the caller talks over the agent.
+type DoubleTalk string
+
+const (
+	// DoubleTalkStop cuts the agent off: the turn being answered is
+	// abandoned, queued speech dropped, and audio already sent cleared.
+	DoubleTalkStop DoubleTalk = "stop"
+	// DoubleTalkDuck lets the agent carry on, more quietly, until the
+	// caller stops.
+	DoubleTalkDuck DoubleTalk = "duck"
+	// DoubleTalkFinish lets the agent finish the sentence it is speaking,
+	// then abandons the rest of the turn.
+	DoubleTalkFinish DoubleTalk = "finish-sentence"
+)
+
+// DefaultDuckGain is how much DoubleTalkDuck lowers the agent's voice
+// unless told otherwise: about 12 dB.
+const DefaultDuckGain = 0.25
+
+// ParseDoubleTalk parses a double-talk policy; "" is DoubleTalkStop.
+func ParseDoubleTalk(name string) (DoubleTalk, error) {
+	switch d := DoubleTalk(strings.ToLower(strings.TrimSpace(name))); d {
+	case "":
+		return DoubleTalkStop, nil
+	case DoubleTalkStop, DoubleTalkDuck, DoubleTalkFinish:
+		return d, nil
+	default:
+		return "", fmt.Errorf("unknown double-talk policy %q (want stop, duck or finish-sentence)", name)
+	}
+}
+
+// duckRampSamples is how many samples the gain of a ducked voice takes to
+// change, so ducking doesn't click.
+const duckRampSamples = 160
+
+// duckingConnection lowers the agent's voice while the caller talks over
+// it.
+type duckingConnection struct {
+	transport.Connection
+	writer *duckingWriter
+}
+
+// newDuckingConnection wraps conn to duck to gain the audio written to it
+// in the TTS output format, or returns nil if the format can't be ducked.
+func newDuckingConnection(conn transport.Connection, format string, gain float64) *duckingConnection {
+	codec, err := audio.ParseCodec(format)
+	if err != nil || codec == audio.CodecG722 {
+		return nil
+	}
+	return &duckingConnection{
+		Connection: conn,
+		writer: &duckingWriter{
+			dst:     conn.AudioIn(),
+			decoder: codec.NewDecoder(),
+			encoder: codec.NewEncoder(),
+			ducked:  gain,
+			gain:    1,
+		},
+	}
+}
+
+// AudioIn returns the ducking writer.
+func (c *duckingConnection) AudioIn() io.WriteCloser {
+	return c.writer
+}
+
+// duck lowers the voice from now on, or brings it back.
+func (c *duckingConnection) duck(on bool) {
+	if c != nil {
+		c.writer.on.Store(on)
+	}
+}
+
+type duckingWriter struct {
+	dst     io.WriteCloser
+	decoder audio.Decoder
+	encoder audio.Encoder
+	ducked  float64
+	on      atomic.Bool
+
+	// gain, pcm and out belong to the one goroutine writing
+	gain float64
+	pcm  []int16
+	out  []byte
+}
+
+func (w *duckingWriter) Write(b []byte) (int, error) {
+	target := 1.0
+	if w.on.Load() {
+		target = w.ducked
+	}
+	if w.gain == 1 && target == 1 {
+		return w.dst.Write(b)
+	}
+	step := (1 - w.ducked) / duckRampSamples
+	w.pcm = audio.AppendDecode(w.decoder, w.pcm[:0], b)
+	for i, s := range w.pcm {
+		if w.gain > target {
+			w.gain = max(target, w.gain-step)
+		} else if w.gain < target {
+			w.gain = min(target, w.gain+step)
+		}
+		w.pcm[i] = int16(float64(s) * w.gain)
+	}
+	w.out = audio.AppendEncode(w.encoder, w.out[:0], w.pcm)
+	if _, err := w.dst.Write(w.out); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (w *duckingWriter) Close() error {
+	return w.dst.Close()
+}
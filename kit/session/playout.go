@@ -0,0 +1,96 @@
+package session
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/audio"
+	"github.com/agentplexus/omnivoice/pipeline"
+	"github.com/agentplexus/omnivoice/transport"
+)
+
+// playoutConnection keeps track of when the agent's audio will have
+// played to the caller. Transports such as Twilio's take audio faster than
+// real time and buffer it, so audio sent is not audio heard: it plays out
+// at the output format's rate from when it was sent, or from the end of
+// the audio before it.
+type playoutConnection struct {
+	transport.Connection
+	bytesPerSecond int
+
+	mu    sync.Mutex
+	until time.Time
+}
+
+// newPlayoutConnection wraps conn to time the audio written to it in the
+// TTS output format.
+func newPlayoutConnection(conn transport.Connection, config pipeline.TTSPipelineConfig) *playoutConnection {
+	bytesPerSecond := 2 * config.SampleRate
+	if codec, err := audio.ParseCodec(config.OutputFormat); err == nil {
+		bytesPerSecond = codec.BytesPerSecond()
+	}
+	if bytesPerSecond <= 0 {
+		bytesPerSecond = 16000
+	}
+	return &playoutConnection{Connection: conn, bytesPerSecond: bytesPerSecond}
+}
+
+// AudioIn returns a writer timing the audio written through it.
+func (c *playoutConnection) AudioIn() io.WriteCloser {
+	return playoutWriter{conn: c, dst: c.Connection.AudioIn()}
+}
+
+// Until returns when the audio written so far will have played.
+func (c *playoutConnection) Until() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.until
+}
+
+// Clear drops the audio not yet played, on transports that can.
+func (c *playoutConnection) Clear() error {
+	cl, ok := c.Connection.(interface{ Clear() error })
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	if err := cl.Clear(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	c.until = time.Now()
+	c.mu.Unlock()
+	return nil
+}
+
+// played accounts for n bytes written.
+func (c *playoutConnection) played(n int) {
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.until = later(c.until, now).Add(time.Duration(n) * time.Second / time.Duration(c.bytesPerSecond))
+}
+
+type playoutWriter struct {
+	conn *playoutConnection
+	dst  io.WriteCloser
+}
+
+func (w playoutWriter) Write(b []byte) (int, error) {
+	n, err := w.dst.Write(b)
+	w.conn.played(n)
+	return n, err
+}
+
+func (w playoutWriter) Close() error {
+	return w.dst.Close()
+}
+
+// later returns the later of a and b.
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
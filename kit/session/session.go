@@ -0,0 +1,617 @@
+// Package session runs a voice agent's side of one call: the caller's
+// audio is transcribed, each utterance is answered by an agent.Agent, and
+// the replies are synthesized back to the caller, who can cut them off by
+// speaking. It is the loop the ElevenLabs, order, survey and call
+// screening examples run their calls on:
+//
+//	s := session.NewVoiceSession(conn, sttProvider, ttsProvider, agent.NewEcho(), session.Options{
+//		Greeting: "Hello! How can I help you today?",
+//		STT:      pipeline.STTPipelineConfig{Encoding: "mulaw", SampleRate: 8000, Channels: 1},
+//		TTS:      pipeline.TTSPipelineConfig{OutputFormat: "ulaw", SampleRate: 8000},
+//	})
+//	err := s.Run(ctx) // until the caller or the agent hangs up
+//	lines := s.Transcript()
+//
+// Hosts that need more than Options offers build their own loop from the
+// same parts. The Deepgram example does: its caller turns and replies pass
+// through interceptors (payment redaction and moderation among them)
+// between transcript and agent and between agent and speech, its audio is
+// tapped for live listeners and supervisors can take the call over, and it
+// hangs up on termination rules that watch every turn. Each of those acts
+// inside a turn rather than around it, where Options has no hook.
+package session
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/agent"
+	"github.com/agentplexus/omnivoice/pipeline"
+	"github.com/agentplexus/omnivoice/stt"
+	"github.com/agentplexus/omnivoice/transport"
+	"github.com/agentplexus/omnivoice/tts"
+)
+
+// DefaultDTMFTimeout is how long a caller answering with keys may pause
+// before the keys pressed so far are taken as their answer.
+const DefaultDTMFTimeout = 2 * time.Second
+
+// noInputInterval is how often a session with Options.NoInput checks
+// whether the caller has gone quiet.
+const noInputInterval = 100 * time.Millisecond
+
+// Speaker is who said a line of the transcript.
+type Speaker string
+
+// Speakers in a transcript.
+const (
+	Caller Speaker = "caller"
+	Agent  Speaker = "agent"
+)
+
+// Line is one utterance in a session's transcript. An agent line is added
+// as it starts playing, so a reply cut off by the caller is still there.
+type Line struct {
+	Speaker Speaker
+	Text    string
+	At      time.Time
+}
+
+// Options configures a VoiceSession. The zero value greets with the
+// agent's own greeting, if it has one, and lets the caller barge in.
+type Options struct {
+	// SessionID identifies the session to the agent. Default the
+	// connection's ID.
+	SessionID string
+	// Metadata is passed to the agent with every turn, e.g. the call's
+	// stream parameters.
+	Metadata map[string]string
+	// Greeting is spoken as the session starts. Default the agent's
+	// greeting if it is an agent.Greeter, or none.
+	Greeting string
+
+	// STT and TTS configure the pipelines: model, language, voice and
+	// audio formats. The session sets STT's transcript and speech
+	// callbacks; its OnError, and TTS's callbacks, are called as given.
+	STT pipeline.STTPipelineConfig
+	TTS pipeline.TTSPipelineConfig
+
+	// NoBargeIn lets replies play out while the caller speaks, instead of
+	// cutting them off and abandoning the turn being answered. It
+	// overrides DoubleTalk.
+	NoBargeIn bool
+	// DoubleTalk is what the agent does when the caller talks over it.
+	// Default DoubleTalkStop. Audio a transport has already been sent and
+	// buffers, as Twilio's does, can't be taken back: DoubleTalkFinish
+	// lets it play out, and DoubleTalkDuck only lowers audio sent from
+	// then on, and only in mu-law or A-law.
+	DoubleTalk DoubleTalk
+	// DuckGain, 0 to 1, is how much DoubleTalkDuck lowers the agent's
+	// voice. Default DefaultDuckGain.
+	DuckGain float64
+
+	// NoInput, if set, gives the agent a turn with no text when the
+	// caller says nothing for that long once the agent's audio has
+	// played, e.g. to ask again, or to hang up on a silent robocall.
+	NoInput time.Duration
+
+	// DTMF answers the keys the caller presses as turns, with
+	// agent.Turn.DTMF set: keys are collected until # or a pause of
+	// DTMFTimeout, and * starts over. Without it they are ignored.
+	DTMF bool
+	// DTMFTimeout defaults to DefaultDTMFTimeout.
+	DTMFTimeout time.Duration
+
+	// OnLine, if set, is called with each line as it is added to the
+	// transcript.
+	OnLine func(Line)
+	// OnAction, if set, is called with the actions the agent takes other
+	// than hanging up, such as a transfer; without it they are logged and
+	// ignored. A hangup ends the session once everything queued has been
+	// spoken.
+	OnAction func(agent.Action)
+
+	// Logger defaults to slog.Default, tagged with the session ID.
+	Logger *slog.Logger
+}
+
+// VoiceSession is one call's conversation between a caller and an agent.
+type VoiceSession struct {
+	conn   transport.Connection
+	agent  agent.Agent
+	opts   Options
+	id     string
+	logger *slog.Logger
+	stt    *pipeline.STTPipeline
+	tts    *pipeline.TTSPipeline
+	// ducking, if set, lowers the agent's voice under DoubleTalkDuck.
+	ducking *duckingConnection
+	// playout times the agent's audio, and synthesized is signalled as
+	// each utterance has been sent.
+	playout     *playoutConnection
+	synthesized chan struct{}
+
+	// wake tells the speaker there is something to say, and ended is
+	// closed once the agent has hung up and said everything queued.
+	wake  chan struct{}
+	ended chan struct{}
+	wg    sync.WaitGroup
+
+	mu         sync.Mutex
+	ctx        context.Context // the running session's; nil until Run
+	stopped    bool
+	turns      int
+	cancelTurn context.CancelFunc
+	queue      []string
+	speaking   bool
+	answering  int
+	hangup     bool
+	transcript []Line
+	// quietSince is when the caller last spoke, and callerSpeaking
+	// whether they are speaking now.
+	quietSince     time.Time
+	callerSpeaking bool
+	// keys are the keys pressed towards the next DTMF turn, which keysDone
+	// ends after a pause.
+	keys     []byte
+	keysDone *time.Timer
+}
+
+// NewVoiceSession returns a session between the caller on conn and a,
+// listening through sttProvider and speaking through ttsProvider. Nothing
+// happens until Run.
+func NewVoiceSession(conn transport.Connection, sttProvider stt.StreamingProvider, ttsProvider tts.StreamingProvider, a agent.Agent, opts Options) *VoiceSession {
+	s := &VoiceSession{
+		conn:        conn,
+		agent:       a,
+		opts:        opts,
+		id:          opts.SessionID,
+		wake:        make(chan struct{}, 1),
+		ended:       make(chan struct{}),
+		synthesized: make(chan struct{}, 1),
+	}
+	if s.id == "" {
+		s.id = conn.ID()
+	}
+	if opts.DoubleTalk == DoubleTalkDuck && !opts.NoBargeIn {
+		gain := opts.DuckGain
+		if gain <= 0 || gain > 1 {
+			gain = DefaultDuckGain
+		}
+		if s.ducking = newDuckingConnection(conn, opts.TTS.OutputFormat, gain); s.ducking != nil {
+			s.conn = s.ducking
+		}
+	}
+	s.playout = newPlayoutConnection(s.conn, opts.TTS)
+	s.conn = s.playout
+	s.logger = opts.Logger
+	if s.logger == nil {
+		s.logger = slog.Default().With("session", s.id)
+	}
+
+	sttConfig := opts.STT
+	sttConfig.OnTranscript = s.heard
+	sttConfig.OnSpeechStart = func() {
+		s.heardSpeech(true)
+		s.bargeIn()
+	}
+	sttConfig.OnSpeechEnd = func() {
+		s.heardSpeech(false)
+		s.ducking.duck(false)
+	}
+	sttConfig.OnError = func(err error) {
+		s.logger.Error("STT error", "error", err)
+		if opts.STT.OnError != nil {
+			opts.STT.OnError(err)
+		}
+	}
+	ttsConfig := opts.TTS
+	ttsConfig.OnComplete = func() {
+		select {
+		case s.synthesized <- struct{}{}:
+		default:
+		}
+		if opts.TTS.OnComplete != nil {
+			opts.TTS.OnComplete()
+		}
+	}
+	s.stt = pipeline.NewSTTPipeline(sttProvider, sttConfig)
+	s.tts = pipeline.NewTTSPipeline(ttsProvider, ttsConfig)
+	return s
+}
+
+// ID returns the session ID the agent knows the session by.
+func (s *VoiceSession) ID() string {
+	return s.id
+}
+
+// Run greets the caller and holds the conversation until the caller hangs
+// up, the agent does, or ctx is cancelled. It returns once everything the
+// session started has stopped, with an error only if transcription
+// couldn't start. The connection is left for the caller to close.
+func (s *VoiceSession) Run(ctx context.Context) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	if e, ok := s.agent.(agent.SessionEnder); ok {
+		defer e.EndSession(s.id)
+	}
+
+	s.mu.Lock()
+	s.ctx = ctx
+	s.quietSince = time.Now()
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.speak(ctx)
+	}()
+	if s.opts.NoInput > 0 {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.awaitInput(ctx)
+		}()
+	}
+
+	greeting := s.opts.Greeting
+	if g, ok := s.agent.(agent.Greeter); ok && greeting == "" {
+		greeting = g.Greeting(s.id)
+	}
+	if greeting != "" {
+		s.Say(greeting)
+	}
+
+	err := s.stt.StartFromConnection(ctx, s.conn)
+	if err != nil {
+		err = fmt.Errorf("start STT: %w", err)
+	} else {
+		s.wait(ctx)
+		s.stt.Stop()
+	}
+
+	// No new turns; stop what's under way and wait for it
+	s.mu.Lock()
+	s.stopped = true
+	if s.keysDone != nil {
+		s.keysDone.Stop()
+	}
+	s.mu.Unlock()
+	s.tts.Stop()
+	cancel()
+	s.wg.Wait()
+	return err
+}
+
+// wait returns once the call is over.
+func (s *VoiceSession) wait(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.ended:
+			s.logger.Info("agent hung up")
+			return
+		case event, ok := <-s.conn.Events():
+			if !ok || event.Type == transport.EventDisconnected {
+				s.logger.Info("caller hung up")
+				return
+			}
+			switch event.Type {
+			case transport.EventError:
+				s.logger.Warn("stream failed", "error", event.Error)
+				return
+			case transport.EventDTMF:
+				if s.opts.DTMF {
+					s.pressed(event.Data)
+				}
+			}
+		}
+	}
+}
+
+// Say queues text to be spoken after anything already queued.
+func (s *VoiceSession) Say(text string) {
+	s.mu.Lock()
+	s.queue = append(s.queue, text)
+	s.mu.Unlock()
+	s.signal()
+}
+
+// Transcript returns the conversation so far.
+func (s *VoiceSession) Transcript() []Line {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return slices.Clone(s.transcript)
+}
+
+func (s *VoiceSession) signal() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// speak synthesizes queued text to the caller, one utterance at a time,
+// until ctx is done or the agent has hung up with nothing left to say. A
+// hangup waits for the last of the audio to play, so the caller hears the
+// goodbye.
+func (s *VoiceSession) speak(ctx context.Context) {
+	for {
+		s.mu.Lock()
+		if len(s.queue) == 0 {
+			s.speaking = false
+			hangup := s.hangup
+			s.mu.Unlock()
+			if hangup {
+				timer := time.NewTimer(time.Until(s.playout.Until()))
+				select {
+				case <-ctx.Done():
+				case <-timer.C:
+				}
+				timer.Stop()
+				close(s.ended)
+				return
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-s.wake:
+			}
+			continue
+		}
+		text := s.queue[0]
+		s.queue = s.queue[1:]
+		s.speaking = true
+		s.mu.Unlock()
+
+		s.add(Agent, text)
+		// Synthesis runs in the background; the next utterance waits for it
+		if err := s.tts.SynthesizeToConnection(ctx, text, s.conn); err != nil {
+			if ctx.Err() == nil {
+				s.logger.Error("failed to synthesize response", "error", err)
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.synthesized:
+		}
+	}
+}
+
+// awaitInput gives the agent an empty turn whenever the caller has said
+// nothing for NoInput with the agent quiet.
+func (s *VoiceSession) awaitInput(ctx context.Context) {
+	ticker := time.NewTicker(noInputInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.mu.Lock()
+		quiet := !s.speaking && len(s.queue) == 0 && s.answering == 0 && !s.hangup && !s.callerSpeaking && len(s.keys) == 0
+		since := later(s.quietSince, s.playout.Until())
+		s.mu.Unlock()
+		if quiet && time.Since(since) >= s.opts.NoInput {
+			s.logger.Info("caller said nothing", "for", s.opts.NoInput)
+			s.turn("", false)
+		}
+	}
+}
+
+// heardSpeech notes the caller starting or stopping speaking, which
+// restarts the NoInput clock.
+func (s *VoiceSession) heardSpeech(speaking bool) {
+	s.mu.Lock()
+	s.quietSince = time.Now()
+	s.callerSpeaking = speaking
+	s.mu.Unlock()
+}
+
+// heard answers each final transcript as a turn.
+func (s *VoiceSession) heard(transcript string, isFinal bool) {
+	if !isFinal {
+		s.logger.Debug("interim transcript", "text", transcript)
+		return
+	}
+	text := strings.TrimSpace(transcript)
+	if text == "" {
+		return
+	}
+	s.turn(text, false)
+}
+
+// pressed takes a key the caller pressed, which the transport reports as
+// a string such as "7" or "#". The first key of an answer cuts the agent
+// off, as speaking does.
+func (s *VoiceSession) pressed(data any) {
+	key, _ := data.(string)
+	if len(key) != 1 || !strings.Contains("0123456789*#", key) {
+		return
+	}
+	timeout := s.opts.DTMFTimeout
+	if timeout <= 0 {
+		timeout = DefaultDTMFTimeout
+	}
+
+	s.mu.Lock()
+	first := len(s.keys) == 0
+	switch key[0] {
+	case '*':
+		s.keys = s.keys[:0]
+	case '#':
+	default:
+		s.keys = append(s.keys, key[0])
+	}
+	if s.keysDone != nil {
+		s.keysDone.Stop()
+	}
+	s.quietSince = time.Now()
+	done := key[0] == '#' && len(s.keys) > 0
+	if !done {
+		s.keysDone = time.AfterFunc(timeout, s.keyed)
+	}
+	s.mu.Unlock()
+
+	if first && key[0] != '#' && key[0] != '*' {
+		s.bargeIn()
+	}
+	if done {
+		s.keyed()
+	}
+}
+
+// keyed answers the keys pressed so far as a turn.
+func (s *VoiceSession) keyed() {
+	s.mu.Lock()
+	keys := string(s.keys)
+	s.keys = nil
+	s.mu.Unlock()
+	if keys != "" {
+		s.turn(keys, true)
+	}
+}
+
+// turn has the agent answer text, abandoning the turn before it if that
+// is still being answered. Empty text is the caller saying nothing, and
+// dtmf text keys they pressed.
+func (s *VoiceSession) turn(text string, dtmf bool) {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return
+	}
+	if s.cancelTurn != nil {
+		s.cancelTurn()
+	}
+	s.turns++
+	s.answering++
+	s.quietSince = time.Now()
+	s.callerSpeaking = false
+	turn := agent.Turn{SessionID: s.id, Index: s.turns, Text: text, DTMF: dtmf, Metadata: s.opts.Metadata}
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.cancelTurn = cancel
+	s.wg.Add(1)
+	s.mu.Unlock()
+
+	switch {
+	case dtmf:
+		s.logger.Info("user pressed", "keys", text, "turn", turn.Index)
+		s.add(Caller, text)
+	case text != "":
+		s.logger.Info("user said", "text", text, "turn", turn.Index)
+		s.add(Caller, text)
+	}
+	go func() {
+		defer s.wg.Done()
+		defer cancel()
+		s.answer(ctx, turn)
+		s.mu.Lock()
+		s.answering--
+		s.quietSince = time.Now()
+		s.mu.Unlock()
+	}()
+}
+
+// answer asks the agent for its reply to turn and queues it as it streams
+// in, until ctx is cancelled by a barge-in or a newer turn.
+func (s *VoiceSession) answer(ctx context.Context, turn agent.Turn) {
+	responses, err := s.agent.OnUserTurn(ctx, turn)
+	if err != nil {
+		s.logger.Error("agent failed", "error", err, "turn", turn.Index)
+		return
+	}
+	// Drained to the end: the agent closes the channel once it stops
+	for r := range responses {
+		if ctx.Err() != nil {
+			continue
+		}
+		if r.Err != nil {
+			s.logger.Error("agent reply failed", "error", r.Err, "turn", turn.Index)
+			continue
+		}
+		if r.Text != "" {
+			s.Say(r.Text)
+		}
+		if r.Action != nil {
+			s.act(*r.Action)
+		}
+	}
+}
+
+// act carries out an action the agent took.
+func (s *VoiceSession) act(action agent.Action) {
+	if action.Kind == agent.ActionHangup {
+		s.mu.Lock()
+		s.hangup = true
+		s.mu.Unlock()
+		s.signal()
+		return
+	}
+	if s.opts.OnAction != nil {
+		s.opts.OnAction(action)
+		return
+	}
+	s.logger.Warn("agent action not supported, ignored", "action", action.Kind, "target", action.Target)
+}
+
+// bargeIn handles the caller starting to speak as DoubleTalk says. Under
+// DoubleTalkStop the agent is cut off: the turn being answered is
+// abandoned, queued speech dropped, synthesis stopped, and on transports
+// that buffer audio, such as Twilio's, what was sent is cleared.
+func (s *VoiceSession) bargeIn() {
+	if s.opts.NoBargeIn {
+		return
+	}
+	switch s.opts.DoubleTalk {
+	case DoubleTalkDuck:
+		s.ducking.duck(true)
+		return
+	case DoubleTalkFinish:
+		// The utterance being synthesized is the sentence being spoken
+		s.mu.Lock()
+		if s.cancelTurn != nil {
+			s.cancelTurn()
+			s.cancelTurn = nil
+		}
+		s.queue = nil
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Lock()
+	if s.cancelTurn != nil {
+		s.cancelTurn()
+		s.cancelTurn = nil
+	}
+	s.queue = nil
+	s.mu.Unlock()
+	if s.tts.IsActive() {
+		s.tts.Stop()
+	}
+	if c, ok := s.conn.(interface{ Clear() error }); ok {
+		if err := c.Clear(); err != nil && !errors.Is(err, errors.ErrUnsupported) {
+			s.logger.Debug("failed to clear audio", "error", err)
+		}
+	}
+}
+
+// add appends a line to the transcript.
+func (s *VoiceSession) add(speaker Speaker, text string) {
+	line := Line{Speaker: speaker, Text: text, At: time.Now()}
+	s.mu.Lock()
+	s.transcript = append(s.transcript, line)
+	s.mu.Unlock()
+	if s.opts.OnLine != nil {
+		s.opts.OnLine(line)
+	}
+}
@@ -0,0 +1,124 @@
+package session
+
+import (
+	"context"
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/agent"
+	"github.com/agentplexus/omnivoice-examples/kit/mock"
+	"github.com/agentplexus/omnivoice/pipeline"
+)
+
+var testOptions = Options{
+	STT: pipeline.STTPipelineConfig{Encoding: "mulaw", SampleRate: 8000, Channels: 1},
+	TTS: pipeline.TTSPipelineConfig{OutputFormat: "ulaw", SampleRate: 8000},
+}
+
+// run runs a session on conn until it ends, and returns it with the
+// agent audio it sent.
+func run(t *testing.T, conn *mock.Conn, sttProvider *mock.STT, a agent.Agent, opts Options) (*VoiceSession, []byte) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Second)
+	defer cancel()
+	s := NewVoiceSession(conn, sttProvider, &mock.TTS{PerChar: 20 * time.Millisecond}, a, opts)
+	if err := s.Run(ctx); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if ctx.Err() != nil {
+		t.Fatal("session didn't end")
+	}
+	if time.Now().Before(s.playout.Until()) {
+		t.Error("session ended before its audio had played")
+	}
+	_ = conn.Close()
+	audio, err := io.ReadAll(conn.Received())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, audio
+}
+
+func agentLines(lines []Line) []string {
+	var texts []string
+	for _, l := range lines {
+		if l.Speaker == Agent {
+			texts = append(texts, l.Text)
+		}
+	}
+	return texts
+}
+
+func TestSessionSpeaksEverythingBeforeHangingUp(t *testing.T) {
+	a := agent.Func(func(ctx context.Context, turn agent.Turn) (<-chan agent.Response, error) {
+		return agent.Reply(agent.Say("One."), agent.Say("Two."), agent.Do(agent.ActionHangup, "")), nil
+	})
+	opts := testOptions
+	opts.Greeting = "Hi."
+	s, audio := run(t, mock.NewConn("CA0001", nil), &mock.STT{Script: []string{"Bye."}, Interval: 50 * time.Millisecond}, a, opts)
+
+	if got, want := agentLines(s.Transcript()), []string{"Hi.", "One.", "Two."}; !slices.Equal(got, want) {
+		t.Errorf("agent said %q, want %q", got, want)
+	}
+	// 11 characters of 20ms: 220ms of 8kHz mu-law
+	if got, want := len(audio), 1760; got != want {
+		t.Errorf("sent %d bytes of audio, want %d", got, want)
+	}
+}
+
+func TestSessionNoInput(t *testing.T) {
+	var turns []agent.Turn
+	a := agent.Func(func(ctx context.Context, turn agent.Turn) (<-chan agent.Response, error) {
+		turns = append(turns, turn)
+		return agent.Reply(agent.Say("Goodbye."), agent.Do(agent.ActionHangup, "")), nil
+	})
+	opts := testOptions
+	opts.Greeting = "Hello?"
+	opts.NoInput = 200 * time.Millisecond
+	start := time.Now()
+	s, _ := run(t, mock.NewConn("CA0001", nil), &mock.STT{Script: []string{"Hi."}, Interval: time.Hour}, a, opts)
+
+	if len(turns) != 1 || turns[0].Text != "" || turns[0].Index != 1 {
+		t.Fatalf("agent got turns %+v, want one empty turn", turns)
+	}
+	if got, want := agentLines(s.Transcript()), []string{"Hello?", "Goodbye."}; !slices.Equal(got, want) {
+		t.Errorf("agent said %q, want %q", got, want)
+	}
+	// The greeting plays for 120ms before the caller is waited for
+	if elapsed := time.Since(start); elapsed < 320*time.Millisecond {
+		t.Errorf("no-input turn came after %v, before the greeting played and NoInput passed", elapsed)
+	}
+}
+
+func TestSessionDTMF(t *testing.T) {
+	var turns []agent.Turn
+	a := agent.Func(func(ctx context.Context, turn agent.Turn) (<-chan agent.Response, error) {
+		turns = append(turns, turn)
+		if turn.Text == "7" {
+			return agent.Reply(agent.Say("Thanks."), agent.Do(agent.ActionHangup, "")), nil
+		}
+		return agent.Reply(), nil
+	})
+	opts := testOptions
+	opts.DTMF = true
+	opts.DTMFTimeout = 100 * time.Millisecond
+	conn := mock.NewConn("CA0001", nil)
+	go func() {
+		conn.Press("1*42#")
+		conn.Press("7")
+	}()
+	run(t, conn, &mock.STT{Script: []string{"Hi."}, Interval: time.Hour}, a, opts)
+
+	var keys []string
+	for _, turn := range turns {
+		if !turn.DTMF {
+			t.Errorf("turn %q isn't DTMF", turn.Text)
+		}
+		keys = append(keys, turn.Text)
+	}
+	if want := []string{"42", "7"}; !slices.Equal(keys, want) {
+		t.Errorf("agent got keys %q, want %q", keys, want)
+	}
+}
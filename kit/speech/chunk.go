@@ -0,0 +1,163 @@
+package speech
+
+import (
+	"strings"
+	"unicode"
+)
+
+// abbreviations end in a period that doesn't end the sentence. Words with
+// periods inside ("e.g.", "U.S.") and single letters (initials) are
+// treated the same way.
+var abbreviations = map[string]bool{
+	"mr": true, "mrs": true, "ms": true, "dr": true, "prof": true,
+	"sr": true, "jr": true, "st": true, "mt": true, "ave": true,
+	"no": true, "vs": true, "approx": true, "dept": true, "apt": true,
+	"inc": true, "ltd": true, "co": true, "corp": true,
+}
+
+// Chunker splits streamed text into chunks to synthesize one at a time.
+// Chunks end at sentence ends and line breaks; clause breaks (commas,
+// semicolons, colons and dashes) end the first chunk of a reply once it
+// has FirstClause bytes, and a sentence running past MaxLen bytes. A
+// Chunker is not safe for concurrent use.
+type Chunker struct {
+	// FirstClause is how long the first chunk must be before it may end
+	// at a clause break, so speech starts sooner; 0 waits for the end of
+	// the first sentence.
+	FirstClause int
+	// MaxLen is how long a sentence may run before it is split at its
+	// last clause break, or failing that its last word; 0 never splits a
+	// sentence.
+	MaxLen int
+
+	buf    string
+	chunks int // emitted since the last Flush
+}
+
+// NewChunker returns a chunker that ends the first chunk at a clause
+// break after 30 bytes and splits sentences longer than 200.
+func NewChunker() *Chunker {
+	return &Chunker{FirstClause: 30, MaxLen: 200}
+}
+
+// Write adds streamed text and returns the chunks it completes, in order
+// and trimmed of surrounding whitespace.
+func (c *Chunker) Write(text string) []string {
+	c.buf += text
+	var chunks []string
+	for {
+		i := c.boundary()
+		if i == 0 {
+			return chunks
+		}
+		if chunk := strings.TrimSpace(c.buf[:i]); chunk != "" {
+			chunks = append(chunks, chunk)
+			c.chunks++
+		}
+		c.buf = c.buf[i:]
+	}
+}
+
+// Flush returns the text not yet chunked, trimmed, at the end of a reply,
+// and readies the chunker for the next.
+func (c *Chunker) Flush() string {
+	rest := strings.TrimSpace(c.buf)
+	c.buf, c.chunks = "", 0
+	return rest
+}
+
+// boundary returns the index just past the end of the first complete
+// chunk in the buffer, or 0 if there is none yet. A break only counts
+// once the whitespace after it has arrived: until then "$42." may still
+// become "$42.50".
+func (c *Chunker) boundary() int {
+	start := len(c.buf) - len(strings.TrimLeftFunc(c.buf, unicode.IsSpace))
+	clause, word := 0, 0 // the last clause and word breaks seen
+	for i := start; i < len(c.buf); i++ {
+		if c.MaxLen > 0 && i-start >= c.MaxLen {
+			if clause-start >= c.MaxLen/2 {
+				return clause
+			}
+			if word > start {
+				return word
+			}
+		}
+
+		ch := c.buf[i]
+		switch {
+		case ch == '\n':
+			return i + 1
+		case ch == ' ' || ch == '\t':
+			word = i
+			continue
+		case strings.HasPrefix(c.buf[i:], "—"):
+			// Em dashes often have no spaces around them
+			if i+len("—") < len(c.buf) {
+				clause = i + len("—")
+				if c.eager(clause - start) {
+					return clause
+				}
+			}
+			continue
+		}
+
+		// Punctuation counts once whatever closes around it (quotes,
+		// brackets) and the whitespace after it have arrived
+		end := i + 1
+		for end < len(c.buf) && strings.IndexByte(`"')]`, c.buf[end]) >= 0 {
+			end++
+		}
+		if end == len(c.buf) || (c.buf[end] != ' ' && c.buf[end] != '\t' && c.buf[end] != '\n') {
+			continue
+		}
+		switch ch {
+		case '!', '?':
+			return end
+		case '.':
+			if !c.abbreviation(start, i) {
+				return end
+			}
+		case ',', ';', ':':
+			clause = end
+			if c.eager(clause - start) {
+				return clause
+			}
+		case '-':
+			// A spaced hyphen stands in for a dash
+			if i > start && c.buf[i-1] == ' ' {
+				clause = end
+				if c.eager(clause - start) {
+					return clause
+				}
+			}
+		}
+	}
+	return 0
+}
+
+// eager reports whether a first chunk of n bytes may end at a clause
+// break.
+func (c *Chunker) eager(n int) bool {
+	return c.chunks == 0 && c.FirstClause > 0 && n >= c.FirstClause
+}
+
+// abbreviation reports whether the period at i, in the chunk starting at
+// start, ends an abbreviation or a list item's number rather than a
+// sentence.
+func (c *Chunker) abbreviation(start, i int) bool {
+	from := strings.LastIndexAny(c.buf[start:i], " \t\n") + 1 + start
+	word := c.buf[from:i]
+	switch {
+	case word == "":
+		return false
+	case strings.Contains(word, "."):
+		return true
+	case len(word) == 1 && unicode.IsLetter(rune(word[0])):
+		return true
+	case abbreviations[strings.ToLower(strings.TrimLeft(word, `"'(`))]:
+		return true
+	}
+	// "1. " opening a line numbers a list item
+	lineStart := strings.LastIndexByte(c.buf[:from], '\n') + 1
+	return from == max(lineStart, start) && strings.TrimFunc(word, unicode.IsDigit) == ""
+}
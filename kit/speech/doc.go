@@ -0,0 +1,46 @@
+// Package speech prepares a language model's streamed text for speech
+// synthesis, and reads values from what callers said.
+//
+// A Chunker splits text as it streams in at boundaries that sound natural
+// when each piece is synthesized on its own: sentence ends, line breaks
+// (list items, paragraphs) and, for the first chunk of a reply or a
+// sentence that runs long, clause breaks. Speech can start on the first
+// chunk while the model is still writing the rest:
+//
+//	c := speech.NewChunker()
+//	provider.Stream(ctx, req, func(text string) {
+//		for _, chunk := range c.Write(text) {
+//			speak(chunk)
+//		}
+//	})
+//	speak(c.Flush())
+//
+// Normalize rewrites a chunk the way it should be read aloud: amounts of
+// money, times, percentages, ordinals, phone numbers and other numbers
+// become words ("$42.50" is "forty-two dollars and fifty cents"), and
+// markdown the model slipped in is dropped. Chunks only end at
+// whitespace, so a number is never split across two of them.
+//
+// Markup builds on Normalize for data read back to a caller, in the
+// dialect of markup the TTS provider reads: SSML for providers that take
+// it, <break> tags alone for ElevenLabs, and punctuation for the rest. It
+// adds a pause after each question, reads phone numbers slowly in their
+// groups, and spells out confirmation codes a character at a time:
+//
+//	m := speech.NewMarkup(speech.DialectFor(provider.Name()))
+//	provider.SynthesizeStream(ctx, m.Build(chunk), config)
+//
+// A Lexicon corrects how Markup has brand and product names said, with a
+// respelling or IPA phonemes in the form each dialect takes.
+//
+// A Tone reads the emotion tags a model was asked (EmotionInstructions)
+// to write in its replies, such as "[apologetic] I'm sorry to hear
+// that.", splitting each chunk into spans of one emotion with the tags
+// stripped. A host maps each emotion to its TTS provider's controls, such
+// as SSML prosody with Markup.BuildWith.
+//
+// The other way, Denormalize rewrites what a recognizer transcribed with
+// its values as they are written, for slot filling: "five five five one
+// two one two" is "555-1212", "march third" is a date and "john dot smith
+// at gmail dot com" an email address.
+package speech
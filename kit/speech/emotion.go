@@ -0,0 +1,115 @@
+package speech
+
+import (
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// Neutral is the tag that returns a reply to the voice's normal delivery.
+const Neutral = "neutral"
+
+// emotionTag is a tag such as [cheerful] or [Apologetic].
+var emotionTag = regexp.MustCompile(`\[\s*([A-Za-z][A-Za-z _-]*?)\s*\]\s*`)
+
+// Span is a stretch of a reply spoken with one emotion. Emotion is "" for
+// the voice's normal delivery.
+type Span struct {
+	Text    string
+	Emotion string
+}
+
+// Tone follows the emotion tags a language model writes in a reply, such
+// as "[apologetic] I'm sorry to hear that.", through the reply's chunks. A
+// tag holds until the next one, so it carries over from chunk to chunk;
+// [neutral] ends it. Only tags naming a known emotion are taken: other
+// bracketed text is left as it is. Use a new Tone for each reply.
+type Tone struct {
+	known   []string
+	current string
+}
+
+// NewTone returns a tone that knows the emotions named, in lowercase.
+func NewTone(emotions ...string) *Tone {
+	return &Tone{known: emotions}
+}
+
+// Read strips the known tags from chunk and returns its text split into
+// spans at them, each with the emotion it is spoken with. Text before the
+// chunk's first tag keeps the emotion of the chunks before it.
+func (t *Tone) Read(chunk string) []Span {
+	var spans []Span
+	add := func(text string) {
+		text = strings.TrimSpace(text)
+		if text == "" {
+			return
+		}
+		if n := len(spans); n > 0 && spans[n-1].Emotion == t.current {
+			spans[n-1].Text += " " + text
+			return
+		}
+		spans = append(spans, Span{Text: text, Emotion: t.current})
+	}
+	last := 0
+	for _, loc := range emotionTag.FindAllStringSubmatchIndex(chunk, -1) {
+		name := strings.ToLower(chunk[loc[2]:loc[3]])
+		if name != Neutral && !slices.Contains(t.known, name) {
+			continue
+		}
+		add(chunk[last:loc[0]])
+		t.current = name
+		if name == Neutral {
+			t.current = ""
+		}
+		last = loc[1]
+	}
+	add(chunk[last:])
+	return spans
+}
+
+// Text returns the text of spans without their tags.
+func Text(spans []Span) string {
+	texts := make([]string, len(spans))
+	for i, s := range spans {
+		texts[i] = s.Text
+	}
+	return strings.Join(texts, " ")
+}
+
+// EmotionInstructions tells a language model how to tag its replies with
+// the emotions named, for a system prompt.
+func EmotionInstructions(emotions []string) string {
+	tags := make([]string, len(emotions))
+	for i, e := range emotions {
+		tags[i] = "[" + e + "]"
+	}
+	return "Your replies are spoken aloud. When part of a reply should sound a particular way, start it with one of these tags: " +
+		strings.Join(tags, ", ") + ". A tag holds until the next one, and [" + Neutral + "] returns to your normal tone. " +
+		"Use them sparingly, only where the feeling fits, and never mention them."
+}
+
+// Prosody is how SSML has a stretch of speech spoken, as the attributes
+// of a <prosody> element: e.g. a rate of "slow" or "95%", a pitch of
+// "+5%" or "low", a volume of "soft". Empty attributes are left out.
+type Prosody struct {
+	Rate   string
+	Pitch  string
+	Volume string
+}
+
+// wrap puts text in a <prosody> element, or returns it as it is if p sets
+// nothing.
+func (p Prosody) wrap(text string) string {
+	if p == (Prosody{}) {
+		return text
+	}
+	var b strings.Builder
+	b.WriteString("<prosody")
+	for _, attr := range [...]struct{ name, value string }{{"rate", p.Rate}, {"pitch", p.Pitch}, {"volume", p.Volume}} {
+		if attr.value != "" {
+			b.WriteString(" " + attr.name + `="` + xmlEscaper.Replace(attr.value) + `"`)
+		}
+	}
+	b.WriteString(">" + text + "</prosody>")
+	return b.String()
+}
@@ -0,0 +1,657 @@
+package speech
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Denormalize rewrites a transcript with its numbers, dates, times,
+// amounts and email addresses as they are written, the inverse of
+// Normalize, so a value reads the same however the recognizer wrote it:
+// "five five five one two one two" is "555-1212", "march third" is
+// "2025-03-03", "one twenty three main street" is "123 main street" and
+// "forty two dollars and fifty cents" is "$42.50". A date said without a
+// year is in the year of now. Numbers under ten said on their own ("one
+// of them") and other words are kept as transcribed.
+func Denormalize(text string, now time.Time) string {
+	toks := tokenize(text)
+	var out []string
+	for i := 0; i < len(toks); {
+		s, n := denormalizeEmail(toks, i)
+		if n == 0 {
+			s, n = denormalizeDate(toks, i, now)
+		}
+		if n == 0 {
+			s, n = denormalizeNumber(toks, i)
+		}
+		if n > 0 {
+			out = append(out, s+toks[i+n-1].trail)
+			i += n
+			continue
+		}
+		if toks[i].joined && len(out) > 0 {
+			out[len(out)-1] += "-" + toks[i].raw
+		} else {
+			out = append(out, toks[i].raw)
+		}
+		i++
+	}
+	return strings.Join(out, " ")
+}
+
+// token is a word of a transcript.
+type token struct {
+	// raw is the word as transcribed, with any trailing punctuation.
+	raw string
+	// word is the word in lower case, without trailing punctuation.
+	word  string
+	trail string
+	// joined is set on the second and later parts of a hyphenated number
+	// ("twenty-five"), split to be read as words.
+	joined bool
+}
+
+func tokenize(text string) []token {
+	var toks []token
+	for _, f := range strings.Fields(text) {
+		core := strings.TrimRight(f, ",.?!;:")
+		trail := f[len(core):]
+		word := strings.ToLower(core)
+		parts := strings.Split(word, "-")
+		if len(parts) > 1 && allNumberWords(parts) {
+			for i, p := range parts {
+				t := token{raw: p, word: p, joined: i > 0}
+				if i == len(parts)-1 {
+					t.raw += trail
+					t.trail = trail
+				}
+				toks = append(toks, t)
+			}
+			continue
+		}
+		toks = append(toks, token{raw: f, word: word, trail: trail})
+	}
+	return toks
+}
+
+func allNumberWords(words []string) bool {
+	for _, w := range words {
+		if _, ok := numberWords[w]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// ends reports whether t ends a sentence, which a number doesn't run on
+// past.
+func (t token) ends() bool { return strings.ContainsAny(t.trail, ".?!") }
+
+// wordAt returns the word at i, or "" past the end.
+func wordAt(toks []token, i int) string {
+	if i < 0 || i >= len(toks) {
+		return ""
+	}
+	return toks[i].word
+}
+
+// numberKind is what a number word is, which decides what may follow it.
+type numberKind int
+
+const (
+	unitWord numberKind = iota + 1
+	teenWord
+	tensWord
+	hundredWord
+	scaleWord
+)
+
+type numberWord struct {
+	value   int64
+	kind    numberKind
+	ordinal bool
+}
+
+// numberWords are the words numbers are said in, cardinal and ordinal.
+var numberWords = map[string]numberWord{
+	"hundred": {100, hundredWord, false}, "thousand": {1e3, scaleWord, false},
+	"million": {1e6, scaleWord, false}, "billion": {1e9, scaleWord, false},
+}
+
+func init() {
+	for n := int64(0); n < 20; n++ {
+		kind := unitWord
+		if n >= 10 {
+			kind = teenWord
+		}
+		numberWords[smallNumbers[n]] = numberWord{n, kind, false}
+		if n > 0 {
+			numberWords[spellOrdinal(n)] = numberWord{n, kind, true}
+		}
+	}
+	for n := int64(2); n < 10; n++ {
+		numberWords[tensNames[n]] = numberWord{n * 10, tensWord, false}
+		numberWords[spellOrdinal(n*10)] = numberWord{n * 10, tensWord, true}
+	}
+}
+
+// chunk is a number said on its own: "twenty five", "one hundred and
+// five", "third".
+type chunk struct {
+	value int64
+	text  string
+	words int
+	// digit is a single digit said as one word, which may be read in a
+	// run of digits ("five five five").
+	digit   bool
+	ordinal bool
+}
+
+// parseChunk reads the number said at toks[i], if any.
+func parseChunk(toks []token, i int) (chunk, bool) {
+	w := wordAt(toks, i)
+	if isNumeral(w) {
+		n, err := strconv.ParseInt(w, 10, 64)
+		return chunk{value: n, text: w, words: 1, digit: len(w) == 1}, err == nil
+	}
+
+	var total, current int64
+	var last numberKind
+	ordinal := false
+	j := i
+	for ; j < len(toks) && !ordinal; j++ {
+		if j > i && toks[j-1].ends() {
+			break
+		}
+		w := toks[j].word
+		// "a hundred", "a thousand"
+		if w == "a" && last == 0 {
+			if next, ok := numberWords[wordAt(toks, j+1)]; ok && next.kind >= hundredWord {
+				current, last = 1, unitWord
+				continue
+			}
+			break
+		}
+		// "one hundred and five"
+		if w == "and" && (last == hundredWord || last == scaleWord) {
+			if next, ok := numberWords[wordAt(toks, j+1)]; ok && next.kind <= tensWord {
+				continue
+			}
+			break
+		}
+		nw, ok := numberWords[w]
+		if !ok {
+			break
+		}
+		afterScale := last == 0 || last == hundredWord || last == scaleWord
+		switch nw.kind {
+		case unitWord:
+			if nw.value == 0 && last != 0 {
+				ok = false
+			} else if afterScale || (last == tensWord && current%10 == 0 && nw.value > 0) {
+				current += nw.value
+			} else {
+				ok = false
+			}
+		case teenWord, tensWord:
+			if afterScale {
+				current += nw.value
+			} else {
+				ok = false
+			}
+		case hundredWord:
+			if last != 0 && last != hundredWord && last != scaleWord && current > 0 && current < 100 && !nw.ordinal {
+				current *= 100
+			} else {
+				ok = false
+			}
+		case scaleWord:
+			if last != 0 && last != scaleWord && current > 0 {
+				total += current * nw.value
+				current = 0
+			} else {
+				ok = false
+			}
+		}
+		if !ok {
+			break
+		}
+		last = nw.kind
+		ordinal = nw.ordinal
+		// Zero is never part of a longer number
+		if nw.kind == unitWord && nw.value == 0 {
+			j++
+			break
+		}
+	}
+	if last == 0 {
+		return chunk{}, false
+	}
+	value := total + current
+	words := j - i
+	return chunk{
+		value:   value,
+		text:    strconv.FormatInt(value, 10),
+		words:   words,
+		digit:   words == 1 && last == unitWord && !ordinal,
+		ordinal: ordinal,
+	}, true
+}
+
+// parseRun reads the numbers said one after another at toks[i]: a
+// single number, or digits and groups of digits ("five five five",
+// "one twenty three", "nineteen oh five", "double seven"). It returns
+// them with the number of words they took.
+func parseRun(toks []token, i int) ([]chunk, int) {
+	var run []chunk
+	j := i
+	for j < len(toks) {
+		if j > i && toks[j-1].ends() {
+			break
+		}
+		w := toks[j].word
+		repeat := 1
+		if w == "double" || w == "triple" {
+			if c, ok := parseChunk(toks, j+1); !ok || !c.digit {
+				break
+			}
+			repeat = 2
+			if w == "triple" {
+				repeat = 3
+			}
+			j++
+			w = toks[j].word
+		}
+
+		var c chunk
+		// "Oh" is a zero among other digits
+		if w == "oh" {
+			if _, ok := parseChunk(toks, j+1); len(run) == 0 && !ok {
+				break
+			}
+			c = chunk{text: "0", words: 1, digit: true}
+		} else {
+			var ok bool
+			if c, ok = parseChunk(toks, j); !ok {
+				break
+			}
+		}
+		for range repeat {
+			run = append(run, c)
+		}
+		j += c.words
+		if c.ordinal {
+			break
+		}
+	}
+	return run, j - i
+}
+
+// streetSuffixes are the words that end a street name, after which a
+// number is a house number.
+var streetSuffixes = map[string]bool{
+	"street": true, "st": true, "avenue": true, "ave": true, "road": true, "rd": true,
+	"boulevard": true, "blvd": true, "lane": true, "ln": true, "drive": true, "dr": true,
+	"court": true, "ct": true, "way": true, "place": true, "pl": true, "terrace": true,
+	"circle": true, "highway": true, "parkway": true,
+}
+
+// unitWords are the words before a number that is always written in
+// digits: "apartment four b" is "apartment 4B".
+var unitWords = map[string]bool{
+	"apartment": true, "apt": true, "unit": true, "suite": true, "room": true,
+	"floor": true, "flat": true, "building": true, "number": true,
+}
+
+// beforeStreet reports whether one of the few words at i ends a street
+// name.
+func beforeStreet(toks []token, i int) bool {
+	for j := i; j < i+3 && j < len(toks); j++ {
+		if streetSuffixes[toks[j].word] {
+			return true
+		}
+		if toks[j].ends() {
+			break
+		}
+	}
+	return false
+}
+
+// denormalizeNumber rewrites the number said at toks[i], with what it
+// counts: an amount, a percentage, a time, a phone number, an address or
+// a plain number. It returns the words it took, 0 if it left them.
+func denormalizeNumber(toks []token, i int) (string, int) {
+	run, n := parseRun(toks, i)
+	if len(run) == 0 {
+		return "", 0
+	}
+	end := i + n
+	last := run[len(run)-1]
+	var b strings.Builder
+	for _, c := range run {
+		b.WriteString(c.text)
+	}
+	text := b.String()
+	prev := wordAt(toks, i-1)
+
+	// "two point five"
+	decimal := false
+	if wordAt(toks, end) == "point" && !last.ordinal && !toks[end-1].ends() {
+		if fraction, m := parseRun(toks, end+1); len(fraction) > 0 && allDigits(fraction) {
+			text += "."
+			for _, c := range fraction {
+				text += c.text
+			}
+			end += 1 + m
+			decimal = true
+		}
+	}
+
+	next := wordAt(toks, end)
+	if toks[end-1].ends() {
+		next = ""
+	}
+	switch {
+	case last.ordinal:
+		if len(run) > 1 || (last.value < 10 && !beforeStreet(toks, end)) {
+			return "", 0
+		}
+		return text + ordinalSuffix(last.value), end - i
+
+	case next == "dollars" || next == "dollar" || next == "bucks":
+		s := "$" + text
+		end++
+		// "and fifty cents"
+		j := end
+		if wordAt(toks, j) == "and" {
+			j++
+		}
+		if cents, m := parseRun(toks, j); len(cents) == 1 && cents[0].value < 100 && !decimal {
+			if w := wordAt(toks, j+m); w == "cents" || w == "cent" {
+				s += fmt.Sprintf(".%02d", cents[0].value)
+				end = j + m + 1
+			}
+		}
+		return s, end - i
+
+	case (next == "cents" || next == "cent") && len(run) == 1 && last.value < 100 && !decimal:
+		return fmt.Sprintf("$0.%02d", last.value), end + 1 - i
+
+	case next == "percent":
+		return text + "%", end + 1 - i
+	case next == "per" && wordAt(toks, end+1) == "cent":
+		return text + "%", end + 2 - i
+	}
+
+	if !decimal {
+		if s, m, ok := spokenTime(toks, run, end, prev == "at" && !beforeStreet(toks, end)); ok {
+			return s, end + m - i
+		}
+	}
+
+	// "apartment four b"
+	if unitWords[prev] && len(run) == 1 && !decimal {
+		if w := wordAt(toks, end); len(w) == 1 && w[0] >= 'a' && w[0] <= 'z' && !toks[end-1].ends() {
+			return text + strings.ToUpper(w), end + 1 - i
+		}
+	}
+
+	switch {
+	case len(run) > 1:
+		if allDigits(run) {
+			return phoneDigits(text), end - i
+		}
+		return text, end - i
+	case decimal || last.value >= 10 || unitWords[prev] || beforeStreet(toks, end):
+		return text, end - i
+	default:
+		return "", 0
+	}
+}
+
+// spokenTime reads a time of day: the hour and minutes in run, then "am",
+// "pm" or "o'clock" at toks[i]. With bare set, "three thirty" is a time
+// without them. It returns the time and the words after run it took.
+func spokenTime(toks []token, run []chunk, i int, bare bool) (string, int, bool) {
+	hour := run[0].value
+	if hour < 1 || hour > 12 || run[0].ordinal {
+		return "", 0, false
+	}
+	minute := int64(-1)
+	switch {
+	case len(run) == 1:
+		minute = 0
+	case len(run) == 2 && !run[1].digit && run[1].value >= 10 && run[1].value < 60:
+		minute = run[1].value
+	case len(run) == 3 && run[1].text == "0" && run[2].digit:
+		minute = run[2].value
+	}
+	if minute < 0 || run[len(run)-1].ordinal {
+		return "", 0, false
+	}
+	clock := fmt.Sprintf("%d:%02d", hour, minute)
+
+	var meridiem string
+	var n int
+	switch w := wordAt(toks, i); {
+	case toks[i-1].ends():
+	case w == "am" || w == "a.m":
+		meridiem, n = "AM", 1
+	case w == "pm" || w == "p.m":
+		meridiem, n = "PM", 1
+	case (w == "a" || w == "p") && wordAt(toks, i+1) == "m" && !toks[i].ends():
+		meridiem, n = strings.ToUpper(w)+"M", 2
+	case w == "o'clock" && len(run) == 1:
+		return clock, 1, true
+	}
+	switch {
+	case meridiem != "":
+		return clock + " " + meridiem, n, true
+	case bare && len(run) > 1:
+		return clock, 0, true
+	default:
+		return "", 0, false
+	}
+}
+
+// isNumeral reports whether w is written in digits.
+func isNumeral(w string) bool {
+	return w != "" && strings.Trim(w, "0123456789") == ""
+}
+
+func allDigits(run []chunk) bool {
+	for _, c := range run {
+		if !c.digit {
+			return false
+		}
+	}
+	return true
+}
+
+// phoneDigits groups a run of digits as a phone number if it is as long
+// as one: 555-1212, 415-555-1212 or 1-415-555-1212.
+func phoneDigits(s string) string {
+	switch {
+	case len(s) == 7:
+		return s[:3] + "-" + s[3:]
+	case len(s) == 10:
+		return s[:3] + "-" + s[3:6] + "-" + s[6:]
+	case len(s) == 11 && s[0] == '1':
+		return s[:1] + "-" + s[1:4] + "-" + s[4:7] + "-" + s[7:]
+	default:
+		return s
+	}
+}
+
+func ordinalSuffix(n int64) string {
+	if n%100 >= 11 && n%100 <= 13 {
+		return "th"
+	}
+	switch n % 10 {
+	case 1:
+		return "st"
+	case 2:
+		return "nd"
+	case 3:
+		return "rd"
+	default:
+		return "th"
+	}
+}
+
+// months are the month names, and their abbreviations.
+var months = map[string]time.Month{
+	"january": time.January, "jan": time.January, "february": time.February, "feb": time.February,
+	"march": time.March, "april": time.April, "apr": time.April, "may": time.May,
+	"june": time.June, "july": time.July, "august": time.August, "aug": time.August,
+	"september": time.September, "sept": time.September, "sep": time.September,
+	"october": time.October, "oct": time.October, "november": time.November, "nov": time.November,
+	"december": time.December, "dec": time.December,
+}
+
+// denormalizeDate rewrites the date said at toks[i], "march third",
+// "the third of march" or "march 3rd 2025", as 2025-03-03.
+func denormalizeDate(toks []token, i int, now time.Time) (string, int) {
+	j := i
+	month, ok := months[wordAt(toks, j)]
+	var day int64
+	if ok {
+		j++
+		if wordAt(toks, j) == "the" {
+			j++
+		}
+		d, n, ordinal := dayAt(toks, j)
+		// "May" and "march" are words too, so only an ordinal makes them
+		// a month
+		w := wordAt(toks, i)
+		if n == 0 || toks[j-1].ends() || (!ordinal && !isNumeral(wordAt(toks, j)) && (w == "may" || w == "march")) {
+			return "", 0
+		}
+		day, j = d, j+n
+	} else {
+		if wordAt(toks, j) == "the" {
+			j++
+		}
+		d, n, ordinal := dayAt(toks, j)
+		if n == 0 || !ordinal || wordAt(toks, j+n) != "of" {
+			return "", 0
+		}
+		if month, ok = months[wordAt(toks, j+n+1)]; !ok {
+			return "", 0
+		}
+		day, j = d, j+n+2
+	}
+
+	year := now.Year()
+	if !toks[j-1].ends() {
+		if run, n := parseRun(toks, j); len(run) > 0 && !run[len(run)-1].ordinal {
+			var b strings.Builder
+			for _, c := range run {
+				b.WriteString(c.text)
+			}
+			if y, err := strconv.Atoi(b.String()); err == nil && y >= 1900 && y < 2100 {
+				year, j = y, j+n
+			}
+		}
+	}
+
+	date := time.Date(year, month, int(day), 0, 0, 0, 0, time.UTC)
+	if day < 1 || date.Month() != month {
+		return "", 0
+	}
+	return date.Format("2006-01-02"), j - i
+}
+
+// dayAt reads a day of the month at toks[i], as words or digits, and
+// whether it was said as an ordinal ("third", "3rd").
+func dayAt(toks []token, i int) (day int64, words int, ordinal bool) {
+	w := wordAt(toks, i)
+	if digits := strings.TrimRight(w, "stndrh"); digits != "" && len(digits) <= 2 && strings.Trim(digits, "0123456789") == "" {
+		d, _ := strconv.ParseInt(digits, 10, 64)
+		if suffix := w[len(digits):]; suffix == "" || suffix == ordinalSuffix(d) {
+			return d, 1, suffix != ""
+		}
+		return 0, 0, false
+	}
+	c, ok := parseChunk(toks, i)
+	if !ok || c.value > 31 {
+		return 0, 0, false
+	}
+	return c.value, c.words, c.ordinal
+}
+
+// emailTLDs are the top-level domains an email address said aloud may
+// end in.
+var emailTLDs = map[string]bool{
+	"com": true, "net": true, "org": true, "edu": true, "gov": true, "io": true,
+	"co": true, "us": true, "uk": true, "ca": true, "info": true, "biz": true, "me": true,
+}
+
+// emailLinks are the words said between the parts of an email address.
+var emailLinks = map[string]string{
+	"dot": ".", "underscore": "_", "dash": "-", "hyphen": "-",
+}
+
+// notMailbox are words before "at" that aren't the start of an address:
+// "email me at", "reach us at".
+var notMailbox = map[string]bool{
+	"me": true, "us": true, "him": true, "her": true, "them": true, "it": true,
+	"is": true, "was": true, "email": true, "mail": true, "address": true, "be": true,
+}
+
+// denormalizeEmail rewrites the email address said at toks[i], "john dot
+// smith at gmail dot com", as john.smith@gmail.com.
+func denormalizeEmail(toks []token, i int) (string, int) {
+	if notMailbox[wordAt(toks, i)] {
+		return "", 0
+	}
+	var b strings.Builder
+	j := i
+	// parts reads words joined by link words, and returns how many dots
+	// joined them
+	parts := func() (dots int, last string) {
+		for {
+			w := wordAt(toks, j)
+			if !mailboxWord(w) {
+				return dots, ""
+			}
+			b.WriteString(w)
+			last = w
+			j++
+			link, ok := emailLinks[wordAt(toks, j)]
+			if !ok || !mailboxWord(wordAt(toks, j+1)) || toks[j-1].ends() {
+				return dots, last
+			}
+			if link == "." {
+				dots++
+			}
+			b.WriteString(link)
+			j++
+		}
+	}
+	if _, last := parts(); last == "" || wordAt(toks, j) != "at" || toks[j-1].ends() {
+		return "", 0
+	}
+	b.WriteByte('@')
+	j++
+	if dots, last := parts(); dots == 0 || !emailTLDs[last] {
+		return "", 0
+	}
+	return b.String(), j - i
+}
+
+// mailboxWord reports whether w may be part of an email address.
+func mailboxWord(w string) bool {
+	if w == "" || w == "at" || emailLinks[w] != "" {
+		return false
+	}
+	for _, r := range w {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && !strings.ContainsRune("._-", r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,49 @@
+package speech
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDenormalize(t *testing.T) {
+	now := time.Date(2025, time.January, 10, 0, 0, 0, 0, time.UTC)
+	for _, tt := range []struct {
+		said, want string
+	}{
+		// Phone numbers
+		{"five five five one two one two", "555-1212"},
+		{"call four one five five five five one two one two", "call 415-555-1212"},
+		{"double seven", "77"},
+		// Dates, in the year of now unless one is said
+		{"march third", "2025-03-03"},
+		{"the third of march", "2025-03-03"},
+		{"march 3rd 2026", "2026-03-03"},
+		// Times
+		{"three thirty pm", "3:30 PM"},
+		{"seven o'clock", "7:00"},
+		{"meet at three thirty", "meet at 3:30"},
+		// Amounts and percentages
+		{"forty two dollars and fifty cents", "$42.50"},
+		{"fifty percent", "50%"},
+		// Addresses
+		{"one twenty three main street", "123 main street"},
+		{"apartment four b", "apartment 4B"},
+		// Plain numbers
+		{"twenty-five", "25"},
+		{"It's twenty five.", "It's 25."},
+		{"one hundred and five", "105"},
+		{"nineteen oh five", "1905"},
+		{"twenty five thousand", "25000"},
+		// Email addresses
+		{"john dot smith at gmail dot com", "john.smith@gmail.com"},
+		{"email me at john at example dot com", "email me at john@example.com"},
+		// Left as transcribed
+		{"one of them", "one of them"},
+		{"I have two dogs and three cats", "I have two dogs and three cats"},
+		{"The first one.", "The first one."},
+	} {
+		if got := Denormalize(tt.said, now); got != tt.want {
+			t.Errorf("Denormalize(%q) = %q, want %q", tt.said, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package speech
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Pronunciation is how a word or name is said: respelled in ordinary
+// letters ("nuh-WIN"), in IPA phonemes ("ŋwɪən"), or both.
+type Pronunciation struct {
+	Respelling string
+	IPA        string
+}
+
+// Lexicon corrects how TTS providers say brand, product and other names
+// they get wrong. Words are matched whole and regardless of case; the
+// longest entry wins where entries overlap. A nil Lexicon corrects
+// nothing.
+type Lexicon struct {
+	entries map[string]Pronunciation
+	pattern *regexp.Regexp
+	key     string
+}
+
+// NewLexicon returns a lexicon of the pronunciations of the words in
+// entries, each of which needs a respelling or IPA, or nil if entries is
+// empty.
+func NewLexicon(entries map[string]Pronunciation) (*Lexicon, error) {
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	l := &Lexicon{entries: make(map[string]Pronunciation, len(entries))}
+	h := sha256.New()
+	words := slices.Sorted(maps.Keys(entries))
+	for _, word := range words {
+		p := entries[word]
+		if strings.TrimSpace(word) == "" {
+			return nil, errors.New("lexicon entry with no word")
+		}
+		if strings.TrimSpace(p.Respelling) == "" && strings.TrimSpace(p.IPA) == "" {
+			return nil, fmt.Errorf("lexicon entry %q has neither a respelling nor IPA", word)
+		}
+		l.entries[strings.ToLower(word)] = p
+		fmt.Fprintf(h, "%s\x00%s\x00%s\x00", word, p.Respelling, p.IPA)
+	}
+	// Longest first, so "Acme Cloud" wins over "Acme"
+	slices.SortStableFunc(words, func(a, b string) int { return len(b) - len(a) })
+	quoted := make([]string, len(words))
+	for i, w := range words {
+		quoted[i] = regexp.QuoteMeta(w)
+	}
+	l.pattern = regexp.MustCompile(`(?i)` + strings.Join(quoted, "|"))
+	l.key = hex.EncodeToString(h.Sum(nil)[:8])
+	return l, nil
+}
+
+// Key identifies the lexicon's entries, e.g. to keep audio spoken with
+// different lexicons apart. It is "" for a nil Lexicon.
+func (l *Lexicon) Key() string {
+	if l == nil {
+		return ""
+	}
+	return l.key
+}
+
+// lexiconMatch is a word of the lexicon found in text.
+type lexiconMatch struct {
+	start, end int
+	p          Pronunciation
+}
+
+// find returns the words of the lexicon in text, in order.
+func (l *Lexicon) find(text string) []lexiconMatch {
+	if l == nil {
+		return nil
+	}
+	var matches []lexiconMatch
+	for _, loc := range l.pattern.FindAllStringIndex(text, -1) {
+		if !wordBoundary(text, loc[0], loc[1]) {
+			continue
+		}
+		matches = append(matches, lexiconMatch{start: loc[0], end: loc[1], p: l.entries[strings.ToLower(text[loc[0]:loc[1]])]})
+	}
+	return matches
+}
+
+// wordBoundary reports whether text[start:end] is neither preceded nor
+// followed by a letter or digit.
+func wordBoundary(text string, start, end int) bool {
+	before, _ := utf8.DecodeLastRuneInString(text[:start])
+	after, _ := utf8.DecodeRuneInString(text[end:])
+	isWord := func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) }
+	return (start == 0 || !isWord(before)) && (end == len(text) || !isWord(after))
+}
+
+// pronounce marks up word as p says it in the dialect: SSML with a
+// <phoneme> or <sub> element, ElevenLabs with its respelling or else a
+// <phoneme> tag, which only some of its models read, and plain text with
+// its respelling.
+func (m Markup) pronounce(word string, p Pronunciation) string {
+	switch {
+	case m.Dialect == SSML && p.IPA != "":
+		return `<phoneme alphabet="ipa" ph="` + xmlEscaper.Replace(p.IPA) + `">` + xmlEscaper.Replace(word) + `</phoneme>`
+	case m.Dialect == SSML:
+		return `<sub alias="` + xmlEscaper.Replace(p.Respelling) + `">` + xmlEscaper.Replace(word) + `</sub>`
+	case p.Respelling != "":
+		return p.Respelling
+	case m.Dialect == ElevenLabs:
+		return `<phoneme alphabet="ipa" ph="` + xmlEscaper.Replace(p.IPA) + `">` + word + `</phoneme>`
+	default:
+		return word
+	}
+}
@@ -0,0 +1,277 @@
+package speech
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// Markdown: list markers opening a line, headings, emphasis and code
+	listMarker = regexp.MustCompile(`(?m)^[ \t]*(?:[-*•]|\d{1,2}[.)])[ \t]+`)
+	heading    = regexp.MustCompile(`(?m)^[ \t]*#{1,6}[ \t]+`)
+	emphasis   = regexp.MustCompile("\\*\\*|__|[*`]")
+
+	// North American phone numbers: 555-123-4567, (555) 123-4567,
+	// +1 555 123 4567
+	phoneNumber = regexp.MustCompile(`(?:\+?1[ .-]?)?\(?\b(\d{3})\)?[ .-]?(\d{3})[ .-](\d{4})\b`)
+	// $42.50, €3, £1,200, $1.5 million, $20k
+	money = regexp.MustCompile(`([$€£])(\d{1,3}(?:,\d{3})+|\d+)(?:\.(\d+))?(?:\s?(k|K|thousand|million|billion)\b)?`)
+	// 3:30, 9:05 am, 12:00 PM
+	clockTime = regexp.MustCompile(`\b([01]?\d|2[0-3]):([0-5]\d)(?:\s?([AaPp])\.?[Mm]\b\.?)?`)
+	// 3pm, 11 a.m.
+	hourTime = regexp.MustCompile(`\b(1[0-2]|0?[1-9])\s?([AaPp])\.?[Mm]\b\.?`)
+	percent  = regexp.MustCompile(`\b(\d+(?:\.\d+)?)\s?%`)
+	ordinal  = regexp.MustCompile(`\b(\d+)(?:st|nd|rd|th)\b`)
+	// 1,200.5, 3.14, 42
+	number = regexp.MustCompile(`\b(\d{1,3}(?:,\d{3})+|\d+)(?:\.(\d+))?\b`)
+)
+
+// Normalize rewrites text to be read aloud, spelling out numbers the way
+// a person would say them and dropping markdown. Text without digits or
+// markdown is returned unchanged.
+func Normalize(text string) string {
+	text = listMarker.ReplaceAllString(text, "")
+	text = heading.ReplaceAllString(text, "")
+	text = emphasis.ReplaceAllString(text, "")
+	text = strings.ReplaceAll(text, " & ", " and ")
+	if !strings.ContainsAny(text, "0123456789") {
+		return text
+	}
+
+	text = phoneNumber.ReplaceAllStringFunc(text, func(s string) string {
+		m := phoneNumber.FindStringSubmatch(s)
+		return digits(m[1]) + ", " + digits(m[2]) + ", " + digits(m[3])
+	})
+	text = money.ReplaceAllStringFunc(text, func(s string) string {
+		m := money.FindStringSubmatch(s)
+		return spellMoney(m[1], m[2], m[3], m[4])
+	})
+	text = clockTime.ReplaceAllStringFunc(text, func(s string) string {
+		m := clockTime.FindStringSubmatch(s)
+		return spellTime(m[1], m[2], m[3])
+	})
+	text = hourTime.ReplaceAllStringFunc(text, func(s string) string {
+		m := hourTime.FindStringSubmatch(s)
+		return spellTime(m[1], "00", m[2])
+	})
+	text = percent.ReplaceAllStringFunc(text, func(s string) string {
+		m := percent.FindStringSubmatch(s)
+		return spellNumber(m[1]) + " percent"
+	})
+	text = ordinal.ReplaceAllStringFunc(text, func(s string) string {
+		n, err := strconv.ParseInt(ordinal.FindStringSubmatch(s)[1], 10, 64)
+		if err != nil || n > 1e12 {
+			return s
+		}
+		return spellOrdinal(n)
+	})
+	return number.ReplaceAllStringFunc(text, spellNumber)
+}
+
+// currencies names each currency symbol's units, singular and plural.
+var currencies = map[string][4]string{
+	"$": {"dollar", "dollars", "cent", "cents"},
+	"€": {"euro", "euros", "cent", "cents"},
+	"£": {"pound", "pounds", "penny", "pence"},
+}
+
+// spellMoney spells an amount of money: its whole units, its fraction in
+// hundredths and any scale word after it.
+func spellMoney(symbol, whole, fraction, scale string) string {
+	names := currencies[symbol]
+	if scale != "" {
+		// "$1.5 million" is read as a number of millions
+		if scale == "k" || scale == "K" {
+			scale = "thousand"
+		}
+		amount := whole
+		if fraction != "" {
+			amount += "." + fraction
+		}
+		return spellNumber(amount) + " " + scale + " " + names[1]
+	}
+
+	units, err := strconv.ParseInt(strings.ReplaceAll(whole, ",", ""), 10, 64)
+	if err != nil {
+		return symbol + whole
+	}
+	var cents int64
+	if fraction != "" {
+		// Round to the nearest hundredth: ".5" is 50, ".999" is 100
+		f, _ := strconv.ParseFloat("0."+fraction, 64)
+		cents = int64(f*100 + 0.5)
+		if cents == 100 {
+			units, cents = units+1, 0
+		}
+	}
+
+	var parts []string
+	if units > 0 || cents == 0 {
+		parts = append(parts, spellCardinal(units)+" "+plural(units, names[0], names[1]))
+	}
+	if cents > 0 {
+		parts = append(parts, spellCardinal(cents)+" "+plural(cents, names[2], names[3]))
+	}
+	return strings.Join(parts, " and ")
+}
+
+// spellTime spells a time of day from its hour, minute and, for a
+// 12-hour clock, "a" or "p".
+func spellTime(hour, minute, meridiem string) string {
+	h, _ := strconv.Atoi(hour)
+	m, _ := strconv.Atoi(minute)
+	var s string
+	switch {
+	case m == 0 && meridiem != "":
+		s = spellCardinal(int64(h))
+	case m == 0 && h > 12:
+		s = spellCardinal(int64(h)) + " hundred"
+	case m == 0:
+		s = spellCardinal(int64(h)) + " o'clock"
+	case m < 10:
+		s = spellCardinal(int64(h)) + " oh " + spellCardinal(int64(m))
+	default:
+		s = spellCardinal(int64(h)) + " " + spellCardinal(int64(m))
+	}
+	switch strings.ToLower(meridiem) {
+	case "a":
+		s += " a.m."
+	case "p":
+		s += " p.m."
+	}
+	return s
+}
+
+// spellNumber spells a number as written in digits, with any thousands
+// separators and decimal part. Leading zeros and numbers too long to say
+// as one are read digit by digit; four-digit numbers that could be years
+// are read as years.
+func spellNumber(s string) string {
+	whole, fraction, _ := strings.Cut(s, ".")
+	grouped := strings.Contains(whole, ",")
+	whole = strings.ReplaceAll(whole, ",", "")
+
+	var spoken string
+	n, err := strconv.ParseInt(whole, 10, 64)
+	switch {
+	case err != nil || n >= 1e15 || (!grouped && len(whole) > 1 && whole[0] == '0'):
+		spoken = digits(whole)
+	case !grouped && fraction == "" && len(whole) == 4 && isYear(n):
+		spoken = spellYear(n)
+	default:
+		spoken = spellCardinal(n)
+	}
+	if fraction != "" {
+		spoken += " point " + digits(fraction)
+	}
+	return spoken
+}
+
+// isYear reports whether n is usually read as a year would be, in pairs
+// of digits: 1984 as "nineteen eighty-four".
+func isYear(n int64) bool {
+	return (n >= 1100 && n < 2000) || (n >= 2010 && n < 2100)
+}
+
+// spellYear spells a year in pairs of digits.
+func spellYear(n int64) string {
+	century, rest := n/100, n%100
+	switch {
+	case rest == 0:
+		return spellCardinal(century) + " hundred"
+	case rest < 10:
+		return spellCardinal(century) + " oh " + spellCardinal(rest)
+	default:
+		return spellCardinal(century) + " " + spellCardinal(rest)
+	}
+}
+
+var (
+	smallNumbers = [...]string{
+		"zero", "one", "two", "three", "four", "five", "six", "seven", "eight", "nine",
+		"ten", "eleven", "twelve", "thirteen", "fourteen", "fifteen", "sixteen",
+		"seventeen", "eighteen", "nineteen",
+	}
+	tensNames = [...]string{"", "", "twenty", "thirty", "forty", "fifty", "sixty", "seventy", "eighty", "ninety"}
+	scales    = []struct {
+		value int64
+		name  string
+	}{
+		{1e12, "trillion"},
+		{1e9, "billion"},
+		{1e6, "million"},
+		{1e3, "thousand"},
+	}
+)
+
+// spellCardinal spells n in words: 1205 is "one thousand two hundred five".
+func spellCardinal(n int64) string {
+	switch {
+	case n < 0:
+		return "minus " + spellCardinal(-n)
+	case n < 20:
+		return smallNumbers[n]
+	case n < 100:
+		if n%10 == 0 {
+			return tensNames[n/10]
+		}
+		return tensNames[n/10] + "-" + smallNumbers[n%10]
+	case n < 1000:
+		s := smallNumbers[n/100] + " hundred"
+		if n%100 != 0 {
+			s += " " + spellCardinal(n%100)
+		}
+		return s
+	}
+	for _, scale := range scales {
+		if n >= scale.value {
+			s := spellCardinal(n/scale.value) + " " + scale.name
+			if n%scale.value != 0 {
+				s += " " + spellCardinal(n%scale.value)
+			}
+			return s
+		}
+	}
+	return ""
+}
+
+// irregularOrdinals are the ordinals not formed by adding "th".
+var irregularOrdinals = map[string]string{
+	"one": "first", "two": "second", "three": "third", "five": "fifth",
+	"eight": "eighth", "nine": "ninth", "twelve": "twelfth",
+}
+
+// spellOrdinal spells n as an ordinal: 21 is "twenty-first".
+func spellOrdinal(n int64) string {
+	s := spellCardinal(n)
+	i := strings.LastIndexAny(s, " -") + 1
+	last := s[i:]
+	switch {
+	case irregularOrdinals[last] != "":
+		last = irregularOrdinals[last]
+	case strings.HasSuffix(last, "y"):
+		last = strings.TrimSuffix(last, "y") + "ieth"
+	default:
+		last += "th"
+	}
+	return s[:i] + last
+}
+
+// digits reads s digit by digit.
+func digits(s string) string {
+	words := make([]string, 0, len(s))
+	for _, d := range s {
+		if d >= '0' && d <= '9' {
+			words = append(words, smallNumbers[d-'0'])
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+func plural(n int64, one, many string) string {
+	if n == 1 {
+		return one
+	}
+	return many
+}
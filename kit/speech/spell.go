@@ -0,0 +1,269 @@
+package speech
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// phonetic is the word for each letter in the NATO phonetic alphabet, as
+// a TTS voice should say it.
+var phonetic = [26]string{
+	"alpha", "bravo", "charlie", "delta", "echo", "foxtrot", "golf", "hotel",
+	"india", "juliet", "kilo", "lima", "mike", "november", "oscar", "papa",
+	"quebec", "romeo", "sierra", "tango", "uniform", "victor", "whiskey",
+	"x-ray", "yankee", "zulu",
+}
+
+// symbolNames are how the symbols of email addresses and codes are said.
+var symbolNames = map[rune]string{
+	'@': "at", '.': "dot", '-': "dash", '_': "underscore", '+': "plus", '/': "slash",
+}
+
+// wellKnownDomains are email domains said as words rather than spelled.
+var wellKnownDomains = map[string]bool{
+	"gmail.com": true, "yahoo.com": true, "outlook.com": true, "hotmail.com": true,
+	"icloud.com": true, "aol.com": true, "live.com": true, "msn.com": true,
+}
+
+// Spell reads s back a character at a time with the phonetic alphabet, as
+// a caller can check it: "AB3" is "A as in alpha, B as in bravo, three".
+// The domain of an email address everyone knows is said as words
+// ("gmail dot com").
+func Spell(s string) string {
+	var parts []string
+	local, domain, email := strings.Cut(s, "@")
+	if email && wellKnownDomains[strings.ToLower(domain)] {
+		s = local
+	}
+	for _, r := range s {
+		switch {
+		case r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z':
+			upper := unicode.ToUpper(r)
+			parts = append(parts, string(upper)+" as in "+phonetic[upper-'A'])
+		case r >= '0' && r <= '9':
+			parts = append(parts, smallNumbers[r-'0'])
+		case symbolNames[r] != "":
+			parts = append(parts, symbolNames[r])
+		case !unicode.IsSpace(r):
+			parts = append(parts, string(r))
+		}
+	}
+	if email && wellKnownDomains[strings.ToLower(domain)] {
+		name, tld, _ := strings.Cut(strings.ToLower(domain), ".")
+		parts = append(parts, "at "+name+" dot "+tld)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// SpellingKind is what a caller is spelling, which decides how words
+// that aren't letters are read.
+type SpellingKind int
+
+const (
+	// Code is a reference number, confirmation code or the like: letters
+	// and digits, read in capitals.
+	Code SpellingKind = iota
+	// Email is an email address: "at" and "dot" are symbols, and words
+	// such as a domain name may be said whole.
+	Email
+)
+
+// Spelling is a value read from what a caller spelled out.
+type Spelling struct {
+	Value string
+	// Unsure are the characters the recognizer may have misheard, in
+	// order.
+	Unsure []Unsure
+}
+
+// Unsure is a character of a Spelling that sounds like others: said on
+// its own, "B" is easily heard as "D" or "P".
+type Unsure struct {
+	// Index is the character's byte index in the value.
+	Index int
+	// Candidates are the characters it may be, the one heard first.
+	Candidates []rune
+}
+
+// confusable lists, for the letters a recognizer most often mistakes on a
+// phone line, what they are mistaken for.
+var confusable = map[rune][]rune{
+	'B': {'D', 'P'}, 'D': {'B', 'T'}, 'P': {'B', 'T'}, 'T': {'D', 'P'},
+	'V': {'B', 'Z'}, 'Z': {'C', 'V'}, 'C': {'Z', 'T'}, 'E': {'B', 'D'},
+	'M': {'N'}, 'N': {'M'}, 'F': {'S'}, 'S': {'F'}, 'G': {'J'}, 'J': {'G'},
+	'A': {'H', 'K'}, 'K': {'A'},
+}
+
+// letterNames are the words recognizers write for letters said on their
+// own.
+var letterNames = map[string]rune{
+	"a": 'A', "ay": 'A', "b": 'B', "be": 'B', "bee": 'B', "c": 'C', "see": 'C', "sea": 'C',
+	"d": 'D', "dee": 'D', "e": 'E', "ee": 'E', "f": 'F', "ef": 'F', "eff": 'F',
+	"g": 'G', "gee": 'G', "h": 'H', "aitch": 'H', "i": 'I', "eye": 'I', "j": 'J', "jay": 'J',
+	"k": 'K', "kay": 'K', "l": 'L', "el": 'L', "ell": 'L', "m": 'M', "em": 'M',
+	"n": 'N', "en": 'N', "o": 'O', "p": 'P', "pee": 'P', "q": 'Q', "cue": 'Q', "queue": 'Q',
+	"r": 'R', "are": 'R', "ar": 'R', "s": 'S', "es": 'S', "ess": 'S', "t": 'T', "tee": 'T', "tea": 'T',
+	"u": 'U', "you": 'U', "v": 'V', "vee": 'V', "w": 'W', "x": 'X', "ex": 'X',
+	"y": 'Y', "why": 'Y', "z": 'Z', "zee": 'Z', "zed": 'Z',
+}
+
+// digitNames are the words for digits.
+var digitNames = map[string]rune{
+	"zero": '0', "one": '1', "two": '2', "three": '3', "four": '4',
+	"five": '5', "six": '6', "seven": '7', "eight": '8', "nine": '9',
+}
+
+// spokenSymbols are the words for symbols in email addresses and codes.
+var spokenSymbols = map[string]rune{
+	"dash": '-', "hyphen": '-', "minus": '-', "underscore": '_', "plus": '+',
+	"dot": '.', "period": '.', "point": '.', "slash": '/',
+}
+
+// fillers are words callers say around the characters they spell.
+var fillers = map[string]bool{
+	"it's": true, "its": true, "it": true, "is": true, "that's": true, "thats": true,
+	"and": true, "then": true, "um": true, "uh": true, "the": true, "letter": true,
+	"number": true, "my": true, "email": true, "address": true, "code": true, "capital": true,
+	"okay": true, "ok": true, "so": true, "like": true, "sign": true, "character": true,
+	"yes": true, "yeah": true, "yep": true, "no": true, "nope": true, "not": true,
+	"right": true, "correct": true, "sorry": true, "was": true, "wait": true,
+}
+
+// ParseSpelling reads the value a caller spelled out, as transcribed:
+// single letters and the words recognizers write for them, phonetic
+// alphabet words, "X as in xylophone", digits and their words, "double"
+// and "triple", and the symbols of email addresses. Letters said on their
+// own that sound like others are marked unsure.
+func ParseSpelling(text string, kind SpellingKind) Spelling {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return unicode.IsSpace(r) || r == ',' || r == '?' || r == '!' || r == ';'
+	})
+	for i, w := range words {
+		// "J." is a letter; a period inside ("gmail.com") is kept
+		words[i] = strings.Trim(w, `."'()`)
+	}
+
+	var b strings.Builder
+	var s Spelling
+	repeat := 1
+	// add writes r, which may have been any of alternatives
+	add := func(r rune, alternatives ...rune) {
+		for range repeat {
+			if len(alternatives) > 0 {
+				s.Unsure = append(s.Unsure, Unsure{Index: b.Len(), Candidates: append([]rune{r}, alternatives...)})
+			}
+			b.WriteRune(r)
+		}
+		repeat = 1
+	}
+
+	for i := 0; i < len(words); i++ {
+		w := words[i]
+		// "B as in bravo" or "B for bravo": the example word decides
+		if i+3 < len(words) && words[i+1] == "as" && words[i+2] == "in" {
+			add(exampleLetter(w, words[i+3]))
+			i += 3
+			continue
+		}
+		if i+2 < len(words) && words[i+1] == "for" {
+			if _, ok := letterNames[w]; ok {
+				add(exampleLetter(w, words[i+2]))
+				i += 2
+				continue
+			}
+		}
+
+		var following string
+		if i+1 < len(words) {
+			following = words[i+1]
+		}
+		switch {
+		case w == "":
+		case w == "double" && (following == "you" || following == "u"):
+			add('W')
+			i++
+		case w == "x" && following == "ray":
+			add('X')
+			i++
+		case w == "double":
+			repeat = 2
+		case w == "triple":
+			repeat = 3
+		case kind == Email && w == "at":
+			add('@')
+		case w == "oh" && kind == Code:
+			// "Oh" is as often a zero as an O, and next to digits a zero
+			if v := b.String(); (v != "" && unicode.IsDigit(rune(v[len(v)-1]))) || digitNames[following] != 0 || following == "oh" {
+				add('0')
+			} else {
+				add('O', '0')
+			}
+		case w == "oh":
+			add('O')
+		case phoneticLetter(w) != 0:
+			add(phoneticLetter(w))
+		case letterNames[w] != 0:
+			add(letterNames[w], confusable[letterNames[w]]...)
+		case digitNames[w] != 0:
+			add(digitNames[w])
+		case spokenSymbols[w] != 0:
+			// Codes have dashes but no dots
+			if kind == Email || spokenSymbols[w] == '-' {
+				add(spokenSymbols[w])
+			}
+		case fillers[w]:
+		default:
+			// Characters run together ("JS3", "gmail.com"); in a code, a
+			// word the recognizer heard whole is taken letter by letter
+			for _, r := range w {
+				if unicode.IsLetter(r) || unicode.IsDigit(r) || strings.ContainsRune("@.-_+", r) {
+					b.WriteRune(r)
+				}
+			}
+			repeat = 1
+		}
+	}
+
+	s.Value = b.String()
+	if kind == Code {
+		s.Value = strings.ToUpper(s.Value)
+	} else {
+		s.Value = strings.ToLower(s.Value)
+	}
+	return s
+}
+
+// phoneticLetter returns the letter a phonetic alphabet word stands for,
+// or 0.
+func phoneticLetter(w string) rune {
+	switch w {
+	case "alfa":
+		return 'A'
+	case "juliett":
+		return 'J'
+	case "xray":
+		return 'X'
+	}
+	for i, p := range phonetic {
+		if w == p {
+			return rune('A' + i)
+		}
+	}
+	return 0
+}
+
+// exampleLetter returns the letter of "letter as in example". The example
+// decides between letters that sound alike ("B as in dog" was a misheard
+// D) but not others ("F as in phone" is an F).
+func exampleLetter(letter, example string) rune {
+	if r := phoneticLetter(example); r != 0 {
+		return r
+	}
+	initial := unicode.ToUpper([]rune(example)[0])
+	heard, ok := letterNames[letter]
+	if !ok || initial == heard || slices.Contains(confusable[heard], initial) {
+		return initial
+	}
+	return heard
+}
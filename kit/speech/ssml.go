@@ -0,0 +1,278 @@
+package speech
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dialect is the markup a TTS provider understands.
+type Dialect string
+
+const (
+	// Plain is text without markup: pauses and careful readback come
+	// from punctuation alone.
+	Plain Dialect = "plain"
+	// ElevenLabs is text with <break> tags, the only SSML ElevenLabs
+	// reads.
+	ElevenLabs Dialect = "elevenlabs"
+	// SSML is W3C SSML as Amazon Polly, Google and Azure take it, with
+	// <say-as> and <prosody>.
+	SSML Dialect = "ssml"
+)
+
+// ParseDialect parses a dialect name.
+func ParseDialect(s string) (Dialect, error) {
+	switch d := Dialect(strings.ToLower(strings.TrimSpace(s))); d {
+	case Plain, ElevenLabs, SSML:
+		return d, nil
+	default:
+		return "", fmt.Errorf("unknown markup dialect %q (want plain, elevenlabs or ssml)", s)
+	}
+}
+
+// DialectFor returns the dialect of the omnivoice TTS provider named
+// provider, or Plain for one it doesn't know.
+func DialectFor(provider string) Dialect {
+	switch strings.ToLower(provider) {
+	case "elevenlabs":
+		return ElevenLabs
+	case "polly", "google", "azure":
+		return SSML
+	default:
+		return Plain
+	}
+}
+
+// Markup turns a chunk of plain text into what a TTS provider should be
+// sent, so that data read back to a caller is intelligible: a pause after
+// each question, phone numbers read slowly in their groups, and
+// confirmation codes and other alphanumerics spelled out a character at
+// a time. The rest of the text is normalized as by Normalize.
+type Markup struct {
+	Dialect Dialect
+	// QuestionPause is the pause after a question, so the caller hears
+	// it is their turn.
+	QuestionPause time.Duration
+	// GroupPause is the pause between the groups of a phone number or
+	// code being read back.
+	GroupPause time.Duration
+	// Lexicon, if set, corrects how names in the text are said.
+	Lexicon *Lexicon
+}
+
+// NewMarkup returns markup in dialect d with a 400ms pause after
+// questions and 250ms between readback groups.
+func NewMarkup(d Dialect) Markup {
+	return Markup{Dialect: d, QuestionPause: 400 * time.Millisecond, GroupPause: 250 * time.Millisecond}
+}
+
+var (
+	// Confirmation codes, booking references and the like: capitals and
+	// digits with at least one of each (X7K92Q, AB-1234), or digits only
+	// if too long to be a quantity (order 84213907)
+	code = regexp.MustCompile(`\b[A-Z0-9]+(?:-[A-Z0-9]+)*\b`)
+	// Where a question ends: its mark and what closes around it
+	questionEnd = regexp.MustCompile(`\?["')\]]*(?:\s|$)`)
+)
+
+// Build marks up text.
+func (m Markup) Build(text string) string {
+	return m.BuildWith(text, Prosody{})
+}
+
+// BuildWith marks up text spoken with prosody p, e.g. for an emotion.
+// Only SSML has prosody; other dialects ignore it.
+func (m Markup) BuildWith(text string, p Prosody) string {
+	var b strings.Builder
+	last := 0
+	for _, word := range m.Lexicon.find(text) {
+		m.build(&b, text[last:word.start])
+		b.WriteString(m.pronounce(text[word.start:word.end], word.p))
+		last = word.end
+	}
+	m.build(&b, text[last:])
+	if m.Dialect == SSML {
+		return "<speak>" + p.wrap(b.String()) + "</speak>"
+	}
+	return b.String()
+}
+
+// build writes text marked up to b: its phone numbers and codes read
+// back, and the rest as prose.
+func (m Markup) build(b *strings.Builder, text string) {
+	last := 0
+	for _, span := range readbackSpans(text) {
+		b.WriteString(m.prose(text[last:span.start]))
+		b.WriteString(m.readback(span.groups, span.phone))
+		last = span.end
+	}
+	b.WriteString(m.prose(text[last:]))
+}
+
+// readbackSpan is a phone number or code in the text, in the groups it is
+// read in.
+type readbackSpan struct {
+	start, end int
+	groups     []string
+	phone      bool
+}
+
+// readbackSpans finds the phone numbers and codes in text, in order.
+func readbackSpans(text string) []readbackSpan {
+	var spans []readbackSpan
+	for _, loc := range phoneNumber.FindAllStringSubmatchIndex(text, -1) {
+		spans = append(spans, readbackSpan{
+			start:  loc[0],
+			end:    loc[1],
+			groups: []string{text[loc[2]:loc[3]], text[loc[4]:loc[5]], text[loc[6]:loc[7]]},
+			phone:  true,
+		})
+	}
+	for _, loc := range code.FindAllStringIndex(text, -1) {
+		s := text[loc[0]:loc[1]]
+		if !isCode(s) || inNumber(text, loc[0], loc[1]) || overlaps(spans, loc[0], loc[1]) {
+			continue
+		}
+		spans = append(spans, readbackSpan{start: loc[0], end: loc[1], groups: codeGroups(s)})
+	}
+	slices.SortFunc(spans, func(a, b readbackSpan) int { return a.start - b.start })
+	return spans
+}
+
+// isCode reports whether s, matched by code, is read back a character at
+// a time.
+func isCode(s string) bool {
+	chars := strings.ReplaceAll(s, "-", "")
+	letters := strings.IndexFunc(chars, func(r rune) bool { return r >= 'A' && r <= 'Z' }) >= 0
+	digits := strings.IndexFunc(chars, func(r rune) bool { return r >= '0' && r <= '9' }) >= 0
+	switch {
+	case letters && digits:
+		return len(chars) >= 3
+	case digits:
+		// A leading zero or more digits than a quantity would have
+		return len(chars) >= 7 || (len(chars) >= 4 && chars[0] == '0')
+	default:
+		// All capitals is an acronym, read as the provider sees fit
+		return false
+	}
+}
+
+// inNumber reports whether text[start:end] is part of an amount, a
+// percentage or a number with separators, which Normalize reads instead.
+func inNumber(text string, start, end int) bool {
+	before := text[:start]
+	for _, prefix := range []string{"$", "€", "£", ".", ","} {
+		if strings.HasSuffix(before, prefix) {
+			return true
+		}
+	}
+	after := text[end:]
+	return strings.HasPrefix(after, "%") ||
+		(len(after) > 1 && (after[0] == '.' || after[0] == ',') && after[1] >= '0' && after[1] <= '9')
+}
+
+func overlaps(spans []readbackSpan, start, end int) bool {
+	for _, s := range spans {
+		if start < s.end && s.start < end {
+			return true
+		}
+	}
+	return false
+}
+
+// codeGroups splits a code into the groups it is read in: at its hyphens,
+// or into threes, with a last group of four rather than one.
+func codeGroups(s string) []string {
+	if strings.Contains(s, "-") {
+		return strings.Split(s, "-")
+	}
+	var groups []string
+	for len(s) > 4 {
+		groups, s = append(groups, s[:3]), s[3:]
+	}
+	return append(groups, s)
+}
+
+// prose normalizes text that isn't read back and marks the pauses after
+// its questions.
+func (m Markup) prose(text string) string {
+	text = Normalize(text)
+	var b strings.Builder
+	last := 0
+	if m.Dialect != Plain && m.QuestionPause > 0 {
+		for _, loc := range questionEnd.FindAllStringIndex(text, -1) {
+			// The pause goes before the whitespace, which stays
+			end := loc[0] + len(strings.TrimRight(text[loc[0]:loc[1]], " \t\n"))
+			b.WriteString(m.escape(text[last:end]))
+			b.WriteString(m.pause(m.QuestionPause))
+			last = end
+		}
+	}
+	b.WriteString(m.escape(text[last:]))
+	return b.String()
+}
+
+// readback marks up groups of digits and letters to be read slowly, one
+// character at a time.
+func (m Markup) readback(groups []string, phone bool) string {
+	if m.Dialect == SSML {
+		if phone {
+			return `<prosody rate="slow"><say-as interpret-as="telephone">` + strings.Join(groups, "-") + `</say-as></prosody>`
+		}
+		var b strings.Builder
+		b.WriteString(`<prosody rate="slow">`)
+		for i, g := range groups {
+			if i > 0 {
+				b.WriteString(m.pause(m.GroupPause))
+			}
+			b.WriteString(`<say-as interpret-as="characters">` + g + `</say-as>`)
+		}
+		b.WriteString(`</prosody>`)
+		return b.String()
+	}
+
+	// Without say-as, characters are spelled as words and slowed down
+	// with commas between them and pauses between groups
+	spelled := make([]string, len(groups))
+	for i, g := range groups {
+		chars := make([]string, 0, len(g))
+		for _, r := range g {
+			if r >= '0' && r <= '9' {
+				chars = append(chars, smallNumbers[r-'0'])
+			} else {
+				chars = append(chars, string(r))
+			}
+		}
+		spelled[i] = strings.Join(chars, ", ")
+	}
+	if m.Dialect == Plain || m.GroupPause <= 0 {
+		return strings.Join(spelled, ", ")
+	}
+	return strings.Join(spelled, ","+m.pause(m.GroupPause)+" ")
+}
+
+// pause returns a pause of d in the dialect.
+func (m Markup) pause(d time.Duration) string {
+	switch m.Dialect {
+	case SSML:
+		return fmt.Sprintf(`<break time="%dms"/>`, d.Milliseconds())
+	case ElevenLabs:
+		return `<break time="` + strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + `s" />`
+	default:
+		return ""
+	}
+}
+
+var xmlEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", `"`, "&quot;", "'", "&apos;")
+
+// escape escapes text for the dialect.
+func (m Markup) escape(text string) string {
+	if m.Dialect != SSML {
+		return text
+	}
+	return xmlEscaper.Replace(text)
+}
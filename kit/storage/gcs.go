@@ -0,0 +1,197 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/agentplexus/omnivoice-examples/kit/googleauth"
+)
+
+// GCSUploadURL is the Google Cloud Storage JSON API's upload root.
+const GCSUploadURL = "https://storage.googleapis.com/upload/storage/v1"
+
+// GCSAPIURL is the Google Cloud Storage JSON API's root, for reads.
+const GCSAPIURL = "https://storage.googleapis.com/storage/v1"
+
+// GCSScope is the OAuth scope Google Cloud Storage needs to read and write
+// objects.
+const GCSScope = "https://www.googleapis.com/auth/devstorage.read_write"
+
+// GCS stores objects in a Google Cloud Storage bucket.
+type GCS struct {
+	Bucket string
+	// Prefix is prepended to every key, e.g. "calls/".
+	Prefix string
+	// Token returns an access token for each request, e.g. a
+	// googleauth.ServiceAccount's with GCSScope.
+	Token func(ctx context.Context) (string, error)
+	// BaseURL defaults to GCSUploadURL.
+	BaseURL string
+	// APIURL, for reads, defaults to GCSAPIURL.
+	APIURL string
+	// Client defaults to one with a 30 second timeout.
+	Client *http.Client
+}
+
+// NewGCS returns the bucket, with prefix prepended to every key, reached
+// with the service account whose JSON key file is at keyFile.
+func NewGCS(bucket, prefix, keyFile string) (*GCS, error) {
+	account, err := googleauth.LoadServiceAccount(keyFile, GCSScope)
+	if err != nil {
+		return nil, err
+	}
+	return &GCS{Bucket: bucket, Prefix: prefix, Token: account.Token}, nil
+}
+
+// Put uploads data to the bucket under key.
+func (g *GCS) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	token, err := g.Token(ctx)
+	if err != nil {
+		return fmt.Errorf("storage: Google access token: %w", err)
+	}
+	base := g.BaseURL
+	if base == "" {
+		base = GCSUploadURL
+	}
+	query := url.Values{"uploadType": {"media"}, "name": {g.Prefix + key}}
+	target := base + "/b/" + url.PathEscape(g.Bucket) + "/o?" + query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, target, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	req.Header.Set("Content-Type", contentType)
+	resp, err := g.do(req, token, "upload "+key)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Get downloads the object under key.
+func (g *GCS) Get(ctx context.Context, key string) ([]byte, error) {
+	token, err := g.Token(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("storage: Google access token: %w", err)
+	}
+	target := g.apiURL() + "/b/" + url.PathEscape(g.Bucket) + "/o/" + url.PathEscape(g.Prefix+key) + "?alt=media"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := g.do(req, token, "download "+key)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("storage: %w", err)
+	}
+	return data, nil
+}
+
+// List lists the keys under prefix, a page at a time.
+func (g *GCS) List(ctx context.Context, prefix string) ([]string, error) {
+	token, err := g.Token(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("storage: Google access token: %w", err)
+	}
+	var keys []string
+	query := url.Values{"prefix": {g.Prefix + prefix}, "fields": {"items(name),nextPageToken"}}
+	for {
+		target := g.apiURL() + "/b/" + url.PathEscape(g.Bucket) + "/o?" + query.Encode()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := g.do(req, token, "list "+prefix)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Items []struct {
+				Name string `json:"name"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("storage: Google Cloud Storage list %s: %w", prefix, err)
+		}
+		for _, item := range page.Items {
+			keys = append(keys, strings.TrimPrefix(item.Name, g.Prefix))
+		}
+		if page.NextPageToken == "" {
+			return keys, nil
+		}
+		query.Set("pageToken", page.NextPageToken)
+	}
+}
+
+// Location returns the bucket's location, e.g. "EU", "US" or
+// "EUROPE-WEST1": where Google keeps what is stored in it. Reading it
+// needs the storage.buckets.get permission.
+func (g *GCS) Location(ctx context.Context) (string, error) {
+	token, err := g.Token(ctx)
+	if err != nil {
+		return "", fmt.Errorf("storage: Google access token: %w", err)
+	}
+	target := g.apiURL() + "/b/" + url.PathEscape(g.Bucket) + "?fields=location"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := g.do(req, token, "bucket "+g.Bucket)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	var bucket struct {
+		Location string `json:"location"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&bucket); err != nil {
+		return "", fmt.Errorf("storage: Google Cloud Storage bucket %s: %w", g.Bucket, err)
+	}
+	return bucket.Location, nil
+}
+
+func (g *GCS) apiURL() string {
+	if g.APIURL == "" {
+		return GCSAPIURL
+	}
+	return g.APIURL
+}
+
+// do sends req with token, returning an error for any response but a
+// success; a missing object's wraps ErrNotFound.
+func (g *GCS) do(req *http.Request, token, what string) (*http.Response, error) {
+	req.Header.Set("Authorization", "Bearer "+token)
+	client := g.Client
+	if client == nil {
+		client = defaultHTTPClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("storage: %w", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		if resp.StatusCode == http.StatusNotFound && req.Method == http.MethodGet {
+			return nil, fmt.Errorf("%w: Google Cloud Storage %s", ErrNotFound, what)
+		}
+		return nil, fmt.Errorf("storage: Google Cloud Storage %s: %s: %s", what, resp.Status, msg)
+	}
+	return resp, nil
+}
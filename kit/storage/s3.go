@@ -0,0 +1,259 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// S3 stores objects in an Amazon S3 bucket, or in any service that speaks
+// its API, such as MinIO or Cloudflare R2, signing each request with AWS
+// Signature Version 4.
+type S3 struct {
+	Bucket string
+	// Prefix is prepended to every key, e.g. "calls/".
+	Prefix string
+	// Region is the bucket's region. Default us-east-1.
+	Region string
+	// Endpoint, if set, is the service's URL, e.g. "http://localhost:9000"
+	// for MinIO; objects are addressed by path under it. By default
+	// objects are addressed on the bucket's own AWS host.
+	Endpoint string
+
+	AccessKey string
+	SecretKey string
+	// SessionToken is set for temporary credentials.
+	SessionToken string
+
+	// Client defaults to one with a 30 second timeout.
+	Client *http.Client
+}
+
+// Put uploads data to the bucket under key.
+func (s *S3) Put(ctx context.Context, key string, data []byte, contentType string) error {
+	target, err := s.objectURL(s.Prefix + key)
+	if err != nil {
+		return err
+	}
+	resp, err := s.do(ctx, http.MethodPut, target, data, contentType, "PUT "+key)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Get downloads the object under key.
+func (s *S3) Get(ctx context.Context, key string) ([]byte, error) {
+	target, err := s.objectURL(s.Prefix + key)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.do(ctx, http.MethodGet, target, nil, "", "GET "+key)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("storage: %w", err)
+	}
+	return data, nil
+}
+
+// List lists the keys under prefix, a page of up to 1000 at a time.
+func (s *S3) List(ctx context.Context, prefix string) ([]string, error) {
+	base, err := s.bucketURL()
+	if err != nil {
+		return nil, err
+	}
+	var keys []string
+	query := url.Values{"list-type": {"2"}, "prefix": {s.Prefix + prefix}}
+	for {
+		resp, err := s.do(ctx, http.MethodGet, base+"?"+canonicalQuery(query), nil, "", "list "+prefix)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("storage: S3 list %s: %w", prefix, err)
+		}
+		for _, object := range page.Contents {
+			keys = append(keys, strings.TrimPrefix(object.Key, s.Prefix))
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return keys, nil
+		}
+		query.Set("continuation-token", page.NextContinuationToken)
+	}
+}
+
+// do sends a signed request for what, returning an error for any response
+// but a success; a missing object's wraps ErrNotFound.
+func (s *S3) do(ctx context.Context, method, target string, data []byte, contentType, what string) (*http.Response, error) {
+	var body io.Reader
+	if data != nil {
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	sum := sha256.Sum256(data)
+	s.sign(req, hex.EncodeToString(sum[:]), time.Now())
+
+	client := s.Client
+	if client == nil {
+		client = defaultHTTPClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("storage: %w", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		if resp.StatusCode == http.StatusNotFound && method == http.MethodGet {
+			return nil, fmt.Errorf("%w: S3 %s", ErrNotFound, what)
+		}
+		return nil, fmt.Errorf("storage: S3 %s: %s: %s", what, resp.Status, msg)
+	}
+	return resp, nil
+}
+
+func (s *S3) region() string {
+	if s.Region == "" {
+		return "us-east-1"
+	}
+	return s.Region
+}
+
+// bucketURL returns the URL of the bucket, which lists its objects.
+func (s *S3) bucketURL() (string, error) {
+	if s.Endpoint == "" {
+		return fmt.Sprintf("https://%s.s3.%s.amazonaws.com/", s.Bucket, s.region()), nil
+	}
+	u, err := url.Parse(strings.TrimSuffix(s.Endpoint, "/"))
+	if err != nil || u.Host == "" {
+		return "", fmt.Errorf("storage: invalid S3 endpoint %q", s.Endpoint)
+	}
+	return u.String() + "/" + escape(s.Bucket, false), nil
+}
+
+// objectURL returns the URL of the object named key.
+func (s *S3) objectURL(key string) (string, error) {
+	base, err := s.bucketURL()
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSuffix(base, "/") + "/" + escape(key, true), nil
+}
+
+// sign adds AWS Signature Version 4 headers to req, whose body hashes to
+// payloadHash, signing every header already set.
+func (s *S3) sign(req *http.Request, payloadHash string, now time.Time) {
+	now = now.UTC()
+	stamp := now.Format("20060102T150405Z")
+	day := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", stamp)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := day + "/" + s.region() + "/s3/aws4_request"
+	hashed := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(hashed[:])
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), day)
+	for _, part := range []string{s.region(), "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// canonicalQuery encodes a query string as S3 signs it: sorted by name,
+// every byte escaped but RFC 3986's unreserved characters.
+func canonicalQuery(query url.Values) string {
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	var pairs []string
+	for _, name := range names {
+		for _, value := range query[name] {
+			pairs = append(pairs, escape(name, false)+"="+escape(value, false))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// escape escapes s as S3 signs it: every byte but RFC 3986's unreserved
+// characters and, in a slash-separated key, the slashes.
+func escape(s string, keepSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~', c == '/' && keepSlash:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
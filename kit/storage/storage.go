@@ -0,0 +1,140 @@
+// Package storage keeps what calls leave behind, such as recordings,
+// transcripts and summaries, in a directory on local disk or in an S3 or
+// Google Cloud Storage bucket, behind one interface:
+//
+//	var store storage.Storage = &storage.S3{
+//		Bucket:    "call-archive",
+//		Prefix:    "calls/",
+//		Region:    "eu-west-1",
+//		AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+//		SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+//	}
+//	err := store.Put(ctx, "CA123/transcript.json", data, "application/json")
+//
+// Keys are slash-separated paths. Objects are written whole, so they are
+// meant for artifacts of a call, not for audio as it streams. All three
+// can read back what they stored, as a Reader, e.g. for a dashboard of
+// past calls.
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Storage stores objects by key.
+type Storage interface {
+	// Put stores data under key, replacing any object already there.
+	Put(ctx context.Context, key string, data []byte, contentType string) error
+}
+
+// Reader is implemented by storage that can read back what it stored.
+type Reader interface {
+	// Get returns the object stored under key, or an error wrapping
+	// ErrNotFound if there is none.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// List returns the keys of the objects stored under prefix, sorted.
+	List(ctx context.Context, prefix string) ([]string, error)
+}
+
+// ErrNotFound is returned by Get for a key with no object stored.
+var ErrNotFound = errors.New("storage: object not found")
+
+var defaultHTTPClient = &http.Client{Timeout: 30 * time.Second}
+
+// Dir stores objects as files under a directory, each key's slashes
+// making subdirectories. Files are written whole, through a temporary
+// file, so a reader never sees one half-written.
+type Dir struct {
+	Root string
+
+	mu sync.Mutex // serializes directory creation
+}
+
+// Put writes data to the file for key. The content type isn't kept.
+func (d *Dir) Put(_ context.Context, key string, data []byte, _ string) error {
+	if !filepath.IsLocal(filepath.FromSlash(key)) {
+		return fmt.Errorf("storage: invalid key %q", key)
+	}
+	path := filepath.Join(d.Root, filepath.FromSlash(key))
+	d.mu.Lock()
+	err := os.MkdirAll(filepath.Dir(path), 0o750)
+	d.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("storage: %w", err)
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("storage: %w", err)
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("storage: %w", err)
+	}
+	if err := f.Chmod(0o640); err != nil {
+		_ = f.Close()
+		return fmt.Errorf("storage: %w", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("storage: %w", err)
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return fmt.Errorf("storage: %w", err)
+	}
+	return nil
+}
+
+// Get reads the file for key.
+func (d *Dir) Get(_ context.Context, key string) ([]byte, error) {
+	if !filepath.IsLocal(filepath.FromSlash(key)) {
+		return nil, fmt.Errorf("storage: invalid key %q", key)
+	}
+	data, err := os.ReadFile(filepath.Join(d.Root, filepath.FromSlash(key)))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, key)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("storage: %w", err)
+	}
+	return data, nil
+}
+
+// List walks the directory for the keys under prefix, leaving out files
+// still being written.
+func (d *Dir) List(_ context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := filepath.WalkDir(d.Root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && path == d.Root {
+				return fs.SkipAll
+			}
+			return err
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			return nil
+		}
+		rel, err := filepath.Rel(d.Root, path)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("storage: %w", err)
+	}
+	slices.Sort(keys)
+	return keys, nil
+}
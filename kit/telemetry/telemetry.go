@@ -0,0 +1,351 @@
+// Package telemetry counts which providers, transports and features a
+// deployment exercises, for operators who choose to share that with the
+// maintainers. It is off unless turned on.
+//
+// Only names are counted: which STT, TTS and LLM providers served calls,
+// over which transport and codec, and which features (barge-in, transfer,
+// echo guard, ...) took part. Nothing said on a call, no phone numbers, no
+// call, account or session IDs, no credentials and no hostnames are
+// recorded, and names that could carry any are dropped. A deployment is
+// identified only by a random ID chosen the first time it reports.
+//
+// In local mode the summary is only written to a file, for operators who
+// want to see what would be shared, or to keep usage figures of their
+// own. Remote mode also sends it, as JSON, to a collector:
+//
+//	usage, err := telemetry.New(telemetry.Config{Mode: telemetry.Local, File: "usage.json", App: "my-agent"})
+//	go usage.Run(ctx)
+//	usage.RecordCall(telemetry.Call{Providers: []string{"stt:deepgram"}, Transport: "twilio", Codec: "mulaw"})
+//
+// Setting DO_NOT_TRACK to a true value turns telemetry off whatever the
+// configuration says. A nil *Reporter, returned when telemetry is off,
+// records nothing.
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mode is what is done with the usage summary.
+type Mode string
+
+const (
+	// Off records nothing. It is the default.
+	Off Mode = "off"
+	// Local writes the summary to a file and sends nothing.
+	Local Mode = "local"
+	// Remote writes the summary to a file and sends it to a collector.
+	Remote Mode = "remote"
+)
+
+// ParseMode parses a mode name. Empty is Off.
+func ParseMode(s string) (Mode, error) {
+	switch m := Mode(strings.ToLower(strings.TrimSpace(s))); m {
+	case "":
+		return Off, nil
+	case Off, Local, Remote:
+		return m, nil
+	default:
+		return "", fmt.Errorf("unknown telemetry mode %q (want off, local or remote)", s)
+	}
+}
+
+// Config configures telemetry for one deployment.
+type Config struct {
+	Mode Mode
+	// File is where the summary is kept, and where counting resumes from
+	// after a restart. Required unless Mode is Off.
+	File string
+	// Endpoint is the collector's URL, POSTed the summary in Remote mode.
+	Endpoint string
+	// Interval is how often the summary is written, and sent. Default 24
+	// hours.
+	Interval time.Duration
+	// App names the example or application reporting.
+	App string
+	// Client sends the summary. Default a client with a 10 second timeout.
+	Client *http.Client
+	// Now defaults to time.Now.
+	Now func() time.Time
+}
+
+// Call is what one call exercised. Names are lowercase identifiers such
+// as "stt:deepgram" or "barge_in"; any other name is dropped.
+type Call struct {
+	// Providers are the services that served the call, as kind:name.
+	Providers []string
+	Transport string
+	Codec     string
+	Features  []string
+}
+
+// Summary is everything reported: counts of calls by provider, transport,
+// codec and feature since the deployment first reported, and the features
+// it has configured.
+type Summary struct {
+	DeploymentID string         `json:"deployment_id"`
+	App          string         `json:"app,omitempty"`
+	GoVersion    string         `json:"go_version"`
+	OS           string         `json:"os"`
+	Arch         string         `json:"arch"`
+	Since        time.Time      `json:"since"`
+	Updated      time.Time      `json:"updated"`
+	Calls        int            `json:"calls"`
+	Providers    map[string]int `json:"providers,omitempty"`
+	Transports   map[string]int `json:"transports,omitempty"`
+	Codecs       map[string]int `json:"codecs,omitempty"`
+	Features     map[string]int `json:"features,omitempty"`
+	Configured   []string       `json:"configured,omitempty"`
+}
+
+// validName matches the names that may be counted: short identifiers that
+// can't hold a number, an address or free text.
+var validName = regexp.MustCompile(`^[a-z][a-z0-9_.-]{0,31}(:[a-z][a-z0-9_.-]{0,31})?$`)
+
+// Reporter counts usage and writes, or sends, the summary. It is safe for
+// concurrent use.
+type Reporter struct {
+	cfg Config
+
+	mu      sync.Mutex
+	summary Summary
+}
+
+// New returns a reporter for cfg, picking up the counts in cfg.File if it
+// exists. It returns nil if telemetry is off.
+func New(cfg Config) (*Reporter, error) {
+	if cfg.Mode == "" || cfg.Mode == Off || doNotTrack() {
+		return nil, nil
+	}
+	if _, err := ParseMode(string(cfg.Mode)); err != nil {
+		return nil, err
+	}
+	if cfg.File == "" {
+		return nil, errors.New("telemetry: File required")
+	}
+	if cfg.Mode == Remote && !strings.HasPrefix(cfg.Endpoint, "https://") && !strings.HasPrefix(cfg.Endpoint, "http://") {
+		return nil, fmt.Errorf("telemetry: remote mode needs an http(s) Endpoint, got %q", cfg.Endpoint)
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 24 * time.Hour
+	}
+	if cfg.Client == nil {
+		cfg.Client = &http.Client{Timeout: 10 * time.Second}
+	}
+	if cfg.Now == nil {
+		cfg.Now = time.Now
+	}
+
+	r := &Reporter{cfg: cfg}
+	if err := r.load(); err != nil {
+		return nil, fmt.Errorf("telemetry: reading %s: %w", cfg.File, err)
+	}
+	now := cfg.Now()
+	if r.summary.DeploymentID == "" {
+		r.summary.DeploymentID = newDeploymentID()
+		r.summary.Since = now
+	}
+	r.summary.App = cfg.App
+	r.summary.GoVersion = runtime.Version()
+	r.summary.OS, r.summary.Arch = runtime.GOOS, runtime.GOARCH
+	r.summary.Updated = now
+	r.summary.Configured = nil
+	return r, nil
+}
+
+// doNotTrack reports whether DO_NOT_TRACK asks for no telemetry.
+func doNotTrack() bool {
+	v := os.Getenv("DO_NOT_TRACK")
+	if v == "" {
+		return false
+	}
+	off, err := strconv.ParseBool(v)
+	return err != nil || off
+}
+
+// load resumes from the summary file, if there is one.
+func (r *Reporter) load() error {
+	data, err := os.ReadFile(r.cfg.File)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, &r.summary)
+}
+
+// Configured records features the deployment has configured, such as
+// "config_file" or "redis", whether or not calls use them.
+func (r *Reporter) Configured(features ...string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, f := range features {
+		if validName.MatchString(f) && !slices.Contains(r.summary.Configured, f) {
+			r.summary.Configured = append(r.summary.Configured, f)
+		}
+	}
+	slices.Sort(r.summary.Configured)
+}
+
+// RecordCall counts a finished call.
+func (r *Reporter) RecordCall(c Call) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.summary.Calls++
+	for _, p := range c.Providers {
+		count(&r.summary.Providers, p)
+	}
+	count(&r.summary.Transports, c.Transport)
+	count(&r.summary.Codecs, c.Codec)
+	for _, f := range c.Features {
+		count(&r.summary.Features, f)
+	}
+}
+
+// count adds one to name's count, if name may be counted.
+func count(counts *map[string]int, name string) {
+	if !validName.MatchString(name) {
+		return
+	}
+	if *counts == nil {
+		*counts = make(map[string]int)
+	}
+	(*counts)[name]++
+}
+
+// Summary returns a copy of the summary.
+func (r *Reporter) Summary() Summary {
+	if r == nil {
+		return Summary{}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := r.summary
+	s.Providers = cloneCounts(s.Providers)
+	s.Transports = cloneCounts(s.Transports)
+	s.Codecs = cloneCounts(s.Codecs)
+	s.Features = cloneCounts(s.Features)
+	s.Configured = slices.Clone(s.Configured)
+	return s
+}
+
+func cloneCounts(m map[string]int) map[string]int {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]int, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// Run writes, and in Remote mode sends, the summary every Interval until
+// ctx is done. Failures are logged and retried at the next interval.
+func (r *Reporter) Run(ctx context.Context) {
+	if r == nil {
+		return
+	}
+	ticker := time.NewTicker(r.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := r.Flush(ctx); err != nil {
+				slog.Warn("telemetry report failed", "error", err)
+			}
+		}
+	}
+}
+
+// Flush writes the summary file and, in Remote mode, sends the summary.
+func (r *Reporter) Flush(ctx context.Context) error {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	r.summary.Updated = r.cfg.Now()
+	r.mu.Unlock()
+	summary := r.Summary()
+	data, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := writeFile(r.cfg.File, append(data, '\n')); err != nil {
+		return err
+	}
+	if r.cfg.Mode != Remote {
+		return nil
+	}
+	return r.send(ctx, data)
+}
+
+// writeFile replaces path with data, so a crash can't leave it half
+// written.
+func writeFile(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		_ = os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// send POSTs the summary to the collector.
+func (r *Reporter) send(ctx context.Context, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.cfg.Endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := r.cfg.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("sending usage summary: %w", err)
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("sending usage summary: HTTP %s", resp.Status)
+	}
+	return nil
+}
+
+// newDeploymentID returns a random ID for a deployment.
+func newDeploymentID() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,156 @@
+// Package twilioauth verifies that requests to an example really come from
+// Twilio, so the examples are safe to expose publicly.
+//
+// Twilio signs every webhook, and the handshake of every Media Streams
+// WebSocket, with the account's auth token in the X-Twilio-Signature
+// header. Validator checks the signature, and its Middleware rejects
+// requests without a valid one:
+//
+//	v := &twilioauth.Validator{AuthToken: authToken}
+//	http.Handle("/voice/inbound", v.Middleware(inbound))
+//
+// A Media Stream can additionally be tied to the call it was started for
+// by passing StreamToken as a custom parameter in the TwiML that starts it
+// and checking it with VerifyStreamToken once the stream connects.
+package twilioauth
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureHeader is the header Twilio signs requests with.
+const SignatureHeader = "X-Twilio-Signature"
+
+var (
+	// ErrMissingSignature is returned for requests without a signature.
+	ErrMissingSignature = errors.New("missing " + SignatureHeader)
+	// ErrInvalidSignature is returned for requests whose signature doesn't
+	// match.
+	ErrInvalidSignature = errors.New("invalid " + SignatureHeader)
+)
+
+// Signature returns the signature Twilio sends for a request to rawURL
+// (including any query string) with the given POST parameters: the
+// base64-encoded HMAC-SHA1, keyed with the auth token, of the URL followed
+// by each parameter name and value, sorted by name.
+func Signature(authToken, rawURL string, params url.Values) string {
+	mac := hmac.New(sha1.New, []byte(authToken))
+	mac.Write([]byte(rawURL))
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		for _, value := range params[key] {
+			mac.Write([]byte(key))
+			mac.Write([]byte(value))
+		}
+	}
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Validator checks the signatures of requests made by Twilio.
+type Validator struct {
+	// AuthToken is the auth token of the Twilio account.
+	AuthToken string
+	// PublicHost, if set, is used instead of the request's Host header to
+	// rebuild the URL Twilio requested, for servers behind a proxy that
+	// rewrites it.
+	PublicHost string
+}
+
+// Validate checks the request's signature. Form requests are parsed, so
+// handlers can still read r.Form afterwards.
+func (v *Validator) Validate(r *http.Request) error {
+	signature := r.Header.Get(SignatureHeader)
+	if signature == "" {
+		return ErrMissingSignature
+	}
+	var params url.Values
+	if r.Method == http.MethodPost {
+		if err := r.ParseForm(); err != nil {
+			return err
+		}
+		params = r.PostForm
+	}
+	want := Signature(v.AuthToken, v.requestURL(r), params)
+	if !hmac.Equal([]byte(signature), []byte(want)) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Middleware rejects requests that fail Validate with 403 Forbidden.
+func (v *Validator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := v.Validate(r); err != nil {
+			slog.Warn("rejecting unsigned request", "path", r.URL.Path, "remote_addr", r.RemoteAddr, "reason", err)
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requestURL rebuilds the URL Twilio requested. Twilio only calls public
+// HTTPS endpoints, usually through a TLS-terminating proxy, so the scheme is
+// https, or wss for a WebSocket handshake.
+func (v *Validator) requestURL(r *http.Request) string {
+	scheme := "https"
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		scheme = "wss"
+	}
+	host := v.PublicHost
+	if host == "" {
+		host = r.Host
+	}
+	return scheme + "://" + host + r.URL.RequestURI()
+}
+
+// StreamTokenTTL is how long a stream token is good for. The stream a
+// token is issued for connects within seconds of the TwiML being fetched,
+// and a stream that drops is reconnected with fresh TwiML and a new token.
+const StreamTokenTTL = 5 * time.Minute
+
+// StreamToken returns the token that ties a Media Stream to callSID until
+// expires. It is derived from the auth token, so any instance of the server
+// can check it, and carries its expiry, so a leaked token can't be replayed
+// once the call has connected.
+func StreamToken(authToken, callSID string, expires time.Time) string {
+	expiry := strconv.FormatInt(expires.Unix(), 10)
+	return expiry + "." + streamTokenMAC(authToken, callSID, expiry)
+}
+
+// VerifyStreamToken reports whether token is a stream token for callSID
+// that hasn't expired at now.
+func VerifyStreamToken(authToken, callSID, token string, now time.Time) bool {
+	expiry, mac, ok := strings.Cut(token, ".")
+	if callSID == "" || !ok {
+		return false
+	}
+	expires, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || now.Unix() >= expires {
+		return false
+	}
+	return hmac.Equal([]byte(mac), []byte(streamTokenMAC(authToken, callSID, expiry)))
+}
+
+// streamTokenMAC returns the MAC of a stream token for callSID expiring at
+// the Unix time expiry.
+func streamTokenMAC(authToken, callSID, expiry string) string {
+	mac := hmac.New(sha256.New, []byte(authToken))
+	mac.Write([]byte("media-stream:" + callSID + ":" + expiry))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
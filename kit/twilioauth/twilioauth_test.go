@@ -0,0 +1,153 @@
+package twilioauth
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The request and signature of the example in Twilio's webhook security
+// documentation.
+const (
+	docsAuthToken = "12345"
+	docsURL       = "https://mycompany.com/myapp.php?foo=1&bar=2"
+	docsSignature = "0/KCTR6DLpKmkAf8muzZqo1nDgQ="
+)
+
+var docsParams = url.Values{
+	"CallSid": {"CA1234567890ABCDE"},
+	"Caller":  {"+12349013030"},
+	"Digits":  {"1234"},
+	"From":    {"+12349013030"},
+	"To":      {"+18005551212"},
+}
+
+func TestSignature(t *testing.T) {
+	if got := Signature(docsAuthToken, docsURL, docsParams); got != docsSignature {
+		t.Errorf("Signature = %q, want %q", got, docsSignature)
+	}
+}
+
+// docsRequest returns the documentation's request, as a proxy forwards it,
+// signed with signature and with its Digits replaced by digits.
+func docsRequest(signature, digits string) *http.Request {
+	form := url.Values{}
+	for key, values := range docsParams {
+		form[key] = values
+	}
+	form.Set("Digits", digits)
+	r := httptest.NewRequest(http.MethodPost, "http://mycompany.com/myapp.php?foo=1&bar=2", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if signature != "" {
+		r.Header.Set(SignatureHeader, signature)
+	}
+	return r
+}
+
+func TestValidate(t *testing.T) {
+	v := &Validator{AuthToken: docsAuthToken}
+	for _, tt := range []struct {
+		name string
+		r    *http.Request
+		want error
+	}{
+		{"signed", docsRequest(docsSignature, "1234"), nil},
+		{"tampered signature", docsRequest("1/KCTR6DLpKmkAf8muzZqo1nDgQ=", "1234"), ErrInvalidSignature},
+		{"tampered parameter", docsRequest(docsSignature, "9999"), ErrInvalidSignature},
+		{"missing header", docsRequest("", "1234"), ErrMissingSignature},
+	} {
+		if err := v.Validate(tt.r); !errors.Is(err, tt.want) {
+			t.Errorf("%s: Validate = %v, want %v", tt.name, err, tt.want)
+		}
+	}
+
+	// The form is still there for the handler
+	r := docsRequest(docsSignature, "1234")
+	if err := v.Validate(r); err != nil {
+		t.Fatal(err)
+	}
+	if got := r.FormValue("Digits"); got != "1234" {
+		t.Errorf("Digits after Validate = %q, want 1234", got)
+	}
+}
+
+func TestValidateHost(t *testing.T) {
+	// Behind a proxy that rewrites the Host header, the URL Twilio
+	// requested is rebuilt with PublicHost
+	r := docsRequest(docsSignature, "1234")
+	r.Host = "internal:8080"
+	if err := (&Validator{AuthToken: docsAuthToken}).Validate(r); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("rewritten host: Validate = %v, want %v", err, ErrInvalidSignature)
+	}
+	if err := (&Validator{AuthToken: docsAuthToken, PublicHost: "mycompany.com"}).Validate(r); err != nil {
+		t.Errorf("rewritten host with PublicHost: Validate = %v", err)
+	}
+}
+
+func TestValidateWebSocket(t *testing.T) {
+	// A Media Streams handshake is signed as a wss URL without parameters
+	r := httptest.NewRequest(http.MethodGet, "http://voice.example.com/media-stream", nil)
+	r.Header.Set("Upgrade", "websocket")
+	r.Header.Set(SignatureHeader, Signature("token", "wss://voice.example.com/media-stream", nil))
+	if err := (&Validator{AuthToken: "token"}).Validate(r); err != nil {
+		t.Errorf("Validate = %v", err)
+	}
+	r.Header.Set(SignatureHeader, Signature("token", "https://voice.example.com/media-stream", nil))
+	if err := (&Validator{AuthToken: "token"}).Validate(r); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("https signature on a handshake: Validate = %v, want %v", err, ErrInvalidSignature)
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	h := (&Validator{AuthToken: docsAuthToken}).Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	for _, tt := range []struct {
+		name string
+		r    *http.Request
+		want int
+	}{
+		{"signed", docsRequest(docsSignature, "1234"), http.StatusNoContent},
+		{"tampered", docsRequest(docsSignature, "9999"), http.StatusForbidden},
+		{"unsigned", docsRequest("", "1234"), http.StatusForbidden},
+	} {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, tt.r)
+		if w.Code != tt.want {
+			t.Errorf("%s: status %d, want %d", tt.name, w.Code, tt.want)
+		}
+	}
+}
+
+func TestStreamToken(t *testing.T) {
+	now := time.Date(2025, time.March, 15, 12, 0, 0, 0, time.UTC)
+	expires := now.Add(StreamTokenTTL)
+	token := StreamToken("secret", "CA1", expires)
+
+	for _, tt := range []struct {
+		name               string
+		authToken, callSID string
+		token              string
+		at                 time.Time
+		want               bool
+	}{
+		{"round trip", "secret", "CA1", token, now, true},
+		{"just before expiry", "secret", "CA1", token, expires.Add(-time.Second), true},
+		{"at expiry", "secret", "CA1", token, expires, false},
+		{"after expiry", "secret", "CA1", token, expires.Add(time.Hour), false},
+		{"other call", "secret", "CA2", token, now, false},
+		{"other auth token", "other", "CA1", token, now, false},
+		{"no call", "secret", "", StreamToken("secret", "", expires), now, false},
+		{"expiry extended", "secret", "CA1", "9999999999" + token[strings.Index(token, "."):], now, false},
+		{"no expiry", "secret", "CA1", token[strings.Index(token, ".")+1:], now, false},
+		{"empty", "secret", "CA1", "", now, false},
+	} {
+		if got := VerifyStreamToken(tt.authToken, tt.callSID, tt.token, tt.at); got != tt.want {
+			t.Errorf("%s: VerifyStreamToken = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+// Package twiml builds TwiML, the XML documents that tell Twilio what to
+// do with a call, from typed verbs rather than string formatting. Every
+// attribute and text is escaped, so caller IDs, SIP headers and messages
+// containing quotes, ampersands or angle brackets can't break a document.
+//
+// A Response lists the verbs Twilio carries out in order:
+//
+//	doc := twiml.Response{
+//		twiml.Say{Text: "Connecting you now.", Language: "en-GB"},
+//		twiml.Connect{Stream: twiml.Stream{
+//			URL:        "wss://example.com/media-stream",
+//			Parameters: map[string]string{"caller": from},
+//		}},
+//	}
+//	w.Header().Set("Content-Type", twiml.ContentType)
+//	io.WriteString(w, doc.String())
+//
+// Only the verbs and attributes the examples use are covered.
+package twiml
+
+import (
+	"encoding/xml"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ContentType is the media type of a TwiML response.
+const ContentType = "application/xml"
+
+// Verb is an instruction in a Response.
+type Verb interface {
+	element() element
+}
+
+// Response is a TwiML document.
+type Response []Verb
+
+// String renders the document, indented, with an XML declaration.
+func (r Response) String() string {
+	root := element{name: "Response"}
+	for _, verb := range r {
+		root.children = append(root.children, verb.element())
+	}
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>` + "\n")
+	root.write(&b, 0)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// Say reads text to the caller with Twilio's text-to-speech.
+type Say struct {
+	Text string
+	// Voice is a Twilio voice, e.g. "alice" or "Polly.Joanna"; empty uses
+	// Twilio's default.
+	Voice string
+	// Language is the language Text is read in, e.g. "en-GB"; empty uses
+	// the voice's default.
+	Language string
+}
+
+func (s Say) element() element {
+	return element{name: "Say", attrs: attrs("voice", s.Voice, "language", s.Language), text: s.Text}
+}
+
+// Play plays an audio file to the caller, e.g. hold music.
+type Play struct {
+	URL string
+	// Loop is how many times to play the file; 0 plays it once.
+	Loop int
+}
+
+func (p Play) element() element {
+	var loop string
+	if p.Loop > 0 {
+		loop = strconv.Itoa(p.Loop)
+	}
+	return element{name: "Play", attrs: attrs("loop", loop), text: p.URL}
+}
+
+// Connect connects the call to a bidirectional Media Stream for as long
+// as the stream lasts.
+type Connect struct {
+	Stream Stream
+	// Action, if set, is requested once the stream ends, e.g. because the
+	// server closed it; the call carries on with the TwiML it returns.
+	Action string
+}
+
+func (c Connect) element() element {
+	return element{name: "Connect", attrs: attrs("action", c.Action), children: []element{c.Stream.element()}}
+}
+
+// Start starts a unidirectional Media Stream alongside the verbs that
+// follow it.
+type Start struct {
+	Stream Stream
+}
+
+func (s Start) element() element {
+	return element{name: "Start", children: []element{s.Stream.element()}}
+}
+
+// Stream is a Media Stream to a WebSocket.
+type Stream struct {
+	URL string
+	// Track is the audio streamed by <Start>: "inbound_track",
+	// "outbound_track" or "both_tracks". Empty uses Twilio's default.
+	Track string
+	// Parameters are passed to the stream's start message as custom
+	// parameters. They are rendered sorted by name.
+	Parameters map[string]string
+}
+
+func (s Stream) element() element {
+	e := element{name: "Stream", attrs: attrs("url", s.URL, "track", s.Track)}
+	for _, name := range slices.Sorted(maps.Keys(s.Parameters)) {
+		e.children = append(e.children, Parameter{Name: name, Value: s.Parameters[name]}.element())
+	}
+	return e
+}
+
+// Parameter is a custom parameter of a Stream.
+type Parameter struct {
+	Name, Value string
+}
+
+func (p Parameter) element() element {
+	return element{name: "Parameter", attrs: [][2]string{{"name", p.Name}, {"value", p.Value}}}
+}
+
+// Dial connects the caller to another party.
+type Dial struct {
+	// Number is an E.164 number to dial.
+	Number string
+	// SIP, if set, is a SIP URI dialed instead of Number.
+	SIP string
+	// SendDigits are played to a number once it answers, e.g. an
+	// extension; each "w" waits half a second.
+	SendDigits string
+	// URL, if set, is TwiML played to the number once it answers, before
+	// the two are connected, e.g. a whisper saying who is calling.
+	URL string
+	// Timeout is how many seconds to let the number ring; 0 uses Twilio's
+	// default of 30.
+	Timeout int
+	// Action, if set, is requested with the outcome (DialCallStatus) once
+	// the dialed party hangs up or doesn't answer; the call carries on
+	// with the TwiML it returns.
+	Action string
+}
+
+func (d Dial) element() element {
+	noun := element{name: "Number", attrs: attrs("sendDigits", d.SendDigits, "url", d.URL), text: d.Number}
+	if d.SIP != "" {
+		noun = element{name: "Sip", attrs: attrs("url", d.URL), text: d.SIP}
+	}
+	var timeout string
+	if d.Timeout > 0 {
+		timeout = strconv.Itoa(d.Timeout)
+	}
+	return element{name: "Dial", attrs: attrs("timeout", timeout, "action", d.Action), children: []element{noun}}
+}
+
+// Record records the caller, e.g. a voicemail message.
+type Record struct {
+	// MaxLength is the longest recording in seconds; 0 uses Twilio's
+	// default of an hour.
+	MaxLength int
+	PlayBeep  bool
+	// Action, if set, is requested with the recording once it ends.
+	Action string
+}
+
+func (r Record) element() element {
+	var maxLength string
+	if r.MaxLength > 0 {
+		maxLength = strconv.Itoa(r.MaxLength)
+	}
+	return element{name: "Record", attrs: attrs("maxLength", maxLength, "playBeep", strconv.FormatBool(r.PlayBeep), "action", r.Action)}
+}
+
+// Gather listens for the caller's answer to its prompts, spoken or keyed
+// in, and requests Action with it (SpeechResult, Digits). If the caller
+// says nothing, the call carries on with the verbs after it.
+type Gather struct {
+	// Input is "speech", "dtmf" or "dtmf speech"; empty uses Twilio's
+	// default of dtmf.
+	Input  string
+	Action string
+	// Language is the language speech is recognized in, e.g. "en-GB".
+	Language string
+	// SpeechTimeout is how long a pause ends the answer, in seconds, or
+	// "auto" to end it when the caller stops speaking.
+	SpeechTimeout string
+	// NumDigits, if positive, ends a keyed-in answer after that many
+	// keys; otherwise it ends with # or a pause.
+	NumDigits int
+	// Prompt is said or played while listening, e.g. a Say.
+	Prompt []Verb
+}
+
+func (g Gather) element() element {
+	var numDigits string
+	if g.NumDigits > 0 {
+		numDigits = strconv.Itoa(g.NumDigits)
+	}
+	e := element{name: "Gather", attrs: attrs("input", g.Input, "action", g.Action, "language", g.Language, "speechTimeout", g.SpeechTimeout, "numDigits", numDigits)}
+	for _, verb := range g.Prompt {
+		e.children = append(e.children, verb.element())
+	}
+	return e
+}
+
+// Redirect hands the call to the TwiML at URL.
+type Redirect struct {
+	URL string
+}
+
+func (r Redirect) element() element {
+	return element{name: "Redirect", text: r.URL}
+}
+
+// Hangup ends the call.
+type Hangup struct{}
+
+func (Hangup) element() element {
+	return element{name: "Hangup"}
+}
+
+// element is an XML element being rendered.
+type element struct {
+	name     string
+	attrs    [][2]string
+	text     string
+	children []element
+}
+
+// attrs pairs up names and values, leaving out empty values.
+func attrs(pairs ...string) [][2]string {
+	var out [][2]string
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if pairs[i+1] != "" {
+			out = append(out, [2]string{pairs[i], pairs[i+1]})
+		}
+	}
+	return out
+}
+
+// write renders e at depth, one element per line, indented by four spaces
+// per level.
+func (e element) write(b *strings.Builder, depth int) {
+	indent := strings.Repeat("    ", depth)
+	b.WriteString(indent + "<" + e.name)
+	for _, a := range e.attrs {
+		b.WriteString(" " + a[0] + `="`)
+		_ = xml.EscapeText(b, []byte(a[1]))
+		b.WriteString(`"`)
+	}
+	switch {
+	case len(e.children) > 0:
+		b.WriteString(">\n")
+		for _, child := range e.children {
+			child.write(b, depth+1)
+		}
+		b.WriteString(indent + "</" + e.name + ">\n")
+	case e.text != "":
+		b.WriteString(">")
+		_ = xml.EscapeText(b, []byte(e.text))
+		b.WriteString("</" + e.name + ">\n")
+	default:
+		b.WriteString("/>\n")
+	}
+}
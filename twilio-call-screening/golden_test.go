@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/agentplexus/omnivoice-examples/kit/golden"
+	"github.com/agentplexus/omnivoice-examples/kit/intent"
+	"github.com/agentplexus/omnivoice-examples/kit/mail"
+	"github.com/agentplexus/omnivoice-examples/kit/phone"
+)
+
+// update rewrites golden files with what the tests produce instead of
+// comparing them.
+var update = flag.Bool("update", false, "rewrite golden files")
+
+// Fixed inputs for the snapshots.
+const (
+	goldenCallSID = "CA00000000000000000000000000000000"
+	goldenCaller  = "+15551230001"
+	goldenReason  = `It's your sister & it's "urgent"`
+)
+
+// TestGolden compares the TwiML returned by each webhook, and the email
+// sent with a message, with the files in testdata/golden. Run it with
+// -update to rewrite them after an intended change.
+func TestGolden(t *testing.T) {
+	screening := defaultScreening(phone.Number{E164: "+14155550100"})
+	extension := defaultScreening(phone.Number{E164: "+14155550100", Extension: "204"})
+	s := &Server{screening: screening, screener: NewScreener(screening, intent.NewRules(screening.Intents)), sayVoice: "Polly.Amy", language: "en-GB"}
+	screened := func(s *Server) string {
+		s.connects.put(goldenCallSID, goldenCaller, goldenReason)
+		return serve(t, s.handleScreened, "/voice/screened", url.Values{"CallSid": {goldenCallSID}})
+	}
+
+	golden.Check(t, "testdata/golden", map[string]string{
+		"inbound.xml":              serve(t, s.handleInboundCall, "/voice/inbound", url.Values{"CallSid": {goldenCallSID}, "From": {goldenCaller}, "To": {"+14155550199"}}),
+		"screened-connect.xml":     screened(s),
+		"screened-extension.xml":   screened(&Server{screening: extension}),
+		"screened-hangup.xml":      serve(t, s.handleScreened, "/voice/screened", url.Values{"CallSid": {goldenCallSID}}),
+		"whisper.xml":              serve(t, s.handleWhisper, "/voice/whisper?"+url.Values{"caller": {goldenCaller}, "reason": {goldenReason}}.Encode(), nil),
+		"whisper-unknown.xml":      serve(t, s.handleWhisper, "/voice/whisper", nil),
+		"unanswered.xml":           serve(t, s.handleUnanswered, "/voice/unanswered?"+url.Values{"reason": {goldenReason}}.Encode(), url.Values{"CallSid": {goldenCallSID}, "From": {goldenCaller}, "DialCallStatus": {"no-answer"}}),
+		"unanswered-completed.xml": serve(t, s.handleUnanswered, "/voice/unanswered", url.Values{"CallSid": {goldenCallSID}, "DialCallStatus": {"completed"}}),
+		"message-email.txt": messageEmail(t, takenMessage{
+			callSID: goldenCallSID,
+			caller:  goldenCaller,
+			reason:  goldenReason,
+			text:    "Call me back. It's about Sunday.",
+		}),
+		"message-email-unknown.txt": messageEmail(t, takenMessage{callSID: goldenCallSID, text: "Call me back."}),
+	}, *update)
+}
+
+// serve posts form to a webhook handler, as Twilio does, and returns the
+// response body.
+func serve(t *testing.T, handler http.HandlerFunc, target string, form url.Values) string {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "https://voice.example.com"+target, strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	handler(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("%s: status %d", target, w.Code)
+	}
+	return w.Body.String()
+}
+
+// messageEmail renders the email m is passed on in.
+func messageEmail(t *testing.T, m takenMessage) string {
+	t.Helper()
+	var sent mail.Message
+	e := &MessageEmail{
+		From: "Call Screening <screening@example.com>",
+		To:   []string{"owner@example.com"},
+		Sender: senderFunc(func(_ context.Context, m mail.Message) error {
+			sent = m
+			return nil
+		}),
+	}
+	if err := e.Send(context.Background(), m); err != nil {
+		t.Fatal(err)
+	}
+	return fmt.Sprintf("From: %s\nTo: %s\nSubject: %s\n\n%s", sent.From, strings.Join(sent.To, ", "), sent.Subject, sent.Text)
+}
+
+type senderFunc func(ctx context.Context, m mail.Message) error
+
+func (f senderFunc) Send(ctx context.Context, m mail.Message) error { return f(ctx, m) }
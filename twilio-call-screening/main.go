@@ -0,0 +1,346 @@
+// Example: AI receptionist screening calls over Twilio Media Streams
+//
+// This example answers calls to a personal number the way a receptionist
+// would: it asks who's calling and why, classifies the answer, and then
+// either puts the caller through to the owner's real number, takes a
+// message, or hangs up on spam and robocalls:
+//
+//	┌──────────┐        ┌─────────────────┐         ┌──────────────────────────────┐
+//	│  Caller  │◄──────►│      Twilio     │◄───────►│  kit/session                 │
+//	│  (PSTN)  │  PSTN  │      Media      │WebSocket│  STT ─► Screener ─► TTS      │
+//	└──────────┘        │      Streams    │ (μ-law) │         (kit/intent:         │
+//	                    └────────┬────────┘         │          rules + LLM)        │
+//	                             │ <Connect action> └──────────────────────────────┘
+//	          ┌──────────────────┼──────────────────┐
+//	          ▼                  ▼                  ▼
+//	   <Dial> owner      message, emailed       <Hangup>
+//
+// The conversation is run by kit/session, with ElevenLabs speech-to-text
+// and text-to-speech. When the stream ends, Twilio asks the <Connect>
+// action what to do next: a caller being put through is dialed to the
+// owner there.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	elevenlabs "github.com/agentplexus/go-elevenlabs"
+	elevenstt "github.com/agentplexus/go-elevenlabs/omnivoice/stt"
+	elevenvoice "github.com/agentplexus/go-elevenlabs/omnivoice/tts"
+	"github.com/agentplexus/omnivoice-examples/kit/config"
+	"github.com/agentplexus/omnivoice-examples/kit/intent"
+	"github.com/agentplexus/omnivoice-examples/kit/llm"
+	"github.com/agentplexus/omnivoice-examples/kit/mediastream"
+	"github.com/agentplexus/omnivoice-examples/kit/session"
+	"github.com/agentplexus/omnivoice-examples/kit/twilioauth"
+	"github.com/agentplexus/omnivoice-examples/kit/twiml"
+	twiliotransport "github.com/agentplexus/omnivoice-twilio/transport"
+	"github.com/agentplexus/omnivoice/pipeline"
+	"github.com/agentplexus/omnivoice/stt"
+	"github.com/agentplexus/omnivoice/transport"
+	"github.com/agentplexus/omnivoice/tts"
+)
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Load settings from CONFIG_FILE, if set, overridden by the environment
+	cfg, err := config.Load(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if cfg.ElevenLabs.APIKey == "" {
+		log.Fatal("ELEVENLABS_API_KEY (elevenlabs.api_key) required")
+	}
+	if cfg.Twilio.AccountSID == "" || cfg.Twilio.AuthToken == "" {
+		log.Fatal("TWILIO_ACCOUNT_SID and TWILIO_AUTH_TOKEN (twilio.account_sid and twilio.auth_token) required")
+	}
+	screening, err := screeningFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	messages, err := messageEmailFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Answers are classified by keyword, and by a language model when
+	// LLM_PROVIDER is set
+	rules := intent.NewRules(screening.Intents)
+	rules.MaxWords = 0
+	var classifier intent.Classifier = rules
+	if cfg.LLM.Provider != "" {
+		provider, err := llm.FromEnv(cfg.LLM.Provider, cfg.LLM.Model)
+		if err != nil {
+			log.Fatalf("Failed to create LLM provider: %v", err)
+		}
+		classifier = intent.Classifiers{rules, intent.NewModel(provider, screening.Intents)}
+	}
+
+	// Create ElevenLabs STT and TTS providers
+	elevenClient, err := elevenlabs.NewClient(elevenlabs.WithAPIKey(cfg.ElevenLabs.APIKey))
+	if err != nil {
+		log.Fatalf("Failed to create ElevenLabs client: %v", err)
+	}
+
+	// Create Twilio Media Streams transport
+	twilioTransport, err := twiliotransport.New(
+		twiliotransport.WithAccountSID(cfg.Twilio.AccountSID),
+		twiliotransport.WithAuthToken(cfg.Twilio.AuthToken),
+	)
+	if err != nil {
+		log.Fatalf("Failed to create Twilio transport: %v", err)
+	}
+	defer func() {
+		if err := twilioTransport.Close(); err != nil {
+			slog.Error("failed to close Twilio transport", "error", err)
+		}
+	}()
+
+	server := &Server{
+		screening:   screening,
+		screener:    NewScreener(screening, classifier),
+		sttProvider: elevenstt.NewWithClient(elevenClient),
+		ttsProvider: elevenvoice.NewWithClient(elevenClient),
+		voice:       cfg.ElevenLabs,
+		messages:    messages,
+		sayVoice:    cfg.Twilio.SayVoice,
+		language:    cfg.Twilio.SayLanguage,
+	}
+
+	// Media Streams are handed over once their start message, with the
+	// call's custom parameters, has arrived
+	streams, err := mediastream.NewServer(ctx, twilioTransport, "/media-stream")
+	if err != nil {
+		log.Fatalf("Failed to start Media Streams listener: %v", err)
+	}
+
+	// Serve only requests signed by Twilio
+	var signatures *twilioauth.Validator
+	if cfg.Twilio.ValidateSignatures {
+		signatures = &twilioauth.Validator{AuthToken: cfg.Twilio.AuthToken, PublicHost: cfg.Server.PublicHost}
+	} else {
+		slog.Warn("Twilio signature validation disabled; anyone can reach the owner's number through this server")
+	}
+	handle := func(pattern string, h http.Handler) {
+		if signatures != nil {
+			http.Handle(pattern, signatures.Middleware(h))
+			return
+		}
+		http.Handle(pattern, h)
+	}
+	handle("/voice/inbound", http.HandlerFunc(server.handleInboundCall))
+	handle("/media-stream", streams)
+	handle("/voice/screened", http.HandlerFunc(server.handleScreened))
+	handle("/voice/whisper", http.HandlerFunc(server.handleWhisper))
+	handle("/voice/unanswered", http.HandlerFunc(server.handleUnanswered))
+
+	// Handle shutdown
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		cancel()
+	}()
+
+	go server.handleConnections(ctx, streams.Connections())
+
+	addr := cfg.Server.Addr
+	log.Printf("Starting server on %s, screening calls for %s", addr, screening.Owner)
+	httpServer := &http.Server{
+		Addr:              addr,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatalf("Server error: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("Shutting down...")
+	_ = httpServer.Close()
+}
+
+// Server answers Twilio's webhooks and Media Streams for the screened
+// number.
+type Server struct {
+	screening   *Screening
+	screener    *Screener
+	sttProvider stt.StreamingProvider
+	ttsProvider tts.StreamingProvider
+	voice       config.ElevenLabs
+	// messages, if set, emails each message taken.
+	messages *MessageEmail
+	// connects are the callers to put through once their streams end.
+	connects connects
+
+	// sayVoice and language are the Twilio voice and language the owner
+	// hears the whisper in.
+	sayVoice string
+	language string
+}
+
+// handleInboundCall connects a call to the screener.
+func (s *Server) handleInboundCall(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Incoming call: %s -> %s (SID: %s)", r.FormValue("From"), r.FormValue("To"), r.FormValue("CallSid"))
+	writeTwiML(w, twiml.Response{s.connect(r, modeScreen, "")}.String())
+}
+
+// connect streams the call on r to the screener in mode. Once the stream
+// ends, Twilio asks handleScreened what to do next.
+func (s *Server) connect(r *http.Request, mode, reason string) twiml.Connect {
+	params := map[string]string{
+		paramCallSID: r.FormValue("CallSid"),
+		paramCaller:  r.FormValue("From"),
+		paramMode:    mode,
+	}
+	if reason != "" {
+		params[paramReason] = reason
+	}
+	return twiml.Connect{
+		Action: fmt.Sprintf("https://%s/voice/screened", r.Host),
+		Stream: twiml.Stream{URL: fmt.Sprintf("wss://%s/media-stream", r.Host), Parameters: params},
+	}
+}
+
+// handleConnections screens calls on incoming Media Streams connections.
+func (s *Server) handleConnections(ctx context.Context, connCh <-chan transport.Connection) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case conn := <-connCh:
+			go s.handleSession(ctx, conn)
+		}
+	}
+}
+
+// handleSession screens the call on a single Media Stream, and acts on
+// the outcome before the stream is closed and Twilio asks for it.
+func (s *Server) handleSession(ctx context.Context, conn transport.Connection) {
+	var params map[string]string
+	if c, ok := conn.(interface{ CustomParameters() map[string]string }); ok {
+		params = c.CustomParameters()
+	}
+	log.Printf("New session: %s (call SID: %s, caller: %s, mode: %s)", conn.ID(), params[paramCallSID], params[paramCaller], params[paramMode])
+
+	s.screener.Begin(conn.ID(), params)
+	voice := session.NewVoiceSession(conn, s.sttProvider, s.ttsProvider, s.screener, session.Options{
+		Metadata: params,
+		NoInput:  time.Duration(s.screening.SilenceTimeout) * time.Second,
+		STT: pipeline.STTPipelineConfig{
+			Encoding:   "mulaw",
+			SampleRate: 8000,
+			Channels:   1,
+		},
+		TTS: pipeline.TTSPipelineConfig{
+			VoiceID:      s.voice.VoiceID,
+			OutputFormat: "ulaw",
+			SampleRate:   8000,
+			Model:        s.voice.Model,
+			OnError: func(err error) {
+				slog.Error("TTS error", "error", err, "session", conn.ID())
+			},
+		},
+	})
+	if err := voice.Run(ctx); err != nil {
+		slog.Error("session failed", "error", err, "session", conn.ID())
+	}
+	call := s.screener.End(conn.ID())
+
+	switch call.decision {
+	case decisionConnect:
+		s.connects.put(call.callSID, call.caller, call.reason)
+	case decisionMessage:
+		s.passOn(takenMessage{callSID: call.callSID, caller: call.caller, reason: call.reason, text: call.Message()})
+	}
+	_ = conn.Close()
+	log.Printf("Session ended: %s (decision: %s)", conn.ID(), call.decision)
+}
+
+// handleScreened puts a caller the screener chose to through to the owner,
+// once their stream has ended, and hangs up on anyone else.
+func (s *Server) handleScreened(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	call, ok := s.connects.take(r.Form.Get("CallSid"))
+	if !ok {
+		writeTwiML(w, twiml.Response{twiml.Hangup{}}.String())
+		return
+	}
+	whisper := url.Values{"caller": {call.caller}, "reason": {call.reason}}
+	unanswered := url.Values{"reason": {call.reason}}
+	dial := s.screening.dial()
+	dial.URL = fmt.Sprintf("https://%s/voice/whisper?%s", r.Host, whisper.Encode())
+	dial.Action = fmt.Sprintf("https://%s/voice/unanswered?%s", r.Host, unanswered.Encode())
+	writeTwiML(w, twiml.Response{dial}.String())
+}
+
+// handleWhisper tells the owner, once they answer, who is calling and
+// why, before the caller is put through. It is read by Twilio on the
+// owner's leg of the call, which has no stream.
+func (s *Server) handleWhisper(w http.ResponseWriter, r *http.Request) {
+	writeTwiML(w, twiml.Response{twiml.Say{
+		Text:     whisperText(r.FormValue("caller"), r.FormValue("reason")),
+		Voice:    s.sayVoice,
+		Language: s.language,
+	}}.String())
+}
+
+// handleUnanswered connects a caller the owner didn't pick up for back to
+// the screener to leave a message, and ends a call that was put through
+// once either side hangs up.
+func (s *Server) handleUnanswered(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	status := r.Form.Get("DialCallStatus")
+	if status == "completed" {
+		writeTwiML(w, twiml.Response{twiml.Hangup{}}.String())
+		return
+	}
+	slog.Info("owner didn't answer", "call_sid", r.Form.Get("CallSid"), "status", status)
+	writeTwiML(w, twiml.Response{s.connect(r, modeMessage, r.Form.Get("reason"))}.String())
+}
+
+// passOn logs a message taken, and emails it if messages are emailed. A
+// caller who left no message has nothing to pass on.
+func (s *Server) passOn(msg takenMessage) {
+	if msg.text == "" {
+		slog.Info("no message left", "call_sid", msg.callSID, "caller", msg.caller)
+		return
+	}
+	slog.Info("message taken", "call_sid", msg.callSID, "caller", msg.caller, "reason", msg.reason, "message", msg.text)
+	if s.messages == nil {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), emailSendTimeout)
+		defer cancel()
+		if err := s.messages.Send(ctx, msg); err != nil {
+			slog.Error("failed to email message", "call_sid", msg.callSID, "error", err)
+		}
+	}()
+}
+
+// writeTwiML writes a TwiML document as the response.
+func writeTwiML(w http.ResponseWriter, doc string) {
+	w.Header().Set("Content-Type", twiml.ContentType)
+	if _, err := w.Write([]byte(doc)); err != nil {
+		slog.Error("failed to write TwiML", "error", err)
+	}
+}
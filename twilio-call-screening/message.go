@@ -0,0 +1,88 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/mail"
+)
+
+// emailSendTimeout bounds emailing a message.
+const emailSendTimeout = 30 * time.Second
+
+// takenMessage is a message a screened caller left.
+type takenMessage struct {
+	callSID, caller string
+	// reason is the caller's answer to who's calling and why.
+	reason string
+	// text is the message, as transcribed.
+	text string
+}
+
+// MessageEmail emails the owner each message taken, with the caller's
+// reason for calling and what they said.
+type MessageEmail struct {
+	From   string
+	To     []string
+	Sender mail.Sender
+}
+
+// messageEmailFromEnv builds the message email. EMAIL_FROM is the sender
+// and EMAIL_TO the owner's addresses, comma-separated; SMTP_ADDR (with
+// SMTP_USERNAME and SMTP_PASSWORD) or SENDGRID_API_KEY choose how mail is
+// sent. It returns nil if EMAIL_FROM isn't set: messages are only logged.
+func messageEmailFromEnv() (*MessageEmail, error) {
+	from := os.Getenv("EMAIL_FROM")
+	if from == "" {
+		return nil, nil
+	}
+	e := &MessageEmail{From: from}
+	smtpAddr, sendGridKey := os.Getenv("SMTP_ADDR"), os.Getenv("SENDGRID_API_KEY")
+	switch {
+	case smtpAddr != "" && sendGridKey != "":
+		return nil, errors.New("invalid email configuration: set SMTP_ADDR or SENDGRID_API_KEY, not both")
+	case smtpAddr != "":
+		e.Sender = &mail.SMTP{Addr: smtpAddr, Username: os.Getenv("SMTP_USERNAME"), Password: os.Getenv("SMTP_PASSWORD")}
+	case sendGridKey != "":
+		e.Sender = &mail.SendGrid{APIKey: sendGridKey}
+	default:
+		return nil, errors.New("invalid email configuration: EMAIL_FROM requires SMTP_ADDR or SENDGRID_API_KEY")
+	}
+	for _, to := range strings.Split(os.Getenv("EMAIL_TO"), ",") {
+		if strings.TrimSpace(to) == "" {
+			continue
+		}
+		addr, err := mail.ParseAddress(to)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EMAIL_TO: %w", err)
+		}
+		e.To = append(e.To, addr)
+	}
+	if len(e.To) == 0 {
+		return nil, errors.New("invalid email configuration: EMAIL_FROM requires EMAIL_TO")
+	}
+	return e, nil
+}
+
+// Send emails m to the owner.
+func (e *MessageEmail) Send(ctx context.Context, m takenMessage) error {
+	caller := m.caller
+	if caller == "" {
+		caller = "an unknown number"
+	}
+	reason := m.reason
+	if reason == "" {
+		reason = "(didn't say)"
+	}
+	return e.Sender.Send(ctx, mail.Message{
+		From:    e.From,
+		To:      e.To,
+		Subject: "Message from " + caller,
+		Text: fmt.Sprintf("%s left a message.\n\nWho's calling and why: %s\n\nMessage: %s\n\nCall SID: %s\n",
+			caller, reason, m.text, m.callSID),
+	})
+}
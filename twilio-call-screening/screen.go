@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/agentplexus/omnivoice-examples/kit/intent"
+	"github.com/agentplexus/omnivoice-examples/kit/phone"
+	"github.com/agentplexus/omnivoice-examples/kit/twiml"
+)
+
+// What the screener does with a call, named after the intents it is
+// classified by.
+const (
+	// decisionConnect puts the caller through to the owner.
+	decisionConnect = "connect"
+	// decisionMessage takes a message: what callers get when their answer
+	// isn't clearly one thing or the other.
+	decisionMessage = "message"
+	// decisionReject hangs up on spam and robocalls.
+	decisionReject = "reject"
+)
+
+// defaultConnectKeywords put a caller through: they know the owner, or it
+// can't wait.
+var defaultConnectKeywords = []string{
+	"urgent", "emergency", "it's your", "this is your", "family", "mom", "dad",
+	"son", "daughter", "brother", "sister", "friend", "doctor", "hospital",
+	"school", "you asked me to call", "returning your call", "calling you back",
+}
+
+// defaultRejectKeywords mark the usual unsolicited calls.
+var defaultRejectKeywords = []string{
+	"warranty", "extended warranty", "special offer", "limited time offer",
+	"you've been selected", "you have been selected", "congratulations",
+	"lower your interest rate", "credit card debt", "student loan",
+	"free cruise", "vacation package", "solar", "social security",
+	"tax debt", "irs", "press one", "press 1", "sales", "promotion",
+	"survey", "donation", "fundraising", "final notice",
+}
+
+// Screening is how calls to the owner's number are answered and sorted.
+type Screening struct {
+	// Owner is the number screened callers are put through to.
+	Owner phone.Number
+	// RingTimeout is how many seconds the owner's phone rings before the
+	// caller is asked to leave a message.
+	RingTimeout int
+	// MessageMaxLength is the longest message in seconds; a longer one is
+	// ended at the caller's next pause.
+	MessageMaxLength int
+	// SilenceTimeout is how many seconds of silence end the caller's
+	// answer or message.
+	SilenceTimeout int
+
+	// Intents are what answers are classified as: decisionConnect and
+	// decisionReject. Anything else takes a message.
+	Intents []intent.Intent
+
+	// What the caller is told: asked who's calling, told they're being put
+	// through or turned away, asked for a message outright or after the
+	// owner didn't answer, and thanked for it.
+	Greeting          string
+	ConnectMessage    string
+	RejectMessage     string
+	MessagePrompt     string
+	UnansweredMessage string
+	Goodbye           string
+}
+
+// defaultScreening returns the screening of calls to owner.
+func defaultScreening(owner phone.Number) *Screening {
+	return &Screening{
+		Owner:            owner,
+		RingTimeout:      20,
+		MessageMaxLength: 120,
+		SilenceTimeout:   5,
+		Intents: []intent.Intent{
+			{
+				Name:        decisionReject,
+				Description: "a sales, marketing, survey or charity call, a recorded message, or a scam such as a warranty, debt relief or tax threat",
+				Keywords:    slices.Clone(defaultRejectKeywords),
+			},
+			{
+				Name:        decisionConnect,
+				Description: "someone who knows the person they called, such as family, a friend, their doctor or their child's school, or anything urgent",
+				Keywords:    slices.Clone(defaultConnectKeywords),
+			},
+		},
+		Greeting:          "Hello, you've reached an automated assistant. May I ask who's calling, and what it's about?",
+		ConnectMessage:    "Thank you. Please hold while I put you through.",
+		RejectMessage:     "Thank you, but they don't take calls like this one. Please remove this number from your list. Goodbye.",
+		MessagePrompt:     "Thank you. They can't come to the phone right now, so please leave a message and I'll pass it on.",
+		UnansweredMessage: "Sorry, they're not available. Please leave a message and I'll pass it on.",
+		Goodbye:           "Thank you, I'll pass your message on. Goodbye.",
+	}
+}
+
+// screeningFromEnv reads the screening of calls. SCREEN_OWNER_NUMBER, the
+// number callers are put through to, is required; DIAL_COUNTRY_CODE
+// (default 1) reads it if it has no country code. SCREEN_RING_TIMEOUT,
+// SCREEN_MESSAGE_MAX_LENGTH and SCREEN_SILENCE_TIMEOUT are in seconds. SCREEN_CONNECT_KEYWORDS and
+// SCREEN_REJECT_KEYWORDS, comma-separated, add to the default keywords,
+// e.g. the names of family members. SCREEN_GREETING, SCREEN_CONNECT_MESSAGE,
+// SCREEN_REJECT_MESSAGE, SCREEN_MESSAGE_PROMPT, SCREEN_UNANSWERED_MESSAGE
+// and SCREEN_GOODBYE change what callers are told.
+func screeningFromEnv() (*Screening, error) {
+	v := os.Getenv("SCREEN_OWNER_NUMBER")
+	if v == "" {
+		return nil, errors.New("SCREEN_OWNER_NUMBER required")
+	}
+	countryCode := os.Getenv("DIAL_COUNTRY_CODE")
+	if countryCode == "" {
+		countryCode = "1"
+	}
+	owner, err := phone.PlanFor(countryCode).Parse(v)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SCREEN_OWNER_NUMBER: %q", v)
+	}
+	s := defaultScreening(owner)
+	for _, n := range []struct {
+		env string
+		dst *int
+	}{
+		{"SCREEN_RING_TIMEOUT", &s.RingTimeout},
+		{"SCREEN_MESSAGE_MAX_LENGTH", &s.MessageMaxLength},
+		{"SCREEN_SILENCE_TIMEOUT", &s.SilenceTimeout},
+	} {
+		if v := os.Getenv(n.env); v != "" {
+			seconds, err := strconv.Atoi(v)
+			if err != nil || seconds <= 0 {
+				return nil, fmt.Errorf("invalid %s: %q", n.env, v)
+			}
+			*n.dst = seconds
+		}
+	}
+	for i, env := range []string{"SCREEN_REJECT_KEYWORDS", "SCREEN_CONNECT_KEYWORDS"} {
+		// In the order of s.Intents
+		for _, kw := range strings.Split(os.Getenv(env), ",") {
+			if kw = strings.TrimSpace(kw); kw != "" {
+				s.Intents[i].Keywords = append(s.Intents[i].Keywords, kw)
+			}
+		}
+	}
+	for _, m := range []struct {
+		env string
+		dst *string
+	}{
+		{"SCREEN_GREETING", &s.Greeting},
+		{"SCREEN_CONNECT_MESSAGE", &s.ConnectMessage},
+		{"SCREEN_REJECT_MESSAGE", &s.RejectMessage},
+		{"SCREEN_MESSAGE_PROMPT", &s.MessagePrompt},
+		{"SCREEN_UNANSWERED_MESSAGE", &s.UnansweredMessage},
+		{"SCREEN_GOODBYE", &s.Goodbye},
+	} {
+		if v := os.Getenv(m.env); v != "" {
+			*m.dst = v
+		}
+	}
+	return s, nil
+}
+
+// Decide classifies a caller's answer to who's calling and why. A caller
+// who gave no answer, or one the classifier couldn't place or failed on,
+// is asked for a message.
+func (s *Screening) Decide(ctx context.Context, classifier intent.Classifier, answer string) (string, error) {
+	if strings.TrimSpace(answer) == "" {
+		return decisionMessage, nil
+	}
+	name, err := classifier.Classify(ctx, answer)
+	if err != nil {
+		return decisionMessage, err
+	}
+	if name == "" {
+		return decisionMessage, nil
+	}
+	return name, nil
+}
+
+// dial rings the owner's number, dialing its extension, if any, once it
+// answers.
+func (s *Screening) dial() twiml.Dial {
+	dial := twiml.Dial{Number: s.Owner.Address(), Timeout: s.RingTimeout}
+	switch {
+	case s.Owner.IsSIP():
+		dial = twiml.Dial{SIP: s.Owner.Address(), Timeout: s.RingTimeout}
+	case s.Owner.Extension != "":
+		// Each w waits half a second for the far end to answer
+		dial.SendDigits = "ww" + s.Owner.Extension
+	}
+	return dial
+}
+
+// whisperText is what the owner hears about a screened call before it is
+// put through.
+func whisperText(caller, reason string) string {
+	if caller == "" {
+		caller = "an unknown number"
+	}
+	if reason == "" {
+		return fmt.Sprintf("Screened call from %s. Connecting you now.", caller)
+	}
+	return fmt.Sprintf("Screened call from %s, who said: %s. Connecting you now.", caller, reason)
+}
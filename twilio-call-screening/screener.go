@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/agent"
+	"github.com/agentplexus/omnivoice-examples/kit/intent"
+)
+
+// Stream parameters set by the TwiML that connects a call to the
+// screener.
+const (
+	paramCallSID = "callSid"
+	paramCaller  = "caller"
+	// paramMode is modeScreen to ask who's calling, or modeMessage to take
+	// a message straight away, for a caller the owner didn't answer.
+	paramMode = "mode"
+	// paramReason is the caller's answer, when they have already given it.
+	paramReason = "reason"
+)
+
+// Stream modes.
+const (
+	modeScreen  = "screen"
+	modeMessage = "message"
+)
+
+// screenedTTL is how long a decision to put a caller through waits for
+// Twilio to ask for it once the stream has ended.
+const screenedTTL = time.Minute
+
+// Screener is the agent on the line: it asks who's calling and why, acts on
+// the answer, and takes messages. Each call's outcome is kept until End.
+type Screener struct {
+	screening  *Screening
+	classifier intent.Classifier
+
+	mu    sync.Mutex
+	calls map[string]*screenedCall
+}
+
+// screenedCall is one call's screening.
+type screenedCall struct {
+	callSID, caller string
+	mode            string
+	// decision is what was decided from reason, the caller's answer, and
+	// is empty until they gave one.
+	decision, reason string
+	// message is what the caller said after being asked for a message,
+	// which started at messageStart.
+	message      []string
+	messageStart time.Time
+}
+
+// NewScreener returns an agent screening calls as screening says.
+func NewScreener(screening *Screening, classifier intent.Classifier) *Screener {
+	return &Screener{screening: screening, classifier: classifier, calls: map[string]*screenedCall{}}
+}
+
+// Begin starts screening a session with its stream parameters.
+func (s *Screener) Begin(sessionID string, params map[string]string) {
+	c := &screenedCall{
+		callSID: params[paramCallSID],
+		caller:  params[paramCaller],
+		mode:    params[paramMode],
+		reason:  params[paramReason],
+	}
+	if c.mode == modeMessage {
+		c.decision = decisionMessage
+		c.messageStart = time.Now()
+	}
+	s.mu.Lock()
+	s.calls[sessionID] = c
+	s.mu.Unlock()
+}
+
+// End stops screening a session and returns how it went.
+func (s *Screener) End(sessionID string) *screenedCall {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := s.calls[sessionID]
+	delete(s.calls, sessionID)
+	return c
+}
+
+// Greeting asks who's calling, or for a message from a caller the owner
+// didn't answer.
+func (s *Screener) Greeting(sessionID string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c := s.calls[sessionID]; c != nil && c.mode == modeMessage {
+		return s.screening.UnansweredMessage
+	}
+	return s.screening.Greeting
+}
+
+// OnUserTurn acts on the caller's answer, or adds to their message. An
+// empty turn is the caller falling silent: before answering, as robocalls
+// do, it is hung up on; after a message, it ends the message.
+func (s *Screener) OnUserTurn(ctx context.Context, turn agent.Turn) (<-chan agent.Response, error) {
+	s.mu.Lock()
+	c := s.calls[turn.SessionID]
+	s.mu.Unlock()
+	if c == nil {
+		return agent.Reply(agent.Do(agent.ActionHangup, "")), nil
+	}
+
+	if c.decision == "" {
+		if turn.Text == "" {
+			slog.Info("caller said nothing; hanging up", "call_sid", c.callSID, "caller", c.caller)
+			return agent.Reply(agent.Do(agent.ActionHangup, "")), nil
+		}
+		decision, err := s.screening.Decide(ctx, s.classifier, turn.Text)
+		if err != nil {
+			// Better a message the owner didn't need than a call they missed
+			slog.Warn("failed to classify caller", "call_sid", c.callSID, "error", err)
+		}
+		slog.Info("call screened", "call_sid", c.callSID, "caller", c.caller, "reason", turn.Text, "decision", decision)
+		s.mu.Lock()
+		c.decision, c.reason = decision, turn.Text
+		if decision == decisionMessage {
+			c.messageStart = time.Now()
+		}
+		s.mu.Unlock()
+		switch decision {
+		case decisionConnect:
+			return agent.Reply(agent.Say(s.screening.ConnectMessage), agent.Do(agent.ActionHangup, "")), nil
+		case decisionReject:
+			return agent.Reply(agent.Say(s.screening.RejectMessage), agent.Do(agent.ActionHangup, "")), nil
+		default:
+			return agent.Reply(agent.Say(s.screening.MessagePrompt)), nil
+		}
+	}
+
+	// Taking a message: it ends when the caller falls silent or runs out
+	// of time
+	s.mu.Lock()
+	if turn.Text != "" {
+		c.message = append(c.message, turn.Text)
+	}
+	done := turn.Text == "" || time.Since(c.messageStart) >= time.Duration(s.screening.MessageMaxLength)*time.Second
+	s.mu.Unlock()
+	if !done {
+		return agent.Reply(), nil
+	}
+	return agent.Reply(agent.Say(s.screening.Goodbye), agent.Do(agent.ActionHangup, "")), nil
+}
+
+// Message returns the message the caller left, if any.
+func (c *screenedCall) Message() string {
+	return strings.Join(c.message, " ")
+}
+
+// connects holds the callers to put through once their streams end, by
+// call SID, until Twilio asks for them.
+type connects struct {
+	mu    sync.Mutex
+	calls map[string]connectCall
+}
+
+type connectCall struct {
+	caller, reason string
+	expires        time.Time
+}
+
+// put records that the caller on callSID is to be put through.
+func (c *connects) put(callSID, caller, reason string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := time.Now()
+	for sid, call := range c.calls {
+		if now.After(call.expires) {
+			delete(c.calls, sid)
+		}
+	}
+	if c.calls == nil {
+		c.calls = map[string]connectCall{}
+	}
+	c.calls[callSID] = connectCall{caller: caller, reason: reason, expires: now.Add(screenedTTL)}
+}
+
+// take returns and forgets the caller on callSID to put through, if any.
+func (c *connects) take(callSID string) (connectCall, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	call, ok := c.calls[callSID]
+	delete(c.calls, callSID)
+	if ok && time.Now().After(call.expires) {
+		return connectCall{}, false
+	}
+	return call, ok
+}
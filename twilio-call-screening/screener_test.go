@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/agentplexus/omnivoice-examples/kit/agent"
+	"github.com/agentplexus/omnivoice-examples/kit/intent"
+	"github.com/agentplexus/omnivoice-examples/kit/phone"
+)
+
+// replies runs a turn and returns what the screener said and whether it
+// hung up.
+func replies(t *testing.T, s *Screener, sessionID, text string) (said []string, hangup bool) {
+	t.Helper()
+	responses, err := s.OnUserTurn(context.Background(), agent.Turn{SessionID: sessionID, Text: text})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for r := range responses {
+		if r.Text != "" {
+			said = append(said, r.Text)
+		}
+		if r.Action != nil && r.Action.Kind == agent.ActionHangup {
+			hangup = true
+		}
+	}
+	return said, hangup
+}
+
+func newTestScreener() (*Screener, *Screening) {
+	screening := defaultScreening(phone.Number{E164: "+14155550100"})
+	return NewScreener(screening, intent.NewRules(screening.Intents)), screening
+}
+
+func TestScreener(t *testing.T) {
+	tests := []struct {
+		answer   string
+		decision string
+		said     func(*Screening) string
+		hangup   bool
+	}{
+		{"It's your sister, it's urgent", decisionConnect, func(s *Screening) string { return s.ConnectMessage }, true},
+		{"Congratulations, you've been selected for a free cruise", decisionReject, func(s *Screening) string { return s.RejectMessage }, true},
+		{"I'm calling about the bike you listed", decisionMessage, func(s *Screening) string { return s.MessagePrompt }, false},
+	}
+	for _, tt := range tests {
+		s, screening := newTestScreener()
+		s.Begin("MZ1", map[string]string{paramCallSID: "CA1", paramCaller: "+15555550123", paramMode: modeScreen})
+		said, hangup := replies(t, s, "MZ1", tt.answer)
+		if len(said) != 1 || said[0] != tt.said(screening) || hangup != tt.hangup {
+			t.Errorf("answer %q: said %q, hung up %v", tt.answer, said, hangup)
+		}
+		if call := s.End("MZ1"); call.decision != tt.decision || call.reason != tt.answer {
+			t.Errorf("answer %q: decision %q, reason %q", tt.answer, call.decision, call.reason)
+		}
+	}
+}
+
+func TestScreenerHangsUpOnSilence(t *testing.T) {
+	s, _ := newTestScreener()
+	s.Begin("MZ1", map[string]string{paramMode: modeScreen})
+	if said, hangup := replies(t, s, "MZ1", ""); len(said) != 0 || !hangup {
+		t.Errorf("silent caller: said %q, hung up %v", said, hangup)
+	}
+	if call := s.End("MZ1"); call.decision != "" {
+		t.Errorf("silent caller decided %q", call.decision)
+	}
+}
+
+func TestScreenerTakesMessage(t *testing.T) {
+	s, screening := newTestScreener()
+	s.Begin("MZ1", map[string]string{paramMode: modeMessage, paramReason: "It's your sister"})
+	if got := s.Greeting("MZ1"); got != screening.UnansweredMessage {
+		t.Errorf("Greeting = %q, want the unanswered message", got)
+	}
+	for _, text := range []string{"Call me back.", "It's about Sunday."} {
+		if said, hangup := replies(t, s, "MZ1", text); len(said) != 0 || hangup {
+			t.Fatalf("message %q: said %q, hung up %v", text, said, hangup)
+		}
+	}
+	if said, hangup := replies(t, s, "MZ1", ""); len(said) != 1 || said[0] != screening.Goodbye || !hangup {
+		t.Errorf("end of message: said %q, hung up %v", said, hangup)
+	}
+	call := s.End("MZ1")
+	if call.decision != decisionMessage || call.reason != "It's your sister" || call.Message() != "Call me back. It's about Sunday." {
+		t.Errorf("call = %+v", call)
+	}
+}
@@ -0,0 +1,319 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/audio"
+	"github.com/agentplexus/omnivoice-examples/kit/storage"
+)
+
+// adminActionTimeout bounds an admin action that calls out to Twilio.
+const adminActionTimeout = 10 * time.Second
+
+// Transcript speakers.
+const (
+	speakerCaller = "caller"
+	speakerAgent  = "agent"
+)
+
+// TranscriptLine is one utterance of a live transcript.
+type TranscriptLine struct {
+	Speaker string `json:"speaker"`
+	Text    string `json:"text"`
+	// Target is the only leg that heard a whispered utterance.
+	Target Leg       `json:"target,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// liveCall is what the admin API sees of an agent call in progress: its
+// transcript so far, its audio for supervisors listening in, and the
+// controls an operator can use.
+type liveCall struct {
+	// say speaks an operator's message, even while muted, to target only
+	// unless target is LegAll.
+	say func(text string, target Leg) error
+	// setMuted stops or resumes the agent's speech.
+	setMuted func(muted bool)
+	// setVoice has the agent speak in another voice from now on; empty
+	// returns to the call's voice.
+	setVoice func(voiceID string)
+	// hangUp ends the call at once.
+	hangUp func(ctx context.Context)
+	// takeOver pauses or resumes the agent while a supervisor handles the
+	// call.
+	takeOver func(on bool)
+	// recorded, if set, receives every transcript line as it is added.
+	recorded func(TranscriptLine)
+
+	codec audio.Codec
+	// takeOverMu serializes takeovers, so the agent's state follows the
+	// last one.
+	takeOverMu sync.Mutex
+
+	mu          sync.Mutex
+	transcript  []TranscriptLine
+	muted       bool
+	watchers    map[*monitorWatcher]struct{}
+	listeners   int             // watchers receiving audio
+	takenOverBy *monitorWatcher // nil unless a supervisor has taken over
+	agentAudio  []int16         // played audio not yet mixed with the caller's
+	ended       bool
+}
+
+// newLiveCall creates the live view of a call carried in codec. Its
+// controls are set once the session's pipelines exist.
+func newLiveCall(codec audio.Codec) *liveCall {
+	return &liveCall{codec: codec, watchers: make(map[*monitorWatcher]struct{})}
+}
+
+// Add appends an utterance to the transcript.
+func (c *liveCall) Add(speaker, text string) {
+	c.AddTo(speaker, text, LegAll)
+}
+
+// AddTo is like Add for an utterance only target heard.
+func (c *liveCall) AddTo(speaker, text string, target Leg) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	line := TranscriptLine{Speaker: speaker, Text: text, Target: target, At: time.Now()}
+	c.transcript = append(c.transcript, line)
+	c.publish(MonitorEvent{Kind: monitorTranscript, Speaker: speaker, Text: text, Target: target, At: line.At})
+	if c.recorded != nil {
+		c.recorded(line)
+	}
+}
+
+// Interim passes on an interim transcript to the watchers that want them,
+// e.g. for live captions. It isn't added to the transcript.
+func (c *liveCall) Interim(speaker, text string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.publish(MonitorEvent{Kind: monitorInterim, Speaker: speaker, Text: text, At: time.Now()})
+}
+
+// Restore starts the transcript with the lines of an earlier stream of the
+// same call.
+func (c *liveCall) Restore(lines []TranscriptLine) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.transcript = append(append([]TranscriptLine(nil), lines...), c.transcript...)
+}
+
+// Mute stops or resumes the agent's speech.
+func (c *liveCall) Mute(muted bool) {
+	c.mu.Lock()
+	c.muted = muted
+	c.publish(c.state())
+	c.mu.Unlock()
+	c.setMuted(muted)
+}
+
+// snapshot returns a copy of the transcript, whether the agent is muted,
+// and whether a supervisor has taken over.
+func (c *liveCall) snapshot() (transcript []TranscriptLine, muted, takenOver bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]TranscriptLine(nil), c.transcript...), c.muted, c.takenOverBy != nil
+}
+
+// adminSession is a session as listed by the admin API.
+type adminSession struct {
+	SessionInfo
+	Muted      bool             `json:"muted"`
+	TakenOver  bool             `json:"taken_over"`
+	Transcript []TranscriptLine `json:"transcript,omitempty"`
+}
+
+// adminAPI serves the admin endpoints for inspecting and controlling calls
+// in progress.
+type adminAPI struct {
+	sessions *SessionManager
+	events   *eventLog
+	// archive, if set, is the call archive, read for calls that have
+	// ended.
+	archive storage.Reader
+}
+
+// newAdminHandler returns the admin API, served only to requests bearing
+// token.
+func newAdminHandler(sessions *SessionManager, events *eventLog, archive storage.Reader, token string) http.Handler {
+	a := &adminAPI{sessions: sessions, events: events, archive: archive}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /admin/events", a.recentEvents)
+	mux.HandleFunc("GET /admin/sessions", a.list)
+	mux.HandleFunc("GET /admin/sessions/{id}", a.get)
+	mux.HandleFunc("POST /admin/sessions/{id}/say", a.say)
+	mux.HandleFunc("POST /admin/sessions/{id}/mute", a.mute(true))
+	mux.HandleFunc("POST /admin/sessions/{id}/unmute", a.mute(false))
+	mux.HandleFunc("POST /admin/sessions/{id}/hangup", a.hangUp)
+	mux.HandleFunc("POST /admin/sessions/{id}/voice", a.voice)
+	mux.HandleFunc("GET /admin/sessions/{id}/monitor", a.monitor)
+	mux.HandleFunc("GET /admin/sessions/{id}/captions", a.captions)
+	mux.HandleFunc("GET /admin/calls", a.archivedCalls)
+	mux.HandleFunc("GET /admin/calls/{call}/{started}", a.archivedCall)
+	mux.HandleFunc("GET /admin/calls/{call}/audio/{name}", a.archivedAudio)
+	return requireToken(token, mux)
+}
+
+// list returns every session in progress with its transcript.
+func (a *adminAPI) list(w http.ResponseWriter, r *http.Request) {
+	infos := a.sessions.List()
+	sessions := make([]adminSession, 0, len(infos))
+	for _, info := range infos {
+		sessions = append(sessions, a.describe(info))
+	}
+	connecting, queued, draining := a.sessions.Status()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"sessions":       sessions,
+		"connecting":     connecting,
+		"queued":         queued,
+		"max_concurrent": a.sessions.limits.MaxConcurrent,
+		"draining":       draining,
+	})
+}
+
+// get returns one session with its transcript.
+func (a *adminAPI) get(w http.ResponseWriter, r *http.Request) {
+	info, ok := a.sessions.Get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "unknown session", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, a.describe(info))
+}
+
+// recentEvents returns the latest session events, oldest first, of the
+// session named by ?session= if given.
+func (a *adminAPI) recentEvents(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"events": a.events.Recent(r.URL.Query().Get("session"))})
+}
+
+// say speaks a message into the call, e.g. {"text": "One moment please."}.
+// An optional target ("caller" or "human") whispers it to that leg of a
+// bridged call only.
+func (a *adminAPI) say(w http.ResponseWriter, r *http.Request) {
+	call, ok := a.call(w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		Text   string `json:"text"`
+		Target string `json:"target"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&body); err != nil || strings.TrimSpace(body.Text) == "" {
+		http.Error(w, `body must be {"text": "...", "target": "caller" | "human" (optional)}`, http.StatusBadRequest)
+		return
+	}
+	target, err := parseLeg(body.Target)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := call.say(body.Text, target); err != nil {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	slog.Info("admin injected message", "session", r.PathValue("id"), "text", body.Text, "target", target)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// mute stops or resumes the agent's speech. The caller is still
+// transcribed and the agent still sees every turn.
+func (a *adminAPI) mute(muted bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		call, ok := a.call(w, r)
+		if !ok {
+			return
+		}
+		slog.Info("admin set agent mute", "session", r.PathValue("id"), "muted", muted)
+		call.Mute(muted)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// voice switches the agent to another voice for the rest of the call, e.g.
+// {"voice_id": "..."}; an empty voice_id returns to the call's voice.
+// What is already queued is spoken in the voice it was queued in.
+func (a *adminAPI) voice(w http.ResponseWriter, r *http.Request) {
+	call, ok := a.call(w, r)
+	if !ok {
+		return
+	}
+	var body struct {
+		VoiceID string `json:"voice_id"`
+	}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&body); err != nil {
+		http.Error(w, `body must be {"voice_id": "..."}`, http.StatusBadRequest)
+		return
+	}
+	slog.Info("admin switched voice", "session", r.PathValue("id"), "voice", body.VoiceID)
+	call.setVoice(strings.TrimSpace(body.VoiceID))
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// hangUp ends the call immediately.
+func (a *adminAPI) hangUp(w http.ResponseWriter, r *http.Request) {
+	call, ok := a.call(w, r)
+	if !ok {
+		return
+	}
+	slog.Info("admin hung up call", "session", r.PathValue("id"))
+	ctx, cancel := context.WithTimeout(r.Context(), adminActionTimeout)
+	defer cancel()
+	call.hangUp(ctx)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// call looks up the controls of the session named in the request, writing
+// an error response if there are none.
+func (a *adminAPI) call(w http.ResponseWriter, r *http.Request) (*liveCall, bool) {
+	id := r.PathValue("id")
+	if _, ok := a.sessions.Get(id); !ok {
+		http.Error(w, "unknown session", http.StatusNotFound)
+		return nil, false
+	}
+	call, ok := a.sessions.Live(id)
+	if !ok {
+		http.Error(w, "session is not an agent call", http.StatusConflict)
+		return nil, false
+	}
+	return call, true
+}
+
+// describe adds a session's transcript and mute state to its info.
+func (a *adminAPI) describe(info SessionInfo) adminSession {
+	s := adminSession{SessionInfo: info}
+	if call, ok := a.sessions.Live(info.ID); ok {
+		s.Transcript, s.Muted, s.TakenOver = call.snapshot()
+	}
+	return s
+}
+
+// writeJSON writes v as a JSON response.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Error("failed to write response", "error", err)
+	}
+}
+
+// requireToken serves next only to requests bearing token.
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+
+	"github.com/agentplexus/omnivoice-examples/kit/audio"
+)
+
+// AmbienceConfig sets the background noise, such as an office or call
+// centre, played under the agent's voice and between its replies so the
+// call doesn't sound sterile.
+type AmbienceConfig struct {
+	// File is a WAV file of the ambience, which is looped.
+	File string
+	// LevelDB is the gain applied to the file while the agent is quiet.
+	LevelDB float64
+	// DuckDB is how much further the ambience is lowered while the agent
+	// speaks.
+	DuckDB float64
+}
+
+// defaultAmbienceConfig returns the levels used unless overridden by
+// AMBIENCE_LEVEL_DB and AMBIENCE_DUCK_DB.
+func defaultAmbienceConfig() AmbienceConfig {
+	return AmbienceConfig{LevelDB: -24, DuckDB: -10}
+}
+
+// ambienceFromEnv loads the ambience in AMBIENCE_FILE at each wire sample
+// rate, resampling it with quality, or returns nil if none is set.
+func ambienceFromEnv(quality audio.Quality) (*ambienceBed, error) {
+	cfg := defaultAmbienceConfig()
+	cfg.File = os.Getenv("AMBIENCE_FILE")
+	if cfg.File == "" {
+		return nil, nil
+	}
+	for name, level := range map[string]*float64{"AMBIENCE_LEVEL_DB": &cfg.LevelDB, "AMBIENCE_DUCK_DB": &cfg.DuckDB} {
+		v := os.Getenv(name)
+		if v == "" {
+			continue
+		}
+		db, err := strconv.ParseFloat(v, 64)
+		if err != nil || db > 0 {
+			return nil, fmt.Errorf("invalid %s %q (want a gain <= 0)", name, v)
+		}
+		*level = db
+	}
+	return loadAmbience(cfg, quality)
+}
+
+// ambienceBed is a loop of ambience decoded once and shared by every call,
+// at each wire sample rate, and the gains it is played at.
+type ambienceBed struct {
+	loops map[int][]int16
+	// level is the loop's gain while the agent is quiet, and ducked while
+	// it speaks.
+	level, ducked float64
+}
+
+// loadAmbience reads cfg.File and resamples it to each wire sample rate.
+func loadAmbience(cfg AmbienceConfig, quality audio.Quality) (*ambienceBed, error) {
+	f, err := os.Open(cfg.File)
+	if err != nil {
+		return nil, fmt.Errorf("invalid AMBIENCE_FILE: %w", err)
+	}
+	defer f.Close()
+	samples, rate, err := audio.ReadWAV(f)
+	if err != nil {
+		return nil, fmt.Errorf("invalid AMBIENCE_FILE %s: %w", cfg.File, err)
+	}
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("invalid AMBIENCE_FILE %s: no audio", cfg.File)
+	}
+	bed := &ambienceBed{
+		loops:  make(map[int][]int16),
+		level:  dbGain(cfg.LevelDB),
+		ducked: dbGain(cfg.LevelDB + cfg.DuckDB),
+	}
+	for _, codec := range []audio.Codec{audio.CodecMulaw, audio.CodecG722} {
+		to := codec.SampleRate()
+		loop := samples
+		if rate != to {
+			if loop, err = audio.Resample(samples, rate, to, quality); err != nil {
+				return nil, fmt.Errorf("resampling AMBIENCE_FILE: %w", err)
+			}
+		}
+		bed.loops[to] = loop
+	}
+	return bed, nil
+}
+
+// dbGain converts a gain in decibels to a linear factor.
+func dbGain(db float64) float64 {
+	return math.Pow(10, db/20)
+}
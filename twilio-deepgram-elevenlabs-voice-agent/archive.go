@@ -0,0 +1,285 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/storage"
+)
+
+// archiveTimeout bounds each attempt to store an artifact;
+// archiveRecordingTimeout each attempt to fetch, encode and store a
+// recording, which for a long call can be large.
+const (
+	archiveTimeout          = 30 * time.Second
+	archiveRecordingTimeout = 10 * time.Minute
+)
+
+// archiveAttempts is how many times storing an artifact is tried, a few
+// seconds apart, e.g. while Twilio finishes processing a recording.
+const archiveAttempts = 3
+
+// archiveQueue is how many artifacts may wait to be stored before new
+// ones are dropped.
+const archiveQueue = 256
+
+// storageFromEnv builds the storage that calls' artifacts are archived
+// to, or returns nil if STORAGE_URL isn't set. STORAGE_URL is a directory,
+// s3://bucket[/prefix] or gs://bucket[/prefix]. S3 is reached with
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and, for temporary credentials,
+// AWS_SESSION_TOKEN, in AWS_REGION (default us-east-1), or at
+// AWS_ENDPOINT_URL_S3 for an S3-compatible service; the region must be
+// allowed by the data residency policy. Google Cloud Storage is reached
+// with the service account key in GOOGLE_APPLICATION_CREDENTIALS; under a
+// data residency policy, the bucket's location must be allowed by it.
+func storageFromEnv(residency ResidencyPolicy) (storage.Storage, error) {
+	raw := os.Getenv("STORAGE_URL")
+	if raw == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid STORAGE_URL: %w", err)
+	}
+	prefix := strings.TrimPrefix(u.Path, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	switch u.Scheme {
+	case "s3":
+		s := &storage.S3{
+			Bucket:       u.Host,
+			Prefix:       prefix,
+			Region:       firstNonEmpty(os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1"),
+			Endpoint:     os.Getenv("AWS_ENDPOINT_URL_S3"),
+			AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		}
+		if s.Bucket == "" {
+			return nil, fmt.Errorf("invalid STORAGE_URL: %q names no bucket", raw)
+		}
+		if s.AccessKey == "" || s.SecretKey == "" {
+			return nil, errors.New("invalid STORAGE_URL: S3 needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+		}
+		// Where a custom endpoint keeps its data can't be told from its
+		// region setting
+		if residency != ResidencyAny && s.Endpoint != "" {
+			return nil, fmt.Errorf("S3 endpoint %q can't be checked against data residency policy %q", s.Endpoint, residency)
+		}
+		if !residency.Allows(awsJurisdiction(s.Region)) {
+			return nil, fmt.Errorf("S3 region %q is not allowed by data residency policy %q", s.Region, residency)
+		}
+		return s, nil
+	case "gs":
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid STORAGE_URL: %q names no bucket", raw)
+		}
+		path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+		if path == "" {
+			return nil, errors.New("invalid STORAGE_URL: Google Cloud Storage needs GOOGLE_APPLICATION_CREDENTIALS")
+		}
+		gcs, err := storage.NewGCS(u.Host, prefix, path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GOOGLE_APPLICATION_CREDENTIALS: %w", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), archiveTimeout)
+		defer cancel()
+		if err := checkGCSResidency(ctx, gcs, residency); err != nil {
+			return nil, err
+		}
+		return gcs, nil
+	case "", "file":
+		return &storage.Dir{Root: u.Path}, nil
+	default:
+		return nil, fmt.Errorf("invalid STORAGE_URL: unknown scheme %q (want a directory, s3:// or gs://)", u.Scheme)
+	}
+}
+
+// checkGCSResidency rejects a bucket whose location is outside the data
+// residency policy. The location is looked up, so a bucket that can't be
+// read is rejected too.
+func checkGCSResidency(ctx context.Context, gcs *storage.GCS, residency ResidencyPolicy) error {
+	if residency == ResidencyAny {
+		return nil
+	}
+	location, err := gcs.Location(ctx)
+	if err != nil {
+		return fmt.Errorf("Google Cloud Storage bucket %q can't be checked against data residency policy %q: %w", gcs.Bucket, residency, err)
+	}
+	if !residency.Allows(gcsJurisdiction(location)) {
+		return fmt.Errorf("Google Cloud Storage bucket %q in %s is not allowed by data residency policy %q", gcs.Bucket, location, residency)
+	}
+	return nil
+}
+
+// callArchive stores what each call leaves behind, for compliance: its
+// CDR, transcript and summary as it ends, and its recordings once Twilio
+// has them. Artifacts are stored from a queue of their own, so storage
+// never holds up a call. A nil archive stores nothing.
+type callArchive struct {
+	store  storage.Storage
+	twilio *twilioClient
+	format RecordingFormat
+	ops    chan archiveOp
+	done   chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// archiveOp stores one artifact.
+type archiveOp struct {
+	key string
+	put func(ctx context.Context, key string) error
+	// timeout, if set, replaces archiveTimeout.
+	timeout time.Duration
+}
+
+// newCallArchive archives the calls ended on bus to store, with their
+// recordings in format, or returns nil if there is no store.
+func newCallArchive(store storage.Storage, twilio *twilioClient, bus *EventBus, format RecordingFormat) *callArchive {
+	if store == nil {
+		return nil
+	}
+	a := &callArchive{
+		store:  store,
+		twilio: twilio,
+		format: format,
+		ops:    make(chan archiveOp, archiveQueue),
+		done:   make(chan struct{}),
+	}
+	Subscribe(bus, a.ended)
+	go a.run()
+	return a
+}
+
+func (a *callArchive) run() {
+	defer close(a.done)
+	for op := range a.ops {
+		var err error
+		for attempt := range archiveAttempts {
+			if attempt > 0 {
+				time.Sleep(time.Duration(attempt) * 2 * time.Second)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), cmp.Or(op.timeout, archiveTimeout))
+			err = op.put(ctx, op.key)
+			cancel()
+			if err == nil {
+				break
+			}
+		}
+		if err != nil {
+			slog.Error("failed to archive call artifact", "key", op.key, "error", err)
+		}
+	}
+}
+
+// enqueue queues an artifact to be stored, dropping it if storage has
+// fallen behind or the archive is closed.
+func (a *callArchive) enqueue(op archiveOp) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.closed {
+		slog.Error("call archive closed, artifact not stored", "key", op.key)
+		return
+	}
+	select {
+	case a.ops <- op:
+	default:
+		slog.Error("call archive is behind, artifact not stored", "key", op.key)
+	}
+}
+
+// putData queues data to be stored under key.
+func (a *callArchive) putData(key string, data []byte, contentType string) {
+	a.enqueue(archiveOp{key: key, put: func(ctx context.Context, key string) error {
+		return a.store.Put(ctx, key, data, contentType)
+	}})
+}
+
+// callPrefix is where a call's artifacts are kept. A call whose stream
+// reconnected has a CDR for each stream, told apart by when it started.
+func callPrefix(callSID, sessionID string, started time.Time) string {
+	return firstNonEmpty(callSID, sessionID) + "/" + started.UTC().Format("20060102T150405Z") + "/"
+}
+
+// ended queues an ended session's CDR, transcript, summary and captions.
+func (a *callArchive) ended(e SessionEnded) {
+	prefix := callPrefix(e.CallSID, e.SessionID, e.CDR.StartedAt)
+	if data, err := json.MarshalIndent(e.CDR, "", "  "); err == nil {
+		a.putData(prefix+"cdr.json", data, "application/json")
+	}
+	transcript := e.Transcript
+	if transcript == nil {
+		transcript = []TranscriptLine{}
+	}
+	if data, err := json.MarshalIndent(transcript, "", "  "); err == nil {
+		a.putData(prefix+"transcript.json", data, "application/json")
+	}
+	summary := callSummary{cdr: e.CDR, caller: e.From, called: e.To, transcript: e.Transcript}
+	a.putData(prefix+"summary.txt", []byte(writeSummary(summary, nil, true, false)), "text/plain; charset=utf-8")
+	if len(e.Captions) > 0 {
+		a.putData(prefix+"captions.vtt", WebVTT(e.Captions), "text/vtt; charset=utf-8")
+		a.putData(prefix+"captions.srt", SRT(e.Captions), "application/x-subrip")
+	}
+}
+
+// Recording queues a finished recording of a call, fetched from Twilio
+// and encoded in the archive's format, to be stored under the call as name
+// with the format's extension.
+func (a *callArchive) Recording(callSID, recordingSID, name string) {
+	if a == nil || callSID == "" || recordingSID == "" {
+		return
+	}
+	ext := a.format.Extension()
+	a.enqueue(archiveOp{key: callSID + "/" + name + "." + ext, timeout: archiveRecordingTimeout, put: func(ctx context.Context, key string) error {
+		fetched, err := a.twilio.RecordingAudio(ctx, recordingSID, a.format.source())
+		if err != nil {
+			return err
+		}
+		audio, err := a.format.Encode(ctx, fetched)
+		if err != nil {
+			return err
+		}
+		return a.store.Put(ctx, key, audio, recordingContentType(ext))
+	}})
+}
+
+// Close stores what is queued, then stops.
+func (a *callArchive) Close() {
+	if a == nil {
+		return
+	}
+	a.mu.Lock()
+	if !a.closed {
+		a.closed = true
+		close(a.ops)
+	}
+	a.mu.Unlock()
+	<-a.done
+}
+
+// handleRecordingStatus receives Twilio's notice that a call recording
+// started by CallSession.StartRecording is complete, and archives it.
+func (s *Server) handleRecordingStatus(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	if r.Form.Get("RecordingStatus") == "completed" {
+		sid := r.Form.Get("RecordingSid")
+		s.archive.Recording(r.Form.Get("CallSid"), sid, "recording-"+sid)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
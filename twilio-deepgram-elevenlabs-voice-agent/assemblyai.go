@@ -0,0 +1,275 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/audio"
+	"github.com/agentplexus/omnivoice/stt"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// assemblyAIStreamURL is AssemblyAI's Universal-Streaming endpoint.
+	assemblyAIStreamURL = "wss://streaming.assemblyai.com/v3/ws"
+	// assemblyAIChunk is how much audio each message to AssemblyAI
+	// carries. It takes 50ms to 1s a message, and calls send 20ms frames.
+	assemblyAIChunk = 100 * time.Millisecond
+	// assemblyAICloseTimeout bounds waiting for AssemblyAI to end a
+	// stream once it's been told to.
+	assemblyAICloseTimeout = 5 * time.Second
+)
+
+// assemblyAISTT transcribes with AssemblyAI's Universal-Streaming API over
+// its WebSocket, as a secondary to Deepgram from another vendor. There is
+// no omnivoice provider for AssemblyAI, so only what a call needs,
+// streaming, is implemented.
+type assemblyAISTT struct {
+	apiKey string
+	// url is the streaming endpoint, assemblyAIStreamURL unless a test
+	// sets it.
+	url string
+}
+
+var _ stt.StreamingProvider = (*assemblyAISTT)(nil)
+
+// newAssemblyAISTT creates an AssemblyAI provider with apiKey.
+func newAssemblyAISTT(apiKey string) (*assemblyAISTT, error) {
+	if apiKey == "" {
+		return nil, errors.New("AssemblyAI needs STT_SECONDARY_API_KEY or ASSEMBLYAI_API_KEY")
+	}
+	return &assemblyAISTT{apiKey: apiKey, url: assemblyAIStreamURL}, nil
+}
+
+// Name returns "assemblyai".
+func (p *assemblyAISTT) Name() string { return "assemblyai" }
+
+// errAssemblyAIBatch is returned for transcribing recorded audio, which
+// calls don't need.
+var errAssemblyAIBatch = errors.New("assemblyai: only streaming transcription is supported")
+
+// Transcribe isn't supported.
+func (p *assemblyAISTT) Transcribe(ctx context.Context, audio []byte, config stt.TranscriptionConfig) (*stt.TranscriptionResult, error) {
+	return nil, errAssemblyAIBatch
+}
+
+// TranscribeFile isn't supported.
+func (p *assemblyAISTT) TranscribeFile(ctx context.Context, filePath string, config stt.TranscriptionConfig) (*stt.TranscriptionResult, error) {
+	return nil, errAssemblyAIBatch
+}
+
+// TranscribeURL isn't supported.
+func (p *assemblyAISTT) TranscribeURL(ctx context.Context, url string, config stt.TranscriptionConfig) (*stt.TranscriptionResult, error) {
+	return nil, errAssemblyAIBatch
+}
+
+// TranscribeStream opens a stream with config's model, AssemblyAI's
+// speech_model. AssemblyAI takes mu-law and linear PCM; A-law is decoded
+// to linear PCM on the way.
+func (p *assemblyAISTT) TranscribeStream(ctx context.Context, config stt.TranscriptionConfig) (io.WriteCloser, <-chan stt.StreamEvent, error) {
+	w := &assemblyAIWriter{bytesPerSecond: 2 * config.SampleRate}
+	encoding := "pcm_s16le"
+	switch config.Encoding {
+	case "mulaw":
+		encoding, w.bytesPerSecond = "pcm_mulaw", config.SampleRate
+	case "alaw":
+		w.decode = true
+	case "linear16":
+	default:
+		return nil, nil, fmt.Errorf("assemblyai: %w: %q", stt.ErrUnsupportedFormat, config.Encoding)
+	}
+	if config.SampleRate <= 0 {
+		return nil, nil, fmt.Errorf("assemblyai: %w: no sample rate", stt.ErrInvalidConfig)
+	}
+	query := url.Values{
+		"sample_rate":  {strconv.Itoa(config.SampleRate)},
+		"encoding":     {encoding},
+		"format_turns": {"true"},
+	}
+	if config.Model != "" {
+		query.Set("speech_model", config.Model)
+	}
+	header := http.Header{"Authorization": {p.apiKey}}
+	conn, resp, err := websocket.DefaultDialer.DialContext(ctx, p.url+"?"+query.Encode(), header)
+	if err != nil {
+		if resp != nil {
+			return nil, nil, fmt.Errorf("assemblyai: HTTP %d: %w", resp.StatusCode, err)
+		}
+		return nil, nil, fmt.Errorf("assemblyai: %w", err)
+	}
+	w.conn = conn
+
+	events := make(chan stt.StreamEvent, 100)
+	done := make(chan struct{})
+	go func() {
+		defer close(events)
+		defer close(done)
+		defer conn.Close()
+		readAssemblyAI(ctx, conn, events)
+	}()
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = conn.Close()
+		case <-done:
+		}
+	}()
+	return w, events, nil
+}
+
+// assemblyAIMessage is a message from AssemblyAI: Begin, Turn or
+// Termination.
+type assemblyAIMessage struct {
+	Type            string `json:"type"`
+	Transcript      string `json:"transcript"`
+	EndOfTurn       bool   `json:"end_of_turn"`
+	TurnIsFormatted bool   `json:"turn_is_formatted"`
+	Words           []struct {
+		Text       string  `json:"text"`
+		Start      int64   `json:"start"` // ms
+		End        int64   `json:"end"`
+		Confidence float64 `json:"confidence"`
+	} `json:"words"`
+	Error string `json:"error"`
+}
+
+// readAssemblyAI turns AssemblyAI's messages into stream events until the
+// stream ends. A turn is heard as interim transcripts, then, once
+// AssemblyAI has formatted it, a final one and the end of speech.
+func readAssemblyAI(ctx context.Context, conn *websocket.Conn, events chan<- stt.StreamEvent) {
+	send := func(e stt.StreamEvent) bool {
+		select {
+		case events <- e:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+	speaking := false
+	for {
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() == nil && !websocket.IsCloseError(err, websocket.CloseNormalClosure) && !errors.Is(err, net.ErrClosed) {
+				send(stt.StreamEvent{Type: stt.EventError, Error: fmt.Errorf("assemblyai: %w", err)})
+			}
+			return
+		}
+		var m assemblyAIMessage
+		if err := json.Unmarshal(data, &m); err != nil {
+			send(stt.StreamEvent{Type: stt.EventError, Error: fmt.Errorf("assemblyai: %w", err)})
+			return
+		}
+		switch m.Type {
+		case "Turn":
+			if m.Transcript == "" {
+				continue
+			}
+			if !speaking {
+				speaking = true
+				if !send(stt.StreamEvent{Type: stt.EventSpeechStart, SpeechStarted: true}) {
+					return
+				}
+			}
+			if !m.EndOfTurn || !m.TurnIsFormatted {
+				if !send(stt.StreamEvent{Type: stt.EventTranscript, Transcript: m.Transcript}) {
+					return
+				}
+				continue
+			}
+			speaking = false
+			if !send(stt.StreamEvent{Type: stt.EventTranscript, Transcript: m.Transcript, IsFinal: true, Segment: m.segment()}) ||
+				!send(stt.StreamEvent{Type: stt.EventSpeechEnd, SpeechEnded: true}) {
+				return
+			}
+		case "Termination":
+			return
+		case "Error":
+			send(stt.StreamEvent{Type: stt.EventError, Error: fmt.Errorf("assemblyai: %s", m.Error)})
+			return
+		}
+	}
+}
+
+// segment returns a final turn as a segment, its confidence the mean of
+// its words'.
+func (m *assemblyAIMessage) segment() *stt.Segment {
+	seg := &stt.Segment{Text: m.Transcript}
+	for _, w := range m.Words {
+		seg.Words = append(seg.Words, stt.Word{
+			Text:       w.Text,
+			StartTime:  time.Duration(w.Start) * time.Millisecond,
+			EndTime:    time.Duration(w.End) * time.Millisecond,
+			Confidence: w.Confidence,
+		})
+		seg.Confidence += w.Confidence
+	}
+	if n := len(seg.Words); n > 0 {
+		seg.StartTime, seg.EndTime = seg.Words[0].StartTime, seg.Words[n-1].EndTime
+		seg.Confidence /= float64(n)
+	}
+	return seg
+}
+
+// assemblyAIWriter sends a stream's audio to AssemblyAI in
+// assemblyAIChunk messages.
+type assemblyAIWriter struct {
+	conn           *websocket.Conn
+	bytesPerSecond int
+	// decode is set for A-law, sent as linear PCM.
+	decode bool
+
+	mu     sync.Mutex
+	buf    []byte
+	closed bool
+}
+
+func (w *assemblyAIWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return 0, io.ErrClosedPipe
+	}
+	n := len(p)
+	if w.decode {
+		w.buf = audio.AppendPCM16ToBytes(w.buf, audio.AlawDecode(p))
+	} else {
+		w.buf = append(w.buf, p...)
+	}
+	chunk := int(int64(w.bytesPerSecond) * int64(assemblyAIChunk) / int64(time.Second))
+	for len(w.buf) >= chunk {
+		if err := w.conn.WriteMessage(websocket.BinaryMessage, w.buf[:chunk]); err != nil {
+			return 0, err
+		}
+		w.buf = w.buf[:copy(w.buf, w.buf[chunk:])]
+	}
+	return n, nil
+}
+
+// Close sends the audio left and ends the stream. AssemblyAI sends the
+// turn in progress, then closes its side, which closes the events.
+func (w *assemblyAIWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	// AssemblyAI rejects a message under 50ms, so a shorter tail is
+	// dropped
+	if shortest := w.bytesPerSecond / 20; len(w.buf) >= shortest {
+		_ = w.conn.WriteMessage(websocket.BinaryMessage, w.buf)
+	}
+	w.buf = nil
+	err := w.conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"Terminate"}`))
+	_ = w.conn.SetReadDeadline(time.Now().Add(assemblyAICloseTimeout))
+	return err
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agentplexus/omnivoice/stt"
+	"github.com/gorilla/websocket"
+)
+
+// fakeAssemblyAI is an AssemblyAI streaming endpoint that answers a
+// stream's audio with a turn, recording what it was sent.
+func fakeAssemblyAI(t *testing.T, query chan<- string, sizes chan<- []int) *httptest.Server {
+	upgrader := websocket.Upgrader{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "key" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		query <- r.URL.RawQuery
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		_ = conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"Begin","id":"s1"}`))
+		var received []int
+		for {
+			kind, data, err := conn.ReadMessage()
+			if err != nil {
+				t.Errorf("stream ended without Terminate: %v", err)
+				return
+			}
+			if kind == websocket.BinaryMessage {
+				received = append(received, len(data))
+				continue
+			}
+			if string(data) != `{"type":"Terminate"}` {
+				t.Errorf("message %s, want audio or Terminate", data)
+			}
+			break
+		}
+		sizes <- received
+		for _, m := range []string{
+			`{"type":"Turn","transcript":"hello","end_of_turn":false}`,
+			`{"type":"Turn","transcript":"hello there","end_of_turn":true,"turn_is_formatted":false}`,
+			`{"type":"Turn","transcript":"Hello there.","end_of_turn":true,"turn_is_formatted":true,"words":[` +
+				`{"text":"Hello","start":100,"end":400,"confidence":0.9},{"text":"there.","start":400,"end":800,"confidence":0.7}]}`,
+			`{"type":"Termination","audio_duration_seconds":1}`,
+		} {
+			_ = conn.WriteMessage(websocket.TextMessage, []byte(m))
+		}
+		_ = conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestAssemblyAIStream(t *testing.T) {
+	for _, tt := range []struct {
+		encoding string
+		frame    int // bytes in 20ms
+		query    string
+		sizes    []int
+	}{
+		// 260ms of audio goes as two 100ms messages and a 60ms tail
+		{"mulaw", 160, "encoding=pcm_mulaw&format_turns=true&sample_rate=8000&speech_model=universal-streaming-english", []int{800, 800, 480}},
+		// A-law is sent as linear PCM
+		{"alaw", 160, "encoding=pcm_s16le&format_turns=true&sample_rate=8000&speech_model=universal-streaming-english", []int{1600, 1600, 960}},
+	} {
+		query, sizes := make(chan string, 1), make(chan []int, 1)
+		srv := fakeAssemblyAI(t, query, sizes)
+		p, err := newAssemblyAISTT("key")
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.url = "ws" + strings.TrimPrefix(srv.URL, "http")
+
+		w, events, err := p.TranscribeStream(t.Context(), stt.TranscriptionConfig{Encoding: tt.encoding, SampleRate: 8000, Model: "universal-streaming-english"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for range 13 {
+			if _, err := w.Write(make([]byte, tt.frame)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if got := <-query; got != tt.query {
+			t.Errorf("%s: query %s, want %s", tt.encoding, got, tt.query)
+		}
+		if got := <-sizes; !slices.Equal(got, tt.sizes) {
+			t.Errorf("%s: sent messages of %v bytes, want %v", tt.encoding, got, tt.sizes)
+		}
+
+		var got []stt.StreamEvent
+		timeout := time.After(5 * time.Second)
+	collect:
+		for {
+			select {
+			case e, ok := <-events:
+				if !ok {
+					break collect
+				}
+				got = append(got, e)
+			case <-timeout:
+				t.Fatalf("%s: events not closed after the stream ended", tt.encoding)
+			}
+		}
+		var kinds []string
+		for _, e := range got {
+			kind := string(e.Type)
+			if e.Type == stt.EventTranscript {
+				kind += ":" + e.Transcript
+				if e.IsFinal {
+					kind += " (final)"
+				}
+			}
+			kinds = append(kinds, kind)
+		}
+		want := []string{"speech_start", "transcript:hello", "transcript:hello there", "transcript:Hello there. (final)", "speech_end"}
+		if !slices.Equal(kinds, want) {
+			t.Fatalf("%s: events %q, want %q", tt.encoding, kinds, want)
+		}
+		seg := got[3].Segment
+		if seg == nil || seg.StartTime != 100*time.Millisecond || seg.EndTime != 800*time.Millisecond || seg.Confidence < 0.79 || seg.Confidence > 0.81 || len(seg.Words) != 2 {
+			t.Errorf("%s: final segment %+v", tt.encoding, seg)
+		}
+	}
+}
+
+func TestAssemblyAIRejected(t *testing.T) {
+	srv := fakeAssemblyAI(t, make(chan string, 1), make(chan []int, 1))
+	p, err := newAssemblyAISTT("wrong")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.url = "ws" + strings.TrimPrefix(srv.URL, "http")
+	if _, _, err := p.TranscribeStream(t.Context(), stt.TranscriptionConfig{Encoding: "mulaw", SampleRate: 8000}); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("TranscribeStream with a wrong key = %v, want HTTP 401", err)
+	}
+	if _, _, err := p.TranscribeStream(t.Context(), stt.TranscriptionConfig{Encoding: "opus", SampleRate: 48000}); err == nil {
+		t.Error("TranscribeStream accepted Opus")
+	}
+}
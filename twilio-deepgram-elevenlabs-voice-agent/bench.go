@@ -0,0 +1,368 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/audio"
+)
+
+// BenchConfig controls the audio benchmark, which runs the per-frame audio
+// path of many concurrent calls at real time and measures the garbage it
+// makes.
+type BenchConfig struct {
+	// Calls is how many calls run at once.
+	Calls int
+	// Duration is how long each path is measured.
+	Duration time.Duration
+	// Codec is the wire codec.
+	Codec audio.Codec
+	// PCMRate is the rate of the PCM the TTS provider is asked for, which
+	// is transcoded locally, or 0 for the wire codec itself where the
+	// provider emits it (see ttsFormat).
+	PCMRate int
+}
+
+// defaultBenchConfig returns the configuration used unless overridden by
+// the BENCH_* environment variables.
+func defaultBenchConfig() BenchConfig {
+	return BenchConfig{
+		Calls:    500,
+		Duration: 10 * time.Second,
+		Codec:    audio.CodecMulaw,
+		PCMRate:  16000,
+	}
+}
+
+// benchConfigFromEnv applies environment overrides to the default
+// configuration.
+func benchConfigFromEnv() (BenchConfig, error) {
+	cfg := defaultBenchConfig()
+	if v := os.Getenv("BENCH_CALLS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return cfg, fmt.Errorf("invalid BENCH_CALLS: %q", v)
+		}
+		cfg.Calls = n
+	}
+	if v := os.Getenv("BENCH_DURATION"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return cfg, fmt.Errorf("invalid BENCH_DURATION: %q", v)
+		}
+		cfg.Duration = d
+	}
+	if v := os.Getenv("BENCH_CODEC"); v != "" {
+		codec, err := audio.ParseCodec(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid BENCH_CODEC: %w", err)
+		}
+		cfg.Codec = codec
+	}
+	if v := os.Getenv("BENCH_PCM_RATE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("invalid BENCH_PCM_RATE: %q", v)
+		}
+		cfg.PCMRate = n
+	}
+	return cfg, nil
+}
+
+// benchPath is one way of moving a call's audio: set up returns the work
+// for one 20ms tick of a call, both directions.
+type benchPath struct {
+	name  string
+	setup func(cfg BenchConfig) (tick func(), err error)
+}
+
+// benchResult is what one path cost.
+type benchResult struct {
+	frames        int64
+	allocs, bytes uint64
+	gcs           uint32
+	pause         time.Duration
+	late          int64
+}
+
+// runBench measures the naive and pooled audio paths in turn.
+func runBench(ctx context.Context, cfg BenchConfig) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	format, rate, transcode := ttsFormat(cfg.Codec, cfg.PCMRate)
+	slog.Info("starting audio benchmark", "calls", cfg.Calls, "duration", cfg.Duration, "codec", cfg.Codec, "tts_format", format, "tts_rate", rate, "transcode", transcode, "gomaxprocs", runtime.GOMAXPROCS(0))
+
+	paths := []benchPath{
+		{"naive", naiveBenchCall},
+		{"pooled", pooledBenchCall},
+	}
+	results := make([]benchResult, len(paths))
+	for i, path := range paths {
+		r, err := benchRun(ctx, cfg, path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path.name, err)
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		results[i] = r
+		seconds := cfg.Duration.Seconds()
+		slog.Info("audio benchmark result",
+			"path", path.name,
+			"frames", r.frames,
+			"allocs_per_frame", fmt.Sprintf("%.2f", float64(r.allocs)/float64(r.frames)),
+			"bytes_per_frame", fmt.Sprintf("%.0f", float64(r.bytes)/float64(r.frames)),
+			"alloc_mb_per_sec", fmt.Sprintf("%.1f", float64(r.bytes)/seconds/(1<<20)),
+			"gc_cycles", r.gcs,
+			"gc_per_sec", fmt.Sprintf("%.2f", float64(r.gcs)/seconds),
+			"gc_pause", r.pause.Round(time.Microsecond),
+			"late_ticks", r.late)
+	}
+
+	naive, pooled := results[0], results[1]
+	slog.Info("audio benchmark finished",
+		"alloc_reduction", ratio(naive.bytes, pooled.bytes),
+		"gc_cycles", fmt.Sprintf("%d → %d", naive.gcs, pooled.gcs))
+	return nil
+}
+
+// ratio formats how many times smaller b is than a.
+func ratio(a, b uint64) string {
+	if b == 0 {
+		return "∞"
+	}
+	return fmt.Sprintf("%.1fx", float64(a)/float64(b))
+}
+
+// benchRun runs cfg.Calls calls on path for cfg.Duration, each ticking
+// every frame interval like a live call.
+func benchRun(ctx context.Context, cfg BenchConfig, path benchPath) (benchResult, error) {
+	ticks := make([]func(), cfg.Calls)
+	for i := range ticks {
+		tick, err := path.setup(cfg)
+		if err != nil {
+			return benchResult{}, err
+		}
+		ticks[i] = tick
+	}
+
+	runtime.GC()
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Duration)
+	defer cancel()
+	var (
+		wg           sync.WaitGroup
+		frames, late atomic.Int64
+	)
+	for _, tick := range ticks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(outboundFrameInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case at := <-ticker.C:
+					tick()
+					frames.Add(2)
+					// A tick that overruns the next one means the process
+					// couldn't keep up with this many calls
+					if time.Since(at) > outboundFrameInterval {
+						late.Add(1)
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	runtime.ReadMemStats(&after)
+	return benchResult{
+		frames: frames.Load(),
+		allocs: after.Mallocs - before.Mallocs,
+		bytes:  after.TotalAlloc - before.TotalAlloc,
+		gcs:    after.NumGC - before.NumGC,
+		pause:  time.Duration(after.PauseTotalNs - before.PauseTotalNs),
+		late:   late.Load(),
+	}, nil
+}
+
+// benchAudio returns 20ms of a tone in the TTS provider's output format,
+// and the same on the wire.
+func benchAudio(cfg BenchConfig) (tts, wire []byte, err error) {
+	format, rate, _ := ttsFormat(cfg.Codec, cfg.PCMRate)
+	pcm := make([]int16, rate/50)
+	for i := range pcm {
+		pcm[i] = int16(8000 * math.Sin(2*math.Pi*440*float64(i)/float64(rate)))
+	}
+	switch format {
+	case "ulaw":
+		tts = audio.MulawEncode(pcm)
+	case "alaw":
+		tts = audio.AlawEncode(pcm)
+	default:
+		tts = audio.PCM16ToBytes(pcm)
+	}
+	wirePCM, err := audio.Resample(pcm, rate, cfg.Codec.SampleRate(), audio.QualityLinear)
+	if err != nil {
+		return nil, nil, err
+	}
+	return tts, cfg.Codec.NewEncoder().Encode(wirePCM), nil
+}
+
+// naiveBenchCall moves a call's audio allocating at every step, as a
+// straightforward pipeline would: a new slice for each conversion, each
+// outbound frame and each decoded inbound frame.
+func naiveBenchCall(cfg BenchConfig) (func(), error) {
+	chunk, frame, err := benchAudio(cfg)
+	if err != nil {
+		return nil, err
+	}
+	_, rate, transcode := ttsFormat(cfg.Codec, cfg.PCMRate)
+	encoding, _, _ := sttFormat(cfg.Codec)
+	var resampler *audio.Resampler
+	if transcode && rate != cfg.Codec.SampleRate() {
+		if resampler, err = audio.NewResampler(rate, cfg.Codec.SampleRate(), audio.QualitySinc); err != nil {
+			return nil, err
+		}
+	}
+	encoder, decoder := cfg.Codec.NewEncoder(), cfg.Codec.NewDecoder()
+	tapDecoder := cfg.Codec.NewDecoder()
+	// Both paths feed the echo guard, so they do the same work on the
+	// decoded audio
+	guard := newEchoGuard(defaultEchoGuardConfig(), cfg.Codec)
+	queue := make(chan []byte, outboundBufferFrames)
+	var partial []byte
+
+	return func() {
+		// Outbound: transcode, frame, queue, send
+		wire := chunk
+		if transcode {
+			pcm := audio.PCM16FromBytes(chunk)
+			if resampler != nil {
+				pcm = resampler.Process(pcm)
+			}
+			wire = encoder.Encode(pcm)
+		}
+		partial = append(partial, wire...)
+		for len(partial) >= outboundFrameSize {
+			f := make([]byte, outboundFrameSize)
+			copy(f, partial)
+			partial = partial[outboundFrameSize:]
+			queue <- f
+		}
+		for len(queue) > 0 {
+			f := <-queue
+			guard.played(tapDecoder.Decode(f))
+			_, _ = io.Discard.Write(f)
+		}
+
+		// Inbound: decode for STT and gate echo
+		var pcm []int16
+		switch encoding {
+		case "mulaw":
+			pcm = audio.MulawDecode(frame)
+		case "alaw":
+			pcm = audio.AlawDecode(frame)
+		default:
+			pcm = audio.PCM16FromBytes(audio.PCM16ToBytes(decoder.Decode(frame)))
+		}
+		guard.allow(pcm)
+	}, nil
+}
+
+// benchTranscodeWorkers transcode for the pooled path's calls, as the
+// server's do by default.
+var benchTranscodeWorkers = sync.OnceValue(func() *transcodeWorkers {
+	return newTranscodeWorkers(defaultConcurrencyConfig().TranscodeWorkers)
+})
+
+// pooledBenchCall moves a call's audio through the session's own
+// components: the transcoder and its shared workers, the pacer with its
+// frame pool, the echo guard's tap and gate, and the decoder for STT.
+func pooledBenchCall(cfg BenchConfig) (func(), error) {
+	chunk, frame, err := benchAudio(cfg)
+	if err != nil {
+		return nil, err
+	}
+	_, rate, transcode := ttsFormat(cfg.Codec, cfg.PCMRate)
+	encoding, _, decode := sttFormat(cfg.Codec)
+	guard := newEchoGuard(defaultEchoGuardConfig(), cfg.Codec)
+
+	tap := &echoTapWriter{dst: nopWriteCloser{io.Discard}, guard: guard, decoder: cfg.Codec.NewDecoder()}
+	p := &pacer{
+		dst:    tap,
+		frames: make(chan *audio.Frame, outboundBufferFrames),
+		policy: OutboundBlock,
+		done:   make(chan struct{}),
+	}
+	var out io.Writer = p
+	if transcode {
+		w := &pcmEncodingWriter{dst: p, encoder: cfg.Codec.NewEncoder(), workers: benchTranscodeWorkers(), done: make(chan struct{}, 1)}
+		if rate != cfg.Codec.SampleRate() {
+			if w.resampler, err = audio.NewResampler(rate, cfg.Codec.SampleRate(), audio.QualitySinc); err != nil {
+				return nil, err
+			}
+		}
+		out = w
+	}
+
+	// Decoded for STT first, then gated, as in a session
+	src := &repeatReader{frame: frame}
+	var in io.Reader = src
+	if decode {
+		in = &pcmDecodingReader{src: in, decoder: cfg.Codec.NewDecoder(), workers: benchTranscodeWorkers(), done: make(chan struct{}, 1)}
+	}
+	in = &echoGateReader{src: in, guard: guard, encoding: encoding}
+	// Room for a frame decoded to 16-bit PCM, so it is read in one go
+	buf := make([]byte, 4*len(frame))
+
+	return func() {
+		// Outbound: the pacer's run loop, without its clock
+		_, _ = out.Write(chunk)
+		for len(p.frames) > 0 {
+			p.send(<-p.frames)
+		}
+
+		// Inbound: one frame, read whole
+		src.ready = true
+		_, _ = in.Read(buf)
+	}, nil
+}
+
+// repeatReader yields the same frame each time it is made ready.
+type repeatReader struct {
+	frame []byte
+	ready bool
+}
+
+func (r *repeatReader) Read(p []byte) (int, error) {
+	if !r.ready {
+		return 0, io.EOF
+	}
+	r.ready = false
+	return copy(p, r.frame), nil
+}
+
+// nopWriteCloser adds a no-op Close to a writer.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,326 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/agent"
+	"github.com/agentplexus/omnivoice-examples/kit/calendar"
+	"github.com/agentplexus/omnivoice-examples/kit/llm"
+)
+
+// Tools the agent books appointments with.
+const (
+	toolCheckAvailability = "check_availability"
+	toolBookAppointment   = "book_appointment"
+)
+
+const (
+	// bookingHorizon is how far ahead appointments may be booked.
+	bookingHorizon = 90 * 24 * time.Hour
+	// bookingSearchDays is how many days check_availability looks ahead
+	// for the next free day when the one asked about is full.
+	bookingSearchDays = 14
+	// bookingMaxSlots caps the times check_availability lists.
+	bookingMaxSlots = 12
+	// bookingTimeout bounds each calendar request.
+	bookingTimeout = 5 * time.Second
+)
+
+// Booking lets the agent book appointments on a calendar: it checks which
+// times are free within opening hours and books the one the caller
+// chooses, reading it back to them.
+type Booking struct {
+	Calendar calendar.Calendar
+	// Hours are when appointments may be booked, in the business's time
+	// zone.
+	Hours calendar.Hours
+	// Length is how long each appointment is.
+	Length time.Duration
+	// Title is the summary of booked events.
+	Title string
+
+	mu sync.Mutex
+	// calls holds the caller and bookings of each session.
+	calls map[string]*bookingCall
+}
+
+type bookingCall struct {
+	caller string
+	booked []string
+}
+
+// bookingFromEnv builds appointment booking. BOOKING_CALENDAR is a Google
+// Calendar ID, reached with the service account key in
+// GOOGLE_APPLICATION_CREDENTIALS, or "memory" for a calendar kept in
+// memory. BOOKING_TIMEZONE (default UTC) and BOOKING_HOURS (default
+// "Mon-Fri 09:00-17:00") are the opening hours, BOOKING_LENGTH (default
+// 30m) the length of an appointment and BOOKING_TITLE (default
+// "Appointment") its title. It returns nil if BOOKING_CALENDAR isn't set.
+func bookingFromEnv() (*Booking, error) {
+	id := os.Getenv("BOOKING_CALENDAR")
+	if id == "" {
+		return nil, nil
+	}
+	b := &Booking{
+		Length: 30 * time.Minute,
+		Title:  firstNonEmpty(os.Getenv("BOOKING_TITLE"), "Appointment"),
+		calls:  make(map[string]*bookingCall),
+	}
+	if id == "memory" {
+		b.Calendar = &calendar.Memory{}
+	} else {
+		path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+		if path == "" {
+			return nil, errors.New("invalid BOOKING_CALENDAR: a Google Calendar needs GOOGLE_APPLICATION_CREDENTIALS")
+		}
+		google, err := calendar.NewGoogle(id, path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GOOGLE_APPLICATION_CREDENTIALS: %w", err)
+		}
+		b.Calendar = google
+	}
+
+	loc, err := time.LoadLocation(firstNonEmpty(os.Getenv("BOOKING_TIMEZONE"), "UTC"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid BOOKING_TIMEZONE: %w", err)
+	}
+	b.Hours, err = calendar.ParseHours(firstNonEmpty(os.Getenv("BOOKING_HOURS"), "Mon-Fri 09:00-17:00"), loc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid BOOKING_HOURS: %w", err)
+	}
+	if v := os.Getenv("BOOKING_LENGTH"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 5*time.Minute {
+			return nil, fmt.Errorf("invalid BOOKING_LENGTH: %q (want at least 5m)", v)
+		}
+		b.Length = d
+	}
+	return b, nil
+}
+
+// Tools returns the check_availability and book_appointment tools.
+func (b *Booking) Tools() []agent.Tool {
+	timezone := `"timezone":{"type":"string","description":"The caller's IANA time zone, e.g. America/Chicago, if they said they are in a different one. Defaults to ` + b.Hours.Location.String() + `."}`
+	return []agent.Tool{
+		{
+			Tool: llm.Tool{
+				Name:        toolCheckAvailability,
+				Description: "List the free appointment times on a date. If the date is full, the next day with free times is given instead.",
+				Parameters:  json.RawMessage(`{"type":"object","properties":{"date":{"type":"string","description":"The date, as YYYY-MM-DD."},` + timezone + `},"required":["date"]}`),
+			},
+			Call:       b.checkAvailability,
+			Idempotent: true,
+		},
+		{
+			Tool: llm.Tool{
+				Name:        toolBookAppointment,
+				Description: "Book an appointment at a free time the caller chose, once they have confirmed the day and time. Then read the booked time from the result back to the caller.",
+				Parameters: json.RawMessage(`{"type":"object","properties":{` +
+					`"start":{"type":"string","description":"The start time, as YYYY-MM-DDTHH:MM."},` + timezone + `,` +
+					`"name":{"type":"string","description":"The caller's name."},` +
+					`"notes":{"type":"string","description":"What the appointment is for, if the caller said."}},` +
+					`"required":["start","name"]}`),
+			},
+			Call: b.book,
+		},
+	}
+}
+
+// location returns the zone a tool call's times are in: the caller's if
+// they gave one, otherwise the business's.
+func (b *Booking) location(name string) (*time.Location, error) {
+	if name == "" {
+		return b.Hours.Location, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("unknown time zone %q", name)
+	}
+	return loc, nil
+}
+
+// checkAvailability lists the free times on a date.
+func (b *Booking) checkAvailability(ctx context.Context, args json.RawMessage) (string, error) {
+	var params struct {
+		Date     string `json:"date"`
+		Timezone string `json:"timezone"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return "", err
+	}
+	loc, err := b.location(params.Timezone)
+	if err != nil {
+		return "", err
+	}
+	day, err := time.ParseInLocation(time.DateOnly, params.Date, loc)
+	if err != nil {
+		return "", fmt.Errorf("invalid date %q: want YYYY-MM-DD", params.Date)
+	}
+	now := time.Now()
+	from := day
+	if from.Before(now) {
+		from = now
+	}
+	to := day.AddDate(0, 0, bookingSearchDays)
+	if horizon := now.Add(bookingHorizon); to.After(horizon) {
+		to = horizon
+	}
+	if !from.Before(to) || !day.AddDate(0, 0, 1).After(now) {
+		return "That date can't be booked: appointments can be made from today up to 90 days ahead.", nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, bookingTimeout)
+	defer cancel()
+	busy, err := b.Calendar.Busy(ctx, from, to)
+	if err != nil {
+		return "", err
+	}
+	free := calendar.FreeSlots(busy, b.Hours, from, to, b.Length)
+	if len(free) == 0 {
+		return fmt.Sprintf("Nothing is free in the %d days from %s.", bookingSearchDays, day.Format("Monday, January 2")), nil
+	}
+
+	// The times on the first day with any, in the caller's zone
+	first := free[0].Start.In(loc)
+	var times []string
+	for _, slot := range free {
+		start := slot.Start.In(loc)
+		if start.YearDay() != first.YearDay() || start.Year() != first.Year() {
+			break
+		}
+		times = append(times, start.Format("3:04 PM"))
+	}
+	var result strings.Builder
+	if first.Format(time.DateOnly) != params.Date {
+		fmt.Fprintf(&result, "Nothing is free on %s. ", day.Format("Monday, January 2"))
+	}
+	fmt.Fprintf(&result, "Free %d-minute appointments on %s (%s time): ", int(b.Length.Minutes()), first.Format("Monday, January 2"), loc)
+	if len(times) > bookingMaxSlots {
+		fmt.Fprintf(&result, "%s, and %d later times.", strings.Join(times[:bookingMaxSlots], ", "), len(times)-bookingMaxSlots)
+	} else {
+		result.WriteString(strings.Join(times, ", ") + ".")
+	}
+	return result.String(), nil
+}
+
+// book books an appointment for the caller of the session it is called
+// in.
+func (b *Booking) book(ctx context.Context, args json.RawMessage) (string, error) {
+	var params struct {
+		Start    string `json:"start"`
+		Timezone string `json:"timezone"`
+		Name     string `json:"name"`
+		Notes    string `json:"notes"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return "", err
+	}
+	loc, err := b.location(params.Timezone)
+	if err != nil {
+		return "", err
+	}
+	start, err := time.ParseInLocation("2006-01-02T15:04", params.Start, loc)
+	if err != nil {
+		return "", fmt.Errorf("invalid start %q: want YYYY-MM-DDTHH:MM", params.Start)
+	}
+	slot := calendar.Slot{Start: start, End: start.Add(b.Length)}
+	if start.Before(time.Now()) || start.After(time.Now().Add(bookingHorizon)) {
+		return "That time can't be booked: appointments can be made from now up to 90 days ahead.", nil
+	}
+	if !b.Hours.Contains(slot) {
+		return "That time is outside opening hours. Check availability and offer a free time.", nil
+	}
+
+	sessionID, _ := agent.SessionID(ctx)
+	b.mu.Lock()
+	call := b.calls[sessionID]
+	b.mu.Unlock()
+	if call == nil {
+		return "", errors.New("booking is not available on this call")
+	}
+	description := "Booked by phone."
+	if call.caller != "" {
+		description += " Caller: " + call.caller + "."
+	}
+	description += " Name: " + strings.TrimSpace(params.Name) + "."
+	if notes := strings.TrimSpace(params.Notes); notes != "" {
+		description += " Notes: " + notes
+	}
+	event := calendar.Event{
+		Summary:     fmt.Sprintf("%s: %s", b.Title, strings.TrimSpace(params.Name)),
+		Description: description,
+		// Start in the business's zone, so the event shows in it
+		Start: slot.Start.In(b.Hours.Location),
+		End:   slot.End.In(b.Hours.Location),
+	}
+	// An ID from the tool call's idempotency key keeps a retried request
+	// from booking twice
+	if key, ok := agent.IdempotencyKey(ctx); ok {
+		sum := sha256.Sum256([]byte(key))
+		event.ID = hex.EncodeToString(sum[:16])
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, bookingTimeout)
+	defer cancel()
+	id, err := calendar.Book(ctx, b.Calendar, event)
+	if errors.Is(err, calendar.ErrSlotTaken) {
+		return "That time was just taken. Check availability again and offer another time.", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	b.mu.Lock()
+	call.booked = append(call.booked, id)
+	b.mu.Unlock()
+	local := start.In(loc)
+	return fmt.Sprintf("Booked for %s at %s (%s time), for %d minutes. Read the day and time back to the caller.",
+		local.Format("Monday, January 2"), local.Format("3:04 PM"), loc, int(b.Length.Minutes())), nil
+}
+
+// Brief tells the agent today's date and the opening hours, so it can
+// make sense of "next Tuesday" and offer sensible times.
+func (b *Booking) Brief(now time.Time) string {
+	local := now.In(b.Hours.Location)
+	days := make([]string, len(b.Hours.Days))
+	for i, d := range b.Hours.Days {
+		days[i] = d.String()
+	}
+	opens, closes := time.Time{}.Add(b.Hours.Open), time.Time{}.Add(b.Hours.Close)
+	return fmt.Sprintf("You can book %d-minute appointments. It is now %s at %s (%s time). Appointments are taken on %s, from %s to %s. Check availability before offering times, and confirm the day and time with the caller before booking.",
+		int(b.Length.Minutes()), local.Format("Monday, January 2, 2006"), local.Format("3:04 PM"), b.Hours.Location,
+		strings.Join(days, ", "), opens.Format("3:04 PM"), closes.Format("3:04 PM"))
+}
+
+// Begin lets the agent book appointments for the caller of a session.
+func (b *Booking) Begin(sessionID, caller string) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.calls[sessionID] = &bookingCall{caller: caller}
+}
+
+// End returns the IDs of the events booked during a session.
+func (b *Booking) End(sessionID string) []string {
+	if b == nil {
+		return nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	call := b.calls[sessionID]
+	delete(b.calls, sessionID)
+	if call == nil {
+		return nil
+	}
+	return call.booked
+}
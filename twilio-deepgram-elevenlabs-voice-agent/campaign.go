@@ -0,0 +1,693 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/dnc"
+	"github.com/agentplexus/omnivoice-examples/kit/pacing"
+	"github.com/agentplexus/omnivoice-examples/kit/phone"
+	"github.com/agentplexus/omnivoice-examples/kit/twilioauth"
+)
+
+// Stream parameters naming the campaign and contact of an outbound call.
+const (
+	paramCampaign = "campaign"
+	paramContact  = "contactId"
+)
+
+// Outcomes of a campaign's call attempts, as recorded in its results file.
+// Answered, failed and suppressed contacts are done; the others are
+// retried until they run out of attempts.
+const (
+	outcomeAnswered   = "answered"
+	outcomeNoAnswer   = "no_answer"
+	outcomeBusy       = "busy"
+	outcomeMachine    = "machine"
+	outcomeAbandoned  = "abandoned"
+	outcomeError      = "error"
+	outcomeFailed     = "failed"
+	outcomeSuppressed = "suppressed"
+	// outcomeDeferred is a call the do-not-call gate put off, outside
+	// calling hours or because its list couldn't be checked. It doesn't
+	// count as an attempt.
+	outcomeDeferred = "deferred"
+)
+
+// CampaignConfig is an outbound campaign: who to call, how fast, and what
+// to do with calls that don't get through.
+type CampaignConfig struct {
+	// File is a CSV of contacts; empty runs no campaign.
+	File string
+	// Name identifies the campaign in logs, call records and results.
+	Name string
+	// From is the number calls are placed from.
+	From string
+	// Results is the CSV file each attempt's outcome is appended to.
+	Results string
+	// Rate caps how many calls are placed per second.
+	Rate float64
+	// Pacing decides how many calls may be in progress at once.
+	Pacing pacing.Config
+	// MaxAttempts is how many times a contact is called before giving up,
+	// and RetryDelay how long to wait between attempts.
+	MaxAttempts int
+	RetryDelay  time.Duration
+	// RingTimeout is how long a call rings before it counts as unanswered.
+	RingTimeout time.Duration
+	// MachineDetection has Twilio tell people from answering machines;
+	// calls a machine answers are hung up and retried.
+	MachineDetection bool
+	// AbandonMessage is said to a person who answers when no agent is
+	// free, before hanging up.
+	AbandonMessage string
+}
+
+// defaultCampaignConfig returns the configuration used unless overridden
+// by CAMPAIGN_NAME, CAMPAIGN_RESULTS, CAMPAIGN_RATE, CAMPAIGN_PACING,
+// CAMPAIGN_AGENTS, CAMPAIGN_MAX_CONCURRENT, CAMPAIGN_MAX_ABANDON_RATE,
+// CAMPAIGN_MAX_ATTEMPTS, CAMPAIGN_RETRY_DELAY, CAMPAIGN_RING_TIMEOUT,
+// CAMPAIGN_MACHINE_DETECTION and CAMPAIGN_ABANDON_MESSAGE.
+func defaultCampaignConfig() CampaignConfig {
+	return CampaignConfig{
+		Rate: 1,
+		Pacing: pacing.Config{
+			Mode:           pacing.Progressive,
+			Agents:         5,
+			MaxAbandonRate: 0.03,
+		},
+		MaxAttempts:      3,
+		RetryDelay:       time.Hour,
+		RingTimeout:      30 * time.Second,
+		MachineDetection: true,
+		AbandonMessage:   "Sorry, we called at a busy moment. We'll try you again later. Goodbye.",
+	}
+}
+
+// campaignConfigFromEnv applies environment overrides to the defaults.
+// CAMPAIGN_FILE starts a campaign, and then CAMPAIGN_FROM is required.
+func campaignConfigFromEnv(plan phone.DialPlan) (CampaignConfig, error) {
+	c := defaultCampaignConfig()
+	c.File = os.Getenv("CAMPAIGN_FILE")
+	if c.File == "" {
+		return c, nil
+	}
+	c.Name = firstNonEmpty(os.Getenv("CAMPAIGN_NAME"), strings.TrimSuffix(filepath.Base(c.File), filepath.Ext(c.File)))
+	c.Results = firstNonEmpty(os.Getenv("CAMPAIGN_RESULTS"), c.Name+"-results.csv")
+
+	from, err := plan.Parse(os.Getenv("CAMPAIGN_FROM"))
+	if err != nil {
+		return c, fmt.Errorf("invalid CAMPAIGN_FROM: %w", err)
+	}
+	c.From = from.Address()
+
+	if v := os.Getenv("CAMPAIGN_RATE"); v != "" {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil || rate <= 0 {
+			return c, fmt.Errorf("invalid CAMPAIGN_RATE: %q (want calls per second)", v)
+		}
+		c.Rate = rate
+	}
+	if v := os.Getenv("CAMPAIGN_PACING"); v != "" {
+		mode, err := pacing.ParseMode(v)
+		if err != nil {
+			return c, fmt.Errorf("invalid CAMPAIGN_PACING: %w", err)
+		}
+		c.Pacing.Mode = mode
+	}
+	for key, n := range map[string]*int{
+		"CAMPAIGN_AGENTS":         &c.Pacing.Agents,
+		"CAMPAIGN_MAX_CONCURRENT": &c.Pacing.MaxConcurrent,
+		"CAMPAIGN_MAX_ATTEMPTS":   &c.MaxAttempts,
+	} {
+		if v := os.Getenv(key); v != "" {
+			i, err := strconv.Atoi(v)
+			if err != nil || i < 0 {
+				return c, fmt.Errorf("invalid %s: %q", key, v)
+			}
+			*n = i
+		}
+	}
+	if v := os.Getenv("CAMPAIGN_MAX_ABANDON_RATE"); v != "" {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return c, fmt.Errorf("invalid CAMPAIGN_MAX_ABANDON_RATE: %q", v)
+		}
+		c.Pacing.MaxAbandonRate = rate
+	}
+	for key, d := range map[string]*time.Duration{
+		"CAMPAIGN_RETRY_DELAY":  &c.RetryDelay,
+		"CAMPAIGN_RING_TIMEOUT": &c.RingTimeout,
+	} {
+		if v := os.Getenv(key); v != "" {
+			parsed, err := time.ParseDuration(v)
+			if err != nil || parsed <= 0 {
+				return c, fmt.Errorf("invalid %s: %q", key, v)
+			}
+			*d = parsed
+		}
+	}
+	if v := os.Getenv("CAMPAIGN_MACHINE_DETECTION"); v != "" {
+		on, err := strconv.ParseBool(v)
+		if err != nil {
+			return c, fmt.Errorf("invalid CAMPAIGN_MACHINE_DETECTION: %q", v)
+		}
+		c.MachineDetection = on
+	}
+	if v := os.Getenv("CAMPAIGN_ABANDON_MESSAGE"); v != "" {
+		c.AbandonMessage = v
+	}
+	if c.MaxAttempts < 1 {
+		return c, errors.New("invalid CAMPAIGN_MAX_ATTEMPTS: want at least 1")
+	}
+	return c, nil
+}
+
+// Contact is one row of a campaign's contact list.
+type Contact struct {
+	// ID is the id column, or the contact's line in the file.
+	ID     string
+	Number string
+	// Context holds the row's other columns, for the agent.
+	Context map[string]string
+}
+
+// loadContacts reads contacts from a CSV file with a header row. The
+// number column is required and read with plan; an id column is optional.
+// Every other column is passed to the call as a stream parameter and to
+// the agent as context, so none may be named like the server's own
+// parameters.
+func loadContacts(path string, plan phone.DialPlan) ([]Contact, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.TrimLeadingSpace = true
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%s: reading header: %w", path, err)
+	}
+	for i := range header {
+		header[i] = strings.TrimSpace(header[i])
+	}
+	numberCol := slices.Index(header, "number")
+	if numberCol < 0 {
+		return nil, fmt.Errorf("%s: no number column", path)
+	}
+	idCol := slices.Index(header, "id")
+	for i, name := range header {
+		if i == numberCol || i == idCol {
+			continue
+		}
+		if err := validateStreamParameters(map[string]string{name: ""}); err != nil {
+			return nil, fmt.Errorf("%s: column %w", path, err)
+		}
+		if name == paramCampaign || name == paramContact {
+			return nil, fmt.Errorf("%s: column name %q is reserved", path, name)
+		}
+	}
+
+	var contacts []Contact
+	seen := make(map[string]bool)
+	for line := 2; ; line++ {
+		row, err := r.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		number, err := plan.Parse(row[numberCol])
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+		c := Contact{ID: strconv.Itoa(line), Number: number.Address(), Context: make(map[string]string)}
+		if idCol >= 0 && strings.TrimSpace(row[idCol]) != "" {
+			c.ID = strings.TrimSpace(row[idCol])
+		}
+		if seen[c.ID] {
+			return nil, fmt.Errorf("%s:%d: duplicate id %q", path, line, c.ID)
+		}
+		seen[c.ID] = true
+		for i, value := range row {
+			if i != numberCol && i != idCol && strings.TrimSpace(value) != "" {
+				c.Context[header[i]] = strings.TrimSpace(value)
+			}
+		}
+		contacts = append(contacts, c)
+	}
+	if len(contacts) == 0 {
+		return nil, fmt.Errorf("%s: no contacts", path)
+	}
+	return contacts, nil
+}
+
+// Campaign places a campaign's calls, paced by kit/pacing, and records how
+// each attempt went. Calls a person answers are connected to the agent
+// like inbound calls.
+type Campaign struct {
+	cfg    CampaignConfig
+	pacer  *pacing.Pacer
+	twilio *twilioClient
+	// dial, if set, is checked before every call.
+	dial *dnc.Gate
+	// baseURL is where Twilio reaches this server, e.g.
+	// https://voice.example.com.
+	baseURL string
+
+	mu       sync.Mutex
+	contacts []*campaignContact
+	byID     map[string]*campaignContact
+	results  *csv.Writer
+	closer   io.Closer
+	finished chan struct{}
+	done     bool
+}
+
+// campaignContact is a contact and how calling them is going.
+type campaignContact struct {
+	Contact
+	attempts int
+	// next is when the contact may be called again.
+	next    time.Time
+	outcome string
+	final   bool
+	// call is the attempt in progress, if any.
+	call *campaignCall
+}
+
+// campaignCall is an attempt in progress.
+type campaignCall struct {
+	sid     string
+	attempt *pacing.Attempt
+	// answeredBy is Twilio's answering machine detection result.
+	answeredBy string
+	// connected is set once the call was put through to the agent, and
+	// abandoned if a person answered with no agent free.
+	connected bool
+	abandoned bool
+}
+
+// NewCampaign loads cfg's contacts and opens its results file.
+func NewCampaign(cfg CampaignConfig, plan phone.DialPlan, twilio *twilioClient, dial *dnc.Gate, baseURL string) (*Campaign, error) {
+	contacts, err := loadContacts(cfg.File, plan)
+	if err != nil {
+		return nil, err
+	}
+	pacer, err := pacing.New(cfg.Pacing)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(cfg.Results, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o640)
+	if err != nil {
+		return nil, err
+	}
+	c := &Campaign{
+		cfg:      cfg,
+		pacer:    pacer,
+		twilio:   twilio,
+		dial:     dial,
+		baseURL:  baseURL,
+		byID:     make(map[string]*campaignContact),
+		results:  csv.NewWriter(f),
+		closer:   f,
+		finished: make(chan struct{}),
+	}
+	if info, err := f.Stat(); err == nil && info.Size() == 0 {
+		_ = c.results.Write([]string{"campaign", "contact_id", "number", "attempt", "call_sid", "outcome", "duration_seconds", "final", "at"})
+		c.results.Flush()
+	}
+	for _, contact := range contacts {
+		cc := &campaignContact{Contact: contact}
+		c.contacts = append(c.contacts, cc)
+		c.byID[contact.ID] = cc
+	}
+	return c, nil
+}
+
+// Close closes the results file.
+func (c *Campaign) Close() error {
+	return c.closer.Close()
+}
+
+// Run places the campaign's calls until every contact is done, ctx is
+// cancelled, or stop reports true (e.g. the server is draining). Calls in
+// progress are still recorded as they end.
+func (c *Campaign) Run(ctx context.Context, stop func() bool) {
+	slog.Info("campaign started", "campaign", c.cfg.Name, "contacts", len(c.contacts), "pacing", c.cfg.Pacing.Mode)
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / c.cfg.Rate))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.finished:
+			slog.Info("campaign finished", "campaign", c.cfg.Name, "outcomes", c.outcomes())
+			return
+		case <-ticker.C:
+		}
+		if stop() {
+			slog.Info("campaign stopped", "campaign", c.cfg.Name)
+			return
+		}
+		if c.pacer.Ready() == 0 {
+			continue
+		}
+		contact := c.due(time.Now())
+		if contact == nil {
+			continue
+		}
+		attempt, ok := c.pacer.Dial()
+		if !ok {
+			c.mu.Lock()
+			contact.call = nil
+			c.mu.Unlock()
+			continue
+		}
+		c.mu.Lock()
+		contact.call.attempt = attempt
+		c.mu.Unlock()
+		go c.place(ctx, contact)
+	}
+}
+
+// due returns the first contact that may be called at now, holding it for
+// a call, or nil if none may be.
+func (c *Campaign) due(now time.Time) *campaignContact {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, contact := range c.contacts {
+		if !contact.final && contact.call == nil && !contact.next.After(now) {
+			contact.call = &campaignCall{}
+			return contact
+		}
+	}
+	return nil
+}
+
+// place calls contact, after checking the do-not-call gate.
+func (c *Campaign) place(ctx context.Context, contact *campaignContact) {
+	c.mu.Lock()
+	call := contact.call
+	c.mu.Unlock()
+
+	if c.dial != nil {
+		if err := c.dial.Check(ctx, contact.Number, "campaign", contact.ID); err != nil {
+			call.attempt.Failed()
+			outcome := outcomeDeferred
+			if errors.Is(err, dnc.ErrListed) {
+				outcome = outcomeSuppressed
+			}
+			slog.Info("campaign call suppressed", "campaign", c.cfg.Name, "contact", contact.ID, "reason", err)
+			c.record(contact, outcome, 0)
+			return
+		}
+	}
+
+	c.mu.Lock()
+	contact.attempts++
+	attempts := contact.attempts
+	c.mu.Unlock()
+	query := "?contact=" + url.QueryEscape(contact.ID)
+	sid, err := c.twilio.CreateCall(ctx, outboundCall{
+		To:               contact.Number,
+		From:             c.cfg.From,
+		URL:              c.baseURL + "/voice/outbound" + query,
+		StatusCallback:   c.baseURL + "/voice/outbound/status" + query,
+		Timeout:          c.cfg.RingTimeout,
+		MachineDetection: c.cfg.MachineDetection,
+	})
+	if err != nil {
+		slog.Warn("campaign call failed", "campaign", c.cfg.Name, "contact", contact.ID, "error", err)
+		call.attempt.Failed()
+		c.record(contact, outcomeError, 0)
+		return
+	}
+	c.mu.Lock()
+	if call.sid == "" {
+		call.sid = sid
+	}
+	c.mu.Unlock()
+	slog.Info("campaign call placed", "campaign", c.cfg.Name, "contact", contact.ID, "call_sid", sid, "attempt", attempts)
+}
+
+// answerAction is what to do with an outbound call that was picked up.
+type answerAction int
+
+const (
+	answerHangup answerAction = iota
+	answerAbandon
+	answerConnect
+)
+
+// answered decides what happens to the call to contactID now that it was
+// picked up: hang up on answering machines (and calls the campaign doesn't
+// know), connect people to the agent if one is free, and otherwise
+// abandon the call.
+func (c *Campaign) answered(contactID, callSID, answeredBy string) (Contact, answerAction) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	contact, ok := c.byID[contactID]
+	if !ok || contact.call == nil || contact.call.attempt == nil {
+		return Contact{}, answerHangup
+	}
+	call := contact.call
+	if call.sid == "" {
+		call.sid = callSID
+	}
+	call.answeredBy = answeredBy
+	if strings.HasPrefix(answeredBy, "machine_") || answeredBy == "fax" {
+		call.attempt.NoAnswer()
+		return contact.Contact, answerHangup
+	}
+	if !call.attempt.Answered() {
+		call.abandoned = true
+		return contact.Contact, answerAbandon
+	}
+	call.connected = true
+	return contact.Contact, answerConnect
+}
+
+// unavailable turns a call answered with an agent free into an abandoned
+// one, when the server can't take it after all.
+func (c *Campaign) unavailable(contactID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if contact, ok := c.byID[contactID]; ok && contact.call != nil && contact.call.connected {
+		contact.call.attempt.Ended()
+		contact.call.connected, contact.call.abandoned = false, true
+	}
+}
+
+// completed records how the call to contactID ended, from Twilio's
+// CallStatus and CallDuration.
+func (c *Campaign) completed(contactID, status string, duration int) {
+	c.mu.Lock()
+	contact, ok := c.byID[contactID]
+	if !ok || contact.call == nil || contact.call.attempt == nil {
+		c.mu.Unlock()
+		return
+	}
+	call := contact.call
+	c.mu.Unlock()
+
+	var outcome string
+	switch {
+	case call.connected:
+		call.attempt.Ended()
+		outcome = outcomeAnswered
+	case call.abandoned:
+		outcome = outcomeAbandoned
+	case strings.HasPrefix(call.answeredBy, "machine_") || call.answeredBy == "fax":
+		outcome = outcomeMachine
+	case status == "busy":
+		call.attempt.NoAnswer()
+		outcome = outcomeBusy
+	case status == "failed":
+		call.attempt.Failed()
+		outcome = outcomeFailed
+	default:
+		// Rang out, was cancelled, or hung up before it was put through
+		call.attempt.NoAnswer()
+		outcome = outcomeNoAnswer
+	}
+	c.record(contact, outcome, duration)
+}
+
+// record ends contact's attempt with outcome, scheduling a retry unless
+// the contact is done, and appends it to the results file.
+func (c *Campaign) record(contact *campaignContact, outcome string, duration int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var sid string
+	if contact.call != nil {
+		sid = contact.call.sid
+	}
+	contact.call = nil
+	contact.outcome = outcome
+	switch outcome {
+	case outcomeAnswered, outcomeFailed, outcomeSuppressed:
+		contact.final = true
+	case outcomeDeferred:
+	default:
+		contact.final = contact.attempts >= c.cfg.MaxAttempts
+	}
+	if !contact.final {
+		contact.next = time.Now().Add(c.cfg.RetryDelay)
+	}
+
+	_ = c.results.Write([]string{
+		c.cfg.Name, contact.ID, contact.Number, strconv.Itoa(contact.attempts), sid, outcome,
+		strconv.Itoa(duration), strconv.FormatBool(contact.final), time.Now().UTC().Format(time.RFC3339),
+	})
+	c.results.Flush()
+	if err := c.results.Error(); err != nil {
+		slog.Error("failed to write campaign results", "campaign", c.cfg.Name, "error", err)
+	}
+
+	if !c.done && !slices.ContainsFunc(c.contacts, func(cc *campaignContact) bool { return !cc.final }) {
+		c.done = true
+		close(c.finished)
+	}
+}
+
+// outcomes counts contacts by their latest outcome. c.mu must not be held.
+func (c *Campaign) outcomes() map[string]int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts := make(map[string]int)
+	for _, contact := range c.contacts {
+		switch {
+		case contact.call != nil:
+			counts["in_progress"]++
+		case contact.outcome == "":
+			counts["pending"]++
+		default:
+			counts[contact.outcome]++
+		}
+	}
+	return counts
+}
+
+// ServeHTTP reports the campaign's progress and pacing as JSON.
+func (c *Campaign) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	finished := 0
+	for _, contact := range c.contacts {
+		if contact.final {
+			finished++
+		}
+	}
+	c.mu.Unlock()
+	report := map[string]any{
+		"campaign": c.cfg.Name,
+		"contacts": len(c.contacts),
+		"finished": finished,
+		"outcomes": c.outcomes(),
+		"pacing":   c.pacer.Stats(),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		slog.Error("failed to write campaign status", "error", err)
+	}
+}
+
+// campaignBrief describes an outbound call's contact to the agent, or
+// returns "" for inbound calls.
+func campaignBrief(m SessionMetadata) string {
+	campaign := m.Custom[paramCampaign]
+	if campaign == "" {
+		return ""
+	}
+	var details []string
+	for _, name := range slices.Sorted(maps.Keys(m.Custom)) {
+		if name != paramCampaign && name != paramContact {
+			details = append(details, name+": "+m.Custom[name])
+		}
+	}
+	brief := fmt.Sprintf("You placed this call as part of the %q campaign; the person who answered didn't call you. Introduce yourself and why you're calling.", campaign)
+	if len(details) > 0 {
+		brief += " What we know about the contact: " + strings.Join(details, "; ") + "."
+	}
+	return brief
+}
+
+// handleOutboundAnswer returns TwiML for a campaign call once it is
+// picked up, connecting a person to the agent like an inbound call.
+func (s *Server) handleOutboundAnswer(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	contactID, callSID := r.Form.Get("contact"), r.Form.Get("CallSid")
+	contact, action := s.campaign.answered(contactID, callSID, r.Form.Get("AnsweredBy"))
+	if action == answerConnect {
+		if err := s.sessions.Reserve(callSID, contact.Number); err != nil {
+			slog.Warn("abandoning campaign call", "call_sid", callSID, "reason", err)
+			s.campaign.unavailable(contactID)
+			action = answerAbandon
+		}
+	}
+	switch action {
+	case answerHangup:
+		writeTwiML(w, hangupTwiML(s.callTwiML.say("")))
+		return
+	case answerAbandon:
+		slog.Info("campaign call abandoned", "call_sid", callSID, "contact", contactID)
+		writeTwiML(w, hangupTwiML(s.callTwiML.say(s.campaign.cfg.AbandonMessage)))
+		return
+	}
+
+	// The contact is the caller, as far as the session is concerned
+	params := maps.Clone(contact.Context)
+	maps.Copy(params, s.callTwiML.Parameters)
+	params[paramCampaign] = s.campaign.cfg.Name
+	params[paramContact] = contact.ID
+	params[paramCallSID] = callSID
+	params[paramCaller] = contact.Number
+	params[paramCalled] = s.campaign.cfg.From
+	metadata := metadataFromParameters(params)
+	slog.Info("campaign call answered", "call_sid", callSID, "contact", contactID)
+	s.metadata.Put(metadata)
+	s.shareMetadata(r.Context(), metadata)
+
+	params = metadata.streamParameters()
+	if s.signatures != nil {
+		params[paramStreamToken] = twilioauth.StreamToken(s.signatures.AuthToken, callSID, time.Now().Add(twilioauth.StreamTokenTTL))
+	}
+	// Nobody called in, so nobody is told they're being connected. The
+	// call isn't redirected to /voice/inbound if its stream drops, as that
+	// would read it as a call from the campaign's number.
+	connect := s.callTwiML
+	connect.ConnectMessage = ""
+	writeTwiML(w, connectTwiML(connect, fmt.Sprintf("wss://%s/media-stream", r.Host), params, ""))
+}
+
+// handleOutboundStatus records how a campaign call ended.
+func (s *Server) handleOutboundStatus(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	duration, _ := strconv.Atoi(r.Form.Get("CallDuration"))
+	s.campaign.completed(r.Form.Get("contact"), r.Form.Get("CallStatus"), duration)
+	w.WriteHeader(http.StatusNoContent)
+}
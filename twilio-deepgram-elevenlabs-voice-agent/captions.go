@@ -0,0 +1,427 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/agentplexus/omnivoice/stt"
+)
+
+// Limits on a single caption, so each stays readable on screen.
+const (
+	captionMaxDuration = 6 * time.Second
+	captionMaxChars    = 84
+	// captionPause is how long the caller may pause before their next
+	// words start a new caption.
+	captionPause = time.Second
+)
+
+// captionWordDuration is how long each word is taken to last when the STT
+// provider gives no word timestamps and the speech's own timing is too
+// short to go by.
+const captionWordDuration = 300 * time.Millisecond
+
+// Caption is a stretch of speech on a call, timed against the call's
+// recording.
+type Caption struct {
+	Start   time.Duration
+	End     time.Duration
+	Speaker string
+	Text    string
+}
+
+// captionTrack times what is said on a call, for its captions. The
+// caller's words are timed by the STT provider's word timestamps; the
+// agent's utterances from when each starts playing until the caller has
+// heard it or it is cut off. A nil track times nothing.
+type captionTrack struct {
+	mu sync.Mutex
+	// heard holds the timing of each final transcript not yet captioned,
+	// oldest first.
+	heard []speechTiming
+	spans []spokenSpan
+	// playing holds the indexes in spans of the agent's utterances still
+	// playing, oldest first.
+	playing []int
+	// agentFree is when the agent's last utterance stopped playing; the
+	// next can't have started before.
+	agentFree time.Time
+}
+
+// speechTiming is when the caller said a final transcript, and each of its
+// words if the provider timed them.
+type speechTiming struct {
+	start, end time.Time
+	words      []wordTiming
+}
+
+type wordTiming struct {
+	start, end time.Time
+}
+
+// spokenSpan is one utterance, before it is broken into captions.
+type spokenSpan struct {
+	speaker    string
+	text       string
+	start, end time.Time
+	words      []wordTiming
+}
+
+// stt returns p with the caller's speech timed for the track.
+func (t *captionTrack) stt(p stt.StreamingProvider) stt.StreamingProvider {
+	if t == nil {
+		return p
+	}
+	return &captionedSTT{StreamingProvider: p, track: t}
+}
+
+// heardFinal records the timing of a final transcript from a stream opened
+// at opened, in which the caller started speaking at speaking, if known.
+func (t *captionTrack) heardFinal(opened, speaking time.Time, segment *stt.Segment) {
+	timing := speechTiming{start: speaking, end: time.Now()}
+	if segment != nil && len(segment.Words) > 0 {
+		timing.words = make([]wordTiming, len(segment.Words))
+		for i, w := range segment.Words {
+			timing.words[i] = wordTiming{start: opened.Add(w.StartTime), end: opened.Add(w.EndTime)}
+		}
+		timing.start, timing.end = timing.words[0].start, timing.words[len(timing.words)-1].end
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.heard = append(t.heard, timing)
+}
+
+// Caller captions the caller's next final transcript as text, the
+// transcript once intercepted, so a redacted transcript is captioned
+// redacted. Every final transcript is passed, even one left empty.
+func (t *captionTrack) Caller(text string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var timing speechTiming
+	if len(t.heard) > 0 {
+		timing, t.heard = t.heard[0], t.heard[1:]
+	} else {
+		timing.end = time.Now()
+	}
+	words := len(strings.Fields(text))
+	if words == 0 {
+		return
+	}
+	if guess := time.Duration(words) * captionWordDuration; timing.words == nil && (timing.start.IsZero() || timing.end.Sub(timing.start) < guess/3) {
+		timing.start = timing.end.Add(-guess)
+	}
+	t.spans = append(t.spans, spokenSpan{speaker: speakerCaller, text: text, start: timing.start, end: timing.end, words: timing.words})
+}
+
+// AgentStarted captions an utterance of the agent's as it starts playing.
+// Whispers to one leg aren't captioned.
+func (t *captionTrack) AgentStarted(text string, target Leg) {
+	if t == nil || target != LegAll {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.playing = append(t.playing, len(t.spans))
+	t.spans = append(t.spans, spokenSpan{speaker: speakerAgent, text: text, start: time.Now()})
+}
+
+// AgentPlayed ends the caption of an utterance the caller heard in full.
+// Utterances started before it and still playing were never heard, as
+// utterances are heard in order, and are dropped.
+func (t *captionTrack) AgentPlayed(text string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, index := range t.playing {
+		if t.spans[index].text != text {
+			continue
+		}
+		for _, unheard := range t.playing[:i] {
+			t.spans[unheard].text = ""
+		}
+		t.finish(index, time.Now())
+		t.playing = t.playing[i+1:]
+		return
+	}
+}
+
+// AgentCut ends the captions of the agent's utterances as they are cut
+// off, e.g. by a barge-in.
+func (t *captionTrack) AgentCut() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cut(time.Now())
+}
+
+func (t *captionTrack) cut(at time.Time) {
+	for _, index := range t.playing {
+		t.finish(index, at)
+	}
+	t.playing = nil
+}
+
+// finish ends an agent utterance's span at at. Utterances are synthesized
+// ahead of being played, so one starts no earlier than the last ended; one
+// that never got to play is dropped.
+func (t *captionTrack) finish(index int, at time.Time) {
+	span := &t.spans[index]
+	if span.start.Before(t.agentFree) {
+		span.start = t.agentFree
+	}
+	if !span.start.Before(at) {
+		span.text = ""
+		return
+	}
+	span.end = at
+	t.agentFree = at
+}
+
+// Captions ends the utterances still playing at ended and returns the
+// call's captions, timed from zero, the start of the call's recording.
+// Speech before zero isn't captioned.
+func (t *captionTrack) Captions(zero, ended time.Time) []Caption {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cut(ended)
+	var captions []Caption
+	for _, span := range t.spans {
+		tokens := strings.Fields(span.text)
+		if len(tokens) == 0 {
+			continue
+		}
+		words := span.words
+		if len(words) != len(tokens) {
+			// Intercepted or agent text is timed by spreading it over the span
+			words = spreadWords(tokens, span.start, span.end)
+		}
+		for _, c := range groupCaptions(tokens, words) {
+			if !c.end.After(zero) {
+				continue
+			}
+			if c.start.Before(zero) {
+				c.start = zero
+			}
+			captions = append(captions, Caption{Start: c.start.Sub(zero), End: c.end.Sub(zero), Speaker: span.speaker, Text: c.text})
+		}
+	}
+	slices.SortStableFunc(captions, func(a, b Caption) int { return cmp.Compare(a.Start, b.Start) })
+	return captions
+}
+
+// spreadWords times tokens across start to end, each in proportion to its
+// length.
+func spreadWords(tokens []string, start, end time.Time) []wordTiming {
+	total := 0
+	for _, token := range tokens {
+		total += len(token) + 1
+	}
+	span := end.Sub(start)
+	words := make([]wordTiming, len(tokens))
+	at := 0
+	for i, token := range tokens {
+		words[i].start = start.Add(span * time.Duration(at) / time.Duration(total))
+		at += len(token) + 1
+		words[i].end = start.Add(span * time.Duration(at) / time.Duration(total))
+	}
+	return words
+}
+
+type timedCaption struct {
+	start, end time.Time
+	text       string
+}
+
+// groupCaptions breaks timed words into captions, starting a new one at a
+// pause, after a sentence once the current one is half full, or when the
+// current one would grow too long.
+func groupCaptions(tokens []string, words []wordTiming) []timedCaption {
+	var captions []timedCaption
+	var c timedCaption
+	for i, token := range tokens {
+		w := words[i]
+		sentence := strings.HasSuffix(c.text, ".") || strings.HasSuffix(c.text, "?") || strings.HasSuffix(c.text, "!")
+		if c.text != "" && (w.start.Sub(c.end) >= captionPause || w.end.Sub(c.start) > captionMaxDuration ||
+			len(c.text)+1+len(token) > captionMaxChars || sentence && len(c.text) >= captionMaxChars/2) {
+			captions = append(captions, c)
+			c = timedCaption{}
+		}
+		if c.text == "" {
+			c.start, c.text = w.start, token
+		} else {
+			c.text += " " + token
+		}
+		c.end = w.end
+	}
+	if c.text != "" {
+		captions = append(captions, c)
+	}
+	return captions
+}
+
+// captionedSTT times the caller's final transcripts for a caption track,
+// asking the provider for word timestamps.
+type captionedSTT struct {
+	stt.StreamingProvider
+	track *captionTrack
+}
+
+// TranscribeStream opens a stream, timing its final transcripts from when
+// it was opened.
+func (p *captionedSTT) TranscribeStream(ctx context.Context, config stt.TranscriptionConfig) (io.WriteCloser, <-chan stt.StreamEvent, error) {
+	config.EnableWordTimestamps = true
+	opened := time.Now()
+	w, events, err := p.StreamingProvider.TranscribeStream(ctx, config)
+	if err != nil {
+		return nil, nil, err
+	}
+	timed := make(chan stt.StreamEvent, cap(events))
+	go func() {
+		defer close(timed)
+		var speaking time.Time
+		for event := range events {
+			switch {
+			case event.Type == stt.EventSpeechStart:
+				speaking = time.Now()
+			case event.IsFinal && event.Transcript != "":
+				p.track.heardFinal(opened, speaking, event.Segment)
+				speaking = time.Time{}
+			}
+			select {
+			case timed <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return w, timed, nil
+}
+
+// WebVTT writes captions as a WebVTT file, each cue voiced by its speaker.
+func WebVTT(captions []Caption) []byte {
+	var b strings.Builder
+	b.WriteString("WEBVTT\n")
+	escape := strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+	for i, c := range captions {
+		fmt.Fprintf(&b, "\n%d\n%s --> %s\n<v %s>%s\n", i+1,
+			captionTime(c.Start, '.'), captionTime(c.End, '.'), escape.Replace(c.Speaker), escape.Replace(c.Text))
+	}
+	return []byte(b.String())
+}
+
+// SRT writes captions as a SubRip file, each line led by its speaker.
+func SRT(captions []Caption) []byte {
+	var b strings.Builder
+	for i, c := range captions {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "%d\n%s --> %s\n%s: %s\n", i+1,
+			captionTime(c.Start, ','), captionTime(c.End, ','), c.Speaker, c.Text)
+	}
+	return []byte(b.String())
+}
+
+// captionTime formats d as hh:mm:ss followed by sep and milliseconds.
+func captionTime(d time.Duration, sep byte) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d%c%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+}
+
+// CaptionEvent is a live caption, sent on a call's caption stream. The
+// caller's interim captions are revised by each that follows until one is
+// final; the agent's are final as each utterance starts playing.
+type CaptionEvent struct {
+	Speaker string `json:"speaker"`
+	Text    string `json:"text"`
+	Final   bool   `json:"final"`
+	// Target is the only leg that heard a whispered utterance.
+	Target Leg       `json:"target,omitempty"`
+	At     time.Time `json:"at"`
+}
+
+// captions streams a session's live captions as Server-Sent Events: the
+// transcript so far, then each caption as it is said, interim and final,
+// then an end event once the call is over.
+func (a *adminAPI) captions(w http.ResponseWriter, r *http.Request) {
+	call, ok := a.call(w, r)
+	if !ok {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	send := func(name string, v any) bool {
+		data, _ := json.Marshal(v)
+		if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, data); err != nil {
+			return false
+		}
+		flusher.Flush()
+		return true
+	}
+	end := func() { send("end", map[string]time.Time{"at": time.Now()}) }
+
+	watcher, history, _, ok := call.watch(false, true)
+	if !ok {
+		end()
+		return
+	}
+	defer call.unwatch(watcher)
+	for _, line := range history {
+		if !send("caption", CaptionEvent{Speaker: line.Speaker, Text: line.Text, Final: true, Target: line.Target, At: line.At}) {
+			return
+		}
+	}
+
+	// Comments keep idle connections open through proxies
+	keepalive := time.NewTicker(monitorPingInterval)
+	defer keepalive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, open := <-watcher.events:
+			if !open {
+				return
+			}
+			switch event.Kind {
+			case monitorTranscript, monitorInterim:
+				caption := CaptionEvent{Speaker: event.Speaker, Text: event.Text, Final: event.Kind == monitorTranscript, Target: event.Target, At: event.At}
+				if !send("caption", caption) {
+					return
+				}
+			case monitorEnd:
+				end()
+				return
+			}
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"time"
+)
+
+// CallDetailRecord summarizes a finished call. It is logged as a JSON
+// object when the session ends, ready for ingestion by a log pipeline
+// (use LOG_FORMAT=json to have it embedded rather than quoted).
+type CallDetailRecord struct {
+	SessionID       string         `json:"session_id"`
+	StartedAt       time.Time      `json:"started_at"`
+	EndedAt         time.Time      `json:"ended_at"`
+	DurationSeconds float64        `json:"duration_seconds"`
+	Turns           int            `json:"turns"`
+	BargeIns        int            `json:"barge_ins,omitempty"`
+	Reprompts       int            `json:"reprompts,omitempty"`
+	EndedBy         string         `json:"ended_by"`
+	Residency       string         `json:"residency"`
+	Tenant          string         `json:"tenant,omitempty"`
+	Experiment      string         `json:"experiment,omitempty"`
+	Variant         string         `json:"variant,omitempty"`
+	AccountID       string         `json:"account_id,omitempty"`
+	TicketID        string         `json:"ticket_id,omitempty"`
+	Campaign        string         `json:"campaign,omitempty"`
+	SMSSent         int            `json:"sms_sent,omitempty"`
+	EmailsSent      int            `json:"emails_sent,omitempty"`
+	Appointments    []string       `json:"appointments,omitempty"`
+	Payments        []string       `json:"payments,omitempty"`
+	Specialists     []string       `json:"specialists,omitempty"`
+	Intents         []string       `json:"intents,omitempty"`
+	RecordingSIDs   []string       `json:"recording_sids,omitempty"`
+	TransferredTo   string         `json:"transferred_to,omitempty"`
+	Coached         bool           `json:"coached,omitempty"`
+	Degradation     string         `json:"degradation,omitempty"`
+	Cost            *CostSummary   `json:"cost,omitempty"`
+	Outbound        *OutboundStats `json:"outbound,omitempty"`
+	Topics          []TopicSegment `json:"topics,omitempty"`
+	// Moderation lists the utterances moderation flagged.
+	Moderation []ModerationFlag `json:"moderation,omitempty"`
+	// Snippets lists the keys of the caller audio saved for turns that may
+	// have been misheard.
+	Snippets []string `json:"snippets,omitempty"`
+	// Emergencies lists the emergencies the caller spoke of.
+	Emergencies []EmergencyFlag `json:"emergencies,omitempty"`
+}
+
+// newCallDetailRecord starts a record for a session.
+func newCallDetailRecord(sessionID string, residency ResidencyPolicy) *CallDetailRecord {
+	return &CallDetailRecord{
+		SessionID: sessionID,
+		StartedAt: time.Now(),
+		EndedBy:   "caller",
+		Residency: residency.String(),
+	}
+}
+
+// emit finalizes the record and logs it to the session's logger.
+func (r *CallDetailRecord) emit(logger *slog.Logger) {
+	r.EndedAt = time.Now()
+	r.DurationSeconds = r.EndedAt.Sub(r.StartedAt).Seconds()
+	data, err := json.Marshal(r)
+	if err != nil {
+		logger.Error("failed to encode call detail record", "error", err)
+		return
+	}
+	logger.Info("call detail record", "cdr", json.RawMessage(data))
+}
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/agentplexus/omnivoice/pipeline"
+	"github.com/agentplexus/omnivoice/transport"
+)
+
+const (
+	// paramMode marks a Media Stream started for coaching rather than for
+	// the voice agent.
+	paramMode = "mode"
+	modeCoach = "coach"
+
+	// coachingRetention is how long a call's coaching history stays
+	// available to the console after the call.
+	coachingRetention = 4 * time.Hour
+	// coachingSubscriberBuffer bounds the events queued for a slow browser.
+	coachingSubscriberBuffer = 32
+)
+
+// Coaching event kinds.
+const (
+	coachingTranscript = "transcript"
+	coachingHint       = "hint"
+	coachingKnowledge  = "knowledge"
+	coachingEnd        = "end"
+)
+
+// CoachingEvent is pushed to the human agent's console.
+type CoachingEvent struct {
+	Kind  string    `json:"kind"`
+	Title string    `json:"title,omitempty"`
+	Text  string    `json:"text"`
+	At    time.Time `json:"at"`
+}
+
+// Coach turns what the caller says after a transfer into hints for the
+// human now handling the call. A Coach is created per call and may keep
+// state, e.g. to avoid repeating itself. An LLM-backed Coach can replace
+// the built-in playbook one without touching the rest of the pipeline.
+type Coach interface {
+	Suggest(ctx context.Context, utterance string) []CoachingEvent
+}
+
+// KnowledgeSnippet is a knowledge-base entry surfaced when the caller
+// mentions one of its keywords.
+type KnowledgeSnippet struct {
+	Title    string   `json:"title"`
+	Keywords []string `json:"keywords"`
+	Text     string   `json:"text"`
+}
+
+// loadKnowledge reads knowledge snippets from a JSON file.
+func loadKnowledge(path string) ([]KnowledgeSnippet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var snippets []KnowledgeSnippet
+	if err := json.Unmarshal(data, &snippets); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return snippets, nil
+}
+
+// knowledgeBase holds the knowledge snippets coaching draws on, reloaded
+// from their file while the server runs: when the file changes, or on
+// request. Each coaching session takes the snippets current as it starts.
+type knowledgeBase struct {
+	path     string
+	snippets atomic.Pointer[[]KnowledgeSnippet]
+
+	// mu serializes reloads; modTime is the file's as last loaded.
+	mu      sync.Mutex
+	modTime time.Time
+}
+
+// loadKnowledgeBase reads the knowledge snippets in path.
+func loadKnowledgeBase(path string) (*knowledgeBase, error) {
+	kb := &knowledgeBase{path: path}
+	if _, err := kb.Reload(); err != nil {
+		return nil, err
+	}
+	return kb, nil
+}
+
+// Snippets returns the snippets as last loaded.
+func (kb *knowledgeBase) Snippets() []KnowledgeSnippet {
+	if kb == nil {
+		return nil
+	}
+	return *kb.snippets.Load()
+}
+
+// Reload reads the file again, returning how many snippets it has. A file
+// that can't be read leaves the snippets as they were.
+func (kb *knowledgeBase) Reload() (int, error) {
+	kb.mu.Lock()
+	defer kb.mu.Unlock()
+	info, err := os.Stat(kb.path)
+	if err != nil {
+		return 0, err
+	}
+	// A broken file is tried again once it changes, not on every check
+	kb.modTime = info.ModTime()
+	snippets, err := loadKnowledge(kb.path)
+	if err != nil {
+		return 0, err
+	}
+	kb.snippets.Store(&snippets)
+	return len(snippets), nil
+}
+
+// Watch reloads the file whenever its modification time changes, checking
+// every interval until ctx ends. Editors that replace the file rather than
+// writing it in place are picked up too.
+func (kb *knowledgeBase) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		info, err := os.Stat(kb.path)
+		kb.mu.Lock()
+		changed := err == nil && !info.ModTime().Equal(kb.modTime)
+		kb.mu.Unlock()
+		if !changed {
+			continue
+		}
+		n, err := kb.Reload()
+		if err != nil {
+			slog.Warn("failed to reload knowledge file, keeping the loaded snippets", "path", kb.path, "error", err)
+			continue
+		}
+		slog.Info("knowledge file reloaded", "path", kb.path, "snippets", n)
+	}
+}
+
+// ServeHTTP reloads the file on request, e.g. from a deploy of new support
+// content, reporting how many snippets it has.
+func (kb *knowledgeBase) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	n, err := kb.Reload()
+	if err != nil {
+		slog.Warn("failed to reload knowledge file, keeping the loaded snippets", "path", kb.path, "error", err)
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+		return
+	}
+	slog.Info("knowledge file reloaded", "path", kb.path, "snippets", n)
+	writeJSON(w, http.StatusOK, map[string]int{"snippets": n})
+}
+
+// defaultPlaybook maps the default topics to a hint for the human agent.
+func defaultPlaybook() map[string]string {
+	return map[string]string{
+		"billing":           "Confirm the charge date and amount before discussing a refund.",
+		"cancellation":      "Ask what prompted the cancellation before processing it; a retention offer may apply.",
+		"retention offer":   "State the offer's duration and what happens when it ends.",
+		"technical support": "Check the service status page for an ongoing outage first.",
+		"account":           "Verify the caller's identity before changing account details.",
+		"orders":            "Have the order number ready and check the carrier's tracking status.",
+	}
+}
+
+// playbookCoach recognises topics with the transcript lexicon and suggests
+// the playbook hint for each, plus any matching knowledge snippets. Each
+// hint and snippet is shown at most once per call.
+type playbookCoach struct {
+	segmenter *topicSegmenter
+	playbook  map[string]string
+	knowledge []KnowledgeSnippet
+	shown     map[string]bool
+}
+
+func newPlaybookCoach(topics []Topic, playbook map[string]string, knowledge []KnowledgeSnippet) *playbookCoach {
+	return &playbookCoach{
+		segmenter: newTopicSegmenter(topics),
+		playbook:  playbook,
+		knowledge: knowledge,
+		shown:     make(map[string]bool),
+	}
+}
+
+// Suggest returns hints not yet shown for an utterance.
+func (c *playbookCoach) Suggest(_ context.Context, utterance string) []CoachingEvent {
+	var events []CoachingEvent
+	now := time.Now()
+
+	if label, _ := c.segmenter.classify(utterance); label != "" && !c.shown["topic:"+label] {
+		if hint, ok := c.playbook[label]; ok {
+			c.shown["topic:"+label] = true
+			events = append(events, CoachingEvent{Kind: coachingHint, Title: label, Text: hint, At: now})
+		}
+	}
+
+	words := normalizeWords(utterance)
+	for _, snippet := range c.knowledge {
+		if c.shown["kb:"+snippet.Title] {
+			continue
+		}
+		for _, kw := range snippet.Keywords {
+			if matchesKeyword(words, normalizeWords(kw)) {
+				c.shown["kb:"+snippet.Title] = true
+				events = append(events, CoachingEvent{Kind: coachingKnowledge, Title: snippet.Title, Text: snippet.Text, At: now})
+				break
+			}
+		}
+	}
+	return events
+}
+
+// coachingHub fans coaching events out to the consoles watching each call.
+type coachingHub struct {
+	mu    sync.Mutex
+	calls map[string]*coachedCall
+}
+
+type coachedCall struct {
+	token   string
+	created time.Time
+	history []CoachingEvent
+	subs    map[chan CoachingEvent]struct{}
+	ended   bool
+}
+
+func newCoachingHub() *coachingHub {
+	return &coachingHub{calls: make(map[string]*coachedCall)}
+}
+
+// Register prepares coaching for a call and returns the access token for
+// its console.
+func (h *coachingHub) Register(callSID string) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	for sid, c := range h.calls {
+		if now.Sub(c.created) > coachingRetention {
+			delete(h.calls, sid)
+		}
+	}
+	h.calls[callSID] = &coachedCall{token: token, created: now, subs: make(map[chan CoachingEvent]struct{})}
+	return token, nil
+}
+
+// Registered reports whether coaching was set up for a call.
+func (h *coachingHub) Registered(callSID string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, ok := h.calls[callSID]
+	return ok
+}
+
+// Publish records an event and sends it to every open console.
+func (h *coachingHub) Publish(callSID string, event CoachingEvent) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c, ok := h.calls[callSID]
+	if !ok || c.ended {
+		return
+	}
+	c.history = append(c.history, event)
+	for ch := range c.subs {
+		select {
+		case ch <- event:
+		default:
+			slog.Warn("coaching console too slow, event dropped", "call_sid", callSID)
+		}
+	}
+	if event.Kind == coachingEnd {
+		c.ended = true
+		for ch := range c.subs {
+			close(ch)
+		}
+		c.subs = nil
+	}
+}
+
+// End marks the call finished; consoles keep the history.
+func (h *coachingHub) End(callSID string) {
+	h.Publish(callSID, CoachingEvent{Kind: coachingEnd, Text: "Call ended", At: time.Now()})
+}
+
+// subscribe returns the history so far and a channel of further events,
+// closed when the call ends.
+func (h *coachingHub) subscribe(callSID, token string) ([]CoachingEvent, chan CoachingEvent, func(), bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	c, ok := h.calls[callSID]
+	if !ok || subtle.ConstantTimeCompare([]byte(c.token), []byte(token)) != 1 {
+		return nil, nil, nil, false
+	}
+	history := append([]CoachingEvent(nil), c.history...)
+	if c.ended {
+		return history, nil, func() {}, true
+	}
+
+	ch := make(chan CoachingEvent, coachingSubscriberBuffer)
+	c.subs[ch] = struct{}{}
+	cancel := func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if _, ok := c.subs[ch]; ok {
+			delete(c.subs, ch)
+			close(ch)
+		}
+	}
+	return history, ch, cancel, true
+}
+
+// ServeHTTP serves the console page at /coach/ and its event stream
+// (Server-Sent Events) at /coach/events.
+func (h *coachingHub) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasSuffix(r.URL.Path, "/events") {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		_, _ = w.Write([]byte(coachingConsoleHTML))
+		return
+	}
+
+	callSID := r.URL.Query().Get("call")
+	history, events, cancel, ok := h.subscribe(callSID, r.URL.Query().Get("token"))
+	if !ok {
+		http.Error(w, "unknown call or invalid token", http.StatusForbidden)
+		return
+	}
+	defer cancel()
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	send := func(event CoachingEvent) bool {
+		data, _ := json.Marshal(event)
+		if _, err := fmt.Fprintf(w, "data: %s\n\n", data); err != nil {
+			return false
+		}
+		flusher.Flush()
+		return true
+	}
+	for _, event := range history {
+		if !send(event) {
+			return
+		}
+	}
+	if events == nil {
+		return
+	}
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event, open := <-events:
+			if !open || !send(event) {
+				return
+			}
+		}
+	}
+}
+
+// handleCoachingSession transcribes the caller's side of a transferred
+// call and pushes the transcript and coaching hints to the human's console.
+// The stream is listen-only; nothing is ever spoken.
+func (s *Server) handleCoachingSession(ctx context.Context, conn transport.Connection, callSID string, logger *slog.Logger) {
+	if !s.coaching.Registered(callSID) {
+		// Only streams this server started after the caller consented.
+		logger.Warn("rejecting unregistered coaching stream")
+		_ = conn.Close()
+		return
+	}
+	logger.Info("coaching session started")
+	defer s.coaching.End(callSID)
+
+	sessionCtx, cancelSession := context.WithCancel(ctx)
+	defer cancelSession()
+
+	codec := codecOf(conn, s.transportCodec)
+	inbound := conn
+	sttEncoding, sttRate, decode := sttFormat(codec)
+	if decode {
+		inbound = newDecodingConnection(conn, codec, s.concurrency.transcode)
+	}
+
+	coach := s.newCoach()
+	sttPipeline := pipeline.NewSTTPipeline(withSTTFormatting(s.sttProvider, s.stt), pipeline.STTPipelineConfig{
+		Model:      s.stt.Model,
+		Language:   s.stt.Language,
+		Encoding:   sttEncoding,
+		SampleRate: sttRate,
+		Channels:   1,
+		OnTranscript: func(transcript string, isFinal bool) {
+			text := strings.TrimSpace(transcript)
+			if !isFinal || text == "" {
+				return
+			}
+			s.coaching.Publish(callSID, CoachingEvent{Kind: coachingTranscript, Title: "Caller", Text: text, At: time.Now()})
+			for _, event := range coach.Suggest(sessionCtx, text) {
+				s.coaching.Publish(callSID, event)
+			}
+		},
+		OnError: func(err error) {
+			logger.Error("coaching STT error", "error", err)
+		},
+	})
+	if err := sttPipeline.StartFromConnection(sessionCtx, inbound); err != nil {
+		logger.Error("failed to start coaching STT pipeline", "error", err)
+		_ = conn.Close()
+		return
+	}
+
+	select {
+	case <-sessionCtx.Done():
+	case <-conn.Events():
+	}
+	sttPipeline.Stop()
+	_ = conn.Close()
+	logger.Info("coaching session ended")
+}
+
+// coachingConsoleHTML is a minimal console for the human agent. It reads
+// call and token from its own query string.
+const coachingConsoleHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Call coaching</title>
+<style>
+  body { font-family: system-ui, sans-serif; margin: 2rem; max-width: 48rem; }
+  .event { padding: .5rem .75rem; margin: .5rem 0; border-radius: .25rem; }
+  .transcript { background: #f3f3f3; }
+  .hint { background: #fff4d6; }
+  .knowledge { background: #e3f0ff; }
+  .end { color: #888; }
+  .title { font-weight: 600; margin-right: .5rem; }
+</style>
+</head>
+<body>
+<h1>Call coaching</h1>
+<div id="events"></div>
+<script>
+  const params = new URLSearchParams(location.search);
+  const source = new EventSource("events?" + params.toString());
+  const list = document.getElementById("events");
+  source.onmessage = (msg) => {
+    const e = JSON.parse(msg.data);
+    const div = document.createElement("div");
+    div.className = "event " + e.kind;
+    if (e.title) {
+      const title = document.createElement("span");
+      title.className = "title";
+      title.textContent = e.title;
+      div.appendChild(title);
+    }
+    div.appendChild(document.createTextNode(e.text));
+    list.appendChild(div);
+    if (e.kind === "end") source.close();
+  };
+</script>
+</body>
+</html>
+`
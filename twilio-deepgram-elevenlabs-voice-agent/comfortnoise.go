@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"os"
+	"strconv"
+
+	"github.com/agentplexus/omnivoice-examples/kit/audio"
+)
+
+const (
+	// defaultComfortNoiseDBFS is the level of comfort noise unless
+	// COMFORT_NOISE_DBFS overrides it: audible in an earpiece, but well
+	// under any line noise a caller would notice.
+	defaultComfortNoiseDBFS = -62
+	// comfortNoiseSeconds is how much noise is generated and looped, long
+	// enough that the repetition can't be heard.
+	comfortNoiseSeconds = 5
+)
+
+// comfortNoiseFromEnv returns pink noise at COMFORT_NOISE_DBFS to play
+// while the agent isn't speaking, or nil unless enabled by
+// features.comfort_noise (COMFORT_NOISE).
+func comfortNoiseFromEnv(enabled bool) (*ambienceBed, error) {
+	if !enabled {
+		return nil, nil
+	}
+	level := float64(defaultComfortNoiseDBFS)
+	if v := os.Getenv("COMFORT_NOISE_DBFS"); v != "" {
+		db, err := strconv.ParseFloat(v, 64)
+		if err != nil || db > 0 {
+			return nil, fmt.Errorf("invalid COMFORT_NOISE_DBFS %q (want a level <= 0)", v)
+		}
+		level = db
+	}
+	bed := &ambienceBed{loops: make(map[int][]int16), level: 1}
+	for _, codec := range []audio.Codec{audio.CodecMulaw, audio.CodecG722} {
+		rate := codec.SampleRate()
+		bed.loops[rate] = pinkNoise(comfortNoiseSeconds*rate, level)
+	}
+	return bed, nil
+}
+
+// pinkNoise returns n samples of pink noise, whose power falls off with
+// frequency like the hiss of a real line, at an RMS level of dbfs. It
+// uses Paul Kellet's economy filter over white noise.
+func pinkNoise(n int, dbfs float64) []int16 {
+	rng := rand.New(rand.NewPCG(1, 2))
+	pink := make([]float64, n)
+	var b0, b1, b2, sum float64
+	for i := range pink {
+		white := rng.Float64()*2 - 1
+		b0 = 0.99765*b0 + white*0.0990460
+		b1 = 0.96300*b1 + white*0.2965164
+		b2 = 0.57000*b2 + white*1.0526913
+		pink[i] = b0 + b1 + b2 + white*0.1848
+		sum += pink[i] * pink[i]
+	}
+	scale := dbGain(dbfs) * math.MaxInt16 / math.Sqrt(sum/float64(n))
+	samples := make([]int16, n)
+	for i, v := range pink {
+		samples[i] = int16(max(math.MinInt16, min(math.MaxInt16, v*scale)))
+	}
+	return samples
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/agentplexus/omnivoice/transport"
+	"golang.org/x/sync/errgroup"
+)
+
+// sessionGoroutines is how many goroutines an agent call runs on for its
+// whole length, besides its background tasks and whatever the STT and TTS
+// providers run: the session itself, the pacer and the goroutine tracking
+// it, the speech queue, the STT pipeline's reader and event loop, the STT
+// stream's forwarder, and the clocks for silence, the maximum call
+// duration and the drain deadline. A call speaking a reply runs one more
+// to stream it. With SessionTasks and controlTasks, this is a call's
+// goroutine budget, which the soak test holds calls to.
+const sessionGoroutines = 10
+
+// controlTasks is how many control tasks (hanging up, transferring,
+// handing over to voicemail) one call runs at once. They have capacity of
+// their own, so a call with SessionTasks turns running can still be ended
+// or handed on.
+const controlTasks = 4
+
+// ConcurrencyConfig bounds the goroutines the server runs for calls.
+type ConcurrencyConfig struct {
+	// MaxStreams caps Media Streams being handled at once, agent and
+	// coaching. A stream over the cap is closed as it arrives.
+	MaxStreams int
+	// SessionTasks caps the background tasks one call runs at once:
+	// answering a turn and reporting a reply cut off. A task over the cap
+	// isn't started, and is logged.
+	SessionTasks int
+	// TranscodeWorkers is how many goroutines resample and encode audio
+	// for every call between them, or 0 to transcode on each call's own
+	// goroutines.
+	TranscodeWorkers int
+}
+
+// defaultConcurrencyConfig returns the configuration used unless
+// overridden by MAX_STREAMS, SESSION_TASKS and TRANSCODE_WORKERS: up to
+// 1000 streams of up to 16 tasks each, transcoded on one worker per CPU.
+func defaultConcurrencyConfig() ConcurrencyConfig {
+	return ConcurrencyConfig{
+		MaxStreams:       1000,
+		SessionTasks:     16,
+		TranscodeWorkers: runtime.GOMAXPROCS(0),
+	}
+}
+
+// concurrencyConfigFromEnv applies environment overrides to the default
+// configuration.
+func concurrencyConfigFromEnv() (ConcurrencyConfig, error) {
+	cfg := defaultConcurrencyConfig()
+	for _, v := range []struct {
+		name string
+		dst  *int
+		min  int
+	}{
+		{"MAX_STREAMS", &cfg.MaxStreams, 1},
+		{"SESSION_TASKS", &cfg.SessionTasks, 1},
+		{"TRANSCODE_WORKERS", &cfg.TranscodeWorkers, 0},
+	} {
+		s := os.Getenv(v.name)
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil || n < v.min {
+			return cfg, fmt.Errorf("invalid %s: %q (want an integer of at least %d)", v.name, s, v.min)
+		}
+		*v.dst = n
+	}
+	return cfg, nil
+}
+
+// Concurrency runs the goroutines of every call within ConcurrencyConfig:
+// one per stream, in a group capped at MaxStreams; each call's background
+// tasks in a group of its own; and transcoding on a shared pool of
+// workers.
+type Concurrency struct {
+	Config ConcurrencyConfig
+
+	streams   errgroup.Group
+	transcode *transcodeWorkers // nil transcodes inline
+
+	// tasks counts background tasks running across calls, and peakTasks
+	// the most one call has run at once.
+	tasks     atomic.Int64
+	peakTasks atomic.Int64
+	// refusedTasks and refusedStreams count what was turned away over
+	// the caps.
+	refusedTasks   atomic.Int64
+	refusedStreams atomic.Int64
+}
+
+// newConcurrency starts the transcode workers for cfg, which run for the
+// life of the process.
+func newConcurrency(cfg ConcurrencyConfig) *Concurrency {
+	c := &Concurrency{Config: cfg, transcode: newTranscodeWorkers(cfg.TranscodeWorkers)}
+	c.streams.SetLimit(cfg.MaxStreams)
+	return c
+}
+
+// Serve handles conn on a goroutine of its own, unless MaxStreams are
+// being handled already, in which case conn is closed.
+func (c *Concurrency) Serve(conn transport.Connection, handle func(transport.Connection)) {
+	if c.streams.TryGo(func() error { handle(conn); return nil }) {
+		return
+	}
+	c.refusedStreams.Add(1)
+	slog.Warn("stream budget exhausted, closing stream", "session", conn.ID(), "max_streams", c.Config.MaxStreams)
+	_ = conn.Close()
+}
+
+// sessionTasks returns a group for a call's background tasks.
+func (c *Concurrency) sessionTasks(logger *slog.Logger) *sessionTasks {
+	t := &sessionTasks{c: c, logger: logger}
+	t.group.SetLimit(c.Config.SessionTasks)
+	t.control.SetLimit(controlTasks)
+	return t
+}
+
+// ServeHTTP reports the goroutines running for calls against their
+// budget, as JSON.
+func (c *Concurrency) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	stats := map[string]any{
+		"goroutines":         runtime.NumGoroutine(),
+		"max_streams":        c.Config.MaxStreams,
+		"session_goroutines": sessionGoroutines,
+		"session_tasks":      c.Config.SessionTasks,
+		"control_tasks":      controlTasks,
+		"tasks":              c.tasks.Load(),
+		"peak_session_tasks": c.peakTasks.Load(),
+		"refused_tasks":      c.refusedTasks.Load(),
+		"refused_streams":    c.refusedStreams.Load(),
+		"transcode_workers":  c.Config.TranscodeWorkers,
+		"transcode_busy":     c.transcode.busyWorkers(),
+		"transcode_queued":   c.transcode.queued(),
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		slog.Error("failed to write concurrency stats", "error", err)
+	}
+}
+
+// sessionTasks runs a call's background tasks: up to SessionTasks at once
+// answering it, up to controlTasks at once controlling it, and its clocks.
+// The session waits for them before it ends, so none outlives its call:
+// every task must return once the session's context is done.
+type sessionTasks struct {
+	c      *Concurrency
+	logger *slog.Logger
+	group  errgroup.Group
+	// control runs the tasks that end or hand on the call, and clocks the
+	// tasks that run for its whole length, outside SessionTasks.
+	control errgroup.Group
+	clocks  sync.WaitGroup
+
+	running, peak atomic.Int64
+	refused       atomic.Int64
+}
+
+// Go runs fn on a goroutine of its own and reports whether it was
+// started; it isn't when the call already has SessionTasks running.
+func (t *sessionTasks) Go(name string, fn func()) bool {
+	return t.start(&t.group, name, t.c.Config.SessionTasks, fn)
+}
+
+// Control runs fn, which ends or hands on the call, on a goroutine of its
+// own and reports whether it was started; it isn't when the call already
+// has controlTasks running.
+func (t *sessionTasks) Control(name string, fn func()) bool {
+	return t.start(&t.control, name, controlTasks, fn)
+}
+
+// Clock runs fn, which times the call and returns once the session's
+// context is done, on a goroutine of its own. Clocks are part of
+// sessionGoroutines, not counted as tasks.
+func (t *sessionTasks) Clock(fn func()) {
+	t.clocks.Add(1)
+	go func() {
+		defer t.clocks.Done()
+		fn()
+	}()
+}
+
+// start runs fn in group, which allows limit tasks at once, and reports
+// whether it was started.
+func (t *sessionTasks) start(group *errgroup.Group, name string, limit int, fn func()) bool {
+	// Counted before starting, so the peak doesn't depend on scheduling
+	n := t.running.Add(1)
+	t.c.tasks.Add(1)
+	done := func() {
+		t.running.Add(-1)
+		t.c.tasks.Add(-1)
+	}
+	started := group.TryGo(func() error {
+		defer done()
+		fn()
+		return nil
+	})
+	if started {
+		raise(&t.peak, n)
+	} else {
+		done()
+		t.refused.Add(1)
+		t.c.refusedTasks.Add(1)
+		t.logger.Error("session task budget exhausted, task not started", "task", name, "limit", limit)
+	}
+	return started
+}
+
+// Wait waits for the running tasks and clocks to return.
+func (t *sessionTasks) Wait() {
+	_ = t.group.Wait()
+	_ = t.control.Wait()
+	t.clocks.Wait()
+	raise(&t.c.peakTasks, t.peak.Load())
+}
+
+// raise sets v to n if n is greater.
+func raise(v *atomic.Int64, n int64) {
+	for {
+		old := v.Load()
+		if n <= old || v.CompareAndSwap(old, n) {
+			return
+		}
+	}
+}
+
+// transcoder is audio conversion work run on a transcode worker.
+type transcoder interface {
+	transcode()
+}
+
+// transcodeTask is a transcoder and where to say it is done.
+type transcodeTask struct {
+	work transcoder
+	done chan<- struct{}
+}
+
+// transcodeWorkers is a fixed pool of goroutines that transcode for every
+// call, so the CPU spent resampling and encoding is bounded by the pool
+// rather than by the number of calls, and a burst of calls queues for it
+// instead of starving everything else. Transcoding is short, CPU-bound and
+// never blocks, so a worker is never held up by a slow call.
+type transcodeWorkers struct {
+	tasks chan transcodeTask
+	busy  atomic.Int64
+}
+
+// newTranscodeWorkers starts n workers, or returns nil for n of 0.
+func newTranscodeWorkers(n int) *transcodeWorkers {
+	if n == 0 {
+		return nil
+	}
+	p := &transcodeWorkers{tasks: make(chan transcodeTask, n)}
+	for range n {
+		go func() {
+			for task := range p.tasks {
+				p.busy.Add(1)
+				task.work.transcode()
+				p.busy.Add(-1)
+				task.done <- struct{}{}
+			}
+		}()
+	}
+	return p
+}
+
+// run runs work on a worker and waits for it, signalling done, which must
+// have room for one; a nil pool runs work here.
+func (p *transcodeWorkers) run(work transcoder, done chan struct{}) {
+	if p == nil {
+		work.transcode()
+		return
+	}
+	p.tasks <- transcodeTask{work: work, done: done}
+	<-done
+}
+
+func (p *transcodeWorkers) busyWorkers() int64 {
+	if p == nil {
+		return 0
+	}
+	return p.busy.Load()
+}
+
+func (p *transcodeWorkers) queued() int {
+	if p == nil {
+		return 0
+	}
+	return len(p.tasks)
+}
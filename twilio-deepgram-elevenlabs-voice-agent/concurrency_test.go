@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/agent"
+	"github.com/agentplexus/omnivoice-examples/kit/audio"
+	"github.com/agentplexus/omnivoice-examples/kit/config"
+	"github.com/agentplexus/omnivoice-examples/kit/mock"
+)
+
+// TestSessionsEndTheirGoroutines runs calls on mock providers until they
+// are partway through a conversation, cancels them, and checks every
+// goroutine they started has returned.
+func TestSessionsEndTheirGoroutines(t *testing.T) {
+	const calls = 8
+	server := &Server{
+		agent:           agent.NewEcho(),
+		ttsProvider:     &mock.TTS{},
+		sttProvider:     &mock.STT{Script: []string{"Hi, I'm calling about my order.", "It hasn't arrived yet."}, Interval: 100 * time.Millisecond},
+		tts:             config.Default().ElevenLabs,
+		stt:             config.Default().Deepgram,
+		resampleQuality: audio.QualitySinc,
+		transportCodec:  audio.CodecMulaw,
+		dedupThreshold:  defaultDedupThreshold,
+		latency:         NewLatencyStats(),
+		outbound:        newOutboundMetrics(defaultOutboundBuffer()),
+		concurrency:     newConcurrency(defaultConcurrencyConfig()),
+		metadata:        newMetadataStore(),
+		greeting:        defaultGreetingConfig(),
+		termination:     defaultTerminationPolicy(),
+		twilio:          newTwilioClient("", ""),
+		coaching:        newCoachingHub(),
+		drain:           defaultDrainPolicy(),
+		sessions:        NewSessionManager(SessionLimits{}),
+	}
+	// The transcode workers run for the life of the process, so the
+	// baseline is taken once they have started
+	runtime.GC()
+	baseline := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ended := make(chan struct{}, calls)
+	for i := range calls {
+		conn := mock.NewConn(fmt.Sprintf("MZ%d", i), map[string]string{paramCallSID: fmt.Sprintf("CA%d", i)})
+		go func() {
+			server.handleSession(ctx, conn)
+			ended <- struct{}{}
+		}()
+		go func() { _, _ = io.Copy(io.Discard, conn.Received()) }()
+	}
+
+	// Give the calls time to hear their caller and start answering
+	time.Sleep(500 * time.Millisecond)
+	if n := runtime.NumGoroutine(); n < baseline+calls*sessionGoroutines/2 {
+		t.Fatalf("%d goroutines with %d calls up, started with %d: calls didn't start", n, calls, baseline)
+	}
+	cancel()
+	for range calls {
+		select {
+		case <-ended:
+		case <-time.After(10 * time.Second):
+			t.Fatal("session did not end once cancelled")
+		}
+	}
+
+	waitCtx, cancelWait := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelWait()
+	if n := settledGoroutines(waitCtx, baseline); n > baseline {
+		buf := make([]byte, 1<<20)
+		t.Errorf("%d goroutines left after every call ended, started with %d\n%s", n, baseline, buf[:runtime.Stack(buf, true)])
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/agentplexus/omnivoice/stt"
+)
+
+// ConfidencePolicy asks the caller to repeat themselves when the
+// recognizer isn't sure what they said, rather than have the agent answer
+// a guess.
+type ConfidencePolicy struct {
+	// Threshold is the confidence, 0 to 1, below which a final transcript
+	// isn't answered; 0 answers every transcript.
+	Threshold float64
+	// Reprompt is said instead of answering.
+	Reprompt string
+	// MaxReprompts is how many times in a row the caller is asked to
+	// repeat themselves before what they say is answered however unsure.
+	MaxReprompts int
+}
+
+// confidencePolicyFromEnv reads STT_CONFIDENCE_THRESHOLD (default 0, off),
+// STT_CONFIDENCE_REPROMPT and STT_CONFIDENCE_MAX_REPROMPTS (default 2).
+func confidencePolicyFromEnv() (ConfidencePolicy, error) {
+	p := ConfidencePolicy{Reprompt: "Sorry, could you repeat that?", MaxReprompts: 2}
+	if v := os.Getenv("STT_CONFIDENCE_THRESHOLD"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 || f > 1 {
+			return p, fmt.Errorf("invalid STT_CONFIDENCE_THRESHOLD: %q (want 0 to 1)", v)
+		}
+		p.Threshold = f
+	}
+	if v := os.Getenv("STT_CONFIDENCE_REPROMPT"); v != "" {
+		p.Reprompt = v
+	}
+	if v := os.Getenv("STT_CONFIDENCE_MAX_REPROMPTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return p, fmt.Errorf("invalid STT_CONFIDENCE_MAX_REPROMPTS: %q", v)
+		}
+		p.MaxReprompts = n
+	}
+	return p, nil
+}
+
+// ConfidenceGate applies a ConfidencePolicy to every call and counts how
+// confident the recognizer was and how often callers were asked to repeat
+// themselves. A nil gate answers every transcript.
+type ConfidenceGate struct {
+	policy ConfidencePolicy
+
+	mu sync.Mutex
+	// scored counts final transcripts with a confidence, sum their
+	// confidence and low those below the threshold.
+	scored    int
+	sum       float64
+	low       int
+	reprompts int
+}
+
+// NewConfidenceGate returns a gate applying policy.
+func NewConfidenceGate(policy ConfidencePolicy) *ConfidenceGate {
+	return &ConfidenceGate{policy: policy}
+}
+
+// track returns a call's track of its transcripts' confidence.
+func (g *ConfidenceGate) track() *confidenceTrack {
+	if g == nil {
+		return nil
+	}
+	return &confidenceTrack{gate: g}
+}
+
+// ServeHTTP reports the transcripts scored, their mean confidence and the
+// reprompt rate as JSON.
+func (g *ConfidenceGate) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mu.Lock()
+	stats := map[string]any{
+		"threshold":      g.policy.Threshold,
+		"transcripts":    g.scored,
+		"low_confidence": g.low,
+		"reprompts":      g.reprompts,
+	}
+	if g.scored > 0 {
+		stats["mean_confidence"] = g.sum / float64(g.scored)
+		stats["reprompt_rate"] = float64(g.reprompts) / float64(g.scored)
+	}
+	g.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		slog.Error("failed to write confidence stats", "error", err)
+	}
+}
+
+// scoredTranscript is a final transcript as the recognizer scored it.
+// Providers that don't score their transcripts leave scored false.
+type scoredTranscript struct {
+	text       string
+	confidence float64
+	scored     bool
+}
+
+// confidenceTrack pairs a call's final transcripts with the confidence
+// the recognizer gave them, and asks the caller to repeat what it wasn't
+// sure of. A nil track answers every transcript.
+type confidenceTrack struct {
+	gate *ConfidenceGate
+
+	mu sync.Mutex
+	// heard holds the final transcripts not yet answered, oldest first.
+	heard []scoredTranscript
+	// reprompts is how many times in a row the caller has been asked to
+	// repeat themselves.
+	reprompts int
+}
+
+// stt returns p with its final transcripts' confidence kept for the track.
+func (t *confidenceTrack) stt(p stt.StreamingProvider) stt.StreamingProvider {
+	if t == nil {
+		return p
+	}
+	return &scoredSTT{StreamingProvider: p, track: t}
+}
+
+// scored records the confidence of a final transcript.
+func (t *confidenceTrack) scored(event stt.StreamEvent) {
+	h := scoredTranscript{text: event.Transcript}
+	if event.Segment != nil && event.Segment.Confidence > 0 {
+		h.confidence, h.scored = event.Segment.Confidence, true
+	}
+	t.mu.Lock()
+	t.heard = append(t.heard, h)
+	t.mu.Unlock()
+
+	if h.scored {
+		g := t.gate
+		g.mu.Lock()
+		g.scored++
+		g.sum += h.confidence
+		if h.confidence < g.policy.Threshold {
+			g.low++
+		}
+		g.mu.Unlock()
+	}
+}
+
+// Heard returns how the final transcript text was scored. Transcripts
+// scored before it that never reached the session, such as those lost as
+// a stream dropped, are skipped.
+func (t *confidenceTrack) Heard(text string) scoredTranscript {
+	if t == nil {
+		return scoredTranscript{text: text}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for i, h := range t.heard {
+		if h.text == text {
+			t.heard = t.heard[i+1:]
+			return h
+		}
+	}
+	return scoredTranscript{text: text}
+}
+
+// Reprompt reports whether the caller should be asked to repeat what they
+// said instead of having it answered, counting the reprompt if so.
+func (t *confidenceTrack) Reprompt(h scoredTranscript) bool {
+	if t == nil {
+		return false
+	}
+	policy := t.gate.policy
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !h.scored || h.confidence >= policy.Threshold || t.reprompts >= policy.MaxReprompts || policy.Reprompt == "" {
+		t.reprompts = 0
+		return false
+	}
+	t.reprompts++
+	t.gate.mu.Lock()
+	t.gate.reprompts++
+	t.gate.mu.Unlock()
+	return true
+}
+
+// scoredSTT keeps the confidence of a stream's final transcripts for a
+// confidence track.
+type scoredSTT struct {
+	stt.StreamingProvider
+	track *confidenceTrack
+}
+
+// TranscribeStream opens a stream, scoring its final transcripts.
+func (p *scoredSTT) TranscribeStream(ctx context.Context, config stt.TranscriptionConfig) (io.WriteCloser, <-chan stt.StreamEvent, error) {
+	w, events, err := p.StreamingProvider.TranscribeStream(ctx, config)
+	if err != nil {
+		return nil, nil, err
+	}
+	scored := make(chan stt.StreamEvent, cap(events))
+	go func() {
+		defer close(scored)
+		for event := range events {
+			if event.Type == stt.EventTranscript && event.IsFinal && event.Transcript != "" {
+				p.track.scored(event)
+			}
+			select {
+			case scored <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return w, scored, nil
+}
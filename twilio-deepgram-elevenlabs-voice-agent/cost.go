@@ -0,0 +1,387 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/agentplexus/omnivoice-examples/kit/llm"
+	"github.com/agentplexus/omnivoice/stt"
+	"github.com/agentplexus/omnivoice/tts"
+)
+
+// TokenPrice is a language model's price per million tokens.
+type TokenPrice struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+// PricingTable prices provider usage. Each table is keyed by
+// "provider/model", or by provider alone for its other models.
+type PricingTable struct {
+	Currency string `json:"currency"`
+	// STT is per minute of audio streamed.
+	STT map[string]float64 `json:"stt"`
+	// TTS is per 1000 characters synthesized.
+	TTS map[string]float64 `json:"tts"`
+	// LLM is per million tokens.
+	LLM map[string]TokenPrice `json:"llm"`
+}
+
+// defaultPricing returns pay-as-you-go list prices in US dollars, as
+// published when this was written. Plans and volume discounts differ, so
+// set your own with PRICING_FILE.
+func defaultPricing() PricingTable {
+	return PricingTable{
+		Currency: "USD",
+		STT: map[string]float64{
+			"deepgram": 0.0059,
+			"mock":     0,
+			"replay":   0,
+		},
+		TTS: map[string]float64{
+			"elevenlabs": 0.30,
+			"mock":       0,
+		},
+		LLM: map[string]TokenPrice{
+			"anthropic":                  {Input: 3, Output: 15}, // claude-sonnet-4-5
+			"anthropic/claude-haiku-4-5": {Input: 1, Output: 5},
+			"openai":                     {Input: 0.15, Output: 0.60}, // gpt-4o-mini
+			"openai/gpt-4o":              {Input: 2.50, Output: 10},
+			"gemini":                     {Input: 0.30, Output: 2.50}, // gemini-2.5-flash
+			"ollama":                     {},
+		},
+	}
+}
+
+// pricingFromEnv applies PRICING_FILE, a JSON pricing table, over the
+// defaults: its entries replace or add to theirs.
+func pricingFromEnv() (PricingTable, error) {
+	pricing := defaultPricing()
+	path := os.Getenv("PRICING_FILE")
+	if path == "" {
+		return pricing, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return pricing, fmt.Errorf("invalid PRICING_FILE: %w", err)
+	}
+	var file PricingTable
+	if err := json.Unmarshal(data, &file); err != nil {
+		return pricing, fmt.Errorf("invalid PRICING_FILE: %s: %w", path, err)
+	}
+	if file.Currency != "" {
+		pricing.Currency = file.Currency
+	}
+	maps.Copy(pricing.STT, file.STT)
+	maps.Copy(pricing.TTS, file.TTS)
+	maps.Copy(pricing.LLM, file.LLM)
+	return pricing, nil
+}
+
+// unpriced remembers usage keys already warned about.
+var unpriced sync.Map
+
+// lookupPrice finds key's price, falling back to its provider's.
+func lookupPrice[P any](table map[string]P, kind, key string) P {
+	if p, ok := table[key]; ok {
+		return p
+	}
+	provider, _, _ := strings.Cut(key, "/")
+	p, ok := table[provider]
+	if !ok || provider != key {
+		if _, warned := unpriced.LoadOrStore(kind+":"+key, true); !warned {
+			if ok {
+				slog.Warn("no price for model, using its provider's", "usage", kind, "model", key)
+			} else {
+				slog.Warn("no price for provider, counting it as free", "usage", kind, "provider", key)
+			}
+		}
+	}
+	return p
+}
+
+// usageKey names a provider's model for pricing.
+func usageKey(provider, model string) string {
+	if model == "" {
+		return provider
+	}
+	return provider + "/" + model
+}
+
+// CallCost accumulates one call's provider usage as it happens.
+type CallCost struct {
+	mu         sync.Mutex
+	sttSeconds map[string]float64
+	ttsChars   map[string]int
+	llmTokens  map[string]llm.Usage
+}
+
+func newCallCost() *CallCost {
+	return &CallCost{
+		sttSeconds: make(map[string]float64),
+		ttsChars:   make(map[string]int),
+		llmTokens:  make(map[string]llm.Usage),
+	}
+}
+
+type callCostContextKey struct{}
+
+// withCallCost returns a context whose provider calls are charged to cost.
+func withCallCost(ctx context.Context, cost *CallCost) context.Context {
+	return context.WithValue(ctx, callCostContextKey{}, cost)
+}
+
+// callCostFrom returns the call ctx's provider calls are charged to, or
+// nil.
+func callCostFrom(ctx context.Context) *CallCost {
+	cost, _ := ctx.Value(callCostContextKey{}).(*CallCost)
+	return cost
+}
+
+// AddSTT records audio streamed for transcription.
+func (c *CallCost) AddSTT(key string, seconds float64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sttSeconds[key] += seconds
+}
+
+// AddTTS records characters sent for synthesis.
+func (c *CallCost) AddTTS(key string, chars int) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ttsChars[key] += chars
+}
+
+// AddLLM records a language model request's tokens.
+func (c *CallCost) AddLLM(key string, usage llm.Usage) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	total := c.llmTokens[key]
+	total.InputTokens += usage.InputTokens
+	total.OutputTokens += usage.OutputTokens
+	c.llmTokens[key] = total
+}
+
+// CostSummary is provider usage and what it cost.
+type CostSummary struct {
+	STTMinutes      float64 `json:"stt_minutes"`
+	TTSCharacters   int     `json:"tts_characters"`
+	LLMInputTokens  int     `json:"llm_input_tokens"`
+	LLMOutputTokens int     `json:"llm_output_tokens"`
+	STT             float64 `json:"stt"`
+	TTS             float64 `json:"tts"`
+	LLM             float64 `json:"llm"`
+	Total           float64 `json:"total"`
+	Currency        string  `json:"currency"`
+}
+
+// Summary prices the call's usage so far.
+func (c *CallCost) Summary(pricing PricingTable) CostSummary {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := CostSummary{Currency: pricing.Currency}
+	for key, seconds := range c.sttSeconds {
+		s.STTMinutes += seconds / 60
+		s.STT += seconds / 60 * lookupPrice(pricing.STT, "stt", key)
+	}
+	for key, chars := range c.ttsChars {
+		s.TTSCharacters += chars
+		s.TTS += float64(chars) / 1000 * lookupPrice(pricing.TTS, "tts", key)
+	}
+	for key, usage := range c.llmTokens {
+		price := lookupPrice(pricing.LLM, "llm", key)
+		s.LLMInputTokens += usage.InputTokens
+		s.LLMOutputTokens += usage.OutputTokens
+		s.LLM += (float64(usage.InputTokens)*price.Input + float64(usage.OutputTokens)*price.Output) / 1e6
+	}
+	s.Total = s.STT + s.TTS + s.LLM
+	return s
+}
+
+// add accumulates another summary.
+func (s *CostSummary) add(o CostSummary) {
+	s.STTMinutes += o.STTMinutes
+	s.TTSCharacters += o.TTSCharacters
+	s.LLMInputTokens += o.LLMInputTokens
+	s.LLMOutputTokens += o.LLMOutputTokens
+	s.STT += o.STT
+	s.TTS += o.TTS
+	s.LLM += o.LLM
+	s.Total += o.Total
+}
+
+// CostStats totals call costs across sessions, overall and by tenant, for
+// /stats/cost.
+type CostStats struct {
+	currency string
+
+	mu       sync.Mutex
+	calls    int
+	total    CostSummary
+	byTenant map[string]*tenantCost
+}
+
+type tenantCost struct {
+	Calls int `json:"calls"`
+	CostSummary
+}
+
+// NewCostStats creates empty totals in pricing's currency.
+func NewCostStats(pricing PricingTable) *CostStats {
+	return &CostStats{
+		currency: pricing.Currency,
+		total:    CostSummary{Currency: pricing.Currency},
+		byTenant: make(map[string]*tenantCost),
+	}
+}
+
+// record adds a finished call's cost. Calls without a tenant are counted
+// under "default".
+func (s *CostStats) record(tenant string, cost CostSummary) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	s.total.add(cost)
+	if tenant == "" {
+		tenant = "default"
+	}
+	t, ok := s.byTenant[tenant]
+	if !ok {
+		t = &tenantCost{CostSummary: CostSummary{Currency: s.currency}}
+		s.byTenant[tenant] = t
+	}
+	t.Calls++
+	t.add(cost)
+}
+
+// ServeHTTP reports the totals as JSON.
+func (s *CostStats) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	report := map[string]any{
+		"calls":     s.calls,
+		"total":     s.total,
+		"by_tenant": s.byTenant,
+	}
+	if s.calls > 0 {
+		report["average_per_call"] = s.total.Total / float64(s.calls)
+	}
+	data, err := json.Marshal(report)
+	s.mu.Unlock()
+	if err != nil {
+		slog.Error("failed to encode cost stats", "error", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(data)
+}
+
+// meteredSTT charges the audio streamed to a provider to a call.
+type meteredSTT struct {
+	stt.StreamingProvider
+	cost *CallCost
+	key  string
+}
+
+// TranscribeStream opens a stream, counting the audio written to it.
+func (p *meteredSTT) TranscribeStream(ctx context.Context, config stt.TranscriptionConfig) (io.WriteCloser, <-chan stt.StreamEvent, error) {
+	w, events, err := p.StreamingProvider.TranscribeStream(ctx, config)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &meteredWriter{WriteCloser: w, p: p, rate: audioBytesPerSecond(config)}, events, nil
+}
+
+type meteredWriter struct {
+	io.WriteCloser
+	p    *meteredSTT
+	rate float64
+}
+
+func (w *meteredWriter) Write(b []byte) (int, error) {
+	n, err := w.WriteCloser.Write(b)
+	w.p.cost.AddSTT(w.p.key, float64(n)/w.rate)
+	return n, err
+}
+
+// audioBytesPerSecond returns a stream's audio rate. Streams that don't
+// give their format are taken to be 8kHz mu-law, as Twilio sends.
+func audioBytesPerSecond(config stt.TranscriptionConfig) float64 {
+	rate := float64(config.SampleRate)
+	if rate == 0 {
+		rate = 8000
+	}
+	rate *= float64(max(config.Channels, 1))
+	if config.Encoding == "linear16" {
+		rate *= 2
+	}
+	return rate
+}
+
+// meteredTTS charges the text synthesized by a provider to a call.
+type meteredTTS struct {
+	tts.StreamingProvider
+	cost *CallCost
+	key  string
+}
+
+func (p *meteredTTS) Synthesize(ctx context.Context, text string, config tts.SynthesisConfig) (*tts.SynthesisResult, error) {
+	p.cost.AddTTS(p.key, utf8.RuneCountInString(text))
+	return p.StreamingProvider.Synthesize(ctx, text, config)
+}
+
+func (p *meteredTTS) SynthesizeStream(ctx context.Context, text string, config tts.SynthesisConfig) (<-chan tts.StreamChunk, error) {
+	p.cost.AddTTS(p.key, utf8.RuneCountInString(text))
+	return p.StreamingProvider.SynthesizeStream(ctx, text, config)
+}
+
+func (p *meteredTTS) SynthesizeFromReader(ctx context.Context, reader io.Reader, config tts.SynthesisConfig) (<-chan tts.StreamChunk, error) {
+	return p.StreamingProvider.SynthesizeFromReader(ctx, &countingReader{Reader: reader, p: p}, config)
+}
+
+// countingReader charges the characters read through it.
+type countingReader struct {
+	io.Reader
+	p *meteredTTS
+}
+
+func (r *countingReader) Read(b []byte) (int, error) {
+	n, err := r.Reader.Read(b)
+	r.p.cost.AddTTS(r.p.key, utf8.RuneCount(b[:n]))
+	return n, err
+}
+
+// meteredLLM charges each request's tokens to the call in its context.
+type meteredLLM struct {
+	llm.Provider
+	key string
+}
+
+func (p *meteredLLM) Stream(ctx context.Context, req llm.Request, onText func(text string)) (*llm.Response, error) {
+	resp, err := p.Provider.Stream(ctx, req, onText)
+	if resp != nil {
+		callCostFrom(ctx).AddLLM(p.key, resp.Usage)
+	}
+	return resp, err
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/crm"
+)
+
+// CallerLookup finds who is calling in a CRM as each call starts, so the
+// agent can be briefed on them before it greets them.
+type CallerLookup struct {
+	Directory crm.Directory
+	// Timeout bounds each lookup; a call whose lookup fails or times out
+	// goes ahead without a profile.
+	Timeout time.Duration
+}
+
+// callerLookupFromEnv builds the caller lookup. CRM_FILE names a JSON file
+// mapping numbers to profiles, CRM_URL an API answering GET ?number=...
+// with a profile or 404, authenticated with CRM_API_KEY as a bearer token
+// if set. CRM_TIMEOUT bounds each lookup (default 1s). It returns nil if
+// neither CRM_FILE nor CRM_URL is set.
+func callerLookupFromEnv() (*CallerLookup, error) {
+	var dirs crm.Directories
+	if path := os.Getenv("CRM_FILE"); path != "" {
+		dir, err := crm.LoadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CRM_FILE: %w", err)
+		}
+		dirs = append(dirs, dir)
+	}
+	if u := os.Getenv("CRM_URL"); u != "" {
+		dir := &crm.HTTPDirectory{URL: u}
+		if key := os.Getenv("CRM_API_KEY"); key != "" {
+			dir.Header = http.Header{"Authorization": {"Bearer " + key}}
+		}
+		dirs = append(dirs, dir)
+	}
+	if len(dirs) == 0 {
+		return nil, nil
+	}
+
+	l := &CallerLookup{Directory: dirs, Timeout: time.Second}
+	if v := os.Getenv("CRM_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid CRM_TIMEOUT: %q", v)
+		}
+		l.Timeout = d
+	}
+	return l, nil
+}
+
+// Lookup returns the profile of the caller from number, or nil if they
+// aren't known, withheld their number, or the lookup failed.
+func (l *CallerLookup) Lookup(ctx context.Context, number string, logger *slog.Logger) *crm.Profile {
+	if l == nil || !strings.HasPrefix(number, "+") {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, l.Timeout)
+	defer cancel()
+	start := time.Now()
+	profile, err := l.Directory.Lookup(ctx, number)
+	if err != nil {
+		logger.Warn("caller lookup failed", "error", err, "duration", time.Since(start))
+		return nil
+	}
+	if profile != nil {
+		logger.Info("caller identified", "account_id", profile.AccountID, "tier", profile.Tier, "duration", time.Since(start))
+	}
+	return profile
+}
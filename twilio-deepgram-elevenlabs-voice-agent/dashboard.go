@@ -0,0 +1,357 @@
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/storage"
+)
+
+// archiveListLimit is how many archived calls are listed unless ?limit=
+// says otherwise; archiveListMax is the most that can be asked for.
+const (
+	archiveListLimit = 20
+	archiveListMax   = 200
+)
+
+var (
+	// archiveKeyPart matches a call SID, session ID or start time as it
+	// appears in an archive key.
+	archiveKeyPart = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+	// archiveAudioName matches the name of an archived recording or
+	// voicemail.
+	archiveAudioName = regexp.MustCompile(`^(recording|voicemail)-[A-Za-z0-9]+\.(mp3|ogg|wav)$`)
+)
+
+// ArchivedCall is one session of a call as kept in the call archive.
+type ArchivedCall struct {
+	CallSID string `json:"call_sid"`
+	// Started is the session's start as it appears in its keys.
+	Started    string            `json:"started"`
+	CDR        *CallDetailRecord `json:"cdr,omitempty"`
+	Transcript []TranscriptLine  `json:"transcript,omitempty"`
+	// Audio names the call's recordings and voicemails in the archive.
+	Audio []string `json:"audio,omitempty"`
+}
+
+// archivedCalls lists the latest sessions in the call archive, newest
+// first, with their CDRs: at most ?limit= (default 20), of the call ?call=
+// if given.
+func (a *adminAPI) archivedCalls(w http.ResponseWriter, r *http.Request) {
+	if a.archive == nil {
+		http.Error(w, "call archive is off or can't be read", http.StatusNotFound)
+		return
+	}
+	limit := archiveListLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		limit = min(n, archiveListMax)
+	}
+	prefix := ""
+	if call := r.URL.Query().Get("call"); call != "" {
+		if !archiveKeyPart.MatchString(call) {
+			http.Error(w, "invalid call", http.StatusBadRequest)
+			return
+		}
+		prefix = call + "/"
+	}
+
+	keys, err := a.archive.List(r.Context(), prefix)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	var calls []ArchivedCall
+	for _, key := range keys {
+		parts := strings.Split(key, "/")
+		if len(parts) == 3 && parts[2] == "cdr.json" {
+			calls = append(calls, ArchivedCall{CallSID: parts[0], Started: parts[1]})
+		}
+	}
+	slices.SortFunc(calls, func(x, y ArchivedCall) int {
+		return cmp.Or(strings.Compare(y.Started, x.Started), strings.Compare(x.CallSID, y.CallSID))
+	})
+	calls = calls[:min(limit, len(calls))]
+	for i := range calls {
+		// A CDR that can't be read leaves the session listed without one
+		_ = a.readArchived(r, calls[i].CallSID+"/"+calls[i].Started+"/cdr.json", &calls[i].CDR)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"calls": calls})
+}
+
+// archivedCall returns one archived session with its CDR, transcript and
+// the call's recordings.
+func (a *adminAPI) archivedCall(w http.ResponseWriter, r *http.Request) {
+	if a.archive == nil {
+		http.Error(w, "call archive is off or can't be read", http.StatusNotFound)
+		return
+	}
+	call := ArchivedCall{CallSID: r.PathValue("call"), Started: r.PathValue("started")}
+	if !archiveKeyPart.MatchString(call.CallSID) || !archiveKeyPart.MatchString(call.Started) {
+		http.Error(w, "invalid call", http.StatusBadRequest)
+		return
+	}
+	prefix := call.CallSID + "/" + call.Started + "/"
+	if err := a.readArchived(r, prefix+"cdr.json", &call.CDR); err != nil {
+		status := http.StatusBadGateway
+		if errors.Is(err, storage.ErrNotFound) {
+			status = http.StatusNotFound
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	_ = a.readArchived(r, prefix+"transcript.json", &call.Transcript)
+	if keys, err := a.archive.List(r.Context(), call.CallSID+"/"); err == nil {
+		for _, key := range keys {
+			if name := strings.TrimPrefix(key, call.CallSID+"/"); archiveAudioName.MatchString(name) {
+				call.Audio = append(call.Audio, name)
+			}
+		}
+	}
+	writeJSON(w, http.StatusOK, call)
+}
+
+// archivedAudio serves an archived recording or voicemail of a call.
+func (a *adminAPI) archivedAudio(w http.ResponseWriter, r *http.Request) {
+	if a.archive == nil {
+		http.Error(w, "call archive is off or can't be read", http.StatusNotFound)
+		return
+	}
+	call, name := r.PathValue("call"), r.PathValue("name")
+	if !archiveKeyPart.MatchString(call) || !archiveAudioName.MatchString(name) {
+		http.Error(w, "invalid recording", http.StatusBadRequest)
+		return
+	}
+	data, err := a.archive.Get(r.Context(), call+"/"+name)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, "unknown recording", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", recordingContentType(path.Ext(name)[1:]))
+	http.ServeContent(w, r, name, time.Time{}, bytes.NewReader(data))
+}
+
+// readArchived reads the JSON object under key into v.
+func (a *adminAPI) readArchived(r *http.Request, key string, v any) error {
+	data, err := a.archive.Get(r.Context(), key)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// handleDashboard serves the dashboard page. It holds no data of its own;
+// the page asks for the admin token and reads everything from the admin
+// API.
+func handleDashboard(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write([]byte(dashboardHTML))
+}
+
+// dashboardHTML lists the calls in progress, following one's live
+// captions, and the calls in the archive, with their transcripts and
+// recordings.
+const dashboardHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Voice agent calls</title>
+<style>
+  body { font-family: system-ui, sans-serif; margin: 2rem; max-width: 64rem; }
+  table { border-collapse: collapse; width: 100%; }
+  th, td { text-align: left; padding: .35rem .6rem; border-bottom: 1px solid #ddd; }
+  tbody tr { cursor: pointer; }
+  tbody tr:hover { background: #f5f8ff; }
+  .line { padding: .4rem .75rem; margin: .35rem 0; border-radius: .25rem; }
+  .caller { background: #f3f3f3; }
+  .agent { background: #e3f0ff; }
+  .interim { color: #777; font-style: italic; }
+  .speaker { font-weight: 600; margin-right: .5rem; }
+  .muted { color: #888; }
+  #token { width: 24rem; }
+</style>
+</head>
+<body>
+<h1>Calls</h1>
+<p><label>Admin token <input id="token" type="password" autocomplete="off"></label></p>
+
+<h2>In progress</h2>
+<table>
+<thead><tr><th>Call</th><th>From</th><th>To</th><th>Mode</th><th>Started</th></tr></thead>
+<tbody id="live"></tbody>
+</table>
+
+<h2>Archive</h2>
+<table>
+<thead><tr><th>Call</th><th>Started</th><th>Duration</th><th>Turns</th><th>Ended by</th></tr></thead>
+<tbody id="archive"></tbody>
+</table>
+
+<h2 id="title"></h2>
+<div id="audio"></div>
+<div id="transcript"></div>
+
+<script>
+  const tokenInput = document.getElementById("token");
+  tokenInput.value = sessionStorage.getItem("adminToken") || "";
+  tokenInput.onchange = () => { sessionStorage.setItem("adminToken", tokenInput.value); refresh(); };
+  const auth = () => ({ Authorization: "Bearer " + tokenInput.value });
+
+  async function api(path) {
+    const res = await fetch(path, { headers: auth() });
+    if (!res.ok) throw new Error(path + ": " + res.status + " " + (await res.text()));
+    return res;
+  }
+
+  function cell(row, text) {
+    const td = document.createElement("td");
+    td.textContent = text;
+    row.appendChild(td);
+  }
+
+  function show(body, items, columns, choose) {
+    body.replaceChildren();
+    if (items.length === 0) {
+      const row = body.insertRow();
+      cell(row, "None");
+      row.className = "muted";
+    }
+    for (const item of items) {
+      const row = body.insertRow();
+      columns(item).forEach((text) => cell(row, text));
+      row.onclick = () => choose(item);
+    }
+  }
+
+  const when = (t) => t ? new Date(t).toLocaleString() : "";
+
+  async function refresh() {
+    if (!tokenInput.value) return;
+    try {
+      const live = await (await api("/admin/sessions")).json();
+      show(document.getElementById("live"), live.sessions,
+        (s) => [s.call_sid || s.id, s.from || "", s.to || "", s.mode, when(s.started_at)], follow);
+    } catch (err) {
+      console.error(err);
+    }
+    try {
+      const archive = await (await api("/admin/calls")).json();
+      show(document.getElementById("archive"), archive.calls || [],
+        (c) => [c.call_sid, when(c.cdr && c.cdr.started_at),
+          c.cdr ? Math.round(c.cdr.duration_seconds) + "s" : "", c.cdr ? c.cdr.turns : "", c.cdr ? c.cdr.ended_by : ""],
+        review);
+    } catch (err) {
+      console.error(err);
+    }
+  }
+
+  const transcript = document.getElementById("transcript");
+  let following = null;
+
+  function line(speaker, text, interim) {
+    const div = document.createElement("div");
+    div.className = "line " + speaker + (interim ? " interim" : "");
+    const who = document.createElement("span");
+    who.className = "speaker";
+    who.textContent = speaker;
+    div.append(who, document.createTextNode(text));
+    transcript.appendChild(div);
+    return div;
+  }
+
+  function open(title) {
+    if (following) following.abort();
+    following = null;
+    document.getElementById("title").textContent = title;
+    document.getElementById("audio").replaceChildren();
+    transcript.replaceChildren();
+  }
+
+  // follow streams a live call's captions, showing each caller's interim
+  // caption in place until it is final.
+  async function follow(session) {
+    open("Live: " + (session.call_sid || session.id));
+    const ctrl = new AbortController();
+    following = ctrl;
+    const pending = {};
+    const caption = (c) => {
+      if (pending[c.speaker]) pending[c.speaker].remove();
+      delete pending[c.speaker];
+      const div = line(c.speaker + (c.target ? " to " + c.target : ""), c.text, !c.final);
+      if (!c.final) pending[c.speaker] = div;
+    };
+    try {
+      const res = await fetch("/admin/sessions/" + encodeURIComponent(session.id) + "/captions",
+        { headers: auth(), signal: ctrl.signal });
+      if (!res.ok) throw new Error(res.status + " " + (await res.text()));
+      const reader = res.body.pipeThrough(new TextDecoderStream()).getReader();
+      let buffer = "";
+      for (;;) {
+        const { value, done } = await reader.read();
+        if (done) break;
+        buffer += value;
+        let end;
+        while ((end = buffer.indexOf("\n\n")) >= 0) {
+          const block = buffer.slice(0, end);
+          buffer = buffer.slice(end + 2);
+          let name = "", data = "";
+          for (const field of block.split("\n")) {
+            if (field.startsWith("event: ")) name = field.slice(7);
+            if (field.startsWith("data: ")) data += field.slice(6);
+          }
+          if (name === "caption") caption(JSON.parse(data));
+          if (name === "end") {
+            line("", "Call ended", true);
+            refresh();
+          }
+        }
+      }
+    } catch (err) {
+      if (err.name !== "AbortError") line("", String(err), true);
+    }
+  }
+
+  // review shows an archived call's transcript and plays its recordings,
+  // fetched with the token.
+  async function review(call) {
+    open("Archived: " + call.call_sid + " " + when(call.cdr && call.cdr.started_at));
+    try {
+      const detail = await (await api("/admin/calls/" + encodeURIComponent(call.call_sid) + "/" + encodeURIComponent(call.started))).json();
+      for (const l of detail.transcript || []) line(l.speaker + (l.target ? " to " + l.target : ""), l.text, false);
+      for (const name of detail.audio || []) {
+        const blob = await (await api("/admin/calls/" + encodeURIComponent(call.call_sid) + "/audio/" + encodeURIComponent(name))).blob();
+        const player = document.createElement("audio");
+        player.controls = true;
+        player.src = URL.createObjectURL(blob);
+        const label = document.createElement("div");
+        label.textContent = name;
+        document.getElementById("audio").append(label, player);
+      }
+    } catch (err) {
+      line("", String(err), true);
+    }
+  }
+
+  refresh();
+  setInterval(refresh, 5000);
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// defaultDedupThreshold is the word-level similarity at or above which a
+// sentence is treated as a repeat.
+const defaultDedupThreshold = 0.85
+
+// sentenceDeduper drops sentences that closely match one already spoken.
+// LLMs sometimes repeat themselves, and a streaming chunker may re-emit a
+// sentence after a retry; either way the caller would hear it twice.
+type sentenceDeduper struct {
+	threshold float64
+
+	mu     sync.Mutex
+	spoken [][]string // normalized words of each sentence spoken
+}
+
+// newSentenceDeduper creates a deduper. A threshold of 0 disables it.
+func newSentenceDeduper(threshold float64) *sentenceDeduper {
+	return &sentenceDeduper{threshold: threshold}
+}
+
+// Filter returns text with duplicate sentences removed, plus the sentences
+// that were dropped. Kept sentences are remembered for later comparisons.
+func (d *sentenceDeduper) Filter(text string) (string, []string) {
+	if d.threshold <= 0 {
+		return text, nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var kept, dropped []string
+	for _, sentence := range splitSentences(text) {
+		words := normalizeWords(sentence)
+		if len(words) > 0 && d.isDuplicate(words) {
+			dropped = append(dropped, sentence)
+			continue
+		}
+		kept = append(kept, sentence)
+		if len(words) > 0 {
+			d.spoken = append(d.spoken, words)
+		}
+	}
+	return strings.Join(kept, " "), dropped
+}
+
+// Forget un-remembers the sentences of text, which was filtered but never
+// spoken (synthesis failed or it was cleared), so a retry can say it.
+func (d *sentenceDeduper) Forget(text string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, sentence := range splitSentences(text) {
+		words := normalizeWords(sentence)
+		for i := len(d.spoken) - 1; i >= 0; i-- {
+			if slices.Equal(d.spoken[i], words) {
+				d.spoken = slices.Delete(d.spoken, i, i+1)
+				break
+			}
+		}
+	}
+}
+
+// Reset forgets everything spoken so far.
+func (d *sentenceDeduper) Reset() {
+	d.mu.Lock()
+	d.spoken = nil
+	d.mu.Unlock()
+}
+
+func (d *sentenceDeduper) isDuplicate(words []string) bool {
+	for _, prev := range d.spoken {
+		if wordSimilarity(words, prev) >= d.threshold {
+			return true
+		}
+	}
+	return false
+}
+
+// splitSentences splits text after sentence-ending punctuation that is
+// followed by whitespace, so "$42.50" and "e.g." mid-word stay intact.
+func splitSentences(text string) []string {
+	var sentences []string
+	start := 0
+	runes := []rune(text)
+	for i, r := range runes {
+		if r != '.' && r != '!' && r != '?' {
+			continue
+		}
+		if i+1 < len(runes) && !unicode.IsSpace(runes[i+1]) {
+			continue
+		}
+		if s := strings.TrimSpace(string(runes[start : i+1])); s != "" {
+			sentences = append(sentences, s)
+		}
+		start = i + 1
+	}
+	if s := strings.TrimSpace(string(runes[start:])); s != "" {
+		sentences = append(sentences, s)
+	}
+	return sentences
+}
+
+// normalizeWords lowercases text and splits it into words, ignoring punctuation.
+func normalizeWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+	})
+}
+
+// wordSimilarity returns 1 minus the word-level edit distance divided by the
+// longer sentence's length: 1 for identical sentences, 0 for disjoint ones.
+func wordSimilarity(a, b []string) float64 {
+	longest := max(len(a), len(b))
+	if longest == 0 {
+		return 1
+	}
+
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return 1 - float64(prev[len(b)])/float64(longest)
+}
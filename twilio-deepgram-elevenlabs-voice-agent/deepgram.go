@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+
+	dgomnivoice "github.com/agentplexus/omnivoice-deepgram/omnivoice"
+	"github.com/agentplexus/omnivoice/stt"
+	wsinterfaces "github.com/deepgram/deepgram-go-sdk/v3/pkg/api/listen/v1/websocket/interfaces"
+	interfaces "github.com/deepgram/deepgram-go-sdk/v3/pkg/client/interfaces"
+	listen "github.com/deepgram/deepgram-go-sdk/v3/pkg/client/listen"
+)
+
+// deepgramEndpoint opens Deepgram live transcription streams on one host.
+//
+// omnivoice-deepgram has no host option: the Deepgram SDK client it
+// creates takes its host from DEEPGRAM_HOST, which is process-wide. Each
+// region's streams are opened here on the SDK directly instead, with the
+// region's host in the client options, the way omnivoice-deepgram opens
+// them otherwise.
+type deepgramEndpoint struct {
+	apiKey string
+	// host is the endpoint's host, or empty for the SDK's default.
+	host string
+}
+
+// TranscribeStream starts a streaming transcription on the endpoint.
+func (e deepgramEndpoint) TranscribeStream(ctx context.Context, config stt.TranscriptionConfig) (io.WriteCloser, <-chan stt.StreamEvent, error) {
+	events := make(chan stt.StreamEvent, 100)
+	handler := &deepgramHandler{events: events, ctx: ctx}
+	options := &interfaces.ClientOptions{Host: e.host}
+	client, err := listen.NewWSUsingCallback(ctx, e.apiKey, options, dgomnivoice.ConfigToLiveTranscriptionOptions(config), handler)
+	if err == nil && client == nil {
+		err = fmt.Errorf("unsupported transcription options")
+	}
+	if err != nil {
+		close(events)
+		return nil, nil, fmt.Errorf("failed to create Deepgram client: %w", err)
+	}
+	if !client.Connect() {
+		close(events)
+		return nil, nil, fmt.Errorf("failed to connect to Deepgram")
+	}
+
+	w := &deepgramWriter{client: client, events: events, done: make(chan struct{})}
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = w.Close()
+		case <-w.done:
+		}
+	}()
+	return w, events, nil
+}
+
+// deepgramWriter sends a stream's audio to Deepgram.
+type deepgramWriter struct {
+	client interface {
+		Write(p []byte) (int, error)
+		Stop()
+	}
+	events chan stt.StreamEvent
+	done   chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+}
+
+func (w *deepgramWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	closed := w.closed
+	w.mu.Unlock()
+	if closed {
+		return 0, io.ErrClosedPipe
+	}
+	return w.client.Write(p)
+}
+
+// Close stops the stream and closes its events.
+func (w *deepgramWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	w.client.Stop()
+	close(w.done)
+	close(w.events)
+	return nil
+}
+
+// deepgramHandler turns Deepgram's callbacks into stream events, as
+// omnivoice-deepgram does.
+type deepgramHandler struct {
+	events chan stt.StreamEvent
+	ctx    context.Context
+}
+
+var _ wsinterfaces.LiveMessageCallback = (*deepgramHandler)(nil)
+
+// send delivers an event, dropping it if the session is behind.
+func (h *deepgramHandler) send(event stt.StreamEvent) error {
+	select {
+	case h.events <- event:
+	case <-h.ctx.Done():
+		return h.ctx.Err()
+	default:
+	}
+	return nil
+}
+
+func (h *deepgramHandler) Open(*wsinterfaces.OpenResponse) error { return nil }
+
+func (h *deepgramHandler) Message(mr *wsinterfaces.MessageResponse) error {
+	if mr == nil {
+		return nil
+	}
+	result := &dgomnivoice.MessageResponse{IsFinal: mr.IsFinal, Duration: mr.Duration, Start: mr.Start}
+	for _, alt := range mr.Channel.Alternatives {
+		a := dgomnivoice.Alternative{Transcript: alt.Transcript, Confidence: alt.Confidence}
+		for _, w := range alt.Words {
+			a.Words = append(a.Words, dgomnivoice.Word{
+				Word:       w.Word,
+				Start:      w.Start,
+				End:        w.End,
+				Confidence: w.Confidence,
+				Speaker:    w.Speaker,
+			})
+		}
+		result.Channel.Alternatives = append(result.Channel.Alternatives, a)
+	}
+	return h.send(dgomnivoice.MessageResponseToStreamEvent(result))
+}
+
+func (h *deepgramHandler) Metadata(*wsinterfaces.MetadataResponse) error { return nil }
+
+func (h *deepgramHandler) SpeechStarted(*wsinterfaces.SpeechStartedResponse) error {
+	return h.send(stt.StreamEvent{Type: stt.EventSpeechStart, SpeechStarted: true})
+}
+
+func (h *deepgramHandler) UtteranceEnd(*wsinterfaces.UtteranceEndResponse) error {
+	return h.send(stt.StreamEvent{Type: stt.EventSpeechEnd, SpeechEnded: true})
+}
+
+func (h *deepgramHandler) Close(*wsinterfaces.CloseResponse) error { return nil }
+
+func (h *deepgramHandler) Error(er *wsinterfaces.ErrorResponse) error {
+	if er == nil {
+		return nil
+	}
+	return h.send(stt.StreamEvent{Type: stt.EventError, Error: fmt.Errorf("deepgram error: %s", er.Description)})
+}
+
+func (h *deepgramHandler) UnhandledEvent([]byte) error { return nil }
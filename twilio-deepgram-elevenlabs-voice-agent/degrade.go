@@ -0,0 +1,579 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/twiml"
+)
+
+// DegradationLevel is a rung of the degradation ladder. Each rung asks
+// less of the providers than the one above it.
+type DegradationLevel int
+
+const (
+	// LevelFull is normal service.
+	LevelFull DegradationLevel = iota
+	// LevelBrief asks the agent for shorter replies and cuts off any
+	// that run long, so each turn spends less time in synthesis.
+	LevelBrief
+	// LevelFAQ stops consulting the agent: callers are answered from the
+	// FAQ file, or told only common questions can be answered.
+	LevelFAQ
+	// LevelVoicemail hands calls to Twilio to take a message, so neither
+	// recognition nor synthesis is needed.
+	LevelVoicemail
+)
+
+var degradationLevels = []string{"full", "brief", "faq", "voicemail"}
+
+func (l DegradationLevel) String() string {
+	if int(l) < len(degradationLevels) {
+		return degradationLevels[l]
+	}
+	return "level(" + strconv.Itoa(int(l)) + ")"
+}
+
+// MarshalText encodes the level by name.
+func (l DegradationLevel) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+// parseDegradationLevel parses a rung name.
+func parseDegradationLevel(s string) (DegradationLevel, error) {
+	i := slices.Index(degradationLevels, strings.TrimSpace(s))
+	if i < 0 {
+		return 0, fmt.Errorf("unknown level %q (want one of %s)", s, strings.Join(degradationLevels, ", "))
+	}
+	return DegradationLevel(i), nil
+}
+
+// degradationCondition is one reason to step down to a rung: a latency or
+// error-rate objective breached over its window, or failing provider
+// probes.
+type degradationCondition struct {
+	// Name is the condition as written, e.g. "p95<3s/2m" or
+	// "unhealthy:deepgram".
+	Name string
+	// SLO is the objective, unless Unhealthy is set.
+	SLO SLO
+	// Unhealthy conditions hold while Provider's probe fails, or any
+	// probe if Provider is empty.
+	Unhealthy bool
+	Provider  string
+}
+
+// DegradationRung is a level and the conditions, any one of which is
+// enough to step down to it.
+type DegradationRung struct {
+	Level      DegradationLevel
+	Conditions []degradationCondition
+}
+
+// DegradationConfig configures the degradation ladder.
+type DegradationConfig struct {
+	// Rungs are the levels below full service, in order. A level left out
+	// is skipped.
+	Rungs []DegradationRung
+	// MinSamples is how many turns an objective's window needs before it
+	// is judged; until then it counts as met.
+	MinSamples int
+	// Interval is how often the conditions are checked.
+	Interval time.Duration
+	// RecoverAfter is how long a rung's conditions must stay clear before
+	// the ladder steps back up one rung. Stepping down is immediate.
+	RecoverAfter time.Duration
+
+	// BriefSentences caps replies at the brief level.
+	BriefSentences int
+	// FAQ answers callers at the faq level; FAQFallback is said when no
+	// entry matches.
+	FAQ         []FAQEntry
+	FAQFallback string
+	// VoicemailPrompt and VoicemailClosing are spoken by Twilio before and
+	// after the caller leaves a message of up to VoicemailMaxLength.
+	VoicemailPrompt    string
+	VoicemailClosing   string
+	VoicemailMaxLength time.Duration
+}
+
+// defaultDegradationConfig returns the configuration used unless
+// overridden by DEGRADATION_LADDER, DEGRADATION_MIN_SAMPLES,
+// DEGRADATION_CHECK_INTERVAL, DEGRADATION_RECOVER_AFTER,
+// BRIEF_REPLY_SENTENCES, FAQ_FILE, FAQ_FALLBACK, VOICEMAIL_PROMPT and
+// VOICEMAIL_MAX_LENGTH. It has no rungs: the ladder is off.
+func defaultDegradationConfig() DegradationConfig {
+	return DegradationConfig{
+		MinSamples:         10,
+		Interval:           15 * time.Second,
+		RecoverAfter:       2 * time.Minute,
+		BriefSentences:     2,
+		FAQFallback:        "Sorry, I can only help with common questions right now. Please call back later for anything else.",
+		VoicemailPrompt:    "Sorry, we're having technical difficulties. Please leave your name, number and a short message after the tone, and we'll call you back.",
+		VoicemailClosing:   "Thank you, we'll be in touch. Goodbye.",
+		VoicemailMaxLength: 2 * time.Minute,
+	}
+}
+
+// degradationConfigFromEnv applies environment overrides to the defaults.
+func degradationConfigFromEnv() (DegradationConfig, error) {
+	cfg := defaultDegradationConfig()
+	if v := os.Getenv("DEGRADATION_LADDER"); v != "" {
+		rungs, err := parseDegradationLadder(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid DEGRADATION_LADDER: %w", err)
+		}
+		cfg.Rungs = rungs
+	}
+	if v := os.Getenv("DEGRADATION_MIN_SAMPLES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return cfg, fmt.Errorf("invalid DEGRADATION_MIN_SAMPLES: %q", v)
+		}
+		cfg.MinSamples = n
+	}
+	if v := os.Getenv("DEGRADATION_CHECK_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return cfg, fmt.Errorf("invalid DEGRADATION_CHECK_INTERVAL: %q", v)
+		}
+		cfg.Interval = d
+	}
+	if v := os.Getenv("DEGRADATION_RECOVER_AFTER"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return cfg, fmt.Errorf("invalid DEGRADATION_RECOVER_AFTER: %q", v)
+		}
+		cfg.RecoverAfter = d
+	}
+	if v := os.Getenv("BRIEF_REPLY_SENTENCES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return cfg, fmt.Errorf("invalid BRIEF_REPLY_SENTENCES: %q", v)
+		}
+		cfg.BriefSentences = n
+	}
+	if path := os.Getenv("FAQ_FILE"); path != "" {
+		faq, err := loadFAQ(path)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid FAQ_FILE: %w", err)
+		}
+		cfg.FAQ = faq
+	}
+	if v := os.Getenv("FAQ_FALLBACK"); v != "" {
+		cfg.FAQFallback = v
+	}
+	if v := os.Getenv("VOICEMAIL_PROMPT"); v != "" {
+		cfg.VoicemailPrompt = v
+	}
+	if v := os.Getenv("VOICEMAIL_MAX_LENGTH"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Second || d > time.Hour {
+			return cfg, fmt.Errorf("invalid VOICEMAIL_MAX_LENGTH: %q (want 1s to 1h)", v)
+		}
+		cfg.VoicemailMaxLength = d
+	}
+	for _, rung := range cfg.Rungs {
+		if rung.Level == LevelFAQ && len(cfg.FAQ) == 0 {
+			return cfg, fmt.Errorf("DEGRADATION_LADDER has a faq rung but FAQ_FILE is not set")
+		}
+	}
+	return cfg, nil
+}
+
+// parseDegradationLadder parses a comma-separated list of rungs, each
+// "level=condition|condition...", from the top of the ladder down:
+//
+//	brief=p95<2.5s/2m                    shorter replies while replies are slow
+//	faq=p95<5s/2m|error_rate<10%/2m      FAQ answers while very slow or failing
+//	voicemail=unhealthy:deepgram         take messages while Deepgram is down
+//
+// Conditions are objectives as in SLOS, or "unhealthy" for any provider
+// probe failing, or "unhealthy:name" for one. "off" or "none" is no
+// ladder.
+func parseDegradationLadder(s string) ([]DegradationRung, error) {
+	if v := strings.TrimSpace(s); v == "off" || v == "none" {
+		return nil, nil
+	}
+	var rungs []DegradationRung
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		name, conditions, ok := strings.Cut(field, "=")
+		if !ok {
+			return nil, fmt.Errorf("%q: want level=condition[|condition...]", field)
+		}
+		level, err := parseDegradationLevel(name)
+		if err != nil {
+			return nil, err
+		}
+		if level == LevelFull {
+			return nil, fmt.Errorf("%q: full service is the top of the ladder, not a rung", field)
+		}
+		if len(rungs) > 0 && level <= rungs[len(rungs)-1].Level {
+			return nil, fmt.Errorf("%q: rungs must be listed from brief down to voicemail, each once", field)
+		}
+		rung := DegradationRung{Level: level}
+		for _, c := range strings.Split(conditions, "|") {
+			condition, err := parseDegradationCondition(strings.TrimSpace(c))
+			if err != nil {
+				return nil, fmt.Errorf("%q: %w", field, err)
+			}
+			rung.Conditions = append(rung.Conditions, condition)
+		}
+		rungs = append(rungs, rung)
+	}
+	return rungs, nil
+}
+
+// parseDegradationCondition parses one condition.
+func parseDegradationCondition(s string) (degradationCondition, error) {
+	if s == "unhealthy" {
+		return degradationCondition{Name: s, Unhealthy: true}, nil
+	}
+	if provider, ok := strings.CutPrefix(s, "unhealthy:"); ok {
+		if provider == "" {
+			return degradationCondition{}, fmt.Errorf("%q: missing provider", s)
+		}
+		return degradationCondition{Name: s, Unhealthy: true, Provider: provider}, nil
+	}
+	slo, err := parseSLO(s)
+	if err != nil {
+		return degradationCondition{}, err
+	}
+	return degradationCondition{Name: s, SLO: slo}, nil
+}
+
+// FAQEntry is a canned answer given at the faq level when the caller
+// mentions its keywords.
+type FAQEntry struct {
+	Title    string   `json:"title"`
+	Keywords []string `json:"keywords"`
+	Answer   string   `json:"answer"`
+}
+
+// loadFAQ reads FAQ entries from a JSON file.
+func loadFAQ(path string) ([]FAQEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var faq []FAQEntry
+	if err := json.Unmarshal(data, &faq); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for _, entry := range faq {
+		if len(entry.Keywords) == 0 || strings.TrimSpace(entry.Answer) == "" {
+			return nil, fmt.Errorf("%s: entry %q needs keywords and an answer", path, entry.Title)
+		}
+	}
+	return faq, nil
+}
+
+// DegradationConditionStatus is a condition's standing at the last check.
+type DegradationConditionStatus struct {
+	Condition string `json:"condition"`
+	Breached  bool   `json:"breached"`
+	// Detail is the value measured, or the providers failing.
+	Detail string `json:"detail,omitempty"`
+}
+
+// DegradationRungStatus is a rung's conditions at the last check.
+type DegradationRungStatus struct {
+	Level      DegradationLevel             `json:"level"`
+	Breached   bool                         `json:"breached"`
+	Conditions []DegradationConditionStatus `json:"conditions"`
+}
+
+// DegradationLadder walks service down the rungs as provider health or
+// turn latency worsens and back up, one rung at a time, as it recovers.
+// A nil ladder keeps full service.
+type DegradationLadder struct {
+	cfg DegradationConfig
+	// samples holds the turns the ladder's objectives are judged over;
+	// nil if no rung has an objective.
+	samples *SLOMonitor
+	health  *HealthChecker
+	now     func() time.Time
+
+	mu          sync.Mutex
+	level       DegradationLevel
+	since       time.Time
+	clearSince  time.Time // when the current rung's conditions were first found clear
+	transitions int
+	timeAt      map[DegradationLevel]time.Duration // before the current level
+	rungs       []DegradationRungStatus
+}
+
+// NewDegradationLadder creates a ladder for cfg, checking provider health
+// with health, or returns nil if cfg has no rungs.
+func NewDegradationLadder(cfg DegradationConfig, health *HealthChecker) *DegradationLadder {
+	if len(cfg.Rungs) == 0 {
+		return nil
+	}
+	var objectives []SLO
+	for _, rung := range cfg.Rungs {
+		for _, c := range rung.Conditions {
+			if !c.Unhealthy {
+				objectives = append(objectives, c.SLO)
+			}
+		}
+	}
+	return &DegradationLadder{
+		cfg:     cfg,
+		samples: NewSLOMonitor(SLOConfig{Objectives: objectives, MinSamples: cfg.MinSamples}),
+		health:  health,
+		now:     time.Now,
+		since:   time.Now(),
+		timeAt:  make(map[DegradationLevel]time.Duration),
+	}
+}
+
+// Level returns the current level.
+func (l *DegradationLadder) Level() DegradationLevel {
+	if l == nil {
+		return LevelFull
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.level
+}
+
+// BriefSentences returns how many sentences a reply may have at the brief
+// level.
+func (l *DegradationLadder) BriefSentences() int {
+	return l.cfg.BriefSentences
+}
+
+// RecordTurn records a completed turn's stage latencies.
+func (l *DegradationLadder) RecordTurn(stages map[string]time.Duration) {
+	if l == nil {
+		return
+	}
+	l.samples.RecordTurn(stages)
+}
+
+// RecordError records a failed turn.
+func (l *DegradationLadder) RecordError() {
+	if l == nil {
+		return
+	}
+	l.samples.RecordError()
+}
+
+// Run checks the conditions every interval until ctx is done.
+func (l *DegradationLadder) Run(ctx context.Context) {
+	if l == nil {
+		return
+	}
+	ticker := time.NewTicker(l.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			l.Evaluate(ctx)
+		}
+	}
+}
+
+// Evaluate checks every rung's conditions and moves to the level they
+// call for: straight down to the lowest rung with a condition breached,
+// or up one rung once the current rung has been clear for RecoverAfter.
+// It returns the new level.
+func (l *DegradationLadder) Evaluate(ctx context.Context) DegradationLevel {
+	var probes map[string]healthResult
+	for _, rung := range l.cfg.Rungs {
+		if slices.ContainsFunc(rung.Conditions, func(c degradationCondition) bool { return c.Unhealthy }) {
+			probes, _ = l.health.Results(ctx)
+			break
+		}
+	}
+
+	target := LevelFull
+	statuses := make([]DegradationRungStatus, 0, len(l.cfg.Rungs))
+	var reasons []string
+	for _, rung := range l.cfg.Rungs {
+		status := DegradationRungStatus{Level: rung.Level}
+		for _, c := range rung.Conditions {
+			cs := l.check(c, probes)
+			if cs.Breached {
+				status.Breached = true
+				reasons = append(reasons, cs.Condition)
+			}
+			status.Conditions = append(status.Conditions, cs)
+		}
+		if status.Breached {
+			target = rung.Level
+		}
+		statuses = append(statuses, status)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rungs = statuses
+	now := l.now()
+	switch {
+	case target > l.level:
+		slog.Warn("degrading service", "from", l.level, "to", target, "reason", strings.Join(reasons, ", "))
+		l.moveTo(target, now)
+	case target < l.level:
+		if l.clearSince.IsZero() {
+			l.clearSince = now
+		}
+		if now.Sub(l.clearSince) < l.cfg.RecoverAfter {
+			break
+		}
+		// Back up one rung; the next waits out RecoverAfter again
+		next := LevelFull
+		for _, rung := range l.cfg.Rungs {
+			if rung.Level < l.level {
+				next = rung.Level
+			}
+		}
+		next = max(next, target)
+		slog.Info("service recovering", "from", l.level, "to", next)
+		l.moveTo(next, now)
+	default:
+		l.clearSince = time.Time{}
+	}
+	return l.level
+}
+
+// moveTo changes level. l.mu must be held.
+func (l *DegradationLadder) moveTo(level DegradationLevel, now time.Time) {
+	l.timeAt[l.level] += now.Sub(l.since)
+	l.level, l.since = level, now
+	l.clearSince = time.Time{}
+	l.transitions++
+}
+
+// check judges one condition.
+func (l *DegradationLadder) check(c degradationCondition, probes map[string]healthResult) DegradationConditionStatus {
+	status := DegradationConditionStatus{Condition: c.Name}
+	if c.Unhealthy {
+		var failing []string
+		for name, r := range probes {
+			if !r.OK && (c.Provider == "" || c.Provider == name) {
+				failing = append(failing, name)
+			}
+		}
+		slices.Sort(failing)
+		status.Breached = len(failing) > 0
+		if status.Breached {
+			status.Detail = strings.Join(failing, ", ") + " failing"
+		}
+		return status
+	}
+	slo := l.samples.measure(c.SLO)
+	if slo.Value == nil {
+		status.Detail = fmt.Sprintf("%d of %d samples", slo.Samples, l.cfg.MinSamples)
+		return status
+	}
+	status.Breached = slo.Breached
+	status.Detail = formatSLOValue(*slo.Value, slo.Unit) + " over " + slo.Window
+	return status
+}
+
+// Answer returns the answer of the FAQ entry with the most keywords in
+// the utterance, or the fallback if none has any.
+func (l *DegradationLadder) Answer(utterance string) string {
+	words := normalizeWords(utterance)
+	answer, best := l.cfg.FAQFallback, 0
+	for _, entry := range l.cfg.FAQ {
+		matched := 0
+		for _, kw := range entry.Keywords {
+			if matchesKeyword(words, normalizeWords(kw)) {
+				matched++
+			}
+		}
+		if matched > best {
+			answer, best = entry.Answer, matched
+		}
+	}
+	return answer
+}
+
+// voicemailTwiML has Twilio take a message, posting it to actionURL if
+// set, then hang up. Messages are read as c says.
+func (l *DegradationLadder) voicemailTwiML(c TwiMLConfig, actionURL string) string {
+	return twiml.Response{
+		c.say(l.cfg.VoicemailPrompt),
+		twiml.Record{MaxLength: int(l.cfg.VoicemailMaxLength / time.Second), PlayBeep: true, Action: actionURL},
+		// Reached only if the caller left no message
+		c.say(l.cfg.VoicemailClosing),
+		twiml.Hangup{},
+	}.String()
+}
+
+// handleVoicemail receives a message recorded by voicemailTwiML's
+// <Record>, logs where it is, archives it if storage is set, and thanks
+// the caller.
+func (s *Server) handleVoicemail(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	slog.Info("voicemail recorded",
+		"call_sid", r.Form.Get("CallSid"),
+		"from", r.Form.Get("From"),
+		"recording_sid", r.Form.Get("RecordingSid"),
+		"recording_url", r.Form.Get("RecordingUrl"),
+		"duration", r.Form.Get("RecordingDuration"))
+	sid := r.Form.Get("RecordingSid")
+	s.archive.Recording(r.Form.Get("CallSid"), sid, "voicemail-"+sid)
+	writeTwiML(w, hangupTwiML(s.callTwiML.say(s.degradation.cfg.VoicemailClosing)))
+}
+
+// ServeHTTP reports the current level and each rung's conditions as JSON.
+func (l *DegradationLadder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	l.mu.Lock()
+	now := l.now()
+	seconds := make(map[string]float64, len(degradationLevels))
+	for level, d := range l.timeAt {
+		seconds[level.String()] = d.Seconds()
+	}
+	seconds[l.level.String()] += now.Sub(l.since).Seconds()
+	report := map[string]any{
+		"level":            l.level,
+		"since":            l.since,
+		"transitions":      l.transitions,
+		"seconds_at_level": seconds,
+		"rungs":            l.rungs,
+	}
+	l.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		slog.Error("failed to write degradation status", "error", err)
+	}
+}
+
+// truncateSentences returns text cut to at most n sentences, and how many
+// sentences the result has.
+func truncateSentences(text string, n int) (string, int) {
+	sentences := splitSentences(text)
+	if len(sentences) <= n {
+		return text, len(sentences)
+	}
+	return strings.Join(sentences[:n], " "), n
+}
+
+// recordTurnError counts a failed turn against the objectives and the
+// degradation ladder.
+func (s *Server) recordTurnError() {
+	s.slo.RecordError()
+	s.degradation.RecordError()
+}
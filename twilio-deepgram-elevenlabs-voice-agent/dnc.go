@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/agentplexus/omnivoice-examples/kit/dnc"
+)
+
+// dialGateFromEnv builds the do-not-call gate checked before every outbound
+// dial. DNC_FILE names a list file (one number per line), DNC_URL an API
+// answering GET ?number=... with {"listed": bool}, authenticated with
+// DNC_API_KEY as a bearer token if set. DNC_CALLING_HOURS=true enforces the
+// default calling hours, and DNC_AUDIT_FILE appends a JSON line for every
+// suppressed attempt (otherwise they are only logged). It returns a nil
+// gate if none of these is set, and a closer for the audit file.
+func dialGateFromEnv() (*dnc.Gate, io.Closer, error) {
+	gate := &dnc.Gate{}
+	configured := false
+
+	var lists dnc.Lists
+	if path := os.Getenv("DNC_FILE"); path != "" {
+		list, err := dnc.LoadFile(path)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid DNC_FILE: %w", err)
+		}
+		lists = append(lists, list)
+	}
+	if u := os.Getenv("DNC_URL"); u != "" {
+		list := &dnc.HTTPList{URL: u}
+		if key := os.Getenv("DNC_API_KEY"); key != "" {
+			list.Header = http.Header{"Authorization": {"Bearer " + key}}
+		}
+		lists = append(lists, list)
+	}
+	if len(lists) > 0 {
+		gate.List = lists
+		configured = true
+	}
+
+	if v := os.Getenv("DNC_CALLING_HOURS"); v != "" && v != "false" && v != "0" {
+		gate.Hours = dnc.DefaultCallingHours()
+		configured = true
+	}
+
+	var closer io.Closer
+	if path := os.Getenv("DNC_AUDIT_FILE"); path != "" {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o640)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid DNC_AUDIT_FILE: %w", err)
+		}
+		gate.Audit, closer = f, f
+		configured = true
+	}
+
+	if !configured {
+		return nil, nil, nil
+	}
+	return gate, closer, nil
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/agentplexus/omnivoice-examples/kit/config"
+	"github.com/agentplexus/omnivoice-examples/kit/session"
+)
+
+// doubleTalk is what an agent does when the caller talks over it: the
+// policy, and the gain the duck policy lowers its voice to.
+type doubleTalk struct {
+	policy session.DoubleTalk
+	duck   float64
+}
+
+// doubleTalkFromConfig reads a double-talk policy. The zero doubleTalk
+// stops the agent, as the stop policy does.
+func doubleTalkFromConfig(cfg config.DoubleTalk) (doubleTalk, error) {
+	policy, err := session.ParseDoubleTalk(cfg.Policy)
+	if err != nil {
+		return doubleTalk{}, err
+	}
+	if cfg.DuckDB > 0 {
+		return doubleTalk{}, fmt.Errorf("invalid double_talk.duck_db %v (want a gain <= 0)", cfg.DuckDB)
+	}
+	return doubleTalk{policy: policy, duck: dbGain(cfg.DuckDB)}, nil
+}
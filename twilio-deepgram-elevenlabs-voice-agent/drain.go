@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+	"time"
+)
+
+// drainGracePeriod is how long calls still up at the drain deadline get to
+// hear the shutdown message and hang up before being cut off.
+const drainGracePeriod = 15 * time.Second
+
+// DrainPolicy controls graceful shutdown. On SIGTERM the server stops
+// taking new calls and waits up to Timeout for calls in progress to end;
+// calls still up are then told the call has to end and are hung up.
+type DrainPolicy struct {
+	Timeout time.Duration
+	// Message is spoken to calls still up at the deadline. Empty hangs up
+	// without a message.
+	Message string
+}
+
+// defaultDrainPolicy returns the policy used unless overridden by
+// DRAIN_MESSAGE. The timeout is set from the config's timeouts.drain
+// (DRAIN_TIMEOUT).
+func defaultDrainPolicy() DrainPolicy {
+	return DrainPolicy{
+		Timeout: 5 * time.Minute,
+		Message: "I'm sorry, we need to end this call now. Please call back in a moment.",
+	}
+}
+
+// drainPolicyFromEnv applies environment overrides to the default policy.
+// DRAIN_MESSAGE set to the empty string disables the message.
+func drainPolicyFromEnv() DrainPolicy {
+	policy := defaultDrainPolicy()
+	if v, ok := os.LookupEnv("DRAIN_MESSAGE"); ok {
+		policy.Message = v
+	}
+	return policy
+}
+
+// drainSessions waits up to timeout for sessions to end, then asks the
+// rest to end and gives them drainGracePeriod to hang up. A signal on force
+// stops waiting at once.
+func drainSessions(sessions *SessionManager, timeout time.Duration, force <-chan os.Signal) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-force:
+			slog.Warn("second signal, ending calls now")
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	waitCtx, cancelWait := context.WithTimeout(ctx, timeout)
+	defer cancelWait()
+	if sessions.Wait(waitCtx) == nil {
+		slog.Info("all calls ended")
+		return
+	}
+	if ctx.Err() != nil {
+		return
+	}
+
+	slog.Info("drain timeout reached, ending remaining calls", "calls", sessions.ShutdownAll())
+	graceCtx, cancelGrace := context.WithTimeout(ctx, drainGracePeriod)
+	defer cancelGrace()
+	_ = sessions.Wait(graceCtx)
+}
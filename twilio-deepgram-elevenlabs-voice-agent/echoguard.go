@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/audio"
+	"github.com/agentplexus/omnivoice/transport"
+)
+
+const (
+	// echoMaxDelay bounds the round trip from sending a frame to hearing it
+	// back: Twilio's jitter buffer, the PSTN leg, and the acoustic path.
+	echoMaxDelay = 500 * time.Millisecond
+	// echoTail is how long after the last outbound frame inbound audio is
+	// still treated as possible echo.
+	echoTail = echoMaxDelay
+	// echoHangover keeps the gate open after genuine caller speech so
+	// double-talk isn't chopped up.
+	echoHangover = 300 * time.Millisecond
+)
+
+// EchoGuardConfig controls self-echo suppression. While the agent is
+// speaking, inbound audio that correlates with what was just played, or
+// that is too quiet to be a barge-in, is replaced with silence before it
+// reaches STT. This stops speakerphone leakage from being transcribed as
+// the caller and answered by the agent.
+type EchoGuardConfig struct {
+	Enabled bool
+	// GateDBFS is the inbound level below which audio during playback is
+	// treated as echo. Callers talking over the agent are well above it.
+	GateDBFS float64
+	// Correlation (0-1) at which inbound audio is considered an echo of
+	// recent outbound audio.
+	Correlation float64
+}
+
+// defaultEchoGuardConfig returns the configuration used unless overridden by
+// ECHO_GATE_DBFS and ECHO_CORRELATION. Enabled is set from the config's
+// features.echo_guard (ECHO_GUARD).
+func defaultEchoGuardConfig() EchoGuardConfig {
+	return EchoGuardConfig{Enabled: true, GateDBFS: -40, Correlation: 0.5}
+}
+
+// echoGuardConfigFromEnv applies environment overrides to the defaults.
+func echoGuardConfigFromEnv() (EchoGuardConfig, error) {
+	cfg := defaultEchoGuardConfig()
+	if v := os.Getenv("ECHO_GATE_DBFS"); v != "" {
+		level, err := strconv.ParseFloat(v, 64)
+		if err != nil || level > 0 {
+			return cfg, fmt.Errorf("invalid ECHO_GATE_DBFS %q (want a level <= 0)", v)
+		}
+		cfg.GateDBFS = level
+	}
+	if v := os.Getenv("ECHO_CORRELATION"); v != "" {
+		corr, err := strconv.ParseFloat(v, 64)
+		if err != nil || corr <= 0 || corr > 1 {
+			return cfg, fmt.Errorf("invalid ECHO_CORRELATION %q (want 0-1)", v)
+		}
+		cfg.Correlation = corr
+	}
+	return cfg, nil
+}
+
+// echoGuard watches outbound audio at the wire and gates inbound audio on
+// its way to STT.
+type echoGuard struct {
+	cfg      EchoGuardConfig
+	codec    audio.Codec
+	detector *audio.EchoDetector
+
+	mu          sync.Mutex
+	lastSent    time.Time
+	speechUntil time.Time
+	suppressed  time.Duration
+}
+
+// newEchoGuard creates a guard for a connection using codec on the wire.
+func newEchoGuard(cfg EchoGuardConfig, codec audio.Codec) *echoGuard {
+	return &echoGuard{
+		cfg:      cfg,
+		codec:    codec,
+		detector: audio.NewEchoDetector(codec.SampleRate(), echoMaxDelay, cfg.Correlation),
+	}
+}
+
+// Tap wraps conn so that outbound audio is recorded as the echo reference.
+// It should sit below the pacer, so audio is recorded as it is played
+// rather than as fast as TTS produces it.
+func (g *echoGuard) Tap(conn transport.Connection) transport.Connection {
+	return &echoTapConnection{
+		Connection: conn,
+		writer:     &echoTapWriter{dst: conn.AudioIn(), guard: g, decoder: g.codec.NewDecoder()},
+	}
+}
+
+// Gate wraps conn so that echo is silenced in its inbound audio, which is
+// in the given STT encoding ("mulaw", "alaw" or "linear16").
+func (g *echoGuard) Gate(conn transport.Connection, encoding string) transport.Connection {
+	return &echoGateConnection{
+		Connection: conn,
+		reader:     &echoGateReader{src: conn.AudioOut(), guard: g, encoding: encoding},
+	}
+}
+
+// Suppressed returns how much inbound audio has been silenced.
+func (g *echoGuard) Suppressed() time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.suppressed
+}
+
+func (g *echoGuard) played(pcm []int16) {
+	g.detector.Reference(pcm)
+	g.mu.Lock()
+	g.lastSent = time.Now()
+	g.mu.Unlock()
+}
+
+// allow decides whether an inbound frame reaches STT.
+func (g *echoGuard) allow(pcm []int16) bool {
+	now := time.Now()
+	g.mu.Lock()
+	playing := now.Sub(g.lastSent) < echoTail
+	inHangover := now.Before(g.speechUntil)
+	g.mu.Unlock()
+	if !playing || inHangover {
+		return true
+	}
+
+	if audio.LevelDBFS(pcm) < g.cfg.GateDBFS || g.detector.IsEcho(pcm) {
+		g.mu.Lock()
+		g.suppressed += time.Duration(len(pcm)) * time.Second / time.Duration(g.codec.SampleRate())
+		g.mu.Unlock()
+		return false
+	}
+
+	g.mu.Lock()
+	g.speechUntil = now.Add(echoHangover)
+	g.mu.Unlock()
+	return true
+}
+
+// echoTapConnection records outbound audio for the echo guard.
+type echoTapConnection struct {
+	transport.Connection
+	writer *echoTapWriter
+}
+
+// AudioIn returns the tapping writer.
+func (c *echoTapConnection) AudioIn() io.WriteCloser {
+	return c.writer
+}
+
+type echoTapWriter struct {
+	dst     io.WriteCloser
+	guard   *echoGuard
+	decoder audio.Decoder
+
+	// pcm is reused for every write, all of which come from the pacer;
+	// the guard doesn't retain it.
+	pcm []int16
+}
+
+func (w *echoTapWriter) Write(b []byte) (int, error) {
+	w.pcm = audio.AppendDecode(w.decoder, w.pcm[:0], b)
+	w.guard.played(w.pcm)
+	return w.dst.Write(b)
+}
+
+func (w *echoTapWriter) Close() error {
+	return w.dst.Close()
+}
+
+// echoGateConnection silences echo in inbound audio.
+type echoGateConnection struct {
+	transport.Connection
+	reader *echoGateReader
+}
+
+// AudioOut returns the gated reader.
+func (c *echoGateConnection) AudioOut() io.Reader {
+	return c.reader
+}
+
+type echoGateReader struct {
+	src      io.Reader
+	guard    *echoGuard
+	encoding string
+
+	// pcm is reused for every read; the guard doesn't retain it.
+	pcm []int16
+}
+
+func (r *echoGateReader) Read(p []byte) (int, error) {
+	n, err := r.src.Read(p)
+	if n == 0 {
+		return n, err
+	}
+
+	var pcm []int16
+	silence := byte(0)
+	switch r.encoding {
+	case "mulaw":
+		r.pcm = audio.AppendMulawDecode(r.pcm[:0], p[:n])
+		pcm, silence = r.pcm, 0xFF
+	case "alaw":
+		r.pcm = audio.AppendAlawDecode(r.pcm[:0], p[:n])
+		pcm, silence = r.pcm, 0xD5
+	default:
+		r.pcm = audio.AppendPCM16FromBytes(r.pcm[:0], p[:n])
+		pcm = r.pcm
+	}
+	if !r.guard.allow(pcm) {
+		for i := range p[:n] {
+			p[i] = silence
+		}
+	}
+	return n, err
+}
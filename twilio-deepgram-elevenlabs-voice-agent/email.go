@@ -0,0 +1,266 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/agent"
+	"github.com/agentplexus/omnivoice-examples/kit/llm"
+	"github.com/agentplexus/omnivoice-examples/kit/mail"
+)
+
+// toolEmailSummary is the tool the agent emails the caller their call's
+// summary with.
+const toolEmailSummary = "email_summary"
+
+// emailMaxAddresses caps how many addresses a caller may have a call's
+// summary sent to.
+const emailMaxAddresses = 2
+
+// emailSendTimeout bounds sending a call's summaries once it ends.
+const emailSendTimeout = 30 * time.Second
+
+// CallEmail emails a summary of each call once it ends: to configured
+// recipients, such as a team inbox, and to any address the caller gave the
+// agent with the email_summary tool, completing flows like "book me in and
+// email me the details".
+type CallEmail struct {
+	// From is the sender of every summary.
+	From   string
+	Sender mail.Sender
+	// To, if set, are sent the summary of every call.
+	To []string
+	// Transcript adds the call's transcript to summaries.
+	Transcript bool
+
+	mu sync.Mutex
+	// calls holds the addresses and details the agent gave for each
+	// session.
+	calls map[string]*emailCall
+}
+
+type emailCall struct {
+	to      []string
+	details []string
+}
+
+// callSummary is what a call's summary is written from.
+type callSummary struct {
+	cdr            *CallDetailRecord
+	caller, called string
+	transcript     []TranscriptLine
+}
+
+// callEmailFromEnv builds the call summary email. EMAIL_FROM is the
+// sender; SMTP_ADDR (with SMTP_USERNAME and SMTP_PASSWORD) or
+// SENDGRID_API_KEY choose how mail is sent; EMAIL_TO lists addresses sent
+// every call's summary, comma-separated; EMAIL_TRANSCRIPT=false leaves
+// transcripts out. It returns nil if EMAIL_FROM isn't set.
+func callEmailFromEnv() (*CallEmail, error) {
+	from := os.Getenv("EMAIL_FROM")
+	if from == "" {
+		return nil, nil
+	}
+	e := &CallEmail{From: from, Transcript: true, calls: make(map[string]*emailCall)}
+	smtpAddr, sendGridKey := os.Getenv("SMTP_ADDR"), os.Getenv("SENDGRID_API_KEY")
+	switch {
+	case smtpAddr != "" && sendGridKey != "":
+		return nil, errors.New("invalid email configuration: set SMTP_ADDR or SENDGRID_API_KEY, not both")
+	case smtpAddr != "":
+		e.Sender = &mail.SMTP{Addr: smtpAddr, Username: os.Getenv("SMTP_USERNAME"), Password: os.Getenv("SMTP_PASSWORD")}
+	case sendGridKey != "":
+		e.Sender = &mail.SendGrid{APIKey: sendGridKey}
+	default:
+		return nil, errors.New("invalid email configuration: EMAIL_FROM requires SMTP_ADDR or SENDGRID_API_KEY")
+	}
+	if v := os.Getenv("EMAIL_TO"); v != "" {
+		for _, to := range strings.Split(v, ",") {
+			addr, err := mail.ParseAddress(to)
+			if err != nil {
+				return nil, fmt.Errorf("invalid EMAIL_TO: %w", err)
+			}
+			e.To = append(e.To, addr)
+		}
+	}
+	if v := os.Getenv("EMAIL_TRANSCRIPT"); v != "" {
+		on, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EMAIL_TRANSCRIPT: %q", v)
+		}
+		e.Transcript = on
+	}
+	return e, nil
+}
+
+// Tool returns the email_summary tool, which has the summary of the call
+// it is called in sent to an address the caller gave.
+func (e *CallEmail) Tool() agent.Tool {
+	return agent.Tool{
+		Tool: llm.Tool{
+			Name:        toolEmailSummary,
+			Description: "Email the caller a summary of this call once it ends, e.g. the details of an appointment you booked. Ask for their email address and spell it back with spell_out to confirm it first. Put anything they should have in writing, such as dates, times and confirmation numbers, in details.",
+			Parameters:  json.RawMessage(`{"type":"object","properties":{"address":{"type":"string","description":"The caller's confirmed email address."},"details":{"type":"string","description":"What the caller should have in writing, in plain sentences."}},"required":["address"]}`),
+		},
+		Call: e.queue,
+	}
+}
+
+// queue records an email_summary call's address and details for its
+// session.
+func (e *CallEmail) queue(ctx context.Context, args json.RawMessage) (string, error) {
+	var params struct {
+		Address string `json:"address"`
+		Details string `json:"details"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return "", err
+	}
+	addr, err := mail.ParseAddress(params.Address)
+	if err != nil {
+		return "", err
+	}
+
+	sessionID, _ := agent.SessionID(ctx)
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	call, ok := e.calls[sessionID]
+	if !ok {
+		return "", errors.New("email is not available on this call")
+	}
+	if !containsFold(call.to, addr) {
+		if len(call.to) >= emailMaxAddresses {
+			return "No more addresses can be added on this call.", nil
+		}
+		call.to = append(call.to, addr)
+	}
+	if details := strings.TrimSpace(params.Details); details != "" {
+		call.details = append(call.details, details)
+	}
+	return fmt.Sprintf("A summary of the call will be emailed to %s when it ends.", addr), nil
+}
+
+// Begin lets the agent email the caller of a session.
+func (e *CallEmail) Begin(sessionID string) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.calls[sessionID] = &emailCall{}
+}
+
+// End sends a session's summaries: to the configured recipients, and to
+// the addresses the caller gave. It returns how many emails were sent.
+func (e *CallEmail) End(ctx context.Context, sessionID string, summary callSummary, logger *slog.Logger) int {
+	if e == nil {
+		return 0
+	}
+	e.mu.Lock()
+	call := e.calls[sessionID]
+	delete(e.calls, sessionID)
+	e.mu.Unlock()
+	if call == nil {
+		call = &emailCall{}
+	}
+
+	var messages []mail.Message
+	date := summary.cdr.StartedAt.UTC().Format("Jan 2, 2006")
+	if len(e.To) > 0 {
+		messages = append(messages, mail.Message{
+			From:    e.From,
+			To:      e.To,
+			Subject: fmt.Sprintf("Call from %s on %s", firstNonEmpty(summary.caller, "unknown caller"), date),
+			Text:    e.summaryText(summary, call.details, true),
+		})
+	}
+	if len(call.to) > 0 {
+		messages = append(messages, mail.Message{
+			From:    e.From,
+			To:      call.to,
+			Subject: "Summary of your call on " + date,
+			Text:    e.summaryText(summary, call.details, false),
+		})
+	}
+
+	sent := 0
+	for _, m := range messages {
+		if err := e.Sender.Send(ctx, m); err != nil {
+			logger.Warn("failed to email call summary", "recipients", len(m.To), "error", err)
+			continue
+		}
+		logger.Info("call summary emailed", "recipients", len(m.To))
+		sent++
+	}
+	return sent
+}
+
+// summaryText writes a call's summary, with its transcript if
+// EMAIL_TRANSCRIPT allows.
+func (e *CallEmail) summaryText(s callSummary, details []string, internal bool) string {
+	return writeSummary(s, details, internal, e.Transcript)
+}
+
+// writeSummary writes a call's summary. Internal summaries, for the
+// configured recipients and the archive, also say who called and how the
+// call ended; the caller's leaves out anything only the other side of the
+// call heard.
+func writeSummary(s callSummary, details []string, internal, withTranscript bool) string {
+	var b strings.Builder
+	started := s.cdr.StartedAt.UTC()
+	duration := time.Since(s.cdr.StartedAt).Round(time.Second)
+	if !s.cdr.EndedAt.IsZero() {
+		duration = s.cdr.EndedAt.Sub(s.cdr.StartedAt).Round(time.Second)
+	}
+	fmt.Fprintf(&b, "Call on %s at %s UTC, lasting %s.\n", started.Format("Mon Jan 2, 2006"), started.Format("15:04"), duration)
+	if internal {
+		fmt.Fprintf(&b, "Caller: %s\nCalled: %s\nSession: %s\nEnded by: %s\n", s.caller, s.called, s.cdr.SessionID, firstNonEmpty(s.cdr.EndedBy, "unknown"))
+		if s.cdr.TransferredTo != "" {
+			fmt.Fprintf(&b, "Transferred to: %s\n", s.cdr.TransferredTo)
+		}
+	}
+	if len(s.cdr.Topics) > 0 {
+		labels := make([]string, len(s.cdr.Topics))
+		for i, t := range s.cdr.Topics {
+			labels[i] = t.Label
+		}
+		fmt.Fprintf(&b, "Topics: %s\n", strings.Join(labels, ", "))
+	}
+	if len(details) > 0 {
+		b.WriteString("\nDetails\n\n")
+		for _, d := range details {
+			b.WriteString(d + "\n")
+		}
+	}
+	if withTranscript && len(s.transcript) > 0 {
+		b.WriteString("\nTranscript\n\n")
+		for _, line := range s.transcript {
+			if !internal && line.Target != LegAll && line.Target != LegCaller {
+				continue
+			}
+			speaker := line.Speaker
+			if speaker != "" {
+				speaker = strings.ToUpper(speaker[:1]) + speaker[1:]
+			}
+			fmt.Fprintf(&b, "[%s] %s: %s\n", line.At.UTC().Format("15:04:05"), speaker, line.Text)
+		}
+	}
+	return b.String()
+}
+
+// containsFold reports whether addrs holds addr, ignoring case.
+func containsFold(addrs []string, addr string) bool {
+	for _, a := range addrs {
+		if strings.EqualFold(a, addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/agentplexus/omnivoice-examples/kit/moderation"
+	"github.com/agentplexus/omnivoice-examples/kit/phone"
+)
+
+// Built-in emergency categories.
+const (
+	emergencySelfHarm = "self-harm"
+	emergencyMedical  = "medical"
+)
+
+// defaultEmergencyPhrases are what callers say of threats of self-harm and
+// of medical emergencies, in the forms a recognizer writes them.
+var defaultEmergencyPhrases = map[string][]string{
+	emergencySelfHarm: {
+		"kill myself", "killing myself", "end my life", "take my own life", "suicide", "suicidal",
+		"want to die", "hurt myself", "harm myself", "don't want to live", "no reason to live",
+	},
+	emergencyMedical: {
+		"heart attack", "having a stroke", "chest pain", "can't breathe", "cannot breathe",
+		"not breathing", "stopped breathing", "unconscious", "overdose", "overdosed", "seizure",
+		"bleeding heavily", "call an ambulance", "need an ambulance",
+	},
+}
+
+// EmergencyPolicy is how caller transcripts are watched for emergencies.
+// A caller who says an emergency phrase interrupts whatever the call was
+// doing: the reply in progress is cut off, the escalation message is said,
+// an EmergencyDetected event is published and, if a number is set, the
+// call is transferred there.
+type EmergencyPolicy struct {
+	// Phrases flags emergencies by category, matched as whole words.
+	// Detection is off when nil.
+	Phrases *moderation.Wordlist
+	// Message is said to the caller as soon as an emergency is detected.
+	Message string
+	// Number, if set, is dialled after the message (a phone number or
+	// SIP URI), e.g. a crisis line or a supervisor.
+	Number phone.Number
+	// WebhookURL, if set, is POSTed each EmergencyDetected event as an
+	// alert, apart from the session events webhook.
+	WebhookURL string
+}
+
+// defaultEmergencyPolicy returns the policy used unless overridden by
+// EMERGENCY_DETECTION, EMERGENCY_PHRASES, EMERGENCY_MESSAGE,
+// EMERGENCY_TRANSFER_NUMBER and EMERGENCY_WEBHOOK_URL. Detection is off by
+// default.
+func defaultEmergencyPolicy() EmergencyPolicy {
+	return EmergencyPolicy{
+		Message: "It sounds like this may be an emergency. If you or someone else is in danger, " +
+			"please hang up and call 911 now. You can also call or text 988 to reach the Suicide and Crisis Lifeline.",
+	}
+}
+
+// emergencyPolicyFromEnv applies environment overrides to the default
+// policy. EMERGENCY_PHRASES names a file of phrases added to the built-in
+// ones, one per line, flagged as "emergency" or as the category before a
+// colon. EMERGENCY_TRANSFER_NUMBER is read with plan.
+func emergencyPolicyFromEnv(plan phone.DialPlan) (EmergencyPolicy, error) {
+	policy := defaultEmergencyPolicy()
+	v := os.Getenv("EMERGENCY_DETECTION")
+	if v == "" {
+		return policy, nil
+	}
+	on, err := strconv.ParseBool(v)
+	if err != nil {
+		return policy, fmt.Errorf("invalid EMERGENCY_DETECTION: %q", v)
+	}
+	if !on {
+		return policy, nil
+	}
+	policy.Phrases = moderation.NewWordlist("emergency")
+	for category, phrases := range defaultEmergencyPhrases {
+		policy.Phrases.Add(category, phrases...)
+	}
+	if path := os.Getenv("EMERGENCY_PHRASES"); path != "" {
+		extra, err := moderation.LoadWordlistAs(path, "emergency")
+		if err != nil {
+			return policy, fmt.Errorf("invalid EMERGENCY_PHRASES: %w", err)
+		}
+		policy.Phrases.Merge(extra)
+	}
+	if v := os.Getenv("EMERGENCY_MESSAGE"); v != "" {
+		policy.Message = v
+	}
+	if v := os.Getenv("EMERGENCY_TRANSFER_NUMBER"); v != "" {
+		number, err := plan.Parse(v)
+		if err != nil {
+			return policy, fmt.Errorf("invalid EMERGENCY_TRANSFER_NUMBER: %w", err)
+		}
+		policy.Number = number
+	}
+	policy.WebhookURL = os.Getenv("EMERGENCY_WEBHOOK_URL")
+	return policy, nil
+}
+
+// Enabled reports whether emergency detection is on.
+func (p EmergencyPolicy) Enabled() bool {
+	return p.Phrases != nil
+}
+
+// Detect flags the emergency phrases in a caller's transcript.
+func (p EmergencyPolicy) Detect(text string) moderation.Result {
+	if p.Phrases == nil {
+		return moderation.Result{}
+	}
+	r, _ := p.Phrases.Check(context.Background(), text)
+	return r
+}
+
+// EmergencyFlag is an emergency detected on a call, as recorded in the
+// CDR. What was said isn't recorded.
+type EmergencyFlag struct {
+	Turn       int      `json:"turn"`
+	Categories []string `json:"categories"`
+	Phrases    []string `json:"phrases"`
+}
+
+// emergency records a detected emergency in the CDR.
+func (s *CallSession) emergency(turn int, r moderation.Result) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cdr.Emergencies = append(s.cdr.Emergencies, EmergencyFlag{
+		Turn:       turn,
+		Categories: r.Categories,
+		Phrases:    r.Terms,
+	})
+}
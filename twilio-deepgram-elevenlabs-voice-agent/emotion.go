@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"regexp"
+	"slices"
+
+	"github.com/agentplexus/omnivoice-examples/kit/config"
+	"github.com/agentplexus/omnivoice-examples/kit/speech"
+)
+
+var validEmotion = regexp.MustCompile(`^[a-z][a-z_-]*$`)
+
+// defaultEmotions are the emotions the model may tag its replies with
+// unless the configuration file lists its own.
+func defaultEmotions() map[string]config.Emotion {
+	f := func(v float64) *float64 { return &v }
+	return map[string]config.Emotion{
+		"cheerful":   {Stability: f(0.35), Style: f(0.45), Rate: "105%", Pitch: "+5%"},
+		"apologetic": {Stability: f(0.6), Style: f(0.2), Rate: "95%", Pitch: "-3%", Volume: "soft"},
+		"empathetic": {Stability: f(0.5), Style: f(0.3), Rate: "95%"},
+		"calm":       {Stability: f(0.75), Style: f(0), Rate: "92%", Pitch: "-2%"},
+		"excited":    {Stability: f(0.3), Style: f(0.6), Rate: "110%", Pitch: "+8%"},
+		"serious":    {Stability: f(0.7), Style: f(0.1), Rate: "97%", Pitch: "-4%"},
+	}
+}
+
+// emotionsFromConfig returns the emotions the model may tag its replies
+// with, or nil without features.emotion_tags (EMOTION_TAGS).
+func emotionsFromConfig(cfg config.Config) (map[string]config.Emotion, error) {
+	if !cfg.Features.EmotionTags {
+		return nil, nil
+	}
+	emotions := cfg.Emotions
+	if len(emotions) == 0 {
+		emotions = defaultEmotions()
+	}
+	for name, e := range emotions {
+		if !validEmotion.MatchString(name) || name == speech.Neutral {
+			return nil, fmt.Errorf("invalid emotion %q (want lowercase letters, underscores and hyphens, and not %q)", name, speech.Neutral)
+		}
+		for setting, v := range map[string]*float64{"stability": e.Stability, "style": e.Style} {
+			if v != nil && (*v < 0 || *v > 1) {
+				return nil, fmt.Errorf("invalid emotion %s: %s %v is outside 0 to 1", name, setting, *v)
+			}
+		}
+	}
+	return emotions, nil
+}
+
+// emotionNames returns the names of emotions, sorted.
+func emotionNames(emotions map[string]config.Emotion) []string {
+	return slices.Sorted(maps.Keys(emotions))
+}
+
+// emotionProsody returns the SSML prosody of each of emotions that sets
+// any, or nil if none do.
+func emotionProsody(emotions map[string]config.Emotion) map[string]speech.Prosody {
+	var prosody map[string]speech.Prosody
+	for name, e := range emotions {
+		p := speech.Prosody{Rate: e.Rate, Pitch: e.Pitch, Volume: e.Volume}
+		if p == (speech.Prosody{}) {
+			continue
+		}
+		if prosody == nil {
+			prosody = make(map[string]speech.Prosody)
+		}
+		prosody[name] = p
+	}
+	return prosody
+}
+
+// newTone returns a tone reading the emotion tags of one reply, or nil if
+// replies aren't tagged.
+func newTone(emotions map[string]config.Emotion) *speech.Tone {
+	if emotions == nil {
+		return nil
+	}
+	return speech.NewTone(emotionNames(emotions)...)
+}
+
+// emotionInstructions tells the model how to tag its replies with
+// emotions, or is "" if replies aren't tagged.
+func emotionInstructions(emotions map[string]config.Emotion) string {
+	if emotions == nil {
+		return ""
+	}
+	return speech.EmotionInstructions(emotionNames(emotions))
+}
+
+// readTone returns reply without its emotion tags, and split into spans
+// at them. If tone is nil reply is one untagged span.
+func readTone(tone *speech.Tone, reply string) (string, []speech.Span) {
+	if tone == nil {
+		return reply, []speech.Span{{Text: reply}}
+	}
+	spans := tone.Read(reply)
+	return speech.Text(spans), spans
+}
+
+type emotionContextKey struct{}
+
+// withEmotion returns a context whose speech is spoken with emotion.
+func withEmotion(ctx context.Context, emotion string) context.Context {
+	if emotion == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, emotionContextKey{}, emotion)
+}
+
+// emotionFrom returns the emotion ctx's speech is spoken with, or "".
+func emotionFrom(ctx context.Context) string {
+	emotion, _ := ctx.Value(emotionContextKey{}).(string)
+	return emotion
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Session event types, as named in JSON.
+const (
+	eventSessionStarted    = "session_started"
+	eventTurnCompleted     = "turn_completed"
+	eventBargeIn           = "barge_in"
+	eventProviderError     = "provider_error"
+	eventEmergencyDetected = "emergency_detected"
+	eventSessionEnded      = "session_ended"
+	// eventVoicemailReceived is published for messages left after hours,
+	// outside any agent session.
+	eventVoicemailReceived = "voicemail_received"
+)
+
+// Event is what every session event carries: the session it happened in,
+// and when.
+type Event struct {
+	SessionID string    `json:"session_id"`
+	CallSID   string    `json:"call_sid,omitempty"`
+	At        time.Time `json:"at"`
+}
+
+func (e Event) header() Event { return e }
+
+// SessionEvent is something that happened in an agent call: one of
+// SessionStarted, TurnCompleted, BargeIn, ProviderError,
+// EmergencyDetected or SessionEnded, or a VoicemailReceived for a call the
+// agent didn't answer.
+type SessionEvent interface {
+	header() Event
+}
+
+// SessionStarted is published once an agent call's pipelines are running.
+type SessionStarted struct {
+	Event
+	From   string `json:"from,omitempty"`
+	To     string `json:"to,omitempty"`
+	Tenant string `json:"tenant,omitempty"`
+	// Resumed is set for a call picked up where a dropped stream left off.
+	Resumed bool `json:"resumed,omitempty"`
+}
+
+// TurnCompleted is published once a turn has been answered in full,
+// unless a newer turn or a barge-in cut it short.
+type TurnCompleted struct {
+	Event
+	Turn int    `json:"turn"`
+	Text string `json:"text"`
+	// Reply is what was queued to be spoken in answer, and AnsweredBy
+	// what answered: the agent, an intent's canned reply, or the FAQ of a
+	// degraded service.
+	Reply      string `json:"reply"`
+	AnsweredBy string `json:"answered_by"`
+}
+
+// BargeIn is published when the caller speaks over the agent, cutting off
+// audio not yet played.
+type BargeIn struct {
+	Event
+	Turn        int   `json:"turn"`
+	DiscardedMs int64 `json:"discarded_ms"`
+}
+
+// ProviderError is published when STT, the agent or TTS fails a turn or
+// the session.
+type ProviderError struct {
+	Event
+	// Stage is the latency stage that failed: stt, agent or tts.
+	Stage string `json:"stage"`
+	Error string `json:"error"`
+}
+
+// EmergencyDetected is published when the caller speaks of an emergency,
+// as an alert. Text is the caller's turn as recorded, so redacted if the
+// redact interceptor is on.
+type EmergencyDetected struct {
+	Event
+	From       string   `json:"from,omitempty"`
+	To         string   `json:"to,omitempty"`
+	Turn       int      `json:"turn"`
+	Categories []string `json:"categories"`
+	Phrases    []string `json:"phrases"`
+	Text       string   `json:"text"`
+	// TransferredTo is the number the call is being transferred to, if
+	// any.
+	TransferredTo string `json:"transferred_to,omitempty"`
+}
+
+// SessionEnded is published last, once the call's detail record is
+// complete. The transcript and captions are for subscribers in this
+// process only; captions are kept only with the call archive on.
+type SessionEnded struct {
+	Event
+	From       string            `json:"from,omitempty"`
+	To         string            `json:"to,omitempty"`
+	EndedBy    string            `json:"ended_by"`
+	CDR        *CallDetailRecord `json:"cdr"`
+	Transcript []TranscriptLine  `json:"-"`
+	Captions   []Caption         `json:"-"`
+}
+
+// eventType returns the JSON name of e's type.
+func eventType(e SessionEvent) string {
+	switch e.(type) {
+	case SessionStarted:
+		return eventSessionStarted
+	case TurnCompleted:
+		return eventTurnCompleted
+	case BargeIn:
+		return eventBargeIn
+	case ProviderError:
+		return eventProviderError
+	case EmergencyDetected:
+		return eventEmergencyDetected
+	case SessionEnded:
+		return eventSessionEnded
+	case VoicemailReceived:
+		return eventVoicemailReceived
+	}
+	return fmt.Sprintf("%T", e)
+}
+
+// eventEnvelope is a session event as sent to a webhook or listed by the
+// admin API.
+type eventEnvelope struct {
+	Type  string       `json:"type"`
+	Event SessionEvent `json:"event"`
+}
+
+func envelope(e SessionEvent) eventEnvelope {
+	return eventEnvelope{Type: eventType(e), Event: e}
+}
+
+// EventBus carries agent calls' lifecycle events to the subsystems that
+// follow them, such as metrics, the admin API, webhooks and recorders, so
+// a session only says what happened, not who needs to know. Handlers run
+// on the publishing goroutine, in the order they subscribed, and must not
+// block. A nil EventBus drops every event.
+type EventBus struct {
+	mu       sync.RWMutex
+	handlers []func(SessionEvent)
+}
+
+// NewEventBus returns a bus with no subscribers.
+func NewEventBus() *EventBus {
+	return &EventBus{}
+}
+
+// Publish hands e to every subscriber.
+func (b *EventBus) Publish(e SessionEvent) {
+	if b == nil {
+		return
+	}
+	b.mu.RLock()
+	handlers := b.handlers
+	b.mu.RUnlock()
+	for _, h := range handlers {
+		h(e)
+	}
+}
+
+// SubscribeAll calls fn with every event.
+func (b *EventBus) SubscribeAll(fn func(SessionEvent)) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// Copied, so a Publish in progress keeps the handlers it started with
+	b.handlers = append(slices.Clip(b.handlers), fn)
+}
+
+// Subscribe calls fn with every event of type E published on b.
+func Subscribe[E SessionEvent](b *EventBus, fn func(E)) {
+	b.SubscribeAll(func(e SessionEvent) {
+		if e, ok := e.(E); ok {
+			fn(e)
+		}
+	})
+}
+
+// subscribeMetrics has the server's metrics follow its calls: provider
+// errors count against the SLOs and the degradation ladder, and each
+// call's cost is added to the cost stats.
+func (s *Server) subscribeMetrics() {
+	Subscribe(s.events, func(ProviderError) { s.recordTurnError() })
+	Subscribe(s.events, func(e SessionEnded) {
+		if e.CDR.Cost != nil {
+			s.costs.record(e.CDR.Tenant, *e.CDR.Cost)
+		}
+	})
+}
+
+// eventLogSize is how many recent events the admin API keeps.
+const eventLogSize = 500
+
+// eventLog keeps the most recent events for the admin API.
+type eventLog struct {
+	mu     sync.Mutex
+	events []eventEnvelope
+}
+
+// newEventLog returns a log of the events published on bus.
+func newEventLog(bus *EventBus) *eventLog {
+	l := &eventLog{}
+	bus.SubscribeAll(l.add)
+	return l
+}
+
+func (l *eventLog) add(e SessionEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.events) == eventLogSize {
+		l.events = slices.Delete(l.events, 0, 1)
+	}
+	l.events = append(l.events, envelope(e))
+}
+
+// Recent returns the events kept, oldest first, of session only unless it
+// is empty.
+func (l *eventLog) Recent(session string) []eventEnvelope {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	events := make([]eventEnvelope, 0, len(l.events))
+	for _, e := range l.events {
+		if session == "" || e.Event.header().SessionID == session {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// eventWebhookTimeout bounds each event webhook request.
+const eventWebhookTimeout = 5 * time.Second
+
+// eventWebhookQueue is how many events may wait to be sent before new
+// ones are dropped.
+const eventWebhookQueue = 1024
+
+// EventWebhookConfig sends session events to a URL of your own, e.g. to
+// update a CRM as calls start and end.
+type EventWebhookConfig struct {
+	// URL receives each event as a JSON POST; empty sends none.
+	URL string
+	// Types limits the events sent to these types; empty sends them all.
+	Types []string
+}
+
+// eventWebhookConfigFromEnv reads EVENTS_WEBHOOK_URL and
+// EVENTS_WEBHOOK_TYPES (comma-separated).
+func eventWebhookConfigFromEnv() (EventWebhookConfig, error) {
+	cfg := EventWebhookConfig{URL: os.Getenv("EVENTS_WEBHOOK_URL")}
+	v := os.Getenv("EVENTS_WEBHOOK_TYPES")
+	if v == "" {
+		return cfg, nil
+	}
+	known := []string{eventSessionStarted, eventTurnCompleted, eventBargeIn, eventProviderError, eventEmergencyDetected, eventSessionEnded, eventVoicemailReceived}
+	for _, t := range strings.Split(v, ",") {
+		t = strings.TrimSpace(t)
+		if !slices.Contains(known, t) {
+			return cfg, fmt.Errorf("invalid EVENTS_WEBHOOK_TYPES: unknown event type %q (want %s)", t, strings.Join(known, ", "))
+		}
+		cfg.Types = append(cfg.Types, t)
+	}
+	return cfg, nil
+}
+
+// eventWebhook POSTs events to a URL from a queue of its own, so a slow
+// endpoint never holds up a call. Events that don't fit in the queue are
+// dropped and counted.
+type eventWebhook struct {
+	cfg     EventWebhookConfig
+	client  *http.Client
+	queue   chan eventEnvelope
+	dropped atomic.Int64
+}
+
+// newEventWebhook sends the events published on bus until ctx is done, or
+// returns nil if cfg has no URL.
+func newEventWebhook(ctx context.Context, cfg EventWebhookConfig, bus *EventBus) *eventWebhook {
+	if cfg.URL == "" {
+		return nil
+	}
+	w := &eventWebhook{
+		cfg:    cfg,
+		client: &http.Client{Timeout: eventWebhookTimeout},
+		queue:  make(chan eventEnvelope, eventWebhookQueue),
+	}
+	bus.SubscribeAll(w.enqueue)
+	go w.run(ctx)
+	return w
+}
+
+func (w *eventWebhook) enqueue(e SessionEvent) {
+	env := envelope(e)
+	if len(w.cfg.Types) > 0 && !slices.Contains(w.cfg.Types, env.Type) {
+		return
+	}
+	select {
+	case w.queue <- env:
+	default:
+		if w.dropped.Add(1)%100 == 1 {
+			slog.Warn("event webhook backed up, dropping events", "dropped", w.dropped.Load())
+		}
+	}
+}
+
+func (w *eventWebhook) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case env := <-w.queue:
+			if err := w.send(ctx, env); err != nil && ctx.Err() == nil {
+				slog.Error("failed to send session event", "type", env.Type, "session", env.Event.header().SessionID, "error", err)
+			}
+		}
+	}
+}
+
+// send POSTs an event to the webhook.
+func (w *eventWebhook) send(ctx context.Context, env eventEnvelope) error {
+	body, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.cfg.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errors.New("webhook answered " + resp.Status)
+	}
+	return nil
+}
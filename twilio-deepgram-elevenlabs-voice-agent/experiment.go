@@ -0,0 +1,341 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"net/http"
+	"sync"
+
+	"github.com/agentplexus/omnivoice-examples/kit/agent"
+	"github.com/agentplexus/omnivoice-examples/kit/config"
+	"github.com/agentplexus/omnivoice-examples/kit/phone"
+)
+
+// Experiments buckets calls into the variants of the configured
+// experiments and aggregates each variant's results. A call is in the
+// first experiment covering the number it called, and a caller always
+// lands in the same variant of it. A nil Experiments assigns nothing.
+type Experiments struct {
+	experiments []*experiment
+}
+
+// experiment is one configured experiment.
+type experiment struct {
+	name string
+	// numbers are the numbers called it covers, normalized; nil covers
+	// every call.
+	numbers  map[string]bool
+	variants []*variant
+	total    int // sum of the variants' weights
+}
+
+// variant is one arm of an experiment, applied to every tenant.
+type variant struct {
+	name   string
+	weight int
+	// tenants are the variant's agents, keyed like Server.tenants; ""
+	// is the agent for numbers without a tenant.
+	tenants map[string]*tenant
+	stats   *variantStats
+}
+
+// newExperiments builds the variants of cfg.Experiments over each tenant
+// and the server's own agent, brain, or returns nil if there are none.
+// Variants' models are put behind guard.
+func newExperiments(cfg config.Config, brain agent.Agent, tenants map[string]*tenant, plan phone.DialPlan, guard LLMGuard) (*Experiments, error) {
+	if len(cfg.Experiments) == 0 {
+		return nil, nil
+	}
+
+	// The agents variants change, with the configuration they were built from
+	doubleTalk, err := doubleTalkFromConfig(cfg.DoubleTalk)
+	if err != nil {
+		return nil, err
+	}
+	bases := map[string]*tenant{"": {agent: brain, tts: cfg.ElevenLabs, stt: cfg.Deepgram, doubleTalk: doubleTalk}}
+	baseConfigs := map[string]config.Tenant{}
+	baseConfigs[""], _ = cfg.TenantFor("")
+	for number := range cfg.Tenants {
+		n, err := plan.Parse(number)
+		if err != nil {
+			return nil, fmt.Errorf("tenant %s: %w", number, err)
+		}
+		bases[n.Address()] = tenants[n.Address()]
+		baseConfigs[n.Address()], _ = cfg.TenantFor(number)
+	}
+
+	e := &Experiments{}
+	names := make(map[string]bool)
+	for _, c := range cfg.Experiments {
+		if c.Name == "" {
+			return nil, errors.New("experiment without a name")
+		}
+		if names[c.Name] {
+			return nil, fmt.Errorf("experiment %s: configured twice", c.Name)
+		}
+		names[c.Name] = true
+		if len(c.Variants) < 2 {
+			return nil, fmt.Errorf("experiment %s: needs at least two variants", c.Name)
+		}
+
+		x := &experiment{name: c.Name}
+		for _, number := range c.Numbers {
+			n, err := plan.Parse(number)
+			if err != nil {
+				return nil, fmt.Errorf("experiment %s: %w", c.Name, err)
+			}
+			if x.numbers == nil {
+				x.numbers = make(map[string]bool)
+			}
+			x.numbers[n.Address()] = true
+		}
+		variantNames := make(map[string]bool)
+		for _, vc := range c.Variants {
+			if vc.Name == "" || variantNames[vc.Name] {
+				return nil, fmt.Errorf("experiment %s: variants need distinct names", c.Name)
+			}
+			variantNames[vc.Name] = true
+			if vc.Weight < 0 {
+				return nil, fmt.Errorf("experiment %s: variant %s: negative weight", c.Name, vc.Name)
+			}
+			v := &variant{
+				name:    vc.Name,
+				weight:  max(vc.Weight, 1),
+				tenants: make(map[string]*tenant, len(bases)),
+				stats:   newVariantStats(),
+			}
+			for number, base := range bases {
+				t, err := base.withVariant(vc, baseConfigs[number], guard)
+				if err != nil {
+					return nil, fmt.Errorf("experiment %s: variant %s: %w", c.Name, vc.Name, err)
+				}
+				v.tenants[number] = t
+			}
+			x.variants = append(x.variants, v)
+			x.total += v.weight
+		}
+		e.experiments = append(e.experiments, x)
+	}
+	return e, nil
+}
+
+// withVariant returns t changed by v. base is the configuration t was
+// built from; a variant changing the model or the system prompt gets a
+// brain of its own.
+func (t *tenant) withVariant(v config.Variant, base config.Tenant, guard LLMGuard) (*tenant, error) {
+	vt := *t
+	vt.tts.VoiceID = firstNonEmpty(v.VoiceID, t.tts.VoiceID)
+	vt.tts.Model = firstNonEmpty(v.TTSModel, t.tts.Model)
+	vt.stt.Model = firstNonEmpty(v.STTModel, t.stt.Model)
+	vt.stt.Language = firstNonEmpty(v.Language, t.stt.Language)
+	vt.greeting = firstNonEmpty(v.Prompts.Greeting, t.greeting)
+	if v.LLM.Provider != "" || v.Prompts.System != "" {
+		model := base.LLM
+		if v.LLM.Provider != "" {
+			model = v.LLM
+		}
+		brain, err := newBrain(model, firstNonEmpty(v.Prompts.System, base.Prompts.System), guard)
+		if err != nil {
+			return nil, err
+		}
+		vt.agent = brain
+	}
+	return &vt, nil
+}
+
+// Assignment is the variant a call was bucketed into.
+type Assignment struct {
+	Experiment string
+	Variant    string
+	tenant     *tenant
+	stats      *variantStats
+}
+
+// Assign buckets a call to number (normalized) from caller into a variant,
+// or returns nil if no experiment covers the number. Callers are bucketed
+// by their number, so they get the same variant each time they call;
+// withheld numbers are bucketed by call.
+func (e *Experiments) Assign(number, caller, callSID string) *Assignment {
+	if e == nil {
+		return nil
+	}
+	for _, x := range e.experiments {
+		if x.numbers != nil && !x.numbers[number] {
+			continue
+		}
+		key := caller
+		if key == "" || key == "anonymous" {
+			key = callSID
+		}
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(x.name + "/" + key))
+		bucket := int(h.Sum64() % uint64(x.total))
+		for _, v := range x.variants {
+			if bucket < v.weight {
+				t, ok := v.tenants[number]
+				if !ok {
+					t = v.tenants[""]
+				}
+				return &Assignment{Experiment: x.name, Variant: v.name, tenant: t, stats: v.stats}
+			}
+			bucket -= v.weight
+		}
+	}
+	return nil
+}
+
+// variantStats aggregates one variant's calls.
+type variantStats struct {
+	latency *LatencyStats
+
+	mu          sync.Mutex
+	calls       int
+	seconds     float64
+	turns       int
+	bargeIns    int
+	transferred int
+	degraded    int
+	endedBy     map[string]int
+	costed      int
+	cost        float64
+}
+
+func newVariantStats() *variantStats {
+	return &variantStats{latency: NewLatencyStats(), endedBy: make(map[string]int)}
+}
+
+// record adds a finished call.
+func (s *variantStats) record(cdr *CallDetailRecord) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls++
+	s.seconds += cdr.DurationSeconds
+	s.turns += cdr.Turns
+	s.bargeIns += cdr.BargeIns
+	s.endedBy[cdr.EndedBy]++
+	if cdr.TransferredTo != "" {
+		s.transferred++
+	}
+	if cdr.Degradation != "" {
+		s.degraded++
+	}
+	if cdr.Cost != nil {
+		s.costed++
+		s.cost += cdr.Cost.Total
+	}
+}
+
+// VariantResult is one variant's aggregate results. Rates are shares of
+// its calls; latency is the total turn latency.
+type VariantResult struct {
+	Name               string         `json:"name"`
+	Weight             int            `json:"weight"`
+	Calls              int            `json:"calls"`
+	AvgDurationSeconds float64        `json:"avg_duration_seconds"`
+	AvgTurns           float64        `json:"avg_turns"`
+	BargeInsPerCall    float64        `json:"barge_ins_per_call"`
+	TransferRate       float64        `json:"transfer_rate"`
+	DegradedRate       float64        `json:"degraded_rate"`
+	EndedBy            map[string]int `json:"ended_by"`
+	LatencyP50         float64        `json:"latency_p50_ms"`
+	LatencyP90         float64        `json:"latency_p90_ms"`
+	AvgCost            float64        `json:"avg_cost"`
+}
+
+// result summarizes the variant.
+func (v *variant) result() VariantResult {
+	r := VariantResult{Name: v.name, Weight: v.weight, EndedBy: map[string]int{}}
+	total := v.stats.latency.Percentiles()[stageTotal]
+	r.LatencyP50, r.LatencyP90 = total.P50, total.P90
+
+	s := v.stats
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	r.Calls = s.calls
+	for endedBy, n := range s.endedBy {
+		r.EndedBy[endedBy] = n
+	}
+	if s.calls > 0 {
+		calls := float64(s.calls)
+		r.AvgDurationSeconds = s.seconds / calls
+		r.AvgTurns = float64(s.turns) / calls
+		r.BargeInsPerCall = float64(s.bargeIns) / calls
+		r.TransferRate = float64(s.transferred) / calls
+		r.DegradedRate = float64(s.degraded) / calls
+	}
+	if s.costed > 0 {
+		r.AvgCost = s.cost / float64(s.costed)
+	}
+	return r
+}
+
+// VariantComparison is a variant's results relative to the control's:
+// the fractional change in each metric, e.g. 0.1 for 10% higher. Metrics
+// the control has no value for are left out.
+type VariantComparison struct {
+	Variant string             `json:"variant"`
+	Control string             `json:"control"`
+	Change  map[string]float64 `json:"change"`
+}
+
+// compare compares r with control.
+func compare(r, control VariantResult) VariantComparison {
+	c := VariantComparison{Variant: r.Name, Control: control.Name, Change: map[string]float64{}}
+	if r.Calls == 0 || control.Calls == 0 {
+		return c
+	}
+	metrics := []struct {
+		name           string
+		value, control float64
+	}{
+		{"avg_duration_seconds", r.AvgDurationSeconds, control.AvgDurationSeconds},
+		{"avg_turns", r.AvgTurns, control.AvgTurns},
+		{"barge_ins_per_call", r.BargeInsPerCall, control.BargeInsPerCall},
+		{"transfer_rate", r.TransferRate, control.TransferRate},
+		{"degraded_rate", r.DegradedRate, control.DegradedRate},
+		{"latency_p50_ms", r.LatencyP50, control.LatencyP50},
+		{"latency_p90_ms", r.LatencyP90, control.LatencyP90},
+		{"avg_cost", r.AvgCost, control.AvgCost},
+	}
+	for _, m := range metrics {
+		if m.control != 0 {
+			c.Change[m.name] = (m.value - m.control) / m.control
+		}
+	}
+	return c
+}
+
+// ExperimentResult is an experiment's variants, each compared with the
+// control.
+type ExperimentResult struct {
+	Name        string              `json:"name"`
+	Variants    []VariantResult     `json:"variants"`
+	Comparisons []VariantComparison `json:"comparisons"`
+}
+
+// Results returns every experiment's results.
+func (e *Experiments) Results() []ExperimentResult {
+	results := make([]ExperimentResult, 0, len(e.experiments))
+	for _, x := range e.experiments {
+		r := ExperimentResult{Name: x.name}
+		for _, v := range x.variants {
+			r.Variants = append(r.Variants, v.result())
+		}
+		for _, v := range r.Variants[1:] {
+			r.Comparisons = append(r.Comparisons, compare(v, r.Variants[0]))
+		}
+		results = append(results, r)
+	}
+	return results
+}
+
+// ServeHTTP reports the results as JSON.
+func (e *Experiments) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]any{"experiments": e.Results()}); err != nil {
+		slog.Error("failed to write experiment results", "error", err)
+	}
+}
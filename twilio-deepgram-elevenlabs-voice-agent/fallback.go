@@ -0,0 +1,592 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	elevenlabs "github.com/agentplexus/go-elevenlabs"
+	elevenvoice "github.com/agentplexus/go-elevenlabs/omnivoice/tts"
+	deepgramstt "github.com/agentplexus/omnivoice-deepgram/omnivoice/stt"
+	"github.com/agentplexus/omnivoice-examples/kit/audio"
+	"github.com/agentplexus/omnivoice/stt"
+	"github.com/agentplexus/omnivoice/tts"
+)
+
+// Default objectives a primary provider must meet to keep its traffic.
+// STT latency is how long a stream takes to open; TTS latency is how long
+// synthesis takes to return its first audio.
+const (
+	defaultSTTFallbackSLOs = "p95<1s/2m,error_rate<20%/2m"
+	defaultTTSFallbackSLOs = "p95<1s/2m,error_rate<20%/2m"
+)
+
+// SecondaryProvider is a provider to fall back to. Model and VoiceID, if
+// set, replace those a session asks for; APIKey defaults to the primary's
+// when it is the same provider. URL is its endpoint, empty for the
+// provider's default.
+type SecondaryProvider struct {
+	Name    string
+	Model   string
+	VoiceID string
+	APIKey  string
+	URL     string
+}
+
+// secondaryVendors are the secondaries from another vendor than the
+// primaries, with the variables holding their API key and endpoint, and
+// the model and voice they use unless told otherwise: the primary's mean
+// nothing to them.
+var secondaryVendors = map[string]struct {
+	keyEnv, urlEnv string
+	defaults       SecondaryProvider
+}{
+	"assemblyai": {keyEnv: "ASSEMBLYAI_API_KEY", defaults: SecondaryProvider{Model: "universal-streaming-english"}},
+	"openai":     {keyEnv: "OPENAI_API_KEY", urlEnv: "OPENAI_BASE_URL", defaults: SecondaryProvider{Model: "gpt-4o-mini-tts", VoiceID: "alloy"}},
+}
+
+// withVendorDefaults fills in what spec leaves out for a secondary from
+// another vendor.
+func withVendorDefaults(spec SecondaryProvider) SecondaryProvider {
+	vendor, ok := secondaryVendors[spec.Name]
+	if !ok {
+		return spec
+	}
+	spec.Model = firstNonEmpty(spec.Model, vendor.defaults.Model)
+	spec.VoiceID = firstNonEmpty(spec.VoiceID, vendor.defaults.VoiceID)
+	spec.APIKey = firstNonEmpty(spec.APIKey, os.Getenv(vendor.keyEnv))
+	if vendor.urlEnv != "" {
+		spec.URL = firstNonEmpty(spec.URL, os.Getenv(vendor.urlEnv))
+	}
+	return spec
+}
+
+// FallbackConfig configures the secondary STT and TTS providers and when
+// traffic moves to them.
+type FallbackConfig struct {
+	// STT and TTS are the secondaries; a zero Name has none.
+	STT, TTS SecondaryProvider
+	// STTObjectives and TTSObjectives are what the provider in use must
+	// meet, judged per provider over rolling windows of its own requests.
+	STTObjectives, TTSObjectives []SLO
+	// MinSamples is how many requests a window needs before it's judged.
+	MinSamples int
+	// Interval is how often providers are judged.
+	Interval time.Duration
+	// FailbackAfter is how long a more preferred provider must stay clear
+	// before traffic moves back to it.
+	FailbackAfter time.Duration
+}
+
+// defaultFallbackConfig returns the configuration used unless overridden
+// by STT_SECONDARY, STT_SECONDARY_MODEL, STT_SECONDARY_API_KEY,
+// TTS_SECONDARY, TTS_SECONDARY_MODEL, TTS_SECONDARY_VOICE_ID,
+// TTS_SECONDARY_API_KEY, ASSEMBLYAI_API_KEY, OPENAI_API_KEY,
+// OPENAI_BASE_URL, STT_FALLBACK_SLOS, TTS_FALLBACK_SLOS,
+// FALLBACK_MIN_SAMPLES, FALLBACK_CHECK_INTERVAL and FALLBACK_FAILBACK_AFTER.
+func defaultFallbackConfig() FallbackConfig {
+	sttObjectives, err := parseFallbackSLOs(defaultSTTFallbackSLOs)
+	if err != nil {
+		panic(err)
+	}
+	ttsObjectives, err := parseFallbackSLOs(defaultTTSFallbackSLOs)
+	if err != nil {
+		panic(err)
+	}
+	return FallbackConfig{
+		STTObjectives: sttObjectives,
+		TTSObjectives: ttsObjectives,
+		MinSamples:    5,
+		Interval:      10 * time.Second,
+		FailbackAfter: time.Minute,
+	}
+}
+
+// fallbackConfigFromEnv applies environment overrides to the defaults.
+// Without STT_SECONDARY or TTS_SECONDARY there is no fallback.
+func fallbackConfigFromEnv() (FallbackConfig, error) {
+	cfg := defaultFallbackConfig()
+	cfg.STT = SecondaryProvider{
+		Name:   strings.ToLower(strings.TrimSpace(os.Getenv("STT_SECONDARY"))),
+		Model:  os.Getenv("STT_SECONDARY_MODEL"),
+		APIKey: os.Getenv("STT_SECONDARY_API_KEY"),
+	}
+	cfg.TTS = SecondaryProvider{
+		Name:    strings.ToLower(strings.TrimSpace(os.Getenv("TTS_SECONDARY"))),
+		Model:   os.Getenv("TTS_SECONDARY_MODEL"),
+		VoiceID: os.Getenv("TTS_SECONDARY_VOICE_ID"),
+		APIKey:  os.Getenv("TTS_SECONDARY_API_KEY"),
+	}
+	cfg.STT, cfg.TTS = withVendorDefaults(cfg.STT), withVendorDefaults(cfg.TTS)
+	for key, objectives := range map[string]*[]SLO{"STT_FALLBACK_SLOS": &cfg.STTObjectives, "TTS_FALLBACK_SLOS": &cfg.TTSObjectives} {
+		if v := os.Getenv(key); v != "" {
+			parsed, err := parseFallbackSLOs(v)
+			if err != nil {
+				return cfg, fmt.Errorf("invalid %s: %w", key, err)
+			}
+			*objectives = parsed
+		}
+	}
+	if v := os.Getenv("FALLBACK_MIN_SAMPLES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return cfg, fmt.Errorf("invalid FALLBACK_MIN_SAMPLES: %q", v)
+		}
+		cfg.MinSamples = n
+	}
+	if v := os.Getenv("FALLBACK_CHECK_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return cfg, fmt.Errorf("invalid FALLBACK_CHECK_INTERVAL: %q", v)
+		}
+		cfg.Interval = d
+	}
+	if v := os.Getenv("FALLBACK_FAILBACK_AFTER"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return cfg, fmt.Errorf("invalid FALLBACK_FAILBACK_AFTER: %q", v)
+		}
+		cfg.FailbackAfter = d
+	}
+	return cfg, nil
+}
+
+// parseFallbackSLOs parses objectives as for SLOS. Providers are judged
+// on their own latency, so objectives name no stage.
+func parseFallbackSLOs(s string) ([]SLO, error) {
+	objectives, err := parseSLOs(s)
+	if err != nil {
+		return nil, err
+	}
+	for _, slo := range objectives {
+		if slo.Stage != "" && slo.Stage != stageTotal {
+			return nil, fmt.Errorf("%q: provider objectives are pNN or error_rate, without a stage", slo.Name)
+		}
+	}
+	return objectives, nil
+}
+
+// newSecondarySTT creates the secondary STT provider spec names.
+func newSecondarySTT(spec SecondaryProvider, offline OfflineConfig) (stt.StreamingProvider, error) {
+	switch spec.Name {
+	case "deepgram":
+		p, err := deepgramstt.New(deepgramstt.WithAPIKey(spec.APIKey))
+		if err != nil {
+			return nil, err
+		}
+		return p, nil
+	case "assemblyai":
+		return newAssemblyAISTT(spec.APIKey)
+	case "mock":
+		_, p := offline.Providers()
+		return p, nil
+	}
+	return nil, fmt.Errorf("unsupported STT provider %q (want deepgram, assemblyai or mock)", spec.Name)
+}
+
+// newSecondaryTTS creates the secondary TTS provider spec names. One that
+// only speaks linear PCM resamples it with quality.
+func newSecondaryTTS(spec SecondaryProvider, offline OfflineConfig, quality audio.Quality) (tts.StreamingProvider, error) {
+	switch spec.Name {
+	case "elevenlabs":
+		client, err := elevenlabs.NewClient(elevenlabs.WithAPIKey(spec.APIKey))
+		if err != nil {
+			return nil, err
+		}
+		return elevenvoice.NewWithClient(client), nil
+	case "openai":
+		return newOpenAITTS(spec.APIKey, spec.URL, quality)
+	case "mock":
+		p, _ := offline.Providers()
+		return p, nil
+	}
+	return nil, fmt.Errorf("unsupported TTS provider %q (want elevenlabs, openai or mock)", spec.Name)
+}
+
+// chainMember is one provider in a fallback chain, with the samples its
+// health is judged on.
+type chainMember struct {
+	role     string // "primary" or "secondary"
+	provider string
+	model    string
+	// probe names the member's health check, if it has one.
+	probe   string
+	samples *SLOMonitor
+}
+
+// ChainMemberStatus is a provider's standing in its chain.
+type ChainMemberStatus struct {
+	Role       string      `json:"role"`
+	Provider   string      `json:"provider"`
+	Model      string      `json:"model,omitempty"`
+	Active     bool        `json:"active"`
+	Breached   bool        `json:"breached"`
+	Unhealthy  bool        `json:"unhealthy,omitempty"`
+	Objectives []SLOStatus `json:"objectives"`
+}
+
+// providerChain routes requests to the most preferred provider meeting
+// its objectives. Requests go to the active provider first and fall
+// through to the others when it fails, so a failure between checks costs
+// a retry rather than the request. Each check moves traffic off a
+// breached provider at once, and back once a more preferred one has
+// stayed clear for FailbackAfter.
+type providerChain struct {
+	kind          string // "stt" or "tts"
+	objectives    []SLO
+	failbackAfter time.Duration
+	members       []chainMember
+	health        *HealthChecker
+	now           func() time.Time
+
+	mu         sync.Mutex
+	active     int
+	since      time.Time
+	clearSince time.Time // when a more preferred provider was first found clear
+	failovers  int
+	status     []ChainMemberStatus
+}
+
+func newProviderChain(kind string, objectives []SLO, cfg FallbackConfig, health *HealthChecker, members ...chainMember) *providerChain {
+	for i := range members {
+		members[i].samples = NewSLOMonitor(SLOConfig{Objectives: objectives, MinSamples: cfg.MinSamples})
+	}
+	return &providerChain{
+		kind:          kind,
+		objectives:    objectives,
+		failbackAfter: cfg.FailbackAfter,
+		members:       members,
+		health:        health,
+		now:           time.Now,
+		since:         time.Now(),
+	}
+}
+
+// order returns the members to try, the active one first and the rest in
+// preference order.
+func (c *providerChain) order() []int {
+	c.mu.Lock()
+	active := c.active
+	c.mu.Unlock()
+	order := []int{active}
+	for i := range c.members {
+		if i != active {
+			order = append(order, i)
+		}
+	}
+	return order
+}
+
+// activeMember returns the index of the provider in use.
+func (c *providerChain) activeMember() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.active
+}
+
+// record records a request's latency against member i.
+func (c *providerChain) record(i int, latency time.Duration) {
+	c.members[i].samples.RecordTurn(map[string]time.Duration{stageTotal: latency})
+}
+
+// recordError records a failed request against member i.
+func (c *providerChain) recordError(i int) {
+	c.members[i].samples.RecordError()
+}
+
+// Evaluate judges every provider and moves traffic to the one the chain
+// should use.
+func (c *providerChain) Evaluate(ctx context.Context) {
+	var probes map[string]healthResult
+	if c.health != nil {
+		probes, _ = c.health.Results(ctx)
+	}
+
+	statuses := make([]ChainMemberStatus, len(c.members))
+	best := -1
+	var reasons []string
+	for i, m := range c.members {
+		status := ChainMemberStatus{Role: m.role, Provider: m.provider, Model: m.model}
+		for _, slo := range c.objectives {
+			s := m.samples.measure(slo)
+			if s.Breached {
+				status.Breached = true
+				reasons = append(reasons, m.role+" "+slo.Name)
+			}
+			status.Objectives = append(status.Objectives, s)
+		}
+		if r, ok := probes[m.probe]; ok && m.probe != "" && !r.OK {
+			status.Breached, status.Unhealthy = true, true
+			reasons = append(reasons, m.role+" unhealthy")
+		}
+		if !status.Breached && best < 0 {
+			best = i
+		}
+		statuses[i] = status
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	switch {
+	case best < 0 || best == c.active:
+		// Nowhere better to go
+		c.clearSince = time.Time{}
+	case statuses[c.active].Breached:
+		slog.Warn("provider failing over", "kind", c.kind, "from", c.members[c.active].role, "to", c.members[best].role,
+			"provider", c.members[best].provider, "reason", strings.Join(reasons, ", "))
+		c.failovers++
+		c.moveTo(best, now)
+	default:
+		// A more preferred provider is clear again
+		if c.clearSince.IsZero() {
+			c.clearSince = now
+		}
+		if now.Sub(c.clearSince) < c.failbackAfter {
+			break
+		}
+		slog.Info("provider failing back", "kind", c.kind, "from", c.members[c.active].role, "to", c.members[best].role,
+			"provider", c.members[best].provider)
+		c.moveTo(best, now)
+	}
+	for i := range statuses {
+		statuses[i].Active = i == c.active
+	}
+	c.status = statuses
+}
+
+// moveTo makes member i active. c.mu must be held.
+func (c *providerChain) moveTo(i int, now time.Time) {
+	c.active, c.since = i, now
+	c.clearSince = time.Time{}
+}
+
+// report returns the chain's standing for /stats/providers.
+func (c *providerChain) report() map[string]any {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return map[string]any{
+		"active":    c.members[c.active].role,
+		"since":     c.since,
+		"failovers": c.failovers,
+		"providers": c.status,
+	}
+}
+
+// ProviderFallback holds the STT and TTS fallback chains. A nil
+// ProviderFallback, or a nil chain in it, has no fallback.
+type ProviderFallback struct {
+	interval time.Duration
+	stt      *providerChain
+	tts      *providerChain
+}
+
+// Run judges the chains every interval until ctx is done.
+func (f *ProviderFallback) Run(ctx context.Context) {
+	if f == nil {
+		return
+	}
+	ticker := time.NewTicker(f.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, c := range []*providerChain{f.stt, f.tts} {
+				if c != nil {
+					c.Evaluate(ctx)
+				}
+			}
+		}
+	}
+}
+
+// ServeHTTP reports each chain's providers and which is in use as JSON.
+func (f *ProviderFallback) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	report := make(map[string]any, 2)
+	for kind, c := range map[string]*providerChain{"stt": f.stt, "tts": f.tts} {
+		if c != nil {
+			report[kind] = c.report()
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		slog.Error("failed to write provider status", "error", err)
+	}
+}
+
+// fallbackSTT opens each stream on the STT provider its chain has in
+// use, falling through to the others if it can't. A stream stays with
+// its provider; one that drops is reopened (see resilientSTT) through
+// the chain, so a call moves provider only then.
+type fallbackSTT struct {
+	stt.StreamingProvider // the primary, for everything but streaming
+	chain                 *providerChain
+	providers             []stt.StreamingProvider
+	models                []string // replacing the session's, if set
+}
+
+var _ stt.StreamingProvider = (*fallbackSTT)(nil)
+
+// newFallbackSTT chains primary with secondary.
+func newFallbackSTT(primary, secondary stt.StreamingProvider, cfg FallbackConfig, health *HealthChecker, probe string) *fallbackSTT {
+	return &fallbackSTT{
+		StreamingProvider: primary,
+		chain: newProviderChain("stt", cfg.STTObjectives, cfg, health,
+			chainMember{role: "primary", provider: primary.Name(), probe: probe},
+			chainMember{role: "secondary", provider: secondary.Name(), model: cfg.STT.Model}),
+		providers: []stt.StreamingProvider{primary, secondary},
+		models:    []string{"", cfg.STT.Model},
+	}
+}
+
+// Name returns the name of the provider in use.
+func (p *fallbackSTT) Name() string {
+	return p.providers[p.chain.activeMember()].Name()
+}
+
+// TranscribeStream opens a stream on each provider in turn.
+func (p *fallbackSTT) TranscribeStream(ctx context.Context, config stt.TranscriptionConfig) (io.WriteCloser, <-chan stt.StreamEvent, error) {
+	var errs []error
+	for _, i := range p.chain.order() {
+		c := config
+		if p.models[i] != "" {
+			c.Model = p.models[i]
+		}
+		start := time.Now()
+		w, events, err := p.providers[i].TranscribeStream(ctx, c)
+		if err == nil {
+			p.chain.record(i, time.Since(start))
+			return w, p.watch(ctx, i, events), nil
+		}
+		if ctx.Err() != nil {
+			return nil, nil, err
+		}
+		p.chain.recordError(i)
+		slog.Warn("STT provider failed, trying the next", "provider", p.providers[i].Name(), "error", err)
+		errs = append(errs, fmt.Errorf("%s: %w", p.providers[i].Name(), err))
+	}
+	return nil, nil, errors.Join(errs...)
+}
+
+// watch passes a stream's events on, counting its errors against member i.
+func (p *fallbackSTT) watch(ctx context.Context, i int, events <-chan stt.StreamEvent) <-chan stt.StreamEvent {
+	out := make(chan stt.StreamEvent, cap(events))
+	go func() {
+		defer close(out)
+		for e := range events {
+			if e.Type == stt.EventError && ctx.Err() == nil {
+				p.chain.recordError(i)
+			}
+			select {
+			case out <- e:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out
+}
+
+// fallbackTTS synthesizes with the TTS provider its chain has in use,
+// falling through to the others when synthesis fails before any audio.
+type fallbackTTS struct {
+	tts.StreamingProvider // the primary, for voices
+	chain                 *providerChain
+	providers             []tts.StreamingProvider
+	models, voices        []string // replacing the session's, if set
+}
+
+var _ tts.StreamingProvider = (*fallbackTTS)(nil)
+
+// newFallbackTTS chains primary with secondary.
+func newFallbackTTS(primary, secondary tts.StreamingProvider, cfg FallbackConfig, health *HealthChecker, probe string) *fallbackTTS {
+	return &fallbackTTS{
+		StreamingProvider: primary,
+		chain: newProviderChain("tts", cfg.TTSObjectives, cfg, health,
+			chainMember{role: "primary", provider: primary.Name(), probe: probe},
+			chainMember{role: "secondary", provider: secondary.Name(), model: cfg.TTS.Model}),
+		providers: []tts.StreamingProvider{primary, secondary},
+		models:    []string{"", cfg.TTS.Model},
+		voices:    []string{"", cfg.TTS.VoiceID},
+	}
+}
+
+// Name returns the name of the provider in use.
+func (p *fallbackTTS) Name() string {
+	return p.providers[p.chain.activeMember()].Name()
+}
+
+func (p *fallbackTTS) config(i int, config tts.SynthesisConfig) tts.SynthesisConfig {
+	if p.models[i] != "" {
+		config.Model = p.models[i]
+	}
+	if p.voices[i] != "" {
+		config.VoiceID = p.voices[i]
+	}
+	return config
+}
+
+// Synthesize converts text to speech with each provider in turn.
+func (p *fallbackTTS) Synthesize(ctx context.Context, text string, config tts.SynthesisConfig) (*tts.SynthesisResult, error) {
+	var errs []error
+	for _, i := range p.chain.order() {
+		start := time.Now()
+		result, err := p.providers[i].Synthesize(ctx, text, p.config(i, config))
+		if err == nil {
+			p.chain.record(i, time.Since(start))
+			return result, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		p.chain.recordError(i)
+		errs = append(errs, fmt.Errorf("%s: %w", p.providers[i].Name(), err))
+	}
+	return nil, errors.Join(errs...)
+}
+
+// SynthesizeStream streams synthesis from each provider in turn, until
+// one returns audio.
+func (p *fallbackTTS) SynthesizeStream(ctx context.Context, text string, config tts.SynthesisConfig) (<-chan tts.StreamChunk, error) {
+	var errs []error
+	for _, i := range p.chain.order() {
+		start := time.Now()
+		ch, err := p.providers[i].SynthesizeStream(ctx, text, p.config(i, config))
+		if err == nil {
+			ch, err = firstChunk(ctx, ch)
+		}
+		if err == nil {
+			p.chain.record(i, time.Since(start))
+			return ch, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		p.chain.recordError(i)
+		slog.Warn("TTS provider failed, trying the next", "provider", p.providers[i].Name(), "error", err)
+		errs = append(errs, fmt.Errorf("%s: %w", p.providers[i].Name(), err))
+	}
+	return nil, errors.Join(errs...)
+}
+
+// SynthesizeFromReader streams text from a reader through the provider in
+// use. The reader can only be consumed once, so there is no fallback.
+func (p *fallbackTTS) SynthesizeFromReader(ctx context.Context, reader io.Reader, config tts.SynthesisConfig) (<-chan tts.StreamChunk, error) {
+	i := p.chain.activeMember()
+	ch, err := p.providers[i].SynthesizeFromReader(ctx, reader, p.config(i, config))
+	if err != nil && ctx.Err() == nil {
+		p.chain.recordError(i)
+	}
+	return ch, err
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/golden"
+	"github.com/agentplexus/omnivoice-examples/kit/phone"
+	"github.com/agentplexus/omnivoice-examples/kit/twilioauth"
+	"github.com/agentplexus/omnivoice-examples/kit/twiml"
+)
+
+// goldenDir holds the expected output of every TwiML document and outgoing
+// request body the server produces, rendered from fixed inputs.
+const goldenDir = "testdata/golden"
+
+// Fixed inputs for the snapshots.
+const (
+	goldenAccountSID = "AC00000000000000000000000000000000"
+	goldenCallSID    = "CA00000000000000000000000000000000"
+	goldenStreamURL  = "wss://voice.example.com/media-stream"
+)
+
+var goldenTransferNumber = phone.Number{E164: "+15551230003"}
+
+// goldenCase renders one snapshot.
+type goldenCase struct {
+	name   string
+	render func(ctx context.Context) (string, error)
+}
+
+// goldenCases returns a case for each TwiML builder and each request the
+// server sends to Twilio or downstream consumers.
+func goldenCases() []goldenCase {
+	limits := defaultSessionLimits()
+	metadata := metadataFromWebhook(map[string][]string{
+		"CallSid":                {goldenCallSID},
+		"From":                   {"+15551230001"},
+		"To":                     {"+15551230002"},
+		"SipHeader_X-Account-Id": {"acct-42"},
+		"SipHeader_X-Ticket-Id":  {`T-7 "urgent" <escalated> & open`},
+	}, nil)
+	doc := func(s string) func(context.Context) (string, error) {
+		return func(context.Context) (string, error) { return s, nil }
+	}
+	configured := TwiMLConfig{
+		ConnectMessage: "Thanks for calling Acme & Co. One moment…",
+		Voice:          "Polly.Amy",
+		Language:       "en-GB",
+		Parameters:     map[string]string{"brand": "acme", "region": "eu"},
+	}
+	signed := metadata.streamParameters()
+	signed[paramStreamToken] = twilioauth.StreamToken("token", goldenCallSID, time.Date(2025, 1, 2, 15, 9, 5, 0, time.UTC))
+
+	return []goldenCase{
+		// TwiML returned by the voice webhook
+		{"connect.xml", doc(connectTwiML(defaultTwiMLConfig(), goldenStreamURL, metadata.streamParameters(), ""))},
+		{"connect-signed.xml", doc(connectTwiML(defaultTwiMLConfig(), goldenStreamURL, signed, ""))},
+		{"connect-minimal.xml", doc(connectTwiML(defaultTwiMLConfig(), goldenStreamURL, metadataFromWebhook(map[string][]string{"CallSid": {goldenCallSID}}, nil).streamParameters(), ""))},
+		{"connect-escaped.xml", doc(connectTwiML(defaultTwiMLConfig(), goldenStreamURL, metadataFromWebhook(map[string][]string{
+			"CallSid": {goldenCallSID},
+			"From":    {`"O'Brien & Sons" <sip:+15551230001@pbx.example.com>`},
+			"To":      {"+15551230002"},
+		}, nil).streamParameters(), ""))},
+		{"connect-configured.xml", doc(connectTwiML(configured, goldenStreamURL, metadataFromWebhook(map[string][]string{
+			"CallSid": {goldenCallSID},
+			"From":    {"+15551230001"},
+			"To":      {"+15551230002"},
+		}, configured.Parameters).streamParameters(), ""))},
+		{"connect-reconnect.xml", doc(connectTwiML(defaultTwiMLConfig(), goldenStreamURL, metadata.streamParameters(), "https://voice.example.com/voice/inbound"))},
+		{"hangup-busy.xml", doc(hangupTwiML(twiml.Say{Text: limits.BusyMessage}))},
+		{"hangup-rate-limited.xml", doc(hangupTwiML(twiml.Say{Text: limits.RateLimitedMessage}))},
+		{"hangup-silent.xml", doc(hangupTwiML(twiml.Say{}))},
+		{"hold.xml", doc(holdTwiML(defaultTwiMLConfig(), limits, 3, "https://voice.example.com/voice/hold"))},
+		{"voicemail.xml", doc((&DegradationLadder{cfg: defaultDegradationConfig()}).voicemailTwiML(defaultTwiMLConfig(), "https://voice.example.com/voice/voicemail"))},
+		{"after-hours.xml", doc(defaultBusinessHours().afterHoursTwiML(defaultTwiMLConfig(), "https://voice.example.com/voice/after-hours"))},
+		{"hangup-escaped.xml", doc(hangupTwiML(twiml.Say{Text: `Lines are busy <sorry> & "goodbye"`}))},
+
+		// TwiML sent to live calls
+		{"transfer-number.xml", doc(transferTwiML(goldenTransferNumber, "", goldenCallSID))},
+		{"transfer-extension.xml", doc(transferTwiML(phone.Number{E164: "+15551230003", Extension: "204"}, "", goldenCallSID))},
+		{"transfer-sip.xml", doc(transferTwiML(phone.Number{SIP: "sip:support@pbx.example.com;transport=tls"}, "", goldenCallSID))},
+		{"transfer-coached.xml", doc(transferTwiML(goldenTransferNumber, goldenStreamURL, goldenCallSID))},
+
+		// Twilio REST API requests
+		{"twilio-end-call.txt", goldenTwilio(func(ctx context.Context, call *CallSession) error {
+			return call.EndCall(ctx)
+		})},
+		{"twilio-redirect-busy.txt", goldenTwilio(func(ctx context.Context, call *CallSession) error {
+			return call.Redirect(ctx, hangupTwiML(twiml.Say{Text: limits.BusyMessage}))
+		})},
+		{"twilio-redirect-url.txt", goldenTwilio(func(ctx context.Context, call *CallSession) error {
+			return call.RedirectURL(ctx, "https://voice.example.com/twiml/hold?queue=support&lang=en")
+		})},
+		{"twilio-transfer-coached.txt", goldenTwilio(func(ctx context.Context, call *CallSession) error {
+			return call.Transfer(ctx, goldenTransferNumber, goldenStreamURL)
+		})},
+		{"twilio-campaign-call.txt", goldenTwilio(func(ctx context.Context, call *CallSession) error {
+			query := "?contact=" + url.QueryEscape("a&1")
+			_, err := call.twilio.CreateCall(ctx, outboundCall{
+				To:               "+15551230002",
+				From:             "+15550000000",
+				URL:              "https://voice.example.com/voice/outbound" + query,
+				StatusCallback:   "https://voice.example.com/voice/outbound/status" + query,
+				Timeout:          30 * time.Second,
+				MachineDetection: true,
+			})
+			return err
+		})},
+		{"twilio-sms.txt", goldenTwilio(func(ctx context.Context, call *CallSession) error {
+			_, err := call.twilio.SendSMS(ctx, "+15551230001", "+15550000000", "Thanks for calling. Your confirmation number is AB-12 & C.")
+			return err
+		})},
+		{"twilio-recording.txt", goldenTwilio(func(ctx context.Context, call *CallSession) error {
+			if err := call.StartRecording(ctx); err != nil {
+				return err
+			}
+			return call.StopRecording(ctx)
+		})},
+		{"twilio-recording-pause.txt", goldenTwilio(func(ctx context.Context, call *CallSession) error {
+			if err := call.StartRecording(ctx); err != nil {
+				return err
+			}
+			if _, err := call.PauseRecording(ctx); err != nil {
+				return err
+			}
+			return call.ResumeRecording(ctx)
+		})},
+
+		// The call detail record consumed by log pipelines
+		{"cdr.json", func(context.Context) (string, error) {
+			data, err := json.Marshal(goldenCDR())
+			return string(data), err
+		}},
+
+		// Session events POSTed to EVENTS_WEBHOOK_URL
+		{"event-session-started.txt", goldenEvent(SessionStarted{Event: goldenEventHeader(), From: "+15551230001", To: "+15551230002", Tenant: "acme", Resumed: true})},
+		{"event-turn-completed.txt", goldenEvent(TurnCompleted{Event: goldenEventHeader(), Turn: 2, Text: `Where's my order "A&B"?`, Reply: "It ships tomorrow.", AnsweredBy: "agent"})},
+		{"event-barge-in.txt", goldenEvent(BargeIn{Event: goldenEventHeader(), Turn: 3, DiscardedMs: 1200})},
+		{"event-provider-error.txt", goldenEvent(ProviderError{Event: goldenEventHeader(), Stage: "tts", Error: "elevenlabs: 503 Service Unavailable"})},
+		{"event-emergency-detected.txt", goldenEvent(EmergencyDetected{
+			Event:         goldenEventHeader(),
+			From:          "+15551230001",
+			To:            "+15551230002",
+			Turn:          4,
+			Categories:    []string{"medical"},
+			Phrases:       []string{"chest pain"},
+			Text:          "I have chest pain",
+			TransferredTo: "+15551230003",
+		})},
+		{"event-session-ended.txt", goldenEvent(SessionEnded{
+			Event:      goldenEventHeader(),
+			From:       "+15551230001",
+			To:         "+15551230002",
+			EndedBy:    "transfer",
+			CDR:        goldenCDR(),
+			Transcript: []TranscriptLine{{Speaker: "caller", Text: "not sent"}},
+		})},
+		{"event-voicemail-received.txt", goldenEvent(VoicemailReceived{
+			Event:           goldenEventHeader(),
+			From:            "+15551230001",
+			To:              "+15551230002",
+			RecordingSID:    "RE00000000000000000000000000000000",
+			DurationSeconds: 14,
+			Key:             goldenCallSID + "/voicemail.wav",
+			Transcript:      "Please call me back tomorrow.",
+		})},
+	}
+}
+
+// goldenEventHeader is the header every golden event carries.
+func goldenEventHeader() Event {
+	return Event{SessionID: "session-1", CallSID: goldenCallSID, At: time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC)}
+}
+
+// goldenEvent sends e to an event webhook stand-in and renders the
+// request it received.
+func goldenEvent(e SessionEvent) func(context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		var (
+			mu      sync.Mutex
+			request string
+		)
+		api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			mu.Lock()
+			request = fmt.Sprintf("%s %s\nContent-Type: %s\n\n%s\n", r.Method, r.URL.Path, r.Header.Get("Content-Type"), body)
+			mu.Unlock()
+		}))
+		defer api.Close()
+
+		w := &eventWebhook{cfg: EventWebhookConfig{URL: api.URL + "/events"}, client: api.Client()}
+		if err := w.send(ctx, envelope(e)); err != nil {
+			return "", err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return request, nil
+	}
+}
+
+// goldenCDR is a record with every field set.
+func goldenCDR() *CallDetailRecord {
+	started := time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC)
+	return &CallDetailRecord{
+		SessionID:       "session-1",
+		StartedAt:       started,
+		EndedAt:         started.Add(95 * time.Second),
+		DurationSeconds: 95,
+		Turns:           6,
+		BargeIns:        2,
+		EndedBy:         "transfer",
+		Residency:       ResidencyEU.String(),
+		Tenant:          "acme",
+		AccountID:       "acct-42",
+		TicketID:        "T-7",
+		RecordingSIDs:   []string{"RE00000000000000000000000000000000"},
+		TransferredTo:   "+15551230003",
+		Coached:         true,
+		Topics: []TopicSegment{
+			{Label: "billing", StartTurn: 1, EndTurn: 4, StartedAt: started},
+			{Label: "transfer", StartTurn: 5, EndTurn: 6, StartedAt: started.Add(70 * time.Second)},
+		},
+	}
+}
+
+// goldenTwilio runs fn against a Twilio API stand-in and renders the
+// requests it made.
+func goldenTwilio(fn func(ctx context.Context, call *CallSession) error) func(context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		var (
+			mu       sync.Mutex
+			requests strings.Builder
+		)
+		api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			body, _ := io.ReadAll(r.Body)
+			mu.Lock()
+			fmt.Fprintf(&requests, "%s %s\nContent-Type: %s\n\n%s\n\n", r.Method, r.URL.Path, r.Header.Get("Content-Type"), body)
+			mu.Unlock()
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = io.WriteString(w, `{"sid": "RE00000000000000000000000000000000"}`)
+		}))
+		defer api.Close()
+
+		client := newTwilioClient(goldenAccountSID, "token")
+		client.baseURL = api.URL
+		logger := slog.New(slog.DiscardHandler)
+		call := newCallSession("session-1", goldenCallSID, client, newCallDetailRecord("session-1", ResidencyAny), logger)
+		if err := fn(ctx, call); err != nil {
+			return "", err
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return requests.String(), nil
+	}
+}
+
+// TestGolden renders every case and compares it with its golden file;
+// -update rewrites the files.
+func TestGolden(t *testing.T) {
+	files := make(map[string]string)
+	for _, c := range goldenCases() {
+		got, err := c.render(t.Context())
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		files[c.name] = got
+	}
+	golden.Check(t, goldenDir, files, *update)
+}
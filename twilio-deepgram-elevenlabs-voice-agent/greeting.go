@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/phone"
+)
+
+// GreetingPolicy decides when the agent greets the caller.
+type GreetingPolicy string
+
+const (
+	// GreetingImmediate greets as soon as the call connects.
+	GreetingImmediate GreetingPolicy = "immediate"
+	// GreetingAfterFirstVoice waits for the caller to speak first, e.g.
+	// "Hello?", and answers that first utterance with the greeting.
+	GreetingAfterFirstVoice GreetingPolicy = "after-first-voice"
+	// GreetingAfterSilence waits like GreetingAfterFirstVoice, but greets
+	// anyway once the caller has been silent for the silence timeout.
+	GreetingAfterSilence GreetingPolicy = "after-silence-timeout"
+)
+
+// parseGreetingPolicy parses a policy name.
+func parseGreetingPolicy(s string) (GreetingPolicy, error) {
+	switch p := GreetingPolicy(strings.ToLower(strings.TrimSpace(s))); p {
+	case GreetingImmediate, GreetingAfterFirstVoice, GreetingAfterSilence:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown greeting policy %q (want immediate, after-first-voice or after-silence-timeout)", s)
+	}
+}
+
+// GreetingConfig chooses a greeting policy per tenant. Tenants are told
+// apart by the number the caller dialed.
+type GreetingConfig struct {
+	// Policy applies to numbers without one of their own. Empty is
+	// GreetingImmediate.
+	Policy GreetingPolicy
+	// ByNumber holds the policy of each tenant's number, keyed by its
+	// normalized form (see phone.DialPlan.Normalize).
+	ByNumber map[string]GreetingPolicy
+	// SilenceTimeout is how long GreetingAfterSilence waits for the caller.
+	SilenceTimeout time.Duration
+	// Text is the greeting, unless the agent chooses its own.
+	Text string
+}
+
+// defaultGreetingConfig returns the configuration used unless overridden by
+// GREETING_POLICY and GREETING_POLICY_BY_NUMBER. The text and silence
+// timeout are set from the config's prompts.greeting (GREETING) and
+// timeouts.greeting_silence (GREETING_SILENCE_TIMEOUT).
+func defaultGreetingConfig() GreetingConfig {
+	return GreetingConfig{
+		Policy:         GreetingImmediate,
+		SilenceTimeout: 3 * time.Second,
+		Text:           "Hello! I'm your voice assistant powered by Deepgram and ElevenLabs. How can I help you today?",
+	}
+}
+
+// greetingConfigFromEnv applies environment overrides to the defaults.
+// GREETING_POLICY_BY_NUMBER is a comma-separated list of number=policy,
+// e.g. "+15551230001=after-first-voice,+15551230002=immediate"; its numbers
+// are read with plan.
+func greetingConfigFromEnv(plan phone.DialPlan) (GreetingConfig, error) {
+	cfg := defaultGreetingConfig()
+	if v := os.Getenv("GREETING_POLICY"); v != "" {
+		p, err := parseGreetingPolicy(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid GREETING_POLICY: %w", err)
+		}
+		cfg.Policy = p
+	}
+	if v := os.Getenv("GREETING_POLICY_BY_NUMBER"); v != "" {
+		cfg.ByNumber = make(map[string]GreetingPolicy)
+		for _, entry := range strings.Split(v, ",") {
+			number, name, ok := strings.Cut(entry, "=")
+			number = strings.TrimSpace(number)
+			if !ok || number == "" {
+				return cfg, fmt.Errorf("invalid GREETING_POLICY_BY_NUMBER entry %q (want number=policy)", entry)
+			}
+			n, err := plan.Parse(number)
+			if err != nil {
+				return cfg, fmt.Errorf("invalid GREETING_POLICY_BY_NUMBER: %w", err)
+			}
+			p, err := parseGreetingPolicy(name)
+			if err != nil {
+				return cfg, fmt.Errorf("invalid GREETING_POLICY_BY_NUMBER: %w", err)
+			}
+			cfg.ByNumber[n.Address()] = p
+		}
+	}
+	return cfg, nil
+}
+
+// PolicyFor returns the policy for calls to number, which must be
+// normalized.
+func (c GreetingConfig) PolicyFor(number string) GreetingPolicy {
+	if p, ok := c.ByNumber[number]; ok {
+		return p
+	}
+	if c.Policy == "" {
+		return GreetingImmediate
+	}
+	return c.Policy
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// healthCacheTTL is how long probe results are reused, so frequent
+	// Kubernetes probes don't hit the provider APIs on every request.
+	healthCacheTTL = 30 * time.Second
+	// healthProbeTimeout bounds each provider probe.
+	healthProbeTimeout = 5 * time.Second
+)
+
+// healthCheck is a cheap authenticated request against one provider.
+type healthCheck struct {
+	name  string
+	probe func(ctx context.Context) error
+}
+
+// healthResult is the outcome of one provider probe.
+type healthResult struct {
+	OK        bool      `json:"ok"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// HealthChecker probes provider connectivity and credentials for the
+// /healthz and /readyz endpoints, caching results for healthCacheTTL.
+type HealthChecker struct {
+	checks   []healthCheck
+	draining atomic.Bool
+
+	refresh sync.Mutex // held while probing, so concurrent requests share one round
+
+	mu        sync.Mutex
+	results   map[string]healthResult
+	checkedAt time.Time
+}
+
+// NewHealthChecker creates a checker with no probes.
+func NewHealthChecker() *HealthChecker {
+	return &HealthChecker{}
+}
+
+// SetDraining makes readiness fail so no new calls are routed here while
+// the server shuts down.
+func (h *HealthChecker) SetDraining() {
+	h.draining.Store(true)
+}
+
+// Add registers a provider probe.
+func (h *HealthChecker) Add(name string, probe func(ctx context.Context) error) {
+	h.checks = append(h.checks, healthCheck{name: name, probe: probe})
+}
+
+// Results returns the latest probe results, probing again if they are
+// stale, and whether every provider is reachable.
+func (h *HealthChecker) Results(ctx context.Context) (map[string]healthResult, bool) {
+	h.refresh.Lock()
+	defer h.refresh.Unlock()
+
+	h.mu.Lock()
+	stale := h.results == nil || time.Since(h.checkedAt) > healthCacheTTL
+	h.mu.Unlock()
+	if stale {
+		results := h.probe(ctx)
+		h.mu.Lock()
+		h.results, h.checkedAt = results, time.Now()
+		h.mu.Unlock()
+	}
+	return h.Cached()
+}
+
+// Cached returns the latest results without probing; ok is false until
+// the first probe completes.
+func (h *HealthChecker) Cached() (map[string]healthResult, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	ok := h.results != nil
+	results := make(map[string]healthResult, len(h.results))
+	for name, r := range h.results {
+		results[name] = r
+		ok = ok && r.OK
+	}
+	return results, ok
+}
+
+// probe runs every check in parallel.
+func (h *HealthChecker) probe(ctx context.Context) map[string]healthResult {
+	ctx, cancel := context.WithTimeout(ctx, healthProbeTimeout)
+	defer cancel()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	results := make(map[string]healthResult, len(h.checks))
+	for _, check := range h.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := check.probe(ctx)
+			r := healthResult{OK: err == nil, CheckedAt: time.Now()}
+			if err != nil {
+				r.Error = err.Error()
+				slog.Warn("provider health check failed", "provider", check.name, "error", err)
+			}
+			mu.Lock()
+			results[check.name] = r
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+// Healthz reports liveness: the process is up and serving. The last
+// provider results are included for information, but it never probes or
+// fails, so a provider outage doesn't get healthy pods restarted.
+func (h *HealthChecker) Healthz(w http.ResponseWriter, _ *http.Request) {
+	results, _ := h.Cached()
+	writeHealth(w, http.StatusOK, "ok", results)
+}
+
+// Readyz reports readiness: every provider is reachable with the
+// configured credentials and the server isn't draining. Pods failing it
+// stop receiving calls.
+func (h *HealthChecker) Readyz(w http.ResponseWriter, r *http.Request) {
+	if h.draining.Load() {
+		results, _ := h.Cached()
+		writeHealth(w, http.StatusServiceUnavailable, "draining", results)
+		return
+	}
+	results, ok := h.Results(r.Context())
+	if !ok {
+		writeHealth(w, http.StatusServiceUnavailable, "unavailable", results)
+		return
+	}
+	writeHealth(w, http.StatusOK, "ok", results)
+}
+
+func writeHealth(w http.ResponseWriter, code int, status string, results map[string]healthResult) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	err := json.NewEncoder(w).Encode(struct {
+		Status    string                  `json:"status"`
+		Providers map[string]healthResult `json:"providers"`
+	}{status, results})
+	if err != nil {
+		slog.Error("failed to write health status", "error", err)
+	}
+}
+
+// probeHTTP sends an authenticated GET and fails on any non-2xx status,
+// so an expired or revoked key shows up as well as an unreachable API.
+func probeHTTP(ctx context.Context, client *http.Client, url string, authorize func(*http.Request)) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	authorize(req)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("%s returned %s", url, resp.Status)
+	}
+	return nil
+}
+
+// deepgramProbe checks the Deepgram key against the preferred region.
+func deepgramProbe(apiKey string, pool *RegionPool, client *http.Client) func(context.Context) error {
+	return func(ctx context.Context) error {
+		base := firstNonEmpty(pool.Candidates()[0].URL, "https://api.deepgram.com")
+		return probeHTTP(ctx, client, base+"/v1/projects", func(req *http.Request) {
+			req.Header.Set("Authorization", "Token "+apiKey)
+		})
+	}
+}
+
+// elevenLabsProbe checks the ElevenLabs key against the preferred region.
+func elevenLabsProbe(apiKey string, pool *RegionPool, client *http.Client) func(context.Context) error {
+	return func(ctx context.Context) error {
+		base := firstNonEmpty(pool.Candidates()[0].URL, "https://api.elevenlabs.io")
+		return probeHTTP(ctx, client, base+"/v1/user", func(req *http.Request) {
+			req.Header.Set("Xi-Api-Key", apiKey)
+		})
+	}
+}
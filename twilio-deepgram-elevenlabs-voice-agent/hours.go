@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/config"
+	"github.com/agentplexus/omnivoice-examples/kit/dnc"
+	"github.com/agentplexus/omnivoice-examples/kit/mail"
+	"github.com/agentplexus/omnivoice-examples/kit/storage"
+	"github.com/agentplexus/omnivoice-examples/kit/twiml"
+	"github.com/agentplexus/omnivoice/stt"
+)
+
+const (
+	// afterHoursQueue is how many messages may wait to be stored,
+	// transcribed and sent on; more are logged and dropped.
+	afterHoursQueue = 64
+	// afterHoursTimeout bounds handling one message.
+	afterHoursTimeout = 2 * time.Minute
+)
+
+// BusinessHours routes calls by when they arrive: within the opening
+// hours to the agent, outside them to voicemail. Messages are stored,
+// transcribed, and sent on as a voicemail_received event and by email.
+type BusinessHours struct {
+	// Open are the opening hours; a call is answered by the agent if any
+	// contains the time it arrives.
+	Open []dnc.Window
+	// Message is said to callers after hours before the beep, and Closing
+	// once they have left a message, or if they leave none.
+	Message string
+	Closing string
+	// MaxLength is the longest message taken.
+	MaxLength time.Duration
+}
+
+// defaultBusinessHours returns the after-hours messages used unless
+// overridden, with no opening hours.
+func defaultBusinessHours() *BusinessHours {
+	return &BusinessHours{
+		Message:   "Thanks for calling. We're closed right now. Please leave a message after the beep and we'll get back to you.",
+		Closing:   "Thank you. Goodbye.",
+		MaxLength: 2 * time.Minute,
+	}
+}
+
+// businessHoursFromEnv reads BUSINESS_HOURS, e.g. "Mon-Fri 09:00-17:30;
+// Sat 10:00-14:00", in BUSINESS_TIMEZONE (default UTC), with
+// AFTER_HOURS_MESSAGE, AFTER_HOURS_CLOSING and AFTER_HOURS_MAX_LENGTH. It
+// returns nil, answering every call, if BUSINESS_HOURS isn't set.
+func businessHoursFromEnv() (*BusinessHours, error) {
+	spec := os.Getenv("BUSINESS_HOURS")
+	if spec == "" {
+		return nil, nil
+	}
+	loc, err := time.LoadLocation(firstNonEmpty(os.Getenv("BUSINESS_TIMEZONE"), "UTC"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid BUSINESS_TIMEZONE: %w", err)
+	}
+	open, err := parseBusinessHours(spec, loc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid BUSINESS_HOURS: %w", err)
+	}
+	h := defaultBusinessHours()
+	h.Open = open
+	if v, ok := os.LookupEnv("AFTER_HOURS_MESSAGE"); ok {
+		h.Message = v
+	}
+	if v, ok := os.LookupEnv("AFTER_HOURS_CLOSING"); ok {
+		h.Closing = v
+	}
+	if v := os.Getenv("AFTER_HOURS_MAX_LENGTH"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < time.Second {
+			return nil, fmt.Errorf("invalid AFTER_HOURS_MAX_LENGTH: %q", v)
+		}
+		h.MaxLength = d
+	}
+	return h, nil
+}
+
+// parseBusinessHours reads opening hours as days and times separated by
+// semicolons, e.g. "Mon-Fri 09:00-17:30; Sat 10:00-14:00". Days are a
+// weekday or a range of them; times run to "24:00" for midnight.
+func parseBusinessHours(spec string, loc *time.Location) ([]dnc.Window, error) {
+	var open []dnc.Window
+	for _, part := range strings.Split(spec, ";") {
+		days, hours, ok := strings.Cut(strings.TrimSpace(part), " ")
+		if !ok {
+			return nil, fmt.Errorf("%q: want days and hours, e.g. \"Mon-Fri 09:00-17:00\"", part)
+		}
+		w := dnc.Window{Locations: []*time.Location{loc}}
+		first, last, _ := strings.Cut(days, "-")
+		from, err := parseWeekday(first)
+		if err != nil {
+			return nil, err
+		}
+		to := from
+		if last != "" {
+			if to, err = parseWeekday(last); err != nil {
+				return nil, err
+			}
+		}
+		for d := from; ; d = (d + 1) % 7 {
+			w.Days = append(w.Days, d)
+			if d == to {
+				break
+			}
+		}
+		start, end, ok := strings.Cut(strings.TrimSpace(hours), "-")
+		if !ok {
+			return nil, fmt.Errorf("%q: want hours as start-end, e.g. 09:00-17:00", part)
+		}
+		if w.Start, err = parseTimeOfDay(start); err != nil {
+			return nil, err
+		}
+		if w.End, err = parseTimeOfDay(end); err != nil {
+			return nil, err
+		}
+		if w.End <= w.Start {
+			return nil, fmt.Errorf("%q: hours end before they start", part)
+		}
+		open = append(open, w)
+	}
+	return open, nil
+}
+
+// weekdays are the names parseWeekday accepts, by their first three
+// letters.
+var weekdays = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+
+func parseWeekday(s string) (time.Weekday, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if len(s) >= 3 {
+		if i := slices.Index(weekdays, s[:3]); i >= 0 && strings.HasPrefix(strings.ToLower(time.Weekday(i).String()), s) {
+			return time.Weekday(i), nil
+		}
+	}
+	return 0, fmt.Errorf("unknown weekday %q", s)
+}
+
+// parseTimeOfDay reads "HH:MM" as the time since midnight.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	hh, mm, ok := strings.Cut(strings.TrimSpace(s), ":")
+	h, err1 := strconv.Atoi(hh)
+	m, err2 := strconv.Atoi(mm)
+	if !ok || err1 != nil || err2 != nil || h < 0 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, fmt.Errorf("invalid time of day %q (want HH:MM)", s)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// OpenAt reports whether t is within the opening hours. A nil
+// BusinessHours is always open.
+func (h *BusinessHours) OpenAt(t time.Time) bool {
+	if h == nil {
+		return true
+	}
+	return slices.ContainsFunc(h.Open, func(w dnc.Window) bool { return w.Contains(t) })
+}
+
+// afterHoursTwiML takes a message, posting it to actionURL, then hangs up.
+// Messages are read as c says.
+func (h *BusinessHours) afterHoursTwiML(c TwiMLConfig, actionURL string) string {
+	doc := twiml.Response{
+		c.say(h.Message),
+		twiml.Record{MaxLength: int(h.MaxLength / time.Second), PlayBeep: true, Action: actionURL},
+	}
+	if h.Closing != "" {
+		// Reached only if the caller left no message
+		doc = append(doc, c.say(h.Closing))
+	}
+	return append(doc, twiml.Hangup{}).String()
+}
+
+// VoicemailReceived is published once a message left after hours has been
+// stored and transcribed.
+type VoicemailReceived struct {
+	Event
+	From            string `json:"from,omitempty"`
+	To              string `json:"to,omitempty"`
+	RecordingSID    string `json:"recording_sid"`
+	DurationSeconds int    `json:"duration_seconds"`
+	// Key is where the recording is stored, if storage is configured.
+	Key        string `json:"key,omitempty"`
+	Transcript string `json:"transcript"`
+}
+
+// afterHoursMessage is a message left after hours, as Twilio reported it.
+type afterHoursMessage struct {
+	callSID, from, to string
+	recordingSID      string
+	duration          int
+}
+
+// afterHoursVoicemail stores, transcribes and sends on the messages left
+// after hours, from a queue of its own so Twilio's webhook isn't held up.
+type afterHoursVoicemail struct {
+	twilio *twilioClient
+	// store, if set, keeps each recording.
+	store storage.Storage
+	// stt transcribes messages in the language and model of cfg.
+	stt stt.Provider
+	cfg config.Deepgram
+	// email, if set, sends each message to its recipients.
+	email  *CallEmail
+	events *EventBus
+	queue  chan afterHoursMessage
+}
+
+// newAfterHoursVoicemail handles the messages left after hours until ctx
+// is done.
+func newAfterHoursVoicemail(ctx context.Context, twilio *twilioClient, store storage.Storage, provider stt.Provider, cfg config.Deepgram, email *CallEmail, events *EventBus) *afterHoursVoicemail {
+	v := &afterHoursVoicemail{
+		twilio: twilio,
+		store:  store,
+		stt:    provider,
+		cfg:    cfg,
+		email:  email,
+		events: events,
+		queue:  make(chan afterHoursMessage, afterHoursQueue),
+	}
+	go v.run(ctx)
+	return v
+}
+
+func (v *afterHoursVoicemail) run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case m := <-v.queue:
+			mctx, cancel := context.WithTimeout(ctx, afterHoursTimeout)
+			if err := v.handle(mctx, m); err != nil {
+				slog.Error("failed to handle after-hours message", "call_sid", m.callSID, "recording_sid", m.recordingSID, "error", err)
+			}
+			cancel()
+		}
+	}
+}
+
+// enqueue queues a message, dropping it if handling has fallen behind.
+func (v *afterHoursVoicemail) enqueue(m afterHoursMessage) {
+	select {
+	case v.queue <- m:
+	default:
+		slog.Error("after-hours voicemail is behind, message not handled", "call_sid", m.callSID, "recording_sid", m.recordingSID)
+	}
+}
+
+// handle fetches a message from Twilio, stores and transcribes it, and
+// sends it on. A message that can't be transcribed is still sent on, with
+// no transcript.
+func (v *afterHoursVoicemail) handle(ctx context.Context, m afterHoursMessage) error {
+	audio, err := v.twilio.RecordingAudio(ctx, m.recordingSID, recordingWAV)
+	if err != nil {
+		return fmt.Errorf("fetching recording: %w", err)
+	}
+	e := VoicemailReceived{
+		Event:           Event{SessionID: m.callSID, CallSID: m.callSID, At: time.Now()},
+		From:            m.from,
+		To:              m.to,
+		RecordingSID:    m.recordingSID,
+		DurationSeconds: m.duration,
+	}
+	if v.store != nil {
+		key := m.callSID + "/voicemail-" + m.recordingSID + "." + recordingWAV
+		if err := v.store.Put(ctx, key, audio, recordingContentType(recordingWAV)); err != nil {
+			slog.Error("failed to store after-hours message", "key", key, "error", err)
+		} else {
+			e.Key = key
+		}
+	}
+	result, err := v.stt.Transcribe(ctx, audio, stt.TranscriptionConfig{
+		Language:          v.cfg.Language,
+		Model:             v.cfg.Model,
+		Encoding:          recordingWAV,
+		EnablePunctuation: true,
+	})
+	if err != nil {
+		slog.Error("failed to transcribe after-hours message", "recording_sid", m.recordingSID, "error", err)
+	} else {
+		e.Transcript = strings.TrimSpace(result.Text)
+	}
+	slog.Info("after-hours message received", "call_sid", m.callSID, "from", m.from, "duration", m.duration, "key", e.Key)
+	v.events.Publish(e)
+	return v.send(ctx, e)
+}
+
+// send emails a message to the team inbox, if there is one.
+func (v *afterHoursVoicemail) send(ctx context.Context, e VoicemailReceived) error {
+	if v.email == nil || len(v.email.To) == 0 {
+		return nil
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\nTo: %s\nReceived: %s\nLength: %ds\n", firstNonEmpty(e.From, "unknown"), e.To, e.At.UTC().Format(time.RFC1123), e.DurationSeconds)
+	if e.Key != "" {
+		fmt.Fprintf(&b, "Recording: %s\n", e.Key)
+	}
+	b.WriteString("\n")
+	b.WriteString(firstNonEmpty(e.Transcript, "(The message couldn't be transcribed.)"))
+	b.WriteString("\n")
+	return v.email.Sender.Send(ctx, mail.Message{
+		From:    v.email.From,
+		To:      v.email.To,
+		Subject: "Voicemail from " + firstNonEmpty(e.From, "an unknown caller"),
+		Text:    b.String(),
+	})
+}
+
+// handleAfterHours receives a message recorded by afterHoursTwiML's
+// <Record>, queues it to be stored, transcribed and sent on, and thanks
+// the caller.
+func (s *Server) handleAfterHours(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	duration, _ := strconv.Atoi(r.Form.Get("RecordingDuration"))
+	if sid := r.Form.Get("RecordingSid"); sid != "" {
+		s.afterHours.enqueue(afterHoursMessage{
+			callSID:      r.Form.Get("CallSid"),
+			from:         s.dialPlan.Normalize(r.Form.Get("From")),
+			to:           s.dialPlan.Normalize(r.Form.Get("To")),
+			recordingSID: sid,
+			duration:     duration,
+		})
+	}
+	writeTwiML(w, hangupTwiML(s.callTwiML.say(s.businessHours.Closing)))
+}
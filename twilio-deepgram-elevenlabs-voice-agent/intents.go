@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/agent"
+	"github.com/agentplexus/omnivoice-examples/kit/config"
+	"github.com/agentplexus/omnivoice-examples/kit/intent"
+)
+
+// Intents answers common requests, such as the opening hours or asking for
+// a person, with a canned reply before the agent is consulted, saving the
+// language model's latency and cost for everything else.
+type Intents struct {
+	Classifier intent.Classifier
+	// Replies are the canned replies, by intent name.
+	Replies map[string]IntentReply
+	// Timeout bounds classifying a turn; a turn not classified in time is
+	// left for the agent.
+	Timeout time.Duration
+}
+
+// IntentReply is how an intent is answered: what is said, then what is
+// done, or both.
+type IntentReply struct {
+	Answer string
+	// Action is "transfer", to hand the caller to TRANSFER_NUMBER, or
+	// "hangup".
+	Action agent.ActionKind
+}
+
+// intentEntry is an intent in INTENTS_FILE.
+type intentEntry struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Keywords    []string `json:"keywords"`
+	Answer      string   `json:"answer"`
+	Action      string   `json:"action"`
+}
+
+// intentsFromEnv builds the intents in the JSON file at INTENTS_FILE, a
+// list of entries like
+//
+//	{"name": "hours", "description": "asks when we are open",
+//	 "keywords": ["opening hours", "when are you open"],
+//	 "answer": "We're open nine to five, Monday to Friday."}
+//
+// recognized by their keywords and, with INTENT_PROVIDER set, by that
+// provider's INTENT_MODEL going by their descriptions when no keyword
+// matches. INTENT_TIMEOUT (default 800ms) bounds classifying a turn. It
+// returns nil if INTENTS_FILE isn't set.
+func intentsFromEnv(guard LLMGuard) (*Intents, error) {
+	path := os.Getenv("INTENTS_FILE")
+	if path == "" {
+		return nil, nil
+	}
+	entries, err := loadIntents(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid INTENTS_FILE: %w", err)
+	}
+	i := &Intents{Replies: make(map[string]IntentReply, len(entries)), Timeout: 800 * time.Millisecond}
+	intents := make([]intent.Intent, len(entries))
+	for n, e := range entries {
+		intents[n] = intent.Intent{Name: e.Name, Description: e.Description, Keywords: e.Keywords}
+		i.Replies[e.Name] = IntentReply{Answer: strings.TrimSpace(e.Answer), Action: agent.ActionKind(e.Action)}
+	}
+	classifiers := intent.Classifiers{intent.NewRules(intents)}
+	if provider := os.Getenv("INTENT_PROVIDER"); provider != "" {
+		model := os.Getenv("INTENT_MODEL")
+		p, err := guard.provider(config.LLM{Provider: provider, Model: model})
+		if err != nil {
+			return nil, fmt.Errorf("invalid INTENT_PROVIDER: %w", err)
+		}
+		// Its tokens are charged to the call like the agent's
+		p = &meteredLLM{Provider: p, key: usageKey(provider, model)}
+		classifiers = append(classifiers, intent.NewModel(p, intents))
+	}
+	i.Classifier = classifiers
+	if v := os.Getenv("INTENT_TIMEOUT"); v != "" {
+		if i.Timeout, err = time.ParseDuration(v); err != nil || i.Timeout <= 0 {
+			return nil, fmt.Errorf("invalid INTENT_TIMEOUT: %q", v)
+		}
+	}
+	return i, nil
+}
+
+// loadIntents reads intents from a JSON file.
+func loadIntents(path string) ([]intentEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []intentEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	seen := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		switch {
+		case e.Name == "" || seen[e.Name]:
+			return nil, fmt.Errorf("%s: intent %q needs a unique name", path, e.Name)
+		case len(e.Keywords) == 0 && e.Description == "":
+			return nil, fmt.Errorf("%s: intent %q needs keywords or a description", path, e.Name)
+		case strings.TrimSpace(e.Answer) == "" && e.Action == "":
+			return nil, fmt.Errorf("%s: intent %q needs an answer or an action", path, e.Name)
+		case e.Action != "" && e.Action != string(agent.ActionTransfer) && e.Action != string(agent.ActionHangup):
+			return nil, fmt.Errorf("%s: intent %q has unknown action %q (want transfer or hangup)", path, e.Name, e.Action)
+		}
+		seen[e.Name] = true
+	}
+	return entries, nil
+}
+
+// Match returns the intent of a caller's turn and its reply, if it has
+// one. A classifier that fails or runs out of time leaves the turn for the
+// agent.
+func (i *Intents) Match(ctx context.Context, text string, logger *slog.Logger) (string, IntentReply, bool) {
+	if i == nil {
+		return "", IntentReply{}, false
+	}
+	ctx, cancel := context.WithTimeout(ctx, i.Timeout)
+	defer cancel()
+	name, err := i.Classifier.Classify(ctx, text)
+	if err != nil {
+		logger.Warn("intent classification failed", "error", err)
+		return "", IntentReply{}, false
+	}
+	reply, ok := i.Replies[name]
+	return name, reply, ok
+}
+
+// answeredIntent records a turn answered with an intent's canned reply in
+// the CDR.
+func (s *CallSession) answeredIntent(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cdr.Intents = append(s.cdr.Intents, name)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/agentplexus/omnivoice-examples/kit/moderation"
+)
+
+// Built-in interceptors, as named in INTERCEPTORS.
+const (
+	interceptModeration = "moderation"
+	interceptRedact     = "redact"
+	interceptLog        = "log"
+)
+
+// Utterance is something said on a call on its way through the
+// interceptors: a caller's final transcript before it is recorded and
+// answered, or a piece of the agent's reply before it is spoken.
+type Utterance struct {
+	SessionID string
+	Turn      int
+	// Speaker is speakerCaller or speakerAgent.
+	Speaker string
+	Text    string
+	// Blocked stops the utterance: a caller's turn is recorded as Text but
+	// not answered, and an agent's reply isn't spoken, nor is the rest of
+	// its turn. Instead, if set, is said in its place.
+	Blocked bool
+	Instead string
+}
+
+// Interceptor sees each utterance and returns it as it should go on:
+// unchanged, with its text rewritten, e.g. redacted, filtered or
+// translated, or blocked. An interceptor that fails should pass the
+// utterance on as it was rather than silence the call.
+type Interceptor interface {
+	Intercept(ctx context.Context, u Utterance) Utterance
+}
+
+// InterceptorFunc is a function used as an Interceptor.
+type InterceptorFunc func(ctx context.Context, u Utterance) Utterance
+
+// Intercept calls f.
+func (f InterceptorFunc) Intercept(ctx context.Context, u Utterance) Utterance {
+	return f(ctx, u)
+}
+
+// Interceptors is a chain of interceptors, run in order. An utterance that
+// is blocked or left empty goes no further.
+type Interceptors []Interceptor
+
+// Intercept passes u through the chain.
+func (c Interceptors) Intercept(ctx context.Context, u Utterance) Utterance {
+	for _, i := range c {
+		if u.Blocked || u.Text == "" {
+			break
+		}
+		u = i.Intercept(ctx, u)
+	}
+	return u
+}
+
+// newInterceptor builds an interceptor for one call, or returns nil to
+// leave it out of the call's chain.
+type newInterceptor func(call *CallSession, logger *slog.Logger) Interceptor
+
+// interceptorsFromEnv reads INTERCEPTORS, the comma-separated built-in
+// interceptors to run, in order (default moderation).
+func interceptorsFromEnv() ([]string, error) {
+	v := os.Getenv("INTERCEPTORS")
+	if v == "" {
+		return []string{interceptModeration}, nil
+	}
+	known := []string{interceptModeration, interceptRedact, interceptLog}
+	var names []string
+	for _, name := range strings.Split(v, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if !slices.Contains(known, name) {
+			return nil, fmt.Errorf("invalid INTERCEPTORS: unknown interceptor %q (want %s)", name, strings.Join(known, ", "))
+		}
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// builtinInterceptors returns the named interceptors' constructors.
+func (s *Server) builtinInterceptors(names []string) []newInterceptor {
+	interceptors := make([]newInterceptor, 0, len(names))
+	for _, name := range names {
+		switch name {
+		case interceptModeration:
+			interceptors = append(interceptors, s.moderationInterceptor)
+		case interceptRedact:
+			interceptors = append(interceptors, func(*CallSession, *slog.Logger) Interceptor { return InterceptorFunc(redactPII) })
+		case interceptLog:
+			interceptors = append(interceptors, logInterceptor)
+		}
+	}
+	return interceptors
+}
+
+// interceptorChain builds a call's chain of interceptors.
+func (s *Server) interceptorChain(call *CallSession, logger *slog.Logger) Interceptors {
+	var chain Interceptors
+	for _, build := range s.interceptors {
+		if i := build(call, logger); i != nil {
+			chain = append(chain, i)
+		}
+	}
+	return chain
+}
+
+// moderationInterceptor applies the server's moderation to a call, or
+// returns nil when moderation is off. Flagged utterances are recorded in
+// the call's CDR.
+func (s *Server) moderationInterceptor(call *CallSession, logger *slog.Logger) Interceptor {
+	if s.moderator == nil {
+		return nil
+	}
+	return InterceptorFunc(func(ctx context.Context, u Utterance) Utterance {
+		dir, instead, flagged := moderation.Caller, s.moderation.CallerLine, "caller flagged by moderation"
+		if u.Speaker == speakerAgent {
+			dir, instead, flagged = moderation.Agent, s.moderation.AgentLine, "agent reply flagged by moderation"
+		}
+		outcome, err := s.moderator.Moderate(ctx, dir, u.SessionID, u.Text)
+		if err != nil {
+			logger.Warn("moderation check failed", "direction", dir, "error", err)
+		}
+		if !outcome.Result.Flagged {
+			return u
+		}
+		logger.Warn(flagged, "turn", u.Turn, "categories", outcome.Result.Categories, "decision", outcome.Decision)
+		call.moderated(u.Turn, dir, outcome)
+		u.Text = outcome.Text
+		if outcome.Decision == moderation.Block {
+			u.Blocked, u.Instead = true, instead
+		}
+		return u
+	})
+}
+
+// Personal data masked by the redact interceptor, most specific first.
+var piiPatterns = []struct {
+	re   *regexp.Regexp
+	mask string
+}{
+	{regexp.MustCompile(`[\w.+-]+@[\w-]+(\.[\w-]+)+`), "[email]"},
+	{regexp.MustCompile(`\b\d{3}-\d{2}-\d{4}\b`), "[ssn]"},
+	{regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`), "[card number]"},
+	{regexp.MustCompile(`(?:\+\d{1,3}[ .-]?)?\(?\b\d{3}\)?[ .-]?\d{3}[ .-]?\d{4}\b`), "[phone number]"},
+}
+
+// redactPII masks email addresses, social security, card and phone
+// numbers, so they are neither recorded nor seen by the agent.
+func redactPII(_ context.Context, u Utterance) Utterance {
+	for _, p := range piiPatterns {
+		u.Text = p.re.ReplaceAllString(u.Text, p.mask)
+	}
+	return u
+}
+
+// logInterceptor logs each utterance as it reaches it, at debug level.
+func logInterceptor(_ *CallSession, logger *slog.Logger) Interceptor {
+	return InterceptorFunc(func(_ context.Context, u Utterance) Utterance {
+		logger.Debug("utterance", "speaker", u.Speaker, "turn", u.Turn, "text", u.Text)
+		return u
+	})
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/speech"
+)
+
+// itnFromEnv reports whether caller transcripts are rewritten with their
+// numbers, dates, amounts and email addresses written out before the
+// agent sees them (inverse text normalization). STT_ITN=false turns it
+// off; it is on by default.
+func itnFromEnv() (bool, error) {
+	v := os.Getenv("STT_ITN")
+	if v == "" {
+		return true, nil
+	}
+	enabled, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid STT_ITN: %q", v)
+	}
+	return enabled, nil
+}
+
+// denormalize rewrites a caller's transcript for the agent, "five five
+// five one two one two" as "555-1212" and "march third" as a date in the
+// year of now. It returns the transcript as recognized too when the two
+// differ, for agents that read letters or keywords from the words said.
+func denormalize(text string, now time.Time) (written, spoken string) {
+	written = speech.Denormalize(text, now)
+	if written == text {
+		return text, ""
+	}
+	return written, text
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/agentplexus/omnivoice/transport"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// latencyWindow is how many recent turns each stage's percentiles cover.
+const latencyWindow = 1000
+
+// Latency stages, in pipeline order. Each is measured from the end of the
+// previous one; "total" runs from the caller finishing speaking to the
+// first frame of the reply going out.
+const (
+	stageSTT       = "stt"       // speech end → final transcript
+	stageAgent     = "agent"     // final transcript → agent first token
+	stageTTS       = "tts"       // agent first token → TTS first byte
+	stageTransport = "transport" // TTS first byte → first outbound frame
+	stageTotal     = "total"
+)
+
+var latencyStages = []string{stageSTT, stageAgent, stageTTS, stageTransport, stageTotal}
+
+// turnTimestamps records when each stage of a turn completed.
+type turnTimestamps struct {
+	speechStart time.Time
+	speechEnd   time.Time
+	transcript  time.Time
+	agent       time.Time
+	ttsByte     time.Time
+
+	// ctx carries the turn's span to the agent and provider calls it makes.
+	ctx  context.Context
+	span trace.Span
+}
+
+// LatencyTracker times one session's turns from the caller finishing
+// speaking to the first audio of the reply, logging a per-stage breakdown
+// for each turn and feeding the server-wide LatencyStats. Each turn is also
+// traced: a voice.turn span with a child span per stage.
+type LatencyTracker struct {
+	ctx    context.Context // session context, parent of turn spans
+	logger *slog.Logger
+	stats  *LatencyStats
+
+	// slo, degradation and variant, if set, also receive each completed
+	// turn; variant aggregates the call's experiment variant.
+	slo         *SLOMonitor
+	degradation *DegradationLadder
+	variant     *LatencyStats
+
+	mu    sync.Mutex
+	turn  *turnTimestamps // nil when no reply is pending
+	turns int
+}
+
+// newLatencyTracker creates a tracker reporting into stats. Turn spans are
+// children of the span in ctx.
+func newLatencyTracker(ctx context.Context, logger *slog.Logger, stats *LatencyStats) *LatencyTracker {
+	return &LatencyTracker{ctx: ctx, logger: logger, stats: stats}
+}
+
+// MarkSpeechStart records the caller starting to speak. A reply still
+// pending from the previous turn is abandoned (barge-in); a pause within
+// an utterance continues the current turn.
+func (t *LatencyTracker) MarkSpeechStart() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.turn != nil && t.turn.transcript.IsZero() {
+		return
+	}
+	t.startTurn(time.Now()).speechStart = time.Now()
+}
+
+// MarkSpeechEnd records the caller stopping speaking. Deepgram signals it
+// (UtteranceEnd) after the final transcript, once the turn is timed from
+// its transcript, so it only counts when it comes first. It never starts a
+// turn: one arriving after a reply went out belongs to the turn answered.
+func (t *LatencyTracker) MarkSpeechEnd() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.turn == nil || !t.turn.transcript.IsZero() {
+		return
+	}
+	t.turn.speechEnd = time.Now()
+}
+
+// MarkTranscript records the final transcript. If no speech end was seen
+// (some endpointing finalizes first), the turn starts here.
+func (t *LatencyTracker) MarkTranscript() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.turn == nil || !t.turn.transcript.IsZero() {
+		t.startTurn(time.Now())
+	}
+	t.turn.transcript = time.Now()
+}
+
+// TurnContext returns a context carrying the current turn's span, for the
+// agent and provider calls made to answer it. It falls back to the session
+// context between turns.
+func (t *LatencyTracker) TurnContext() context.Context {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.turn == nil {
+		return t.ctx
+	}
+	return t.turn.ctx
+}
+
+// startTurn abandons any pending turn and starts a new one. t.mu must be held.
+func (t *LatencyTracker) startTurn(now time.Time) *turnTimestamps {
+	if t.turn != nil {
+		t.turn.span.SetAttributes(attribute.Bool("voice.turn.abandoned", true))
+		t.turn.span.End(trace.WithTimestamp(now))
+	}
+	t.turns++
+	ctx, span := tracer.Start(t.ctx, "voice.turn",
+		trace.WithTimestamp(now),
+		trace.WithAttributes(attribute.Int("voice.turn.number", t.turns)),
+	)
+	t.turn = &turnTimestamps{ctx: ctx, span: span}
+	return t.turn
+}
+
+// MarkAgentFirstToken records when the agent produced the start of its
+// reply; for a streaming LLM, call it on the first token.
+func (t *LatencyTracker) MarkAgentFirstToken() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.turn != nil && t.turn.agent.IsZero() {
+		t.turn.agent = time.Now()
+	}
+}
+
+// End abandons any pending turn when the session ends.
+func (t *LatencyTracker) End() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.turn != nil {
+		t.turn.span.SetAttributes(attribute.Bool("voice.turn.abandoned", true))
+		t.turn.span.End()
+		t.turn = nil
+	}
+}
+
+// TapTTS wraps the connection TTS writes to, recording its first byte.
+func (t *LatencyTracker) TapTTS(conn transport.Connection) transport.Connection {
+	return newWriteObserver(conn, t.markTTSFirstByte)
+}
+
+// TapWire wraps the connection below the pacer, recording the first frame
+// actually sent and completing the turn.
+func (t *LatencyTracker) TapWire(conn transport.Connection) transport.Connection {
+	return newWriteObserver(conn, t.markFirstFrame)
+}
+
+func (t *LatencyTracker) markTTSFirstByte() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.turn != nil && !t.turn.transcript.IsZero() && t.turn.ttsByte.IsZero() {
+		t.turn.ttsByte = time.Now()
+	}
+}
+
+func (t *LatencyTracker) markFirstFrame() {
+	t.mu.Lock()
+	turn := t.turn
+	if turn == nil || turn.ttsByte.IsZero() {
+		t.mu.Unlock()
+		return
+	}
+	t.turn = nil
+	t.mu.Unlock()
+
+	now := time.Now()
+	start := turn.speechEnd
+	if start.IsZero() {
+		start = turn.transcript
+	}
+	stages := map[string]time.Duration{
+		stageTransport: now.Sub(turn.ttsByte),
+		stageTotal:     now.Sub(start),
+	}
+	if !turn.speechEnd.IsZero() {
+		stages[stageSTT] = turn.transcript.Sub(turn.speechEnd)
+	}
+	if !turn.agent.IsZero() {
+		stages[stageAgent] = turn.agent.Sub(turn.transcript)
+		stages[stageTTS] = turn.ttsByte.Sub(turn.agent)
+	}
+
+	var attrs []any
+	for _, stage := range latencyStages {
+		if d, ok := stages[stage]; ok {
+			attrs = append(attrs, stage, d.Round(time.Millisecond))
+		}
+	}
+	t.logger.Info("turn latency", attrs...)
+	t.stats.record(stages)
+	t.slo.RecordTurn(stages)
+	t.degradation.RecordTurn(stages)
+	if t.variant != nil {
+		t.variant.record(stages)
+	}
+	traceTurn(turn, now)
+}
+
+// traceTurn records a completed turn's stages as child spans of its turn
+// span, using the timestamps already collected.
+func traceTurn(turn *turnTimestamps, firstFrame time.Time) {
+	stage := func(name string, start, end time.Time) {
+		if start.IsZero() || end.IsZero() {
+			return
+		}
+		_, span := tracer.Start(turn.ctx, name, trace.WithTimestamp(start))
+		span.End(trace.WithTimestamp(end))
+	}
+	stage("voice.transport.receive", turn.speechStart, turn.speechEnd)
+	stage("voice.stt", turn.speechEnd, turn.transcript)
+	stage("voice.agent", turn.transcript, turn.agent)
+	ttsStart := turn.agent
+	if ttsStart.IsZero() {
+		ttsStart = turn.transcript
+	}
+	stage("voice.tts", ttsStart, turn.ttsByte)
+	stage("voice.transport.send", turn.ttsByte, firstFrame)
+	turn.span.End(trace.WithTimestamp(firstFrame))
+}
+
+// LatencyStats aggregates turn latencies across sessions.
+type LatencyStats struct {
+	mu      sync.Mutex
+	samples map[string][]time.Duration // ring of recent samples per stage
+	next    map[string]int
+	turns   int
+}
+
+// NewLatencyStats creates an empty aggregate.
+func NewLatencyStats() *LatencyStats {
+	return &LatencyStats{
+		samples: make(map[string][]time.Duration),
+		next:    make(map[string]int),
+	}
+}
+
+func (s *LatencyStats) record(stages map[string]time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.turns++
+	for stage, d := range stages {
+		if len(s.samples[stage]) < latencyWindow {
+			s.samples[stage] = append(s.samples[stage], d)
+			continue
+		}
+		s.samples[stage][s.next[stage]] = d
+		s.next[stage] = (s.next[stage] + 1) % latencyWindow
+	}
+}
+
+// StagePercentiles summarizes one stage in milliseconds.
+type StagePercentiles struct {
+	Count int     `json:"count"`
+	P50   float64 `json:"p50_ms"`
+	P90   float64 `json:"p90_ms"`
+	P99   float64 `json:"p99_ms"`
+}
+
+// Percentiles returns p50/p90/p99 for each stage over recent turns.
+func (s *LatencyStats) Percentiles() map[string]StagePercentiles {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make(map[string]StagePercentiles, len(s.samples))
+	for stage, samples := range s.samples {
+		sorted := slices.Clone(samples)
+		slices.Sort(sorted)
+		out[stage] = StagePercentiles{
+			Count: len(sorted),
+			P50:   percentileMillis(sorted, 0.50),
+			P90:   percentileMillis(sorted, 0.90),
+			P99:   percentileMillis(sorted, 0.99),
+		}
+	}
+	return out
+}
+
+// ServeHTTP reports the percentiles as JSON.
+func (s *LatencyStats) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	turns := s.turns
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]any{
+		"turns":  turns,
+		"window": latencyWindow,
+		"stages": s.Percentiles(),
+	}); err != nil {
+		slog.Error("failed to write latency stats", "error", err)
+	}
+}
+
+// percentileMillis returns the nearest-rank percentile of sorted samples.
+func percentileMillis(sorted []time.Duration, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := min(int(p*float64(len(sorted))), len(sorted)-1)
+	return float64(sorted[i]) / float64(time.Millisecond)
+}
+
+// writeObserver calls a function on every write to a connection's
+// outbound audio.
+type writeObserver struct {
+	transport.Connection
+	writer *observedWriter
+}
+
+func newWriteObserver(conn transport.Connection, onWrite func()) *writeObserver {
+	return &writeObserver{
+		Connection: conn,
+		writer:     &observedWriter{dst: conn.AudioIn(), onWrite: onWrite},
+	}
+}
+
+// AudioIn returns the observed writer.
+func (c *writeObserver) AudioIn() io.WriteCloser {
+	return c.writer
+}
+
+type observedWriter struct {
+	dst     io.WriteCloser
+	onWrite func()
+}
+
+func (w *observedWriter) Write(b []byte) (int, error) {
+	w.onWrite()
+	return w.dst.Write(b)
+}
+
+func (w *observedWriter) Close() error {
+	return w.dst.Close()
+}
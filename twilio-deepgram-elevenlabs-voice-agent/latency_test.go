@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+)
+
+// TestLatencySpeechEndAfterTranscript follows Deepgram's order of events,
+// which signals the end of speech after the final transcript.
+func TestLatencySpeechEndAfterTranscript(t *testing.T) {
+	stats := NewLatencyStats()
+	tracker := newLatencyTracker(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil)), stats)
+
+	tracker.MarkSpeechStart()
+	tracker.MarkTranscript()
+	tracker.MarkSpeechEnd()
+	tracker.MarkAgentFirstToken()
+	tracker.markTTSFirstByte()
+	tracker.markFirstFrame()
+
+	stages := stats.Percentiles()
+	if got := stages[stageTotal].Count; got != 1 {
+		t.Fatalf("total recorded for %d turns, want 1", got)
+	}
+	if got, ok := stages[stageSTT]; ok {
+		t.Errorf("stt stage recorded as %+v without a speech end before the transcript", got)
+	}
+	for stage, p := range stages {
+		if p.P50 < 0 {
+			t.Errorf("%s stage is negative: %v ms", stage, p.P50)
+		}
+	}
+
+	// A late speech end after the reply went out doesn't start a turn
+	tracker.MarkSpeechEnd()
+	tracker.MarkTranscript()
+	tracker.markTTSFirstByte()
+	tracker.markFirstFrame()
+	if got := stats.Percentiles()[stageSTT].Count; got != 0 {
+		t.Errorf("stt stage recorded for %d turns, want 0", got)
+	}
+}
+
+func TestLatencySpeechEndBeforeTranscript(t *testing.T) {
+	stats := NewLatencyStats()
+	tracker := newLatencyTracker(context.Background(), slog.New(slog.NewTextHandler(io.Discard, nil)), stats)
+
+	tracker.MarkSpeechStart()
+	tracker.MarkSpeechEnd()
+	tracker.MarkTranscript()
+	tracker.markTTSFirstByte()
+	tracker.markFirstFrame()
+
+	if got := stats.Percentiles()[stageSTT].Count; got != 1 {
+		t.Errorf("stt stage recorded for %d turns, want 1", got)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/agentplexus/omnivoice/transport"
+)
+
+// Leg names one party of a bridged call, for speech meant for only one of
+// them (whisper mode): coaching the human without the caller hearing, or
+// telling the caller something the human doesn't need to hear.
+type Leg string
+
+const (
+	// LegAll is heard by every party, like any ordinary speech.
+	LegAll Leg = ""
+	// LegCaller is the person who called in.
+	LegCaller Leg = "caller"
+	// LegHuman is the human the call was bridged to.
+	LegHuman Leg = "human"
+)
+
+// parseLeg parses a speech target. Empty is LegAll.
+func parseLeg(s string) (Leg, error) {
+	switch leg := Leg(strings.ToLower(strings.TrimSpace(s))); leg {
+	case LegAll, LegCaller, LegHuman:
+		return leg, nil
+	default:
+		return "", fmt.Errorf("unknown target %q (want caller or human)", s)
+	}
+}
+
+// errSingleLeg is returned when speech targets one leg of a call whose
+// transport can't play audio to a single leg.
+var errSingleLeg = errors.New("transport can't play audio to a single leg")
+
+// multiLegConnection is implemented by transports that carry every leg of
+// a bridged call on one connection and can send audio to just one of them.
+// A Twilio Media Stream carries a single leg, so whispering needs a
+// transport that bridges the legs itself.
+type multiLegConnection interface {
+	// Leg returns a connection whose outbound audio only leg hears. It
+	// fails if leg is not part of the call (yet).
+	Leg(leg Leg) (transport.Connection, error)
+}
+
+// legOutputs builds the outbound audio path of each leg of a multi-leg
+// connection on first use. Each leg is paced separately, like a call of its
+// own. Whispers skip the echo guard, latency and listen-in taps, which
+// follow the audio everyone hears.
+type legOutputs struct {
+	conn  multiLegConnection
+	build func(wire transport.Connection) (transport.Connection, *pacedConnection, error)
+
+	mu       sync.Mutex
+	outbound map[Leg]transport.Connection
+	paced    []*pacedConnection
+}
+
+func newLegOutputs(conn multiLegConnection, build func(wire transport.Connection) (transport.Connection, *pacedConnection, error)) *legOutputs {
+	return &legOutputs{conn: conn, build: build, outbound: make(map[Leg]transport.Connection)}
+}
+
+// Connection returns the outbound path to leg.
+func (o *legOutputs) Connection(leg Leg) (transport.Connection, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if conn, ok := o.outbound[leg]; ok {
+		return conn, nil
+	}
+	wire, err := o.conn.Leg(leg)
+	if err != nil {
+		return nil, err
+	}
+	conn, paced, err := o.build(wire)
+	if err != nil {
+		return nil, err
+	}
+	o.outbound[leg] = conn
+	o.paced = append(o.paced, paced)
+	return conn, nil
+}
+
+// Clear drops the audio queued for every leg.
+func (o *legOutputs) Clear() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, p := range o.paced {
+		p.Clear()
+	}
+}
+
+// Stop stops pacing every leg.
+func (o *legOutputs) Stop() {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	for _, p := range o.paced {
+		p.Stop()
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/agentplexus/omnivoice-examples/kit/config"
+	"github.com/agentplexus/omnivoice-examples/kit/speech"
+)
+
+// newLexicon returns the lexicon of pronunciations, or nil if there are
+// none.
+func newLexicon(pronunciations map[string]config.Pronunciation) (*speech.Lexicon, error) {
+	entries := make(map[string]speech.Pronunciation, len(pronunciations))
+	for word, p := range pronunciations {
+		entries[word] = speech.Pronunciation{Respelling: p.Respelling, IPA: p.IPA}
+	}
+	lexicon, err := speech.NewLexicon(entries)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pronunciations: %w", err)
+	}
+	return lexicon, nil
+}
+
+// hasPronunciations reports whether cfg corrects how any name is said, at
+// the top level or for a tenant.
+func hasPronunciations(cfg config.Config) bool {
+	if len(cfg.Pronunciations) > 0 {
+		return true
+	}
+	for _, t := range cfg.Tenants {
+		if len(t.Pronunciations) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+type lexiconContextKey struct{}
+
+// withLexicon returns a context whose speech is said with lexicon rather
+// than the top-level pronunciations, unless lexicon is nil.
+func withLexicon(ctx context.Context, lexicon *speech.Lexicon) context.Context {
+	if lexicon == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, lexiconContextKey{}, lexicon)
+}
+
+// lexiconFrom returns the lexicon ctx's speech is said with, or nil for
+// the top-level pronunciations.
+func lexiconFrom(ctx context.Context) *speech.Lexicon {
+	lexicon, _ := ctx.Value(lexiconContextKey{}).(*speech.Lexicon)
+	return lexicon
+}
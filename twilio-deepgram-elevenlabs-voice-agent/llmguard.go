@@ -0,0 +1,179 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/agent"
+	"github.com/agentplexus/omnivoice-examples/kit/config"
+	"github.com/agentplexus/omnivoice-examples/kit/llm"
+)
+
+// LLM guard modes.
+const (
+	// llmGuardBreaker moves turns to the fallback model while the primary
+	// is slow or failing.
+	llmGuardBreaker = "breaker"
+	// llmGuardHedge races the fallback model against a primary slow to
+	// start answering.
+	llmGuardHedge = "hedge"
+)
+
+// LLMGuard keeps reply times predictable during a language model
+// provider's incidents, with a smaller or faster fallback model behind a
+// circuit breaker or racing the primary. With no fallback configured,
+// models are used as they are.
+//
+// It also carries whether agents built on the models get guardrails
+// against prompt injection, and the tools they are offered.
+type LLMGuard struct {
+	// Fallback is the model to fall back to. An empty provider is the
+	// primary's.
+	Fallback config.LLM
+	Mode     string
+	Breaker  llm.BreakerConfig
+	// HedgeDelay is how long the primary has to start answering before the
+	// fallback is sent the request too.
+	HedgeDelay time.Duration
+	// Guardrails, set from features.guardrails, has callers' turns
+	// checked and fenced before they reach the model.
+	Guardrails bool
+	// Tools are offered to agents built on the models besides the
+	// built-in ones, e.g. send_sms.
+	Tools []agent.Tool
+	// Instructions, if set, follow every agent's system prompt, e.g. how
+	// to tag replies with emotions.
+	Instructions string
+	// Residency is the data residency policy every model's endpoint must
+	// fall within.
+	Residency ResidencyPolicy
+	// Regions are the regional endpoints of the providers that have
+	// them, by provider name, from llmPoolsFromEnv.
+	Regions map[string]*RegionPool
+}
+
+// provider returns a provider for model, configured from its environment
+// variables, failing over between its provider's regions if it has them.
+// Its endpoints must be allowed by the guard's residency policy.
+func (guard LLMGuard) provider(model config.LLM) (llm.Provider, error) {
+	if pool := guard.Regions[model.Provider]; pool != nil {
+		return newRegionalLLM(model.Provider, model.Model, pool)
+	}
+	p, err := llm.FromEnv(model.Provider, model.Model)
+	if err != nil {
+		return nil, err
+	}
+	if err := guard.Residency.ValidateLLM(p); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// system returns prompt followed by the guard's instructions.
+func (guard LLMGuard) system(prompt string) string {
+	if guard.Instructions == "" {
+		return prompt
+	}
+	return prompt + "\n\n" + guard.Instructions
+}
+
+// defaultLLMGuard returns the configuration used unless overridden by
+// LLM_FALLBACK_PROVIDER, LLM_FALLBACK_MODEL, LLM_GUARD, LLM_BREAKER_P95,
+// LLM_BREAKER_WINDOW, LLM_BREAKER_MIN_SAMPLES, LLM_BREAKER_ERRORS,
+// LLM_BREAKER_COOLDOWN and LLM_HEDGE_DELAY.
+func defaultLLMGuard() LLMGuard {
+	return LLMGuard{
+		Mode:       llmGuardBreaker,
+		Breaker:    llm.DefaultBreakerConfig(),
+		HedgeDelay: 800 * time.Millisecond,
+	}
+}
+
+// llmGuardFromEnv applies environment overrides to the defaults.
+func llmGuardFromEnv() (LLMGuard, error) {
+	g := defaultLLMGuard()
+	g.Fallback = config.LLM{Provider: os.Getenv("LLM_FALLBACK_PROVIDER"), Model: os.Getenv("LLM_FALLBACK_MODEL")}
+	if v := os.Getenv("LLM_GUARD"); v != "" {
+		if v != llmGuardBreaker && v != llmGuardHedge {
+			return g, fmt.Errorf("invalid LLM_GUARD: %q (want %s or %s)", v, llmGuardBreaker, llmGuardHedge)
+		}
+		g.Mode = v
+	}
+	for key, d := range map[string]*time.Duration{
+		"LLM_BREAKER_P95":      &g.Breaker.MaxP95,
+		"LLM_BREAKER_COOLDOWN": &g.Breaker.Cooldown,
+		"LLM_HEDGE_DELAY":      &g.HedgeDelay,
+	} {
+		if v := os.Getenv(key); v != "" {
+			parsed, err := time.ParseDuration(v)
+			if err != nil || parsed < 0 {
+				return g, fmt.Errorf("invalid %s: %q", key, v)
+			}
+			*d = parsed
+		}
+	}
+	for key, n := range map[string]*int{
+		"LLM_BREAKER_WINDOW":      &g.Breaker.Window,
+		"LLM_BREAKER_MIN_SAMPLES": &g.Breaker.MinSamples,
+		"LLM_BREAKER_ERRORS":      &g.Breaker.MaxErrors,
+	} {
+		if v := os.Getenv(key); v != "" {
+			parsed, err := strconv.Atoi(v)
+			if err != nil || parsed < 1 {
+				return g, fmt.Errorf("invalid %s: %q", key, v)
+			}
+			*n = parsed
+		}
+	}
+	if g.Breaker.MinSamples > g.Breaker.Window {
+		return g, fmt.Errorf("LLM_BREAKER_MIN_SAMPLES (%d) is more than LLM_BREAKER_WINDOW (%d)", g.Breaker.MinSamples, g.Breaker.Window)
+	}
+	return g, nil
+}
+
+// Enabled reports whether a fallback model is configured.
+func (g LLMGuard) Enabled() bool {
+	return g.Fallback.Provider != "" || g.Fallback.Model != ""
+}
+
+// wrap puts primary, a provider for model, behind the guard. Tokens are
+// charged to the call whose turn made the request, each model's
+// separately, so a hedged turn is charged for both.
+func (g LLMGuard) wrap(primary llm.Provider, model config.LLM) (llm.Provider, error) {
+	primary = &meteredLLM{Provider: primary, key: usageKey(model.Provider, model.Model)}
+	if !g.Enabled() {
+		return primary, nil
+	}
+	fallbackModel := g.Fallback
+	fallbackModel.Provider = firstNonEmpty(fallbackModel.Provider, model.Provider)
+	if fallbackModel == model {
+		return nil, fmt.Errorf("LLM fallback %s %s is the model it falls back from", fallbackModel.Provider, fallbackModel.Model)
+	}
+	fallback, err := g.provider(fallbackModel)
+	if err != nil {
+		return nil, fmt.Errorf("LLM fallback: %w", err)
+	}
+	fallback = &meteredLLM{Provider: fallback, key: usageKey(fallbackModel.Provider, fallbackModel.Model)}
+
+	logger := slog.With("primary", usageKey(model.Provider, model.Model), "fallback", usageKey(fallbackModel.Provider, fallbackModel.Model))
+	if g.Mode == llmGuardHedge {
+		h := llm.NewHedged(primary, fallback, g.HedgeDelay)
+		h.OnHedge = func(backupWon bool) {
+			logger.Debug("LLM request hedged", "fallback_won", backupWon)
+		}
+		return h, nil
+	}
+	b := llm.NewBreaker(primary, fallback, g.Breaker)
+	b.OnStateChange = func(from, to llm.BreakerState, reason string) {
+		switch to {
+		case llm.BreakerOpen:
+			logger.Warn("LLM circuit open, using fallback model", "from", from, "reason", reason, "cooldown", g.Breaker.Cooldown)
+		case llm.BreakerClosed:
+			logger.Info("LLM circuit closed, primary model recovered", "reason", reason)
+		}
+	}
+	return b, nil
+}
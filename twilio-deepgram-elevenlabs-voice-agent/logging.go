@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sessionLogger returns a logger tagged with the session ID, call SID and
+// caller, so one call's records can be picked out of interleaved output.
+// When logDir is set, the session's records (including debug) are also
+// written as JSON lines to <logDir>/<CallSid>.log; the returned function
+// closes that file.
+func (s *Server) sessionLogger(sessionID string, metadata SessionMetadata) (*slog.Logger, func()) {
+	logger := slog.Default()
+	closeLog := func() {}
+	if s.logDir != "" {
+		f, err := openCallLog(s.logDir, firstNonEmpty(metadata.CallSID, sessionID))
+		if err != nil {
+			slog.Error("failed to open call log", "error", err, "session", sessionID)
+		} else {
+			file := slog.NewJSONHandler(f, &slog.HandlerOptions{Level: slog.LevelDebug})
+			logger = slog.New(teeHandler{logger.Handler(), file})
+			closeLog = func() { _ = f.Close() }
+		}
+	}
+	return logger.With("session", sessionID, "call_sid", metadata.CallSID, "caller", metadata.From), closeLog
+}
+
+// openCallLog opens (appending) the log file for a call. Streams of the same
+// call, such as a coaching stream after a transfer, share one file.
+func openCallLog(dir, name string) (*os.File, error) {
+	name = strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' {
+			return r
+		}
+		return -1
+	}, name)
+	if name == "" {
+		return nil, fmt.Errorf("no usable name for call log")
+	}
+	return os.OpenFile(filepath.Join(dir, name+".log"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o640)
+}
+
+// teeHandler sends each record to every handler that accepts its level.
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range t {
+		if h.Enabled(ctx, r.Level) {
+			errs = append(errs, h.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	out := make(teeHandler, len(t))
+	for i, h := range t {
+		out[i] = h.WithAttrs(attrs)
+	}
+	return out
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	out := make(teeHandler, len(t))
+	for i, h := range t {
+		out[i] = h.WithGroup(name)
+	}
+	return out
+}
@@ -26,7 +26,7 @@
 //  1. Caller dials Twilio phone number
 //  2. Twilio connects via Media Streams (mu-law audio)
 //  3. Audio goes to Deepgram STT → transcripts
-//  4. Transcripts are processed (echo/LLM)
+//  4. Transcripts are answered by the Agent (echo/LLM/script)
 //  5. Response goes to ElevenLabs TTS → audio
 //  6. Audio (ulaw) streams back to caller via Twilio
 package main
@@ -39,196 +39,2232 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
-	elevenlabs "github.com/agentplexus/go-elevenlabs"
-	elevenvoice "github.com/agentplexus/go-elevenlabs/omnivoice/tts"
-	deepgramstt "github.com/agentplexus/omnivoice-deepgram/omnivoice/stt"
+	"github.com/agentplexus/omnivoice-examples/kit/agent"
+	"github.com/agentplexus/omnivoice-examples/kit/audio"
+	"github.com/agentplexus/omnivoice-examples/kit/config"
+	"github.com/agentplexus/omnivoice-examples/kit/dnc"
+	"github.com/agentplexus/omnivoice-examples/kit/mediastream"
+	"github.com/agentplexus/omnivoice-examples/kit/moderation"
+	"github.com/agentplexus/omnivoice-examples/kit/phone"
+	"github.com/agentplexus/omnivoice-examples/kit/session"
+	"github.com/agentplexus/omnivoice-examples/kit/storage"
+	"github.com/agentplexus/omnivoice-examples/kit/telemetry"
+	"github.com/agentplexus/omnivoice-examples/kit/twilioauth"
+	"github.com/agentplexus/omnivoice-examples/kit/twiml"
 	twiliotransport "github.com/agentplexus/omnivoice-twilio/transport"
 	"github.com/agentplexus/omnivoice/pipeline"
+	"github.com/agentplexus/omnivoice/stt"
 	"github.com/agentplexus/omnivoice/transport"
+	"github.com/agentplexus/omnivoice/tts"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Get API keys from environment
-	elevenLabsAPIKey := os.Getenv("ELEVENLABS_API_KEY")
-	if elevenLabsAPIKey == "" {
-		log.Fatal("ELEVENLABS_API_KEY environment variable required")
+	// Structured logs as JSON lines for log pipelines, text otherwise
+	switch format := os.Getenv("LOG_FORMAT"); format {
+	case "", "text":
+	case "json":
+		slog.SetDefault(slog.New(slog.NewJSONHandler(os.Stderr, nil)))
+	default:
+		log.Fatalf("Invalid LOG_FORMAT: %q (want text or json)", format)
+	}
+
+	// Export traces when an OTLP endpoint is configured
+	shutdownTracing, err := setupTracing(ctx)
+	if err != nil {
+		log.Fatalf("Failed to set up tracing: %v", err)
+	}
+	defer func() {
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			slog.Error("failed to flush traces", "error", err)
+		}
+	}()
+
+	// "soak" runs loopback calls through the pipeline instead of serving
+	if len(os.Args) > 1 && os.Args[1] == "soak" {
+		cfg, err := soakConfigFromEnv()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := runSoak(ctx, cfg); err != nil {
+			log.Fatalf("Soak test failed: %v", err)
+		}
+		return
+	}
+
+	// "bench" measures the garbage the per-frame audio path makes at many
+	// concurrent calls
+	if len(os.Args) > 1 && os.Args[1] == "bench" {
+		cfg, err := benchConfigFromEnv()
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := runBench(ctx, cfg); err != nil {
+			log.Fatalf("Audio benchmark failed: %v", err)
+		}
+		return
+	}
+
+	// Providers, voices, prompts, timeouts and feature flags come from
+	// CONFIG_FILE, if set, overridden by the environment
+	cfg, err := config.Load(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	// OFFLINE=1 runs on mock providers, without API keys or Twilio
+	offline, err := offlineConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if offline.Enabled {
+		slog.Warn("running offline: speech is simulated and nothing is sent to Twilio")
+		offline.Apply(&cfg)
+	} else {
+		if cfg.ElevenLabs.APIKey == "" {
+			log.Fatal("ELEVENLABS_API_KEY (elevenlabs.api_key) required")
+		}
+		if cfg.Deepgram.APIKey == "" {
+			log.Fatal("DEEPGRAM_API_KEY (deepgram.api_key) required")
+		}
+	}
+	if err := validateSTTFormatting(cfg.Deepgram); err != nil {
+		log.Fatal(err)
+	}
+
+	// Optionally request linear PCM from ElevenLabs and resample it to 8kHz
+	// mu-law locally, as needed for providers without telephony formats.
+	if cfg.ElevenLabs.PCMSampleRate < 0 {
+		log.Fatalf("Invalid TTS_PCM_SAMPLE_RATE: %d", cfg.ElevenLabs.PCMSampleRate)
+	}
+	resampleQuality := audio.QualitySinc
+	if v := os.Getenv("RESAMPLER_QUALITY"); v != "" {
+		q, err := audio.ParseQuality(v)
+		if err != nil {
+			log.Fatalf("Invalid RESAMPLER_QUALITY: %v", err)
+		}
+		resampleQuality = q
+	}
+
+	// Wire codec for transports that don't report their own (Twilio is mu-law)
+	transportCodec := audio.CodecMulaw
+	if v := os.Getenv("TRANSPORT_CODEC"); v != "" {
+		codec, err := audio.ParseCodec(v)
+		if err != nil {
+			log.Fatalf("Invalid TRANSPORT_CODEC: %v", err)
+		}
+		transportCodec = codec
+	}
+
+	// Similarity at which a repeated sentence is suppressed (0 disables)
+	dedupThreshold := defaultDedupThreshold
+	if v := os.Getenv("TTS_DEDUP_THRESHOLD"); v != "" {
+		threshold, err := strconv.ParseFloat(v, 64)
+		if err != nil || threshold < 0 || threshold > 1 {
+			log.Fatalf("Invalid TTS_DEDUP_THRESHOLD: %q (want 0-1)", v)
+		}
+		dedupThreshold = threshold
+	}
+
+	// Synthesized audio kept in memory for phrases said again
+	ttsCache, err := ttsCacheFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// How much audio is queued ahead of playback, and what a stalled send
+	// to Twilio does to it
+	outboundBuffer, err := outboundBufferFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Goroutine budget: streams handled at once, background tasks per
+	// call, and the workers shared for transcoding
+	concurrency, err := concurrencyConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Transcripts reach the agent with numbers and dates written out
+	itn, err := itnFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Data residency policy; every provider's endpoints and the archive
+	// must fall within it
+	residency, err := parseResidencyPolicy(os.Getenv("DATA_RESIDENCY"))
+	if err != nil {
+		log.Fatalf("Invalid DATA_RESIDENCY: %v", err)
+	}
+
+	// Content moderation of caller transcripts and agent replies
+	moderationConfig, err := moderationConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	moderator, err := moderationConfig.moderator(residency)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Interceptors that transcripts and replies pass through
+	interceptorNames, err := interceptorsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if moderator != nil && !slices.Contains(interceptorNames, interceptModeration) {
+		log.Fatalf("MODERATION=%s requires moderation in INTERCEPTORS", moderationConfig.Checker)
+	}
+
+	// Self-echo suppression for callers on speakerphone
+	echoGuardConfig, err := echoGuardConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	echoGuardConfig.Enabled = cfg.Features.EchoGuard
+
+	// What the agent does when the caller talks over it
+	doubleTalk, err := doubleTalkFromConfig(cfg.DoubleTalk)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Background ambience mixed under the agent's voice, or else comfort
+	// noise between its replies
+	ambience, err := ambienceFromEnv(resampleQuality)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var comfortNoise *ambienceBed
+	if ambience == nil {
+		if comfortNoise, err = comfortNoiseFromEnv(cfg.Features.ComfortNoise); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// Topic lexicon for segmenting call transcripts
+	topics, err := topicsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// A fallback model for when the primary is slow or failing
+	llmGuard, err := llmGuardFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	llmGuard.Guardrails = cfg.Features.Guardrails
+	llmGuard.Residency = residency
+	// Regional endpoints of the language model providers, in preference
+	// order (e.g. OPENAI_REGIONS="eu=https://eu.api.openai.com/v1,...")
+	if llmGuard.Regions, err = llmPoolsFromEnv(residency); err != nil {
+		log.Fatalf("Invalid LLM regions: %v", err)
+	}
+	for _, pool := range llmGuard.Regions {
+		go pool.Run(ctx, 30*time.Second)
+	}
+
+	// Replies tagged with emotions, spoken with the TTS provider's controls
+	emotions, err := emotionsFromConfig(cfg)
+	if err != nil {
+		log.Fatal(err)
+	}
+	llmGuard.Instructions = emotionInstructions(emotions)
+
+	// How numbers without a country code are read, for configured numbers,
+	// transfer targets and caller IDs alike
+	dialPlan := dialPlanFromEnv()
+
+	// Follow-up texts the agent sends callers with the send_sms tool
+	sms, err := smsFollowUpFromEnv(dialPlan)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if sms != nil {
+		llmGuard.Tools = append(llmGuard.Tools, sms.Tool())
+	}
+
+	// Call summaries emailed to a team inbox and to callers who ask
+	email, err := callEmailFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if email != nil {
+		llmGuard.Tools = append(llmGuard.Tools, email.Tool())
+	}
+
+	// Appointment booking on a Google Calendar
+	booking, err := bookingFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if booking != nil {
+		llmGuard.Tools = append(llmGuard.Tools, booking.Tools()...)
+	}
+
+	// Card payments keyed in on the caller's keypad
+	payments, err := paymentsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if payments != nil {
+		llmGuard.Tools = append(llmGuard.Tools, payments.Tool())
+	}
+
+	// Answer with a language model when one is configured, otherwise echo
+	brain, err := newBrain(cfg.LLM, cfg.Prompts.System, llmGuard)
+	if err != nil {
+		log.Fatalf("Invalid LLM configuration: %v", err)
+	}
+
+	// Specialist agents that hand the call to each other
+	if len(cfg.Team) > 0 {
+		if brain, err = newTeam(cfg.LLM, cfg.Prompts.System, cfg.Team, llmGuard); err != nil {
+			log.Fatalf("Invalid team configuration: %v", err)
+		}
+	}
+
+	// Per-number agents, so one server can host several branded agents
+	tenants, err := newTenants(cfg, brain, dialPlan, llmGuard)
+	if err != nil {
+		log.Fatalf("Invalid tenant configuration: %v", err)
+	}
+
+	// A/B experiments across voices, models and prompts
+	experiments, err := newExperiments(cfg, brain, tenants, dialPlan, llmGuard)
+	if err != nil {
+		log.Fatalf("Invalid experiment configuration: %v", err)
+	}
+
+	// Graceful shutdown: how long to let calls finish on SIGTERM
+	if cfg.Timeouts.Drain < 0 {
+		log.Fatalf("Invalid DRAIN_TIMEOUT: %v", cfg.Timeouts.Drain)
+	}
+	drain := drainPolicyFromEnv()
+	drain.Timeout = cfg.Timeouts.Drain
+
+	// Concurrent call cap, the queue for calls over it, and per-caller
+	// rate limit
+	limits, err := sessionLimitsFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Latency and error-rate objectives, alerting when one is breached
+	sloConfig, err := sloConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Session events sent to a webhook of your own
+	eventWebhookConfig, err := eventWebhookConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Prices for per-call cost accounting
+	pricing, err := pricingFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Reconnecting, retrying and apologizing when a provider fails mid-call
+	resilience, err := resiliencePolicyFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Asking callers to repeat what the recognizer wasn't sure of
+	confidence, err := confidencePolicyFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Canned replies to common intents, before the agent is consulted
+	intents, err := intentsFromEnv(llmGuard)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Opening hours, outside which callers leave a message instead
+	businessHours, err := businessHoursFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Shorter replies, FAQ answers, then voicemail as providers struggle
+	degradationConfig, err := degradationConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// When to greet: at once, or after the caller speaks (per number called)
+	if cfg.Timeouts.GreetingSilence <= 0 {
+		log.Fatalf("Invalid GREETING_SILENCE_TIMEOUT: %v", cfg.Timeouts.GreetingSilence)
+	}
+	greeting, err := greetingConfigFromEnv(dialPlan)
+	if err != nil {
+		log.Fatal(err)
+	}
+	greeting.Text = firstNonEmpty(cfg.Prompts.Greeting, greeting.Text)
+	greeting.SilenceTimeout = cfg.Timeouts.GreetingSilence
+
+	// Goodbye and hand-off behavior
+	termination := terminationPolicyFromEnv()
+	termination.Hangup = cfg.Features.GoodbyeHangup
+	silence := silencePolicyFromEnv()
+	silence.PromptAfter = cfg.Timeouts.SilencePrompt
+	silence.HangupAfter = cfg.Timeouts.SilenceHangup
+
+	// What Twilio says, and passes to the stream, before the agent answers
+	callTwiML := defaultTwiMLConfig()
+	callTwiML.ConnectMessage = firstNonEmpty(cfg.Twilio.ConnectMessage, callTwiML.ConnectMessage)
+	callTwiML.Voice = cfg.Twilio.SayVoice
+	callTwiML.Language = cfg.Twilio.SayLanguage
+	callTwiML.Parameters = cfg.Twilio.StreamParameters
+	if err := validateStreamParameters(callTwiML.Parameters); err != nil {
+		log.Fatalf("Invalid TWILIO_STREAM_PARAMETERS: %v", err)
+	}
+	silence.MaxDuration = cfg.Timeouts.MaxCall
+	switch {
+	case silence.PromptAfter < 0:
+		log.Fatalf("Invalid SILENCE_PROMPT_TIMEOUT: %v", silence.PromptAfter)
+	case silence.HangupAfter < 0:
+		log.Fatalf("Invalid SILENCE_HANGUP_TIMEOUT: %v", silence.HangupAfter)
+	case silence.MaxDuration < 0:
+		log.Fatalf("Invalid MAX_CALL_DURATION: %v", silence.MaxDuration)
+	case silence.HangupAfter > 0 && silence.PromptAfter >= silence.HangupAfter:
+		log.Fatalf("Invalid SILENCE_PROMPT_TIMEOUT: %v (must be shorter than SILENCE_HANGUP_TIMEOUT)", silence.PromptAfter)
+	}
+	transfer, err := transferPolicyFromEnv(dialPlan)
+	if err != nil {
+		log.Fatal(err)
+	}
+	transfer.Coaching = cfg.Features.Coaching
+
+	// Caller transcripts watched for emergencies
+	emergency, err := emergencyPolicyFromEnv(dialPlan)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Optional per-call log files for debugging a single call
+	logDir := os.Getenv("LOG_DIR")
+	if logDir != "" {
+		if err := os.MkdirAll(logDir, 0o750); err != nil {
+			log.Fatalf("Invalid LOG_DIR: %v", err)
+		}
+	}
+
+	// Hand-off to a human, optionally coached from a knowledge file that
+	// is reloaded as it changes
+	var knowledge *knowledgeBase
+	if path := os.Getenv("KNOWLEDGE_FILE"); path != "" {
+		knowledge, err = loadKnowledgeBase(path)
+		if err != nil {
+			log.Fatalf("Invalid KNOWLEDGE_FILE: %v", err)
+		}
+	}
+	knowledgeReload := 10 * time.Second
+	if v := os.Getenv("KNOWLEDGE_RELOAD_INTERVAL"); v != "" {
+		knowledgeReload, err = time.ParseDuration(v)
+		if err != nil || knowledgeReload < 0 {
+			log.Fatalf("Invalid KNOWLEDGE_RELOAD_INTERVAL: %q", v)
+		}
+	}
+
+	// Do-not-call list and calling hours, checked before every outbound dial
+	dialGate, closeDialAudit, err := dialGateFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if closeDialAudit != nil {
+		defer func() { _ = closeDialAudit.Close() }()
+	}
+
+	// Customer profiles from a CRM, looked up by caller ID
+	callers, err := callerLookupFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Call state shared through Redis, for running several instances
+	callState, err := callStateFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if callState.Store != nil {
+		defer func() { _ = callState.Store.Close() }()
+	}
+	if offline.Enabled {
+		// The simulated caller sends no audio, which would look like a drop
+		callState.KeepaliveTimeout = 0
+	}
+
+	if cfg.Twilio.AccountSID == "" || cfg.Twilio.AuthToken == "" {
+		log.Fatal("TWILIO_ACCOUNT_SID and TWILIO_AUTH_TOKEN (twilio.account_sid and twilio.auth_token) required")
+	}
+
+	// Only serve webhooks and Media Streams signed by Twilio
+	var signatures *twilioauth.Validator
+	if cfg.Twilio.ValidateSignatures {
+		signatures = &twilioauth.Validator{AuthToken: cfg.Twilio.AuthToken, PublicHost: cfg.Server.PublicHost}
+	} else {
+		slog.Warn("Twilio signature validation disabled; anyone can place calls through this server")
+	}
+
+	// Calls' artifacts archived to a directory or bucket
+	archiveStore, err := storageFromEnv(residency)
+	if err != nil {
+		log.Fatal(err)
+	}
+	recordingFormat, err := recordingFormatFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Caller audio of turns the recognizer may have got wrong, archived
+	// for debugging
+	snippets, err := snippetPolicyFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if snippets.Enabled && archiveStore == nil {
+		log.Fatal("AUDIO_SNIPPETS requires STORAGE_URL: snippets are stored with the call archive")
+	}
+
+	// Speech providers: regional ElevenLabs and Deepgram, or mocks offline
+	var (
+		ttsProvider      tts.StreamingProvider
+		sttProvider      stt.StreamingProvider
+		ttsPool, sttPool *RegionPool
+	)
+	if offline.Enabled {
+		ttsProvider, sttProvider = offline.Providers()
+	} else {
+		// Regional endpoints, in preference order (e.g. "eu=api.eu.deepgram.com,us=api.deepgram.com")
+		ttsRegions, err := regionsFromEnv("ELEVENLABS_REGIONS")
+		if err != nil {
+			log.Fatalf("Invalid ElevenLabs regions: %v", err)
+		}
+		sttRegions, err := regionsFromEnv("DEEPGRAM_REGIONS")
+		if err != nil {
+			log.Fatalf("Invalid Deepgram regions: %v", err)
+		}
+		if err := residency.Validate("elevenlabs", ttsRegions); err != nil {
+			log.Fatal(err)
+		}
+		if err := residency.Validate("deepgram", sttRegions); err != nil {
+			log.Fatal(err)
+		}
+		ttsPool = NewRegionPool("elevenlabs", ttsRegions, residency)
+		sttPool = NewRegionPool("deepgram", sttRegions, residency)
+		go ttsPool.Run(ctx, 30*time.Second)
+		go sttPool.Run(ctx, 30*time.Second)
+
+		// Create ElevenLabs TTS provider (one client per region)
+		settings, err := voiceSettings(cfg.ElevenLabs.VoiceSettings)
+		if err != nil {
+			log.Fatal(err)
+		}
+		ttsProvider, err = newRegionalTTSProvider(cfg.ElevenLabs.APIKey, ttsPool, settings, emotions)
+		if err != nil {
+			log.Fatalf("Failed to create ElevenLabs client: %v", err)
+		}
+
+		// Create Deepgram STT provider
+		sttProvider, err = newRegionalSTTProvider(cfg.Deepgram.APIKey, sttPool)
+		if err != nil {
+			log.Fatalf("Failed to create Deepgram provider: %v", err)
+		}
+	}
+
+	// Text is marked up in the dialect each TTS provider reads
+	markup, err := markupConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	markup.Prosody = emotionProsody(emotions)
+
+	// Brand and product names said the way they should be
+	lexicon, err := newLexicon(cfg.Pronunciations)
+	if err != nil {
+		log.Fatal(err)
+	}
+	markup.Lexicon = lexicon
+	ttsProvider = markup.wrap(ttsProvider)
+
+	// Prompts said word for word, rendered ahead of time in the primary
+	// voice; "prompts" renders them and exits, e.g. when building an image
+	promptLibrary, err := promptsFromEnv(ctx, ttsProvider, cfg.ElevenLabs, lexicon)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if len(os.Args) > 1 && os.Args[1] == "prompts" {
+		if promptLibrary == nil {
+			log.Fatal("PROMPTS_FILE is not set")
+		}
+		return
+	}
+
+	// Create Twilio Media Streams transport
+	twilioTransport, err := twiliotransport.New(
+		twiliotransport.WithAccountSID(cfg.Twilio.AccountSID),
+		twiliotransport.WithAuthToken(cfg.Twilio.AuthToken),
+	)
+	if err != nil {
+		log.Fatalf("Failed to create Twilio transport: %v", err)
+	}
+	defer func() { _ = twilioTransport.Close() }()
+
+	// Provider connectivity and credentials for /healthz and /readyz
+	twilio := newTwilioClient(cfg.Twilio.AccountSID, cfg.Twilio.AuthToken)
+	health := NewHealthChecker()
+	var offlineTwilio *offlineTwilioAPI
+	if offline.Enabled {
+		offlineTwilio = newOfflineTwilioAPI()
+		defer offlineTwilio.Close()
+		twilio = offlineTwilio.Client()
+	} else {
+		probeClient := &http.Client{Timeout: healthProbeTimeout}
+		health.Add("deepgram", deepgramProbe(cfg.Deepgram.APIKey, sttPool, probeClient))
+		health.Add("elevenlabs", elevenLabsProbe(cfg.ElevenLabs.APIKey, ttsPool, probeClient))
+		health.Add("twilio", twilio.Ping)
+	}
+	if callState.Store != nil {
+		health.Add("redis", callState.Store.Ping)
+	}
+
+	// Outbound campaign, calling a list of contacts through Twilio
+	campaignConfig, err := campaignConfigFromEnv(dialPlan)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var campaign *Campaign
+	if campaignConfig.File != "" {
+		if offline.Enabled {
+			log.Fatal("CAMPAIGN_FILE can't be used offline: calls can't be placed")
+		}
+		if cfg.Server.PublicHost == "" {
+			log.Fatal("CAMPAIGN_FILE requires PUBLIC_HOST, for Twilio to reach the campaign's calls")
+		}
+		campaign, err = NewCampaign(campaignConfig, dialPlan, twilio, dialGate, "https://"+cfg.Server.PublicHost)
+		if err != nil {
+			log.Fatalf("Invalid CAMPAIGN_FILE: %v", err)
+		}
+		defer func() { _ = campaign.Close() }()
+	}
+
+	// Secondary STT and TTS providers, taking over when the primary misses
+	// its objectives or fails its health check
+	fallbackConfig, err := fallbackConfigFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	var fallback *ProviderFallback
+	if fallbackConfig.STT.Name != "" || fallbackConfig.TTS.Name != "" {
+		fallback = &ProviderFallback{interval: fallbackConfig.Interval}
+	}
+	if spec := fallbackConfig.STT; spec.Name != "" {
+		if spec.Name == "deepgram" {
+			spec.APIKey = firstNonEmpty(spec.APIKey, cfg.Deepgram.APIKey)
+		}
+		secondary, err := newSecondarySTT(spec, offline)
+		if err != nil {
+			log.Fatalf("Invalid STT_SECONDARY: %v", err)
+		}
+		if spec.Name != "mock" {
+			if err := residency.Validate(spec.Name, []Region{{Name: "secondary", URL: spec.URL}}); err != nil {
+				log.Fatal(err)
+			}
+		}
+		var probe string
+		if !offline.Enabled {
+			probe = "deepgram"
+		}
+		chain := newFallbackSTT(sttProvider, secondary, fallbackConfig, health, probe)
+		sttProvider, fallback.stt = chain, chain.chain
+		slog.Info("STT fallback configured", "primary", chain.providers[0].Name(), "secondary", secondary.Name())
+	}
+	if spec := fallbackConfig.TTS; spec.Name != "" {
+		if spec.Name == "elevenlabs" {
+			spec.APIKey = firstNonEmpty(spec.APIKey, cfg.ElevenLabs.APIKey)
+		}
+		secondary, err := newSecondaryTTS(spec, offline, resampleQuality)
+		if err != nil {
+			log.Fatalf("Invalid TTS_SECONDARY: %v", err)
+		}
+		if spec.Name != "mock" {
+			if err := residency.Validate(spec.Name, []Region{{Name: "secondary", URL: spec.URL}}); err != nil {
+				log.Fatal(err)
+			}
+		}
+		var probe string
+		if !offline.Enabled {
+			probe = "elevenlabs"
+		}
+		chain := newFallbackTTS(ttsProvider, markup.wrap(secondary), fallbackConfig, health, probe)
+		ttsProvider, fallback.tts = chain, chain.chain
+		slog.Info("TTS fallback configured", "primary", chain.providers[0].Name(), "secondary", secondary.Name())
+	}
+
+	// Handle shutdown
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+
+	// Sessions outlive the shutdown signal so they can drain
+	sessionsCtx, cancelSessions := context.WithCancel(context.Background())
+	defer cancelSessions()
+
+	// Create server with providers
+	server := &Server{
+		agent:           brain,
+		emotions:        emotions,
+		teamVoices:      teamVoices(cfg.Team),
+		tenants:         tenants,
+		experiments:     experiments,
+		dialPlan:        dialPlan,
+		ttsProvider:     ttsProvider,
+		sttProvider:     sttProvider,
+		twilioTransport: twilioTransport,
+		tts:             cfg.ElevenLabs,
+		stt:             cfg.Deepgram,
+		ttsPCMRate:      cfg.ElevenLabs.PCMSampleRate,
+		resampleQuality: resampleQuality,
+		transportCodec:  transportCodec,
+		residency:       residency,
+		dedupThreshold:  dedupThreshold,
+		ttsCache:        ttsCache,
+		prompts:         promptLibrary,
+		itn:             itn,
+		confidence:      NewConfidenceGate(confidence),
+		snippets:        snippets,
+		moderation:      moderationConfig,
+		moderator:       moderator,
+		echoGuard:       echoGuardConfig,
+		ambience:        ambience,
+		comfortNoise:    comfortNoise,
+		doubleTalk:      doubleTalk,
+		latency:         NewLatencyStats(),
+		outbound:        newOutboundMetrics(outboundBuffer),
+		concurrency:     newConcurrency(concurrency),
+		slo:             NewSLOMonitor(sloConfig),
+		degradation:     NewDegradationLadder(degradationConfig, health),
+		resilience:      resilience,
+		fallback:        fallback,
+		pricing:         pricing,
+		costs:           NewCostStats(pricing),
+		metadata:        newMetadataStore(),
+		topics:          topics,
+		greeting:        greeting,
+		termination:     termination,
+		silence:         silence,
+		callTwiML:       callTwiML,
+		twilio:          twilio,
+		transfer:        transfer,
+		emergency:       emergency,
+		dial:            dialGate,
+		callers:         callers,
+		campaign:        campaign,
+		sms:             sms,
+		email:           email,
+		booking:         booking,
+		payments:        payments,
+		intents:         intents,
+		state:           callState,
+		held:            newHeldCalls(callState.ResumeGrace),
+		coaching:        newCoachingHub(),
+		publicHost:      cfg.Server.PublicHost,
+		signatures:      signatures,
+		logDir:          logDir,
+		drain:           drain,
+		sessions:        NewSessionManager(limits),
+		events:          NewEventBus(),
+	}
+	server.newCoach = func() Coach {
+		return newPlaybookCoach(topics, defaultPlaybook(), knowledge.Snippets())
+	}
+
+	// Metrics, the admin API and the webhook follow calls through their
+	// lifecycle events
+	server.subscribeMetrics()
+	server.interceptors = server.builtinInterceptors(interceptorNames)
+	server.redactInterim = slices.Contains(interceptorNames, interceptRedact)
+	recentEvents := newEventLog(server.events)
+	server.eventWebhook = newEventWebhook(sessionsCtx, eventWebhookConfig, server.events)
+	server.emergencyWebhook = newEventWebhook(sessionsCtx, EventWebhookConfig{URL: emergency.WebhookURL, Types: []string{eventEmergencyDetected}}, server.events)
+	server.archive = newCallArchive(archiveStore, server.twilio, server.events, recordingFormat)
+	if businessHours != nil {
+		server.businessHours = businessHours
+		server.afterHours = newAfterHoursVoicemail(sessionsCtx, server.twilio, archiveStore, sttProvider, cfg.Deepgram, email, server.events)
+	}
+
+	// Anonymous feature-usage counts, only if opted in with TELEMETRY
+	usage, err := newTelemetry(cfg.Telemetry)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if usage != nil {
+		slog.Info("telemetry enabled", "mode", cfg.Telemetry.Mode, "file", cfg.Telemetry.File)
+		server.usage = usage
+		usage.Configured(server.configuredFeatures(cfg, offline.Enabled)...)
+		go usage.Run(sessionsCtx)
+	}
+
+	// Start HTTP server
+	http.Handle("/voice/inbound", server.requireTwilio(http.HandlerFunc(server.handleInboundCall)))
+	if limits.QueueSize > 0 {
+		http.Handle("/voice/hold", server.requireTwilio(http.HandlerFunc(server.handleHold)))
+		server.sessions.OnAdmit(server.admitQueued)
+	}
+	// Media Streams are handed over once their start message, with the
+	// call's custom parameters, has arrived
+	streams, err := mediastream.NewServer(sessionsCtx, twilioTransport, "/media-stream")
+	if err != nil {
+		log.Fatalf("Failed to start Media Streams listener: %v", err)
+	}
+	http.Handle("/media-stream", server.requireTwilio(streams))
+	http.Handle("/stats/latency", server.latency)
+	http.Handle("/stats/cost", server.costs)
+	http.Handle("/stats/confidence", server.confidence)
+	http.Handle("/stats/outbound", server.outbound)
+	http.Handle("/stats/concurrency", server.concurrency)
+	if server.slo != nil {
+		http.Handle("/stats/slo", server.slo)
+		go server.slo.Run(sessionsCtx)
+	}
+	if server.degradation != nil {
+		http.Handle("/stats/degradation", server.degradation)
+		http.Handle("/voice/voicemail", server.requireTwilio(http.HandlerFunc(server.handleVoicemail)))
+		go server.degradation.Run(sessionsCtx)
+	}
+	if server.afterHours != nil {
+		http.Handle("/voice/after-hours", server.requireTwilio(http.HandlerFunc(server.handleAfterHours)))
+	}
+	if server.archive != nil {
+		http.Handle("/recordings/status", server.requireTwilio(http.HandlerFunc(server.handleRecordingStatus)))
+	}
+	if server.experiments != nil {
+		http.Handle("/stats/experiments", server.experiments)
+	}
+	if server.ttsCache != nil {
+		http.Handle("/stats/tts-cache", server.ttsCache)
+	}
+	if server.fallback != nil {
+		http.Handle("/stats/providers", server.fallback)
+		go server.fallback.Run(sessionsCtx)
+	}
+	if server.campaign != nil {
+		http.Handle("/stats/campaign", server.campaign)
+		http.Handle("/voice/outbound", server.requireTwilio(http.HandlerFunc(server.handleOutboundAnswer)))
+		http.Handle("/voice/outbound/status", server.requireTwilio(http.HandlerFunc(server.handleOutboundStatus)))
+	}
+	http.Handle("/coach/", server.coaching)
+	http.HandleFunc("/healthz", health.Healthz)
+	http.HandleFunc("/readyz", health.Readyz)
+	if token := cfg.Server.AdminToken; token != "" {
+		// Archived calls are listed if the archive can be read back
+		archiveReader, _ := archiveStore.(storage.Reader)
+		http.Handle("/admin/", newAdminHandler(server.sessions, recentEvents, archiveReader, token))
+		http.HandleFunc("GET /dashboard", handleDashboard)
+		if knowledge != nil {
+			http.Handle("POST /admin/knowledge/reload", requireToken(token, knowledge))
+		}
+	}
+	if knowledge != nil && knowledgeReload > 0 {
+		go knowledge.Watch(sessionsCtx, knowledgeReload)
+	}
+
+	addr := cfg.Server.Addr
+	slog.Info("starting voice agent server", "addr", addr)
+
+	httpServer := &http.Server{
+		Addr:              addr,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	// Handle incoming connections
+	go server.handleConnections(sessionsCtx, streams.Connections())
+
+	// Offline, show the session logic at work on a simulated call
+	if offline.Enabled {
+		go runOfflineCall(sessionsCtx, server, offlineTwilio, offline)
+	}
+
+	go func() {
+		if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatalf("Server error: %v", err)
+		}
+	}()
+
+	// Campaign calls stop being placed once the server drains
+	if server.campaign != nil {
+		go server.campaign.Run(sessionsCtx, server.sessions.Draining)
+	}
+
+	// The first signal drains: no new calls, and calls in progress get up
+	// to DRAIN_TIMEOUT to finish. A second signal ends them immediately.
+	<-sigCh
+	health.SetDraining()
+	slog.Info("draining", "active_calls", server.sessions.Drain(), "timeout", drain.Timeout)
+	drainSessions(server.sessions, drain.Timeout, sigCh)
+
+	slog.Info("shutting down")
+	cancelSessions()
+	server.archive.Close()
+	if err := usage.Flush(context.Background()); err != nil {
+		slog.Warn("telemetry report failed", "error", err)
+	}
+	_ = httpServer.Close()
+}
+
+// maxTurnRetries is how many times a turn is retried when speaking the
+// reply fails.
+const maxTurnRetries = 1
+
+// Server handles voice agent connections.
+type Server struct {
+	// agent answers the caller. Swap in agent.NewLLM or agent.NewScript
+	// to change the brain; the rest of the pipeline is unchanged.
+	agent agent.Agent
+
+	// emotions, if set, are what the agent tags its replies with and how
+	// each is spoken.
+	emotions map[string]config.Emotion
+
+	// teamVoices maps the team's specialists that speak in a voice of
+	// their own to it; the call switches voices as they take over.
+	teamVoices map[string]string
+
+	// tenants replaces agent, tts, stt and the greeting text for calls to
+	// the numbers it holds.
+	tenants map[string]*tenant
+
+	// experiments, if set, buckets calls into variants of their tenant's
+	// agent and compares the variants' results.
+	experiments *Experiments
+
+	// dialPlan reads numbers from configuration, the agent and callers.
+	dialPlan phone.DialPlan
+
+	ttsProvider     tts.StreamingProvider
+	sttProvider     stt.StreamingProvider
+	twilioTransport *twiliotransport.Provider
+
+	// tts and stt choose the voice, models and language.
+	tts config.ElevenLabs
+	stt config.Deepgram
+
+	// ttsPCMRate, when non-zero, requests PCM at this rate from the TTS
+	// provider and transcodes it to the wire codec locally.
+	ttsPCMRate      int
+	resampleQuality audio.Quality
+
+	// transportCodec is the wire codec assumed for connections that don't
+	// report one.
+	transportCodec audio.Codec
+
+	// residency is the data residency policy, recorded in every CDR.
+	residency ResidencyPolicy
+
+	// dedupThreshold is the similarity at which a sentence repeated within
+	// a turn is suppressed; 0 disables suppression.
+	dedupThreshold float64
+
+	// outbound bounds each call's queue of outbound audio and tracks how
+	// full it runs.
+	outbound *OutboundMetrics
+
+	// concurrency bounds the goroutines run for calls: one per stream,
+	// each call's background tasks, and the transcode workers.
+	concurrency *Concurrency
+
+	// ttsCache, if set, plays audio synthesized before from memory.
+	ttsCache *TTSCache
+
+	// prompts, if set, are played by name from audio rendered ahead of
+	// time.
+	prompts *PromptLibrary
+
+	// itn, if set, rewrites caller transcripts with their numbers, dates,
+	// amounts and email addresses written out before the agent sees them.
+	itn bool
+
+	// confidence, if set, has callers repeat what the recognizer wasn't
+	// sure of rather than answering it, and counts how often.
+	confidence *ConfidenceGate
+
+	// snippets, if enabled, archives the caller's audio for turns the
+	// recognizer was unsure of or the caller corrected.
+	snippets SnippetPolicy
+
+	// moderator, if set, checks caller transcripts and agent replies,
+	// applying its policy to what it flags; moderation has the lines said
+	// in place of what was blocked.
+	moderation ModerationConfig
+	moderator  *moderation.Moderator
+
+	// interceptors build each call's chain of interceptors, which caller
+	// transcripts and agent replies pass through in order; append to add
+	// your own, such as translation.
+	interceptors []newInterceptor
+	// redactInterim redacts interim transcripts streamed as live captions,
+	// and the text of emergency alerts raised before the interceptors run,
+	// set when the redact interceptor redacts final ones.
+	redactInterim bool
+
+	// echoGuard configures suppression of the agent's own audio leaking
+	// back from the caller's end.
+	echoGuard EchoGuardConfig
+
+	// ambience, if set, is played under the agent's voice and between its
+	// replies.
+	ambience *ambienceBed
+	// comfortNoise, if set, is played while the agent isn't speaking, so
+	// the silence isn't taken for a dropped call.
+	comfortNoise *ambienceBed
+	// doubleTalk is what the agent does when the caller talks over it,
+	// for calls to numbers without a tenant.
+	doubleTalk doubleTalk
+
+	// metadata holds SIP headers and call details from the voice webhook
+	// until the call's Media Stream connects.
+	metadata *metadataStore
+
+	// topics is the lexicon used to segment transcripts by topic for the CDR.
+	topics []Topic
+
+	// latency aggregates per-turn latency across sessions; slo, if set,
+	// judges it and the turn error rate against objectives.
+	latency *LatencyStats
+	slo     *SLOMonitor
+
+	// degradation, if set, steps service down as providers struggle:
+	// shorter replies, then FAQ answers, then voicemail.
+	degradation *DegradationLadder
+
+	// resilience is how calls ride out providers failing mid-call.
+	resilience ResiliencePolicy
+
+	// fallback, if set, moves STT or TTS traffic to a secondary provider
+	// while the primary struggles.
+	fallback *ProviderFallback
+
+	// costs, if set, totals each call's provider usage priced with pricing.
+	pricing PricingTable
+	costs   *CostStats
+
+	// greeting decides, per number called, when the agent greets.
+	greeting GreetingConfig
+
+	// termination controls goodbye detection and agent-initiated hangup.
+	termination TerminationPolicy
+	twilio      *twilioClient
+
+	// silence decides when a silent caller is prompted and hung up on,
+	// and how long a call may last.
+	silence SilencePolicy
+
+	// callTwiML is how the TwiML returned to Twilio speaks to callers and
+	// what it passes to their streams.
+	callTwiML TwiMLConfig
+
+	// transfer controls hand-off to a human. coaching serves the human's
+	// console, fed by a Coach from newCoach for each coached call.
+	transfer TransferPolicy
+	coaching *coachingHub
+	newCoach func() Coach
+
+	// emergency watches caller transcripts for emergencies, escalating
+	// those it finds.
+	emergency EmergencyPolicy
+
+	// dial, if set, is the do-not-call gate checked before every outbound
+	// dial.
+	dial *dnc.Gate
+
+	// callers, if set, looks up each caller in a CRM as the call starts.
+	callers *CallerLookup
+
+	// campaign, if set, places outbound calls to a list of contacts.
+	campaign *Campaign
+
+	// sms, if set, texts callers the follow-ups the agent queued once
+	// their call ends.
+	sms *SMSFollowUp
+
+	// email, if set, emails a summary of each call once it ends.
+	email *CallEmail
+
+	// booking, if set, lets the agent book callers' appointments.
+	booking *Booking
+
+	// payments, if set, lets the agent take card payments keyed in by
+	// callers.
+	payments *Payments
+
+	// intents, if set, answers common requests without the agent.
+	intents *Intents
+
+	// state shares calls in progress with other instances, if configured.
+	state CallStateConfig
+
+	// held keeps calls whose streams dropped for them to reconnect, if
+	// enabled.
+	held *heldCalls
+
+	// publicHost is the host Twilio reaches this server on. When unset, the
+	// host of the most recent voice webhook is used.
+	publicHost  string
+	webhookHost atomic.Pointer[string]
+
+	// signatures, when set, rejects webhooks and Media Streams not signed
+	// by Twilio, and agent streams without their call's stream token.
+	signatures *twilioauth.Validator
+
+	// logDir, when set, receives a JSON log file per call.
+	logDir string
+
+	// drain controls graceful shutdown; sessions tracks the calls in
+	// progress, enforces call limits, and lets shutdown wait for calls.
+	drain    DrainPolicy
+	sessions *SessionManager
+
+	// usage, when set, counts the providers and features calls use.
+	usage *telemetry.Reporter
+
+	// events carries agent calls' lifecycle events to the subsystems that
+	// follow them, such as eventWebhook, if set, and emergencyWebhook,
+	// which is sent only emergencies.
+	events           *EventBus
+	eventWebhook     *eventWebhook
+	emergencyWebhook *eventWebhook
+
+	// archive, if set, stores each call's CDR, transcript, summary and
+	// recordings.
+	archive *callArchive
+
+	// businessHours, if set, sends calls outside opening hours to
+	// voicemail, whose messages afterHours stores, transcribes and sends
+	// on.
+	businessHours *BusinessHours
+	afterHours    *afterHoursVoicemail
+}
+
+// handleInboundCall returns TwiML to connect the call to Media Streams.
+func (s *Server) handleInboundCall(w http.ResponseWriter, r *http.Request) {
+	// While draining, fail the webhook so Twilio uses the number's fallback URL
+	if s.sessions.Draining() {
+		http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+
+	// Capture SIP headers and call details for the session
+	metadata := metadataFromWebhook(r.Form, s.callTwiML.Parameters)
+	metadata.normalizeNumbers(s.dialPlan)
+	slog.Info("incoming call", "from", metadata.From, "to", metadata.To, "call_sid", metadata.CallSID)
+
+	// At the bottom of the degradation ladder, Twilio takes a message
+	// without the call reaching the providers
+	if s.degradation.Level() == LevelVoicemail {
+		slog.Warn("taking a message, service degraded", "call_sid", metadata.CallSID)
+		writeTwiML(w, s.degradation.voicemailTwiML(s.callTwiML, fmt.Sprintf("https://%s/voice/voicemail", r.Host)))
+		return
+	}
+
+	// A call whose stream dropped comes back here through <Redirect>, and
+	// a queued call once let in, with the metadata it first arrived with
+	_, reconnecting := s.sharedMetadata(r.Context(), metadata.CallSID)
+	reconnecting = reconnecting || s.held.Holding(metadata.CallSID)
+	queued, dequeued := s.sessions.Dequeued(metadata.CallSID)
+	if dequeued {
+		metadata = queued
+	}
+
+	// Outside opening hours a new caller leaves a message; one already
+	// talking to the agent, or let in from the queue, carries on
+	if !reconnecting && !dequeued && !s.businessHours.OpenAt(time.Now()) {
+		slog.Info("taking a message, after hours", "call_sid", metadata.CallSID)
+		writeTwiML(w, s.businessHours.afterHoursTwiML(s.callTwiML, fmt.Sprintf("https://%s/voice/after-hours", r.Host)))
+		return
+	}
+
+	// Hold a slot for the call, or turn it away politely; a call over the
+	// cap waits in the queue if there is one. Reconnecting calls are let in.
+	if reconnecting {
+		slog.Info("call reconnecting", "call_sid", metadata.CallSID)
+	} else if err := s.sessions.Reserve(metadata.CallSID, metadata.From); err != nil {
+		if err == errAtCapacity && s.queueCall(w, r, metadata) {
+			return
+		}
+		slog.Warn("rejecting call", "call_sid", metadata.CallSID, "reason", err)
+		switch err {
+		case errAtCapacity:
+			writeTwiML(w, hangupTwiML(s.callTwiML.say(s.sessions.limits.BusyMessage)))
+		case errRateLimited:
+			writeTwiML(w, hangupTwiML(s.callTwiML.say(s.sessions.limits.RateLimitedMessage)))
+		default:
+			http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+		}
+		return
+	}
+	s.metadata.Put(metadata)
+	s.shareMetadata(r.Context(), metadata)
+	host := r.Host
+	s.webhookHost.Store(&host)
+
+	// Return TwiML to connect to Media Streams
+	wsURL := fmt.Sprintf("wss://%s/media-stream", r.Host)
+	params := metadata.streamParameters()
+	if s.signatures != nil {
+		params[paramStreamToken] = twilioauth.StreamToken(s.signatures.AuthToken, metadata.CallSID, time.Now().Add(twilioauth.StreamTokenTTL))
+	}
+	var reconnectURL string
+	if s.state.Store != nil || s.held != nil {
+		reconnectURL = fmt.Sprintf("https://%s/voice/inbound", r.Host)
+	}
+	// A reconnecting caller is mid-conversation, so isn't told they're
+	// being connected
+	twimlConfig := s.callTwiML
+	if reconnecting {
+		twimlConfig.ConnectMessage = ""
+	}
+	writeTwiML(w, connectTwiML(twimlConfig, wsURL, params, reconnectURL))
+}
+
+// TwiMLConfig is how the TwiML returned to Twilio speaks to callers and
+// what it passes to their streams.
+type TwiMLConfig struct {
+	// ConnectMessage is said while the call connects to the agent.
+	ConnectMessage string
+	// Voice and Language are what Twilio reads messages in; empty uses
+	// Twilio's defaults.
+	Voice    string
+	Language string
+	// Parameters are passed to every agent stream as custom parameters,
+	// reaching the session as metadata.
+	Parameters map[string]string
+}
+
+// defaultTwiMLConfig returns the configuration used unless overridden by
+// the config's twilio.connect_message, twilio.say_voice,
+// twilio.say_language and twilio.stream_parameters (TWILIO_CONNECT_MESSAGE,
+// TWILIO_SAY_VOICE, TWILIO_SAY_LANGUAGE and TWILIO_STREAM_PARAMETERS).
+func defaultTwiMLConfig() TwiMLConfig {
+	return TwiMLConfig{ConnectMessage: "Connecting you to the voice assistant."}
+}
+
+// say reads text to the caller in the configured voice and language.
+func (c TwiMLConfig) say(text string) twiml.Say {
+	return twiml.Say{Text: text, Voice: c.Voice, Language: c.Language}
+}
+
+// connectTwiML connects the call to a Media Stream at wsURL, passing params
+// to the session as custom parameters. If reconnectURL is set, Twilio
+// fetches new TwiML from it when the stream ends while the call is still
+// up, so a call whose stream dropped, or whose instance went away,
+// reconnects.
+func connectTwiML(c TwiMLConfig, wsURL string, params map[string]string, reconnectURL string) string {
+	var doc twiml.Response
+	if c.ConnectMessage != "" {
+		doc = append(doc, c.say(c.ConnectMessage))
+	}
+	doc = append(doc, twiml.Connect{Stream: twiml.Stream{URL: wsURL, Parameters: params}})
+	if reconnectURL != "" {
+		doc = append(doc, twiml.Redirect{URL: reconnectURL})
+	}
+	return doc.String()
+}
+
+// writeTwiML writes a TwiML response.
+func writeTwiML(w http.ResponseWriter, doc string) {
+	w.Header().Set("Content-Type", twiml.ContentType)
+	if _, err := w.Write([]byte(doc)); err != nil {
+		slog.Error("failed to write TwiML", "error", err)
+	}
+}
+
+// host returns the host Twilio reaches this server on.
+func (s *Server) host() string {
+	if s.publicHost != "" {
+		return s.publicHost
+	}
+	if host := s.webhookHost.Load(); host != nil {
+		return *host
+	}
+	return ""
+}
+
+// newOutbound paces audio written to wire at real time, transcoding TTS
+// output at outputRate to codec first when transcode is set. Stop the
+// returned pacer when the session ends.
+func (s *Server) newOutbound(wire transport.Connection, codec audio.Codec, outputRate int, transcode bool) (transport.Connection, *pacedConnection, error) {
+	paced := newPacedConnection(wire, s.outbound.Buffer)
+	s.outbound.track(paced.pacer)
+	if !transcode {
+		return paced, paced, nil
+	}
+	transcoded, err := newTranscodingConnection(paced, outputRate, codec, s.resampleQuality, s.concurrency.transcode)
+	if err != nil {
+		paced.Stop()
+		return nil, nil, err
+	}
+	return transcoded, paced, nil
+}
+
+// requireTwilio rejects requests to h not signed by Twilio, unless
+// signature validation is disabled.
+func (s *Server) requireTwilio(h http.Handler) http.Handler {
+	if s.signatures == nil {
+		return h
+	}
+	return s.signatures.Middleware(h)
+}
+
+// verifyStream checks that an agent stream carries the stream token of its
+// call, so a signed handshake can't be replayed to start a stream for
+// another call. A connection without custom parameters has no token, and
+// is rejected.
+func (s *Server) verifyStream(conn transport.Connection, callSID string) error {
+	if s.signatures == nil {
+		return nil
+	}
+	c, ok := conn.(interface{ CustomParameters() map[string]string })
+	if !ok {
+		return errBadStreamToken
+	}
+	if !twilioauth.VerifyStreamToken(s.signatures.AuthToken, callSID, c.CustomParameters()[paramStreamToken], time.Now()) {
+		return errBadStreamToken
+	}
+	return nil
+}
+
+// handleConnections processes incoming Media Streams connections, each on
+// a goroutine of its own up to the stream budget.
+func (s *Server) handleConnections(ctx context.Context, connCh <-chan transport.Connection) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case conn := <-connCh:
+			s.concurrency.Serve(conn, func(conn transport.Connection) { s.handleSession(ctx, conn) })
+		}
+	}
+}
+
+// handleSession manages a single voice session with full STT → Agent → TTS flow.
+// It runs its own loop rather than kit/session's, whose Options have no
+// hooks inside a turn for the interceptors, payment redaction, live tap,
+// supervisor takeover and termination rules that act there.
+func (s *Server) handleSession(ctx context.Context, conn transport.Connection) {
+	sessionID := conn.ID()
+	callSID := callSIDOf(conn)
+
+	// Context passed in by Twilio or an upstream PBX
+	metadata := metadataOf(conn, callSID, s.metadata)
+	if metadata.From == "" && metadata.To == "" {
+		// The webhook may have been served by another instance
+		if shared, ok := s.sharedMetadata(ctx, callSID); ok {
+			metadata = shared
+		}
+	}
+	metadata.normalizeNumbers(s.dialPlan)
+
+	// Every record for the session carries its session ID, call SID and caller
+	logger, closeLog := s.sessionLogger(sessionID, metadata)
+	defer closeLog()
+
+	info := SessionInfo{ID: sessionID, CallSID: callSID, From: metadata.From, To: metadata.To, Mode: modeAgent}
+	if metadata.Custom[paramMode] == modeCoach {
+		// Coaching streams belong to a call already in progress, so they are
+		// accepted while draining and don't count toward the call limit
+		coachCtx, cancelCoach := context.WithCancel(ctx)
+		defer cancelCoach()
+		info.Mode = modeCoach
+		s.sessions.Track(info, cancelCoach)
+		defer s.sessions.Done(sessionID)
+		s.handleCoachingSession(coachCtx, conn, callSID, logger.With("mode", modeCoach))
+		return
+	}
+
+	// Coaching streams above are only accepted for calls this server
+	// registered; agent streams must come from this server's TwiML
+	if err := s.verifyStream(conn, callSID); err != nil {
+		logger.Warn("rejecting session", "reason", err)
+		_ = conn.Close()
+		return
+	}
+
+	// Refuse new calls while draining, or over the call limit if the call
+	// skipped the webhook's check
+	shutdownCh := make(chan struct{})
+	var shutdownOnce sync.Once
+	if err := s.sessions.Admit(info, func() { shutdownOnce.Do(func() { close(shutdownCh) }) }); err != nil {
+		logger.Warn("rejecting session", "reason", err)
+		if err == errAtCapacity {
+			if err := s.twilio.RedirectCall(ctx, callSID, hangupTwiML(s.callTwiML.say(s.sessions.limits.BusyMessage))); err != nil {
+				logger.Error("failed to hang up rejected call", "error", err)
+			}
+		}
+		_ = conn.Close()
+		return
+	}
+	defer s.sessions.Done(sessionID)
+	logger.Info("session started")
+
+	// Lifecycle events, for the subsystems following the call
+	newEvent := func() Event { return Event{SessionID: sessionID, CallSID: callSID, At: time.Now()} }
+	providerFailed := func(stage string, err error) {
+		s.events.Publish(ProviderError{Event: newEvent(), Stage: stage, Error: err.Error()})
 	}
 
-	deepgramAPIKey := os.Getenv("DEEPGRAM_API_KEY")
-	if deepgramAPIKey == "" {
-		log.Fatal("DEEPGRAM_API_KEY environment variable required")
-	}
+	// The call's background tasks are bounded, and are waited for once
+	// the session is cancelled, so none outlives it
+	tasks := s.concurrency.sessionTasks(logger)
+	defer tasks.Wait()
+
+	sessionCtx, cancelSession := context.WithCancel(ctx)
+	defer cancelSession()
+
+	// One trace per call: provider calls made for the session are children
+	sessionCtx, sessionSpan := tracer.Start(sessionCtx, "voice.session", trace.WithAttributes(
+		attribute.String("voice.session.id", sessionID),
+		attribute.String("twilio.call_sid", callSID),
+	))
+	defer sessionSpan.End()
+
+	// The number called picks the agent, voice and language
+	tenant := s.tenantFor(metadata.To)
+	if tenant.name != "" {
+		logger = logger.With("tenant", tenant.name)
+	}
+
+	// Calls in an experiment get their variant of the agent, and are
+	// tagged with it
+	assignment := s.experiments.Assign(metadata.To, metadata.From, callSID)
+	if assignment != nil {
+		tenant = assignment.tenant
+		logger = logger.With("experiment", assignment.Experiment, "variant", assignment.Variant)
+	}
+
+	// Provider usage made for the call is charged to it
+	cost := newCallCost()
+	sessionCtx = withCallCost(sessionCtx, cost)
+
+	cdr := newCallDetailRecord(sessionID, s.residency)
+	cdr.Tenant = tenant.name
+	if assignment != nil {
+		cdr.Experiment, cdr.Variant = assignment.Experiment, assignment.Variant
+	}
+	cdr.AccountID, cdr.TicketID = metadata.AccountID, metadata.TicketID
+	if metadata.AccountID != "" || metadata.TicketID != "" {
+		logger.Info("call metadata", "account_id", metadata.AccountID, "ticket_id", metadata.TicketID)
+	}
+
+	// Split the conversation into topics for the CDR
+	segmenter := newTopicSegmenter(s.topics)
+
+	// Features the call uses, for telemetry
+	usage := &callUsage{}
+
+	// The caller's customer profile, if the CRM knows them, and the
+	// campaign the call was placed for brief the agent before it greets
+	var briefs []string
+	if profile := s.callers.Lookup(sessionCtx, metadata.From, logger); profile != nil {
+		briefs = append(briefs, profile.Brief())
+		if cdr.AccountID == "" {
+			cdr.AccountID = profile.AccountID
+		}
+		usage.Add("crm_match")
+	}
+	if brief := campaignBrief(metadata); brief != "" {
+		briefs = append(briefs, brief)
+		cdr.Campaign = metadata.Custom[paramCampaign]
+		usage.Add("campaign_call")
+	}
+	if s.booking != nil {
+		briefs = append(briefs, s.booking.Brief(time.Now()))
+	}
+	if b, ok := tenant.agent.(agent.Briefer); ok && len(briefs) > 0 {
+		b.Brief(sessionID, strings.Join(briefs, "\n\n"))
+	}
+	s.sms.Begin(sessionID, metadata.From)
+	s.email.Begin(sessionID)
+	s.booking.Begin(sessionID, metadata.From)
+
+	// Call control (hangup, redirect, recording) for agent logic
+	call := newCallSession(sessionID, callSID, s.twilio, cdr, logger)
+	call.Metadata = metadata
+
+	// Transcript and conversation shared with other instances
+	state := newCallStateWriter(s.state.Store, callSID, logger)
+	call.dial = s.dial
+	if host := s.host(); host != "" && s.archive != nil {
+		call.recordingStatusURL = fmt.Sprintf("https://%s/recordings/status", host)
+	}
+
+	// Negotiate formats with the providers for this connection's codec
+	codec := codecOf(conn, s.transportCodec)
+	sessionSpan.SetAttributes(attribute.String("voice.codec", string(codec)))
+	if codec != audio.CodecMulaw {
+		logger.Info("transport codec", "codec", codec)
+	}
+
+	// Live transcript, audio and operator controls for the admin API
+	live := newLiveCall(codec)
+	defer live.End()
+
+	// A stream that stops sending audio has dropped
+	keepalive := newKeepaliveConnection(conn)
+	// Card details keyed in for a payment are kept out of STT and
+	// supervisors' audio
+	secured := newSecureAudio(keepalive, codec)
+	media := live.Tap(secured)
+
+	// Record outbound audio as it is played so its echo can be recognised
+	wire := media
+	var echo *echoGuard
+	if s.echoGuard.Enabled {
+		echo = newEchoGuard(s.echoGuard, codec)
+		wire = echo.Tap(wire)
+	}
+
+	// Time each turn from speech end to the first frame of the reply
+	latency := newLatencyTracker(sessionCtx, logger, s.latency)
+	latency.slo = s.slo
+	latency.degradation = s.degradation
+	if assignment != nil {
+		latency.variant = assignment.stats.latency
+		usage.Add("experiment")
+	}
+	wire = latency.TapWire(wire)
+
+	// Release outbound audio at real time so barge-in truncates precisely;
+	// native G.711 where the provider supports it, otherwise PCM transcoded locally
+	outputFormat, outputRate, transcode := ttsFormat(codec, s.ttsPCMRate)
+	buildOutbound := func(wire transport.Connection) (transport.Connection, *pacedConnection, error) {
+		return s.newOutbound(wire, codec, outputRate, transcode)
+	}
+	outbound, paced, err := buildOutbound(wire)
+	if err != nil {
+		logger.Error("failed to create transcoder", "error", err)
+		_ = conn.Close()
+		return
+	}
+	defer paced.Stop()
+	// Twilio echoes marks once the caller has heard the audio before them
+	paced.far, _ = conn.(playbackMarker)
+	if transcode {
+		usage.Add("transcode")
+	}
+	// Ambience and comfort noise on their own go out below the echo guard
+	// and latency taps, which would take them for the agent speaking
+	mixer := newOutboundMixer(codec, media.AudioIn())
+	switch {
+	case s.ambience != nil:
+		mixer.playAmbience(s.ambience)
+		usage.Add("ambience")
+	case s.comfortNoise != nil:
+		mixer.playAmbience(s.comfortNoise)
+		usage.Add("comfort_noise")
+	}
+	if tenant.doubleTalk.policy == session.DoubleTalkDuck {
+		mixer.duckVoice(tenant.doubleTalk.duck)
+	}
+	if mixer.active() {
+		paced.Mix(mixer)
+	}
+	outbound = latency.TapTTS(outbound)
+
+	// Transports bridging several legs can whisper to just one of them
+	var legs *legOutputs
+	if multi, ok := conn.(multiLegConnection); ok {
+		legs = newLegOutputs(multi, buildOutbound)
+		defer legs.Stop()
+		usage.Add("multi_leg")
+	}
+
+	// Inbound audio likewise goes to STT natively or decoded to PCM
+	inbound := media
+	sttEncoding, sttRate, decode := sttFormat(codec)
+	if decode {
+		inbound = newDecodingConnection(media, codec, s.concurrency.transcode)
+	}
+	if echo != nil {
+		inbound = echo.Gate(inbound, sttEncoding)
+	}
+
+	// Create TTS pipeline configured for telephony
+	// Switched voices are set per utterance, keeping the pipeline's
+	ttsProvider := &switchedTTS{StreamingProvider: s.ttsCache.Wrap(&meteredTTS{StreamingProvider: newFailoverTTS(s.ttsProvider, s.resilience, logger), cost: cost, key: usageKey(s.ttsProvider.Name(), tenant.tts.Model)})}
+	ttsPipeline := pipeline.NewTTSPipeline(ttsProvider, pipeline.TTSPipelineConfig{
+		VoiceID:      tenant.tts.VoiceID,
+		OutputFormat: outputFormat,
+		SampleRate:   outputRate,
+		Model:        tenant.tts.Model,
+		OnError: func(err error) {
+			logger.Error("TTS error", "error", err)
+		},
+		OnComplete: func() {
+			logger.Debug("TTS complete")
+		},
+	})
+
+	// Captions are kept for the call archive
+	var captions *captionTrack
+	if s.archive != nil {
+		captions = &captionTrack{}
+	}
+
+	// Everything the agent says goes through the speech queue
+	speech := newSpeechQueue(withLexicon(sessionCtx, tenant.lexicon), ttsPipeline, outbound, logger, s.dedupThreshold)
+	speech.prompt = s.prompts.player(tenant.tts, tenant.lexicon, outputFormat, outputRate, s.resampleQuality, logger)
+	if legs != nil {
+		speech.legs = legs.Connection
+	}
+	speech.playback = paced
+
+	// The agent's voice can change mid-call, e.g. as specialists take over
+	switchVoice := func(voiceID, reason string) {
+		if voiceID == tenant.tts.VoiceID {
+			voiceID = ""
+		}
+		if speech.SetVoice(voiceID) {
+			logger.Info("voice switched", "voice", firstNonEmpty(voiceID, tenant.tts.VoiceID), "reason", reason)
+			usage.Add("voice_switch")
+		}
+	}
+
+	// Card payments are keyed in with the caller's audio secured: kept out
+	// of STT and monitoring, and paused in the recording
+	keys := newKeypad()
+	var pausedRecording bool
+	s.payments.Begin(sessionID, paymentSession{
+		keypad: keys,
+		say:    speech.Say,
+		secure: func(ctx context.Context, on bool) error {
+			secured.Set(on)
+			if on {
+				paused, err := call.PauseRecording(ctx)
+				pausedRecording = paused
+				return err
+			}
+			if !pausedRecording {
+				return nil
+			}
+			pausedRecording = false
+			return call.ResumeRecording(ctx)
+		},
+		logger: logger,
+	})
+
+	// Track pending transcript for forming complete utterances
+	var pendingTranscript strings.Builder
+	var transcriptMu sync.Mutex
 
-	twilioAccountSID := os.Getenv("TWILIO_ACCOUNT_SID")
-	twilioAuthToken := os.Getenv("TWILIO_AUTH_TOKEN")
-	if twilioAccountSID == "" || twilioAuthToken == "" {
-		log.Fatal("TWILIO_ACCOUNT_SID and TWILIO_AUTH_TOKEN environment variables required")
+	// While a supervisor has taken over, the caller is transcribed but the
+	// agent doesn't answer. Guarded by transcriptMu.
+	var takenOver bool
+
+	// midUtterance is set while the caller is speaking and not yet
+	// transcribed in full, so a dropped STT stream may have lost their
+	// words. Guarded by transcriptMu.
+	var midUtterance bool
+
+	// hangUp waits for everything queued to finish playing and hangs up,
+	// recording who ended the call. Where the transport reports playback,
+	// the caller has heard the last of it by then, not just been sent it.
+	// The session (and its CDR) ends once the call is gone; only the first
+	// hangUp counts.
+	var hangingUp atomic.Bool
+	hangUp := func(endedBy string) {
+		if !hangingUp.CompareAndSwap(false, true) {
+			return
+		}
+		started := tasks.Control("hangup", func() {
+			if speech.Wait(sessionCtx) != nil || paced.WaitPlayed(sessionCtx, "hangup") != nil {
+				return
+			}
+			if err := call.EndCall(sessionCtx); err != nil {
+				logger.Error("failed to end call", "error", err)
+			} else {
+				call.setEndedBy(endedBy)
+			}
+			cancelSession()
+		})
+		if !started {
+			// With no room to wait for playback, the stream is closed,
+			// which ends the call too
+			cancelSession()
+		}
 	}
 
-	// Create ElevenLabs TTS provider
-	elevenClient, err := elevenlabs.NewClient(elevenlabs.WithAPIKey(elevenLabsAPIKey))
-	if err != nil {
-		log.Fatalf("Failed to create ElevenLabs client: %v", err)
+	// endCall speaks the closing line and, unless disabled, hangs up.
+	var confirmingGoodbye bool
+	endCall := func() {
+		usage.Add("goodbye")
+		speech.Say(s.termination.ClosingLine)
+		if s.termination.Hangup {
+			hangUp("agent")
+		}
 	}
-	ttsProvider := elevenvoice.NewWithClient(elevenClient)
 
-	// Create Deepgram STT provider
-	sttProvider, err := deepgramstt.New(deepgramstt.WithAPIKey(deepgramAPIKey))
-	if err != nil {
-		log.Fatalf("Failed to create Deepgram provider: %v", err)
+	// transferCall speaks the hand-off line, waits for it to play, and dials
+	// a human. With consent, the caller's audio keeps streaming to a
+	// listen-only coaching session that prompts the human. It reports
+	// whether the transfer was started; if not, the caller is told.
+	var confirmingTransfer bool
+	var transferNumber phone.Number
+	var handleAction func(agent.Action)
+	transferCall := func(coached bool) bool {
+		number := transferNumber
+		started := tasks.Control("transfer", func() {
+			// Tell the caller now if the number may not be dialed
+			if call.CheckDial(sessionCtx, number, "transfer") != nil {
+				speech.Say(s.transfer.RefusedLine)
+				return
+			}
+			speech.Say(s.transfer.HandoffLine)
+			if speech.Wait(sessionCtx) != nil || paced.WaitPlayed(sessionCtx, "transfer") != nil {
+				return
+			}
+			var coachStreamURL string
+			if coached {
+				token, err := s.coaching.Register(callSID)
+				if err != nil {
+					logger.Error("failed to register coaching", "error", err)
+				} else if host := s.host(); host != "" {
+					coachStreamURL = fmt.Sprintf("wss://%s/media-stream", host)
+					logger.Info("coaching console", "url", fmt.Sprintf("https://%s/coach/?call=%s&token=%s", host, callSID, token))
+				}
+			}
+			if err := call.Transfer(sessionCtx, number, coachStreamURL); err != nil {
+				logger.Error("failed to transfer call", "error", err)
+				return
+			}
+			cancelSession()
+		})
+		if !started {
+			speech.Say(s.transfer.RefusedLine)
+		}
+		return started
 	}
 
-	// Create Twilio Media Streams transport
-	twilioTransport, err := twiliotransport.New(
-		twiliotransport.WithAccountSID(twilioAccountSID),
-		twiliotransport.WithAuthToken(twilioAuthToken),
-	)
-	if err != nil {
-		log.Fatalf("Failed to create Twilio transport: %v", err)
+	// requestTransfer transfers to number, asking first whether coaching
+	// may listen in. Callers must hold transcriptMu.
+	requestTransfer := func(number phone.Number) {
+		transferNumber = number
+		if s.transfer.Coaching {
+			confirmingTransfer = true
+			speech.Say(s.transfer.ConsentPrompt)
+		} else {
+			transferCall(false)
+		}
 	}
-	defer func() { _ = twilioTransport.Close() }()
 
-	// Handle shutdown
-	sigCh := make(chan os.Signal, 1)
-	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
-	go func() {
-		<-sigCh
-		cancel()
-	}()
+	// handleAction carries out a call action requested by the agent.
+	handleAction = func(action agent.Action) {
+		switch action.Kind {
+		case agent.ActionHangup:
+			hangUp("agent")
+		case agent.ActionPlay:
+			speech.Play(action.Target)
+		case agent.ActionTransfer:
+			number := s.transfer.Number
+			if action.Target != "" {
+				target, err := s.dialPlan.Parse(action.Target)
+				if err != nil {
+					logger.Warn("agent requested transfer to an invalid number", "target", action.Target, "error", err)
+					speech.Say(s.transfer.RefusedLine)
+					return
+				}
+				number = target
+			}
+			if number.IsZero() {
+				logger.Warn("agent requested transfer but TRANSFER_NUMBER is not set")
+				return
+			}
+			transcriptMu.Lock()
+			defer transcriptMu.Unlock()
+			requestTransfer(number)
+		default:
+			logger.Warn("unknown agent action", "action", action.Kind)
+		}
+	}
 
-	// Create server with providers
-	server := &Server{
-		ttsProvider:     ttsProvider,
-		sttProvider:     sttProvider,
-		twilioTransport: twilioTransport,
+	// takeMessage hands the call to Twilio to record a message, at the
+	// bottom of the degradation ladder.
+	takeMessage := func() {
+		tasks.Control("voicemail", func() {
+			var actionURL string
+			if host := s.host(); host != "" {
+				actionURL = fmt.Sprintf("https://%s/voice/voicemail", host)
+			}
+			if err := s.twilio.RedirectCall(sessionCtx, callSID, s.degradation.voicemailTwiML(s.callTwiML, actionURL)); err != nil {
+				logger.Error("failed to hand call to voicemail", "error", err)
+				return
+			}
+			logger.Info("taking a message, service degraded")
+			call.setEndedBy("voicemail")
+			cancelSession()
+		})
 	}
 
-	// Start HTTP server
-	http.HandleFunc("/voice/inbound", server.handleInboundCall)
-	http.HandleFunc("/media-stream", server.handleMediaStream)
+	// runTurn asks the agent for a reply outside the STT callback, so a
+	// streaming agent never holds up transcription. Barge-in cancels it.
+	// If speaking the reply fails, the turn is retried: the agent replays
+	// tool results rather than re-running tools, sentences already heard
+	// are not repeated, and actions are taken once per turn.
+	var turnMu sync.Mutex
+	var turnSeq, actedTurn, answering int
+	// thinking counts turns still being answered, while the caller is
+	// waiting on the agent rather than the other way round
+	var thinking atomic.Int32
+	var acted map[agent.ActionKind]bool
+	cancelTurn := context.CancelFunc(func() {})
+	stopTurn := func() {
+		turnMu.Lock()
+		defer turnMu.Unlock()
+		turnSeq++
+		cancelTurn()
+	}
+	firstAction := func(index int, kind agent.ActionKind) bool {
+		turnMu.Lock()
+		defer turnMu.Unlock()
+		if actedTurn != index {
+			actedTurn, acted = index, make(map[agent.ActionKind]bool)
+		}
+		if acted[kind] {
+			return false
+		}
+		acted[kind] = true
+		return true
+	}
+	turnMetadata := metadata.streamParameters()
 
-	addr := ":8080"
-	log.Printf("Starting voice agent server on %s", addr)
+	// askToRepeat apologizes for a turn that couldn't be answered, unless a
+	// newer turn or barge-in has superseded it.
+	askToRepeat := func(ctx context.Context) {
+		if ctx.Err() != nil || s.resilience.RepeatPrompt == "" {
+			return
+		}
+		usage.Add("repeat_prompt")
+		speech.Say(s.resilience.RepeatPrompt)
+	}
+	// Caller transcripts and agent replies pass through the interceptors
+	interceptors := s.interceptorChain(call, logger)
 
-	httpServer := &http.Server{
-		Addr:              addr,
-		ReadHeaderTimeout: 10 * time.Second,
+	// interceptReply passes a piece of the agent's reply through the
+	// interceptors before it is spoken. Once a piece is blocked, what is
+	// said instead is spoken and the rest of the turn's reply is withheld.
+	interceptReply := func(ctx context.Context, index int, reply string, withheld bool) (string, bool) {
+		if withheld {
+			return "", true
+		}
+		u := interceptors.Intercept(ctx, Utterance{SessionID: sessionID, Turn: index, Speaker: speakerAgent, Text: reply})
+		if u.Blocked {
+			return u.Instead, true
+		}
+		return u.Text, false
 	}
 
-	// Start listening for Media Streams connections
-	connCh, err := twilioTransport.Listen(ctx, "/media-stream")
-	if err != nil {
-		log.Fatalf("Failed to start Media Streams listener: %v", err)
+	// escalate interrupts the call for an emergency the caller spoke of:
+	// the reply in progress is cut off, the emergency message said, the
+	// call transferred if a number is set, and an alert published saying
+	// where to, if the transfer started. A supervisor who has taken the
+	// call over is alerted but speaks for themselves. Callers must hold
+	// transcriptMu.
+	escalate := func(turn int, text string, found moderation.Result) {
+		logger.Warn("emergency detected", "turn", turn, "categories", found.Categories, "phrases", found.Terms)
+		usage.Add("emergency")
+		call.emergency(turn, found)
+		alert := EmergencyDetected{Event: newEvent(), From: metadata.From, To: metadata.To, Turn: turn, Categories: found.Categories, Phrases: found.Terms, Text: text}
+		if takenOver {
+			s.events.Publish(alert)
+			return
+		}
+		stopTurn()
+		speech.Clear()
+		if ttsPipeline.IsActive() {
+			ttsPipeline.Stop()
+		}
+		paced.Clear()
+		captions.AgentCut()
+		confirmingTransfer, confirmingGoodbye = false, false
+		speech.Say(s.emergency.Message)
+		if !s.emergency.Number.IsZero() {
+			transferNumber = s.emergency.Number
+			if transferCall(false) {
+				alert.TransferredTo = s.emergency.Number.String()
+			} else {
+				logger.Error("emergency transfer not started", "turn", turn)
+			}
+		}
+		s.events.Publish(alert)
 	}
+	var runTurn func(index int, text string, attempt int)
+	runTurn = func(index int, text string, attempt int) {
+		// The degradation ladder's level decides how the turn is answered
+		level := s.degradation.Level()
+		if level != LevelFull {
+			call.degraded(level)
+			usage.Add("degraded:" + level.String())
+		}
+		if level == LevelVoicemail {
+			takeMessage()
+			return
+		}
 
-	// Handle incoming connections
-	go server.handleConnections(ctx, connCh)
+		turnMu.Lock()
+		cancelTurn()
+		turnSeq++
+		seq := turnSeq
+		answering = index
+		turnCtx, cancel := context.WithCancel(withTurn(latency.TurnContext(), index))
+		cancelTurn = cancel
+		turnMu.Unlock()
 
-	go func() {
-		if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
-			log.Fatalf("Server error: %v", err)
+		// Retry once per attempt, unless a newer turn or barge-in has
+		// superseded this one since
+		var retryOnce sync.Once
+		onSpeechError := func(err error) {
+			retryOnce.Do(func() {
+				turnMu.Lock()
+				current := seq == turnSeq
+				turnMu.Unlock()
+				if !current {
+					return
+				}
+				if attempt >= maxTurnRetries {
+					logger.Error("speech failed, giving up on turn", "turn", index, "error", err)
+					providerFailed(stageTTS, err)
+					speech.Clear()
+					askToRepeat(turnCtx)
+					return
+				}
+				logger.Warn("speech failed, retrying turn", "turn", index, "attempt", attempt+1, "error", err)
+				speech.Clear()
+				runTurn(index, text, attempt+1)
+			})
 		}
-	}()
-
-	<-ctx.Done()
-	log.Println("Shutting down...")
-	_ = httpServer.Close()
-}
 
-// Server handles voice agent connections.
-type Server struct {
-	ttsProvider     *elevenvoice.Provider
-	sttProvider     *deepgramstt.Provider
-	twilioTransport *twiliotransport.Provider
-}
+		thinking.Add(1)
+		if !tasks.Go("turn", func() {
+			defer thinking.Add(-1)
+			defer cancel()
+			// A turn answered in full is published as it finishes
+			var answeredBy string
+			var replies []string
+			defer func() {
+				if answeredBy != "" && turnCtx.Err() == nil {
+					s.events.Publish(TurnCompleted{Event: newEvent(), Turn: index, Text: text, Reply: strings.Join(replies, " "), AnsweredBy: answeredBy})
+				}
+			}()
 
-// handleInboundCall returns TwiML to connect the call to Media Streams.
-func (s *Server) handleInboundCall(w http.ResponseWriter, r *http.Request) {
-	from := r.FormValue("From")
-	to := r.FormValue("To")
-	callSID := r.FormValue("CallSid")
+			// At the faq level the agent isn't consulted
+			if level == LevelFAQ {
+				latency.MarkAgentFirstToken()
+				answer := s.degradation.Answer(text)
+				segmenter.Add(index, answer)
+				speech.SayContext(turnCtx, answer, onSpeechError)
+				answeredBy, replies = "faq", []string{answer}
+				return
+			}
 
-	log.Printf("Incoming call: %s -> %s (SID: %s)", from, to, callSID)
+			// Common intents get their canned reply without the agent
+			if name, reply, ok := s.intents.Match(turnCtx, text, logger); ok {
+				latency.MarkAgentFirstToken()
+				logger.Info("intent answered", "turn", index, "intent", name)
+				call.answeredIntent(name)
+				usage.Add("intent_answered")
+				if reply.Answer != "" {
+					replies = []string{reply.Answer}
+					segmenter.Add(index, reply.Answer)
+					speech.SayContext(turnCtx, reply.Answer, onSpeechError)
+					if r, ok := tenant.agent.(agent.Recorder); ok {
+						r.Answered(agent.Turn{SessionID: sessionID, Index: index, Text: text, Attempt: attempt}, reply.Answer)
+						state.History(tenant.agent, sessionID)
+					}
+				}
+				if reply.Action != "" && firstAction(index, reply.Action) {
+					handleAction(agent.Action{Kind: reply.Action})
+				}
+				answeredBy = "intent"
+				return
+			}
 
-	// Return TwiML to connect to Media Streams
-	wsURL := fmt.Sprintf("wss://%s/media-stream", r.Host)
+			var brief int
+			if level == LevelBrief {
+				brief = s.degradation.BriefSentences()
+			}
+			written, spoken := text, ""
+			if s.itn {
+				written, spoken = denormalize(text, time.Now())
+			}
+			responses, err := tenant.agent.OnUserTurn(turnCtx, agent.Turn{
+				SessionID:    sessionID,
+				Index:        index,
+				Text:         written,
+				Spoken:       spoken,
+				Attempt:      attempt,
+				Metadata:     turnMetadata,
+				MaxSentences: brief,
+			})
+			if err != nil {
+				logger.Error("agent failed", "error", err)
+				providerFailed(stageAgent, err)
+				askToRepeat(turnCtx)
+				return
+			}
 
-	twiml := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
-<Response>
-    <Say>Connecting you to the voice assistant.</Say>
-    <Connect>
-        <Stream url="%s">
-            <Parameter name="callSid" value="%s"/>
-            <Parameter name="caller" value="%s"/>
-        </Stream>
-    </Connect>
-</Response>`, wsURL, callSID, from)
-
-	w.Header().Set("Content-Type", "application/xml")
-	if _, err := w.Write([]byte(twiml)); err != nil {
-		slog.Error("failed to write TwiML", "error", err)
+			first := attempt == 0
+			tone := newTone(s.emotions)
+			said := 0
+			spoke := false
+			withheld := false
+			failed := false
+			for r := range responses {
+				if first {
+					latency.MarkAgentFirstToken()
+					first = false
+				}
+				if r.Specialist != "" {
+					// Each specialist speaks in its own voice
+					switchVoice(s.teamVoices[r.Specialist], "specialist "+r.Specialist)
+				}
+				if r.Err != nil {
+					logger.Error("agent failed mid-reply", "error", r.Err)
+					if !spoke {
+						failed = true
+						providerFailed(stageAgent, r.Err)
+						askToRepeat(turnCtx)
+					}
+					continue
+				}
+				reply := r.Text
+				if brief > 0 && reply != "" {
+					// Replies that run long are cut off; actions still count
+					var n int
+					reply, n = truncateSentences(reply, brief-said)
+					said += n
+				}
+				if reply != "" && len(interceptors) > 0 {
+					reply, withheld = interceptReply(turnCtx, index, reply, withheld)
+				}
+				reply, spans := readTone(tone, reply)
+				if reply != "" {
+					spoke = true
+					replies = append(replies, reply)
+					segmenter.Add(index, reply)
+					// Traced as part of this turn, each stretch with its emotion
+					for _, span := range spans {
+						speech.SayContext(withEmotion(turnCtx, span.Emotion), span.Text, onSpeechError)
+					}
+				}
+				if r.Action != nil && r.Action.Kind == agent.ActionPlay {
+					// Prompts are spoken like the reply around them
+					spoke = true
+					speech.PlayContext(turnCtx, r.Action.Target, onSpeechError)
+				} else if r.Action != nil && firstAction(index, r.Action.Kind) {
+					handleAction(*r.Action)
+				}
+			}
+			state.History(tenant.agent, sessionID)
+			if !failed {
+				answeredBy = "agent"
+			}
+		}) {
+			// Over budget the turn goes unanswered
+			thinking.Add(-1)
+			askToRepeat(turnCtx)
+			cancel()
+		}
 	}
-}
 
-// handleMediaStream upgrades HTTP to WebSocket and handles Media Streams.
-func (s *Server) handleMediaStream(w http.ResponseWriter, r *http.Request) {
-	if err := s.twilioTransport.HandleWebSocket(w, r, "/media-stream"); err != nil {
-		slog.Error("WebSocket handling failed", "error", err)
+	// Operator controls; silence stops whatever the agent is saying
+	silence := func() {
+		if ttsPipeline.IsActive() {
+			ttsPipeline.Stop()
+		}
+		paced.Clear()
+		captions.AgentCut()
+		if legs != nil {
+			legs.Clear()
+		}
+	}
+	live.say = speech.InjectTo
+	live.setVoice = func(voiceID string) { switchVoice(voiceID, "admin") }
+	live.setMuted = func(muted bool) {
+		speech.SetMuted(muted)
+		if muted {
+			silence()
+		}
+	}
+	live.hangUp = func(ctx context.Context) {
+		if err := call.EndCall(ctx); err != nil {
+			// Closing the Media Stream ends the call too
+			logger.Warn("failed to end call, closing stream", "error", err)
+		}
+		call.setEndedBy("admin")
+		cancelSession()
+	}
+	live.takeOver = func(on bool) {
+		transcriptMu.Lock()
+		takenOver = on
+		transcriptMu.Unlock()
+		if on {
+			usage.Add("takeover")
+			stopTurn()
+			speech.Clear()
+			silence()
+		}
+	}
+	speech.spoken = func(text string, target Leg) {
+		live.AddTo(speakerAgent, text, target)
+		captions.AgentStarted(text, target)
 	}
-}
 
-// handleConnections processes incoming Media Streams connections.
-func (s *Server) handleConnections(ctx context.Context, connCh <-chan transport.Connection) {
-	for {
-		select {
-		case <-ctx.Done():
+	// What the caller heard in full of the latest reply, as reported by
+	// marks, so a barge-in can cut the agent's history down to it
+	var heardMu sync.Mutex
+	var heardTurn int
+	var heard []string
+	speech.played = func(text string, turn int) {
+		logger.Debug("utterance played", "turn", turn, "text", text)
+		captions.AgentPlayed(text)
+		if turn == 0 {
 			return
-		case conn := <-connCh:
-			go s.handleSession(ctx, conn)
 		}
+		heardMu.Lock()
+		defer heardMu.Unlock()
+		if turn != heardTurn {
+			heardTurn, heard = turn, nil
+		}
+		heard = append(heard, text)
 	}
-}
+	// interrupted tells the agent what the caller heard of the reply to
+	// turn before barging in, once the rest of it has been cleared
+	interrupted := func(turn int) {
+		interrupter, ok := tenant.agent.(agent.Interrupter)
+		if !ok || speech.WaitTurn(sessionCtx, turn) != nil {
+			return
+		}
+		heardMu.Lock()
+		var text string
+		if heardTurn == turn {
+			text = strings.Join(heard, " ")
+		}
+		heardMu.Unlock()
+		logger.Debug("reply cut off", "turn", turn, "heard", text)
+		interrupter.Interrupted(sessionID, turn, text)
+		state.History(tenant.agent, sessionID)
+	}
+	s.sessions.Attach(sessionID, live)
 
-// handleSession manages a single voice session with full STT → Agent → TTS flow.
-func (s *Server) handleSession(ctx context.Context, conn transport.Connection) {
-	sessionID := conn.ID()
-	log.Printf("New session: %s", sessionID)
+	// Pick up a call whose stream reconnected, to this instance or another,
+	// and share the rest of the call in case it happens again
+	resumedLines, history := s.resumeCall(sessionCtx, callSID, logger)
+	resumed := len(resumedLines) > 0
+	if resumed {
+		live.Restore(resumedLines)
+		for _, line := range resumedLines {
+			if line.Speaker == speakerCaller {
+				cdr.Turns++
+			}
+		}
+		if r, ok := tenant.agent.(agent.Resumer); ok {
+			r.Resume(sessionID, history)
+		}
+		logger.Info("resuming call", "lines", len(resumedLines), "turns", cdr.Turns)
+		usage.Add("resumed")
+	}
+	live.recorded = state.Line
 
-	sessionCtx, cancelSession := context.WithCancel(ctx)
-	defer cancelSession()
+	// Greet at once, or wait for the caller to speak first, per the policy
+	// of the number they called. Guarded by transcriptMu.
+	greetingPolicy := s.greeting.PolicyFor(metadata.To)
+	greeting := firstNonEmpty(tenant.greeting, s.greeting.Text)
+	if g, ok := tenant.agent.(agent.Greeter); ok && !resumed {
+		greeting = g.Greeting(sessionID)
+	}
+	greeted := greetingPolicy == GreetingImmediate || resumed
+	usage.Add("greeting:" + string(greetingPolicy))
+	var greetTimer *time.Timer
+	greet := func() {
+		greeted = true
+		speech.Say(greeting)
+	}
+	// waitForCaller runs the silence timeout while the caller is quiet and
+	// pauses it while they speak.
+	waitForCaller := func(quiet bool) {
+		if greetingPolicy != GreetingAfterSilence {
+			return
+		}
+		transcriptMu.Lock()
+		defer transcriptMu.Unlock()
+		switch {
+		case greeted:
+		case !quiet:
+			if greetTimer != nil {
+				greetTimer.Stop()
+			}
+		case greetTimer != nil:
+			greetTimer.Reset(s.greeting.SilenceTimeout)
+		default:
+			greetTimer = time.AfterFunc(s.greeting.SilenceTimeout, func() {
+				transcriptMu.Lock()
+				defer transcriptMu.Unlock()
+				if !greeted {
+					logger.Info("caller silent, greeting", "timeout", s.greeting.SilenceTimeout)
+					greet()
+				}
+			})
+		}
+	}
+	defer func() {
+		transcriptMu.Lock()
+		defer transcriptMu.Unlock()
+		if greetTimer != nil {
+			greetTimer.Stop()
+		}
+	}()
 
-	// Create TTS pipeline configured for telephony
-	ttsPipeline := pipeline.NewTTSPipeline(s.ttsProvider, pipeline.TTSPipelineConfig{
-		VoiceID:      "Rachel",
-		OutputFormat: "ulaw",
-		SampleRate:   8000,
-		Model:        "eleven_turbo_v2_5",
-		OnError: func(err error) {
-			slog.Error("TTS error", "error", err, "session", sessionID)
-		},
-		OnComplete: func() {
-			slog.Debug("TTS complete", "session", sessionID)
-		},
-	})
+	// How long the caller has been silent, for the silence policy
+	quiet := newSilenceWatch(s.silence)
 
-	// Track pending transcript for forming complete utterances
-	var pendingTranscript strings.Builder
-	var transcriptMu sync.Mutex
+	// How sure the recognizer was of each final transcript
+	scores := s.confidence.track()
+
+	// The caller's audio, kept to save turns that may have been misheard
+	snippets := s.snippets.track()
 
 	// Create STT pipeline configured for telephony
 	sttConfig := pipeline.STTPipelineConfig{
-		Model:      "nova-2",
-		Language:   "en-US",
-		Encoding:   "mulaw",
-		SampleRate: 8000,
+		Model:      tenant.stt.Model,
+		Language:   tenant.stt.Language,
+		Encoding:   sttEncoding,
+		SampleRate: sttRate,
 		Channels:   1,
 
 		OnTranscript: func(transcript string, isFinal bool) {
@@ -236,105 +2272,449 @@ func (s *Server) handleSession(ctx context.Context, conn transport.Connection) {
 			defer transcriptMu.Unlock()
 
 			if isFinal {
+				midUtterance = false
+				scored := scores.Heard(transcript)
 				// Append final transcript and process complete utterance
 				pendingTranscript.WriteString(transcript)
 				fullText := strings.TrimSpace(pendingTranscript.String())
 				pendingTranscript.Reset()
 
+				// Watched for emergencies as the caller said it, and
+				// escalated before the interceptors run, so moderation can't
+				// block or empty the turn first. The alert carries the turn
+				// redacted, as it is recorded, if transcripts are.
+				emergency := s.emergency.Detect(fullText)
+				if emergency.Flagged {
+					text := fullText
+					if s.redactInterim {
+						text = redactPII(sessionCtx, Utterance{Text: text}).Text
+					}
+					greeted = true
+					escalate(cdr.Turns+1, text, emergency)
+				}
+
+				// Intercepted before it is recorded or answered
+				var blocked bool
+				var instead string
+				if fullText != "" && len(interceptors) > 0 {
+					u := interceptors.Intercept(sessionCtx, Utterance{SessionID: sessionID, Turn: cdr.Turns + 1, Speaker: speakerCaller, Text: fullText})
+					fullText, blocked, instead = strings.TrimSpace(u.Text), u.Blocked, u.Instead
+				}
+				captions.Caller(fullText)
+
+				// The emergency's turn is counted even if nothing of it is
+				// left to record
+				if fullText == "" && emergency.Flagged {
+					cdr.Turns++
+				}
+
 				if fullText != "" {
-					log.Printf("[%s] User said: %s", sessionID, fullText)
+					quiet.Heard()
+					logger.Info("user said", "text", fullText, "turn", cdr.Turns+1)
+					live.Add(speakerCaller, fullText)
+					latency.MarkTranscript()
+					cdr.Turns++
+					segmenter.Add(cdr.Turns, fullText)
+					speech.NewTurn()
+
+					// Saved with what the recognizer heard, if it may have
+					// misheard
+					for _, sn := range snippets.Heard(cdr.Turns, transcript) {
+						key := s.archive.Snippet(callPrefix(callSID, sessionID, cdr.StartedAt), sn)
+						logger.Info("saved audio snippet", "turn", sn.Turn, "reason", sn.Reason, "key", key)
+						call.snippet(key)
+					}
 
-					// Process the transcript and generate response
-					// For this demo, we echo back what the user said
-					// In production, you would send this to an LLM (Claude, GPT, etc.)
-					response := processUserInput(fullText)
+					// An emergency has cut through whatever the call was
+					// doing
+					if emergency.Flagged {
+						return
+					}
+
+					// A supervisor has the call; they answer, not the agent
+					if takenOver {
+						return
+					}
+
+					// A caller who speaks first is answered with the greeting
+					if !greeted {
+						greet()
+						return
+					}
+
+					// A blocked turn isn't passed to the agent
+					if blocked {
+						if instead != "" {
+							speech.Say(instead)
+						}
+						return
+					}
+
+					// Nor is one the recognizer wasn't sure of; the caller
+					// is asked to say it again
+					if scores.Reprompt(scored) {
+						logger.Info("low confidence transcript, asking to repeat", "confidence", scored.confidence)
+						usage.Add("confidence_reprompt")
+						cdr.Reprompts++
+						speech.Say(s.confidence.policy.Reprompt)
+						return
+					}
+
+					// Transfer to a human, asking first whether coaching may listen in
+					if confirmingTransfer {
+						confirmingTransfer = false
+						transferCall(isAffirmative(fullText))
+						return
+					}
+					if s.transfer.Enabled() && s.transfer.WantsHuman(fullText) {
+						requestTransfer(s.transfer.Number)
+						return
+					}
 
-					// Send response to TTS pipeline
-					if err := ttsPipeline.SynthesizeToConnection(sessionCtx, response, conn); err != nil {
-						slog.Error("failed to synthesize response", "error", err, "session", sessionID)
+					// Goodbye handling: confirm if mid-task, otherwise close and hang up
+					if confirmingGoodbye {
+						confirmingGoodbye = false
+						if isAffirmative(fullText) {
+							endCall()
+							return
+						}
+					} else if s.termination.IsGoodbye(fullText) {
+						if t, ok := tenant.agent.(agent.Tasker); ok && t.MidTask(sessionID) {
+							confirmingGoodbye = true
+							speech.Say(s.termination.ConfirmPrompt)
+						} else {
+							endCall()
+						}
+						return
 					}
+
+					// Ask the agent for a reply; responses are spoken as they stream in
+					runTurn(cdr.Turns, fullText, 0)
 				}
 			} else {
 				// Accumulate interim results for context
-				slog.Debug("interim transcript", "text", transcript, "session", sessionID)
+				midUtterance = true
+				logger.Debug("interim transcript", "text", transcript)
+
+				// Interim captions don't go through the interceptors, but
+				// are redacted if transcripts are
+				if s.redactInterim {
+					transcript = redactPII(sessionCtx, Utterance{Text: transcript}).Text
+				}
+				live.Interim(speakerCaller, transcript)
 			}
 		},
 
 		OnSpeechStart: func() {
-			log.Printf("[%s] Speech started", sessionID)
-			// Optionally stop TTS when user starts speaking (barge-in)
-			if ttsPipeline.IsActive() {
-				ttsPipeline.Stop()
+			logger.Info("speech started")
+			latency.MarkSpeechStart()
+			waitForCaller(false)
+			quiet.Speaking(true)
+			transcriptMu.Lock()
+			midUtterance = true
+			transcriptMu.Unlock()
+
+			// The agent's double-talk policy decides whether the caller
+			// talking over it ducks its reply or cuts it off
+			if tenant.doubleTalk.policy == session.DoubleTalkDuck {
+				mixer.talkOver(true)
+				return
+			}
+
+			// A reply not yet heard in full is cut off
+			turnMu.Lock()
+			turn := answering
+			turnMu.Unlock()
+			if turn > 0 && speech.Unheard(turn) {
+				tasks.Go("interrupted", func() { interrupted(turn) })
+			}
+
+			// Stop TTS at once (barge-in), or at the end of the sentence
+			// playing
+			stopTurn()
+			var dropped time.Duration
+			at, queued := paced.PlayingUntil()
+			switch {
+			case tenant.doubleTalk.policy != session.DoubleTalkFinish:
+				speech.Clear()
+				if ttsPipeline.IsActive() {
+					ttsPipeline.Stop()
+				}
+				dropped = paced.Clear()
+				captions.AgentCut()
+			case queued:
+				// The sentence playing has all been queued; what follows
+				// it is dropped
+				speech.Clear()
+				if ttsPipeline.IsActive() {
+					ttsPipeline.Stop()
+				}
+				dropped = paced.CutAfter(at)
+			default:
+				// The sentence playing is still being synthesized
+				speech.Finish()
+			}
+			if dropped > 0 {
+				logger.Debug("barge-in discarded queued audio", "duration", dropped)
+				call.bargedIn()
+				usage.Add("barge_in")
+				s.events.Publish(BargeIn{Event: newEvent(), Turn: turn, DiscardedMs: dropped.Milliseconds()})
 			}
 		},
 
 		OnSpeechEnd: func() {
-			log.Printf("[%s] Speech ended", sessionID)
+			logger.Info("speech ended")
+			latency.MarkSpeechEnd()
+			waitForCaller(true)
+			quiet.Speaking(false)
+			mixer.talkOver(false)
 		},
 
 		OnError: func(err error) {
-			slog.Error("STT error", "error", err, "session", sessionID)
+			logger.Error("STT error", "error", err)
+			providerFailed(stageSTT, err)
 		},
 	}
 
-	sttPipeline := pipeline.NewSTTPipeline(s.sttProvider, sttConfig)
+	// A dropped STT stream is reopened; whatever the caller was saying as
+	// it dropped may be lost, so they're asked to say it again. If it can't
+	// be reopened, the caller is told and the call ends.
+	sttProvider := &resilientSTT{
+		StreamingProvider: snippets.stt(captions.stt(scores.stt(&meteredSTT{StreamingProvider: withSTTFormatting(s.sttProvider, tenant.stt), cost: cost, key: usageKey(s.sttProvider.Name(), tenant.stt.Model)}))),
+		policy:            s.resilience,
+		logger:            logger,
+		onReconnect: func() {
+			usage.Add("stt_reconnect")
+			transcriptMu.Lock()
+			defer transcriptMu.Unlock()
+			if !midUtterance {
+				return
+			}
+			midUtterance = false
+			pendingTranscript.Reset()
+			if greeted && !takenOver && s.resilience.RepeatPrompt != "" {
+				usage.Add("repeat_prompt")
+				speech.Say(s.resilience.RepeatPrompt)
+			}
+		},
+		onGiveUp: func(error) {
+			usage.Add("stt_lost")
+			stopTurn()
+			speech.Clear()
+			if s.resilience.LostLine != "" {
+				speech.Say(s.resilience.LostLine)
+			}
+			hangUp("error")
+		},
+	}
+	sttPipeline := pipeline.NewSTTPipeline(sttProvider, sttConfig)
 
 	// Start STT pipeline
-	if err := sttPipeline.StartFromConnection(sessionCtx, conn); err != nil {
-		slog.Error("failed to start STT pipeline", "error", err)
+	if err := sttPipeline.StartFromConnection(sessionCtx, inbound); err != nil {
+		logger.Error("failed to start STT pipeline", "error", err)
+		providerFailed(stageSTT, err)
 		_ = conn.Close()
 		return
 	}
+	s.events.Publish(SessionStarted{Event: newEvent(), From: metadata.From, To: metadata.To, Tenant: tenant.name, Resumed: resumed})
 
-	// Send initial greeting
-	greeting := "Hello! I'm your voice assistant powered by Deepgram and ElevenLabs. How can I help you today?"
-	if err := ttsPipeline.SynthesizeToConnection(sessionCtx, greeting, conn); err != nil {
-		slog.Error("failed to send greeting", "error", err, "session", sessionID)
+	// Send the greeting, letting agents that open the conversation choose it
+	switch {
+	case resumed:
+		// The caller was greeted by the earlier stream
+		speech.Say(s.state.ResumeLine)
+	case greetingPolicy == GreetingImmediate:
+		speech.Say(greeting)
+	default:
+		logger.Info("waiting for caller to speak first", "greeting_policy", greetingPolicy)
+		waitForCaller(true)
+	}
+
+	// A silent caller is asked whether they're still there, and then
+	// hung up on. The clock holds while the agent is answering or a
+	// supervisor has the call.
+	tasks.Clock(func() {
+		quiet.Run(sessionCtx,
+			func() bool {
+				transcriptMu.Lock()
+				supervised := takenOver
+				transcriptMu.Unlock()
+				return supervised || thinking.Load() > 0 || !speech.Idle() || !paced.Idle()
+			},
+			func() {
+				logger.Info("caller silent, prompting", "timeout", s.silence.PromptAfter)
+				usage.Add("silence_prompt")
+				speech.Say(s.silence.Prompt)
+			},
+			func() {
+				logger.Info("caller silent, ending call", "timeout", s.silence.HangupAfter)
+				usage.Add("silence_hangup")
+				stopTurn()
+				speech.Clear()
+				speech.Say(s.silence.ClosingLine)
+				hangUp("silence")
+			})
+	})
+
+	// At the maximum call duration, tell the caller the call has to end
+	// and hang up, whatever is going on
+	if s.silence.MaxDuration > 0 {
+		tasks.Clock(func() {
+			timer := time.NewTimer(time.Until(cdr.StartedAt.Add(s.silence.MaxDuration)))
+			defer timer.Stop()
+			select {
+			case <-sessionCtx.Done():
+				return
+			case <-timer.C:
+			}
+			logger.Info("ending call at maximum duration", "max_duration", s.silence.MaxDuration)
+			usage.Add("max_duration")
+			stopTurn()
+			speech.Clear()
+			speech.Say(s.silence.MaxDurationLine)
+			hangUp("max_duration")
+		})
+	}
+
+	// At the drain deadline, tell the caller the call has to end and hang up
+	tasks.Clock(func() {
+		select {
+		case <-sessionCtx.Done():
+			return
+		case <-shutdownCh:
+		}
+		logger.Info("ending call for shutdown")
+		stopTurn()
+		speech.Clear()
+		if s.drain.Message != "" {
+			speech.Say(s.drain.Message)
+		}
+		hangUp("shutdown")
+	})
+
+	// A stream that errors, or sends no audio for the keepalive timeout,
+	// has dropped while the call is up
+	var keepaliveTick <-chan time.Time
+	if s.state.KeepaliveTimeout > 0 {
+		ticker := time.NewTicker(s.state.KeepaliveTimeout / 5)
+		defer ticker.Stop()
+		keepaliveTick = ticker.C
 	}
 
-	// Keep session alive until context is cancelled or connection closes
-	select {
-	case <-sessionCtx.Done():
-	case event := <-conn.Events():
-		if event.Type == transport.EventDisconnected {
-			log.Printf("[%s] Connection closed", sessionID)
+	// Keep session alive until context is cancelled or connection closes,
+	// passing the keys the caller presses to the keypad
+wait:
+	for {
+		select {
+		case <-sessionCtx.Done():
+			break wait
+		case <-keepaliveTick:
+			if quiet := keepalive.Quiet(); quiet >= s.state.KeepaliveTimeout {
+				logger.Warn("stream dropped, no audio received", "quiet", quiet.Round(time.Millisecond))
+				call.setEndedBy(endedByDropped)
+				break wait
+			}
+		case event := <-conn.Events():
+			if event.Type == transport.EventDTMF {
+				keys.Press(event.Data)
+				continue
+			}
+			switch event.Type {
+			case transport.EventDisconnected:
+				logger.Info("connection closed")
+			case transport.EventError:
+				logger.Warn("stream dropped", "error", event.Error)
+				call.setEndedBy(endedByDropped)
+			}
+			break wait
 		}
 	}
 
-	// Cleanup
+	// Cleanup. A stream that closed without the call being ended here may
+	// reconnect, so its state is kept until it expires. One that dropped
+	// is also held here for the grace period.
+	stopTurn()
+	endedBy := call.endedBy()
+	state.Close(endedBy != "caller" && endedBy != endedByDropped)
+	if endedBy == endedByDropped {
+		transcript, _, _ := live.snapshot()
+		s.held.Hold(callSID, transcript, agentHistory(tenant.agent, sessionID))
+		usage.Add("stream_dropped")
+	}
+	if team, ok := tenant.agent.(*agent.Team); ok {
+		cdr.Specialists = team.Route(sessionID)
+		if len(cdr.Specialists) > 1 {
+			usage.Add("handoff")
+		}
+	}
+	if ender, ok := tenant.agent.(agent.SessionEnder); ok {
+		ender.EndSession(sessionID)
+	}
+	latency.End()
 	sttPipeline.Stop()
 	ttsPipeline.Stop()
 	_ = conn.Close()
-	log.Printf("Session ended: %s", sessionID)
-}
-
-// processUserInput processes user speech and returns a response.
-// In production, this would call an LLM like Claude or GPT.
-func processUserInput(input string) string {
-	input = strings.ToLower(input)
-
-	// Simple echo bot with a few canned responses
-	switch {
-	case strings.Contains(input, "hello") || strings.Contains(input, "hi"):
-		return "Hello! It's nice to hear from you. What would you like to talk about?"
-
-	case strings.Contains(input, "how are you"):
-		return "I'm doing great, thank you for asking! I'm here and ready to help you with anything you need."
-
-	case strings.Contains(input, "goodbye") || strings.Contains(input, "bye"):
-		return "Goodbye! It was nice talking with you. Have a wonderful day!"
-
-	case strings.Contains(input, "help"):
-		return "I can help you with various tasks. Just tell me what you need, and I'll do my best to assist you."
-
-	case strings.Contains(input, "weather"):
-		return "I don't have access to real-time weather data, but you could try asking a weather service for accurate forecasts."
-
-	case strings.Contains(input, "time"):
-		return fmt.Sprintf("The current time is %s.", time.Now().Format("3:04 PM"))
-
-	default:
-		// Echo back with acknowledgment
-		return fmt.Sprintf("I heard you say: %s. Is there anything specific you'd like me to help you with?", input)
+	if stats := paced.Stats(); stats.Congested() {
+		cdr.Outbound = &stats
+		logger.Warn("outbound audio backed up", "stalls", stats.Stalls, "stalled_ms", stats.StalledMs, "dropped_ms", stats.DroppedMs)
+		usage.Add("outbound_congested")
+	}
+	if tasks.refused.Load() > 0 {
+		usage.Add("session_tasks_refused")
+	}
+	if echo != nil && echo.Suppressed() > 0 {
+		logger.Info("echo guard suppressed inbound audio", "duration", echo.Suppressed().Round(time.Millisecond))
+		usage.Add("echo_guard")
+	}
+	cdr.Topics = segmenter.Segments()
+	cdr.Appointments = s.booking.End(sessionID)
+	if len(cdr.Appointments) > 0 {
+		usage.Add("appointment_booked")
+	}
+	cdr.Payments = s.payments.End(sessionID)
+	if len(cdr.Payments) > 0 {
+		usage.Add("payment_taken")
+	}
+	if s.sms != nil {
+		smsCtx, cancel := context.WithTimeout(context.Background(), smsSendTimeout)
+		cdr.SMSSent = s.sms.End(smsCtx, s.twilio, sessionID, logger)
+		cancel()
+		if cdr.SMSSent > 0 {
+			usage.Add("sms_sent")
+		}
+	}
+	if s.email != nil {
+		transcript, _, _ := live.snapshot()
+		summary := callSummary{cdr: cdr, caller: metadata.From, called: metadata.To, transcript: transcript}
+		emailCtx, cancel := context.WithTimeout(context.Background(), emailSendTimeout)
+		cdr.EmailsSent = s.email.End(emailCtx, sessionID, summary, logger)
+		cancel()
+		if cdr.EmailsSent > 0 {
+			usage.Add("email_sent")
+		}
+	}
+	if s.costs != nil {
+		summary := cost.Summary(s.pricing)
+		cdr.Cost = &summary
+		logger.Info("call cost",
+			"total", fmt.Sprintf("%.4f %s", summary.Total, summary.Currency),
+			"stt_minutes", fmt.Sprintf("%.2f", summary.STTMinutes),
+			"tts_characters", summary.TTSCharacters,
+			"llm_input_tokens", summary.LLMInputTokens,
+			"llm_output_tokens", summary.LLMOutputTokens)
+	}
+	cdr.emit(logger)
+	if assignment != nil {
+		assignment.stats.record(cdr)
+	}
+	s.recordUsage(conn, tenant, cdr, string(codec), usage)
+	transcript, _, _ := live.snapshot()
+	// Captions are timed against the call's recording, if it was recorded
+	zero := call.RecordingStarted()
+	if zero.IsZero() {
+		zero = cdr.StartedAt
 	}
+	captioned := captions.Captions(zero, time.Now())
+	s.events.Publish(SessionEnded{Event: newEvent(), From: metadata.From, To: metadata.To, EndedBy: endedBy, CDR: cdr, Transcript: transcript, Captions: captioned})
+	logger.Info("session ended")
 }
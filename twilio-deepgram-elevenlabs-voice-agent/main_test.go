@@ -0,0 +1,47 @@
+package main
+
+import (
+	"flag"
+	"testing"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/mock"
+	"github.com/agentplexus/omnivoice-examples/kit/twilioauth"
+	"github.com/agentplexus/omnivoice/transport"
+)
+
+// update rewrites golden files with what the tests produce instead of
+// comparing against them.
+var update = flag.Bool("update", false, "rewrite golden files")
+
+// bareConn is a connection that exposes no custom parameters.
+type bareConn struct{ transport.Connection }
+
+func (bareConn) ID() string { return "MZ1" }
+
+func TestVerifyStream(t *testing.T) {
+	s := &Server{signatures: &twilioauth.Validator{AuthToken: "secret"}}
+	expires := time.Now().Add(twilioauth.StreamTokenTTL)
+	token := twilioauth.StreamToken("secret", "CA1", expires)
+
+	tests := []struct {
+		name string
+		conn transport.Connection
+		ok   bool
+	}{
+		{"token", mock.NewConn("MZ1", map[string]string{paramStreamToken: token}), true},
+		{"other call's token", mock.NewConn("MZ1", map[string]string{paramStreamToken: twilioauth.StreamToken("secret", "CA2", expires)}), false},
+		{"expired token", mock.NewConn("MZ1", map[string]string{paramStreamToken: twilioauth.StreamToken("secret", "CA1", time.Now().Add(-time.Second))}), false},
+		{"no token", mock.NewConn("MZ1", nil), false},
+		{"no parameters", bareConn{}, false},
+	}
+	for _, tt := range tests {
+		if err := s.verifyStream(tt.conn, "CA1"); (err == nil) != tt.ok {
+			t.Errorf("%s: verifyStream = %v", tt.name, err)
+		}
+	}
+
+	if err := (&Server{}).verifyStream(bareConn{}, "CA1"); err != nil {
+		t.Errorf("verifyStream without signatures = %v", err)
+	}
+}
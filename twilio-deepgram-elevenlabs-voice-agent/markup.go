@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/speech"
+	"github.com/agentplexus/omnivoice/tts"
+)
+
+// MarkupConfig is how the agent's text is marked up for each TTS
+// provider: numbers spelled out, pauses after questions, and phone
+// numbers and codes read back slowly.
+type MarkupConfig struct {
+	// Dialect forces a markup dialect on every provider; empty chooses
+	// each provider's own.
+	Dialect       speech.Dialect
+	QuestionPause time.Duration
+	GroupPause    time.Duration
+	// Prosody is how each emotion is spoken by providers that read SSML.
+	Prosody map[string]speech.Prosody
+	// Lexicon corrects how names are said, unless a call's tenant has
+	// pronunciations of its own (see withLexicon).
+	Lexicon *speech.Lexicon
+}
+
+// defaultMarkupConfig returns the configuration used unless overridden by
+// TTS_MARKUP, TTS_QUESTION_PAUSE and TTS_READBACK_PAUSE.
+func defaultMarkupConfig() MarkupConfig {
+	m := speech.NewMarkup("")
+	return MarkupConfig{QuestionPause: m.QuestionPause, GroupPause: m.GroupPause}
+}
+
+// markupConfigFromEnv applies environment overrides to the defaults.
+func markupConfigFromEnv() (MarkupConfig, error) {
+	c := defaultMarkupConfig()
+	if v := os.Getenv("TTS_MARKUP"); v != "" && v != "auto" {
+		d, err := speech.ParseDialect(v)
+		if err != nil {
+			return c, fmt.Errorf("invalid TTS_MARKUP: %w", err)
+		}
+		c.Dialect = d
+	}
+	for key, d := range map[string]*time.Duration{
+		"TTS_QUESTION_PAUSE": &c.QuestionPause,
+		"TTS_READBACK_PAUSE": &c.GroupPause,
+	} {
+		if v := os.Getenv(key); v != "" {
+			parsed, err := time.ParseDuration(v)
+			if err != nil || parsed < 0 {
+				return c, fmt.Errorf("invalid %s: %q", key, v)
+			}
+			*d = parsed
+		}
+	}
+	return c, nil
+}
+
+// wrap returns provider sent text marked up in its dialect.
+func (c MarkupConfig) wrap(provider tts.StreamingProvider) tts.StreamingProvider {
+	m := speech.NewMarkup(c.Dialect)
+	if m.Dialect == "" {
+		m.Dialect = speech.DialectFor(provider.Name())
+	}
+	m.QuestionPause, m.GroupPause, m.Lexicon = c.QuestionPause, c.GroupPause, c.Lexicon
+	return &markupTTS{StreamingProvider: provider, markup: m, prosody: c.Prosody}
+}
+
+// markupTTS marks up text before synthesizing it. Only the provider sees
+// the markup; transcripts, logs and cost keep the text as written. Text
+// streamed from a reader is passed through as it is, since marking it up
+// would mean reading it all first.
+type markupTTS struct {
+	tts.StreamingProvider
+	markup  speech.Markup
+	prosody map[string]speech.Prosody
+}
+
+func (p *markupTTS) Synthesize(ctx context.Context, text string, config tts.SynthesisConfig) (*tts.SynthesisResult, error) {
+	return p.StreamingProvider.Synthesize(ctx, p.build(ctx, text), config)
+}
+
+func (p *markupTTS) SynthesizeStream(ctx context.Context, text string, config tts.SynthesisConfig) (<-chan tts.StreamChunk, error) {
+	return p.StreamingProvider.SynthesizeStream(ctx, p.build(ctx, text), config)
+}
+
+// build marks up text, with the prosody of ctx's emotion and its
+// lexicon's pronunciations.
+func (p *markupTTS) build(ctx context.Context, text string) string {
+	m := p.markup
+	if lexicon := lexiconFrom(ctx); lexicon != nil {
+		m.Lexicon = lexicon
+	}
+	return m.BuildWith(text, p.prosody[emotionFrom(ctx)])
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/textproto"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/agentplexus/omnivoice/transport"
+
+	"github.com/agentplexus/omnivoice-examples/kit/phone"
+)
+
+// sipHeaderPrefix is how Twilio passes custom SIP headers (X-*) to the
+// voice webhook, e.g. SipHeader_X-Account-Id. The same prefix is used for
+// the Media Streams parameters that carry them to the session.
+const sipHeaderPrefix = "SipHeader_"
+
+// metadataTTL bounds how long webhook metadata waits for its Media Stream.
+const metadataTTL = 5 * time.Minute
+
+// Stream parameter names for the call's own details.
+const (
+	paramCallSID = "callSid"
+	paramCaller  = "caller"
+	paramCalled  = "called"
+
+	// paramStreamToken ties an agent stream to its call; it is checked by
+	// verifyStream and never becomes metadata.
+	paramStreamToken = "streamToken"
+)
+
+// errBadStreamToken rejects streams without their call's stream token.
+var errBadStreamToken = errors.New("missing or invalid stream token")
+
+// SessionMetadata is context passed into a call by Twilio or an upstream
+// PBX, such as the customer account or an open ticket, so the agent can use
+// it without a separate lookup.
+type SessionMetadata struct {
+	CallSID string
+	From    string
+	To      string
+
+	// AccountID comes from the X-Account-Id SIP header or an accountId
+	// parameter.
+	AccountID string
+	// TicketID comes from the X-Ticket-Id SIP header or a ticketId parameter.
+	TicketID string
+
+	// SIPHeaders holds every custom SIP header, keyed by canonical name.
+	SIPHeaders map[string]string
+	// Custom holds any other stream parameters.
+	Custom map[string]string
+}
+
+// metadataFromWebhook extracts metadata from the voice webhook's form,
+// adding the configured stream parameters extra.
+func metadataFromWebhook(form url.Values, extra map[string]string) SessionMetadata {
+	params := maps.Clone(extra)
+	if params == nil {
+		params = make(map[string]string)
+	}
+	params[paramCallSID] = form.Get("CallSid")
+	params[paramCaller] = form.Get("From")
+	params[paramCalled] = form.Get("To")
+	for key := range form {
+		if strings.HasPrefix(key, sipHeaderPrefix) {
+			params[key] = form.Get(key)
+		}
+	}
+	return metadataFromParameters(params)
+}
+
+// metadataFromParameters builds metadata from Media Streams custom
+// parameters, as produced by streamParameters.
+func metadataFromParameters(params map[string]string) SessionMetadata {
+	m := SessionMetadata{
+		SIPHeaders: make(map[string]string),
+		Custom:     make(map[string]string),
+	}
+	for key, value := range params {
+		switch {
+		case key == paramCallSID:
+			m.CallSID = value
+		case key == paramCaller:
+			m.From = value
+		case key == paramCalled:
+			m.To = value
+		case key == paramStreamToken:
+		case strings.HasPrefix(key, sipHeaderPrefix):
+			m.SIPHeaders[textproto.CanonicalMIMEHeaderKey(strings.TrimPrefix(key, sipHeaderPrefix))] = value
+		default:
+			m.Custom[key] = value
+		}
+	}
+
+	m.AccountID = firstNonEmpty(m.SIPHeaders["X-Account-Id"], m.Custom["accountId"])
+	m.TicketID = firstNonEmpty(m.SIPHeaders["X-Ticket-Id"], m.Custom["ticketId"])
+	return m
+}
+
+// normalizeNumbers reads From and To with plan, so they match configured
+// numbers however Twilio or the upstream PBX wrote them. Caller IDs that
+// aren't numbers, such as "anonymous", are left as they are.
+func (m *SessionMetadata) normalizeNumbers(plan phone.DialPlan) {
+	m.From = plan.Normalize(m.From)
+	m.To = plan.Normalize(m.To)
+}
+
+// dialPlanFromEnv returns the dial plan that configured numbers, transfer
+// targets and caller IDs are read with. DIAL_COUNTRY_CODE is the country
+// of national numbers, those written without a country code (default 1,
+// North America).
+func dialPlanFromEnv() phone.DialPlan {
+	return phone.PlanFor(firstNonEmpty(os.Getenv("DIAL_COUNTRY_CODE"), "1"))
+}
+
+// streamParameters returns the metadata as Media Streams parameters, so it
+// reaches the session even when the webhook was served by another instance.
+func (m SessionMetadata) streamParameters() map[string]string {
+	params := maps.Clone(m.Custom)
+	if params == nil {
+		params = make(map[string]string)
+	}
+	for name, value := range m.SIPHeaders {
+		params[sipHeaderPrefix+name] = value
+	}
+	params[paramCallSID] = m.CallSID
+	params[paramCaller] = m.From
+	params[paramCalled] = m.To
+	return params
+}
+
+// validateStreamParameters rejects configured stream parameters that
+// would pass for the call's own details, its stream token or a SIP header.
+func validateStreamParameters(params map[string]string) error {
+	for name := range params {
+		switch {
+		case name == paramCallSID, name == paramCaller, name == paramCalled,
+			name == paramStreamToken, name == paramMode:
+			return fmt.Errorf("parameter name %q is reserved", name)
+		case strings.HasPrefix(name, sipHeaderPrefix):
+			return fmt.Errorf("parameter name %q is reserved for SIP headers", name)
+		}
+	}
+	return nil
+}
+
+// metadataOf returns a session's metadata. The stream's custom parameters,
+// which mediastream connections carry, are preferred; otherwise the metadata
+// captured by this instance's voice webhook is used.
+func metadataOf(conn transport.Connection, callSID string, store *metadataStore) SessionMetadata {
+	if c, ok := conn.(interface{ CustomParameters() map[string]string }); ok {
+		if params := c.CustomParameters(); len(params) > 0 {
+			store.Take(callSID)
+			return metadataFromParameters(params)
+		}
+	}
+	if m, ok := store.Take(callSID); ok {
+		return m
+	}
+	return metadataFromParameters(map[string]string{paramCallSID: callSID})
+}
+
+// metadataStore holds webhook metadata until the call's Media Stream
+// connects.
+type metadataStore struct {
+	mu      sync.Mutex
+	entries map[string]storedMetadata
+}
+
+type storedMetadata struct {
+	metadata SessionMetadata
+	stored   time.Time
+}
+
+func newMetadataStore() *metadataStore {
+	return &metadataStore{entries: make(map[string]storedMetadata)}
+}
+
+// Put stores metadata for a call, discarding entries whose stream never
+// connected.
+func (s *metadataStore) Put(m SessionMetadata) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	for sid, e := range s.entries {
+		if now.Sub(e.stored) > metadataTTL {
+			delete(s.entries, sid)
+		}
+	}
+	s.entries[m.CallSID] = storedMetadata{metadata: m, stored: now}
+}
+
+// Take removes and returns the metadata for a call.
+func (s *metadataStore) Take(callSID string) (SessionMetadata, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[callSID]
+	delete(s.entries, callSID)
+	return e.metadata, ok
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"io"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"sync/atomic"
+
+	"github.com/agentplexus/omnivoice-examples/kit/audio"
+)
+
+const (
+	// mixAttackMs is how quickly a gain drops, such as the ambience's as
+	// the agent starts speaking, and mixReleaseMs how slowly the ambience
+	// comes back after, so it doesn't pump between words.
+	mixAttackMs  = 40
+	mixReleaseMs = 400
+)
+
+// outboundMixer handles a call's outbound audio as PCM on its way to the
+// wire, frame by frame as the pacer sends it: it mixes ambience under the
+// agent's voice, and lowers the voice while the caller talks over it. Only
+// talkOver is safe to call while the pacer is mixing.
+type outboundMixer struct {
+	codec audio.Codec
+	// The decoder and encoder see all of the call's outbound audio, so
+	// stateful codecs such as G.722 stay in step.
+	decoder audio.Decoder
+	encoder audio.Encoder
+	// dst is where ambience is sent alone, below the taps that take
+	// outbound audio as the agent speaking.
+	dst io.Writer
+
+	// loop is the ambience, if any, and pos where in it the call is.
+	// ambience is its gain now, moving towards level while the agent is
+	// quiet and ducked while it speaks, by attack or release a sample.
+	loop                    []int16
+	pos                     int
+	ambience, level, ducked float64
+	attack, release         float64
+
+	// voice is the agent's gain now, moving towards voiceDucked by
+	// voiceStep a sample while the caller talks over it, and back to 1
+	// after.
+	voice, voiceDucked, voiceStep float64
+	talkedOver                    atomic.Bool
+
+	// pcm and out are reused from frame to frame.
+	pcm []int16
+	out []byte
+}
+
+// newOutboundMixer returns a mixer for a call using codec on the wire,
+// which sends ambience alone to dst. It changes nothing until it is given
+// ambience to play or a voice to duck.
+func newOutboundMixer(codec audio.Codec, dst io.Writer) *outboundMixer {
+	return &outboundMixer{
+		codec:       codec,
+		decoder:     codec.NewDecoder(),
+		encoder:     codec.NewEncoder(),
+		dst:         dst,
+		voice:       1,
+		voiceDucked: 1,
+	}
+}
+
+// playAmbience mixes b's ambience under the agent's voice, and plays it
+// alone between replies. Each call starts somewhere different in the
+// loop, so calls in parallel don't sound alike.
+func (m *outboundMixer) playAmbience(b *ambienceBed) {
+	m.loop = b.loops[m.codec.SampleRate()]
+	m.pos = rand.IntN(len(m.loop))
+	m.ambience, m.level, m.ducked = b.level, b.level, b.ducked
+	m.attack = m.ramp(b.level-b.ducked, mixAttackMs)
+	m.release = m.ramp(b.level-b.ducked, mixReleaseMs)
+}
+
+// duckVoice has the agent's voice lowered to gain while the caller talks
+// over it (see talkOver).
+func (m *outboundMixer) duckVoice(gain float64) {
+	m.voiceDucked = gain
+	m.voiceStep = m.ramp(1-gain, mixAttackMs)
+}
+
+// ramp returns the change a sample that moves a gain by by over ms.
+func (m *outboundMixer) ramp(by float64, ms int) float64 {
+	return by / (float64(ms) * float64(m.codec.SampleRate()) / 1000)
+}
+
+// active reports whether the mixer changes the call's audio.
+func (m *outboundMixer) active() bool {
+	return m.loop != nil || m.voiceDucked != 1
+}
+
+// talkOver reports the caller starting or stopping talking over the
+// agent, whose voice is ducked meanwhile if duckVoice was called.
+func (m *outboundMixer) talkOver(on bool) {
+	m.talkedOver.Store(on)
+}
+
+// mix returns agent's wire audio with its voice ducked while the caller
+// talks over it, and the ambience mixed in under it. The result is reused
+// by the next call.
+func (m *outboundMixer) mix(agent []byte) []byte {
+	m.pcm = audio.AppendDecode(m.decoder, m.pcm[:0], agent)
+	target := 1.0
+	if m.talkedOver.Load() {
+		target = m.voiceDucked
+	}
+	for i, s := range m.pcm {
+		m.voice = towards(m.voice, target, m.voiceStep)
+		m.pcm[i] = clampSample(float64(s)*m.voice + m.next(m.ducked, m.attack))
+	}
+	return m.encode()
+}
+
+// fill returns n bytes of wire audio of the ambience alone, or nil if
+// there is none. The result is reused by the next call.
+func (m *outboundMixer) fill(n int) []byte {
+	if m.loop == nil {
+		return nil
+	}
+	if m.codec == audio.CodecG722 {
+		// Each G.722 byte carries two samples
+		n *= 2
+	}
+	m.pcm = slices.Grow(m.pcm[:0], n)[:n]
+	for i := range m.pcm {
+		m.pcm[i] = clampSample(m.next(m.level, m.release))
+	}
+	return m.encode()
+}
+
+// next returns the next sample of the ambience, moving its gain towards
+// target by step, or 0 if there is no ambience.
+func (m *outboundMixer) next(target, step float64) float64 {
+	if m.loop == nil {
+		return 0
+	}
+	m.ambience = towards(m.ambience, target, step)
+	v := float64(m.loop[m.pos]) * m.ambience
+	if m.pos++; m.pos == len(m.loop) {
+		m.pos = 0
+	}
+	return v
+}
+
+func (m *outboundMixer) encode() []byte {
+	m.out = audio.AppendEncode(m.encoder, m.out[:0], m.pcm)
+	return m.out
+}
+
+// towards moves gain towards target by step, without passing it.
+func towards(gain, target, step float64) float64 {
+	if gain > target {
+		return max(target, gain-step)
+	}
+	return min(target, gain+step)
+}
+
+// clampSample converts v to a 16-bit sample, clipping it.
+func clampSample(v float64) int16 {
+	return int16(max(math.MinInt16, min(math.MaxInt16, v)))
+}
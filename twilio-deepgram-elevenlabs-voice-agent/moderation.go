@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/moderation"
+)
+
+// Moderation checkers, set with MODERATION.
+const (
+	moderationOff      = "off"
+	moderationWordlist = "wordlist"
+	moderationOpenAI   = "openai"
+	moderationBoth     = "both"
+)
+
+// ModerationConfig is how what is said on a call is moderated: caller
+// transcripts before the agent sees them, and the agent's replies before
+// they are synthesized.
+type ModerationConfig struct {
+	// Checker is off, wordlist, openai or both.
+	Checker string
+	// Wordlist, if set, is a file of terms added to the built-in
+	// profanity list.
+	Wordlist string
+	// Caller and Agent are what is done with flagged text from each side.
+	Caller, Agent moderation.Decision
+	// CallerLine is said instead of answering a caller turn that was
+	// blocked; AgentLine is said instead of a reply that was blocked.
+	CallerLine string
+	AgentLine  string
+	// Timeout bounds each check; text whose check fails is allowed.
+	Timeout time.Duration
+}
+
+// defaultModerationConfig returns the configuration used unless overridden
+// by MODERATION, MODERATION_WORDLIST, MODERATION_CALLER, MODERATION_AGENT,
+// MODERATION_CALLER_LINE, MODERATION_AGENT_LINE and MODERATION_TIMEOUT.
+// Moderation is off by default.
+func defaultModerationConfig() ModerationConfig {
+	return ModerationConfig{
+		Checker:    moderationOff,
+		Caller:     moderation.Rewrite,
+		Agent:      moderation.Block,
+		CallerLine: "I'm happy to help, but let's keep it civil. What can I do for you?",
+		AgentLine:  "Sorry, let me put that another way. How else can I help?",
+		Timeout:    500 * time.Millisecond,
+	}
+}
+
+// moderationConfigFromEnv applies environment overrides to the defaults.
+func moderationConfigFromEnv() (ModerationConfig, error) {
+	c := defaultModerationConfig()
+	if v := os.Getenv("MODERATION"); v != "" {
+		switch v = strings.ToLower(strings.TrimSpace(v)); v {
+		case moderationOff, moderationWordlist, moderationOpenAI, moderationBoth:
+			c.Checker = v
+		default:
+			return c, fmt.Errorf("invalid MODERATION: %q (want off, wordlist, openai or both)", v)
+		}
+	}
+	c.Wordlist = os.Getenv("MODERATION_WORDLIST")
+	for key, d := range map[string]*moderation.Decision{"MODERATION_CALLER": &c.Caller, "MODERATION_AGENT": &c.Agent} {
+		if v := os.Getenv(key); v != "" {
+			decision, err := moderation.ParseDecision(v)
+			if err != nil {
+				return c, fmt.Errorf("invalid %s: %w", key, err)
+			}
+			*d = decision
+		}
+	}
+	if v := os.Getenv("MODERATION_CALLER_LINE"); v != "" {
+		c.CallerLine = v
+	}
+	if v := os.Getenv("MODERATION_AGENT_LINE"); v != "" {
+		c.AgentLine = v
+	}
+	if v := os.Getenv("MODERATION_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return c, fmt.Errorf("invalid MODERATION_TIMEOUT: %q", v)
+		}
+		c.Timeout = d
+	}
+	return c, nil
+}
+
+// moderator builds the configured moderator, or nil when moderation is
+// off. Its Policy decides by direction as configured; replace it to decide
+// per call or per category.
+func (c ModerationConfig) moderator(residency ResidencyPolicy) (*moderation.Moderator, error) {
+	var checkers moderation.Checkers
+	if c.Checker == moderationWordlist || c.Checker == moderationBoth {
+		list := moderation.DefaultWordlist()
+		if c.Wordlist != "" {
+			extra, err := moderation.LoadWordlist(c.Wordlist)
+			if err != nil {
+				return nil, fmt.Errorf("invalid MODERATION_WORDLIST: %w", err)
+			}
+			list.Merge(extra)
+		}
+		checkers = append(checkers, list)
+	}
+	if c.Checker == moderationOpenAI || c.Checker == moderationBoth {
+		key := os.Getenv("OPENAI_API_KEY")
+		if key == "" {
+			return nil, fmt.Errorf("MODERATION=%s requires OPENAI_API_KEY", c.Checker)
+		}
+		checker := moderation.NewOpenAI(key)
+		if base := os.Getenv("OPENAI_BASE_URL"); base != "" {
+			checker.BaseURL = base
+		}
+		if err := residency.ValidateEndpoint("openai", checker.BaseURL); err != nil {
+			return nil, fmt.Errorf("MODERATION=%s: %w", c.Checker, err)
+		}
+		checkers = append(checkers, checker)
+	}
+	if len(checkers) == 0 {
+		return nil, nil
+	}
+	return &moderation.Moderator{
+		Checker: checkers,
+		Policy:  moderation.Fixed(c.Caller, c.Agent),
+		Timeout: c.Timeout,
+	}, nil
+}
+
+// ModerationFlag is a flagged utterance, as recorded in the CDR. What was
+// said isn't recorded.
+type ModerationFlag struct {
+	Turn       int      `json:"turn"`
+	Direction  string   `json:"direction"`
+	Categories []string `json:"categories,omitempty"`
+	Decision   string   `json:"decision"`
+}
+
+// moderated records a flagged utterance in the CDR.
+func (s *CallSession) moderated(turn int, dir moderation.Direction, o moderation.Outcome) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cdr.Moderation = append(s.cdr.Moderation, ModerationFlag{
+		Turn:       turn,
+		Direction:  string(dir),
+		Categories: o.Result.Categories,
+		Decision:   o.Decision.String(),
+	})
+}
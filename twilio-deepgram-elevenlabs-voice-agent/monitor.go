@@ -0,0 +1,449 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/audio"
+	"github.com/agentplexus/omnivoice/transport"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	// monitorEventBuffer bounds the events queued for a slow supervisor.
+	monitorEventBuffer = 64
+	// monitorAudioBuffer bounds the audio chunks queued for a slow
+	// supervisor; beyond it chunks are dropped.
+	monitorAudioBuffer = 50
+	// monitorMaxAgentAudio bounds the agent audio held for mixing when the
+	// caller's side stalls.
+	monitorMaxAgentAudio = time.Second
+
+	monitorWriteTimeout = 10 * time.Second
+	monitorPingInterval = 30 * time.Second
+	monitorPongTimeout  = 2 * monitorPingInterval
+)
+
+// Monitor event kinds.
+const (
+	monitorTranscript  = "transcript"
+	monitorInterim     = "interim"
+	monitorState       = "state"
+	monitorAudioFormat = "audio_format"
+	monitorError       = "error"
+	monitorEnd         = "end"
+)
+
+// Supervisor commands.
+const (
+	monitorTakeOver = "takeover"
+	monitorRelease  = "release"
+	monitorSay      = "say"
+)
+
+// MonitorEvent is sent to a supervisor as a JSON text message. Mixed call
+// audio, if requested, is sent as binary messages of 16-bit little-endian
+// PCM at the rate given by the audio_format event.
+type MonitorEvent struct {
+	Kind       string      `json:"kind"`
+	Speaker    string      `json:"speaker,omitempty"`
+	Text       string      `json:"text,omitempty"`
+	Target     Leg         `json:"target,omitempty"`
+	At         time.Time   `json:"at,omitzero"`
+	State      *AgentState `json:"state,omitempty"`
+	Encoding   string      `json:"encoding,omitempty"`
+	SampleRate int         `json:"sample_rate,omitempty"`
+}
+
+// AgentState is whether the agent has been muted or taken over.
+type AgentState struct {
+	Muted     bool `json:"muted"`
+	TakenOver bool `json:"taken_over"`
+}
+
+// monitorCommand is sent by a supervisor, e.g. {"kind": "takeover"} or
+// {"kind": "say", "text": "..."}. A say command with a target ("caller" or
+// "human") whispers to that leg only.
+type monitorCommand struct {
+	Kind   string `json:"kind"`
+	Text   string `json:"text"`
+	Target string `json:"target"`
+}
+
+var (
+	errTakenOver    = errors.New("another supervisor has taken over the call")
+	errNotTakenOver = errors.New("call is not taken over by this supervisor")
+)
+
+// monitorWatcher is one supervisor's WebSocket or caption stream.
+type monitorWatcher struct {
+	events chan MonitorEvent
+	audio  chan []byte // nil unless listening in
+	// interim is set for watchers that also want interim transcripts.
+	interim bool
+}
+
+// monitorUpgrader keeps gorilla's default same-origin check; the admin
+// token is required as well.
+var monitorUpgrader = websocket.Upgrader{}
+
+// watch adds a supervisor, returning the transcript so far. It reports
+// false once the call has ended.
+func (c *liveCall) watch(withAudio, interim bool) (*monitorWatcher, []TranscriptLine, MonitorEvent, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ended {
+		return nil, nil, MonitorEvent{}, false
+	}
+	w := &monitorWatcher{events: make(chan MonitorEvent, monitorEventBuffer), interim: interim}
+	if withAudio {
+		w.audio = make(chan []byte, monitorAudioBuffer)
+		c.listeners++
+	}
+	c.watchers[w] = struct{}{}
+	return w, append([]TranscriptLine(nil), c.transcript...), c.state(), true
+}
+
+// unwatch removes a supervisor.
+func (c *liveCall) unwatch(w *monitorWatcher) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.watchers[w]; !ok {
+		return
+	}
+	delete(c.watchers, w)
+	if w.audio != nil {
+		c.listeners--
+	}
+	if c.listeners == 0 {
+		c.agentAudio = nil
+	}
+}
+
+// TakeOver pauses the agent so the supervisor w can handle the call, or
+// hands it back. Only one supervisor holds a call at a time.
+func (c *liveCall) TakeOver(w *monitorWatcher, on bool) error {
+	c.takeOverMu.Lock()
+	defer c.takeOverMu.Unlock()
+
+	c.mu.Lock()
+	switch {
+	case on && c.takenOverBy != nil && c.takenOverBy != w:
+		c.mu.Unlock()
+		return errTakenOver
+	case !on && c.takenOverBy != w:
+		c.mu.Unlock()
+		return errNotTakenOver
+	}
+	if on {
+		c.takenOverBy = w
+	} else {
+		c.takenOverBy = nil
+	}
+	c.publish(c.state())
+	c.mu.Unlock()
+
+	c.takeOver(on)
+	return nil
+}
+
+// End tells every supervisor the call is over.
+func (c *liveCall) End() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.ended {
+		return
+	}
+	c.publish(MonitorEvent{Kind: monitorEnd, At: time.Now()})
+	c.ended = true
+	for w := range c.watchers {
+		close(w.events)
+	}
+	c.watchers = nil
+	c.listeners = 0
+}
+
+// state describes the agent's state. c.mu must be held.
+func (c *liveCall) state() MonitorEvent {
+	return MonitorEvent{Kind: monitorState, State: &AgentState{Muted: c.muted, TakenOver: c.takenOverBy != nil}}
+}
+
+// publish sends an event to every supervisor. c.mu must be held.
+func (c *liveCall) publish(event MonitorEvent) {
+	for w := range c.watchers {
+		if event.Kind == monitorInterim && !w.interim {
+			continue
+		}
+		select {
+		case w.events <- event:
+		default:
+			slog.Warn("supervisor too slow, event dropped", "kind", event.Kind)
+		}
+	}
+}
+
+// listening reports whether any supervisor wants the call's audio.
+func (c *liveCall) listening() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.listeners > 0
+}
+
+// played holds agent audio until the caller audio it overlaps arrives.
+func (c *liveCall) played(pcm []int16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.listeners == 0 {
+		return
+	}
+	c.agentAudio = append(c.agentAudio, pcm...)
+	if limit := int(monitorMaxAgentAudio.Seconds() * float64(c.codec.SampleRate())); len(c.agentAudio) > limit {
+		c.agentAudio = c.agentAudio[len(c.agentAudio)-limit:]
+	}
+}
+
+// heard mixes caller audio with the agent audio played alongside it and
+// sends it to the supervisors listening in. Inbound audio arrives at real
+// time, so it sets the pace.
+func (c *liveCall) heard(pcm []int16) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.listeners == 0 {
+		return
+	}
+	mixed := make([]int16, len(pcm))
+	n := copy(mixed, pcm)
+	for i, s := range c.agentAudio[:min(n, len(c.agentAudio))] {
+		mixed[i] = int16(max(min(int32(mixed[i])+int32(s), 32767), -32768))
+	}
+	c.agentAudio = c.agentAudio[:copy(c.agentAudio, c.agentAudio[min(n, len(c.agentAudio)):])]
+
+	chunk := audio.PCM16ToBytes(mixed)
+	for w := range c.watchers {
+		if w.audio == nil {
+			continue
+		}
+		select {
+		case w.audio <- chunk:
+		default:
+			// Dropped; the supervisor hears a gap rather than falling behind
+		}
+	}
+}
+
+// Tap wraps conn so that supervisors can listen in on both sides of the
+// call. Audio is only decoded while someone is listening.
+func (c *liveCall) Tap(conn transport.Connection) transport.Connection {
+	return &monitorTapConnection{
+		Connection: conn,
+		reader:     &monitorTapReader{src: conn.AudioOut(), call: c, decoder: c.codec.NewDecoder()},
+		writer:     &monitorTapWriter{dst: conn.AudioIn(), call: c, decoder: c.codec.NewDecoder()},
+	}
+}
+
+// monitorTapConnection copies a call's audio to its supervisors.
+type monitorTapConnection struct {
+	transport.Connection
+	reader *monitorTapReader
+	writer *monitorTapWriter
+}
+
+// AudioOut returns the tapping reader of caller audio.
+func (c *monitorTapConnection) AudioOut() io.Reader {
+	return c.reader
+}
+
+// AudioIn returns the tapping writer of agent audio.
+func (c *monitorTapConnection) AudioIn() io.WriteCloser {
+	return c.writer
+}
+
+type monitorTapReader struct {
+	src     io.Reader
+	call    *liveCall
+	decoder audio.Decoder
+
+	// pcm is reused for every read; the call copies what it keeps.
+	pcm []int16
+}
+
+func (r *monitorTapReader) Read(p []byte) (int, error) {
+	n, err := r.src.Read(p)
+	if n > 0 && r.call.listening() {
+		r.pcm = audio.AppendDecode(r.decoder, r.pcm[:0], p[:n])
+		r.call.heard(r.pcm)
+	}
+	return n, err
+}
+
+type monitorTapWriter struct {
+	dst     io.WriteCloser
+	call    *liveCall
+	decoder audio.Decoder
+
+	// pcm is reused for every write, all of which come from the pacer;
+	// the call copies what it keeps.
+	pcm []int16
+}
+
+func (w *monitorTapWriter) Write(b []byte) (int, error) {
+	if w.call.listening() {
+		w.pcm = audio.AppendDecode(w.decoder, w.pcm[:0], b)
+		w.call.played(w.pcm)
+	}
+	return w.dst.Write(b)
+}
+
+func (w *monitorTapWriter) Close() error {
+	return w.dst.Close()
+}
+
+// monitor streams a session's live transcript, and with ?audio=true its
+// mixed audio, to a supervisor over a WebSocket, and carries out their
+// commands: takeover, release, and say.
+func (a *adminAPI) monitor(w http.ResponseWriter, r *http.Request) {
+	call, ok := a.call(w, r)
+	if !ok {
+		return
+	}
+	withAudio, _ := strconv.ParseBool(r.URL.Query().Get("audio"))
+	conn, err := monitorUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already replied
+		return
+	}
+	defer conn.Close()
+
+	id := r.PathValue("id")
+	logger := slog.With("session", id)
+	watcher, history, state, ok := call.watch(withAudio, false)
+	if !ok {
+		_ = writeMonitor(conn, MonitorEvent{Kind: monitorEnd, At: time.Now()})
+		return
+	}
+	defer func() {
+		// A supervisor who leaves hands the call back to the agent
+		if call.TakeOver(watcher, false) == nil {
+			logger.Info("supervisor left mid-takeover, agent resumed")
+		}
+		call.unwatch(watcher)
+	}()
+	logger.Info("supervisor connected", "audio", withAudio)
+
+	// Commands are read on their own goroutine; every write happens here
+	done := make(chan struct{})
+	defer close(done)
+	commands := make(chan monitorCommand)
+	go func() {
+		defer close(commands)
+		conn.SetReadLimit(64 << 10)
+		_ = conn.SetReadDeadline(time.Now().Add(monitorPongTimeout))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(monitorPongTimeout))
+		})
+		for {
+			var cmd monitorCommand
+			if err := conn.ReadJSON(&cmd); err != nil {
+				return
+			}
+			select {
+			case commands <- cmd:
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	for _, line := range history {
+		if writeMonitor(conn, MonitorEvent{Kind: monitorTranscript, Speaker: line.Speaker, Text: line.Text, Target: line.Target, At: line.At}) != nil {
+			return
+		}
+	}
+	if writeMonitor(conn, state) != nil {
+		return
+	}
+	if withAudio {
+		format := MonitorEvent{Kind: monitorAudioFormat, Encoding: "pcm_s16le", SampleRate: call.codec.SampleRate()}
+		if writeMonitor(conn, format) != nil {
+			return
+		}
+	}
+
+	ping := time.NewTicker(monitorPingInterval)
+	defer ping.Stop()
+	for {
+		select {
+		case cmd, open := <-commands:
+			if !open {
+				logger.Info("supervisor disconnected")
+				return
+			}
+			if err := a.command(call, watcher, cmd, logger); err != nil {
+				if writeMonitor(conn, MonitorEvent{Kind: monitorError, Text: err.Error()}) != nil {
+					return
+				}
+			}
+		case event, open := <-watcher.events:
+			if !open {
+				_ = conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseNormalClosure, "call ended"),
+					time.Now().Add(monitorWriteTimeout))
+				return
+			}
+			if writeMonitor(conn, event) != nil {
+				return
+			}
+		case chunk := <-watcher.audio:
+			_ = conn.SetWriteDeadline(time.Now().Add(monitorWriteTimeout))
+			if conn.WriteMessage(websocket.BinaryMessage, chunk) != nil {
+				return
+			}
+		case <-ping.C:
+			if conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(monitorWriteTimeout)) != nil {
+				return
+			}
+		}
+	}
+}
+
+// command carries out a supervisor's command.
+func (a *adminAPI) command(call *liveCall, watcher *monitorWatcher, cmd monitorCommand, logger *slog.Logger) error {
+	switch cmd.Kind {
+	case monitorTakeOver:
+		if err := call.TakeOver(watcher, true); err != nil {
+			return err
+		}
+		logger.Info("supervisor took over call")
+	case monitorRelease:
+		if err := call.TakeOver(watcher, false); err != nil {
+			return err
+		}
+		logger.Info("supervisor handed call back to agent")
+	case monitorSay:
+		if strings.TrimSpace(cmd.Text) == "" {
+			return errors.New("say needs text")
+		}
+		target, err := parseLeg(cmd.Target)
+		if err != nil {
+			return err
+		}
+		if err := call.say(cmd.Text, target); err != nil {
+			return err
+		}
+		logger.Info("supervisor injected message", "text", cmd.Text, "target", target)
+	default:
+		return errors.New("unknown command: " + cmd.Kind)
+	}
+	return nil
+}
+
+// writeMonitor sends an event to a supervisor.
+func writeMonitor(conn *websocket.Conn, event MonitorEvent) error {
+	_ = conn.SetWriteDeadline(time.Now().Add(monitorWriteTimeout))
+	return conn.WriteJSON(event)
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/config"
+	"github.com/agentplexus/omnivoice-examples/kit/mock"
+	"github.com/agentplexus/omnivoice/stt"
+	"github.com/agentplexus/omnivoice/tts"
+)
+
+const (
+	// offlineAccountSID stands in for the Twilio account when offline.
+	offlineAccountSID = "AC00000000000000000000000000000000"
+	// offlineCallSID is the call SID of the simulated call placed at
+	// startup.
+	offlineCallSID = "CA00000000000000000000000000000000"
+	// offlineCallGrace is how long past the end of its script the
+	// simulated call waits for the agent to hang up before the caller
+	// does.
+	offlineCallGrace = 10 * time.Second
+	// offlineHangupDelay is how long after the agent ends a simulated call
+	// its stream closes.
+	offlineHangupDelay = 200 * time.Millisecond
+)
+
+// OfflineConfig runs the server without API keys, a network connection or
+// a Twilio account: mock STT hears a script, mock TTS speaks a tone, the
+// agent echoes, and Twilio's REST API is answered in process. The full
+// session logic (greeting, barge-in, goodbye, transfers, the CDR) runs as
+// it would on a real call.
+type OfflineConfig struct {
+	Enabled bool
+	// Script is what the caller says on every call, one line each
+	// TurnInterval.
+	Script       []string
+	TurnInterval time.Duration
+	// Tone is the pitch of the agent's voice in Hz; 0 speaks silence.
+	Tone float64
+}
+
+// defaultOfflineConfig returns the configuration used unless overridden by
+// OFFLINE, OFFLINE_SCRIPT, OFFLINE_TURN_INTERVAL and OFFLINE_TONE.
+func defaultOfflineConfig() OfflineConfig {
+	return OfflineConfig{
+		Script:       mock.DefaultScript,
+		TurnInterval: 4 * time.Second,
+		Tone:         440,
+	}
+}
+
+// offlineConfigFromEnv applies environment overrides to the defaults.
+// OFFLINE=1 enables offline mode. OFFLINE_SCRIPT is a file of caller
+// utterances, one per line; blank lines and lines starting with # are
+// skipped.
+func offlineConfigFromEnv() (OfflineConfig, error) {
+	cfg := defaultOfflineConfig()
+	if v := os.Getenv("OFFLINE"); v != "" {
+		enabled, err := strconv.ParseBool(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid OFFLINE: %q", v)
+		}
+		cfg.Enabled = enabled
+	}
+	if path := os.Getenv("OFFLINE_SCRIPT"); path != "" {
+		script, err := loadOfflineScript(path)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid OFFLINE_SCRIPT: %w", err)
+		}
+		cfg.Script = script
+	}
+	if v := os.Getenv("OFFLINE_TURN_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return cfg, fmt.Errorf("invalid OFFLINE_TURN_INTERVAL: %q", v)
+		}
+		cfg.TurnInterval = d
+	}
+	if v := os.Getenv("OFFLINE_TONE"); v != "" {
+		tone, err := strconv.ParseFloat(v, 64)
+		if err != nil || tone < 0 {
+			return cfg, fmt.Errorf("invalid OFFLINE_TONE: %q", v)
+		}
+		cfg.Tone = tone
+	}
+	return cfg, nil
+}
+
+// loadOfflineScript reads a script file.
+func loadOfflineScript(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	var script []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line != "" && !strings.HasPrefix(line, "#") {
+			script = append(script, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(script) == 0 {
+		return nil, fmt.Errorf("%s has no lines", path)
+	}
+	return script, nil
+}
+
+// Apply makes cfg runnable offline: the agent echoes instead of calling a
+// language model, and Twilio gets placeholder credentials, since nothing
+// is sent to it. Signature validation is disabled so local clients can
+// connect.
+func (c OfflineConfig) Apply(cfg *config.Config) {
+	cfg.LLM = config.LLM{}
+	for number, t := range cfg.Tenants {
+		t.LLM = config.LLM{}
+		cfg.Tenants[number] = t
+	}
+	for _, x := range cfg.Experiments {
+		for i := range x.Variants {
+			x.Variants[i].LLM = config.LLM{}
+		}
+	}
+	cfg.Twilio.AccountSID = offlineAccountSID
+	cfg.Twilio.AuthToken = "offline"
+	cfg.Twilio.ValidateSignatures = false
+}
+
+// Providers returns the mock speech providers.
+func (c OfflineConfig) Providers() (tts.StreamingProvider, stt.StreamingProvider) {
+	return &mock.TTS{Tone: c.Tone}, &mock.STT{Script: c.Script, Interval: c.TurnInterval}
+}
+
+// offlineCallPath matches the REST API path that updates a call.
+var offlineCallPath = regexp.MustCompile(`/Calls/([^/]+)\.json$`)
+
+// offlineTwilioAPI answers the Twilio REST API in process. Hanging up or
+// redirecting a simulated call ends it, as Twilio would; everything else
+// is logged and accepted.
+type offlineTwilioAPI struct {
+	server *httptest.Server
+
+	mu    sync.Mutex
+	calls map[string]*mock.Conn
+}
+
+func newOfflineTwilioAPI() *offlineTwilioAPI {
+	api := &offlineTwilioAPI{calls: make(map[string]*mock.Conn)}
+	api.server = httptest.NewServer(http.HandlerFunc(api.serveHTTP))
+	return api
+}
+
+// Client returns a REST client that talks to the stand-in.
+func (a *offlineTwilioAPI) Client() *twilioClient {
+	client := newTwilioClient(offlineAccountSID, "offline")
+	client.baseURL = a.server.URL
+	return client
+}
+
+// Close stops the stand-in.
+func (a *offlineTwilioAPI) Close() {
+	a.server.Close()
+}
+
+// place registers a simulated call, so the agent can end it.
+func (a *offlineTwilioAPI) place(callSID string, conn *mock.Conn) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.calls[callSID] = conn
+}
+
+func (a *offlineTwilioAPI) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	_ = r.ParseForm()
+	slog.Info("offline Twilio API request", "method", r.Method, "path", r.URL.Path, "form", r.PostForm.Encode())
+	if m := offlineCallPath.FindStringSubmatch(r.URL.Path); m != nil && r.Method == http.MethodPost {
+		// A hangup or new TwiML ends the call's stream shortly after the
+		// API answers, as on Twilio
+		if r.PostForm.Get("Status") == "completed" || r.PostForm.Has("Twiml") || r.PostForm.Has("Url") {
+			a.mu.Lock()
+			conn := a.calls[m[1]]
+			delete(a.calls, m[1])
+			a.mu.Unlock()
+			if conn != nil {
+				time.AfterFunc(offlineHangupDelay, conn.Hangup)
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = io.WriteString(w, `{"sid": "RE00000000000000000000000000000000"}`)
+}
+
+// runOfflineCall places one simulated call through the server and waits
+// for it to end. The caller speaks the offline script; if the agent hasn't
+// hung up shortly after the script ends, the caller does.
+func runOfflineCall(ctx context.Context, server *Server, api *offlineTwilioAPI, cfg OfflineConfig) {
+	conn := mock.NewConn(offlineCallSID, map[string]string{
+		paramCallSID: offlineCallSID,
+		paramCaller:  "+15555550100",
+		paramCalled:  "+15555550199",
+	})
+	api.place(offlineCallSID, conn)
+	slog.Info("placing simulated call", "call_sid", offlineCallSID, "turns", len(cfg.Script))
+
+	ended := make(chan struct{})
+	go func() {
+		defer close(ended)
+		server.handleSession(ctx, conn)
+	}()
+
+	// Count what the agent said; the caller has no ears
+	heard := make(chan int64, 1)
+	go func() {
+		n, _ := io.Copy(io.Discard, conn.Received())
+		heard <- n
+	}()
+
+	timeout := time.Duration(len(cfg.Script)+1)*cfg.TurnInterval + offlineCallGrace
+	select {
+	case <-conn.Done():
+	case <-time.After(timeout):
+		slog.Info("simulated caller hanging up")
+		conn.Hangup()
+	case <-ctx.Done():
+		conn.Hangup()
+	}
+	<-ended
+	speech := time.Duration(<-heard) * time.Second / time.Duration(server.transportCodec.BytesPerSecond())
+	slog.Info("simulated call ended", "call_sid", offlineCallSID, "agent_audio", speech.Round(time.Millisecond))
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/audio"
+	"github.com/agentplexus/omnivoice/tts"
+)
+
+const (
+	// openAIBaseURL is OpenAI's default API endpoint.
+	openAIBaseURL = "https://api.openai.com/v1"
+	// openAISpeechRate is the sample rate of OpenAI's "pcm" speech:
+	// 16-bit little-endian mono at 24kHz.
+	openAISpeechRate = 24000
+	// openAISpeechChunk is how much audio each stream chunk carries.
+	openAISpeechChunk = 100 * time.Millisecond
+)
+
+// openAIVoices are OpenAI's built-in voices.
+var openAIVoices = []string{"alloy", "ash", "ballad", "coral", "echo", "fable", "nova", "onyx", "sage", "shimmer", "verse"}
+
+// openAITTS synthesizes with OpenAI's speech API, as a secondary to
+// ElevenLabs from another vendor. OpenAI speaks 24kHz linear PCM, which is
+// resampled and encoded to the format a session asks for, as the
+// transcoding connection does for PCM from the primary.
+type openAITTS struct {
+	apiKey  string
+	baseURL string
+	quality audio.Quality
+	client  *http.Client
+}
+
+var _ tts.StreamingProvider = (*openAITTS)(nil)
+
+// newOpenAITTS creates an OpenAI speech provider with apiKey, on baseURL
+// if it isn't empty, resampling with quality.
+func newOpenAITTS(apiKey, baseURL string, quality audio.Quality) (*openAITTS, error) {
+	if apiKey == "" {
+		return nil, errors.New("OpenAI needs TTS_SECONDARY_API_KEY or OPENAI_API_KEY")
+	}
+	return &openAITTS{apiKey: apiKey, baseURL: firstNonEmpty(baseURL, openAIBaseURL), quality: quality, client: http.DefaultClient}, nil
+}
+
+// Name returns "openai".
+func (p *openAITTS) Name() string { return "openai" }
+
+// Synthesize returns the speech for text.
+func (p *openAITTS) Synthesize(ctx context.Context, text string, config tts.SynthesisConfig) (*tts.SynthesisResult, error) {
+	chunks, err := p.SynthesizeStream(ctx, text, config)
+	if err != nil {
+		return nil, err
+	}
+	var speech []byte
+	for chunk := range chunks {
+		if chunk.Error != nil {
+			return nil, chunk.Error
+		}
+		speech = append(speech, chunk.Audio...)
+	}
+	_, rate, _ := openAIOutput(config)
+	return &tts.SynthesisResult{Audio: speech, Format: config.OutputFormat, SampleRate: rate, CharacterCount: len(text)}, nil
+}
+
+// SynthesizeStream streams the speech for text in config's format:
+// "ulaw" or "alaw" at 8kHz, or "pcm" at config's sample rate.
+func (p *openAITTS) SynthesizeStream(ctx context.Context, text string, config tts.SynthesisConfig) (<-chan tts.StreamChunk, error) {
+	encode, rate, err := openAIOutput(config)
+	if err != nil {
+		return nil, err
+	}
+	var resampler *audio.Resampler
+	if rate != openAISpeechRate {
+		if resampler, err = audio.NewResampler(openAISpeechRate, rate, p.quality); err != nil {
+			return nil, err
+		}
+	}
+	body := map[string]any{
+		"model":           config.Model,
+		"voice":           config.VoiceID,
+		"input":           text,
+		"response_format": "pcm",
+	}
+	if config.Speed > 0 {
+		body["speed"] = config.Speed
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(p.baseURL, "/")+"/audio/speech", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+p.apiKey)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("openai: %w", err)
+	}
+	if resp.StatusCode/100 != 2 {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("openai: HTTP %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	chunks := make(chan tts.StreamChunk)
+	go func() {
+		defer close(chunks)
+		defer resp.Body.Close()
+		send := func(chunk tts.StreamChunk) bool {
+			select {
+			case chunks <- chunk:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+		buf := make([]byte, 2*openAISpeechRate*int(openAISpeechChunk)/int(time.Second))
+		var pcm, resampled []int16
+		carry := 0
+		for {
+			n, err := io.ReadFull(resp.Body, buf[carry:])
+			n += carry
+			// A read can end mid-sample; the odd byte waits for the next
+			whole := n &^ 1
+			pcm = audio.AppendPCM16FromBytes(pcm[:0], buf[:whole])
+			samples := pcm
+			if resampler != nil {
+				resampled = resampler.AppendProcess(resampled[:0], pcm)
+				if err != nil {
+					resampled = append(resampled, resampler.Flush()...)
+				}
+				samples = resampled
+			}
+			last := err != nil
+			if len(samples) > 0 || last {
+				if !send(tts.StreamChunk{Audio: encode(samples), IsFinal: last}) {
+					return
+				}
+			}
+			switch {
+			case errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF):
+				return
+			case err != nil:
+				send(tts.StreamChunk{Error: fmt.Errorf("openai: %w", err), IsFinal: true})
+				return
+			}
+			carry = copy(buf, buf[whole:n])
+		}
+	}()
+	return chunks, nil
+}
+
+// SynthesizeFromReader reads all the text, then streams it like
+// SynthesizeStream.
+func (p *openAITTS) SynthesizeFromReader(ctx context.Context, reader io.Reader, config tts.SynthesisConfig) (<-chan tts.StreamChunk, error) {
+	text, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	return p.SynthesizeStream(ctx, string(text), config)
+}
+
+// ListVoices returns OpenAI's built-in voices.
+func (p *openAITTS) ListVoices(ctx context.Context) ([]tts.Voice, error) {
+	voices := make([]tts.Voice, len(openAIVoices))
+	for i, id := range openAIVoices {
+		voices[i] = tts.Voice{ID: id, Name: id, Provider: "openai"}
+	}
+	return voices, nil
+}
+
+// GetVoice returns one of OpenAI's built-in voices.
+func (p *openAITTS) GetVoice(ctx context.Context, voiceID string) (*tts.Voice, error) {
+	if !slices.Contains(openAIVoices, voiceID) {
+		return nil, fmt.Errorf("openai: %w: %q", tts.ErrVoiceNotFound, voiceID)
+	}
+	return &tts.Voice{ID: voiceID, Name: voiceID, Provider: "openai"}, nil
+}
+
+// openAIOutput returns the encoder and sample rate for a synthesis
+// config's format, as ttsFormat names them.
+func openAIOutput(config tts.SynthesisConfig) (encode func([]int16) []byte, rate int, err error) {
+	switch config.OutputFormat {
+	case "ulaw":
+		return audio.MulawEncode, 8000, nil
+	case "alaw":
+		return audio.AlawEncode, 8000, nil
+	case "pcm":
+		if config.SampleRate <= 0 {
+			return nil, 0, fmt.Errorf("openai: %w: pcm without a sample rate", tts.ErrInvalidConfig)
+		}
+		return audio.PCM16ToBytes, config.SampleRate, nil
+	}
+	return nil, 0, fmt.Errorf("openai: unsupported output format %q", config.OutputFormat)
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/agentplexus/omnivoice-examples/kit/audio"
+	"github.com/agentplexus/omnivoice-examples/kit/mock"
+	"github.com/agentplexus/omnivoice/tts"
+)
+
+// fakeOpenAISpeech is OpenAI's speech API, answering with a second of a
+// 24kHz tone in pieces that split samples, and recording each request.
+func fakeOpenAISpeech(t *testing.T, requests chan<- map[string]any) *httptest.Server {
+	speech := make([]int16, openAISpeechRate)
+	for i := range speech {
+		speech[i] = int16(8000 * math.Sin(2*math.Pi*440*float64(i)/openAISpeechRate))
+	}
+	data := audio.PCM16ToBytes(speech)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/audio/speech" || r.Header.Get("Authorization") != "Bearer key" {
+			http.Error(w, `{"error":{"message":"Incorrect API key provided"}}`, http.StatusUnauthorized)
+			return
+		}
+		var body map[string]any
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+		}
+		requests <- body
+		for rest := data; len(rest) > 0; {
+			n := min(len(rest), 4801)
+			_, _ = w.Write(rest[:n])
+			w.(http.Flusher).Flush()
+			rest = rest[n:]
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestOpenAITTS(t *testing.T) {
+	requests := make(chan map[string]any, 10)
+	srv := fakeOpenAISpeech(t, requests)
+	p, err := newOpenAITTS("key", srv.URL+"/v1", audio.QualitySinc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		format string
+		rate   int
+		want   int // bytes in a second
+	}{
+		{"ulaw", 0, 8000},
+		{"alaw", 0, 8000},
+		{"pcm", 16000, 32000},
+		{"pcm", openAISpeechRate, 2 * openAISpeechRate},
+	} {
+		config := tts.SynthesisConfig{OutputFormat: tt.format, SampleRate: tt.rate, VoiceID: "nova", Model: "gpt-4o-mini-tts"}
+		chunks, err := p.SynthesizeStream(t.Context(), "Hello there.", config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []byte
+		final := false
+		for chunk := range chunks {
+			if chunk.Error != nil {
+				t.Fatal(chunk.Error)
+			}
+			got = append(got, chunk.Audio...)
+			final = chunk.IsFinal
+		}
+		if !final {
+			t.Errorf("%s %d: last chunk not final", tt.format, tt.rate)
+		}
+		// The resampler's delay line may hold back a few samples
+		if len(got) < tt.want-64 || len(got) > tt.want {
+			t.Errorf("%s %d: %d bytes of speech, want about %d", tt.format, tt.rate, len(got), tt.want)
+		}
+		body := <-requests
+		if body["voice"] != "nova" || body["model"] != "gpt-4o-mini-tts" || body["input"] != "Hello there." || body["response_format"] != "pcm" {
+			t.Errorf("%s %d: request %v", tt.format, tt.rate, body)
+		}
+	}
+
+	if _, err := p.SynthesizeStream(t.Context(), "Hello.", tts.SynthesisConfig{OutputFormat: "mp3_44100_128"}); err == nil {
+		t.Error("SynthesizeStream accepted MP3")
+	}
+	wrong, _ := newOpenAITTS("wrong", srv.URL+"/v1", audio.QualitySinc)
+	if _, err := wrong.SynthesizeStream(t.Context(), "Hello.", tts.SynthesisConfig{OutputFormat: "ulaw"}); err == nil {
+		t.Error("SynthesizeStream with a wrong key succeeded")
+	}
+}
+
+// failingTTS is a TTS provider that is down.
+type failingTTS struct{ mock.TTS }
+
+func (p *failingTTS) Name() string { return "elevenlabs" }
+
+func (p *failingTTS) SynthesizeStream(ctx context.Context, text string, config tts.SynthesisConfig) (<-chan tts.StreamChunk, error) {
+	return nil, errors.New("elevenlabs: HTTP 503")
+}
+
+// TestFallbackToOpenAI falls back from a failing primary to OpenAI
+// configured as the server configures it, which speaks in its own voice
+// rather than the primary's.
+func TestFallbackToOpenAI(t *testing.T) {
+	requests := make(chan map[string]any, 1)
+	srv := fakeOpenAISpeech(t, requests)
+	t.Setenv("TTS_SECONDARY", "openai")
+	t.Setenv("OPENAI_API_KEY", "key")
+	t.Setenv("OPENAI_BASE_URL", srv.URL+"/v1")
+	cfg, err := fallbackConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondary, err := newSecondaryTTS(cfg.TTS, OfflineConfig{}, audio.QualitySinc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := newFallbackTTS(&failingTTS{}, secondary, cfg, nil, "")
+
+	chunks, err := chain.SynthesizeStream(t.Context(), "Hello there.", tts.SynthesisConfig{OutputFormat: "ulaw", VoiceID: "21m00Tcm4TlvDq8ikWAM", Model: "eleven_flash_v2_5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range chunks {
+	}
+	body := <-requests
+	if body["voice"] != "alloy" || body["model"] != "gpt-4o-mini-tts" {
+		t.Errorf("secondary asked for voice %v, model %v, want OpenAI's defaults", body["voice"], body["model"])
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// outboundStallThreshold is how long sending one frame to the transport
+// may take before the send counts as stalled. Frames are sent every 20ms,
+// so a send this slow means Twilio isn't keeping up.
+const outboundStallThreshold = 100 * time.Millisecond
+
+// OutboundPolicy is what audio written to a full outbound queue does while
+// sending to the transport has stalled.
+type OutboundPolicy string
+
+const (
+	// OutboundBlock holds the writer until there is room, pushing
+	// backpressure onto the TTS stream. No audio is lost, but playback
+	// falls behind by as long as the stall lasts.
+	OutboundBlock OutboundPolicy = "block"
+	// OutboundDropOldest discards the oldest queued audio to make room, so
+	// the caller hears current audio once the stall clears.
+	OutboundDropOldest OutboundPolicy = "drop-oldest"
+)
+
+// OutboundBuffer bounds the audio queued for a call ahead of playback.
+// Whatever the policy, a queue that is full only because the TTS provider
+// streams faster than real time holds its writer: that is how synthesis is
+// paced.
+type OutboundBuffer struct {
+	// Frames is how many frames may be queued.
+	Frames int
+	Policy OutboundPolicy
+}
+
+// defaultOutboundBuffer queues up to a second of audio and blocks.
+func defaultOutboundBuffer() OutboundBuffer {
+	return OutboundBuffer{Frames: outboundBufferFrames, Policy: OutboundBlock}
+}
+
+// outboundBufferFromEnv reads OUTBOUND_BUFFER, the most audio queued per
+// call (default 1s), and OUTBOUND_BUFFER_POLICY, "block" (the default) or
+// "drop-oldest".
+func outboundBufferFromEnv() (OutboundBuffer, error) {
+	buf := defaultOutboundBuffer()
+	if v := os.Getenv("OUTBOUND_BUFFER"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < outboundPrebufferFrames*outboundFrameInterval {
+			return OutboundBuffer{}, fmt.Errorf("invalid OUTBOUND_BUFFER: %q (want a duration of at least %v)", v, outboundPrebufferFrames*outboundFrameInterval)
+		}
+		buf.Frames = int(d / outboundFrameInterval)
+	}
+	if v := os.Getenv("OUTBOUND_BUFFER_POLICY"); v != "" {
+		buf.Policy = OutboundPolicy(v)
+		if buf.Policy != OutboundBlock && buf.Policy != OutboundDropOldest {
+			return OutboundBuffer{}, fmt.Errorf("invalid OUTBOUND_BUFFER_POLICY: %q (want block or drop-oldest)", v)
+		}
+	}
+	return buf, nil
+}
+
+// OutboundStats describes how one outbound queue fared.
+type OutboundStats struct {
+	// PeakMs is the most audio queued at once.
+	PeakMs int64 `json:"peak_ms"`
+	// Stalls counts sends to the transport slower than
+	// outboundStallThreshold, and StalledMs is how long they took.
+	Stalls    int   `json:"stalls,omitempty"`
+	StalledMs int64 `json:"stalled_ms,omitempty"`
+	// DroppedMs is the audio discarded by the drop-oldest policy.
+	DroppedMs int64 `json:"dropped_ms,omitempty"`
+}
+
+// Congested reports whether sending stalled or audio was dropped.
+func (s OutboundStats) Congested() bool {
+	return s.Stalls > 0 || s.DroppedMs > 0
+}
+
+// OutboundMetrics tracks the outbound queue of every call, live and
+// finished.
+type OutboundMetrics struct {
+	Buffer OutboundBuffer
+
+	mu   sync.Mutex
+	live map[*pacer]struct{}
+	// streams counts finished queues, and total sums their stats, with
+	// the highest peak.
+	streams   int64
+	congested int64
+	total     OutboundStats
+}
+
+// newOutboundMetrics returns metrics for queues bounded by buf.
+func newOutboundMetrics(buf OutboundBuffer) *OutboundMetrics {
+	return &OutboundMetrics{Buffer: buf, live: make(map[*pacer]struct{})}
+}
+
+// track follows p until it stops.
+func (m *OutboundMetrics) track(p *pacer) {
+	m.mu.Lock()
+	m.live[p] = struct{}{}
+	m.mu.Unlock()
+	go func() {
+		<-p.done
+		stats := p.stats()
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		delete(m.live, p)
+		m.streams++
+		if stats.Congested() {
+			m.congested++
+		}
+		m.total.PeakMs = max(m.total.PeakMs, stats.PeakMs)
+		m.total.Stalls += stats.Stalls
+		m.total.StalledMs += stats.StalledMs
+		m.total.DroppedMs += stats.DroppedMs
+	}()
+}
+
+// ServeHTTP reports the audio queued now and how the queues have fared, as
+// JSON.
+func (m *OutboundMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	queued := 0
+	for p := range m.live {
+		queued += len(p.frames)
+	}
+	stats := map[string]any{
+		"policy":       m.Buffer.Policy,
+		"buffer_ms":    (time.Duration(m.Buffer.Frames) * outboundFrameInterval).Milliseconds(),
+		"live_streams": len(m.live),
+		"queued_ms":    (time.Duration(queued) * outboundFrameInterval).Milliseconds(),
+		"streams":      m.streams,
+		"congested":    m.congested,
+		"peak_ms":      m.total.PeakMs,
+		"stalls":       m.total.Stalls,
+		"stalled_ms":   m.total.StalledMs,
+		"dropped_ms":   m.total.DroppedMs,
+	}
+	m.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		slog.Error("failed to write outbound stats", "error", err)
+	}
+}
@@ -0,0 +1,500 @@
+package main
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/audio"
+	"github.com/agentplexus/omnivoice/transport"
+)
+
+const (
+	// outboundFrameSize is 20ms of 8kHz mu-law audio, the frame size Twilio
+	// itself uses for Media Streams. A-law and G.722 also run at 64 kbit/s,
+	// so the same size holds for every supported codec.
+	outboundFrameSize = 160
+	// outboundFrameInterval is the playback duration of one frame.
+	outboundFrameInterval = 20 * time.Millisecond
+	// outboundBufferFrames bounds the pacer queue by default (1 second of
+	// audio). Writers block once it is full, pushing backpressure onto the
+	// TTS stream; see OutboundBuffer.
+	outboundBufferFrames = 50
+	// outboundPrebufferFrames is how much audio is queued before playback
+	// starts, absorbing jitter in the provider's delivery.
+	outboundPrebufferFrames = 3
+)
+
+// outboundFrames recycles the frames pacers queue, which are returned once
+// sent or cleared, so outbound audio isn't allocated frame by frame.
+var outboundFrames = audio.NewFramePool()
+
+// pacedConnection wraps a transport.Connection so outbound audio is released
+// to Twilio in real time rather than as fast as the TTS provider produces it.
+// Keeping Twilio's own buffer nearly empty means barge-in stops playback
+// within a frame instead of after whatever Twilio has already queued.
+type pacedConnection struct {
+	transport.Connection
+	pacer *pacer
+	// far, if set, reports when audio the pacer sent has been played to
+	// the caller.
+	far playbackMarker
+}
+
+// newPacedConnection starts a pacer writing to conn's outbound audio,
+// queueing as buf allows.
+func newPacedConnection(conn transport.Connection, buf OutboundBuffer) *pacedConnection {
+	p := &pacer{
+		dst:    conn.AudioIn(),
+		frames: make(chan *audio.Frame, buf.Frames),
+		policy: buf.Policy,
+		done:   make(chan struct{}),
+	}
+	go p.run()
+	return &pacedConnection{Connection: conn, pacer: p}
+}
+
+// AudioIn returns the paced writer. Closing it is a no-op; the pacer lives
+// as long as the session and is stopped with Stop.
+func (c *pacedConnection) AudioIn() io.WriteCloser {
+	return c.pacer
+}
+
+// Clear drops all queued audio and returns how much playback was discarded.
+func (c *pacedConnection) Clear() time.Duration {
+	return c.pacer.clear()
+}
+
+// Idle reports whether all queued audio has been sent.
+func (c *pacedConnection) Idle() bool {
+	return len(c.pacer.frames) == 0 && !c.pacer.hasPartial()
+}
+
+// WaitIdle blocks until all queued audio has been sent.
+func (c *pacedConnection) WaitIdle(ctx context.Context) error {
+	ticker := time.NewTicker(outboundFrameInterval)
+	defer ticker.Stop()
+	for !c.Idle() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-c.pacer.done:
+			return nil
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// Stats reports how the outbound queue has fared so far.
+func (c *pacedConnection) Stats() OutboundStats {
+	return c.pacer.stats()
+}
+
+// Mix passes the audio the pacer sends through m, which also fills the
+// ticks with none.
+func (c *pacedConnection) Mix(m *outboundMixer) {
+	c.pacer.mixer.Store(m)
+}
+
+// PlayingUntil returns where the utterance playing ends in the audio
+// written: at the next mark. It reports false if no mark is pending, e.g.
+// because the utterance playing hasn't all been written yet.
+func (c *pacedConnection) PlayingUntil() (int, bool) {
+	return c.pacer.nextMark()
+}
+
+// CutAfter drops the audio written from position at up to now rather than
+// sending it, and returns how much playback is discarded.
+func (c *pacedConnection) CutAfter(at int) time.Duration {
+	return c.pacer.cutAfter(at)
+}
+
+// Stop stops the pacer. Queued audio is discarded.
+func (c *pacedConnection) Stop() {
+	c.pacer.stop()
+}
+
+// pacer splits outbound audio into fixed-size frames and sends one frame
+// per frame interval.
+type pacer struct {
+	dst    io.Writer
+	frames chan *audio.Frame
+	policy OutboundPolicy
+	done   chan struct{}
+	// mixer, if set, handles every frame sent and fills the ticks with
+	// none.
+	mixer atomic.Pointer[outboundMixer]
+
+	mu sync.Mutex
+	// partial is the audio written but not yet framed, the tail of buf,
+	// which is reused from write to write.
+	partial, buf []byte
+	// queued and sent count the bytes written to the pacer and those sent
+	// on (or cleared); marks wait for sent to reach their position.
+	queued, sent int
+	marks        []pacerMark
+	// cutFrom and cutTo are the stretch of the audio written that
+	// cutAfter dropped, which is skipped rather than sent.
+	cutFrom, cutTo int
+	stopOnce       sync.Once
+	// sending is when the frame being sent started sending, or zero.
+	sending time.Time
+	// peak is the most frames queued at once, dropped the bytes dropped
+	// to make room, and stalls and stalled the sends that stalled.
+	peak, dropped int
+	stalls        int
+	stalled       time.Duration
+}
+
+// pacerMark is a position in the audio written to the pacer, and what to
+// call once it is reached: sent is false if the audio before it was
+// cleared.
+type pacerMark struct {
+	at      int
+	reached func(sent bool)
+}
+
+// Write queues audio for paced delivery, blocking while the queue is full.
+func (p *pacer) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	p.queued += len(b)
+	n := copy(p.buf[:cap(p.buf)], p.partial)
+	p.buf = append(p.buf[:n], b...)
+	p.partial = p.buf
+	p.mu.Unlock()
+
+	for {
+		frame := p.nextFrame()
+		if frame == nil {
+			return len(b), nil
+		}
+		if err := p.enqueue(frame); err != nil {
+			outboundFrames.Put(frame)
+			return 0, err
+		}
+	}
+}
+
+// nextFrame takes a whole frame off the audio not yet framed, or returns
+// nil if there isn't one.
+func (p *pacer) nextFrame() *audio.Frame {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.partial) < outboundFrameSize {
+		return nil
+	}
+	frame := outboundFrames.Get()
+	frame.Payload = append(frame.Payload, p.partial[:outboundFrameSize]...)
+	p.partial = p.partial[outboundFrameSize:]
+	return frame
+}
+
+// enqueue queues a frame, waiting while the queue is full. Under the
+// drop-oldest policy a queue that stays full because sending has stalled
+// makes room by dropping its oldest frames instead.
+func (p *pacer) enqueue(frame *audio.Frame) error {
+	select {
+	case p.frames <- frame:
+		p.noteQueued()
+		return nil
+	case <-p.done:
+		return io.ErrClosedPipe
+	default:
+	}
+
+	var tick <-chan time.Time
+	if p.policy == OutboundDropOldest {
+		ticker := time.NewTicker(outboundFrameInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case p.frames <- frame:
+			p.noteQueued()
+			return nil
+		case <-p.done:
+			return io.ErrClosedPipe
+		case <-tick:
+			if p.isStalled() {
+				p.dropOldest()
+			}
+		}
+	}
+}
+
+// noteQueued records how full the queue is.
+func (p *pacer) noteQueued() {
+	n := len(p.frames)
+	p.mu.Lock()
+	p.peak = max(p.peak, n)
+	p.mu.Unlock()
+}
+
+// isStalled reports whether the frame being sent has been sending for
+// longer than outboundStallThreshold.
+func (p *pacer) isStalled() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return !p.sending.IsZero() && time.Since(p.sending) > outboundStallThreshold
+}
+
+// dropOldest discards the oldest queued frame, as if it had been cleared.
+func (p *pacer) dropOldest() {
+	select {
+	case frame := <-p.frames:
+		n := len(frame.Payload)
+		outboundFrames.Put(frame)
+		p.mu.Lock()
+		p.dropped += n
+		p.mu.Unlock()
+		p.advance(n, false)
+	default:
+	}
+}
+
+// stats reports how the queue has fared so far.
+func (p *pacer) stats() OutboundStats {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return OutboundStats{
+		PeakMs:    (time.Duration(p.peak) * outboundFrameInterval).Milliseconds(),
+		Stalls:    p.stalls,
+		StalledMs: p.stalled.Milliseconds(),
+		DroppedMs: (time.Duration(p.dropped) * outboundFrameInterval / outboundFrameSize).Milliseconds(),
+	}
+}
+
+// Close implements io.WriteCloser without stopping the pacer.
+func (p *pacer) Close() error {
+	return nil
+}
+
+func (p *pacer) clear() time.Duration {
+	p.mu.Lock()
+	dropped := len(p.partial)
+	p.partial = nil
+	p.mu.Unlock()
+
+	for {
+		select {
+		case frame := <-p.frames:
+			dropped += len(frame.Payload)
+			outboundFrames.Put(frame)
+		default:
+			p.advance(dropped, false)
+			return time.Duration(dropped) * outboundFrameInterval / outboundFrameSize
+		}
+	}
+}
+
+// mark calls reached once everything written so far has been sent or
+// cleared, at once if it already has.
+func (p *pacer) mark(reached func(sent bool)) {
+	p.mu.Lock()
+	select {
+	case <-p.done:
+		p.mu.Unlock()
+		reached(false)
+		return
+	default:
+	}
+	if p.sent >= p.queued {
+		p.mu.Unlock()
+		reached(true)
+		return
+	}
+	p.marks = append(p.marks, pacerMark{at: p.queued, reached: reached})
+	p.mu.Unlock()
+}
+
+// advance counts n more bytes sent, or cleared, and calls the marks
+// reached.
+func (p *pacer) advance(n int, sent bool) {
+	p.mu.Lock()
+	p.sent += n
+	i := 0
+	for i < len(p.marks) && p.marks[i].at <= p.sent {
+		i++
+	}
+	reached := p.marks[:i]
+	p.marks = p.marks[i:]
+	p.mu.Unlock()
+
+	for _, m := range reached {
+		m.reached(sent)
+	}
+}
+
+func (p *pacer) stop() {
+	p.stopOnce.Do(func() {
+		p.mu.Lock()
+		close(p.done)
+		marks := p.marks
+		p.marks = nil
+		p.mu.Unlock()
+		for _, m := range marks {
+			m.reached(false)
+		}
+	})
+}
+
+// run releases one frame per tick. After the queue drains it waits for
+// outboundPrebufferFrames before resuming, unless the producer has gone
+// quiet, in which case any trailing partial frame is flushed.
+func (p *pacer) run() {
+	ticker := time.NewTicker(outboundFrameInterval)
+	defer ticker.Stop()
+
+	playing := false
+	idleTicks := 0
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-ticker.C:
+		}
+
+		if !playing && len(p.frames) < outboundPrebufferFrames && idleTicks < outboundPrebufferFrames {
+			if len(p.frames) > 0 || p.hasPartial() {
+				idleTicks++
+			}
+			p.fill()
+			continue
+		}
+
+		if frame := p.next(); frame != nil {
+			playing = true
+			idleTicks = 0
+			p.send(frame)
+			continue
+		}
+		playing = false
+		idleTicks = 0
+		tail := p.takePartial()
+		if tail != nil && p.skip(len(tail.Payload)) {
+			outboundFrames.Put(tail)
+			tail = nil
+		}
+		if tail != nil {
+			p.send(tail)
+		} else {
+			p.fill()
+		}
+	}
+}
+
+// next takes the next frame to send off the queue, skipping any that
+// cutAfter dropped, or returns nil if the queue is empty.
+func (p *pacer) next() *audio.Frame {
+	for {
+		select {
+		case frame := <-p.frames:
+			if !p.skip(len(frame.Payload)) {
+				return frame
+			}
+			outboundFrames.Put(frame)
+		default:
+			return nil
+		}
+	}
+}
+
+// skip reports whether the next n bytes to send were dropped by cutAfter,
+// counting them as cleared if so. A frame is skipped whole if it starts
+// in the stretch dropped, so it may take up to a frame of what follows.
+func (p *pacer) skip(n int) bool {
+	p.mu.Lock()
+	cut := p.sent >= p.cutFrom && p.sent < p.cutTo
+	p.mu.Unlock()
+	if cut {
+		p.advance(n, false)
+	}
+	return cut
+}
+
+// nextMark returns the position of the first mark not yet reached.
+func (p *pacer) nextMark() (int, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.marks) == 0 {
+		return 0, false
+	}
+	return p.marks[0].at, true
+}
+
+// cutAfter drops the audio written from position at up to now, and
+// returns how much playback that discards.
+func (p *pacer) cutAfter(at int) time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cutFrom, p.cutTo = at, p.queued
+	return time.Duration(max(0, p.queued-max(at, p.sent))) * outboundFrameInterval / outboundFrameSize
+}
+
+func (p *pacer) hasPartial() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.partial) > 0
+}
+
+func (p *pacer) takePartial() *audio.Frame {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.partial) == 0 {
+		return nil
+	}
+	tail := outboundFrames.Get()
+	tail.Payload = append(tail.Payload, p.partial...)
+	p.partial = nil
+	return tail
+}
+
+// send sends a frame, counting it as stalled if it takes longer than
+// outboundStallThreshold, and recycles it.
+func (p *pacer) send(frame *audio.Frame) {
+	start := time.Now()
+	p.mu.Lock()
+	p.sending = start
+	p.mu.Unlock()
+	payload := frame.Payload
+	if m := p.mixer.Load(); m != nil {
+		payload = m.mix(payload)
+	}
+	_, err := p.dst.Write(payload)
+	n := len(frame.Payload)
+	outboundFrames.Put(frame)
+	took := time.Since(start)
+	p.mu.Lock()
+	p.sending = time.Time{}
+	if took > outboundStallThreshold {
+		p.stalls++
+		p.stalled += took
+	}
+	p.mu.Unlock()
+	if err != nil {
+		p.stop()
+		return
+	}
+	p.advance(n, true)
+}
+
+// fill sends a frame of ambience alone, if there is any, on a tick with no
+// audio to send. It bypasses the pacer's destination, so it isn't taken
+// for the agent speaking.
+func (p *pacer) fill() {
+	m := p.mixer.Load()
+	if m == nil {
+		return
+	}
+	frame := m.fill(outboundFrameSize)
+	if frame == nil {
+		return
+	}
+	if _, err := m.dst.Write(frame); err != nil {
+		p.stop()
+	}
+}
@@ -0,0 +1,425 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/agent"
+	"github.com/agentplexus/omnivoice-examples/kit/audio"
+	"github.com/agentplexus/omnivoice-examples/kit/llm"
+	"github.com/agentplexus/omnivoice-examples/kit/payment"
+	"github.com/agentplexus/omnivoice/transport"
+)
+
+// toolCollectPayment is the tool the agent takes card payments with.
+const toolCollectPayment = "collect_payment"
+
+const (
+	// paymentKeyTimeout is how long the caller has to press each key.
+	paymentKeyTimeout = 15 * time.Second
+	// paymentAttempts is how many times the caller may key in each detail.
+	paymentAttempts = 3
+	// paymentChargeTimeout bounds charging the card.
+	paymentChargeTimeout = 20 * time.Second
+	// paymentMaxKeys caps the keys taken for one detail.
+	paymentMaxKeys = 24
+)
+
+// Payments lets the agent take card payments. The caller keys their card
+// details in on their phone's keypad, and while they do their audio is
+// kept out of STT, supervisors' monitoring and the call recording, so the
+// details only ever reach the payment processor.
+type Payments struct {
+	Processor payment.Processor
+	// Currency is the ISO 4217 code of every payment.
+	Currency string
+	// MaxAmount caps a payment, in the currency's minor unit.
+	MaxAmount int64
+
+	mu sync.Mutex
+	// calls holds what a payment needs of each session.
+	calls map[string]*paymentCall
+}
+
+// paymentSession is what taking a payment needs of the call it is taken
+// in.
+type paymentSession struct {
+	keypad *keypad
+	// say speaks a prompt to the caller.
+	say func(text string)
+	// secure keeps the caller's audio out of STT, monitoring and the
+	// recording while on.
+	secure func(ctx context.Context, on bool) error
+	logger *slog.Logger
+}
+
+type paymentCall struct {
+	paymentSession
+	taking   bool
+	receipts []string
+}
+
+// paymentsFromEnv builds card payments. PAYMENT_PROCESSOR is "test", for
+// a processor that approves test cards, or the HTTPS URL of a gateway
+// charges are posted to, with PAYMENT_TOKEN as its bearer token;
+// PAYMENT_CURRENCY (default USD) is the currency of every payment and
+// PAYMENT_MAX_AMOUNT (default 500.00) the largest the agent may take. It
+// returns nil if PAYMENT_PROCESSOR isn't set.
+func paymentsFromEnv() (*Payments, error) {
+	processor := os.Getenv("PAYMENT_PROCESSOR")
+	if processor == "" {
+		return nil, nil
+	}
+	p := &Payments{calls: make(map[string]*paymentCall)}
+	switch {
+	case processor == "test":
+		p.Processor = &payment.Test{}
+	case strings.HasPrefix(processor, "https://"):
+		p.Processor = &payment.HTTP{URL: processor, Token: os.Getenv("PAYMENT_TOKEN")}
+	default:
+		return nil, fmt.Errorf("invalid PAYMENT_PROCESSOR: %q (want test or an https:// URL)", processor)
+	}
+	p.Currency = strings.ToUpper(firstNonEmpty(os.Getenv("PAYMENT_CURRENCY"), "USD"))
+	if len(p.Currency) != 3 || strings.Trim(p.Currency, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") != "" {
+		return nil, fmt.Errorf("invalid PAYMENT_CURRENCY: %q (want an ISO 4217 code such as USD)", p.Currency)
+	}
+	var err error
+	if p.MaxAmount, err = parseAmount(firstNonEmpty(os.Getenv("PAYMENT_MAX_AMOUNT"), "500.00")); err != nil || p.MaxAmount <= 0 {
+		return nil, fmt.Errorf("invalid PAYMENT_MAX_AMOUNT: %q", os.Getenv("PAYMENT_MAX_AMOUNT"))
+	}
+	return p, nil
+}
+
+// Tool returns the collect_payment tool, which has the caller key in
+// their card details and charges the card.
+func (p *Payments) Tool() agent.Tool {
+	return agent.Tool{
+		Tool: llm.Tool{
+			Name:        toolCollectPayment,
+			Description: "Take a card payment from the caller, once they have agreed to the amount. The tool asks them to key their card details in on their phone's keypad and charges the card; never ask for card details aloud, and don't repeat any the caller says. Payments are in " + p.Currency + ".",
+			Parameters:  json.RawMessage(`{"type":"object","properties":{"amount":{"type":"string","description":"The amount the caller agreed to, e.g. 25.00."},"description":{"type":"string","description":"What the payment is for."}},"required":["amount"]}`),
+		},
+		Call: p.collect,
+	}
+}
+
+// collect takes a payment from the caller of the session it is called in.
+// Only the card's last four digits are ever logged or returned to the
+// agent.
+func (p *Payments) collect(ctx context.Context, args json.RawMessage) (string, error) {
+	var params struct {
+		Amount      string `json:"amount"`
+		Description string `json:"description"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return "", err
+	}
+	amount, err := parseAmount(params.Amount)
+	if err != nil {
+		return "", err
+	}
+	if amount <= 0 || amount > p.MaxAmount {
+		return fmt.Sprintf("Payments must be more than 0 and at most %s %s.", formatAmount(p.MaxAmount), p.Currency), nil
+	}
+
+	sessionID, _ := agent.SessionID(ctx)
+	p.mu.Lock()
+	call := p.calls[sessionID]
+	if call == nil {
+		p.mu.Unlock()
+		return "", errors.New("payments are not available on this call")
+	}
+	if call.taking {
+		p.mu.Unlock()
+		return "A payment is already being taken on this call.", nil
+	}
+	call.taking = true
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		call.taking = false
+		p.mu.Unlock()
+	}()
+
+	card, ok, err := p.enter(ctx, call)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		call.logger.Info("payment abandoned", "amount", formatAmount(amount), "currency", p.Currency)
+		return "The caller didn't finish keying in their card details, so no payment was taken.", nil
+	}
+
+	charge := payment.Charge{
+		Card:        card,
+		Amount:      amount,
+		Currency:    p.Currency,
+		Description: strings.TrimSpace(params.Description),
+	}
+	// A reference from the tool call's idempotency key keeps a retried
+	// request from charging twice
+	if key, ok := agent.IdempotencyKey(ctx); ok {
+		sum := sha256.Sum256([]byte(key))
+		charge.Reference = hex.EncodeToString(sum[:16])
+	}
+	chargeCtx, cancel := context.WithTimeout(ctx, paymentChargeTimeout)
+	defer cancel()
+	receipt, err := p.Processor.Charge(chargeCtx, charge)
+	if errors.Is(err, payment.ErrDeclined) {
+		call.logger.Info("payment declined", "amount", formatAmount(amount), "currency", p.Currency, "reason", err)
+		return fmt.Sprintf("The card ending in %s was declined, so no payment was taken. Ask whether they'd like to use another card.", card.Last4()), nil
+	}
+	if err != nil {
+		return "", err
+	}
+	call.logger.Info("payment taken", "amount", formatAmount(amount), "currency", p.Currency, "receipt", receipt.ID)
+	p.mu.Lock()
+	call.receipts = append(call.receipts, receipt.ID)
+	p.mu.Unlock()
+	return fmt.Sprintf("Payment of %s %s taken from the card ending in %s. The receipt number is %s; read it back to the caller.",
+		formatAmount(amount), p.Currency, card.Last4(), receipt.ID), nil
+}
+
+// enter has the caller key in their card's number, expiry date and
+// security code, each checked as it is entered, with their audio secured
+// throughout. It returns false if they didn't finish.
+func (p *Payments) enter(ctx context.Context, call *paymentCall) (payment.Card, bool, error) {
+	if err := call.secure(ctx, true); err != nil {
+		// Card details are only taken if the audio can be kept out of
+		// the recording
+		_ = call.secure(context.WithoutCancel(ctx), false)
+		return payment.Card{}, false, fmt.Errorf("card details can't be taken securely: %w", err)
+	}
+	call.keypad.open()
+	defer func() {
+		call.keypad.close()
+		if err := call.secure(context.WithoutCancel(ctx), false); err != nil {
+			call.logger.Error("failed to resume audio after card entry", "error", err)
+		}
+	}()
+
+	var card payment.Card
+	now := time.Now()
+	steps := []struct {
+		prompt, invalid string
+		check           func(keys string) bool
+		value           *string
+	}{
+		{
+			prompt:  "Please key in your card number, followed by the pound key. If you make a mistake, press star to start again.",
+			invalid: "That card number isn't valid.",
+			check:   payment.ValidNumber,
+			value:   &card.Number,
+		},
+		{
+			prompt:  "Now key in the expiry date as four digits, the month and then the year, followed by pound.",
+			invalid: "That expiry date isn't valid, or has passed.",
+			check:   func(keys string) bool { return payment.ValidExpiry(keys, now) == nil },
+			value:   &card.Expiry,
+		},
+		{
+			prompt:  "And the security code on the back of the card, followed by pound.",
+			invalid: "That security code isn't valid.",
+			check:   payment.ValidCVC,
+			value:   &card.CVC,
+		},
+	}
+	for _, step := range steps {
+		prompt := step.prompt
+		entered := false
+		for attempt := 0; attempt < paymentAttempts && !entered; attempt++ {
+			call.say(prompt)
+			keys, err := call.keypad.entry(ctx, paymentKeyTimeout)
+			if err != nil {
+				return payment.Card{}, false, nil
+			}
+			if entered = step.check(keys); !entered {
+				prompt = step.invalid + " Please key it in again, followed by pound."
+			}
+			*step.value = keys
+		}
+		if !entered {
+			call.say("Sorry, I wasn't able to take those details.")
+			return payment.Card{}, false, nil
+		}
+	}
+	call.say("Thank you. One moment while I take the payment.")
+	return card, true, nil
+}
+
+// Begin lets the agent take payments in a session.
+func (p *Payments) Begin(sessionID string, session paymentSession) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.calls[sessionID] = &paymentCall{paymentSession: session}
+}
+
+// End returns the receipts of the payments taken during a session.
+func (p *Payments) End(sessionID string) []string {
+	if p == nil {
+		return nil
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	call := p.calls[sessionID]
+	delete(p.calls, sessionID)
+	if call == nil {
+		return nil
+	}
+	return call.receipts
+}
+
+// parseAmount reads an amount such as "25" or "25.50" into the currency's
+// minor unit, taking every currency to have two decimal places.
+func parseAmount(s string) (int64, error) {
+	whole, frac, _ := strings.Cut(strings.TrimSpace(s), ".")
+	if whole == "" || len(frac) > 2 || strings.Trim(whole+frac, "0123456789") != "" {
+		return 0, fmt.Errorf("invalid amount %q: want e.g. 25.00", s)
+	}
+	units, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || units > 1e12 {
+		return 0, fmt.Errorf("invalid amount %q", s)
+	}
+	frac += strings.Repeat("0", 2-len(frac))
+	cents, _ := strconv.ParseInt(frac, 10, 64)
+	return units*100 + cents, nil
+}
+
+// formatAmount writes an amount in minor units with two decimal places.
+func formatAmount(amount int64) string {
+	return fmt.Sprintf("%d.%02d", amount/100, amount%100)
+}
+
+// keypad collects the keys the caller presses while a payment is waiting
+// for them. Keys pressed at any other time are dropped.
+type keypad struct {
+	keys   chan byte
+	active atomic.Bool
+}
+
+func newKeypad() *keypad {
+	return &keypad{keys: make(chan byte, paymentMaxKeys)}
+}
+
+// Press takes a DTMF event's key, which the transport reports as a
+// string such as "7" or "#".
+func (k *keypad) Press(data any) {
+	var key string
+	switch v := data.(type) {
+	case string:
+		key = v
+	case rune:
+		key = string(v)
+	}
+	if len(key) != 1 || !strings.Contains("0123456789*#", key) || !k.active.Load() {
+		return
+	}
+	select {
+	case k.keys <- key[0]:
+	default:
+	}
+}
+
+// open starts taking keys, dropping any left from before.
+func (k *keypad) open() {
+	k.drain()
+	k.active.Store(true)
+}
+
+// close stops taking keys and drops those not read.
+func (k *keypad) close() {
+	k.active.Store(false)
+	k.drain()
+}
+
+func (k *keypad) drain() {
+	for {
+		select {
+		case <-k.keys:
+		default:
+			return
+		}
+	}
+}
+
+// entry returns the digits keyed in up to the next #, starting over at
+// each *. It fails if no key is pressed for timeout.
+func (k *keypad) entry(ctx context.Context, timeout time.Duration) (string, error) {
+	var keys []byte
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-timer.C:
+			return "", errors.New("keypad: timed out")
+		case key := <-k.keys:
+			timer.Reset(timeout)
+			switch {
+			case key == '*':
+				keys = keys[:0]
+			case key == '#' && len(keys) > 0:
+				return string(keys), nil
+			case key != '#' && len(keys) < paymentMaxKeys:
+				keys = append(keys, key)
+			}
+		}
+	}
+}
+
+// secureAudio replaces the caller's audio with silence while card details
+// are keyed in, so neither their keys' tones nor anything they say reaches
+// STT or supervisors listening in.
+type secureAudio struct {
+	transport.Connection
+	reader *secureAudioReader
+}
+
+func newSecureAudio(conn transport.Connection, codec audio.Codec) *secureAudio {
+	return &secureAudio{
+		Connection: conn,
+		reader:     &secureAudioReader{src: conn.AudioOut(), silence: codec.Silence(160)},
+	}
+}
+
+// Set turns securing on or off.
+func (a *secureAudio) Set(on bool) {
+	a.reader.on.Store(on)
+}
+
+// AudioOut returns the securing reader of caller audio.
+func (a *secureAudio) AudioOut() io.Reader {
+	return a.reader
+}
+
+type secureAudioReader struct {
+	src     io.Reader
+	on      atomic.Bool
+	silence []byte
+}
+
+func (r *secureAudioReader) Read(p []byte) (int, error) {
+	n, err := r.src.Read(p)
+	if n > 0 && r.on.Load() {
+		for i := 0; i < n; {
+			i += copy(p[i:n], r.silence)
+		}
+	}
+	return n, err
+}
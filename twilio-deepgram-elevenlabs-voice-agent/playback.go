@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// markTimeout bounds the wait for a mark to be echoed, so a call still
+// ends if Twilio never reports it played.
+const markTimeout = 5 * time.Second
+
+// playbackMarker is implemented by connections that can report when the
+// caller has heard the audio sent to them. Twilio Media Streams do so with
+// mark messages: a mark sent after some audio is echoed back once
+// everything before it has played, or at once if that audio is cleared.
+type playbackMarker interface {
+	// Mark sends a mark named name after the audio written so far. The
+	// channel returned is closed when the mark is echoed back.
+	Mark(name string) (<-chan struct{}, error)
+}
+
+// Mark reports when the audio written so far has been played: the channel
+// returned receives true once it has, or false if it was cleared (barge-in)
+// or the pacer stopped first. Played means echoed by a mark sent on the far
+// connection after it or, where the far end can't report playback, sent.
+func (c *pacedConnection) Mark(name string) <-chan bool {
+	played := make(chan bool, 1)
+	c.pacer.mark(func(sent bool) {
+		if !sent || c.far == nil {
+			played <- sent
+			return
+		}
+		echoed, err := c.far.Mark(name)
+		if err != nil {
+			played <- true
+			return
+		}
+		go func() {
+			timer := time.NewTimer(markTimeout)
+			defer timer.Stop()
+			select {
+			case <-echoed:
+				played <- true
+			case <-timer.C:
+				// Not confirmed, but sent long enough ago to have played
+				played <- true
+			case <-c.pacer.done:
+				played <- false
+			}
+		}()
+	})
+	return played
+}
+
+// WaitPlayed blocks until the audio written so far has been played, or
+// cleared.
+func (c *pacedConnection) WaitPlayed(ctx context.Context, name string) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-c.Mark(name):
+		return nil
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/audio"
+	"github.com/agentplexus/omnivoice-examples/kit/config"
+	"github.com/agentplexus/omnivoice-examples/kit/prompts"
+	"github.com/agentplexus/omnivoice-examples/kit/speech"
+	"github.com/agentplexus/omnivoice/tts"
+)
+
+// promptRenderTimeout bounds rendering the prompt library at startup.
+const promptRenderTimeout = 2 * time.Minute
+
+// PromptLibrary is prompts rendered ahead of time, played by name instead
+// of being synthesized on every call, and still played while the TTS
+// provider is down.
+type PromptLibrary struct {
+	*prompts.Library
+	// Voice is the voice the prompts were rendered in.
+	Voice prompts.Voice
+}
+
+// promptsFromEnv renders the prompts in the YAML file at PROMPTS_FILE into
+// PROMPTS_DIR (default "prompts") with provider, in the configured voice.
+// Prompts rendered before are read back from their files; prompts that
+// can't be rendered are logged and synthesized live when played. It
+// returns nil if PROMPTS_FILE isn't set. Prompts are rendered again when
+// the voice's settings or the pronunciations in lexicon change.
+func promptsFromEnv(ctx context.Context, provider tts.Provider, voice config.ElevenLabs, lexicon *speech.Lexicon) (*PromptLibrary, error) {
+	path := os.Getenv("PROMPTS_FILE")
+	if path == "" {
+		return nil, nil
+	}
+	list, err := prompts.Load(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid PROMPTS_FILE: %w", err)
+	}
+	dir := firstNonEmpty(os.Getenv("PROMPTS_DIR"), "prompts")
+	settings := voiceSettingsKey(voice.VoiceSettings)
+	if lexicon != nil {
+		settings += "\x00" + lexicon.Key()
+	}
+	l := &PromptLibrary{Voice: prompts.Voice{ID: voice.VoiceID, Model: voice.Model, Settings: settings}}
+
+	ctx, cancel := context.WithTimeout(ctx, promptRenderTimeout)
+	defer cancel()
+	start := time.Now()
+	l.Library, err = prompts.Render(ctx, provider, list, dir, l.Voice)
+	if l.Library == nil {
+		return nil, fmt.Errorf("invalid PROMPTS_DIR: %w", err)
+	}
+	if err != nil {
+		slog.Warn("some prompts were not rendered and will be synthesized live", "error", err)
+	}
+	slog.Info("prompt library ready", "prompts", len(list), "dir", dir, "took", time.Since(start).Round(time.Millisecond))
+	return l, nil
+}
+
+// player returns the prompt lookup for a call in voice whose audio is
+// format at rate. Calls in another voice, or with a lexicon of their own,
+// get the prompts' text, to be synthesized in their own voice and with
+// their own pronunciations.
+func (l *PromptLibrary) player(voice config.ElevenLabs, lexicon *speech.Lexicon, format string, rate int, quality audio.Quality, logger *slog.Logger) func(name string) (string, []byte, bool) {
+	if l == nil {
+		return nil
+	}
+	sameVoice := voice.VoiceID == l.Voice.ID && voice.Model == l.Voice.Model && lexicon == nil
+	return func(name string) (string, []byte, bool) {
+		text, ok := l.Text(name)
+		if !ok {
+			return "", nil, false
+		}
+		ulaw, rendered := l.Audio(name)
+		if !rendered || !sameVoice {
+			return text, nil, true
+		}
+		converted, err := promptAudio(ulaw, format, rate, quality)
+		if err != nil {
+			logger.Warn("failed to convert prompt", "prompt", name, "error", err)
+			return text, nil, true
+		}
+		return text, converted, true
+	}
+}
+
+// promptAudio converts a prompt rendered as 8kHz mu-law to the format and
+// rate the TTS provider is asked for on the call (see ttsFormat).
+func promptAudio(ulaw []byte, format string, rate int, quality audio.Quality) ([]byte, error) {
+	switch format {
+	case "ulaw":
+		return ulaw, nil
+	case "alaw":
+		return audio.AlawEncode(audio.MulawDecode(ulaw)), nil
+	default:
+		pcm, err := audio.Resample(audio.MulawDecode(ulaw), 8000, rate, quality)
+		if err != nil {
+			return nil, err
+		}
+		return audio.PCM16ToBytes(pcm), nil
+	}
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/twiml"
+)
+
+// Reasons a queued call is let go.
+var (
+	errQueueTimeout = errors.New("waited too long in the queue")
+	errNotQueued    = errors.New("call is not queued")
+)
+
+// queuedCall is a call waiting for a slot, with the webhook metadata it
+// arrived with: the request that lets it in carries no SIP headers.
+type queuedCall struct {
+	metadata SessionMetadata
+	joined   time.Time
+}
+
+// Queue holds a call over the concurrency cap until a slot frees up. It
+// returns the call's place in the queue, counting from 1, or 0 if it was
+// let in at once. It returns errDraining, errRateLimited, or errAtCapacity
+// if the queue is full or not enabled.
+func (m *SessionManager) Queue(metadata SessionMetadata) (int, error) {
+	m.mu.Lock()
+	if m.draining {
+		m.mu.Unlock()
+		return 0, errDraining
+	}
+	now := time.Now()
+	m.expire(now)
+	if i := m.queued(metadata.CallSID); i >= 0 {
+		// Twilio retried the webhook
+		m.mu.Unlock()
+		return i + 1, nil
+	}
+	if len(m.queue) >= m.limits.QueueSize {
+		m.mu.Unlock()
+		return 0, errAtCapacity
+	}
+	if !m.allowCaller(metadata.From, now) {
+		m.mu.Unlock()
+		return 0, errRateLimited
+	}
+	m.queue = append(m.queue, &queuedCall{metadata: metadata, joined: now})
+	admitted := m.promote(now)
+	position := m.queued(metadata.CallSID) + 1
+	m.mu.Unlock()
+
+	// A call let in at once is answered by the caller, not redirected
+	m.notify(slices.DeleteFunc(admitted, func(sid string) bool { return sid == metadata.CallSID }))
+	return position, nil
+}
+
+// Hold returns a queued call's place in the queue, or 0 once it has been
+// let in. A call held longer than QueueWait is taken out of the queue
+// with errQueueTimeout; one not queued gets errNotQueued.
+func (m *SessionManager) Hold(callSID string) (int, error) {
+	m.mu.Lock()
+	now := time.Now()
+	m.expire(now)
+	admitted := m.promote(now)
+	position, err := m.position(callSID, now)
+	m.mu.Unlock()
+	m.notify(admitted)
+	return position, err
+}
+
+// position returns where a call is, as Hold does. m.mu must be held.
+func (m *SessionManager) position(callSID string, now time.Time) (int, error) {
+	if m.draining {
+		return 0, errDraining
+	}
+	if _, ok := m.dequeued[callSID]; ok {
+		return 0, nil
+	}
+	i := m.queued(callSID)
+	if i < 0 {
+		return 0, errNotQueued
+	}
+	if now.Sub(m.queue[i].joined) > m.limits.QueueWait {
+		m.queue = slices.Delete(m.queue, i, i+1)
+		return 0, errQueueTimeout
+	}
+	return i + 1, nil
+}
+
+// Dequeued returns the metadata a call let in from the queue arrived with.
+func (m *SessionManager) Dequeued(callSID string) (SessionMetadata, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	metadata, ok := m.dequeued[callSID]
+	delete(m.dequeued, callSID)
+	return metadata, ok
+}
+
+// Release gives back the slot of a call let in from the queue that
+// couldn't be put through, such as one that hung up while waiting, and
+// lets the next call in.
+func (m *SessionManager) Release(callSID string) {
+	m.mu.Lock()
+	delete(m.reserved, callSID)
+	delete(m.dequeued, callSID)
+	admitted := m.promote(time.Now())
+	m.mu.Unlock()
+	m.notify(admitted)
+}
+
+// OnAdmit sets fn to be told of each call let in from the queue, to put
+// it through to the agent.
+func (m *SessionManager) OnAdmit(fn func(callSID string)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.admitted = fn
+}
+
+// promote reserves slots for queued calls, oldest first, while there are
+// any free, and returns the call SIDs let in. m.mu must be held.
+func (m *SessionManager) promote(now time.Time) []string {
+	var admitted []string
+	for len(m.queue) > 0 && !m.draining && !m.atCapacity() {
+		c := m.queue[0]
+		m.queue = m.queue[1:]
+		m.reserved[c.metadata.CallSID] = now.Add(reservationTTL)
+		m.dequeued[c.metadata.CallSID] = c.metadata
+		admitted = append(admitted, c.metadata.CallSID)
+	}
+	return admitted
+}
+
+// notify tells OnAdmit's function of calls let in. m.mu must not be held.
+func (m *SessionManager) notify(admitted []string) {
+	if len(admitted) == 0 {
+		return
+	}
+	m.mu.Lock()
+	fn := m.admitted
+	m.mu.Unlock()
+	if fn == nil {
+		return
+	}
+	for _, callSID := range admitted {
+		fn(callSID)
+	}
+}
+
+// queued returns where a call is in the queue, or -1. m.mu must be held.
+func (m *SessionManager) queued(callSID string) int {
+	return slices.IndexFunc(m.queue, func(c *queuedCall) bool { return c.metadata.CallSID == callSID })
+}
+
+// expireQueue drops calls held longer than QueueWait, which have hung up
+// if they haven't been back for hold music since. m.mu must be held.
+func (m *SessionManager) expireQueue(now time.Time) {
+	m.queue = slices.DeleteFunc(m.queue, func(c *queuedCall) bool {
+		return now.Sub(c.joined) > m.limits.QueueWait+reservationTTL
+	})
+}
+
+// holdTwiML tells a queued caller their place in the queue, plays hold
+// music, and comes back to holdURL for more.
+func holdTwiML(c TwiMLConfig, limits SessionLimits, position int, holdURL string) string {
+	var doc twiml.Response
+	if limits.QueueMessage != "" {
+		doc = append(doc, c.say(strings.ReplaceAll(limits.QueueMessage, "{position}", strconv.Itoa(position))))
+	}
+	if limits.HoldMusic != "" {
+		doc = append(doc, twiml.Play{URL: limits.HoldMusic})
+	}
+	return append(doc, twiml.Redirect{URL: holdURL}).String()
+}
+
+// queueCall holds a call the webhook found over the concurrency cap,
+// answering with hold music, or reports false if it must be turned away.
+func (s *Server) queueCall(w http.ResponseWriter, r *http.Request, metadata SessionMetadata) bool {
+	if s.sessions.limits.QueueSize <= 0 {
+		return false
+	}
+	position, err := s.sessions.Queue(metadata)
+	if err != nil {
+		slog.Warn("not queueing call", "call_sid", metadata.CallSID, "reason", err)
+		return false
+	}
+	if position == 0 {
+		writeTwiML(w, twiml.Response{twiml.Redirect{URL: fmt.Sprintf("https://%s/voice/inbound", r.Host)}}.String())
+		return true
+	}
+	host := r.Host
+	s.webhookHost.Store(&host)
+	slog.Info("call queued", "call_sid", metadata.CallSID, "position", position)
+	writeTwiML(w, holdTwiML(s.callTwiML, s.sessions.limits, position, fmt.Sprintf("https://%s/voice/hold", r.Host)))
+	return true
+}
+
+// handleHold is where a queued call comes back to after each play of the
+// hold music: it hears its new place in the queue, is put through to the
+// agent if a slot has freed up, or is hung up once it has waited too long.
+func (s *Server) handleHold(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	callSID := r.Form.Get("CallSid")
+	position, err := s.sessions.Hold(callSID)
+	switch {
+	case err != nil:
+		slog.Warn("releasing queued call", "call_sid", callSID, "reason", err)
+		writeTwiML(w, hangupTwiML(s.callTwiML.say(s.sessions.limits.BusyMessage)))
+	case position == 0:
+		writeTwiML(w, twiml.Response{twiml.Redirect{URL: fmt.Sprintf("https://%s/voice/inbound", r.Host)}}.String())
+	default:
+		writeTwiML(w, holdTwiML(s.callTwiML, s.sessions.limits, position, fmt.Sprintf("https://%s/voice/hold", r.Host)))
+	}
+}
+
+// admitQueued puts a call let in from the queue through to the agent,
+// rather than waiting for its hold music to end. A call that can't be
+// redirected, usually because the caller hung up, gives its slot back.
+func (s *Server) admitQueued(callSID string) {
+	slog.Info("queued call admitted", "call_sid", callSID)
+	inboundURL := fmt.Sprintf("https://%s/voice/inbound", s.host())
+	if err := s.twilio.RedirectCallURL(context.Background(), callSID, inboundURL); err != nil {
+		slog.Warn("failed to put queued call through", "call_sid", callSID, "error", err)
+		s.sessions.Release(callSID)
+	}
+}
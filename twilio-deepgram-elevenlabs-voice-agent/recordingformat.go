@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// Recording formats, as named in RECORDING_FORMAT.
+const (
+	recordingMP3 = "mp3"
+	recordingOgg = "ogg"
+	recordingWAV = "wav"
+)
+
+// Default bitrates, in bits per second, for recordings encoded here: both
+// are plenty for telephone audio.
+const (
+	defaultMP3Bitrate  = 32000
+	defaultOpusBitrate = 16000
+)
+
+// RecordingFormat is the format call recordings are archived in. By
+// default they are kept as Twilio makes them, as MP3. Otherwise Twilio's
+// uncompressed WAV is fetched and either kept as is or encoded with FFmpeg
+// as MP3 or as Opus in Ogg, at a bitrate of your choosing.
+type RecordingFormat struct {
+	// Format is "" for Twilio's MP3, or recordingWAV, recordingMP3 or
+	// recordingOgg.
+	Format string
+	// Bitrate is the encoded bitrate in bits per second.
+	Bitrate int
+	// FFmpeg is the path of the ffmpeg binary that encodes.
+	FFmpeg string
+}
+
+// recordingFormatFromEnv reads RECORDING_FORMAT (mp3, ogg or wav; unset
+// keeps Twilio's MP3), RECORDING_BITRATE (e.g. 24k or 24000; default 32k
+// for MP3 and 16k for Ogg) and FFMPEG_PATH (default ffmpeg, looked up on
+// the PATH). Encoding needs an ffmpeg built with libmp3lame or libopus.
+func recordingFormatFromEnv() (RecordingFormat, error) {
+	f := RecordingFormat{Format: strings.ToLower(os.Getenv("RECORDING_FORMAT"))}
+	switch f.Format {
+	case "", recordingWAV:
+		return f, nil
+	case recordingMP3:
+		f.Bitrate = defaultMP3Bitrate
+	case recordingOgg:
+		f.Bitrate = defaultOpusBitrate
+	default:
+		return RecordingFormat{}, fmt.Errorf("invalid RECORDING_FORMAT: %q (want mp3, ogg or wav)", f.Format)
+	}
+	if v := os.Getenv("RECORDING_BITRATE"); v != "" {
+		bitrate, err := parseBitrate(v)
+		if err != nil {
+			return RecordingFormat{}, fmt.Errorf("invalid RECORDING_BITRATE: %w", err)
+		}
+		f.Bitrate = bitrate
+	}
+	path, err := exec.LookPath(firstNonEmpty(os.Getenv("FFMPEG_PATH"), "ffmpeg"))
+	if err != nil {
+		return RecordingFormat{}, fmt.Errorf("RECORDING_FORMAT=%s needs ffmpeg: %w", f.Format, err)
+	}
+	f.FFmpeg = path
+	return f, nil
+}
+
+// parseBitrate parses a bitrate in bits per second, or in kilobits with a
+// k suffix, between 6k and 320k.
+func parseBitrate(v string) (int, error) {
+	digits, kilo := strings.CutSuffix(strings.ToLower(strings.TrimSpace(v)), "k")
+	n, err := strconv.Atoi(digits)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a bitrate", v)
+	}
+	if kilo {
+		n *= 1000
+	}
+	if n < 6000 || n > 320000 {
+		return 0, fmt.Errorf("%q is outside 6k to 320k", v)
+	}
+	return n, nil
+}
+
+// source is the format to fetch a recording from Twilio in.
+func (f RecordingFormat) source() string {
+	if f.Format == "" {
+		return recordingMP3
+	}
+	return recordingWAV
+}
+
+// Extension is the archived recording's file extension.
+func (f RecordingFormat) Extension() string {
+	if f.Format == "" {
+		return recordingMP3
+	}
+	return f.Format
+}
+
+// recordingContentType returns the content type of a recording with
+// extension ext.
+func recordingContentType(ext string) string {
+	switch ext {
+	case recordingOgg:
+		return "audio/ogg"
+	case recordingWAV:
+		return "audio/wav"
+	default:
+		return "audio/mpeg"
+	}
+}
+
+// Encode converts a recording fetched from Twilio in the source format to
+// the archived one, passing it through FFmpeg if it needs encoding.
+func (f RecordingFormat) Encode(ctx context.Context, fetched []byte) ([]byte, error) {
+	args := []string{"-hide_banner", "-loglevel", "error", "-i", "pipe:0", "-b:a", strconv.Itoa(f.Bitrate)}
+	switch f.Format {
+	case recordingMP3:
+		args = append(args, "-c:a", "libmp3lame", "-f", "mp3", "pipe:1")
+	case recordingOgg:
+		args = append(args, "-c:a", "libopus", "-application", "voip", "-f", "ogg", "pipe:1")
+	default:
+		return fetched, nil
+	}
+	cmd := exec.CommandContext(ctx, f.FFmpeg, args...)
+	var out, stderr bytes.Buffer
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = bytes.NewReader(fetched), &out, &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			err = fmt.Errorf("%w: %s", err, msg)
+		}
+		return nil, fmt.Errorf("encoding recording as %s: %w", f.Format, err)
+	}
+	return out.Bytes(), nil
+}
@@ -0,0 +1,464 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	elevenlabs "github.com/agentplexus/go-elevenlabs"
+	elevenvoice "github.com/agentplexus/go-elevenlabs/omnivoice/tts"
+	deepgramstt "github.com/agentplexus/omnivoice-deepgram/omnivoice/stt"
+	"github.com/agentplexus/omnivoice-examples/kit/config"
+	"github.com/agentplexus/omnivoice-examples/kit/llm"
+	"github.com/agentplexus/omnivoice/stt"
+	"github.com/agentplexus/omnivoice/tts"
+)
+
+// Region is a named provider endpoint, e.g. "eu" → https://api.eu.residency.elevenlabs.io.
+// An empty URL means the provider SDK's default endpoint.
+type Region struct {
+	Name string
+	URL  string
+}
+
+// parseRegions parses a comma-separated list of name=url pairs.
+// Order matters: earlier regions are preferred over later ones.
+func parseRegions(spec string) ([]Region, error) {
+	var regions []Region
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, rawURL, ok := strings.Cut(part, "=")
+		if !ok || name == "" || rawURL == "" {
+			return nil, fmt.Errorf("invalid region %q (want name=url)", part)
+		}
+		if !strings.Contains(rawURL, "://") {
+			rawURL = "https://" + rawURL
+		}
+		if _, err := url.Parse(rawURL); err != nil {
+			return nil, fmt.Errorf("invalid region %q: %w", part, err)
+		}
+		regions = append(regions, Region{Name: name, URL: rawURL})
+	}
+	return regions, nil
+}
+
+// regionsFromEnv reads a region list from the given environment variable,
+// falling back to the provider's default endpoint when unset. Both Deepgram
+// and ElevenLabs host their default endpoints in the US.
+func regionsFromEnv(key string) ([]Region, error) {
+	spec := os.Getenv(key)
+	if spec == "" {
+		return []Region{{Name: "us"}}, nil
+	}
+	regions, err := parseRegions(spec)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", key, err)
+	}
+	if len(regions) == 0 {
+		return nil, fmt.Errorf("%s: no regions configured", key)
+	}
+	return regions, nil
+}
+
+// RegionPool tracks the health of a provider's regional endpoints and
+// selects the most preferred healthy one that the residency policy allows.
+type RegionPool struct {
+	provider string
+	regions  []Region
+	policy   ResidencyPolicy
+	client   *http.Client
+
+	mu      sync.RWMutex
+	healthy map[string]bool
+}
+
+// NewRegionPool creates a pool with every region initially considered
+// healthy. Regions the policy disallows are never selected.
+func NewRegionPool(provider string, regions []Region, policy ResidencyPolicy) *RegionPool {
+	healthy := make(map[string]bool, len(regions))
+	for _, r := range regions {
+		healthy[r.Name] = true
+	}
+	return &RegionPool{
+		provider: provider,
+		regions:  regions,
+		policy:   policy,
+		client:   &http.Client{Timeout: 3 * time.Second},
+		healthy:  healthy,
+	}
+}
+
+// Regions returns the configured regions in preference order.
+func (p *RegionPool) Regions() []Region {
+	return p.regions
+}
+
+// Candidates returns the regions to try, healthy ones first in preference
+// order followed by unhealthy ones as a last resort. Failover never leaves
+// the regions allowed by the residency policy.
+func (p *RegionPool) Candidates() []Region {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	candidates := make([]Region, 0, len(p.regions))
+	for _, r := range p.regions {
+		if p.healthy[r.Name] && p.policy.AllowsRegion(p.provider, r) {
+			candidates = append(candidates, r)
+		}
+	}
+	for _, r := range p.regions {
+		if !p.healthy[r.Name] && p.policy.AllowsRegion(p.provider, r) {
+			candidates = append(candidates, r)
+		}
+	}
+	return candidates
+}
+
+// MarkUnhealthy records a failure against a region. The next successful
+// probe marks it healthy again.
+func (p *RegionPool) MarkUnhealthy(name string, err error) {
+	p.mu.Lock()
+	wasHealthy := p.healthy[name]
+	p.healthy[name] = false
+	p.mu.Unlock()
+
+	if wasHealthy {
+		slog.Warn("region marked unhealthy", "provider", p.provider, "region", name, "error", err)
+	}
+}
+
+// Probe checks every region with a cheap unauthenticated request. Any HTTP
+// response counts as reachable; only transport errors mark a region down.
+func (p *RegionPool) Probe(ctx context.Context) {
+	for _, r := range p.regions {
+		if r.URL == "" {
+			continue
+		}
+		err := p.probe(ctx, r.URL)
+
+		p.mu.Lock()
+		wasHealthy := p.healthy[r.Name]
+		p.healthy[r.Name] = err == nil
+		p.mu.Unlock()
+
+		switch {
+		case err != nil && wasHealthy:
+			slog.Warn("region probe failed", "provider", p.provider, "region", r.Name, "error", err)
+		case err == nil && !wasHealthy:
+			slog.Info("region recovered", "provider", p.provider, "region", r.Name)
+		}
+	}
+}
+
+func (p *RegionPool) probe(ctx context.Context, rawURL string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, rawURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	return nil
+}
+
+// Run probes the regions periodically until the context is cancelled.
+func (p *RegionPool) Run(ctx context.Context, interval time.Duration) {
+	if len(p.regions) < 2 {
+		return
+	}
+	p.Probe(ctx)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			p.Probe(ctx)
+		}
+	}
+}
+
+// regionalTTSProvider fails over between per-region ElevenLabs providers.
+// Each utterance starts a new WebSocket, so a region outage mid-call only
+// affects the utterance in flight; the next one goes to a healthy region.
+type regionalTTSProvider struct {
+	pool      *RegionPool
+	providers map[string]tts.StreamingProvider
+}
+
+var _ tts.StreamingProvider = (*regionalTTSProvider)(nil)
+
+// newRegionalTTSProvider creates an ElevenLabs client per region,
+// synthesizing with settings if they aren't nil.
+func newRegionalTTSProvider(apiKey string, pool *RegionPool, settings *elevenlabs.VoiceSettings, emotions map[string]config.Emotion) (*regionalTTSProvider, error) {
+	providers := make(map[string]tts.StreamingProvider, len(pool.Regions()))
+	for _, r := range pool.Regions() {
+		opts := []elevenlabs.Option{elevenlabs.WithAPIKey(apiKey)}
+		if r.URL != "" {
+			opts = append(opts, elevenlabs.WithBaseURL(r.URL))
+		}
+		client, err := elevenlabs.NewClient(opts...)
+		if err != nil {
+			return nil, fmt.Errorf("region %s: %w", r.Name, err)
+		}
+		providers[r.Name] = elevenvoice.NewWithClient(client)
+		if settings != nil || emotions != nil {
+			providers[r.Name] = &voicedTTS{Provider: elevenvoice.NewWithClient(client), client: client, settings: settings, emotions: emotions}
+		}
+	}
+	return &regionalTTSProvider{pool: pool, providers: providers}, nil
+}
+
+// primary returns the provider for the most preferred healthy region.
+func (p *regionalTTSProvider) primary() tts.StreamingProvider {
+	return p.providers[p.pool.Candidates()[0].Name]
+}
+
+// Name returns the provider name.
+func (p *regionalTTSProvider) Name() string {
+	return p.primary().Name()
+}
+
+// Synthesize converts text to speech, trying each region in turn.
+func (p *regionalTTSProvider) Synthesize(ctx context.Context, text string, config tts.SynthesisConfig) (*tts.SynthesisResult, error) {
+	var errs []error
+	for _, r := range p.pool.Candidates() {
+		result, err := p.providers[r.Name].Synthesize(ctx, text, config)
+		if err == nil {
+			return result, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		p.pool.MarkUnhealthy(r.Name, err)
+		errs = append(errs, fmt.Errorf("region %s: %w", r.Name, err))
+	}
+	return nil, errors.Join(errs...)
+}
+
+// SynthesizeStream opens a streaming synthesis, trying each region in turn.
+func (p *regionalTTSProvider) SynthesizeStream(ctx context.Context, text string, config tts.SynthesisConfig) (<-chan tts.StreamChunk, error) {
+	var errs []error
+	for _, r := range p.pool.Candidates() {
+		chunks, err := p.providers[r.Name].SynthesizeStream(ctx, text, config)
+		if err == nil {
+			return chunks, nil
+		}
+		if ctx.Err() != nil {
+			return nil, err
+		}
+		p.pool.MarkUnhealthy(r.Name, err)
+		errs = append(errs, fmt.Errorf("region %s: %w", r.Name, err))
+	}
+	return nil, errors.Join(errs...)
+}
+
+// SynthesizeFromReader streams text from a reader through the preferred
+// region. The reader can only be consumed once, so there is no failover.
+func (p *regionalTTSProvider) SynthesizeFromReader(ctx context.Context, reader io.Reader, config tts.SynthesisConfig) (<-chan tts.StreamChunk, error) {
+	r := p.pool.Candidates()[0]
+	chunks, err := p.providers[r.Name].SynthesizeFromReader(ctx, reader, config)
+	if err != nil && ctx.Err() == nil {
+		p.pool.MarkUnhealthy(r.Name, err)
+	}
+	return chunks, err
+}
+
+// ListVoices returns the voices available in the preferred region.
+func (p *regionalTTSProvider) ListVoices(ctx context.Context) ([]tts.Voice, error) {
+	return p.primary().ListVoices(ctx)
+}
+
+// GetVoice returns a voice from the preferred region.
+func (p *regionalTTSProvider) GetVoice(ctx context.Context, voiceID string) (*tts.Voice, error) {
+	return p.primary().GetVoice(ctx, voiceID)
+}
+
+// regionalSTTProvider selects a Deepgram region for each new stream. Each
+// region has its own endpoint, so a stream opened for one call never
+// changes where another call's audio goes. A live transcription stream is
+// stateful, so failover happens when a stream is opened (i.e. per session)
+// rather than mid-call.
+type regionalSTTProvider struct {
+	*deepgramstt.Provider
+	pool      *RegionPool
+	endpoints map[string]deepgramEndpoint
+}
+
+var _ stt.StreamingProvider = (*regionalSTTProvider)(nil)
+
+// newRegionalSTTProvider creates a Deepgram endpoint per region. The
+// Deepgram SDK lets DEEPGRAM_HOST override any host it's given, so it
+// can't be set alongside regional endpoints.
+func newRegionalSTTProvider(apiKey string, pool *RegionPool) (*regionalSTTProvider, error) {
+	provider, err := deepgramstt.New(deepgramstt.WithAPIKey(apiKey))
+	if err != nil {
+		return nil, err
+	}
+	endpoints := make(map[string]deepgramEndpoint, len(pool.Regions()))
+	for _, r := range pool.Regions() {
+		e := deepgramEndpoint{apiKey: apiKey}
+		if r.URL != "" {
+			if os.Getenv("DEEPGRAM_HOST") != "" {
+				return nil, errors.New("DEEPGRAM_HOST would override every region's host; list endpoints in DEEPGRAM_REGIONS only")
+			}
+			u, err := url.Parse(r.URL)
+			if err != nil {
+				return nil, fmt.Errorf("region %s: %w", r.Name, err)
+			}
+			e.host = u.Host
+		}
+		endpoints[r.Name] = e
+	}
+	return &regionalSTTProvider{Provider: provider, pool: pool, endpoints: endpoints}, nil
+}
+
+// TranscribeStream opens a streaming transcription, trying each region in turn.
+func (p *regionalSTTProvider) TranscribeStream(ctx context.Context, config stt.TranscriptionConfig) (io.WriteCloser, <-chan stt.StreamEvent, error) {
+	var errs []error
+	for _, r := range p.pool.Candidates() {
+		writer, events, err := p.endpoints[r.Name].TranscribeStream(ctx, config)
+		if err == nil {
+			return writer, events, nil
+		}
+		if ctx.Err() != nil {
+			return nil, nil, err
+		}
+		p.pool.MarkUnhealthy(r.Name, err)
+		errs = append(errs, fmt.Errorf("region %s: %w", r.Name, err))
+	}
+	return nil, nil, errors.Join(errs...)
+}
+
+// llmProviders are the language model providers that can be given
+// regional endpoints, each in <PROVIDER>_REGIONS.
+var llmProviders = []string{"anthropic", "openai", "gemini", "ollama"}
+
+// llmBaseURLEnv are the variables that set a language model provider's
+// single endpoint, which its regions replace.
+var llmBaseURLEnv = map[string]string{
+	"openai": "OPENAI_BASE_URL",
+	"ollama": "OLLAMA_HOST",
+}
+
+// llmPoolsFromEnv returns a region pool for each language model provider
+// with regional endpoints in <PROVIDER>_REGIONS, e.g. OPENAI_REGIONS, in
+// preference order. A region's URL is the provider's base URL, e.g.
+// "eu=https://eu.api.openai.com/v1,us=https://api.openai.com/v1". Every
+// region must be allowed by residency.
+func llmPoolsFromEnv(residency ResidencyPolicy) (map[string]*RegionPool, error) {
+	pools := make(map[string]*RegionPool)
+	for _, name := range llmProviders {
+		key := strings.ToUpper(name) + "_REGIONS"
+		if os.Getenv(key) == "" {
+			continue
+		}
+		if base := llmBaseURLEnv[name]; os.Getenv(base) != "" {
+			return nil, fmt.Errorf("%s would be ignored for the regions in %s; list endpoints in %s only", base, key, key)
+		}
+		regions, err := regionsFromEnv(key)
+		if err != nil {
+			return nil, err
+		}
+		if err := residency.Validate(name, regions); err != nil {
+			return nil, err
+		}
+		pools[name] = NewRegionPool(name, regions, residency)
+	}
+	return pools, nil
+}
+
+// llmBaseURL returns the field holding provider's endpoint, or nil if it
+// isn't one of the kit's providers.
+func llmBaseURL(provider llm.Provider) *string {
+	switch provider := provider.(type) {
+	case *llm.Anthropic:
+		return &provider.BaseURL
+	case *llm.OpenAI:
+		return &provider.BaseURL
+	case *llm.Gemini:
+		return &provider.BaseURL
+	case *llm.Ollama:
+		return &provider.BaseURL
+	}
+	return nil
+}
+
+// regionalLLM fails over between per-region providers of one language
+// model. Each turn is a request of its own, so a region failing mid-call
+// only affects the turn in flight, and only until it has started
+// answering: text the caller may already be hearing can't be taken back,
+// so a reply that fails partway isn't retried elsewhere.
+type regionalLLM struct {
+	name      string
+	pool      *RegionPool
+	providers map[string]llm.Provider
+}
+
+var _ llm.Provider = (*regionalLLM)(nil)
+
+// newRegionalLLM creates the named provider for model per region,
+// configured from its environment variables with the region's endpoint.
+func newRegionalLLM(name, model string, pool *RegionPool) (*regionalLLM, error) {
+	providers := make(map[string]llm.Provider, len(pool.Regions()))
+	for _, r := range pool.Regions() {
+		p, err := llm.FromEnv(name, model)
+		if err != nil {
+			return nil, err
+		}
+		if r.URL != "" {
+			*llmBaseURL(p) = r.URL
+		}
+		providers[r.Name] = p
+	}
+	return &regionalLLM{name: name, pool: pool, providers: providers}, nil
+}
+
+// Name returns the provider name.
+func (p *regionalLLM) Name() string {
+	return p.name
+}
+
+// Stream sends the request to each region in turn until one answers. A
+// request the provider rejected as invalid would be rejected by every
+// region, so it isn't tried elsewhere.
+func (p *regionalLLM) Stream(ctx context.Context, req llm.Request, onText func(string)) (*llm.Response, error) {
+	var errs []error
+	for _, r := range p.pool.Candidates() {
+		started := false
+		resp, err := p.providers[r.Name].Stream(ctx, req, func(text string) {
+			started = true
+			if onText != nil {
+				onText(text)
+			}
+		})
+		if err == nil {
+			return resp, nil
+		}
+		var apiErr *llm.APIError
+		if ctx.Err() != nil || errors.As(err, &apiErr) && apiErr.StatusCode < 500 && apiErr.StatusCode != http.StatusTooManyRequests {
+			return nil, err
+		}
+		p.pool.MarkUnhealthy(r.Name, err)
+		errs = append(errs, fmt.Errorf("region %s: %w", r.Name, err))
+		if started {
+			break
+		}
+	}
+	return nil, errors.Join(errs...)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/agentplexus/omnivoice-examples/kit/config"
+	"github.com/agentplexus/omnivoice-examples/kit/llm"
+)
+
+// ollamaRegion is an Ollama endpoint answering each chat request with
+// reply, counting the requests.
+type ollamaRegion struct {
+	*httptest.Server
+	requests atomic.Int32
+}
+
+func newOllamaRegion(t *testing.T, reply func(w http.ResponseWriter)) *ollamaRegion {
+	t.Helper()
+	r := &ollamaRegion{}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodHead {
+			return
+		}
+		r.requests.Add(1)
+		reply(w)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func answer(text string) func(http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		fmt.Fprintf(w, "{\"message\":{\"content\":%q},\"done\":true}\n", text)
+	}
+}
+
+func status(code int) func(http.ResponseWriter) {
+	return func(w http.ResponseWriter) {
+		http.Error(w, http.StatusText(code), code)
+	}
+}
+
+// regionalOllama returns an Ollama provider failing over from eu to us,
+// built as the server builds it from OLLAMA_REGIONS.
+func regionalOllama(t *testing.T, eu, us *ollamaRegion) llm.Provider {
+	t.Helper()
+	t.Setenv("OLLAMA_REGIONS", "eu="+eu.URL+",us="+us.URL)
+	pools, err := llmPoolsFromEnv(ResidencyEU)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := LLMGuard{Residency: ResidencyEU, Regions: pools}.provider(config.LLM{Provider: "ollama"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestRegionalLLMFailover(t *testing.T) {
+	eu, us := newOllamaRegion(t, status(http.StatusServiceUnavailable)), newOllamaRegion(t, answer("Hello from us."))
+	p := regionalOllama(t, eu, us)
+	for range 2 {
+		resp, err := llm.Chat(t.Context(), p, llm.Request{Messages: []llm.Message{{Role: llm.RoleUser, Content: "Hi"}}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Text != "Hello from us." {
+			t.Errorf("reply = %q, want the us region's", resp.Text)
+		}
+	}
+	// The failed region isn't tried again until a probe finds it healthy
+	if n := eu.requests.Load(); n != 1 {
+		t.Errorf("eu region sent %d requests, want 1", n)
+	}
+	if p.Name() != "ollama" {
+		t.Errorf("Name = %q, want ollama", p.Name())
+	}
+}
+
+func TestRegionalLLMNoFailover(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		reply func(http.ResponseWriter)
+	}{
+		// Every region would reject it
+		{"invalid request", status(http.StatusBadRequest)},
+		// The caller may already be hearing the reply
+		{"failed partway", func(w http.ResponseWriter) {
+			fmt.Fprint(w, "{\"message\":{\"content\":\"Let me\"}}\n{\"error\":\"model unloaded\"}\n")
+		}},
+	} {
+		eu, us := newOllamaRegion(t, tt.reply), newOllamaRegion(t, answer("Hello from us."))
+		p := regionalOllama(t, eu, us)
+		if _, err := p.Stream(context.Background(), llm.Request{Messages: []llm.Message{{Role: llm.RoleUser, Content: "Hi"}}}, func(string) {}); err == nil {
+			t.Errorf("%s: Stream succeeded", tt.name)
+		}
+		if n := us.requests.Load(); n != 0 {
+			t.Errorf("%s: us region sent %d requests, want none", tt.name, n)
+		}
+	}
+}
+
+func TestLLMPoolsFromEnv(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		env  map[string]string
+		want bool
+	}{
+		{"none", nil, true},
+		{"allowed", map[string]string{"OPENAI_REGIONS": "eu=https://eu.api.openai.com/v1"}, true},
+		{"outside the policy", map[string]string{"OPENAI_REGIONS": "eu=https://eu.api.openai.com/v1,us=https://api.openai.com/v1"}, false},
+		{"proxy", map[string]string{"ANTHROPIC_REGIONS": "eu=https://llm-proxy.example.com"}, false},
+		{"base URL too", map[string]string{"OPENAI_REGIONS": "eu=https://eu.api.openai.com/v1", "OPENAI_BASE_URL": "https://eu.api.openai.com/v1"}, false},
+		{"invalid", map[string]string{"GEMINI_REGIONS": "global"}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			if _, err := llmPoolsFromEnv(ResidencyEU); (err == nil) != tt.want {
+				t.Errorf("llmPoolsFromEnv = %v, want ok %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	deepgramstt "github.com/agentplexus/omnivoice-deepgram/omnivoice/stt"
+	"github.com/agentplexus/omnivoice-examples/kit/agent"
+	"github.com/agentplexus/omnivoice-examples/kit/audio"
+	"github.com/agentplexus/omnivoice-examples/kit/config"
+	"github.com/agentplexus/omnivoice-examples/kit/mock"
+	"github.com/agentplexus/omnivoice/stt"
+)
+
+// replayDir holds recorded calls, a directory each with the caller's
+// audio, what the recognizer heard in it and when, and the golden outcome.
+const replayDir = "testdata/replay"
+
+// Files in a recorded call's directory.
+const (
+	replayAudioFile  = "caller.wav"
+	replaySTTFile    = "stt.json"
+	replayGoldenFile = "golden.txt"
+)
+
+const (
+	// replayTail is how long the caller stays on the line once its audio
+	// ends, for the agent to answer the last turn.
+	replayTail = 5 * time.Second
+	// replayHangupTimeout bounds how long a session may take to end after
+	// the caller hangs up.
+	replayHangupTimeout = 30 * time.Second
+)
+
+// ReplayConfig controls replay tests, which play recorded calls through the
+// full session pipeline and compare the transcript and the agent's
+// decisions with golden files.
+type ReplayConfig struct {
+	// Threshold is the word similarity (0-1) at which a transcript line
+	// matches its golden line; 1 requires the same words.
+	Threshold float64
+	// DeepgramAPIKey, when set, transcribes the recorded audio with
+	// Deepgram instead of replaying the recognizer events recorded with
+	// it. Updating then records the new events as well.
+	DeepgramAPIKey string
+}
+
+// defaultReplayConfig returns the configuration used unless overridden by
+// the REPLAY_* environment variables.
+func defaultReplayConfig() ReplayConfig {
+	return ReplayConfig{Threshold: 0.8}
+}
+
+// replayConfigFromEnv applies environment overrides to the default
+// configuration. REPLAY_STT is "recorded" (the default) or "deepgram",
+// which needs DEEPGRAM_API_KEY.
+func replayConfigFromEnv() (ReplayConfig, error) {
+	cfg := defaultReplayConfig()
+	if v := os.Getenv("REPLAY_MATCH_THRESHOLD"); v != "" {
+		threshold, err := strconv.ParseFloat(v, 64)
+		if err != nil || threshold <= 0 || threshold > 1 {
+			return cfg, fmt.Errorf("invalid REPLAY_MATCH_THRESHOLD: %q (want 0-1)", v)
+		}
+		cfg.Threshold = threshold
+	}
+	switch v := os.Getenv("REPLAY_STT"); v {
+	case "", "recorded":
+	case "deepgram":
+		cfg.DeepgramAPIKey = os.Getenv("DEEPGRAM_API_KEY")
+		if cfg.DeepgramAPIKey == "" {
+			return cfg, errors.New("REPLAY_STT=deepgram needs DEEPGRAM_API_KEY")
+		}
+	default:
+		return cfg, fmt.Errorf("invalid REPLAY_STT: %q (want recorded or deepgram)", v)
+	}
+	return cfg, nil
+}
+
+// TestReplay plays the recorded calls in testdata/replay through the
+// pipeline and compares what happened with their golden files; with
+// -update it rewrites them.
+func TestReplay(t *testing.T) {
+	if testing.Short() {
+		t.Skip("replayed calls take real time")
+	}
+	cfg, err := replayConfigFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runReplay(t.Context(), replayDir, cfg, *update); err != nil {
+		t.Errorf("replayed calls differ from their golden files (run \"go test -run TestReplay -update\" if the change is intended):\n%v", err)
+	}
+}
+
+// replayOutcomeKeys label the lines of a golden file that record the
+// agent's decisions rather than speech; they must match exactly.
+var replayOutcomeKeys = map[string]bool{"turns": true, "barge-ins": true, "ended by": true, "transferred to": true, "topics": true}
+
+// replayed is what happened on a replayed call.
+type replayed struct {
+	outcome string
+	// events are what Deepgram heard, when transcribing live.
+	events []mock.RecordedEvent
+}
+
+// runReplay replays every call in dir at once and compares each outcome
+// with its golden file, or rewrites the files when update is set.
+func runReplay(ctx context.Context, dir string, cfg ReplayConfig, update bool) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	var names []string
+	for _, e := range entries {
+		if e.IsDir() {
+			names = append(names, e.Name())
+		}
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("no recorded calls in %s", dir)
+	}
+
+	results := make([]replayed, len(names))
+	errs := make([]error, len(names))
+	var wg sync.WaitGroup
+	for i, name := range names {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = replayCall(ctx, filepath.Join(dir, name), cfg)
+		}()
+	}
+	wg.Wait()
+
+	var failures []string
+	for i, name := range names {
+		if errs[i] != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", name, errs[i]))
+			continue
+		}
+		callDir := filepath.Join(dir, name)
+		if update {
+			if err := os.WriteFile(filepath.Join(callDir, replayGoldenFile), []byte(results[i].outcome), 0o644); err != nil {
+				return err
+			}
+			if results[i].events != nil {
+				data, err := json.MarshalIndent(results[i].events, "", "  ")
+				if err != nil {
+					return err
+				}
+				if err := os.WriteFile(filepath.Join(callDir, replaySTTFile), append(data, '\n'), 0o644); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+		want, err := os.ReadFile(filepath.Join(callDir, replayGoldenFile))
+		if err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", name, err))
+			continue
+		}
+		if diff := replayDifference(string(want), results[i].outcome, cfg.Threshold); diff != "" {
+			failures = append(failures, fmt.Sprintf("%s: %s", name, diff))
+		}
+	}
+	if len(failures) > 0 {
+		return errors.New(strings.Join(failures, "\n"))
+	}
+	return nil
+}
+
+// replayCall plays one recorded call through a server of its own: the
+// caller's audio is sent at real time, as on a call, and the caller stays
+// on the line for replayTail after it ends unless the agent hangs up. The
+// agent echoes, so the outcome depends on the pipeline, not a model.
+func replayCall(ctx context.Context, dir string, cfg ReplayConfig) (replayed, error) {
+	caller, err := loadReplayAudio(filepath.Join(dir, replayAudioFile))
+	if err != nil {
+		return replayed{}, err
+	}
+
+	var (
+		sttProvider stt.StreamingProvider
+		recorder    *mock.Recorder
+	)
+	if cfg.DeepgramAPIKey != "" {
+		deepgramProvider, err := deepgramstt.New(deepgramstt.WithAPIKey(cfg.DeepgramAPIKey))
+		if err != nil {
+			return replayed{}, fmt.Errorf("creating Deepgram provider: %w", err)
+		}
+		recorder = mock.NewRecorder(deepgramProvider)
+		sttProvider = recorder
+	} else {
+		data, err := os.ReadFile(filepath.Join(dir, replaySTTFile))
+		if err != nil {
+			return replayed{}, err
+		}
+		var events []mock.RecordedEvent
+		if err := json.Unmarshal(data, &events); err != nil {
+			return replayed{}, fmt.Errorf("%s: %w", replaySTTFile, err)
+		}
+		sttProvider = &mock.ReplaySTT{Events: events}
+	}
+
+	api := newOfflineTwilioAPI()
+	defer api.Close()
+	var (
+		mu         sync.Mutex
+		transcript []TranscriptLine
+		cdr        *CallDetailRecord
+	)
+	server := &Server{
+		agent:           agent.NewEcho(),
+		ttsProvider:     &mock.TTS{},
+		sttProvider:     sttProvider,
+		tts:             config.Default().ElevenLabs,
+		stt:             config.Default().Deepgram,
+		resampleQuality: audio.QualitySinc,
+		transportCodec:  audio.CodecMulaw,
+		dedupThreshold:  defaultDedupThreshold,
+		latency:         NewLatencyStats(),
+		outbound:        newOutboundMetrics(defaultOutboundBuffer()),
+		concurrency:     newConcurrency(defaultConcurrencyConfig()),
+		metadata:        newMetadataStore(),
+		greeting:        defaultGreetingConfig(),
+		termination:     defaultTerminationPolicy(),
+		twilio:          api.Client(),
+		coaching:        newCoachingHub(),
+		drain:           defaultDrainPolicy(),
+		sessions:        NewSessionManager(SessionLimits{}),
+		events:          NewEventBus(),
+	}
+	Subscribe(server.events, func(e SessionEnded) {
+		mu.Lock()
+		defer mu.Unlock()
+		transcript, cdr = e.Transcript, e.CDR
+	})
+
+	conn := mock.NewConn(goldenCallSID, map[string]string{
+		paramCallSID: goldenCallSID,
+		paramCaller:  "+15555550100",
+		paramCalled:  "+15555550199",
+	})
+	api.place(goldenCallSID, conn)
+	// Sessions are ended by their caller hanging up, never by cancellation
+	ended := make(chan struct{})
+	go func() {
+		defer close(ended)
+		server.handleSession(context.Background(), conn)
+	}()
+	go func() { _, _ = io.Copy(io.Discard, conn.Received()) }()
+
+	// The caller speaks its recording, then waits in silence
+	tail := make([]byte, int(replayTail/outboundFrameInterval)*outboundFrameSize)
+	for i := range tail {
+		tail[i] = loopbackSilence
+	}
+	frames := time.NewTicker(outboundFrameInterval)
+	defer frames.Stop()
+	remaining := append(caller, tail...)
+play:
+	for len(remaining) > 0 {
+		select {
+		case <-ctx.Done():
+			break play
+		case <-conn.Done():
+			break play
+		case <-frames.C:
+		}
+		n := min(len(remaining), outboundFrameSize)
+		if err := conn.Send(remaining[:n]); err != nil {
+			break
+		}
+		remaining = remaining[n:]
+	}
+	conn.Hangup()
+
+	select {
+	case <-ended:
+	case <-time.After(replayHangupTimeout):
+		return replayed{}, fmt.Errorf("session did not end within %s of hangup", replayHangupTimeout)
+	}
+	if err := ctx.Err(); err != nil {
+		return replayed{}, err
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if cdr == nil {
+		return replayed{}, errors.New("session ended without a call detail record")
+	}
+	result := replayed{outcome: renderReplay(transcript, cdr)}
+	if recorder != nil {
+		result.events = recorder.Events()
+	}
+	return result, nil
+}
+
+// loadReplayAudio reads a WAV file as 8kHz mu-law.
+func loadReplayAudio(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	samples, rate, err := audio.ReadWAV(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filepath.Base(path), err)
+	}
+	if rate != 8000 {
+		if samples, err = audio.Resample(samples, rate, 8000, audio.QualitySinc); err != nil {
+			return nil, err
+		}
+	}
+	return audio.MulawEncode(samples), nil
+}
+
+// renderReplay writes a call's outcome as golden file lines: the
+// transcript as "speaker: text", whispered lines as "speaker to leg:
+// text", then the decisions recorded in the CDR.
+func renderReplay(transcript []TranscriptLine, cdr *CallDetailRecord) string {
+	var b strings.Builder
+	for _, line := range transcript {
+		speaker := line.Speaker
+		if line.Target != LegAll {
+			speaker += " to " + string(line.Target)
+		}
+		fmt.Fprintf(&b, "%s: %s\n", speaker, line.Text)
+	}
+	fmt.Fprintf(&b, "turns: %d\n", cdr.Turns)
+	fmt.Fprintf(&b, "barge-ins: %d\n", cdr.BargeIns)
+	fmt.Fprintf(&b, "ended by: %s\n", cdr.EndedBy)
+	if cdr.TransferredTo != "" {
+		fmt.Fprintf(&b, "transferred to: %s\n", cdr.TransferredTo)
+	}
+	if len(cdr.Topics) > 0 {
+		labels := make([]string, len(cdr.Topics))
+		for i, t := range cdr.Topics {
+			labels[i] = t.Label
+		}
+		fmt.Fprintf(&b, "topics: %s\n", strings.Join(labels, ", "))
+	}
+	return b.String()
+}
+
+// replayDifference describes the first line where got doesn't match want,
+// or returns "" if every line matches. Speech matches by word similarity,
+// so a recognizer hearing a word differently doesn't fail the call;
+// decisions must match exactly, as must the number of lines.
+func replayDifference(want, got string, threshold float64) string {
+	wantLines := strings.Split(strings.TrimSpace(want), "\n")
+	gotLines := strings.Split(strings.TrimSpace(got), "\n")
+	for i := range max(len(wantLines), len(gotLines)) {
+		var w, g string
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if i >= len(wantLines) || i >= len(gotLines) || !replayLinesMatch(w, g, threshold) {
+			return fmt.Sprintf("line %d differs\n    want: %q\n    got:  %q", i+1, w, g)
+		}
+	}
+	return ""
+}
+
+// replayLinesMatch compares two golden file lines.
+func replayLinesMatch(want, got string, threshold float64) bool {
+	wantKey, wantText, _ := strings.Cut(want, ": ")
+	gotKey, gotText, _ := strings.Cut(got, ": ")
+	if wantKey != gotKey {
+		return false
+	}
+	if replayOutcomeKeys[wantKey] {
+		return wantText == gotText
+	}
+	return wordSimilarity(normalizeWords(wantText), normalizeWords(gotText)) >= threshold
+}
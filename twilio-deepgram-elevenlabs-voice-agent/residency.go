@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/agentplexus/omnivoice-examples/kit/llm"
+)
+
+// ResidencyPolicy restricts where call audio and transcripts may be
+// processed and kept: by the STT, TTS and language model providers, the
+// moderation API and the call archive.
+type ResidencyPolicy string
+
+const (
+	// ResidencyAny allows every configured region.
+	ResidencyAny ResidencyPolicy = ""
+	// ResidencyEU allows only endpoints hosted in the EU.
+	ResidencyEU ResidencyPolicy = "eu"
+	// ResidencyUS allows only endpoints hosted in the US.
+	ResidencyUS ResidencyPolicy = "us"
+)
+
+// providerHosts are the jurisdictions of each provider's endpoints, by
+// host. The first listed for a provider is its SDK's default endpoint.
+// An endpoint on any other host, e.g. a proxy, has no known jurisdiction.
+// Gemini serves every request from one global endpoint, and an Ollama
+// model on the server itself keeps data wherever the server is.
+var providerHosts = map[string][]struct{ host, jurisdiction string }{
+	"deepgram": {
+		{"api.deepgram.com", "us"},
+		{"api.eu.deepgram.com", "eu"},
+	},
+	"elevenlabs": {
+		{"api.elevenlabs.io", "us"},
+		{"api.us.elevenlabs.io", "us"},
+		{"api.eu.residency.elevenlabs.io", "eu"},
+		{"api.in.residency.elevenlabs.io", "in"},
+	},
+	"assemblyai": {
+		{"streaming.assemblyai.com", "us"},
+	},
+	"anthropic": {
+		{"api.anthropic.com", "us"},
+	},
+	"openai": {
+		{"api.openai.com", "us"},
+		{"eu.api.openai.com", "eu"},
+	},
+	"gemini": {
+		{"generativelanguage.googleapis.com", "global"},
+	},
+	"ollama": {
+		{"localhost", jurisdictionLocal},
+		{"127.0.0.1", jurisdictionLocal},
+		{"::1", jurisdictionLocal},
+	},
+}
+
+// jurisdictionLocal is the jurisdiction of an endpoint on the server
+// itself, which every policy allows.
+const jurisdictionLocal = "local"
+
+// parseResidencyPolicy parses the DATA_RESIDENCY setting.
+func parseResidencyPolicy(s string) (ResidencyPolicy, error) {
+	switch p := ResidencyPolicy(strings.ToLower(strings.TrimSpace(s))); p {
+	case ResidencyAny, ResidencyEU, ResidencyUS:
+		return p, nil
+	default:
+		return ResidencyAny, fmt.Errorf("unknown data residency policy %q (want eu or us)", s)
+	}
+}
+
+// String returns the policy name as recorded in call detail records.
+func (p ResidencyPolicy) String() string {
+	if p == ResidencyAny {
+		return "none"
+	}
+	return string(p)
+}
+
+// Allows reports whether the policy permits sending data to a place in
+// jurisdiction, which is empty if it isn't known.
+func (p ResidencyPolicy) Allows(jurisdiction string) bool {
+	return p == ResidencyAny || jurisdiction == string(p) || jurisdiction == jurisdictionLocal
+}
+
+// AllowsRegion reports whether the policy permits sending data to region r
+// of provider. The region's name doesn't matter: its jurisdiction is where
+// its endpoint is hosted.
+func (p ResidencyPolicy) AllowsRegion(provider string, r Region) bool {
+	return p.Allows(regionJurisdiction(provider, r))
+}
+
+// Validate rejects a provider configuration that lists any region outside
+// the policy, so a misconfigured deployment refuses to start rather than
+// silently sending data abroad.
+func (p ResidencyPolicy) Validate(provider string, regions []Region) error {
+	for _, r := range regions {
+		if p.AllowsRegion(provider, r) {
+			continue
+		}
+		host := regionHost(provider, r)
+		if regionJurisdiction(provider, r) == "" {
+			return fmt.Errorf("%s region %q is on %s, which isn't a known %s endpoint, so data residency policy %q can't be checked", provider, r.Name, host, provider, p)
+		}
+		return fmt.Errorf("%s region %q (%s) is not allowed by data residency policy %q", provider, r.Name, host, p)
+	}
+	return nil
+}
+
+// ValidateEndpoint rejects an endpoint of provider, such as a language
+// model API's base URL, outside the policy.
+func (p ResidencyPolicy) ValidateEndpoint(provider, rawURL string) error {
+	r := Region{URL: rawURL}
+	if p.AllowsRegion(provider, r) {
+		return nil
+	}
+	host := regionHost(provider, r)
+	if regionJurisdiction(provider, r) == "" {
+		return fmt.Errorf("%s endpoint %s isn't a known %s endpoint, so data residency policy %q can't be checked", provider, host, provider, p)
+	}
+	return fmt.Errorf("%s endpoint %s is not allowed by data residency policy %q", provider, host, p)
+}
+
+// ValidateLLM rejects a language model provider whose endpoint is outside
+// the policy.
+func (p ResidencyPolicy) ValidateLLM(provider llm.Provider) error {
+	if p == ResidencyAny {
+		return nil
+	}
+	base := llmBaseURL(provider)
+	if base == nil {
+		return fmt.Errorf("%s models can't be checked against data residency policy %q", provider.Name(), p)
+	}
+	return p.ValidateEndpoint(provider.Name(), *base)
+}
+
+// regionHost returns the host of region r's endpoint.
+func regionHost(provider string, r Region) string {
+	if r.URL == "" {
+		if hosts := providerHosts[provider]; len(hosts) > 0 {
+			return hosts[0].host
+		}
+		return ""
+	}
+	u, err := url.Parse(r.URL)
+	if err != nil {
+		return ""
+	}
+	return strings.ToLower(u.Hostname())
+}
+
+// regionJurisdiction returns the jurisdiction region r's endpoint is
+// hosted in, or "" if it isn't a known endpoint of provider.
+func regionJurisdiction(provider string, r Region) string {
+	host := regionHost(provider, r)
+	for _, h := range providerHosts[provider] {
+		if h.host == host {
+			return h.jurisdiction
+		}
+	}
+	return ""
+}
+
+// gcsJurisdiction returns the jurisdiction of a Google Cloud Storage
+// location, e.g. "eu" for EU, EUR4 or EUROPE-WEST1, or "" for one that
+// spans jurisdictions, such as ASIA1.
+func gcsJurisdiction(location string) string {
+	location = strings.ToLower(location)
+	switch {
+	case location == "eu" || strings.HasPrefix(location, "eur") || strings.HasPrefix(location, "europe-"):
+		return "eu"
+	case location == "us" || location == "nam4" || strings.HasPrefix(location, "us-"):
+		return "us"
+	}
+	return ""
+}
+
+// awsJurisdiction returns the jurisdiction of an AWS region, e.g. "eu" for
+// eu-west-1.
+func awsJurisdiction(region string) string {
+	jurisdiction, _, _ := strings.Cut(region, "-")
+	return jurisdiction
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/agentplexus/omnivoice-examples/kit/llm"
+	"github.com/agentplexus/omnivoice-examples/kit/storage"
+)
+
+func TestResidencyPolicyAllowsRegion(t *testing.T) {
+	tests := []struct {
+		policy   ResidencyPolicy
+		provider string
+		region   Region
+		want     bool
+	}{
+		{ResidencyEU, "deepgram", Region{Name: "eu", URL: "https://api.eu.deepgram.com"}, true},
+		{ResidencyEU, "deepgram", Region{Name: "eu", URL: "https://api.deepgram.com"}, false},
+		{ResidencyEU, "deepgram", Region{Name: "us", URL: "https://api.eu.deepgram.com"}, true},
+		{ResidencyEU, "deepgram", Region{Name: "us"}, false},
+		{ResidencyUS, "deepgram", Region{Name: "secondary"}, true},
+		{ResidencyEU, "elevenlabs", Region{Name: "eu", URL: "https://api.eu.residency.elevenlabs.io"}, true},
+		{ResidencyEU, "elevenlabs", Region{Name: "eu-west", URL: "https://API.ElevenLabs.io"}, false},
+		{ResidencyEU, "elevenlabs", Region{Name: "eu", URL: "https://proxy.example.com"}, false},
+		{ResidencyAny, "elevenlabs", Region{Name: "eu", URL: "https://proxy.example.com"}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.policy.AllowsRegion(tt.provider, tt.region); got != tt.want {
+			t.Errorf("%q.AllowsRegion(%q, %+v) = %v, want %v", tt.policy, tt.provider, tt.region, got, tt.want)
+		}
+	}
+}
+
+func TestResidencyPolicyValidate(t *testing.T) {
+	regions := []Region{
+		{Name: "eu", URL: "https://api.eu.deepgram.com"},
+		{Name: "eu-backup", URL: "https://api.deepgram.com"},
+	}
+	if err := ResidencyEU.Validate("deepgram", regions[:1]); err != nil {
+		t.Errorf("Validate(EU region) = %v", err)
+	}
+	if err := ResidencyEU.Validate("deepgram", regions); err == nil {
+		t.Error("Validate accepted a US endpoint named as an EU region")
+	}
+}
+
+func TestResidencyPolicyValidateLLM(t *testing.T) {
+	eu := llm.NewOpenAI("key", "gpt-4o-mini")
+	eu.BaseURL = "https://eu.api.openai.com/v1"
+	proxy := llm.NewOpenAI("key", "gpt-4o-mini")
+	proxy.BaseURL = "https://llm-proxy.example.com/v1"
+	tests := []struct {
+		policy   ResidencyPolicy
+		provider llm.Provider
+		want     bool
+	}{
+		{ResidencyEU, eu, true},
+		{ResidencyUS, eu, false},
+		{ResidencyEU, llm.NewOpenAI("key", "gpt-4o-mini"), false},
+		{ResidencyUS, llm.NewOpenAI("key", "gpt-4o-mini"), true},
+		{ResidencyEU, proxy, false},
+		{ResidencyAny, proxy, true},
+		{ResidencyEU, llm.NewAnthropic("key", "claude-sonnet-4-5"), false},
+		{ResidencyUS, llm.NewAnthropic("key", "claude-sonnet-4-5"), true},
+		{ResidencyEU, llm.NewGemini("key", "gemini-2.5-flash"), false},
+		{ResidencyUS, llm.NewGemini("key", "gemini-2.5-flash"), false},
+		{ResidencyEU, llm.NewOllama("", "llama3.2"), true},
+		{ResidencyEU, llm.NewOllama("http://[::1]:11434", "llama3.2"), true},
+		{ResidencyEU, llm.NewOllama("http://gpu-box.example.com:11434", "llama3.2"), false},
+	}
+	for _, tt := range tests {
+		if err := tt.policy.ValidateLLM(tt.provider); (err == nil) != tt.want {
+			t.Errorf("%q.ValidateLLM(%s) = %v, want allowed %v", tt.policy, tt.provider.Name(), err, tt.want)
+		}
+	}
+}
+
+func TestModeratorResidency(t *testing.T) {
+	t.Setenv("OPENAI_API_KEY", "key")
+	c := ModerationConfig{Checker: moderationOpenAI}
+	if _, err := c.moderator(ResidencyEU); err == nil {
+		t.Error("moderator(eu) accepted the US moderation endpoint")
+	}
+	t.Setenv("OPENAI_BASE_URL", "https://eu.api.openai.com/v1")
+	if _, err := c.moderator(ResidencyEU); err != nil {
+		t.Errorf("moderator(eu) with the EU endpoint = %v", err)
+	}
+}
+
+func TestCheckGCSResidency(t *testing.T) {
+	location := "EUROPE-WEST1"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/b/archive" {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprintf(w, `{"location":%q}`, location)
+	}))
+	defer srv.Close()
+	token := func(context.Context) (string, error) { return "token", nil }
+	bucket := &storage.GCS{Bucket: "archive", APIURL: srv.URL, Token: token}
+
+	tests := []struct {
+		policy   ResidencyPolicy
+		location string
+		want     bool
+	}{
+		{ResidencyEU, "EUROPE-WEST1", true},
+		{ResidencyEU, "EU", true},
+		{ResidencyEU, "EUR4", true},
+		{ResidencyEU, "US", false},
+		{ResidencyUS, "US-CENTRAL1", true},
+		{ResidencyUS, "NAM4", true},
+		{ResidencyEU, "ASIA1", false},
+		{ResidencyAny, "ASIA1", true},
+	}
+	for _, tt := range tests {
+		location = tt.location
+		if err := checkGCSResidency(t.Context(), bucket, tt.policy); (err == nil) != tt.want {
+			t.Errorf("%q: bucket in %s: %v, want allowed %v", tt.policy, tt.location, err, tt.want)
+		}
+	}
+
+	// A bucket whose location can't be read isn't allowed
+	missing := &storage.GCS{Bucket: "missing", APIURL: srv.URL, Token: token}
+	if err := checkGCSResidency(t.Context(), missing, ResidencyEU); err == nil {
+		t.Error("accepted a bucket whose location couldn't be read")
+	}
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/agentplexus/omnivoice/stt"
+	"github.com/agentplexus/omnivoice/tts"
+)
+
+// maxSTTBacklog is how much caller audio is held while a dropped STT
+// stream reconnects, to be transcribed once it is back.
+const maxSTTBacklog = 5 * time.Second
+
+// errSTTStreamClosed reports a recognizer closing its stream unasked.
+var errSTTStreamClosed = errors.New("stream closed by the provider")
+
+// ResiliencePolicy controls how a call rides out provider failures.
+type ResiliencePolicy struct {
+	// STTReconnectAttempts is how many times a dropped STT stream is
+	// reopened, STTReconnectBackoff apart at first and doubling up to
+	// maxBackoff, before the call gives up on hearing the caller.
+	STTReconnectAttempts int
+	STTReconnectBackoff  time.Duration
+	// TTSRetryBackoff is the wait before retrying failed synthesis, once
+	// with the same voice and then with each fallback.
+	TTSRetryBackoff time.Duration
+	// TTSFallbackVoiceID, if set, is tried when the configured voice
+	// keeps failing.
+	TTSFallbackVoiceID string
+	// RepeatPrompt asks the caller to repeat themselves when what they
+	// said was lost or couldn't be answered. LostLine ends the call when
+	// the caller can no longer be heard.
+	RepeatPrompt string
+	LostLine     string
+}
+
+// maxBackoff caps the wait between STT reconnect attempts.
+const maxBackoff = 4 * time.Second
+
+// defaultResiliencePolicy returns the policy used unless overridden by
+// STT_RECONNECT_ATTEMPTS, STT_RECONNECT_BACKOFF, TTS_RETRY_BACKOFF,
+// TTS_FALLBACK_VOICE_ID, REPEAT_PROMPT and LOST_CALLER_LINE.
+func defaultResiliencePolicy() ResiliencePolicy {
+	return ResiliencePolicy{
+		STTReconnectAttempts: 5,
+		STTReconnectBackoff:  250 * time.Millisecond,
+		TTSRetryBackoff:      200 * time.Millisecond,
+		RepeatPrompt:         "Sorry, could you repeat that?",
+		LostLine:             "Sorry, I'm having trouble hearing you. Please try calling back in a few minutes.",
+	}
+}
+
+// resiliencePolicyFromEnv applies environment overrides to the defaults.
+func resiliencePolicyFromEnv() (ResiliencePolicy, error) {
+	p := defaultResiliencePolicy()
+	if v := os.Getenv("STT_RECONNECT_ATTEMPTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return p, fmt.Errorf("invalid STT_RECONNECT_ATTEMPTS: %q", v)
+		}
+		p.STTReconnectAttempts = n
+	}
+	if v := os.Getenv("STT_RECONNECT_BACKOFF"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return p, fmt.Errorf("invalid STT_RECONNECT_BACKOFF: %q", v)
+		}
+		p.STTReconnectBackoff = d
+	}
+	if v := os.Getenv("TTS_RETRY_BACKOFF"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return p, fmt.Errorf("invalid TTS_RETRY_BACKOFF: %q", v)
+		}
+		p.TTSRetryBackoff = d
+	}
+	p.TTSFallbackVoiceID = os.Getenv("TTS_FALLBACK_VOICE_ID")
+	if v := os.Getenv("REPEAT_PROMPT"); v != "" {
+		p.RepeatPrompt = v
+	}
+	if v := os.Getenv("LOST_CALLER_LINE"); v != "" {
+		p.LostLine = v
+	}
+	return p, nil
+}
+
+// sleepContext waits for d, reporting false if ctx ends first.
+func sleepContext(ctx context.Context, d time.Duration) bool {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
+}
+
+// resilientSTT reopens a streaming recognizer's stream when the provider
+// drops it mid-call. Caller audio written while it reconnects is held, up
+// to maxSTTBacklog, and transcribed once the stream is back.
+type resilientSTT struct {
+	stt.StreamingProvider
+	policy ResiliencePolicy
+	logger *slog.Logger
+
+	// onReconnect is called each time a dropped stream is back; onGiveUp
+	// when it can't be reopened.
+	onReconnect func()
+	onGiveUp    func(err error)
+}
+
+// TranscribeStream opens a stream that survives drops. Failing to open it
+// in the first place is returned as before.
+func (p *resilientSTT) TranscribeStream(ctx context.Context, config stt.TranscriptionConfig) (io.WriteCloser, <-chan stt.StreamEvent, error) {
+	w, events, err := p.StreamingProvider.TranscribeStream(ctx, config)
+	if err != nil {
+		return nil, nil, err
+	}
+	s := &resilientStream{
+		p:          p,
+		ctx:        ctx,
+		config:     config,
+		w:          w,
+		connected:  true,
+		maxBacklog: int(audioBytesPerSecond(config) * maxSTTBacklog.Seconds()),
+		out:        make(chan stt.StreamEvent, 16),
+		broken:     make(chan error, 1),
+		done:       make(chan struct{}),
+	}
+	go s.run(events)
+	return s, s.out, nil
+}
+
+// resilientStream is one session's stream, across reconnects.
+type resilientStream struct {
+	p      *resilientSTT
+	ctx    context.Context
+	config stt.TranscriptionConfig
+
+	mu         sync.Mutex
+	w          io.WriteCloser
+	connected  bool
+	backlog    []byte
+	maxBacklog int
+	closed     bool
+
+	out       chan stt.StreamEvent
+	broken    chan error // a write failed
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Write passes audio to the current stream, or holds it while there is
+// none. Audio that can't be held is dropped, oldest first.
+func (s *resilientStream) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return 0, io.ErrClosedPipe
+	}
+	if s.connected {
+		_, err := s.w.Write(b)
+		if err == nil {
+			return len(b), nil
+		}
+		s.connected = false
+		select {
+		case s.broken <- err:
+		default:
+		}
+	}
+	s.backlog = append(s.backlog, b...)
+	if over := len(s.backlog) - s.maxBacklog; over > 0 {
+		s.backlog = s.backlog[over:]
+	}
+	return len(b), nil
+}
+
+// Close ends the stream.
+func (s *resilientStream) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		s.mu.Lock()
+		s.closed = true
+		w := s.w
+		s.mu.Unlock()
+		close(s.done)
+		err = w.Close()
+	})
+	return err
+}
+
+// run forwards the current stream's events, reconnecting when it drops.
+func (s *resilientStream) run(events <-chan stt.StreamEvent) {
+	defer close(s.out)
+	for {
+		err := s.forward(events)
+		if err == nil {
+			return
+		}
+		s.mu.Lock()
+		s.connected = false
+		dead := s.w
+		s.mu.Unlock()
+		_ = dead.Close()
+		s.p.logger.Warn("STT stream dropped, reconnecting", "error", err)
+
+		events = s.reconnect()
+		if events == nil {
+			if s.ctx.Err() != nil || s.isClosed() {
+				return
+			}
+			err = fmt.Errorf("STT stream lost after %d reconnect attempts: %w", s.p.policy.STTReconnectAttempts, err)
+			select {
+			case s.out <- stt.StreamEvent{Type: stt.EventError, Error: err}:
+			case <-s.done:
+			case <-s.ctx.Done():
+			}
+			if s.p.onGiveUp != nil {
+				s.p.onGiveUp(err)
+			}
+			return
+		}
+		if s.p.onReconnect != nil {
+			s.p.onReconnect()
+		}
+	}
+}
+
+// forward passes events on until the stream drops, returning why, or nil
+// once the session is done with it.
+func (s *resilientStream) forward(events <-chan stt.StreamEvent) error {
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				if s.isClosed() || s.ctx.Err() != nil {
+					return nil
+				}
+				return errSTTStreamClosed
+			}
+			if e.Type == stt.EventError {
+				return e.Error
+			}
+			select {
+			case s.out <- e:
+			case <-s.done:
+				return nil
+			case <-s.ctx.Done():
+				return nil
+			}
+		case err := <-s.broken:
+			return err
+		case <-s.done:
+			return nil
+		case <-s.ctx.Done():
+			return nil
+		}
+	}
+}
+
+// reconnect reopens the stream with backoff and sends it the audio held
+// meanwhile. It returns nil if every attempt failed or the session ended.
+func (s *resilientStream) reconnect() <-chan stt.StreamEvent {
+	backoff := s.p.policy.STTReconnectBackoff
+	for attempt := 1; attempt <= s.p.policy.STTReconnectAttempts; attempt++ {
+		if !sleepContext(s.ctx, backoff) || s.isClosed() {
+			return nil
+		}
+		backoff = min(2*backoff, maxBackoff)
+		w, events, err := s.p.StreamingProvider.TranscribeStream(s.ctx, s.config)
+		if err != nil {
+			s.p.logger.Warn("STT reconnect failed", "attempt", attempt, "error", err)
+			continue
+		}
+
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			_ = w.Close()
+			return nil
+		}
+		held := len(s.backlog)
+		_, err = w.Write(s.backlog)
+		s.backlog = s.backlog[:0]
+		s.w, s.connected = w, err == nil
+		s.mu.Unlock()
+		if err != nil {
+			s.p.logger.Warn("STT reconnect failed", "attempt", attempt, "error", err)
+			_ = w.Close()
+			continue
+		}
+		s.p.logger.Info("STT stream reconnected", "attempt", attempt,
+			"held", time.Duration(float64(held)/audioBytesPerSecond(s.config)*float64(time.Second)).Round(time.Millisecond))
+		return events
+	}
+	return nil
+}
+
+func (s *resilientStream) isClosed() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.closed
+}
+
+// failoverTTS retries failed synthesis: once more with the same voice,
+// then with each fallback. Only failures before any audio are retried;
+// a reply that fails partway is retried by the session's turn retry.
+type failoverTTS struct {
+	tts.StreamingProvider
+	fallbacks []ttsFallback
+	backoff   time.Duration
+	logger    *slog.Logger
+}
+
+// ttsFallback is a provider and voice to try when the primary fails. An
+// empty voice keeps the one requested.
+type ttsFallback struct {
+	provider tts.StreamingProvider
+	voiceID  string
+}
+
+// newFailoverTTS wraps provider with the policy's fallbacks.
+func newFailoverTTS(provider tts.StreamingProvider, policy ResiliencePolicy, logger *slog.Logger) *failoverTTS {
+	p := &failoverTTS{StreamingProvider: provider, backoff: policy.TTSRetryBackoff, logger: logger}
+	// The first retry is the same provider and voice: most failures are
+	// momentary
+	p.fallbacks = append(p.fallbacks, ttsFallback{provider: provider})
+	if policy.TTSFallbackVoiceID != "" {
+		p.fallbacks = append(p.fallbacks, ttsFallback{provider: provider, voiceID: policy.TTSFallbackVoiceID})
+	}
+	return p
+}
+
+// ttsAttempt is one way of synthesizing a request.
+type ttsAttempt struct {
+	provider tts.StreamingProvider
+	config   tts.SynthesisConfig
+}
+
+func (p *failoverTTS) attempts(config tts.SynthesisConfig) []ttsAttempt {
+	attempts := []ttsAttempt{{p.StreamingProvider, config}}
+	for _, f := range p.fallbacks {
+		c := config
+		if f.voiceID != "" {
+			c.VoiceID = f.voiceID
+		}
+		attempts = append(attempts, ttsAttempt{f.provider, c})
+	}
+	return attempts
+}
+
+// retry runs try for each attempt in turn, backoff apart, until one
+// succeeds.
+func (p *failoverTTS) retry(ctx context.Context, config tts.SynthesisConfig, try func(ttsAttempt) error) error {
+	var err error
+	for i, a := range p.attempts(config) {
+		if i > 0 {
+			p.logger.Warn("TTS failed, retrying", "attempt", i, "provider", a.provider.Name(), "voice", a.config.VoiceID, "error", err)
+			if !sleepContext(ctx, p.backoff) {
+				return err
+			}
+		}
+		if err = try(a); err == nil {
+			if i > 0 {
+				p.logger.Info("TTS recovered", "attempt", i, "provider", a.provider.Name(), "voice", a.config.VoiceID)
+			}
+			return nil
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func (p *failoverTTS) Synthesize(ctx context.Context, text string, config tts.SynthesisConfig) (*tts.SynthesisResult, error) {
+	var result *tts.SynthesisResult
+	err := p.retry(ctx, config, func(a ttsAttempt) error {
+		var err error
+		result, err = a.provider.Synthesize(ctx, text, a.config)
+		return err
+	})
+	return result, err
+}
+
+func (p *failoverTTS) SynthesizeStream(ctx context.Context, text string, config tts.SynthesisConfig) (<-chan tts.StreamChunk, error) {
+	var chunks <-chan tts.StreamChunk
+	err := p.retry(ctx, config, func(a ttsAttempt) error {
+		ch, err := a.provider.SynthesizeStream(ctx, text, a.config)
+		if err != nil {
+			return err
+		}
+		chunks, err = firstChunk(ctx, ch)
+		return err
+	})
+	return chunks, err
+}
+
+// firstChunk waits for a stream's first chunk, failing if it is an error
+// without audio, and returns the whole stream.
+func firstChunk(ctx context.Context, ch <-chan tts.StreamChunk) (<-chan tts.StreamChunk, error) {
+	var first tts.StreamChunk
+	var ok bool
+	select {
+	case first, ok = <-ch:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	out := make(chan tts.StreamChunk, 1)
+	if !ok {
+		close(out)
+		return out, nil
+	}
+	if first.Error != nil && len(first.Audio) == 0 {
+		// Let the failed stream finish on its own
+		go func() {
+			for range ch {
+			}
+		}()
+		return nil, first.Error
+	}
+	out <- first
+	go func() {
+		defer close(out)
+		for c := range ch {
+			select {
+			case out <- c:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return out, nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/agent"
+	"github.com/agentplexus/omnivoice-examples/kit/llm"
+	"github.com/agentplexus/omnivoice/transport"
+)
+
+// endedByDropped is the CDR's ended_by for a session whose stream dropped
+// while the call was still up.
+const endedByDropped = "dropped"
+
+// heldCall is what a session whose stream dropped leaves for the stream
+// that reconnects: the transcript and the agent's conversation.
+type heldCall struct {
+	lines   []TranscriptLine
+	history []llm.Message
+	timer   *time.Timer
+}
+
+// heldCalls keeps calls whose streams dropped for a grace period, so a
+// stream that reconnects to this instance picks up where the call left
+// off without a shared store. A nil heldCalls holds nothing.
+type heldCalls struct {
+	grace time.Duration
+
+	mu    sync.Mutex
+	calls map[string]*heldCall
+}
+
+// newHeldCalls returns calls held for grace, or nil for a grace of 0.
+func newHeldCalls(grace time.Duration) *heldCalls {
+	if grace <= 0 {
+		return nil
+	}
+	return &heldCalls{grace: grace, calls: make(map[string]*heldCall)}
+}
+
+// Hold keeps a call's transcript and conversation until its stream
+// reconnects or the grace period runs out.
+func (h *heldCalls) Hold(callSID string, lines []TranscriptLine, history []llm.Message) {
+	if h == nil || callSID == "" {
+		return
+	}
+	held := &heldCall{lines: lines, history: history}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if old, ok := h.calls[callSID]; ok {
+		old.timer.Stop()
+	}
+	held.timer = time.AfterFunc(h.grace, func() {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		if h.calls[callSID] == held {
+			delete(h.calls, callSID)
+		}
+	})
+	h.calls[callSID] = held
+}
+
+// Holding reports whether a call is being held.
+func (h *heldCalls) Holding(callSID string) bool {
+	if h == nil {
+		return false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, ok := h.calls[callSID]
+	return ok
+}
+
+// Take returns a held call's transcript and conversation, and stops
+// holding it.
+func (h *heldCalls) Take(callSID string) ([]TranscriptLine, []llm.Message, bool) {
+	if h == nil {
+		return nil, nil, false
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	held, ok := h.calls[callSID]
+	if !ok {
+		return nil, nil, false
+	}
+	held.timer.Stop()
+	delete(h.calls, callSID)
+	return held.lines, held.history, true
+}
+
+// agentHistory returns the agent's conversation in a session, if it keeps
+// one.
+func agentHistory(a agent.Agent, sessionID string) []llm.Message {
+	if r, ok := a.(agent.Resumer); ok {
+		return r.History(sessionID)
+	}
+	return nil
+}
+
+// keepaliveConnection notes when audio last arrived from the caller.
+// Twilio streams audio continuously, silence included, so a stream that
+// goes quiet has dropped even if its socket hasn't noticed.
+type keepaliveConnection struct {
+	transport.Connection
+	reader *keepaliveReader
+}
+
+// newKeepaliveConnection wraps conn, counting from now.
+func newKeepaliveConnection(conn transport.Connection) *keepaliveConnection {
+	r := &keepaliveReader{src: conn.AudioOut()}
+	r.last.Store(time.Now().UnixNano())
+	return &keepaliveConnection{Connection: conn, reader: r}
+}
+
+// AudioOut returns the caller's audio.
+func (c *keepaliveConnection) AudioOut() io.Reader {
+	return c.reader
+}
+
+// Quiet returns how long it has been since audio arrived.
+func (c *keepaliveConnection) Quiet() time.Duration {
+	return time.Duration(time.Now().UnixNano() - c.reader.last.Load())
+}
+
+type keepaliveReader struct {
+	src  io.Reader
+	last atomic.Int64 // unix nanoseconds
+}
+
+func (r *keepaliveReader) Read(p []byte) (int, error) {
+	n, err := r.src.Read(p)
+	if n > 0 {
+		r.last.Store(time.Now().UnixNano())
+	}
+	return n, err
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/dnc"
+	"github.com/agentplexus/omnivoice-examples/kit/phone"
+	"github.com/agentplexus/omnivoice-examples/kit/twiml"
+)
+
+// CallSession gives agent logic the call's context and control over its
+// telephony side: ending it, handing it to other TwiML, and recording it.
+// Audio keeps flowing over Media Streams; call control goes through the
+// Twilio REST API.
+type CallSession struct {
+	ID      string
+	CallSID string
+
+	// Metadata is the context passed in with the call (SIP headers,
+	// stream parameters).
+	Metadata SessionMetadata
+
+	twilio *twilioClient
+	cdr    *CallDetailRecord
+	logger *slog.Logger
+	// dial, if set, is checked before every outbound dial.
+	dial *dnc.Gate
+	// recordingStatusURL, if set, is told when each recording is complete,
+	// for the recording to be archived.
+	recordingStatusURL string
+
+	mu           sync.Mutex
+	recordingSID string
+	// recordingStarted is when the first recording started; the call's
+	// captions are timed from it.
+	recordingStarted time.Time
+	degradation      DegradationLevel
+}
+
+// newCallSession creates the call controls for a session.
+func newCallSession(sessionID, callSID string, twilio *twilioClient, cdr *CallDetailRecord, logger *slog.Logger) *CallSession {
+	return &CallSession{ID: sessionID, CallSID: callSID, twilio: twilio, cdr: cdr, logger: logger}
+}
+
+// EndCall hangs up the call. The Media Stream closes shortly after, which
+// ends the session.
+func (s *CallSession) EndCall(ctx context.Context) error {
+	s.logger.Info("ending call")
+	if err := s.twilio.EndCall(ctx, s.CallSID); err != nil {
+		return err
+	}
+	s.setEndedBy("agent")
+	return nil
+}
+
+// Redirect replaces the call's TwiML, e.g. to <Dial> a human agent or
+// <Enqueue> the caller. The new TwiML replaces the <Connect><Stream>, so
+// the session ends once Twilio applies it.
+func (s *CallSession) Redirect(ctx context.Context, twiml string) error {
+	s.logger.Info("redirecting call to TwiML")
+	if err := s.twilio.RedirectCall(ctx, s.CallSID, twiml); err != nil {
+		return err
+	}
+	s.setEndedBy("redirect")
+	return nil
+}
+
+// RedirectURL is like Redirect but has Twilio fetch the TwiML from a URL.
+func (s *CallSession) RedirectURL(ctx context.Context, twimlURL string) error {
+	s.logger.Info("redirecting call", "url", twimlURL)
+	if err := s.twilio.RedirectCallURL(ctx, s.CallSID, twimlURL); err != nil {
+		return err
+	}
+	s.setEndedBy("redirect")
+	return nil
+}
+
+// Transfer dials a human at number (a phone number or SIP URI) and ends the agent's
+// part of the call. When coachStreamURL is set, a listen-only Media Stream
+// of the caller's audio is started there first so the agent can coach the
+// human; only do this with the caller's consent.
+func (s *CallSession) Transfer(ctx context.Context, number phone.Number, coachStreamURL string) error {
+	if err := s.CheckDial(ctx, number, "transfer"); err != nil {
+		return err
+	}
+	s.logger.Info("transferring call", "to", number, "coaching", coachStreamURL != "")
+	if err := s.twilio.RedirectCall(ctx, s.CallSID, transferTwiML(number, coachStreamURL, s.CallSID)); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cdr.EndedBy = "transfer"
+	s.cdr.TransferredTo = number.String()
+	s.cdr.Coached = coachStreamURL != ""
+	return nil
+}
+
+// CheckDial returns an error if the do-not-call gate suppresses dialing
+// number for purpose. Suppressed attempts are logged and audited.
+func (s *CallSession) CheckDial(ctx context.Context, number phone.Number, purpose string) error {
+	if s.dial == nil {
+		return nil
+	}
+	if err := s.dial.Check(ctx, number.Address(), purpose, s.CallSID); err != nil {
+		s.logger.Warn("outbound dial suppressed", "to", number, "purpose", purpose, "reason", err)
+		return err
+	}
+	return nil
+}
+
+// transferTwiML dials number, first starting a listen-only coaching stream
+// of the caller's audio at coachStreamURL if set. An extension is sent as
+// digits once the call is answered.
+func transferTwiML(number phone.Number, coachStreamURL, callSID string) string {
+	var doc twiml.Response
+	if coachStreamURL != "" {
+		doc = append(doc, twiml.Start{Stream: twiml.Stream{
+			URL:        coachStreamURL,
+			Track:      "inbound_track",
+			Parameters: map[string]string{paramMode: modeCoach, paramCallSID: callSID},
+		}})
+	}
+	dial := twiml.Dial{Number: number.Address()}
+	switch {
+	case number.IsSIP():
+		dial = twiml.Dial{SIP: number.Address()}
+	case number.Extension != "":
+		// Each w waits half a second for the far end to answer
+		dial.SendDigits = "ww" + number.Extension
+	}
+	return append(doc, dial).String()
+}
+
+// StartRecording starts a dual-channel recording of the call. Starting a
+// recording while one is in progress is a no-op.
+func (s *CallSession) StartRecording(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.recordingSID != "" {
+		return nil
+	}
+
+	sid, err := s.twilio.StartRecording(ctx, s.CallSID, s.recordingStatusURL)
+	if err != nil {
+		return err
+	}
+	s.recordingSID = sid
+	if s.recordingStarted.IsZero() {
+		s.recordingStarted = time.Now()
+	}
+	s.cdr.RecordingSIDs = append(s.cdr.RecordingSIDs, sid)
+	s.logger.Info("recording started", "recording_sid", sid)
+	return nil
+}
+
+// RecordingStarted returns when the call's first recording started, or
+// the zero time if it hasn't been recorded.
+func (s *CallSession) RecordingStarted() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.recordingStarted
+}
+
+// StopRecording stops the recording started by StartRecording.
+func (s *CallSession) StopRecording(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.recordingSID == "" {
+		return errors.New("no recording in progress")
+	}
+
+	if err := s.twilio.StopRecording(ctx, s.CallSID, s.recordingSID); err != nil {
+		return err
+	}
+	s.logger.Info("recording stopped", "recording_sid", s.recordingSID)
+	s.recordingSID = ""
+	return nil
+}
+
+// PauseRecording pauses the recording, if one is in progress, e.g. while
+// the caller keys in card details. It returns whether it paused one, to be
+// resumed with ResumeRecording.
+func (s *CallSession) PauseRecording(ctx context.Context) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.recordingSID == "" {
+		return false, nil
+	}
+	if err := s.twilio.PauseRecording(ctx, s.CallSID, s.recordingSID); err != nil {
+		return false, err
+	}
+	s.logger.Info("recording paused", "recording_sid", s.recordingSID)
+	return true, nil
+}
+
+// ResumeRecording resumes the recording paused by PauseRecording.
+func (s *CallSession) ResumeRecording(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.recordingSID == "" {
+		return errors.New("no recording in progress")
+	}
+	if err := s.twilio.ResumeRecording(ctx, s.CallSID, s.recordingSID); err != nil {
+		return err
+	}
+	s.logger.Info("recording resumed", "recording_sid", s.recordingSID)
+	return nil
+}
+
+// Recording reports whether a recording is in progress.
+func (s *CallSession) Recording() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.recordingSID != ""
+}
+
+// endedBy returns who ended the call so far: "caller" unless the agent,
+// an operator or a transfer ended it.
+func (s *CallSession) endedBy() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cdr.EndedBy
+}
+
+func (s *CallSession) setEndedBy(endedBy string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cdr.EndedBy = endedBy
+}
+
+// bargedIn counts the caller interrupting the agent.
+func (s *CallSession) bargedIn() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cdr.BargeIns++
+}
+
+// degraded records that a turn was served at level, keeping the lowest.
+func (s *CallSession) degraded(level DegradationLevel) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if level > s.degradation {
+		s.degradation = level
+		s.cdr.Degradation = level.String()
+	}
+}
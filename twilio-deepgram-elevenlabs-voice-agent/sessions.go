@@ -0,0 +1,420 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/twiml"
+)
+
+// SessionLimits caps the calls the server takes on. Calls over a limit are
+// answered with a short message and hung up at the voice webhook, before
+// any provider is used.
+type SessionLimits struct {
+	// MaxConcurrent caps agent calls in progress. Zero is unlimited.
+	MaxConcurrent int
+
+	// CallerCalls is how many calls one number may place per CallerWindow.
+	// Zero is unlimited.
+	CallerCalls  int
+	CallerWindow time.Duration
+
+	// BusyMessage and RateLimitedMessage are spoken to rejected callers.
+	// Empty hangs up without a message.
+	BusyMessage        string
+	RateLimitedMessage string
+
+	// QueueSize, if set, holds up to that many calls over MaxConcurrent in
+	// a queue instead of turning them away, admitting them in order as
+	// calls end. Only calls over a full queue hear BusyMessage.
+	QueueSize int
+	// QueueWait is the longest a call is held before it is given
+	// BusyMessage and hung up.
+	QueueWait time.Duration
+	// HoldMusic is the URL of the audio played to queued callers, and
+	// QueueMessage is said before each play of it, with {position}
+	// replaced by the caller's place in the queue.
+	HoldMusic    string
+	QueueMessage string
+}
+
+// defaultSessionLimits returns the limits used unless overridden by
+// MAX_CONCURRENT_CALLS, CALLER_RATE_LIMIT, BUSY_MESSAGE,
+// RATE_LIMITED_MESSAGE, QUEUE_SIZE, QUEUE_MAX_WAIT, HOLD_MUSIC_URL and
+// QUEUE_MESSAGE.
+func defaultSessionLimits() SessionLimits {
+	return SessionLimits{
+		CallerWindow:       10 * time.Minute,
+		BusyMessage:        "Sorry, all of our lines are busy right now. Please call back in a few minutes.",
+		RateLimitedMessage: "Sorry, we can't take another call from this number right now. Please try again later.",
+		QueueWait:          10 * time.Minute,
+		HoldMusic:          "http://com.twilio.music.classical.s3.amazonaws.com/BusyStrings.mp3",
+		QueueMessage:       "All of our agents are busy. You are number {position} in line. Please hold.",
+	}
+}
+
+// sessionLimitsFromEnv applies environment overrides to the default limits.
+// CALLER_RATE_LIMIT is calls per window, e.g. "5/10m".
+func sessionLimitsFromEnv() (SessionLimits, error) {
+	limits := defaultSessionLimits()
+	if v := os.Getenv("MAX_CONCURRENT_CALLS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return limits, fmt.Errorf("invalid MAX_CONCURRENT_CALLS: %q", v)
+		}
+		limits.MaxConcurrent = n
+	}
+	if v := os.Getenv("CALLER_RATE_LIMIT"); v != "" {
+		calls, window, ok := strings.Cut(v, "/")
+		n, err := strconv.Atoi(calls)
+		if !ok || err != nil || n < 0 {
+			return limits, fmt.Errorf("invalid CALLER_RATE_LIMIT: %q", v)
+		}
+		d, err := time.ParseDuration(window)
+		if err != nil || d <= 0 {
+			return limits, fmt.Errorf("invalid CALLER_RATE_LIMIT: %q", v)
+		}
+		limits.CallerCalls, limits.CallerWindow = n, d
+	}
+	if v, ok := os.LookupEnv("BUSY_MESSAGE"); ok {
+		limits.BusyMessage = v
+	}
+	if v, ok := os.LookupEnv("RATE_LIMITED_MESSAGE"); ok {
+		limits.RateLimitedMessage = v
+	}
+	if v := os.Getenv("QUEUE_SIZE"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return limits, fmt.Errorf("invalid QUEUE_SIZE: %q", v)
+		}
+		limits.QueueSize = n
+	}
+	if v := os.Getenv("QUEUE_MAX_WAIT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return limits, fmt.Errorf("invalid QUEUE_MAX_WAIT: %q", v)
+		}
+		limits.QueueWait = d
+	}
+	if v := os.Getenv("HOLD_MUSIC_URL"); v != "" {
+		limits.HoldMusic = v
+	}
+	if v, ok := os.LookupEnv("QUEUE_MESSAGE"); ok {
+		limits.QueueMessage = v
+	}
+	return limits, nil
+}
+
+// Reasons a call is refused.
+var (
+	errDraining    = errors.New("server is draining")
+	errAtCapacity  = errors.New("maximum concurrent calls reached")
+	errRateLimited = errors.New("caller rate limit exceeded")
+)
+
+// reservationTTL bounds how long a call accepted at the webhook holds a
+// slot while its Media Stream connects.
+const reservationTTL = time.Minute
+
+// modeAgent is the mode of sessions answered by the agent.
+const modeAgent = "agent"
+
+// SessionInfo describes a session in progress.
+type SessionInfo struct {
+	ID        string    `json:"id"`
+	CallSID   string    `json:"call_sid"`
+	From      string    `json:"from,omitempty"`
+	To        string    `json:"to,omitempty"`
+	Mode      string    `json:"mode"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+type managedSession struct {
+	info     SessionInfo
+	shutdown func()
+	call     *liveCall
+}
+
+// SessionManager tracks the sessions in progress. It admits new calls
+// within the concurrency cap and per-caller rate limit, refuses them once
+// draining, and lets shutdown wait for the calls still up.
+type SessionManager struct {
+	limits SessionLimits
+
+	mu       sync.Mutex
+	sessions map[string]*managedSession
+	// reserved holds the expiry of calls accepted at the webhook whose
+	// Media Stream has not connected yet, by call SID.
+	reserved map[string]time.Time
+	// calls holds recent call times by caller, for rate limiting.
+	calls map[string][]time.Time
+	// queue holds the calls waiting for a slot, oldest first, and
+	// dequeued the metadata of those given one, by call SID, until their
+	// Media Stream connects. admitted is told of each call given a slot.
+	queue    []*queuedCall
+	dequeued map[string]SessionMetadata
+	admitted func(callSID string)
+	draining bool
+	idle     chan struct{} // closed when draining and no sessions remain
+}
+
+// NewSessionManager creates a manager enforcing limits.
+func NewSessionManager(limits SessionLimits) *SessionManager {
+	return &SessionManager{
+		limits:   limits,
+		sessions: make(map[string]*managedSession),
+		reserved: make(map[string]time.Time),
+		calls:    make(map[string][]time.Time),
+		dequeued: make(map[string]SessionMetadata),
+		idle:     make(chan struct{}),
+	}
+}
+
+// Reserve accepts a call at the voice webhook, holding a slot for it until
+// its Media Stream connects. It returns errDraining, errAtCapacity or
+// errRateLimited when the call should be refused.
+func (m *SessionManager) Reserve(callSID, caller string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.draining {
+		return errDraining
+	}
+	now := time.Now()
+	m.expire(now)
+	if _, ok := m.reserved[callSID]; ok {
+		// Twilio retried the webhook, or the call was let in from the
+		// queue
+		return nil
+	}
+	if m.atCapacity() || len(m.queue) > 0 {
+		return errAtCapacity
+	}
+	if !m.allowCaller(caller, now) {
+		return errRateLimited
+	}
+	m.reserved[callSID] = now.Add(reservationTTL)
+	return nil
+}
+
+// Admit registers a new agent session with the function that ends it at
+// the drain deadline. A call reserved at the webhook is always admitted
+// unless draining; one that wasn't (its reservation expired, or the stream
+// was started some other way) is held to the concurrency cap.
+func (m *SessionManager) Admit(info SessionInfo, shutdown func()) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.draining {
+		return errDraining
+	}
+	_, reserved := m.reserved[info.CallSID]
+	delete(m.reserved, info.CallSID)
+	if !reserved && m.atCapacity() {
+		return errAtCapacity
+	}
+	m.add(info, shutdown)
+	return nil
+}
+
+// Track registers a session even while draining and regardless of limits,
+// for streams that belong to a call already in progress.
+func (m *SessionManager) Track(info SessionInfo, shutdown func()) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.add(info, shutdown)
+}
+
+// Done removes a finished session, letting the next queued call in.
+func (m *SessionManager) Done(id string) {
+	m.mu.Lock()
+	delete(m.sessions, id)
+	m.checkIdle()
+	admitted := m.promote(time.Now())
+	m.mu.Unlock()
+	m.notify(admitted)
+}
+
+// List returns the sessions in progress, oldest first.
+func (m *SessionManager) List() []SessionInfo {
+	m.mu.Lock()
+	list := make([]SessionInfo, 0, len(m.sessions))
+	for _, s := range m.sessions {
+		list = append(list, s.info)
+	}
+	m.mu.Unlock()
+
+	slices.SortFunc(list, func(a, b SessionInfo) int { return a.StartedAt.Compare(b.StartedAt) })
+	return list
+}
+
+// Draining reports whether new sessions are being refused.
+func (m *SessionManager) Draining() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.draining
+}
+
+// Drain stops admitting sessions and returns how many are in progress.
+func (m *SessionManager) Drain() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.draining {
+		m.draining = true
+		m.checkIdle()
+	}
+	return len(m.sessions)
+}
+
+// Wait blocks until every session has ended after Drain, or ctx is done.
+func (m *SessionManager) Wait(ctx context.Context) error {
+	select {
+	case <-m.idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// ShutdownAll asks every remaining session to end and returns how many
+// there were.
+func (m *SessionManager) ShutdownAll() int {
+	m.mu.Lock()
+	shutdowns := make([]func(), 0, len(m.sessions))
+	for _, s := range m.sessions {
+		shutdowns = append(shutdowns, s.shutdown)
+	}
+	m.mu.Unlock()
+
+	for _, shutdown := range shutdowns {
+		shutdown()
+	}
+	return len(shutdowns)
+}
+
+// Get returns a session in progress.
+func (m *SessionManager) Get(id string) (SessionInfo, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok {
+		return SessionInfo{}, false
+	}
+	return s.info, true
+}
+
+// Attach makes an agent session's transcript and controls available to the
+// admin API.
+func (m *SessionManager) Attach(id string, call *liveCall) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if s, ok := m.sessions[id]; ok {
+		s.call = call
+	}
+}
+
+// Live returns the transcript and controls attached to a session.
+func (m *SessionManager) Live(id string) (*liveCall, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.sessions[id]
+	if !ok || s.call == nil {
+		return nil, false
+	}
+	return s.call, true
+}
+
+// Status returns how many calls accepted at the webhook are still
+// connecting, how many are queued, and whether the manager is draining.
+func (m *SessionManager) Status() (connecting, queued int, draining bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.reserved), len(m.queue), m.draining
+}
+
+// add registers a session. m.mu must be held.
+func (m *SessionManager) add(info SessionInfo, shutdown func()) {
+	if info.StartedAt.IsZero() {
+		info.StartedAt = time.Now()
+	}
+	m.sessions[info.ID] = &managedSession{info: info, shutdown: shutdown}
+}
+
+// atCapacity reports whether agent calls in progress and reserved fill the
+// concurrency cap. Coaching streams don't count: their call has already
+// left the agent. m.mu must be held.
+func (m *SessionManager) atCapacity() bool {
+	if m.limits.MaxConcurrent <= 0 {
+		return false
+	}
+	active := len(m.reserved)
+	for _, s := range m.sessions {
+		if s.info.Mode == modeAgent {
+			active++
+		}
+	}
+	return active >= m.limits.MaxConcurrent
+}
+
+// allowCaller records a call from caller unless they have used up their
+// calls for the window. m.mu must be held.
+func (m *SessionManager) allowCaller(caller string, now time.Time) bool {
+	if m.limits.CallerCalls <= 0 || caller == "" {
+		return true
+	}
+	recent := m.calls[caller]
+	if len(recent) >= m.limits.CallerCalls {
+		return false
+	}
+	m.calls[caller] = append(recent, now)
+	return true
+}
+
+// expire drops stale reservations and call times outside the rate limit
+// window. m.mu must be held.
+func (m *SessionManager) expire(now time.Time) {
+	for callSID, expiry := range m.reserved {
+		if now.After(expiry) {
+			delete(m.reserved, callSID)
+			delete(m.dequeued, callSID)
+		}
+	}
+	m.expireQueue(now)
+	cutoff := now.Add(-m.limits.CallerWindow)
+	for caller, times := range m.calls {
+		i := 0
+		for i < len(times) && !times[i].After(cutoff) {
+			i++
+		}
+		if i == len(times) {
+			delete(m.calls, caller)
+		} else {
+			m.calls[caller] = times[i:]
+		}
+	}
+}
+
+// checkIdle closes idle once draining with no sessions left. m.mu must be held.
+func (m *SessionManager) checkIdle() {
+	if m.draining && len(m.sessions) == 0 {
+		select {
+		case <-m.idle:
+		default:
+			close(m.idle)
+		}
+	}
+}
+
+// hangupTwiML says message, if it has any text, and hangs up.
+func hangupTwiML(message twiml.Say) string {
+	var doc twiml.Response
+	if message.Text != "" {
+		doc = append(doc, message)
+	}
+	return append(doc, twiml.Hangup{}).String()
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+)
+
+// silenceCheckInterval is how often a silenceWatch checks the call.
+const silenceCheckInterval = 250 * time.Millisecond
+
+// SilencePolicy decides what happens when a caller goes quiet, and how
+// long any call may last. A zero duration turns its behavior off.
+type SilencePolicy struct {
+	// PromptAfter is how long the caller may stay silent, once the agent
+	// has finished speaking, before Prompt asks whether they're still
+	// there.
+	PromptAfter time.Duration
+	// HangupAfter is how long the caller may stay silent before
+	// ClosingLine is spoken and the call ended via the Twilio REST API.
+	// It counts from the same moment as PromptAfter.
+	HangupAfter time.Duration
+	Prompt      string
+	ClosingLine string
+	// MaxDuration is the longest a call may last; at that point
+	// MaxDurationLine is spoken and the call ended, whatever is going on.
+	MaxDuration     time.Duration
+	MaxDurationLine string
+}
+
+// defaultSilencePolicy returns the lines used unless overridden by
+// SILENCE_PROMPT, SILENCE_CLOSING_LINE and MAX_CALL_CLOSING_LINE. The
+// durations are set from the config's timeouts.silence_prompt,
+// timeouts.silence_hangup and timeouts.max_call (SILENCE_PROMPT_TIMEOUT,
+// SILENCE_HANGUP_TIMEOUT and MAX_CALL_DURATION).
+func defaultSilencePolicy() SilencePolicy {
+	return SilencePolicy{
+		Prompt:          "Are you still there?",
+		ClosingLine:     "I haven't heard from you, so I'll end the call here. Feel free to call back any time. Goodbye!",
+		MaxDurationLine: "I'm sorry, we've reached the time limit for this call, so I'll have to end it here. Please call back if you need anything else. Goodbye!",
+	}
+}
+
+// silencePolicyFromEnv applies environment overrides to the default policy.
+func silencePolicyFromEnv() SilencePolicy {
+	policy := defaultSilencePolicy()
+	if v := os.Getenv("SILENCE_PROMPT"); v != "" {
+		policy.Prompt = v
+	}
+	if v := os.Getenv("SILENCE_CLOSING_LINE"); v != "" {
+		policy.ClosingLine = v
+	}
+	if v := os.Getenv("MAX_CALL_CLOSING_LINE"); v != "" {
+		policy.MaxDurationLine = v
+	}
+	return policy
+}
+
+// silenceWatch times how long a caller has been silent. The clock runs
+// only while nobody is talking: it restarts whenever the caller speaks,
+// and is held while the agent is busy answering, except with the prompt
+// asking whether they're still there.
+type silenceWatch struct {
+	policy SilencePolicy
+
+	mu       sync.Mutex
+	last     time.Time
+	speaking bool
+	prompted bool
+}
+
+// newSilenceWatch returns a watch that counts from now.
+func newSilenceWatch(policy SilencePolicy) *silenceWatch {
+	return &silenceWatch{policy: policy, last: time.Now()}
+}
+
+// Speaking records that the caller started or stopped speaking.
+func (w *silenceWatch) Speaking(speaking bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.speaking = speaking
+	w.heard()
+}
+
+// Heard records that the caller said something.
+func (w *silenceWatch) Heard() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.heard()
+}
+
+func (w *silenceWatch) heard() {
+	w.last = time.Now()
+	w.prompted = false
+}
+
+// Run checks the call until ctx is done or the call is ended for
+// silence. busy reports whether the agent is speaking or working out a
+// reply, or otherwise not waiting on the caller. prompt is called once
+// per silence after PromptAfter, and hangup after HangupAfter.
+func (w *silenceWatch) Run(ctx context.Context, busy func() bool, prompt, hangup func()) {
+	if w.policy.PromptAfter <= 0 && w.policy.HangupAfter <= 0 {
+		return
+	}
+	ticker := time.NewTicker(silenceCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		agentBusy := busy()
+		w.mu.Lock()
+		now := time.Now()
+		if w.speaking || agentBusy && !w.prompted {
+			w.last = now
+		}
+		silent := now.Sub(w.last)
+		ask := w.policy.PromptAfter > 0 && silent >= w.policy.PromptAfter && !w.prompted
+		if ask {
+			w.prompted = true
+		}
+		w.mu.Unlock()
+
+		switch {
+		case w.policy.HangupAfter > 0 && silent >= w.policy.HangupAfter:
+			hangup()
+			return
+		case ask:
+			prompt()
+		}
+	}
+}
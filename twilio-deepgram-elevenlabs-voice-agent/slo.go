@@ -0,0 +1,466 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultSLOs are the objectives checked unless SLOS says otherwise: 95%
+// of replies start within 1.5s of the caller finishing, and fewer than 1%
+// of turns fail, each over the last five minutes.
+const defaultSLOs = "p95<1.5s/5m,error_rate<1%/5m"
+
+// defaultSLOWindow is the window of objectives that don't give one.
+const defaultSLOWindow = 5 * time.Minute
+
+// sloWebhookTimeout bounds each alert webhook request.
+const sloWebhookTimeout = 10 * time.Second
+
+// SLO is a service level objective: a latency percentile or the turn error
+// rate, kept under a threshold over a rolling window.
+type SLO struct {
+	// Name is the objective as written, e.g. "p95<1.5s/5m".
+	Name string
+	// Stage and Percentile select the latency measured, e.g. the 0.95
+	// percentile of "total". With Stage empty, the objective is the error
+	// rate.
+	Stage      string
+	Percentile float64
+	MaxLatency time.Duration
+	// MaxErrorRate is the highest acceptable share of failed turns.
+	MaxErrorRate float64
+	Window       time.Duration
+}
+
+// SLOConfig configures SLO evaluation and alerting.
+type SLOConfig struct {
+	Objectives []SLO
+	// MinSamples is how many turns a window needs before its objectives
+	// are judged, so a single slow turn on a quiet server doesn't alert.
+	MinSamples int
+	// Interval is how often objectives are evaluated.
+	Interval time.Duration
+	// WebhookURL, if set, is POSTed a JSON alert when an objective is
+	// breached and when it recovers. Alerts are always logged.
+	WebhookURL string
+}
+
+// defaultSLOConfig returns the configuration used unless overridden by
+// SLOS, SLO_MIN_SAMPLES, SLO_CHECK_INTERVAL and SLO_ALERT_WEBHOOK.
+func defaultSLOConfig() SLOConfig {
+	objectives, err := parseSLOs(defaultSLOs)
+	if err != nil {
+		panic(err)
+	}
+	return SLOConfig{
+		Objectives: objectives,
+		MinSamples: 20,
+		Interval:   30 * time.Second,
+	}
+}
+
+// sloConfigFromEnv applies environment overrides to the defaults. SLOS is
+// a comma-separated list of objectives, or "off" to disable them all.
+func sloConfigFromEnv() (SLOConfig, error) {
+	cfg := defaultSLOConfig()
+	if v := os.Getenv("SLOS"); v != "" {
+		objectives, err := parseSLOs(v)
+		if err != nil {
+			return cfg, fmt.Errorf("invalid SLOS: %w", err)
+		}
+		cfg.Objectives = objectives
+	}
+	if v := os.Getenv("SLO_MIN_SAMPLES"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return cfg, fmt.Errorf("invalid SLO_MIN_SAMPLES: %q", v)
+		}
+		cfg.MinSamples = n
+	}
+	if v := os.Getenv("SLO_CHECK_INTERVAL"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return cfg, fmt.Errorf("invalid SLO_CHECK_INTERVAL: %q", v)
+		}
+		cfg.Interval = d
+	}
+	cfg.WebhookURL = os.Getenv("SLO_ALERT_WEBHOOK")
+	return cfg, nil
+}
+
+// parseSLOs parses a comma-separated list of objectives, each
+// "metric<threshold[/window]":
+//
+//	p95<1.5s/5m        95th percentile total turn latency under 1.5s over 5 minutes
+//	agent.p99<2s/15m   the same for one stage (stt, agent, tts or transport)
+//	error_rate<1%/5m   under 1% of turns failed (or error_rate<0.01)
+//
+// "off" or "none" is no objectives.
+func parseSLOs(s string) ([]SLO, error) {
+	if v := strings.TrimSpace(s); v == "off" || v == "none" {
+		return nil, nil
+	}
+	var objectives []SLO
+	for _, field := range strings.Split(s, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		slo, err := parseSLO(field)
+		if err != nil {
+			return nil, err
+		}
+		objectives = append(objectives, slo)
+	}
+	return objectives, nil
+}
+
+// parseSLO parses one objective.
+func parseSLO(s string) (SLO, error) {
+	metric, rest, ok := strings.Cut(s, "<")
+	if !ok {
+		return SLO{}, fmt.Errorf("%q: want metric<threshold[/window]", s)
+	}
+	slo := SLO{Name: s, Window: defaultSLOWindow}
+	threshold, window, hasWindow := strings.Cut(rest, "/")
+	if hasWindow {
+		d, err := time.ParseDuration(window)
+		if err != nil || d <= 0 {
+			return SLO{}, fmt.Errorf("%q: invalid window %q", s, window)
+		}
+		slo.Window = d
+	}
+
+	metric = strings.TrimSpace(metric)
+	if metric == "error_rate" {
+		rate, err := parseRate(threshold)
+		if err != nil {
+			return SLO{}, fmt.Errorf("%q: %w", s, err)
+		}
+		slo.MaxErrorRate = rate
+		return slo, nil
+	}
+
+	stage, p, ok := strings.Cut(metric, ".")
+	if !ok {
+		stage, p = stageTotal, metric
+	}
+	if !slices.Contains(latencyStages, stage) {
+		return SLO{}, fmt.Errorf("%q: unknown stage %q (want one of %s)", s, stage, strings.Join(latencyStages, ", "))
+	}
+	percentile, err := strconv.ParseFloat(strings.TrimPrefix(p, "p"), 64)
+	if !strings.HasPrefix(p, "p") || err != nil || percentile <= 0 || percentile >= 100 {
+		return SLO{}, fmt.Errorf("%q: unknown metric %q (want pNN, stage.pNN or error_rate)", s, metric)
+	}
+	d, err := time.ParseDuration(threshold)
+	if err != nil || d <= 0 {
+		return SLO{}, fmt.Errorf("%q: invalid latency %q", s, threshold)
+	}
+	slo.Stage, slo.Percentile, slo.MaxLatency = stage, percentile/100, d
+	return slo, nil
+}
+
+// parseRate parses a rate as a percentage ("1%") or a fraction ("0.01").
+func parseRate(s string) (float64, error) {
+	v, percent := strings.CutSuffix(strings.TrimSpace(s), "%")
+	rate, err := strconv.ParseFloat(v, 64)
+	if percent {
+		rate /= 100
+	}
+	if err != nil || rate <= 0 || rate >= 1 {
+		return 0, fmt.Errorf("invalid error rate %q", s)
+	}
+	return rate, nil
+}
+
+// SLOStatus is an objective's standing over its current window.
+type SLOStatus struct {
+	Name    string `json:"name"`
+	Window  string `json:"window"`
+	Samples int    `json:"samples"`
+	// Value and Threshold are milliseconds for latency objectives and a
+	// fraction of turns for the error rate. Value is omitted while the
+	// window has too few samples to judge.
+	Value     *float64   `json:"value,omitempty"`
+	Threshold float64    `json:"threshold"`
+	Unit      string     `json:"unit"`
+	Breached  bool       `json:"breached"`
+	Since     *time.Time `json:"since,omitempty"`
+}
+
+// sloTurn is one completed turn's stage latencies.
+type sloTurn struct {
+	at     time.Time
+	stages map[string]time.Duration
+}
+
+// SLOMonitor evaluates objectives over rolling windows of recent turns and
+// alerts, by log and optionally webhook, when one is breached and again
+// when it recovers. A nil monitor records nothing.
+type SLOMonitor struct {
+	cfg    SLOConfig
+	client *http.Client
+	now    func() time.Time
+
+	mu       sync.Mutex
+	turns    []sloTurn
+	errors   []time.Time
+	breached map[string]time.Time // objective name → breached since
+}
+
+// NewSLOMonitor creates a monitor for cfg, or returns nil if cfg has no
+// objectives.
+func NewSLOMonitor(cfg SLOConfig) *SLOMonitor {
+	if len(cfg.Objectives) == 0 {
+		return nil
+	}
+	return &SLOMonitor{
+		cfg:      cfg,
+		client:   &http.Client{Timeout: sloWebhookTimeout},
+		now:      time.Now,
+		breached: make(map[string]time.Time),
+	}
+}
+
+// RecordTurn records a completed turn's stage latencies.
+func (m *SLOMonitor) RecordTurn(stages map[string]time.Duration) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.turns = append(m.turns, sloTurn{at: m.now(), stages: stages})
+}
+
+// RecordError records a failed turn: the agent, speech synthesis or
+// transcription failed.
+func (m *SLOMonitor) RecordError() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.errors = append(m.errors, m.now())
+}
+
+// Run evaluates the objectives every interval until ctx is done.
+func (m *SLOMonitor) Run(ctx context.Context) {
+	if m == nil {
+		return
+	}
+	ticker := time.NewTicker(m.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			m.Evaluate(ctx)
+		}
+	}
+}
+
+// Evaluate judges every objective over its window, alerting on any that
+// has been breached or has recovered since the last evaluation, and
+// returns their status.
+func (m *SLOMonitor) Evaluate(ctx context.Context) []SLOStatus {
+	m.mu.Lock()
+	now := m.now()
+	m.prune(now)
+	statuses := make([]SLOStatus, 0, len(m.cfg.Objectives))
+	var alerts []SLOStatus
+	for _, slo := range m.cfg.Objectives {
+		status := m.judge(slo, now)
+		since, was := m.breached[slo.Name]
+		switch {
+		case status.Value == nil:
+			// Too few samples to judge: the standing is unchanged
+			status.Breached = was
+		case status.Breached && !was:
+			since = now
+			m.breached[slo.Name] = since
+			alerts = append(alerts, status)
+		case !status.Breached && was:
+			delete(m.breached, slo.Name)
+			alerts = append(alerts, status)
+		}
+		if status.Breached {
+			status.Since = &since
+		}
+		statuses = append(statuses, status)
+	}
+	m.mu.Unlock()
+
+	for _, status := range alerts {
+		m.alert(ctx, status)
+	}
+	return statuses
+}
+
+// judge measures one objective over its window. m.mu must be held.
+func (m *SLOMonitor) judge(slo SLO, now time.Time) SLOStatus {
+	status := SLOStatus{Name: slo.Name, Window: slo.Window.String()}
+	cutoff := now.Add(-slo.Window)
+	turns := 0
+	var latencies []time.Duration
+	for _, t := range m.turns {
+		if t.at.Before(cutoff) {
+			continue
+		}
+		turns++
+		if d, ok := t.stages[slo.Stage]; ok {
+			latencies = append(latencies, d)
+		}
+	}
+
+	if slo.Stage == "" {
+		errs := 0
+		for _, at := range m.errors {
+			if !at.Before(cutoff) {
+				errs++
+			}
+		}
+		status.Samples = turns + errs
+		status.Threshold, status.Unit = slo.MaxErrorRate, "ratio"
+		if status.Samples >= m.cfg.MinSamples {
+			rate := float64(errs) / float64(status.Samples)
+			status.Value = &rate
+			status.Breached = rate >= slo.MaxErrorRate
+		}
+		return status
+	}
+
+	status.Samples = len(latencies)
+	status.Threshold, status.Unit = float64(slo.MaxLatency)/float64(time.Millisecond), "ms"
+	if status.Samples >= m.cfg.MinSamples {
+		slices.Sort(latencies)
+		p := percentileMillis(latencies, slo.Percentile)
+		status.Value = &p
+		status.Breached = p >= status.Threshold
+	}
+	return status
+}
+
+// prune drops samples older than the longest window. m.mu must be held.
+func (m *SLOMonitor) prune(now time.Time) {
+	var longest time.Duration
+	for _, slo := range m.cfg.Objectives {
+		longest = max(longest, slo.Window)
+	}
+	cutoff := now.Add(-longest)
+	i, _ := slices.BinarySearchFunc(m.turns, cutoff, func(t sloTurn, cutoff time.Time) int { return t.at.Compare(cutoff) })
+	m.turns = slices.Delete(m.turns, 0, i)
+	j, _ := slices.BinarySearchFunc(m.errors, cutoff, time.Time.Compare)
+	m.errors = slices.Delete(m.errors, 0, j)
+}
+
+// sloAlert is the JSON POSTed to the alert webhook. Text makes it readable
+// as a chat message by Slack-compatible incoming webhooks.
+type sloAlert struct {
+	Status string    `json:"status"` // "firing" or "resolved"
+	SLO    SLOStatus `json:"slo"`
+	At     time.Time `json:"at"`
+	Text   string    `json:"text"`
+}
+
+// alert logs a breach or recovery and sends it to the webhook, if any.
+func (m *SLOMonitor) alert(ctx context.Context, status SLOStatus) {
+	value := formatSLOValue(*status.Value, status.Unit)
+	threshold := formatSLOValue(status.Threshold, status.Unit)
+	a := sloAlert{Status: "resolved", SLO: status, At: m.now()}
+	if status.Breached {
+		a.Status = "firing"
+		a.Text = fmt.Sprintf("SLO breached: %s is %s (objective %s) over the last %s (%d samples)", status.Name, value, threshold, status.Window, status.Samples)
+		slog.Warn("SLO breached", "slo", status.Name, "value", value, "threshold", threshold, "window", status.Window, "samples", status.Samples)
+	} else {
+		a.Text = fmt.Sprintf("SLO recovered: %s is %s (objective %s) over the last %s", status.Name, value, threshold, status.Window)
+		slog.Info("SLO recovered", "slo", status.Name, "value", value, "threshold", threshold, "window", status.Window)
+	}
+	if m.cfg.WebhookURL == "" {
+		return
+	}
+	if err := m.send(ctx, a); err != nil {
+		slog.Error("failed to send SLO alert", "slo", status.Name, "error", err)
+	}
+}
+
+// send POSTs an alert to the webhook.
+func (m *SLOMonitor) send(ctx context.Context, a sloAlert) error {
+	body, err := json.Marshal(a)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.cfg.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return errors.New("webhook answered " + resp.Status)
+	}
+	return nil
+}
+
+// formatSLOValue formats a latency in milliseconds or an error rate.
+func formatSLOValue(v float64, unit string) string {
+	if unit == "ratio" {
+		return strconv.FormatFloat(v*100, 'f', 2, 64) + "%"
+	}
+	return (time.Duration(v * float64(time.Millisecond))).Round(time.Millisecond).String()
+}
+
+// ServeHTTP reports the objectives' current status as JSON.
+func (m *SLOMonitor) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]any{
+		"min_samples": m.cfg.MinSamples,
+		"objectives":  m.status(),
+	}); err != nil {
+		slog.Error("failed to write SLO status", "error", err)
+	}
+}
+
+// measure judges one objective over its window without alerting or
+// changing its standing. Value is nil while the window has too few
+// samples.
+func (m *SLOMonitor) measure(slo SLO) SLOStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	m.prune(now)
+	return m.judge(slo, now)
+}
+
+// status returns the objectives' standing without alerting.
+func (m *SLOMonitor) status() []SLOStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	statuses := make([]SLOStatus, 0, len(m.cfg.Objectives))
+	for _, slo := range m.cfg.Objectives {
+		status := m.judge(slo, now)
+		if since, ok := m.breached[slo.Name]; ok {
+			status.Breached, status.Since = true, &since
+		} else {
+			status.Breached = false
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses
+}
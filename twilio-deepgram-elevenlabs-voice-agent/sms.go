@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/agent"
+	"github.com/agentplexus/omnivoice-examples/kit/llm"
+	"github.com/agentplexus/omnivoice-examples/kit/phone"
+)
+
+// toolSendSMS is the tool the agent queues a follow-up text with.
+const toolSendSMS = "send_sms"
+
+// smsMaxLength is the longest message Twilio sends, in characters.
+const smsMaxLength = 1600
+
+// smsSendTimeout bounds sending a call's follow-up texts once it ends.
+const smsSendTimeout = 15 * time.Second
+
+// defaultSMSTemplates are the messages the agent may send unless
+// SMS_TEMPLATES_FILE replaces them.
+var defaultSMSTemplates = map[string]string{
+	"confirmation": "Thanks for calling. Your confirmation number is {{.confirmation}}.",
+	"summary":      "Thanks for calling. Here's a summary of your call: {{.summary}}",
+	"link":         "Thanks for calling. {{.description}}: {{.link}}",
+}
+
+// SMSFollowUp texts callers after their call: a confirmation number, a
+// summary or a link collected during the conversation. The agent chooses
+// a template and fills in its fields with the send_sms tool; messages are
+// sent once the call ends, so they don't arrive while the caller is still
+// talking.
+type SMSFollowUp struct {
+	// From is the number messages are sent from.
+	From string
+	// Templates are the messages the agent may send, by name. Fields are
+	// written {{.name}}; a field the agent doesn't fill in is an error.
+	Templates map[string]*template.Template
+	// MaxPerCall caps how many messages one call may send.
+	MaxPerCall int
+
+	mu sync.Mutex
+	// calls holds the caller and queued messages of each session that can
+	// be texted.
+	calls map[string]*smsCall
+}
+
+type smsCall struct {
+	to       string
+	messages []string
+}
+
+// smsFollowUpFromEnv builds the SMS follow-up. SMS_FROM is the number
+// messages are sent from, read with plan; SMS_TEMPLATES_FILE names a JSON
+// file mapping template names to text, replacing the default templates;
+// SMS_MAX_PER_CALL caps the messages per call (default 2). It returns nil
+// if SMS_FROM isn't set.
+func smsFollowUpFromEnv(plan phone.DialPlan) (*SMSFollowUp, error) {
+	from := os.Getenv("SMS_FROM")
+	if from == "" {
+		return nil, nil
+	}
+	n, err := plan.Parse(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMS_FROM: %w", err)
+	}
+
+	texts := defaultSMSTemplates
+	if path := os.Getenv("SMS_TEMPLATES_FILE"); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SMS_TEMPLATES_FILE: %w", err)
+		}
+		texts = nil
+		if err := json.Unmarshal(data, &texts); err != nil {
+			return nil, fmt.Errorf("invalid SMS_TEMPLATES_FILE: %s: %w", path, err)
+		}
+		if len(texts) == 0 {
+			return nil, fmt.Errorf("invalid SMS_TEMPLATES_FILE: %s: no templates", path)
+		}
+	}
+	f := &SMSFollowUp{
+		From:       n.Address(),
+		Templates:  make(map[string]*template.Template, len(texts)),
+		MaxPerCall: 2,
+		calls:      make(map[string]*smsCall),
+	}
+	for name, text := range texts {
+		t, err := template.New(name).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid SMS template %q: %w", name, err)
+		}
+		f.Templates[name] = t
+	}
+	if v := os.Getenv("SMS_MAX_PER_CALL"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid SMS_MAX_PER_CALL: %q", v)
+		}
+		f.MaxPerCall = n
+	}
+	return f, nil
+}
+
+// Tool returns the send_sms tool, which queues a message for the caller
+// of the session it is called in.
+func (f *SMSFollowUp) Tool() agent.Tool {
+	names := slices.Sorted(maps.Keys(f.Templates))
+	var desc strings.Builder
+	desc.WriteString("Text the caller after the call ends, e.g. a confirmation number, a summary or a link they asked for. Choose a template and give a value for each of its fields. Tell the caller they'll get a text; don't read the message out. Templates:")
+	for _, name := range names {
+		fmt.Fprintf(&desc, "\n- %s: %s", name, f.Templates[name].Root.String())
+	}
+	enum, _ := json.Marshal(names)
+	return agent.Tool{
+		Tool: llm.Tool{
+			Name:        toolSendSMS,
+			Description: desc.String(),
+			Parameters: json.RawMessage(`{"type":"object","properties":{` +
+				`"template":{"type":"string","enum":` + string(enum) + `},` +
+				`"fields":{"type":"object","additionalProperties":{"type":"string"},"description":"The template's fields, by name."}},` +
+				`"required":["template","fields"]}`),
+		},
+		Call: f.queue,
+	}
+}
+
+// queue renders a send_sms call's message and queues it for its session.
+func (f *SMSFollowUp) queue(ctx context.Context, args json.RawMessage) (string, error) {
+	var params struct {
+		Template string            `json:"template"`
+		Fields   map[string]string `json:"fields"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return "", err
+	}
+	t, ok := f.Templates[params.Template]
+	if !ok {
+		return "", fmt.Errorf("no template named %q", params.Template)
+	}
+	var body strings.Builder
+	if err := t.Execute(&body, params.Fields); err != nil {
+		return "", fmt.Errorf("template %q: %w", params.Template, err)
+	}
+	message := strings.TrimSpace(body.String())
+	if message == "" {
+		return "", errors.New("the message is empty")
+	}
+	if len([]rune(message)) > smsMaxLength {
+		return "", fmt.Errorf("the message is longer than %d characters", smsMaxLength)
+	}
+
+	sessionID, _ := agent.SessionID(ctx)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	call, ok := f.calls[sessionID]
+	if !ok {
+		return "The caller's number can't receive text messages.", nil
+	}
+	if len(call.messages) >= f.MaxPerCall {
+		return "No more text messages can be sent on this call.", nil
+	}
+	call.messages = append(call.messages, message)
+	return "The text message will be sent to the caller when the call ends.", nil
+}
+
+// Begin lets the agent text the caller of a session, if their number can
+// receive texts: withheld and non-E.164 caller IDs can't.
+func (f *SMSFollowUp) Begin(sessionID, caller string) {
+	if f == nil || !strings.HasPrefix(caller, "+") {
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls[sessionID] = &smsCall{to: caller}
+}
+
+// End sends the messages queued during a session through client and
+// returns how many were sent.
+func (f *SMSFollowUp) End(ctx context.Context, client *twilioClient, sessionID string, logger *slog.Logger) int {
+	if f == nil {
+		return 0
+	}
+	f.mu.Lock()
+	call := f.calls[sessionID]
+	delete(f.calls, sessionID)
+	f.mu.Unlock()
+	if call == nil {
+		return 0
+	}
+
+	sent := 0
+	for _, message := range call.messages {
+		sid, err := client.SendSMS(ctx, call.to, f.From, message)
+		if err != nil {
+			logger.Warn("failed to send text message", "error", err)
+			continue
+		}
+		logger.Info("text message sent", "message_sid", sid, "length", len([]rune(message)))
+		sent++
+	}
+	return sent
+}
@@ -0,0 +1,409 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/audio"
+	"github.com/agentplexus/omnivoice/stt"
+)
+
+// Why a turn's audio was saved, as recorded with its snippet.
+const (
+	snippetLowConfidence = "low_confidence"
+	snippetCorrection    = "correction"
+)
+
+// snippetBuffer is how much of a stream's audio is kept to cut snippets
+// from; a final transcript is never longer.
+const snippetBuffer = 30 * time.Second
+
+// snippetPending is how many final transcripts may wait to be paired with
+// their turns, e.g. while the session is busy.
+const snippetPending = 8
+
+// SnippetPolicy saves the caller's audio for turns the recognizer may have
+// got wrong, alongside what it heard, so developers can replay exactly what
+// STT was sent. A turn's audio is cut from the stream by the recognizer's
+// word timestamps.
+type SnippetPolicy struct {
+	// Enabled saves snippets; they are stored with the call archive.
+	Enabled bool
+	// Threshold is the confidence, 0 to 1, below which a turn is saved.
+	Threshold float64
+	// Corrections are phrases, matched as whole words, with which a caller
+	// says the agent got them wrong; the turn before is saved.
+	Corrections []string
+	// Padding is audio kept either side of the words.
+	Padding time.Duration
+}
+
+// defaultSnippetPolicy returns the policy used unless overridden by
+// AUDIO_SNIPPETS, AUDIO_SNIPPET_THRESHOLD, AUDIO_SNIPPET_CORRECTIONS and
+// AUDIO_SNIPPET_PADDING. Snippets are off by default.
+func defaultSnippetPolicy() SnippetPolicy {
+	return SnippetPolicy{
+		Threshold: 0.75,
+		Corrections: []string{
+			"i said", "that's not what i said", "that's not what i meant", "you misheard",
+			"you misunderstood", "you didn't understand", "that's wrong", "no i meant",
+		},
+		Padding: 250 * time.Millisecond,
+	}
+}
+
+// snippetPolicyFromEnv applies environment overrides to the defaults.
+func snippetPolicyFromEnv() (SnippetPolicy, error) {
+	p := defaultSnippetPolicy()
+	if v := os.Getenv("AUDIO_SNIPPETS"); v != "" {
+		on, err := strconv.ParseBool(v)
+		if err != nil {
+			return p, fmt.Errorf("invalid AUDIO_SNIPPETS: %q", v)
+		}
+		p.Enabled = on
+	}
+	if v := os.Getenv("AUDIO_SNIPPET_THRESHOLD"); v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil || f < 0 || f > 1 {
+			return p, fmt.Errorf("invalid AUDIO_SNIPPET_THRESHOLD: %q (want 0 to 1)", v)
+		}
+		p.Threshold = f
+	}
+	if v, ok := os.LookupEnv("AUDIO_SNIPPET_CORRECTIONS"); ok {
+		p.Corrections = nil
+		for _, phrase := range strings.Split(v, ",") {
+			if phrase = strings.TrimSpace(phrase); phrase != "" {
+				p.Corrections = append(p.Corrections, phrase)
+			}
+		}
+	}
+	if v := os.Getenv("AUDIO_SNIPPET_PADDING"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return p, fmt.Errorf("invalid AUDIO_SNIPPET_PADDING: %q", v)
+		}
+		p.Padding = d
+	}
+	return p, nil
+}
+
+// corrects reports whether an utterance says the agent got the caller
+// wrong.
+func (p SnippetPolicy) corrects(text string) bool {
+	words := normalizeWords(text)
+	for _, phrase := range p.Corrections {
+		if containsWords(words, normalizeWords(phrase)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Snippet is the caller's audio for one turn, with what the recognizer
+// made of it.
+type Snippet struct {
+	Turn   int    `json:"turn"`
+	Reason string `json:"reason"`
+	// Text is the transcript as recognized, before normalization or any
+	// interceptor.
+	Text       string        `json:"text"`
+	Confidence float64       `json:"confidence,omitempty"`
+	Words      []SnippetWord `json:"words,omitempty"`
+	// StartMs and EndMs are where the audio was cut from the STT stream.
+	StartMs    int64 `json:"start_ms"`
+	EndMs      int64 `json:"end_ms"`
+	SampleRate int   `json:"sample_rate"`
+
+	// pcm is the audio, as 16-bit samples.
+	pcm []int16
+}
+
+// SnippetWord is a recognized word, timed from the start of the snippet's
+// audio.
+type SnippetWord struct {
+	Text       string  `json:"text"`
+	StartMs    int64   `json:"start_ms"`
+	EndMs      int64   `json:"end_ms"`
+	Confidence float64 `json:"confidence,omitempty"`
+}
+
+// WAV returns the snippet's audio as a WAV file.
+func (sn Snippet) WAV() []byte {
+	var b bytes.Buffer
+	_ = audio.WriteWAV(&b, sn.pcm, sn.SampleRate)
+	return b.Bytes()
+}
+
+// heardAudio is a final transcript with the audio it was recognized from.
+type heardAudio struct {
+	text       string
+	confidence float64
+	scored     bool
+	words      []SnippetWord
+	start, end time.Duration
+	sampleRate int
+	pcm        []int16
+	// saved is set once a snippet of the audio has been saved.
+	saved bool
+}
+
+// snippetTrack keeps the audio of a call's final transcripts until they
+// are answered, and of the turn before, for snippets. A nil track keeps
+// nothing.
+type snippetTrack struct {
+	policy SnippetPolicy
+
+	mu sync.Mutex
+	// pending holds the final transcripts not yet paired with a turn,
+	// oldest first.
+	pending []heardAudio
+	// turn and last are the latest turn and its audio, for a caller who
+	// corrects the agent in the turn after.
+	turn int
+	last *heardAudio
+}
+
+// track returns a call's snippet track, or nil if snippets are off.
+func (p SnippetPolicy) track() *snippetTrack {
+	if !p.Enabled {
+		return nil
+	}
+	return &snippetTrack{policy: p}
+}
+
+// stt returns p with its audio and final transcripts kept for the track.
+func (t *snippetTrack) stt(p stt.StreamingProvider) stt.StreamingProvider {
+	if t == nil {
+		return p
+	}
+	return &snippetSTT{StreamingProvider: p, track: t}
+}
+
+// Heard pairs turn with the audio of its final transcript, text as
+// recognized, and returns the snippets to save: the turn itself if the
+// recognizer wasn't sure of it, and the turn before if this one corrects
+// it. Transcripts heard before it that never reached the session are
+// skipped.
+func (t *snippetTrack) Heard(turn int, text string) []Snippet {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var heard *heardAudio
+	for i, h := range t.pending {
+		if h.text == text {
+			heard = &h
+			t.pending = t.pending[i+1:]
+			break
+		}
+	}
+	previous, previousTurn := t.last, t.turn
+	t.turn, t.last = turn, heard
+
+	var snippets []Snippet
+	if previous != nil && len(previous.pcm) > 0 && !previous.saved && previousTurn == turn-1 && t.policy.corrects(text) {
+		snippets = append(snippets, previous.snippet(previousTurn, snippetCorrection))
+	}
+	if heard != nil && len(heard.pcm) > 0 && heard.scored && heard.confidence < t.policy.Threshold {
+		snippets = append(snippets, heard.snippet(turn, snippetLowConfidence))
+	}
+	return snippets
+}
+
+// snippet returns the audio as a snippet saved for reason, marking it
+// saved.
+func (h *heardAudio) snippet(turn int, reason string) Snippet {
+	h.saved = true
+	return Snippet{
+		Turn:       turn,
+		Reason:     reason,
+		Text:       h.text,
+		Confidence: h.confidence,
+		Words:      h.words,
+		StartMs:    h.start.Milliseconds(),
+		EndMs:      h.end.Milliseconds(),
+		SampleRate: h.sampleRate,
+		pcm:        h.pcm,
+	}
+}
+
+func (t *snippetTrack) add(h heardAudio) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.pending) == snippetPending {
+		t.pending = t.pending[1:]
+	}
+	t.pending = append(t.pending, h)
+}
+
+// snippetSTT keeps a stream's audio, and cuts each final transcript's
+// from it by its word timestamps for a snippet track.
+type snippetSTT struct {
+	stt.StreamingProvider
+	track *snippetTrack
+}
+
+// TranscribeStream opens a stream, asking for word timestamps, and keeps
+// the audio written to it.
+func (p *snippetSTT) TranscribeStream(ctx context.Context, config stt.TranscriptionConfig) (io.WriteCloser, <-chan stt.StreamEvent, error) {
+	config.EnableWordTimestamps = true
+	w, events, err := p.StreamingProvider.TranscribeStream(ctx, config)
+	if err != nil {
+		return nil, nil, err
+	}
+	stream := newSnippetStream(w, config)
+	kept := make(chan stt.StreamEvent, cap(events))
+	go func() {
+		defer close(kept)
+		for event := range events {
+			if event.Type == stt.EventTranscript && event.IsFinal && event.Transcript != "" {
+				p.track.add(stream.cut(event, p.track.policy.Padding))
+			}
+			select {
+			case kept <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return stream, kept, nil
+}
+
+// snippetStream keeps the last snippetBuffer of the audio written to a
+// stream, in the stream's own encoding.
+type snippetStream struct {
+	io.WriteCloser
+	encoding   string
+	sampleRate int
+	// bytesPerSecond and frame are the audio's rate and the size of one
+	// sample, which cuts are aligned to.
+	bytesPerSecond float64
+	frame          int
+
+	mu  sync.Mutex
+	buf []byte
+	// base is how many bytes of the stream were dropped from buf, and
+	// heard how far the last final transcript reached.
+	base, heard int64
+}
+
+func newSnippetStream(w io.WriteCloser, config stt.TranscriptionConfig) *snippetStream {
+	s := &snippetStream{
+		WriteCloser:    w,
+		encoding:       config.Encoding,
+		sampleRate:     config.SampleRate,
+		bytesPerSecond: audioBytesPerSecond(config),
+		frame:          1,
+	}
+	if s.sampleRate == 0 {
+		s.sampleRate = 8000
+	}
+	if s.encoding == "linear16" {
+		s.frame = 2
+	}
+	return s
+}
+
+// Write keeps the audio as it is sent to the recognizer. The oldest is
+// dropped half a buffer at a time, so it is seldom copied.
+func (s *snippetStream) Write(b []byte) (int, error) {
+	s.mu.Lock()
+	s.buf = append(s.buf, b...)
+	if limit := s.offset(snippetBuffer); int64(len(s.buf)) > limit+limit/2 {
+		drop := int64(len(s.buf)) - limit
+		s.buf = append(s.buf[:0], s.buf[drop:]...)
+		s.base += drop
+	}
+	s.mu.Unlock()
+	return s.WriteCloser.Write(b)
+}
+
+// offset returns the byte offset of d into the stream.
+func (s *snippetStream) offset(d time.Duration) int64 {
+	n := int64(d.Seconds() * s.bytesPerSecond)
+	return n - n%int64(s.frame)
+}
+
+// at returns the stream time of a byte offset.
+func (s *snippetStream) at(offset int64) time.Duration {
+	return time.Duration(float64(offset) / s.bytesPerSecond * float64(time.Second))
+}
+
+// cut returns a final transcript with its audio: its words' span and
+// padding either side, or, without word timestamps, everything since the
+// last final transcript.
+func (s *snippetStream) cut(event stt.StreamEvent, padding time.Duration) heardAudio {
+	h := heardAudio{text: event.Transcript, sampleRate: s.sampleRate}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	written := s.base + int64(len(s.buf))
+	from, to := s.heard, written
+	if seg := event.Segment; seg != nil {
+		h.confidence, h.scored = seg.Confidence, seg.Confidence > 0
+		if len(seg.Words) > 0 {
+			from = s.offset(max(seg.Words[0].StartTime-padding, 0))
+			to = min(s.offset(seg.Words[len(seg.Words)-1].EndTime+padding), written)
+		}
+	}
+	from = max(from, s.base)
+	s.heard = max(s.heard, to)
+	if from >= to {
+		return h
+	}
+	h.start, h.end = s.at(from), s.at(to)
+	if seg := event.Segment; seg != nil {
+		for _, w := range seg.Words {
+			h.words = append(h.words, SnippetWord{
+				Text:       w.Text,
+				StartMs:    (w.StartTime - h.start).Milliseconds(),
+				EndMs:      (w.EndTime - h.start).Milliseconds(),
+				Confidence: w.Confidence,
+			})
+		}
+	}
+	h.pcm = s.decode(s.buf[from-s.base : to-s.base])
+	return h
+}
+
+// decode converts audio in the stream's encoding to 16-bit samples.
+func (s *snippetStream) decode(data []byte) []int16 {
+	switch s.encoding {
+	case "linear16":
+		return audio.PCM16FromBytes(data)
+	case "alaw":
+		return audio.CodecAlaw.NewDecoder().Decode(data)
+	default:
+		return audio.CodecMulaw.NewDecoder().Decode(data)
+	}
+}
+
+// Snippet queues a turn's snippet to be stored under the call's prefix,
+// as snippets/turn-<n>.wav and its transcript as snippets/turn-<n>.json,
+// returning the audio's key.
+func (a *callArchive) Snippet(prefix string, sn Snippet) string {
+	if a == nil {
+		return ""
+	}
+	name := fmt.Sprintf("%ssnippets/turn-%03d", prefix, sn.Turn)
+	a.putData(name+".wav", sn.WAV(), "audio/wav")
+	if data, err := json.MarshalIndent(sn, "", "  "); err == nil {
+		a.putData(name+".json", data, "application/json")
+	}
+	return name + ".wav"
+}
+
+// snippet records a saved snippet in the CDR.
+func (s *CallSession) snippet(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cdr.Snippets = append(s.cdr.Snippets, key)
+}
@@ -0,0 +1,662 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/agent"
+	"github.com/agentplexus/omnivoice-examples/kit/audio"
+	"github.com/agentplexus/omnivoice-examples/kit/config"
+	"github.com/agentplexus/omnivoice/stt"
+	"github.com/agentplexus/omnivoice/transport"
+	"github.com/agentplexus/omnivoice/tts"
+)
+
+// SoakConfig controls the soak test, which keeps loopback calls running
+// through the full session pipeline for hours to catch the leaks and drift
+// that only show on a long-running server.
+type SoakConfig struct {
+	// Calls is how many loopback calls are kept up at once.
+	Calls int
+	// Duration is how long the soak runs.
+	Duration time.Duration
+	// CallDuration is how long each call lasts before the caller hangs up
+	// and redials, so session setup and teardown are soaked too.
+	CallDuration time.Duration
+	// TurnInterval is how often each caller speaks.
+	TurnInterval time.Duration
+	// Warmup is how long to run before taking the heap baseline.
+	Warmup time.Duration
+	// MaxHeapGrowth is how far the live heap may grow past the baseline.
+	MaxHeapGrowth uint64
+	// MaxReconnects is how many STT streams beyond one per call may be
+	// opened.
+	MaxReconnects int
+}
+
+// defaultSoakConfig returns the configuration used unless overridden by
+// the SOAK_* environment variables.
+func defaultSoakConfig() SoakConfig {
+	return SoakConfig{
+		Calls:         24,
+		Duration:      2 * time.Hour,
+		CallDuration:  10 * time.Minute,
+		TurnInterval:  15 * time.Second,
+		Warmup:        5 * time.Minute,
+		MaxHeapGrowth: 32 << 20,
+	}
+}
+
+// soakConfigFromEnv applies environment overrides to the default
+// configuration.
+func soakConfigFromEnv() (SoakConfig, error) {
+	cfg := defaultSoakConfig()
+	for name, d := range map[string]*time.Duration{
+		"SOAK_DURATION":      &cfg.Duration,
+		"SOAK_CALL_DURATION": &cfg.CallDuration,
+		"SOAK_TURN_INTERVAL": &cfg.TurnInterval,
+		"SOAK_WARMUP":        &cfg.Warmup,
+	} {
+		if v := os.Getenv(name); v != "" {
+			parsed, err := time.ParseDuration(v)
+			if err != nil || parsed <= 0 {
+				return cfg, fmt.Errorf("invalid %s: %q", name, v)
+			}
+			*d = parsed
+		}
+	}
+	if v := os.Getenv("SOAK_CALLS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return cfg, fmt.Errorf("invalid SOAK_CALLS: %q", v)
+		}
+		cfg.Calls = n
+	}
+	if v := os.Getenv("SOAK_MAX_HEAP_GROWTH_MB"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("invalid SOAK_MAX_HEAP_GROWTH_MB: %q", v)
+		}
+		cfg.MaxHeapGrowth = uint64(n) << 20
+	}
+	if v := os.Getenv("SOAK_MAX_RECONNECTS"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return cfg, fmt.Errorf("invalid SOAK_MAX_RECONNECTS: %q", v)
+		}
+		cfg.MaxReconnects = n
+	}
+	return cfg, nil
+}
+
+const (
+	// soakReplyTimeout is how long a caller waits for the reply to a turn.
+	soakReplyTimeout = 10 * time.Second
+	// soakSampleInterval is how often the heap and goroutines are sampled.
+	soakSampleInterval = time.Minute
+	// soakHangupTimeout bounds how long a session may take to end after its
+	// caller hangs up.
+	soakHangupTimeout = 30 * time.Second
+	// soakGoroutineSlack is how many more goroutines than before the soak
+	// may remain once every call has ended.
+	soakGoroutineSlack = 10
+	// soakCallGoroutines is how many goroutines each call runs outside
+	// the session: the caller's three, the loopback providers' two, and one
+	// streaming a reply.
+	soakCallGoroutines = 6
+	// soakReplyPrefix starts every reply from the soak agent.
+	soakReplyPrefix = "heard"
+)
+
+// soakWords make up caller utterances. None of them is a goodbye or a
+// request for a human, so calls only end when the caller hangs up.
+var soakWords = []string{"order", "status", "delivery", "tomorrow", "address", "weather", "appointment", "morning", "update", "parcel"}
+
+// soakStats counts what the callers observed.
+type soakStats struct {
+	calls      atomic.Int64
+	turns      atomic.Int64
+	replies    atomic.Int64
+	missing    atomic.Int64
+	mismatched atomic.Int64
+	unexpected atomic.Int64
+	dropped    atomic.Int64
+	stuck      atomic.Int64
+}
+
+// runSoak runs the soak test and returns an error describing every check
+// that failed. Interrupting it ends the soak early and still runs the
+// checks.
+func runSoak(ctx context.Context, cfg SoakConfig) error {
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	sttProvider := &loopbackSTT{}
+	server := &Server{
+		agent:           soakAgent(),
+		ttsProvider:     &loopbackTTS{},
+		sttProvider:     sttProvider,
+		tts:             config.Default().ElevenLabs,
+		stt:             config.Default().Deepgram,
+		resampleQuality: audio.QualitySinc,
+		transportCodec:  audio.CodecMulaw,
+		dedupThreshold:  defaultDedupThreshold,
+		latency:         NewLatencyStats(),
+		outbound:        newOutboundMetrics(defaultOutboundBuffer()),
+		concurrency:     newConcurrency(defaultConcurrencyConfig()),
+		metadata:        newMetadataStore(),
+		greeting:        defaultGreetingConfig(),
+		termination:     defaultTerminationPolicy(),
+		twilio:          newTwilioClient("", ""),
+		coaching:        newCoachingHub(),
+		drain:           defaultDrainPolicy(),
+		sessions:        NewSessionManager(SessionLimits{}),
+	}
+
+	runtime.GC()
+	startGoroutines := runtime.NumGoroutine()
+	slog.Info("starting soak test", "calls", cfg.Calls, "duration", cfg.Duration, "call_duration", cfg.CallDuration, "turn_interval", cfg.TurnInterval)
+
+	soakCtx, cancel := context.WithTimeout(ctx, cfg.Duration)
+	defer cancel()
+	// Sessions are ended by their callers hanging up, never by cancellation
+	sessionsCtx, cancelSessions := context.WithCancel(context.Background())
+	defer cancelSessions()
+
+	var stats soakStats
+	var wg sync.WaitGroup
+	for i := range cfg.Calls {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; soakCtx.Err() == nil; n++ {
+				soakCall(soakCtx, sessionsCtx, server, fmt.Sprintf("soak-%d-%d", i, n), cfg, &stats)
+			}
+		}()
+	}
+
+	// The heap baseline is taken after warmup, once every call is up and
+	// buffers and caches have reached their working size
+	start := time.Now()
+	var baseline, heap uint64
+	var peakGoroutines int
+	ticker := time.NewTicker(soakSampleInterval)
+	defer ticker.Stop()
+	for soakCtx.Err() == nil {
+		select {
+		case <-soakCtx.Done():
+			continue
+		case <-ticker.C:
+		}
+		heap = liveHeap()
+		peakGoroutines = max(peakGoroutines, runtime.NumGoroutine())
+		if baseline == 0 && time.Since(start) >= cfg.Warmup {
+			baseline = heap
+		}
+		slog.Info("soak sample",
+			"elapsed", time.Since(start).Round(time.Second),
+			"heap_mb", heap>>20,
+			"goroutines", runtime.NumGoroutine(),
+			"calls", stats.calls.Load(),
+			"turns", stats.turns.Load(),
+			"replies", stats.replies.Load(),
+			"stt_streams", sttProvider.streams.Load())
+	}
+	wg.Wait()
+
+	var problems []error
+	if n := stats.missing.Load(); n > 0 {
+		problems = append(problems, fmt.Errorf("%d turns got no reply", n))
+	}
+	if n := stats.mismatched.Load(); n > 0 {
+		problems = append(problems, fmt.Errorf("%d replies did not match the turn", n))
+	}
+	if n := stats.unexpected.Load(); n > 0 {
+		problems = append(problems, fmt.Errorf("%d replies arrived with no turn pending", n))
+	}
+	if n := stats.dropped.Load(); n > 0 {
+		problems = append(problems, fmt.Errorf("%d calls ended before the caller hung up", n))
+	}
+	if n := stats.stuck.Load(); n > 0 {
+		problems = append(problems, fmt.Errorf("%d sessions did not end within %s of hangup", n, soakHangupTimeout))
+	}
+	if reconnects := sttProvider.streams.Load() - stats.calls.Load(); reconnects > int64(cfg.MaxReconnects) {
+		problems = append(problems, fmt.Errorf("%d STT reconnects, want at most %d", reconnects, cfg.MaxReconnects))
+	}
+	if n := server.concurrency.refusedTasks.Load(); n > 0 {
+		problems = append(problems, fmt.Errorf("%d session tasks refused over the budget of %d", n, server.concurrency.Config.SessionTasks))
+	}
+	if budget := sessionGoroutines + soakCallGoroutines + server.concurrency.Config.SessionTasks + controlTasks; peakGoroutines > startGoroutines+cfg.Calls*budget {
+		problems = append(problems, fmt.Errorf("%d goroutines at peak, over the budget of %d per call", peakGoroutines, budget))
+	}
+	if baseline == 0 {
+		slog.Warn("soak ended before warmup, skipping heap check")
+	} else if heap > baseline && heap-baseline > cfg.MaxHeapGrowth {
+		problems = append(problems, fmt.Errorf("live heap grew %d MB past the baseline, want at most %d MB", (heap-baseline)>>20, cfg.MaxHeapGrowth>>20))
+	}
+
+	// With every call over, the goroutines sessions started should be gone
+	waitCtx, cancelWait := context.WithTimeout(context.Background(), soakHangupTimeout)
+	defer cancelWait()
+	server.sessions.Drain()
+	if server.sessions.Wait(waitCtx) != nil {
+		problems = append(problems, fmt.Errorf("%d sessions still running after the soak", len(server.sessions.List())))
+	}
+	goroutines := settledGoroutines(waitCtx, startGoroutines+soakGoroutineSlack)
+	if goroutines > startGoroutines+soakGoroutineSlack {
+		problems = append(problems, fmt.Errorf("%d goroutines left after every call ended, started with %d", goroutines, startGoroutines))
+	}
+
+	slog.Info("soak test finished",
+		"elapsed", time.Since(start).Round(time.Second),
+		"calls", stats.calls.Load(),
+		"turns", stats.turns.Load(),
+		"replies", stats.replies.Load(),
+		"heap_baseline_mb", baseline>>20,
+		"heap_mb", heap>>20,
+		"goroutines", goroutines,
+		"peak_goroutines", peakGoroutines,
+		"peak_session_tasks", server.concurrency.peakTasks.Load(),
+		"problems", len(problems))
+	return errors.Join(problems...)
+}
+
+// soakCall runs one loopback call. The caller streams silence, speaks
+// every TurnInterval, checks each reply, and hangs up after CallDuration.
+func soakCall(ctx, sessionsCtx context.Context, server *Server, id string, cfg SoakConfig, stats *soakStats) {
+	conn := newLoopbackConn(id)
+	ended := make(chan struct{})
+	go func() {
+		defer close(ended)
+		server.handleSession(sessionsCtx, conn)
+	}()
+	stats.calls.Add(1)
+
+	hungUp := make(chan struct{})
+	replies := make(chan string, 16)
+	go readLoopbackLines(conn.outReader, replies, hungUp)
+
+	// Audio is written from its own goroutine, so a session that stops
+	// reading shows up as missing replies instead of hanging the caller
+	audioOut := make(chan []byte, outboundBufferFrames)
+	writeErr := make(chan error, 1)
+	go func() {
+		for b := range audioOut {
+			if _, err := conn.inWriter.Write(b); err != nil {
+				writeErr <- err
+				return
+			}
+		}
+	}()
+	defer close(audioOut)
+	send := func(b []byte) {
+		select {
+		case audioOut <- b:
+		default:
+		}
+	}
+
+	callCtx, cancel := context.WithTimeout(ctx, cfg.CallDuration)
+	defer cancel()
+	frames := time.NewTicker(outboundFrameInterval)
+	defer frames.Stop()
+	turns := time.NewTicker(cfg.TurnInterval)
+	defer turns.Stop()
+
+	silence := bytes.Repeat([]byte{loopbackSilence}, outboundFrameSize)
+	turn := 0
+	var expect string
+	var expectBy time.Time
+	for callCtx.Err() == nil {
+		var err error
+		select {
+		case <-callCtx.Done():
+		case err = <-writeErr:
+		case now := <-frames.C:
+			send(silence)
+			if expect != "" && now.After(expectBy) {
+				stats.missing.Add(1)
+				slog.Warn("soak turn got no reply", "call", id, "turn", turn)
+				expect = ""
+			}
+		case <-turns.C:
+			turn++
+			text := soakUtterance(id, turn)
+			expect = fmt.Sprintf("%s turn %d: %s", soakReplyPrefix, turn, text)
+			expectBy = time.Now().Add(soakReplyTimeout)
+			send([]byte(text + "\n"))
+			stats.turns.Add(1)
+		case line, ok := <-replies:
+			if !ok {
+				err = io.EOF
+				break
+			}
+			if !strings.HasPrefix(line, soakReplyPrefix) {
+				// The greeting
+				continue
+			}
+			switch {
+			case expect == "":
+				stats.unexpected.Add(1)
+				slog.Warn("soak reply with no turn pending", "call", id, "reply", line)
+			case strings.Join(strings.Fields(line), " ") == expect:
+				stats.replies.Add(1)
+				expect = ""
+			default:
+				stats.mismatched.Add(1)
+				slog.Warn("soak reply does not match turn", "call", id, "want", expect, "got", line)
+				expect = ""
+			}
+		}
+		if err != nil {
+			stats.dropped.Add(1)
+			slog.Warn("soak call ended by the server", "call", id, "error", err)
+			break
+		}
+	}
+
+	close(hungUp)
+	conn.hangUp()
+	select {
+	case <-ended:
+	case <-time.After(soakHangupTimeout):
+		stats.stuck.Add(1)
+		slog.Error("soak session did not end after hangup", "call", id)
+	}
+}
+
+// soakUtterance returns what a caller says on a turn, unique per call and
+// turn.
+func soakUtterance(id string, turn int) string {
+	words := make([]string, 3)
+	for i := range words {
+		words[i] = soakWords[rand.IntN(len(soakWords))]
+	}
+	return fmt.Sprintf("call %s turn %d %s", id, turn, strings.Join(words, " "))
+}
+
+// soakAgent echoes each turn back tagged with its index, so callers can
+// check nothing was dropped, repeated or garbled on the way.
+func soakAgent() agent.Agent {
+	return agent.Func(func(ctx context.Context, turn agent.Turn) (<-chan agent.Response, error) {
+		return agent.Reply(agent.Say(fmt.Sprintf("%s turn %d: %s", soakReplyPrefix, turn.Index, turn.Text))), nil
+	})
+}
+
+// liveHeap returns the live heap after a collection.
+func liveHeap() uint64 {
+	runtime.GC()
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return m.HeapAlloc
+}
+
+// settledGoroutines waits for the goroutine count to fall to want, giving
+// exiting goroutines time to finish, and returns the last count.
+func settledGoroutines(ctx context.Context, want int) int {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for {
+		n := runtime.NumGoroutine()
+		if n <= want {
+			return n
+		}
+		select {
+		case <-ctx.Done():
+			return n
+		case <-ticker.C:
+		}
+	}
+}
+
+// loopbackSilence is mu-law silence. The loopback providers carry text in
+// the audio stream as newline-terminated ASCII between runs of silence.
+const loopbackSilence = 0xFF
+
+// loopbackBytesPerChar is how much audio the loopback TTS produces per
+// character, roughly a normal speaking rate.
+const loopbackBytesPerChar = 400
+
+// readLoopbackLines sends each line of text carried in r to lines until r
+// is closed or done is.
+func readLoopbackLines(r io.Reader, lines chan<- string, done <-chan struct{}) {
+	defer close(lines)
+	buf := make([]byte, 4096)
+	var line []byte
+	for {
+		n, err := r.Read(buf)
+		for _, b := range buf[:n] {
+			switch b {
+			case loopbackSilence:
+			case '\n':
+				select {
+				case lines <- string(line):
+				case <-done:
+					return
+				}
+				line = line[:0]
+			default:
+				line = append(line, b)
+			}
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// loopbackConn is a transport connection whose far end is a soak caller.
+type loopbackConn struct {
+	id string
+
+	// The caller writes to inWriter and reads what the agent says from
+	// outReader.
+	inReader  *io.PipeReader
+	inWriter  *io.PipeWriter
+	outReader *io.PipeReader
+	outWriter *io.PipeWriter
+
+	events    chan transport.Event
+	closeOnce sync.Once
+}
+
+var _ transport.Connection = (*loopbackConn)(nil)
+
+func newLoopbackConn(id string) *loopbackConn {
+	c := &loopbackConn{id: id, events: make(chan transport.Event, 1)}
+	c.inReader, c.inWriter = io.Pipe()
+	c.outReader, c.outWriter = io.Pipe()
+	return c
+}
+
+func (c *loopbackConn) ID() string                     { return c.id }
+func (c *loopbackConn) AudioIn() io.WriteCloser        { return c.outWriter }
+func (c *loopbackConn) AudioOut() io.Reader            { return c.inReader }
+func (c *loopbackConn) Events() <-chan transport.Event { return c.events }
+func (c *loopbackConn) RemoteAddr() net.Addr           { return nil }
+
+// CustomParameters gives each call its own call SID.
+func (c *loopbackConn) CustomParameters() map[string]string {
+	return map[string]string{paramCallSID: c.id, paramCaller: "soak"}
+}
+
+// Close closes both directions, so a caller still writing or reading sees
+// the call end.
+func (c *loopbackConn) Close() error {
+	c.closeOnce.Do(func() {
+		_ = c.inReader.Close()
+		_ = c.outWriter.Close()
+	})
+	return nil
+}
+
+// hangUp signals the session that the caller has gone.
+func (c *loopbackConn) hangUp() {
+	select {
+	case c.events <- transport.Event{Type: transport.EventDisconnected}:
+	default:
+	}
+}
+
+// loopbackSTT "transcribes" the text lines a soak caller writes into its
+// audio.
+type loopbackSTT struct {
+	streams atomic.Int64
+}
+
+var _ stt.StreamingProvider = (*loopbackSTT)(nil)
+
+func (p *loopbackSTT) Name() string { return "loopback" }
+
+func (p *loopbackSTT) Transcribe(ctx context.Context, audio []byte, config stt.TranscriptionConfig) (*stt.TranscriptionResult, error) {
+	return nil, errors.New("loopback STT only streams")
+}
+
+func (p *loopbackSTT) TranscribeFile(ctx context.Context, filePath string, config stt.TranscriptionConfig) (*stt.TranscriptionResult, error) {
+	return nil, errors.New("loopback STT only streams")
+}
+
+func (p *loopbackSTT) TranscribeURL(ctx context.Context, url string, config stt.TranscriptionConfig) (*stt.TranscriptionResult, error) {
+	return nil, errors.New("loopback STT only streams")
+}
+
+// TranscribeStream opens a stream. Each stream opened counts toward the
+// reconnect check.
+func (p *loopbackSTT) TranscribeStream(ctx context.Context, config stt.TranscriptionConfig) (io.WriteCloser, <-chan stt.StreamEvent, error) {
+	p.streams.Add(1)
+	r := &loopbackRecognizer{events: make(chan stt.StreamEvent, 16), done: make(chan struct{})}
+	go func() {
+		select {
+		case <-ctx.Done():
+			_ = r.Close()
+		case <-r.done:
+		}
+	}()
+	return r, r.events, nil
+}
+
+// loopbackRecognizer turns text lines in written audio into speech start,
+// final transcript and speech end events.
+type loopbackRecognizer struct {
+	events chan stt.StreamEvent
+	done   chan struct{}
+
+	mu     sync.Mutex
+	line   []byte
+	closed bool
+}
+
+func (r *loopbackRecognizer) Write(b []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return 0, io.ErrClosedPipe
+	}
+	for _, c := range b {
+		switch c {
+		case loopbackSilence:
+		case '\n':
+			r.emit(stt.StreamEvent{Type: stt.EventTranscript, Transcript: string(r.line), IsFinal: true})
+			r.emit(stt.StreamEvent{Type: stt.EventSpeechEnd, SpeechEnded: true})
+			r.line = r.line[:0]
+		default:
+			if len(r.line) == 0 {
+				r.emit(stt.StreamEvent{Type: stt.EventSpeechStart, SpeechStarted: true})
+			}
+			r.line = append(r.line, c)
+		}
+	}
+	return len(b), nil
+}
+
+// emit sends an event unless the stream is closed. r.mu must be held.
+func (r *loopbackRecognizer) emit(event stt.StreamEvent) {
+	select {
+	case r.events <- event:
+	case <-r.done:
+	}
+}
+
+func (r *loopbackRecognizer) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.closed {
+		r.closed = true
+		close(r.done)
+		close(r.events)
+	}
+	return nil
+}
+
+// loopbackTTS "synthesizes" text by writing it into the audio, followed by
+// silence lasting about as long as saying it would.
+type loopbackTTS struct{}
+
+var _ tts.StreamingProvider = (*loopbackTTS)(nil)
+
+func (p *loopbackTTS) Name() string { return "loopback" }
+
+func (p *loopbackTTS) Synthesize(ctx context.Context, text string, config tts.SynthesisConfig) (*tts.SynthesisResult, error) {
+	audio := loopbackSpeech(text)
+	return &tts.SynthesisResult{Audio: audio, Format: config.OutputFormat, SampleRate: config.SampleRate, CharacterCount: len(text)}, nil
+}
+
+func (p *loopbackTTS) ListVoices(ctx context.Context) ([]tts.Voice, error) {
+	return []tts.Voice{{ID: "loopback", Name: "Loopback", Provider: "loopback"}}, nil
+}
+
+func (p *loopbackTTS) GetVoice(ctx context.Context, voiceID string) (*tts.Voice, error) {
+	return &tts.Voice{ID: voiceID, Name: "Loopback", Provider: "loopback"}, nil
+}
+
+func (p *loopbackTTS) SynthesizeStream(ctx context.Context, text string, config tts.SynthesisConfig) (<-chan tts.StreamChunk, error) {
+	audio := loopbackSpeech(text)
+	chunks := make(chan tts.StreamChunk)
+	go func() {
+		defer close(chunks)
+		for len(audio) > 0 {
+			n := min(len(audio), 10*outboundFrameSize)
+			select {
+			case chunks <- tts.StreamChunk{Audio: audio[:n], IsFinal: n == len(audio)}:
+			case <-ctx.Done():
+				return
+			}
+			audio = audio[n:]
+		}
+	}()
+	return chunks, nil
+}
+
+func (p *loopbackTTS) SynthesizeFromReader(ctx context.Context, reader io.Reader, config tts.SynthesisConfig) (<-chan tts.StreamChunk, error) {
+	text, err := io.ReadAll(reader)
+	if err != nil {
+		return nil, err
+	}
+	return p.SynthesizeStream(ctx, string(text), config)
+}
+
+// loopbackSpeech encodes text as loopback audio, padded to whole frames so
+// the pacer sends all of it.
+func loopbackSpeech(text string) []byte {
+	text = strings.ReplaceAll(text, "\n", " ")
+	size := max(len(text)*loopbackBytesPerChar, len(text)+1)
+	size = (size + outboundFrameSize - 1) / outboundFrameSize * outboundFrameSize
+	audio := bytes.Repeat([]byte{loopbackSilence}, size)
+	copy(audio, text+"\n")
+	return audio
+}
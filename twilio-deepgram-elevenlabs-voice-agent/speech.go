@@ -0,0 +1,450 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/agentplexus/omnivoice/pipeline"
+	"github.com/agentplexus/omnivoice/transport"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// speechQueue serializes everything the agent says in a session. Utterances
+// are synthesized one at a time in the order they were queued, so a slow
+// synthesis never blocks the STT callbacks and responses never overlap.
+type speechQueue struct {
+	ctx    context.Context
+	tts    *pipeline.TTSPipeline
+	conn   transport.Connection
+	logger *slog.Logger
+	dedup  *sentenceDeduper
+
+	// spoken, if set, is called with each utterance as it starts playing.
+	spoken func(text string, target Leg)
+	// legs, if set, returns the outbound path to a single leg of a bridged
+	// call, for utterances whispered to that leg.
+	legs func(leg Leg) (transport.Connection, error)
+	// playback, if set, reports when each utterance has been played to the
+	// caller; until then the queue isn't idle.
+	playback *pacedConnection
+	// played, if set, is called in order with each utterance the caller
+	// heard in full, and the turn it answered (0 for none).
+	played func(text string, turn int)
+	// prompt, if set, returns a prerecorded prompt's text and its audio in
+	// the connection's format, or nil audio if it wasn't rendered.
+	prompt func(name string) (text string, audio []byte, ok bool)
+
+	mu       sync.Mutex
+	pending  []utterance
+	speaking bool
+	muted    bool
+	// voice is the voice utterances are queued in; empty is the TTS
+	// pipeline's.
+	voice string
+	wake  chan struct{}
+	// unheard counts, by turn, the utterances queued and not yet played,
+	// cleared or failed.
+	unheard map[int]int
+	// cleared counts calls to Clear, so an utterance cut off while it was
+	// synthesized isn't taken as heard.
+	cleared int
+	// marks numbers the marks sent after utterances; reported is closed
+	// once the last utterance marked has been reported, keeping reports
+	// in order.
+	marks    int
+	reported chan struct{}
+}
+
+// newSpeechQueue starts a queue speaking to conn until ctx is cancelled.
+func newSpeechQueue(ctx context.Context, tts *pipeline.TTSPipeline, conn transport.Connection, logger *slog.Logger, dedupThreshold float64) *speechQueue {
+	q := &speechQueue{
+		ctx:      ctx,
+		tts:      tts,
+		conn:     conn,
+		logger:   logger,
+		dedup:    newSentenceDeduper(dedupThreshold),
+		wake:     make(chan struct{}, 1),
+		unheard:  make(map[int]int),
+		reported: make(chan struct{}),
+	}
+	close(q.reported)
+	go q.run()
+	return q
+}
+
+// utterance is queued text, the context (trace) it was queued from and
+// the turn that context answers, who is to hear it, and who to tell if it
+// can't be spoken.
+type utterance struct {
+	ctx  context.Context
+	text string
+	// voice, if set, is synthesized in instead of the pipeline's voice.
+	voice string
+	// audio, if set, is played instead of synthesizing text.
+	audio   []byte
+	turn    int
+	target  Leg
+	onError func(error)
+}
+
+type turnContextKey struct{}
+
+// withTurn returns a context whose speech answers turn index.
+func withTurn(ctx context.Context, index int) context.Context {
+	return context.WithValue(ctx, turnContextKey{}, index)
+}
+
+// turnFrom returns the turn ctx's speech answers, or 0.
+func turnFrom(ctx context.Context) int {
+	index, _ := ctx.Value(turnContextKey{}).(int)
+	return index
+}
+
+// Say queues text to be spoken. Sentences that duplicate something already
+// said this turn are dropped.
+func (q *speechQueue) Say(text string) {
+	q.SayContext(q.ctx, text, nil)
+}
+
+// SayContext is like Say, but synthesis is traced as part of the span in
+// ctx (typically the turn being answered), and onError, if non-nil, is
+// called when synthesis fails. Cancellation still follows the queue's
+// context.
+func (q *speechQueue) SayContext(ctx context.Context, text string, onError func(error)) {
+	q.mu.Lock()
+	muted := q.muted
+	q.mu.Unlock()
+	if muted {
+		q.logger.Debug("agent muted, dropped speech", "text", text)
+		return
+	}
+
+	text, dropped := q.dedup.Filter(text)
+	for _, sentence := range dropped {
+		q.logger.Info("suppressed duplicate sentence", "text", sentence)
+	}
+	if text == "" {
+		return
+	}
+	q.enqueue(utterance{ctx: ctx, text: text, onError: onError})
+}
+
+// Play queues the prerecorded prompt name. A prompt without audio is
+// synthesized from its text instead.
+func (q *speechQueue) Play(name string) {
+	q.PlayContext(q.ctx, name, nil)
+}
+
+// PlayContext is like Play, with ctx and onError as for SayContext.
+func (q *speechQueue) PlayContext(ctx context.Context, name string, onError func(error)) {
+	q.mu.Lock()
+	muted := q.muted
+	q.mu.Unlock()
+	if muted {
+		q.logger.Debug("agent muted, dropped prompt", "prompt", name)
+		return
+	}
+	if q.prompt == nil {
+		q.logger.Warn("no prompt library, prompt not played", "prompt", name)
+		return
+	}
+	text, audio, ok := q.prompt(name)
+	if !ok {
+		q.logger.Warn("unknown prompt", "prompt", name)
+		return
+	}
+	q.mu.Lock()
+	if q.voice != "" {
+		// Prompts are rendered in the call's own voice
+		audio = nil
+	}
+	q.mu.Unlock()
+	q.enqueue(utterance{ctx: ctx, text: text, audio: audio, onError: onError})
+}
+
+// Inject queues text from outside the conversation, such as an operator's
+// message. It is spoken even while muted and never deduplicated.
+func (q *speechQueue) Inject(text string) {
+	q.enqueue(utterance{ctx: q.ctx, text: text})
+}
+
+// InjectTo is like Inject, but only target hears the text unless target is
+// LegAll. It fails if the transport can't play audio to a single leg.
+func (q *speechQueue) InjectTo(text string, target Leg) error {
+	if target != LegAll && q.legs == nil {
+		return errSingleLeg
+	}
+	q.enqueue(utterance{ctx: q.ctx, text: text, target: target})
+	return nil
+}
+
+// SetMuted stops or resumes the agent's speech. Muting drops everything
+// not yet started; the caller stops what is playing.
+func (q *speechQueue) SetMuted(muted bool) {
+	q.mu.Lock()
+	q.muted = muted
+	q.mu.Unlock()
+	if muted {
+		q.Clear()
+	}
+}
+
+// SetVoice has what is queued from now on spoken in voiceID, or in the
+// pipeline's voice if voiceID is empty; what is already queued keeps its
+// voice. It reports whether the voice changed.
+func (q *speechQueue) SetVoice(voiceID string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	changed := q.voice != voiceID
+	q.voice = voiceID
+	return changed
+}
+
+func (q *speechQueue) enqueue(u utterance) {
+	u.turn = turnFrom(u.ctx)
+	q.mu.Lock()
+	u.voice = q.voice
+	q.pending = append(q.pending, u)
+	q.unheard[u.turn]++
+	q.mu.Unlock()
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// NewTurn starts a new conversational turn. Duplicate detection only spans
+// a single turn, so a repeated question still gets its answer repeated.
+func (q *speechQueue) NewTurn() {
+	q.dedup.Reset()
+}
+
+// Clear drops every utterance that has not started playing (barge-in).
+func (q *speechQueue) Clear() {
+	q.drop(true)
+}
+
+// Finish drops every utterance not yet started, leaving the one being
+// synthesized to finish (double-talk under the finish-sentence policy).
+func (q *speechQueue) Finish() {
+	q.drop(false)
+}
+
+// drop drops the utterances not yet started, and with cut counts the one
+// being synthesized as cut off.
+func (q *speechQueue) drop(cut bool) {
+	q.mu.Lock()
+	dropped := q.pending
+	q.pending = nil
+	if cut {
+		q.cleared++
+	}
+	q.mu.Unlock()
+
+	for _, u := range dropped {
+		q.dedup.Forget(u.text)
+		q.done(u)
+	}
+}
+
+// Idle reports whether nothing is queued, being synthesized or, with
+// playback set, still to be played.
+func (q *speechQueue) Idle() bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.pending) == 0 && !q.speaking && len(q.unheard) == 0
+}
+
+// Unheard reports whether some of the reply to turn is still queued,
+// being synthesized or still to be played.
+func (q *speechQueue) Unheard(turn int) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.unheard[turn] > 0
+}
+
+// done counts an utterance played, cleared or failed.
+func (q *speechQueue) done(u utterance) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.unheard[u.turn]--; q.unheard[u.turn] <= 0 {
+		delete(q.unheard, u.turn)
+	}
+}
+
+// Wait blocks until every queued utterance has been synthesized and, with
+// playback set, played.
+func (q *speechQueue) Wait(ctx context.Context) error {
+	return q.waitUntil(ctx, q.Idle)
+}
+
+// WaitTurn blocks until nothing of the reply to turn is left to play.
+func (q *speechQueue) WaitTurn(ctx context.Context, turn int) error {
+	return q.waitUntil(ctx, func() bool { return !q.Unheard(turn) })
+}
+
+func (q *speechQueue) waitUntil(ctx context.Context, done func() bool) error {
+	ticker := time.NewTicker(outboundFrameInterval)
+	defer ticker.Stop()
+	for {
+		if done() {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// next pops the next utterance. The queue counts as speaking until next
+// finds it empty.
+func (q *speechQueue) next() (utterance, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if len(q.pending) == 0 {
+		q.speaking = false
+		return utterance{}, false
+	}
+	u := q.pending[0]
+	q.pending = q.pending[1:]
+	q.speaking = true
+	return u, true
+}
+
+func (q *speechQueue) run() {
+	for {
+		select {
+		case <-q.ctx.Done():
+			return
+		case <-q.wake:
+		}
+
+		for {
+			u, ok := q.next()
+			if !ok {
+				break
+			}
+			q.speak(u)
+		}
+	}
+}
+
+// speak synthesizes one utterance to the connection.
+func (q *speechQueue) speak(u utterance) {
+	ctx := trace.ContextWithSpan(withEmotion(withVoice(q.ctx, u.voice), emotionFrom(u.ctx)), trace.SpanFromContext(u.ctx))
+	ctx, span := tracer.Start(ctx, "tts.synthesize", trace.WithAttributes(attribute.Int("tts.text.length", len(u.text))))
+	defer span.End()
+	if u.voice != "" {
+		span.SetAttributes(attribute.String("tts.voice", u.voice))
+	}
+	if emotion := emotionFrom(ctx); emotion != "" {
+		span.SetAttributes(attribute.String("tts.emotion", emotion))
+	}
+
+	q.mu.Lock()
+	cleared := q.cleared
+	q.mu.Unlock()
+
+	conn := q.conn
+	if u.target != LegAll {
+		span.SetAttributes(attribute.String("tts.target", string(u.target)))
+		legConn, err := q.legs(u.target)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+			q.logger.Error("failed to whisper", "target", u.target, "error", err)
+			q.done(u)
+			return
+		}
+		conn = legConn
+	}
+
+	if q.spoken != nil {
+		q.spoken(u.text, u.target)
+	}
+	if u.audio != nil {
+		span.SetAttributes(attribute.Bool("tts.prerecorded", true))
+		q.play(u.audio, conn, cleared)
+		q.track(u, cleared)
+		return
+	}
+	if err := q.tts.SynthesizeToConnection(ctx, u.text, conn); err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+		q.logger.Error("failed to synthesize response", "error", err)
+		q.dedup.Forget(u.text)
+		q.done(u)
+		if u.onError != nil && q.ctx.Err() == nil {
+			u.onError(err)
+		}
+		return
+	}
+	q.track(u, cleared)
+}
+
+// promptWriteSize is how much prerecorded audio is written at a time,
+// checking in between whether it has been cut off.
+const promptWriteSize = 320
+
+// play writes prerecorded audio to conn until it is all written or cut
+// off by a Clear since cleared was read.
+func (q *speechQueue) play(audio []byte, conn transport.Connection, cleared int) {
+	w := conn.AudioIn()
+	for len(audio) > 0 && q.ctx.Err() == nil {
+		q.mu.Lock()
+		cut := q.cleared != cleared
+		q.mu.Unlock()
+		if cut {
+			return
+		}
+		n := min(promptWriteSize, len(audio))
+		if _, err := w.Write(audio[:n]); err != nil {
+			q.logger.Error("failed to play prompt", "error", err)
+			return
+		}
+		audio = audio[n:]
+	}
+}
+
+// track waits for a synthesized utterance to be played, and reports it if
+// the caller heard it in full: not cut off by a Clear since cleared was
+// read, nor cleared before it played. Whispers aren't reported.
+func (q *speechQueue) track(u utterance, cleared int) {
+	q.mu.Lock()
+	cut := q.cleared != cleared
+	q.marks++
+	name := fmt.Sprintf("utterance-%d", q.marks)
+	previous, reported := q.reported, make(chan struct{})
+	q.reported = reported
+	q.mu.Unlock()
+
+	heard := !cut && u.target == LegAll
+	var played <-chan bool
+	if heard && q.playback != nil {
+		played = q.playback.Mark(name)
+	}
+	go func() {
+		defer close(reported)
+		if played != nil {
+			select {
+			case heard = <-played:
+			case <-q.ctx.Done():
+				heard = false
+			}
+		}
+		<-previous
+		q.done(u)
+		if heard && q.played != nil {
+			q.played(u.text, u.turn)
+		}
+	}()
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/agent"
+	"github.com/agentplexus/omnivoice-examples/kit/callstate"
+	"github.com/agentplexus/omnivoice-examples/kit/llm"
+)
+
+// callStateTimeout bounds each read or write of shared call state.
+const callStateTimeout = 2 * time.Second
+
+// CallStateConfig controls how a call whose Media Stream drops picks up
+// where it left off when the stream reconnects. This instance holds the
+// call for a grace period; with Redis, metadata, the conversation history
+// and the transcript are also shared by call SID between instances of the
+// server, so it can run behind a load balancer.
+type CallStateConfig struct {
+	// Store is nil when call state is kept in this process only.
+	Store *callstate.Store
+	// ResumeLine is spoken when a reconnected stream resumes a call.
+	ResumeLine string
+	// ResumeGrace is how long this instance holds the transcript and
+	// conversation of a call whose stream dropped, for the stream to
+	// reconnect. Zero holds nothing here, leaving resumption to Store.
+	ResumeGrace time.Duration
+	// KeepaliveTimeout is how long a stream may go without sending audio
+	// before it is treated as dropped. Zero disables the check.
+	KeepaliveTimeout time.Duration
+}
+
+// defaultCallStateConfig returns the configuration used unless overridden
+// by REDIS_URL, CALL_STATE_TTL, STREAM_RESUME_GRACE and
+// STREAM_KEEPALIVE_TIMEOUT.
+func defaultCallStateConfig() CallStateConfig {
+	return CallStateConfig{
+		ResumeLine:       "Sorry about that, we were cut off for a moment. Where were we?",
+		ResumeGrace:      30 * time.Second,
+		KeepaliveTimeout: 5 * time.Second,
+	}
+}
+
+// callStateFromEnv applies environment overrides to the defaults.
+// REDIS_URL (redis://[[user]:password@]host[:port][/db], or rediss:// for
+// TLS) enables the shared store. CALL_STATE_TTL is how long a call's state
+// is kept after its last update (default 1h).
+func callStateFromEnv() (CallStateConfig, error) {
+	cfg := defaultCallStateConfig()
+	for name, d := range map[string]*time.Duration{
+		"STREAM_RESUME_GRACE":      &cfg.ResumeGrace,
+		"STREAM_KEEPALIVE_TIMEOUT": &cfg.KeepaliveTimeout,
+	} {
+		if v := os.Getenv(name); v != "" {
+			parsed, err := time.ParseDuration(v)
+			if err != nil || parsed < 0 {
+				return cfg, fmt.Errorf("invalid %s: %q", name, v)
+			}
+			*d = parsed
+		}
+	}
+	rawURL := os.Getenv("REDIS_URL")
+	if rawURL == "" {
+		return cfg, nil
+	}
+	store, err := callstate.Open(rawURL)
+	if err != nil {
+		return cfg, fmt.Errorf("invalid REDIS_URL: %w", err)
+	}
+	if v := os.Getenv("CALL_STATE_TTL"); v != "" {
+		ttl, err := time.ParseDuration(v)
+		if err != nil || ttl <= 0 {
+			return cfg, fmt.Errorf("invalid CALL_STATE_TTL: %q", v)
+		}
+		store.TTL = ttl
+	}
+	cfg.Store = store
+	return cfg, nil
+}
+
+// shareMetadata stores a call's metadata for whichever instance its stream
+// reaches.
+func (s *Server) shareMetadata(ctx context.Context, m SessionMetadata) {
+	if s.state.Store == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, callStateTimeout)
+	defer cancel()
+	if err := s.state.Store.PutMetadata(ctx, m.CallSID, m.streamParameters()); err != nil {
+		slog.Warn("failed to share call metadata", "call_sid", m.CallSID, "error", err)
+	}
+}
+
+// sharedMetadata returns a call's metadata as stored by the instance that
+// served its webhook.
+func (s *Server) sharedMetadata(ctx context.Context, callSID string) (SessionMetadata, bool) {
+	if s.state.Store == nil || callSID == "" {
+		return SessionMetadata{}, false
+	}
+	ctx, cancel := context.WithTimeout(ctx, callStateTimeout)
+	defer cancel()
+	params, err := s.state.Store.Metadata(ctx, callSID)
+	if err != nil {
+		slog.Warn("failed to load call metadata", "call_sid", callSID, "error", err)
+	}
+	if len(params) == 0 {
+		return SessionMetadata{}, false
+	}
+	return metadataFromParameters(params), true
+}
+
+// resumeCall loads what an earlier stream of the call left behind: its
+// transcript and the agent's conversation, held here or shared by another
+// instance. It returns no lines for a new call.
+func (s *Server) resumeCall(ctx context.Context, callSID string, logger *slog.Logger) ([]TranscriptLine, []llm.Message) {
+	if lines, history, ok := s.held.Take(callSID); ok {
+		return lines, history
+	}
+	if s.state.Store == nil || callSID == "" {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, callStateTimeout)
+	defer cancel()
+	stored, err := s.state.Store.Transcript(ctx, callSID)
+	if err != nil {
+		logger.Warn("failed to load call transcript", "error", err)
+		return nil, nil
+	}
+	if len(stored) == 0 {
+		return nil, nil
+	}
+	history, err := s.state.Store.History(ctx, callSID)
+	if err != nil {
+		logger.Warn("failed to load conversation history", "error", err)
+	}
+	lines := make([]TranscriptLine, len(stored))
+	for i, line := range stored {
+		lines[i] = TranscriptLine{Speaker: line.Speaker, Text: line.Text, Target: Leg(line.Target), At: line.At}
+	}
+	return lines, history
+}
+
+// callStateWriter saves a session's transcript and conversation to the
+// shared store in order, off the audio path. A nil writer saves nothing.
+type callStateWriter struct {
+	store   *callstate.Store
+	callSID string
+	logger  *slog.Logger
+	ops     chan func(context.Context) error
+	done    chan struct{}
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// newCallStateWriter returns a writer for the call, or nil if there is no
+// shared store.
+func newCallStateWriter(store *callstate.Store, callSID string, logger *slog.Logger) *callStateWriter {
+	if store == nil || callSID == "" {
+		return nil
+	}
+	w := &callStateWriter{
+		store:   store,
+		callSID: callSID,
+		logger:  logger,
+		ops:     make(chan func(context.Context) error, 64),
+		done:    make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+func (w *callStateWriter) run() {
+	defer close(w.done)
+	for op := range w.ops {
+		ctx, cancel := context.WithTimeout(context.Background(), callStateTimeout)
+		if err := op(ctx); err != nil {
+			w.logger.Warn("failed to save call state", "error", err)
+		}
+		cancel()
+	}
+}
+
+// enqueue queues a write, dropping it if the store has fallen behind or
+// the writer is closed.
+func (w *callStateWriter) enqueue(op func(context.Context) error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.closed {
+		return
+	}
+	select {
+	case w.ops <- op:
+	default:
+		w.logger.Warn("call state store is behind, dropping update")
+	}
+}
+
+// Line appends a transcript line.
+func (w *callStateWriter) Line(line TranscriptLine) {
+	if w == nil {
+		return
+	}
+	w.enqueue(func(ctx context.Context) error {
+		return w.store.AppendTranscript(ctx, w.callSID, callstate.Line{
+			At:      line.At,
+			Speaker: line.Speaker,
+			Text:    line.Text,
+			Target:  string(line.Target),
+		})
+	})
+}
+
+// History saves the agent's conversation for the session, if the agent
+// keeps one.
+func (w *callStateWriter) History(a agent.Agent, sessionID string) {
+	r, ok := a.(agent.Resumer)
+	if w == nil || !ok {
+		return
+	}
+	history := r.History(sessionID)
+	w.enqueue(func(ctx context.Context) error {
+		return w.store.PutHistory(ctx, w.callSID, history)
+	})
+}
+
+// Close waits for queued writes. If the call is over, its state is
+// deleted; otherwise it is kept for a stream that reconnects.
+func (w *callStateWriter) Close(callOver bool) {
+	if w == nil {
+		return
+	}
+	if callOver {
+		w.enqueue(func(ctx context.Context) error {
+			return w.store.Delete(ctx, w.callSID)
+		})
+	}
+	w.mu.Lock()
+	w.closed = true
+	close(w.ops)
+	w.mu.Unlock()
+	<-w.done
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+
+	"github.com/agentplexus/omnivoice-examples/kit/config"
+	"github.com/agentplexus/omnivoice/stt"
+	interfaces "github.com/deepgram/deepgram-go-sdk/v3/pkg/client/interfaces"
+)
+
+// validateSTTFormatting checks the formatting options asked of Deepgram.
+// omnivoice-deepgram always asks for smart formatting, so it can't be
+// turned off.
+func validateSTTFormatting(cfg config.Deepgram) error {
+	if !cfg.SmartFormat {
+		return errors.New("deepgram.smart_format (DEEPGRAM_SMART_FORMAT) can't be turned off: omnivoice-deepgram always asks for smart formatting")
+	}
+	return nil
+}
+
+// sttFormatting returns the query parameters asking Deepgram for the
+// formatting options in cfg that omnivoice-deepgram doesn't ask for
+// itself, or nil if there are none.
+func sttFormatting(cfg config.Deepgram) map[string][]string {
+	var params map[string][]string
+	for name, on := range map[string]bool{
+		"numerals":         cfg.Numerals,
+		"profanity_filter": cfg.ProfanityFilter,
+		"filler_words":     cfg.FillerWords,
+	} {
+		if !on {
+			continue
+		}
+		if params == nil {
+			params = make(map[string][]string)
+		}
+		params[name] = []string{"true"}
+	}
+	return params
+}
+
+// formattedSTT asks Deepgram for formatting options that
+// stt.TranscriptionConfig has no fields for. The Deepgram SDK adds the
+// custom parameters in the stream's context to the URL it connects to;
+// other providers ignore them.
+type formattedSTT struct {
+	stt.StreamingProvider
+	params map[string][]string
+}
+
+// withSTTFormatting returns p asking for the formatting options in cfg,
+// or p itself if it needs to ask for none.
+func withSTTFormatting(p stt.StreamingProvider, cfg config.Deepgram) stt.StreamingProvider {
+	params := sttFormatting(cfg)
+	if params == nil {
+		return p
+	}
+	return &formattedSTT{StreamingProvider: p, params: params}
+}
+
+// TranscribeStream opens a stream with the formatting parameters.
+func (p *formattedSTT) TranscribeStream(ctx context.Context, config stt.TranscriptionConfig) (io.WriteCloser, <-chan stt.StreamEvent, error) {
+	return p.StreamingProvider.TranscribeStream(interfaces.WithCustomParameters(ctx, p.params), config)
+}
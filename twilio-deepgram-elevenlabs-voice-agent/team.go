@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+
+	"github.com/agentplexus/omnivoice-examples/kit/agent"
+	"github.com/agentplexus/omnivoice-examples/kit/config"
+)
+
+// newTeam answers with a team of specialists on model that hand the call
+// to each other, each with its own prompt and its pick of guard's tools.
+// Specialists without a prompt get systemPrompt.
+func newTeam(model config.LLM, systemPrompt string, team []config.Specialist, guard LLMGuard) (*agent.Team, error) {
+	if model.Provider == "" {
+		return nil, errors.New("team needs an LLM provider")
+	}
+	provider, err := guard.provider(model)
+	if err != nil {
+		return nil, err
+	}
+	provider, err = guard.wrap(provider, model)
+	if err != nil {
+		return nil, err
+	}
+	specialists := make([]agent.Specialist, len(team))
+	for i, s := range team {
+		tools := []agent.Tool{agent.ReadbackTool()}
+		for _, name := range s.Tools {
+			j := slices.IndexFunc(guard.Tools, func(t agent.Tool) bool { return t.Name == name })
+			if j < 0 {
+				return nil, fmt.Errorf("team specialist %s: no tool named %q", s.Name, name)
+			}
+			tools = append(tools, guard.Tools[j])
+		}
+		if len(s.Tools) == 0 {
+			tools = append(tools, guard.Tools...)
+		}
+		specialists[i] = agent.Specialist{
+			Name:        s.Name,
+			Description: s.Description,
+			System:      guard.system(firstNonEmpty(s.Prompt, systemPrompt, agent.DefaultSystemPrompt)),
+			Tools:       tools,
+		}
+	}
+	t, err := agent.NewTeam(provider, specialists...)
+	if err != nil {
+		return nil, err
+	}
+	if g := guard.guardrails(); g != nil {
+		t.WithGuardrails(g)
+	}
+	t.OnHandoff = func(sessionID, from, to, reason string) {
+		slog.Info("agent handoff", "session", sessionID, "from", from, "to", to, "reason", reason)
+	}
+	return t, nil
+}
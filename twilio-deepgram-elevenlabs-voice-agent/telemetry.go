@@ -0,0 +1,177 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+
+	"github.com/agentplexus/omnivoice-examples/kit/agent"
+	"github.com/agentplexus/omnivoice-examples/kit/config"
+	"github.com/agentplexus/omnivoice-examples/kit/mock"
+	"github.com/agentplexus/omnivoice-examples/kit/session"
+	"github.com/agentplexus/omnivoice-examples/kit/telemetry"
+	"github.com/agentplexus/omnivoice/transport"
+)
+
+// telemetryApp identifies this example in usage summaries.
+const telemetryApp = "twilio-deepgram-elevenlabs-voice-agent"
+
+// newTelemetry returns the usage reporter configured by cfg.Telemetry, or
+// nil if telemetry is off (the default).
+func newTelemetry(cfg config.Telemetry) (*telemetry.Reporter, error) {
+	mode, err := telemetry.ParseMode(cfg.Mode)
+	if err != nil {
+		return nil, fmt.Errorf("invalid TELEMETRY: %w", err)
+	}
+	return telemetry.New(telemetry.Config{
+		Mode:     mode,
+		File:     cfg.File,
+		Endpoint: cfg.Endpoint,
+		Interval: cfg.Interval,
+		App:      telemetryApp,
+	})
+}
+
+// configuredFeatures names the optional parts of the server's setup, for
+// telemetry.
+func (s *Server) configuredFeatures(cfg config.Config, offline bool) []string {
+	var features []string
+	add := func(on bool, name string) {
+		if on {
+			features = append(features, name)
+		}
+	}
+	add(os.Getenv("CONFIG_FILE") != "", "config_file")
+	add(offline, "offline")
+	add(len(s.tenants) > 0, "tenants")
+	add(s.experiments != nil, "experiments")
+	add(s.ttsPCMRate > 0, "pcm_output")
+	add(cfg.ElevenLabs.VoiceSettings != (config.VoiceSettings{}), "voice_settings")
+	add(s.echoGuard.Enabled, "echo_guard")
+	add(s.ambience != nil, "ambience")
+	add(s.comfortNoise != nil, "comfort_noise")
+	add(s.doubleTalk.policy == session.DoubleTalkDuck, "double_talk_duck")
+	add(s.doubleTalk.policy == session.DoubleTalkFinish, "double_talk_finish")
+	add(s.sessions.limits.QueueSize > 0, "hold_queue")
+	add(s.businessHours != nil, "business_hours")
+	add(s.itn, "itn")
+	add(s.confidence != nil && s.confidence.policy.Threshold > 0, "confidence_reprompt")
+	add(s.snippets.Enabled, "audio_snippets")
+	add(cfg.Deepgram.Numerals, "stt_numerals")
+	add(cfg.Deepgram.ProfanityFilter, "stt_profanity_filter")
+	add(cfg.Deepgram.FillerWords, "stt_filler_words")
+	add(cfg.Features.Guardrails && cfg.LLM.Provider != "", "guardrails")
+	add(s.emotions != nil, "emotion_tags")
+	add(hasPronunciations(cfg), "pronunciations")
+	add(s.moderator != nil, "moderation")
+	interceptors, _ := interceptorsFromEnv()
+	add(slices.Contains(interceptors, interceptRedact), "redact")
+	add(slices.Contains(interceptors, interceptLog), "log_interceptor")
+	add(s.termination.Hangup, "goodbye_hangup")
+	add(s.silence.PromptAfter > 0 || s.silence.HangupAfter > 0, "silence_timeouts")
+	add(s.silence.MaxDuration > 0, "max_call")
+	add(cfg.Twilio.ConnectMessage != "" || cfg.Twilio.SayVoice != "" || cfg.Twilio.SayLanguage != "", "connect_message")
+	add(len(s.callTwiML.Parameters) > 0, "stream_parameters")
+	add(s.transfer.Enabled(), "transfer")
+	add(s.transfer.Coaching, "coaching")
+	add(s.emergency.Enabled(), "emergency_detection")
+	add(s.dial != nil, "dnc")
+	add(s.callers != nil, "crm")
+	add(s.campaign != nil, "campaign")
+	add(s.sms != nil, "sms")
+	add(s.email != nil, "email")
+	add(s.booking != nil, "booking")
+	add(s.payments != nil, "payments")
+	add(len(cfg.Team) > 0, "agent_team")
+	add(s.teamVoices != nil, "specialist_voices")
+	add(s.intents != nil, "intents")
+	add(s.degradation != nil, "degradation")
+	add(s.resilience.TTSFallbackVoiceID != "", "tts_fallback_voice")
+	add(s.outbound.Buffer.Policy == OutboundDropOldest, "outbound_drop_oldest")
+	add(s.concurrency.Config.TranscodeWorkers == 0, "inline_transcode")
+	add(s.ttsCache != nil, "tts_cache")
+	add(s.prompts != nil, "prompts")
+	add(s.fallback != nil && s.fallback.stt != nil, "stt_fallback")
+	add(s.fallback != nil && s.fallback.tts != nil, "tts_fallback")
+	llmFallback := cfg.LLM.Provider != "" && (os.Getenv("LLM_FALLBACK_PROVIDER") != "" || os.Getenv("LLM_FALLBACK_MODEL") != "")
+	add(llmFallback && os.Getenv("LLM_GUARD") != llmGuardHedge, "llm_breaker")
+	add(llmFallback && os.Getenv("LLM_GUARD") == llmGuardHedge, "llm_hedge")
+	add(os.Getenv("TTS_MARKUP") != "" && os.Getenv("TTS_MARKUP") != "auto", "tts_markup")
+	add(s.state.Store != nil, "redis")
+	add(s.held != nil, "stream_resume")
+	add(s.eventWebhook != nil, "events_webhook")
+	add(s.archive != nil, "storage")
+	add(s.archive != nil && s.archive.format.Format != "", "recording_format")
+	add(s.signatures != nil, "signatures")
+	add(cfg.Server.AdminToken != "", "admin_api")
+	add(s.logDir != "", "call_logs")
+	add(os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "", "tracing")
+	add(os.Getenv("ELEVENLABS_REGIONS") != "" || os.Getenv("DEEPGRAM_REGIONS") != "", "regions")
+	add(os.Getenv("DATA_RESIDENCY") != "", "residency")
+	return features
+}
+
+// callUsage collects the features a call exercises as it goes. Only
+// feature names are kept.
+type callUsage struct {
+	mu       sync.Mutex
+	features []string
+}
+
+// Add records that the call used a feature.
+func (u *callUsage) Add(feature string) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if !slices.Contains(u.features, feature) {
+		u.features = append(u.features, feature)
+	}
+}
+
+// recordUsage counts a finished call in the usage summary, if telemetry is
+// on: the providers and transport that served it, its codec, and the
+// features it used, whether collected in usage or read off its CDR.
+func (s *Server) recordUsage(conn transport.Connection, t *tenant, cdr *CallDetailRecord, codec string, usage *callUsage) {
+	if s.usage == nil {
+		return
+	}
+	transportName := s.twilioTransport.Name()
+	if _, ok := conn.(*mock.Conn); ok {
+		transportName = "mock"
+	}
+	// Language models are counted by provider, other agents by kind
+	brain := "agent:custom"
+	switch a := t.agent.(type) {
+	case *agent.Echo:
+		brain = "agent:echo"
+	case *agent.Script:
+		brain = "agent:script"
+	case *agent.LLM:
+		brain = "llm:" + a.Provider()
+	case *agent.Team:
+		brain = "llm:" + a.Provider()
+	}
+
+	usage.mu.Lock()
+	features := slices.Clone(usage.features)
+	usage.mu.Unlock()
+	add := func(on bool, name string) {
+		if on {
+			features = append(features, name)
+		}
+	}
+	add(cdr.Tenant != "", "tenant")
+	add(cdr.AccountID != "" || cdr.TicketID != "", "call_metadata")
+	add(len(cdr.RecordingSIDs) > 0, "recording")
+	add(cdr.TransferredTo != "", "transfer")
+	add(cdr.Coached, "coaching")
+	add(len(cdr.Topics) > 1, "topic_change")
+	features = append(features, "ended_by:"+cdr.EndedBy)
+
+	s.usage.RecordCall(telemetry.Call{
+		Providers: []string{"stt:" + s.sttProvider.Name(), "tts:" + s.ttsProvider.Name(), brain},
+		Transport: transportName,
+		Codec:     codec,
+		Features:  features,
+	})
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+
+	"github.com/agentplexus/omnivoice-examples/kit/agent"
+	"github.com/agentplexus/omnivoice-examples/kit/config"
+	"github.com/agentplexus/omnivoice-examples/kit/phone"
+	"github.com/agentplexus/omnivoice-examples/kit/speech"
+)
+
+// tenant is the agent a call gets, chosen by the number called: its brain,
+// voice, language and greeting.
+type tenant struct {
+	name     string
+	agent    agent.Agent
+	tts      config.ElevenLabs
+	stt      config.Deepgram
+	greeting string
+	// lexicon, if set, replaces the top-level pronunciations for a tenant
+	// with pronunciations of its own.
+	lexicon *speech.Lexicon
+	// doubleTalk is what the agent does when the caller talks over it.
+	doubleTalk doubleTalk
+}
+
+// newBrain answers with a language model when one is configured, otherwise
+// it echoes. The model is put behind guard, with its guardrails.
+func newBrain(model config.LLM, systemPrompt string, guard LLMGuard) (agent.Agent, error) {
+	if model.Provider == "" {
+		return agent.NewEcho(), nil
+	}
+	provider, err := guard.provider(model)
+	if err != nil {
+		return nil, err
+	}
+	provider, err = guard.wrap(provider, model)
+	if err != nil {
+		return nil, err
+	}
+	tools := append([]agent.Tool{agent.ReadbackTool()}, guard.Tools...)
+	brain := agent.NewLLM(provider, guard.system(firstNonEmpty(systemPrompt, agent.DefaultSystemPrompt)), "", tools...)
+	if g := guard.guardrails(); g != nil {
+		brain.WithGuardrails(g)
+	}
+	return brain, nil
+}
+
+// guardrails returns the guardrails agents built behind guard get, or nil
+// if they get none.
+func (guard LLMGuard) guardrails() *agent.Guardrails {
+	if !guard.Guardrails {
+		return nil
+	}
+	g := agent.DefaultGuardrails()
+	g.OnInjection = func(sessionID string, matches []string) {
+		slog.Warn("prompt injection attempt", "session", sessionID, "matches", matches)
+	}
+	return g
+}
+
+// newTenants builds the agent for each number in cfg.Tenants, keyed by the
+// number read with plan. Fields a tenant leaves empty come from the
+// top-level configuration, and tenants with the top-level model and prompt
+// share brain. Tenants' models are put behind guard.
+func newTenants(cfg config.Config, brain agent.Agent, plan phone.DialPlan, guard LLMGuard) (map[string]*tenant, error) {
+	tenants := make(map[string]*tenant, len(cfg.Tenants))
+	for number := range cfg.Tenants {
+		n, err := plan.Parse(number)
+		if err != nil {
+			return nil, fmt.Errorf("tenant %s: %w", number, err)
+		}
+		c, _ := cfg.TenantFor(number)
+		t := &tenant{
+			name:     firstNonEmpty(c.Name, number),
+			agent:    brain,
+			tts:      cfg.ElevenLabs,
+			stt:      cfg.Deepgram,
+			greeting: c.Prompts.Greeting,
+		}
+		t.tts.VoiceID, t.tts.Model = c.VoiceID, c.TTSModel
+		t.stt.Model, t.stt.Language = c.STTModel, c.Language
+		if t.doubleTalk, err = doubleTalkFromConfig(c.DoubleTalk); err != nil {
+			return nil, fmt.Errorf("tenant %s: %w", number, err)
+		}
+		if len(cfg.Tenants[number].Pronunciations) > 0 {
+			if t.lexicon, err = newLexicon(c.Pronunciations); err != nil {
+				return nil, fmt.Errorf("tenant %s: %w", number, err)
+			}
+		}
+		if c.LLM != cfg.LLM || c.Prompts.System != cfg.Prompts.System {
+			b, err := newBrain(c.LLM, c.Prompts.System, guard)
+			if err != nil {
+				return nil, fmt.Errorf("tenant %s: %w", number, err)
+			}
+			t.agent = b
+		}
+		tenants[n.Address()] = t
+	}
+	return tenants, nil
+}
+
+// tenantFor returns the tenant for calls to number, which must be
+// normalized, or the server's own agent for numbers without one.
+func (s *Server) tenantFor(number string) *tenant {
+	if t, ok := s.tenants[number]; ok {
+		return t
+	}
+	return &tenant{agent: s.agent, tts: s.tts, stt: s.stt, doubleTalk: s.doubleTalk}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"os"
+	"slices"
+	"strings"
+)
+
+// TerminationPolicy decides how a conversation ends when the caller says
+// goodbye: optionally confirm if a task is still in progress, speak a
+// closing line, then actively end the call instead of waiting for the
+// caller to hang up.
+type TerminationPolicy struct {
+	// Phrases that signal the caller wants to end the call, matched as
+	// whole words anywhere in an utterance.
+	Phrases []string
+	// ClosingLine is spoken before hanging up.
+	ClosingLine string
+	// ConfirmPrompt is spoken instead of hanging up when the agent reports
+	// a task in progress. An affirmative reply ends the call.
+	ConfirmPrompt string
+	// Hangup ends the call via the Twilio REST API once the closing line
+	// has played. When false the caller is left to hang up.
+	Hangup bool
+}
+
+// defaultTerminationPolicy returns the policy used unless overridden by
+// GOODBYE_PHRASES and GOODBYE_CLOSING_LINE. Hangup is set from the
+// config's features.goodbye_hangup (GOODBYE_HANGUP).
+func defaultTerminationPolicy() TerminationPolicy {
+	return TerminationPolicy{
+		Phrases:       []string{"goodbye", "bye", "that's all", "that is all", "hang up", "end the call", "talk to you later"},
+		ClosingLine:   "Goodbye! It was nice talking with you. Have a wonderful day!",
+		ConfirmPrompt: "Before you go, we haven't quite finished. Would you like to end the call now?",
+		Hangup:        true,
+	}
+}
+
+// terminationPolicyFromEnv applies environment overrides to the default policy.
+func terminationPolicyFromEnv() TerminationPolicy {
+	policy := defaultTerminationPolicy()
+	if v := os.Getenv("GOODBYE_PHRASES"); v != "" {
+		policy.Phrases = nil
+		for _, phrase := range strings.Split(v, ",") {
+			if phrase = strings.TrimSpace(phrase); phrase != "" {
+				policy.Phrases = append(policy.Phrases, phrase)
+			}
+		}
+	}
+	if v := os.Getenv("GOODBYE_CLOSING_LINE"); v != "" {
+		policy.ClosingLine = v
+	}
+	return policy
+}
+
+// IsGoodbye reports whether an utterance contains a goodbye phrase that
+// isn't negated, so "please don't hang up" and "don't say bye yet" don't
+// end the call.
+func (p TerminationPolicy) IsGoodbye(text string) bool {
+	words := normalizeWords(strings.ReplaceAll(text, "’", "'"))
+	for _, phrase := range p.Phrases {
+		phrase := normalizeWords(phrase)
+		for i := 0; i+len(phrase) <= len(words); i++ {
+			if len(phrase) > 0 && slices.Equal(words[i:i+len(phrase)], phrase) && !negated(words[:i]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// goodbyeNegations negate a goodbye phrase up to negationReach words
+// after them. A bare "no" doesn't: "no, that's all" is a goodbye.
+var goodbyeNegations = []string{"don't", "dont", "not", "never", "didn't", "won't", "can't", "cannot"}
+
+const negationReach = 2
+
+// negated reports whether the words before a phrase end in a negation
+// that reaches it.
+func negated(before []string) bool {
+	for _, w := range before[max(len(before)-negationReach, 0):] {
+		if slices.Contains(goodbyeNegations, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// Words that answer a yes/no question. Any negation outweighs a yes, so
+// "no, please don't", "not sure" and "that's not ok" aren't taken as one.
+var (
+	affirmativeWords = []string{"yes", "yeah", "yep", "yup", "sure", "correct", "ok", "okay"}
+	negativeWords    = []string{"no", "nope", "nah", "not", "don't", "dont", "do not", "never", "wait"}
+)
+
+// isAffirmative reports whether an utterance answers a yes/no question with
+// yes, and with nothing that negates it.
+func isAffirmative(text string) bool {
+	words := normalizeWords(strings.ReplaceAll(text, "’", "'"))
+	for _, no := range negativeWords {
+		if containsWords(words, strings.Fields(no)) {
+			return false
+		}
+	}
+	for _, yes := range affirmativeWords {
+		if slices.Contains(words, yes) {
+			return true
+		}
+	}
+	return false
+}
+
+// containsWords reports whether phrase occurs as a contiguous word sequence in words.
+func containsWords(words, phrase []string) bool {
+	if len(phrase) == 0 {
+		return false
+	}
+	for i := 0; i+len(phrase) <= len(words); i++ {
+		if slices.Equal(words[i:i+len(phrase)], phrase) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,58 @@
+package main
+
+import "testing"
+
+func TestIsAffirmative(t *testing.T) {
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{"Yes.", true},
+		{"Yeah, go ahead", true},
+		{"Sure", true},
+		{"OK, thanks", true},
+		{"That's correct", true},
+		{"No, please don't hang up", false},
+		{"Please don't", false},
+		{"I'm not sure", false},
+		{"That's not ok", false},
+		{"Don’t, I still need help", false},
+		{"Yes, no wait", false},
+		{"please", false},
+		{"I'd like to keep going", false},
+	}
+	for _, tt := range tests {
+		if got := isAffirmative(tt.text); got != tt.want {
+			t.Errorf("isAffirmative(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestIsGoodbye(t *testing.T) {
+	p := defaultTerminationPolicy()
+	tests := []struct {
+		text string
+		want bool
+	}{
+		{"Goodbye", true},
+		{"OK, bye!", true},
+		{"No, that's all, thanks", true},
+		{"You can hang up now", true},
+		{"Thanks, talk to you later", true},
+		{"I don't need anything else. Bye.", true},
+		{"Please don't hang up", false},
+		{"Don’t hang up on me", false},
+		{"don't say bye yet", false},
+		{"Do not end the call", false},
+		{"I'm not saying goodbye", false},
+		{"Never hang up on a customer", false},
+		{"I didn't say bye", false},
+		{"I'd like to book a table", false},
+		{"The byelaws say otherwise", false},
+	}
+	for _, tt := range tests {
+		if got := p.IsGoodbye(tt.text); got != tt.want {
+			t.Errorf("IsGoodbye(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
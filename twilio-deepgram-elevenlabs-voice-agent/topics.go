@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// topicGeneral labels conversation before any topic has been recognised.
+const topicGeneral = "general"
+
+// Topic is a conversation topic recognised by its keywords. Single-word
+// keywords match any word they prefix ("bill" matches "billing"); phrases
+// match whole words in order.
+type Topic struct {
+	Label    string
+	Keywords []string
+}
+
+// defaultTopics is a customer-service lexicon, used unless TOPIC_KEYWORDS is set.
+func defaultTopics() []Topic {
+	return []Topic{
+		{Label: "billing", Keywords: []string{"bill", "invoice", "charge", "payment", "refund", "price", "overcharged", "statement"}},
+		{Label: "cancellation", Keywords: []string{"cancel", "terminate", "close my account", "stop my subscription", "unsubscribe"}},
+		{Label: "retention offer", Keywords: []string{"discount", "offer", "promotion", "free month", "stay with us", "loyalty"}},
+		{Label: "technical support", Keywords: []string{"not working", "broken", "error", "outage", "reset", "restart", "slow", "connect"}},
+		{Label: "account", Keywords: []string{"password", "login", "log in", "address", "email", "username", "profile"}},
+		{Label: "orders", Keywords: []string{"order", "delivery", "shipping", "tracking", "package", "return"}},
+	}
+}
+
+// parseTopics parses "label=kw,kw;label=kw,..." as used by TOPIC_KEYWORDS.
+func parseTopics(spec string) ([]Topic, error) {
+	var topics []Topic
+	for _, part := range strings.Split(spec, ";") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		label, keywords, ok := strings.Cut(part, "=")
+		label = strings.TrimSpace(label)
+		if !ok || label == "" {
+			return nil, fmt.Errorf("invalid topic %q (want label=keyword,keyword)", part)
+		}
+		topic := Topic{Label: label}
+		for _, kw := range strings.Split(keywords, ",") {
+			if kw = strings.TrimSpace(kw); kw != "" {
+				topic.Keywords = append(topic.Keywords, kw)
+			}
+		}
+		if len(topic.Keywords) == 0 {
+			return nil, fmt.Errorf("topic %q has no keywords", label)
+		}
+		topics = append(topics, topic)
+	}
+	return topics, nil
+}
+
+// topicsFromEnv returns the configured topic lexicon.
+func topicsFromEnv() ([]Topic, error) {
+	spec := os.Getenv("TOPIC_KEYWORDS")
+	if spec == "" {
+		return defaultTopics(), nil
+	}
+	topics, err := parseTopics(spec)
+	if err != nil {
+		return nil, fmt.Errorf("TOPIC_KEYWORDS: %w", err)
+	}
+	return topics, nil
+}
+
+// TopicSegment is a stretch of the call spent on one topic. Turns are the
+// caller's utterances, numbered from 1 as in the CDR's turn count.
+type TopicSegment struct {
+	Label     string    `json:"label"`
+	StartTurn int       `json:"start_turn"`
+	EndTurn   int       `json:"end_turn"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// topicSegmenter splits a call's transcript into topic segments as it
+// happens. Each utterance is scored against the lexicon; a new segment
+// starts when a different topic is clearly signalled (several keywords in
+// one utterance) or signalled in two consecutive utterances, so a passing
+// mention doesn't fragment the call. Utterances with no signal extend the
+// current segment.
+type topicSegmenter struct {
+	topics []Topic
+
+	mu       sync.Mutex
+	segments []TopicSegment
+	pending  *TopicSegment // topic signalled once, awaiting confirmation
+}
+
+// newTopicSegmenter creates a segmenter using the given lexicon.
+func newTopicSegmenter(topics []Topic) *topicSegmenter {
+	return &topicSegmenter{topics: topics}
+}
+
+// Add processes an utterance from either side of the call during the
+// given caller turn.
+func (s *topicSegmenter) Add(turn int, text string) {
+	label, score := s.classify(text)
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.segments) == 0 {
+		s.segments = append(s.segments, TopicSegment{Label: topicGeneral, StartTurn: turn, StartedAt: now})
+	}
+	current := &s.segments[len(s.segments)-1]
+
+	switch {
+	case label == "":
+		current.EndTurn = max(current.EndTurn, turn)
+	case label == current.Label:
+		s.pending = nil
+		current.EndTurn = max(current.EndTurn, turn)
+	case score >= 2 || current.Label == topicGeneral:
+		s.start(TopicSegment{Label: label, StartTurn: turn, EndTurn: turn, StartedAt: now})
+	case s.pending != nil && s.pending.Label == label:
+		// Confirmed: the topic began where it was first mentioned.
+		next := *s.pending
+		next.EndTurn = turn
+		s.start(next)
+	default:
+		s.pending = &TopicSegment{Label: label, StartTurn: turn, StartedAt: now}
+		current.EndTurn = max(current.EndTurn, turn)
+	}
+}
+
+// start closes the current segment and opens next.
+func (s *topicSegmenter) start(next TopicSegment) {
+	s.pending = nil
+	current := &s.segments[len(s.segments)-1]
+	if current.Label == topicGeneral && current.StartTurn == next.StartTurn {
+		// Nothing was said before the first topic; relabel instead.
+		current.Label = next.Label
+		current.EndTurn = next.EndTurn
+		return
+	}
+	if next.StartTurn < next.EndTurn {
+		// The topic began in an earlier turn; hand those turns over.
+		current.EndTurn = max(current.StartTurn, next.StartTurn-1)
+	}
+	s.segments = append(s.segments, next)
+}
+
+// Segments returns the segments so far.
+func (s *topicSegmenter) Segments() []TopicSegment {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]TopicSegment(nil), s.segments...)
+}
+
+// classify returns the best-scoring topic for an utterance and its keyword
+// count, or "" if no keyword matched.
+func (s *topicSegmenter) classify(text string) (string, int) {
+	words := normalizeWords(text)
+	best, bestScore := "", 0
+	for _, topic := range s.topics {
+		score := 0
+		for _, kw := range topic.Keywords {
+			if matchesKeyword(words, normalizeWords(kw)) {
+				score++
+			}
+		}
+		if score > bestScore {
+			best, bestScore = topic.Label, score
+		}
+	}
+	return best, bestScore
+}
+
+// matchesKeyword reports whether a keyword occurs in words. Single words
+// match as prefixes so inflections count.
+func matchesKeyword(words, keyword []string) bool {
+	if len(keyword) != 1 {
+		return containsWords(words, keyword)
+	}
+	for _, w := range words {
+		if strings.HasPrefix(w, keyword[0]) {
+			return true
+		}
+	}
+	return false
+}
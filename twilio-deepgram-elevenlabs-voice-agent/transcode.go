@@ -0,0 +1,209 @@
+package main
+
+import (
+	"io"
+	"sync"
+
+	"github.com/agentplexus/omnivoice-examples/kit/audio"
+	"github.com/agentplexus/omnivoice/transport"
+)
+
+// codecOf returns the wire codec of a connection. Transports that carry
+// more than mu-law (SIP trunks, A-law or G.722 pass-through) report it via
+// an optional Codec method; Twilio Media Streams is always mu-law, so the
+// configured fallback applies.
+func codecOf(conn transport.Connection, fallback audio.Codec) audio.Codec {
+	if c, ok := conn.(interface{ Codec() string }); ok {
+		if codec, err := audio.ParseCodec(c.Codec()); err == nil {
+			return codec
+		}
+	}
+	return fallback
+}
+
+// ttsFormat returns the TTS output format and sample rate for a wire codec,
+// and whether the audio must be transcoded locally. ElevenLabs emits mu-law
+// and A-law natively; G.722, or any codec when pcmRate is set, is produced
+// from linear PCM.
+func ttsFormat(codec audio.Codec, pcmRate int) (format string, rate int, transcode bool) {
+	switch {
+	case pcmRate > 0:
+		return "pcm", pcmRate, true
+	case codec == audio.CodecMulaw:
+		return "ulaw", 8000, false
+	case codec == audio.CodecAlaw:
+		return "alaw", 8000, false
+	default:
+		return "pcm", codec.SampleRate(), true
+	}
+}
+
+// sttFormat returns the STT encoding and sample rate for a wire codec, and
+// whether inbound audio must be decoded locally. Deepgram accepts mu-law and
+// A-law directly; G.722 is decoded to 16kHz linear PCM.
+func sttFormat(codec audio.Codec) (encoding string, rate int, decode bool) {
+	switch codec {
+	case audio.CodecMulaw:
+		return "mulaw", 8000, false
+	case audio.CodecAlaw:
+		return "alaw", 8000, false
+	default:
+		return "linear16", codec.SampleRate(), true
+	}
+}
+
+// transcodingConnection converts audio between the wire codec and the
+// linear PCM a provider produces or expects. ElevenLabs and Deepgram handle
+// G.711 natively, but this shows what to do for G.722 and for providers (or
+// voices) that only produce 16/24/48kHz PCM.
+type transcodingConnection struct {
+	transport.Connection
+	writer io.WriteCloser // nil passes outbound audio through
+	reader io.Reader      // nil passes inbound audio through
+}
+
+// newTranscodingConnection wraps conn so that writes to AudioIn are
+// little-endian 16-bit PCM at sampleRate, encoded to codec on the wire by
+// workers.
+func newTranscodingConnection(conn transport.Connection, sampleRate int, codec audio.Codec, quality audio.Quality, workers *transcodeWorkers) (*transcodingConnection, error) {
+	w := &pcmEncodingWriter{dst: conn.AudioIn(), encoder: codec.NewEncoder(), workers: workers, done: make(chan struct{}, 1)}
+	if sampleRate != codec.SampleRate() {
+		resampler, err := audio.NewResampler(sampleRate, codec.SampleRate(), quality)
+		if err != nil {
+			return nil, err
+		}
+		w.resampler = resampler
+	}
+	return &transcodingConnection{Connection: conn, writer: w}, nil
+}
+
+// newDecodingConnection wraps conn so that AudioOut yields little-endian
+// 16-bit PCM at the codec's sample rate, decoded by workers.
+func newDecodingConnection(conn transport.Connection, codec audio.Codec, workers *transcodeWorkers) *transcodingConnection {
+	return &transcodingConnection{
+		Connection: conn,
+		reader:     &pcmDecodingReader{src: conn.AudioOut(), decoder: codec.NewDecoder(), workers: workers, done: make(chan struct{}, 1)},
+	}
+}
+
+// AudioIn returns the transcoding writer.
+func (c *transcodingConnection) AudioIn() io.WriteCloser {
+	if c.writer == nil {
+		return c.Connection.AudioIn()
+	}
+	return c.writer
+}
+
+// AudioOut returns the decoding reader.
+func (c *transcodingConnection) AudioOut() io.Reader {
+	if c.reader == nil {
+		return c.Connection.AudioOut()
+	}
+	return c.reader
+}
+
+// pcmEncodingWriter resamples PCM chunks to the codec's rate and encodes them.
+type pcmEncodingWriter struct {
+	dst       io.Writer
+	resampler *audio.Resampler // nil when the rates already match
+	encoder   audio.Encoder
+	// workers transcode, signalling done; nil transcodes in Write.
+	workers *transcodeWorkers
+	done    chan struct{}
+
+	mu   sync.Mutex
+	odd  byte // trailing byte of a sample split across writes
+	held bool // whether odd holds a byte
+	// in is the PCM being written, less odd. pcm, resampled and encoded
+	// are reused from write to write; dst doesn't retain what it is
+	// written.
+	in             []byte
+	pcm, resampled []int16
+	encoded        []byte
+}
+
+func (w *pcmEncodingWriter) Write(b []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	n := len(b)
+	w.pcm = w.pcm[:0]
+	if w.held && len(b) > 0 {
+		w.pcm = append(w.pcm, int16(uint16(w.odd)|uint16(b[0])<<8))
+		b = b[1:]
+		w.held = false
+	}
+	if len(b)%2 == 1 {
+		w.odd, w.held = b[len(b)-1], true
+		b = b[:len(b)-1]
+	}
+
+	w.in = b
+	w.workers.run(w, w.done)
+	w.in = nil
+	if len(w.encoded) == 0 {
+		return n, nil
+	}
+	if _, err := w.dst.Write(w.encoded); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
+// transcode converts in, after any sample held over in pcm, to encoded.
+func (w *pcmEncodingWriter) transcode() {
+	w.pcm = audio.AppendPCM16FromBytes(w.pcm, w.in)
+	pcm := w.pcm
+	if w.resampler != nil {
+		w.resampled = w.resampler.AppendProcess(w.resampled[:0], pcm)
+		pcm = w.resampled
+	}
+	w.encoded = audio.AppendEncode(w.encoder, w.encoded[:0], pcm)
+}
+
+// Close implements io.WriteCloser; the underlying writer is owned by the session.
+func (w *pcmEncodingWriter) Close() error {
+	return nil
+}
+
+// pcmDecodingReader decodes inbound codec audio into 16-bit PCM.
+type pcmDecodingReader struct {
+	src     io.Reader
+	decoder audio.Decoder
+	// workers decode, signalling done; nil decodes in Read.
+	workers *transcodeWorkers
+	done    chan struct{}
+	buf     []byte
+	// in is the audio read from src. pcm and decoded are reused from read
+	// to read.
+	in      []byte
+	pcm     []int16
+	decoded []byte
+	pending []byte // decoded PCM not yet returned, the tail of decoded
+}
+
+func (r *pcmDecodingReader) Read(p []byte) (int, error) {
+	for len(r.pending) == 0 {
+		if r.buf == nil {
+			r.buf = make([]byte, 1024)
+		}
+		n, err := r.src.Read(r.buf)
+		if n > 0 {
+			r.in = r.buf[:n]
+			r.workers.run(r, r.done)
+			r.pending = r.decoded
+		}
+		if err != nil && len(r.pending) == 0 {
+			return 0, err
+		}
+	}
+	n := copy(p, r.pending)
+	r.pending = r.pending[n:]
+	return n, nil
+}
+
+// transcode decodes in to decoded.
+func (r *pcmDecodingReader) transcode() {
+	r.pcm = audio.AppendDecode(r.decoder, r.pcm[:0], r.in)
+	r.decoded = audio.AppendPCM16ToBytes(r.decoded[:0], r.pcm)
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/agentplexus/omnivoice-examples/kit/phone"
+)
+
+// TransferPolicy decides when the caller is handed to a human and whether
+// the pipeline keeps listening to coach that human.
+type TransferPolicy struct {
+	// Number is dialled to reach a human (a phone number or SIP URI).
+	// Transfer is disabled when zero.
+	Number phone.Number
+	// Phrases that ask for a human, matched as whole words.
+	Phrases []string
+	// Coaching keeps transcribing the caller after the transfer and pushes
+	// hints to the human's browser, if the caller consents.
+	Coaching bool
+	// ConsentPrompt asks the caller's permission to keep listening.
+	ConsentPrompt string
+	// HandoffLine is spoken just before the transfer.
+	HandoffLine string
+	// RefusedLine is spoken when the do-not-call gate suppresses the dial.
+	RefusedLine string
+}
+
+// defaultTransferPolicy returns the policy used unless overridden by
+// TRANSFER_NUMBER and TRANSFER_PHRASES. Coaching is set from the config's
+// features.coaching (COACHING).
+func defaultTransferPolicy() TransferPolicy {
+	return TransferPolicy{
+		Phrases:       []string{"human", "real person", "representative", "operator", "speak to someone", "talk to someone"},
+		Coaching:      true,
+		ConsentPrompt: "I'll connect you with a member of our team. Is it okay if our assistant keeps listening to help them with your request?",
+		HandoffLine:   "Connecting you now. Please hold.",
+		RefusedLine:   "I'm sorry, I can't connect you to that number right now.",
+	}
+}
+
+// transferPolicyFromEnv applies environment overrides to the default
+// policy. TRANSFER_NUMBER is read with plan, so it may be a national number
+// or carry an extension.
+func transferPolicyFromEnv(plan phone.DialPlan) (TransferPolicy, error) {
+	policy := defaultTransferPolicy()
+	if v := os.Getenv("TRANSFER_NUMBER"); v != "" {
+		number, err := plan.Parse(v)
+		if err != nil {
+			return policy, fmt.Errorf("invalid TRANSFER_NUMBER: %w", err)
+		}
+		policy.Number = number
+	}
+	if v := os.Getenv("TRANSFER_PHRASES"); v != "" {
+		policy.Phrases = nil
+		for _, phrase := range strings.Split(v, ",") {
+			if phrase = strings.TrimSpace(phrase); phrase != "" {
+				policy.Phrases = append(policy.Phrases, phrase)
+			}
+		}
+	}
+	return policy, nil
+}
+
+// Enabled reports whether transfer to a human is configured.
+func (p TransferPolicy) Enabled() bool {
+	return !p.Number.IsZero()
+}
+
+// WantsHuman reports whether an utterance asks for a human.
+func (p TransferPolicy) WantsHuman(text string) bool {
+	words := normalizeWords(text)
+	for _, phrase := range p.Phrases {
+		if containsWords(words, normalizeWords(phrase)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/agentplexus/omnivoice/tts"
+)
+
+// TTSCache keeps synthesized audio in memory, least recently used first
+// out, so phrases said again and again (greetings, confirmations, menu
+// prompts) are played from memory instead of being synthesized again.
+// Entries are keyed by the text and everything that shapes its audio: the
+// provider, voice, model, format and sample rate.
+type TTSCache struct {
+	// MaxBytes caps the audio kept.
+	MaxBytes int64
+	// TTL is how long audio is kept after it was synthesized; 0 keeps it
+	// until it is evicted.
+	TTL time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	// lru holds *ttsCacheEntry, most recently used first.
+	lru          *list.List
+	size         int64
+	hits, misses int64
+}
+
+type ttsCacheEntry struct {
+	key      string
+	chunks   [][]byte
+	size     int64
+	storedAt time.Time
+}
+
+// ttsCacheFromEnv builds the cache from TTS_CACHE_MB, the most audio to
+// keep in megabytes, and TTS_CACHE_TTL (default 24h), how long to keep it.
+// It returns nil if TTS_CACHE_MB isn't set or is 0.
+func ttsCacheFromEnv() (*TTSCache, error) {
+	v := os.Getenv("TTS_CACHE_MB")
+	if v == "" {
+		return nil, nil
+	}
+	mb, err := strconv.Atoi(v)
+	if err != nil || mb < 0 {
+		return nil, fmt.Errorf("invalid TTS_CACHE_MB: %q", v)
+	}
+	if mb == 0 {
+		return nil, nil
+	}
+	c := newTTSCache(int64(mb)<<20, 24*time.Hour)
+	if v := os.Getenv("TTS_CACHE_TTL"); v != "" {
+		if c.TTL, err = time.ParseDuration(v); err != nil || c.TTL < 0 {
+			return nil, fmt.Errorf("invalid TTS_CACHE_TTL: %q", v)
+		}
+	}
+	return c, nil
+}
+
+// newTTSCache returns a cache holding up to maxBytes of audio for ttl.
+func newTTSCache(maxBytes int64, ttl time.Duration) *TTSCache {
+	return &TTSCache{MaxBytes: maxBytes, TTL: ttl, entries: make(map[string]*list.Element), lru: list.New()}
+}
+
+// Wrap returns p with its streamed synthesis served from the cache, or p
+// itself if c is nil. Audio played from the cache isn't synthesized, so
+// p should be the provider that charges for synthesis.
+func (c *TTSCache) Wrap(p tts.StreamingProvider) tts.StreamingProvider {
+	if c == nil {
+		return p
+	}
+	return &cachedTTS{StreamingProvider: p, cache: c}
+}
+
+// ttsCacheKey identifies the audio of text synthesized by provider with
+// config, spoken with emotion and the pronunciations of lexicon (a
+// lexicon's Key).
+func ttsCacheKey(provider, text, emotion, lexicon string, config tts.SynthesisConfig) string {
+	return strings.Join([]string{
+		provider, config.VoiceID, config.Model, config.OutputFormat,
+		strconv.Itoa(config.SampleRate), strconv.FormatFloat(config.Speed, 'g', -1, 64), emotion, lexicon, text,
+	}, "\x00")
+}
+
+// get returns the audio stored under key, if it hasn't expired.
+func (c *TTSCache) get(key string) ([][]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if ok && c.TTL > 0 && time.Since(el.Value.(*ttsCacheEntry).storedAt) > c.TTL {
+		c.remove(el)
+		ok = false
+	}
+	if !ok {
+		c.misses++
+		return nil, false
+	}
+	c.hits++
+	c.lru.MoveToFront(el)
+	return el.Value.(*ttsCacheEntry).chunks, true
+}
+
+// put stores audio under key, evicting the least recently used audio to
+// make room. Audio larger than the whole cache isn't stored.
+func (c *TTSCache) put(key string, chunks [][]byte) {
+	var size int64
+	for _, chunk := range chunks {
+		size += int64(len(chunk))
+	}
+	if size == 0 || size > c.MaxBytes {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	for c.size+size > c.MaxBytes {
+		c.remove(c.lru.Back())
+	}
+	entry := &ttsCacheEntry{key: key, chunks: chunks, size: size, storedAt: time.Now()}
+	c.entries[key] = c.lru.PushFront(entry)
+	c.size += size
+}
+
+// remove drops an entry. c.mu must be held.
+func (c *TTSCache) remove(el *list.Element) {
+	entry := c.lru.Remove(el).(*ttsCacheEntry)
+	delete(c.entries, entry.key)
+	c.size -= entry.size
+}
+
+// ServeHTTP reports the cache's size and hit rate as JSON.
+func (c *TTSCache) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	stats := map[string]any{
+		"entries":   len(c.entries),
+		"bytes":     c.size,
+		"max_bytes": c.MaxBytes,
+		"ttl":       c.TTL.String(),
+		"hits":      c.hits,
+		"misses":    c.misses,
+	}
+	if lookups := c.hits + c.misses; lookups > 0 {
+		stats["hit_rate"] = float64(c.hits) / float64(lookups)
+	}
+	c.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		slog.Error("failed to write TTS cache stats", "error", err)
+	}
+}
+
+// cachedTTS serves streamed synthesis from a TTSCache, storing what it
+// streams in full.
+type cachedTTS struct {
+	tts.StreamingProvider
+	cache *TTSCache
+}
+
+func (p *cachedTTS) SynthesizeStream(ctx context.Context, text string, config tts.SynthesisConfig) (<-chan tts.StreamChunk, error) {
+	key := ttsCacheKey(p.Name(), text, emotionFrom(ctx), lexiconFrom(ctx).Key(), config)
+	if chunks, ok := p.cache.get(key); ok {
+		ch := make(chan tts.StreamChunk, len(chunks))
+		for i, chunk := range chunks {
+			ch <- tts.StreamChunk{Audio: chunk, IsFinal: i == len(chunks)-1}
+		}
+		close(ch)
+		return ch, nil
+	}
+
+	upstream, err := p.StreamingProvider.SynthesizeStream(ctx, text, config)
+	if err != nil {
+		return nil, err
+	}
+	ch := make(chan tts.StreamChunk)
+	go func() {
+		defer close(ch)
+		var chunks [][]byte
+		complete := true
+		for chunk := range upstream {
+			if chunk.Error != nil {
+				complete = false
+			} else if len(chunk.Audio) > 0 {
+				chunks = append(chunks, bytes.Clone(chunk.Audio))
+			}
+			select {
+			case ch <- chunk:
+			case <-ctx.Done():
+				// Drained so the provider can finish
+				complete = false
+			}
+		}
+		// Audio cut short by a barge-in or an error isn't kept
+		if complete && ctx.Err() == nil {
+			p.cache.put(key, chunks)
+		}
+	}()
+	return ch, nil
+}
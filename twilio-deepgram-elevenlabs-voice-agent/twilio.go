@@ -0,0 +1,247 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/agentplexus/omnivoice/transport"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// twilioAPIBaseURL is the Twilio REST API root.
+const twilioAPIBaseURL = "https://api.twilio.com/2010-04-01"
+
+// twilioClient is a minimal client for the Twilio REST API calls a session
+// needs. omnivoice-twilio carries the Media Streams audio; call control
+// (hangup, redirect, recording) goes through the REST API.
+type twilioClient struct {
+	accountSID string
+	authToken  string
+	baseURL    string
+	httpClient *http.Client
+	// downloadClient fetches recordings, which can take far longer than
+	// other requests; the caller's context bounds it.
+	downloadClient *http.Client
+}
+
+// newTwilioClient creates a REST client for the given account.
+func newTwilioClient(accountSID, authToken string) *twilioClient {
+	return &twilioClient{
+		accountSID:     accountSID,
+		authToken:      authToken,
+		baseURL:        twilioAPIBaseURL,
+		httpClient:     &http.Client{Timeout: 10 * time.Second},
+		downloadClient: &http.Client{},
+	}
+}
+
+// EndCall completes an in-progress call.
+func (c *twilioClient) EndCall(ctx context.Context, callSID string) error {
+	return c.updateCall(ctx, callSID, url.Values{"Status": {"completed"}})
+}
+
+// RedirectCall replaces the call's current TwiML with the given document.
+func (c *twilioClient) RedirectCall(ctx context.Context, callSID, twiml string) error {
+	return c.updateCall(ctx, callSID, url.Values{"Twiml": {twiml}})
+}
+
+// RedirectCallURL makes Twilio fetch new TwiML for the call from a URL.
+func (c *twilioClient) RedirectCallURL(ctx context.Context, callSID, twimlURL string) error {
+	return c.updateCall(ctx, callSID, url.Values{"Url": {twimlURL}, "Method": {http.MethodPost}})
+}
+
+// outboundCall is a call for CreateCall to place.
+type outboundCall struct {
+	To, From string
+	// URL is requested for the call's TwiML once it is answered.
+	URL string
+	// StatusCallback is sent the call's status once it completes,
+	// whether or not it was answered.
+	StatusCallback string
+	// Timeout is how long to let the call ring.
+	Timeout time.Duration
+	// MachineDetection has Twilio tell people from answering machines,
+	// reporting which in the AnsweredBy parameter of the TwiML request.
+	MachineDetection bool
+}
+
+// CreateCall places an outbound call and returns its SID.
+func (c *twilioClient) CreateCall(ctx context.Context, call outboundCall) (string, error) {
+	form := url.Values{
+		"To":                  {call.To},
+		"From":                {call.From},
+		"Url":                 {call.URL},
+		"Method":              {http.MethodPost},
+		"StatusCallback":      {call.StatusCallback},
+		"StatusCallbackEvent": {"completed"},
+	}
+	if call.Timeout > 0 {
+		form.Set("Timeout", strconv.Itoa(int(call.Timeout/time.Second)))
+	}
+	if call.MachineDetection {
+		form.Set("MachineDetection", "Enable")
+	}
+	var created struct {
+		SID string `json:"sid"`
+	}
+	if err := c.post(ctx, fmt.Sprintf("/Accounts/%s/Calls.json", c.accountSID), form, &created); err != nil {
+		return "", err
+	}
+	return created.SID, nil
+}
+
+// SendSMS sends a text message and returns its SID.
+func (c *twilioClient) SendSMS(ctx context.Context, to, from, body string) (string, error) {
+	var message struct {
+		SID string `json:"sid"`
+	}
+	form := url.Values{"To": {to}, "From": {from}, "Body": {body}}
+	if err := c.post(ctx, fmt.Sprintf("/Accounts/%s/Messages.json", c.accountSID), form, &message); err != nil {
+		return "", err
+	}
+	return message.SID, nil
+}
+
+// StartRecording starts recording both legs of the call and returns the
+// recording SID.
+func (c *twilioClient) StartRecording(ctx context.Context, callSID, statusURL string) (string, error) {
+	var recording struct {
+		SID string `json:"sid"`
+	}
+	path := fmt.Sprintf("/Accounts/%s/Calls/%s/Recordings.json", c.accountSID, callSID)
+	form := url.Values{"RecordingChannels": {"dual"}}
+	if statusURL != "" {
+		form.Set("RecordingStatusCallback", statusURL)
+		form.Set("RecordingStatusCallbackEvent", "completed")
+	}
+	if err := c.post(ctx, path, form, &recording); err != nil {
+		return "", err
+	}
+	return recording.SID, nil
+}
+
+// StopRecording stops an in-progress recording.
+func (c *twilioClient) StopRecording(ctx context.Context, callSID, recordingSID string) error {
+	path := fmt.Sprintf("/Accounts/%s/Calls/%s/Recordings/%s.json", c.accountSID, callSID, recordingSID)
+	return c.post(ctx, path, url.Values{"Status": {"stopped"}}, nil)
+}
+
+// PauseRecording pauses an in-progress recording, leaving the paused
+// stretch out of it altogether.
+func (c *twilioClient) PauseRecording(ctx context.Context, callSID, recordingSID string) error {
+	path := fmt.Sprintf("/Accounts/%s/Calls/%s/Recordings/%s.json", c.accountSID, callSID, recordingSID)
+	return c.post(ctx, path, url.Values{"Status": {"paused"}, "PauseBehavior": {"skip"}}, nil)
+}
+
+// ResumeRecording resumes a paused recording.
+func (c *twilioClient) ResumeRecording(ctx context.Context, callSID, recordingSID string) error {
+	path := fmt.Sprintf("/Accounts/%s/Calls/%s/Recordings/%s.json", c.accountSID, callSID, recordingSID)
+	return c.post(ctx, path, url.Values{"Status": {"in-progress"}}, nil)
+}
+
+// RecordingAudio downloads a finished recording as format, mp3 or wav.
+func (c *twilioClient) RecordingAudio(ctx context.Context, recordingSID, format string) ([]byte, error) {
+	var audio []byte
+	err := c.do(ctx, http.MethodGet, fmt.Sprintf("/Accounts/%s/Recordings/%s.%s", c.accountSID, recordingSID, format), nil, &audio)
+	return audio, err
+}
+
+// Ping fetches the account, verifying the credentials are valid.
+func (c *twilioClient) Ping(ctx context.Context) error {
+	return c.do(ctx, http.MethodGet, fmt.Sprintf("/Accounts/%s.json", c.accountSID), nil, nil)
+}
+
+// updateCall modifies a live call.
+func (c *twilioClient) updateCall(ctx context.Context, callSID string, form url.Values) error {
+	return c.post(ctx, fmt.Sprintf("/Accounts/%s/Calls/%s.json", c.accountSID, callSID), form, nil)
+}
+
+// post sends a form to the API and, when out is non-nil, decodes the JSON
+// response into it.
+func (c *twilioClient) post(ctx context.Context, path string, form url.Values, out any) error {
+	return c.do(ctx, http.MethodPost, path, form, out)
+}
+
+// do sends a request with an optional form body. A response decoded into
+// a *[]byte is read as it is rather than as JSON.
+func (c *twilioClient) do(ctx context.Context, method, path string, form url.Values, out any) (err error) {
+	ctx, span := tracer.Start(ctx, "twilio.api", trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("http.request.method", method), attribute.String("url.path", path)))
+	defer func() {
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+
+	var body io.Reader
+	if form != nil {
+		body = strings.NewReader(form.Encode())
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(c.accountSID, c.authToken)
+	if form != nil {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+
+	client := c.httpClient
+	if _, download := out.(*[]byte); download {
+		client = c.downloadClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("twilio request failed: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+
+	if resp.StatusCode >= 300 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		var apiErr struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(body, &apiErr) == nil && apiErr.Message != "" {
+			return fmt.Errorf("twilio API error %d: %s", apiErr.Code, apiErr.Message)
+		}
+		return fmt.Errorf("twilio API returned %s", resp.Status)
+	}
+	if raw, ok := out.(*[]byte); ok {
+		if *raw, err = io.ReadAll(resp.Body); err != nil {
+			return fmt.Errorf("reading twilio response: %w", err)
+		}
+		return nil
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return fmt.Errorf("decoding twilio response: %w", err)
+		}
+	}
+	return nil
+}
+
+// callSIDOf returns the Twilio Call SID of a Media Streams connection.
+// Connections that expose the "start" message's callSid are preferred;
+// otherwise the connection ID is used, which omnivoice-twilio sets to the
+// call SID.
+func callSIDOf(conn transport.Connection) string {
+	if c, ok := conn.(interface{ CallSID() string }); ok {
+		if sid := c.CallSID(); sid != "" {
+			return sid
+		}
+	}
+	return conn.ID()
+}
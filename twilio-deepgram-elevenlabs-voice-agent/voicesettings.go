@@ -0,0 +1,201 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+
+	elevenlabs "github.com/agentplexus/go-elevenlabs"
+	elevenomni "github.com/agentplexus/go-elevenlabs/omnivoice"
+	elevenvoice "github.com/agentplexus/go-elevenlabs/omnivoice/tts"
+	"github.com/agentplexus/omnivoice-examples/kit/config"
+	"github.com/agentplexus/omnivoice/tts"
+)
+
+// voiceSettings returns the ElevenLabs voice settings in cfg, those unset
+// taking ElevenLabs' defaults, or nil if none are set. It warns of those
+// go-elevenlabs can't pass on.
+func voiceSettings(cfg config.VoiceSettings) (*elevenlabs.VoiceSettings, error) {
+	if cfg == (config.VoiceSettings{}) {
+		return nil, nil
+	}
+	s := elevenlabs.DefaultVoiceSettings()
+	if cfg.Stability != nil {
+		s.Stability = *cfg.Stability
+	}
+	if cfg.SimilarityBoost != nil {
+		s.SimilarityBoost = *cfg.SimilarityBoost
+	}
+	if cfg.Style != nil {
+		s.Style = *cfg.Style
+	}
+	if cfg.SpeakerBoost != nil {
+		s.UseSpeakerBoost = *cfg.SpeakerBoost
+		if !s.UseSpeakerBoost {
+			slog.Warn("ElevenLabs speaker boost can't be turned off: go-elevenlabs only sends it when on")
+		}
+	}
+	if cfg.Speed != nil {
+		s.Speed = *cfg.Speed
+		if s.Speed == 0 {
+			return nil, fmt.Errorf("invalid elevenlabs.voice_settings: %w", elevenlabs.ErrInvalidSpeed)
+		}
+		slog.Warn("ElevenLabs speed only applies to speech synthesized over HTTP, not streamed speech")
+	}
+	if err := s.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid elevenlabs.voice_settings: %w", err)
+	}
+	return s, nil
+}
+
+// voiceSettingsKey identifies the voice settings in cfg, or is "" if none
+// are set.
+func voiceSettingsKey(cfg config.VoiceSettings) string {
+	if cfg == (config.VoiceSettings{}) {
+		return ""
+	}
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// voicedTTS synthesizes with voice settings, changed for the emotion set
+// on each request's context (see withEmotion). tts.SynthesisConfig
+// carries only stability, similarity and speed, and the pipeline passes
+// none of them on, so requests are made with the client directly, as the
+// go-elevenlabs provider would make them but with every setting.
+//
+// go-elevenlabs doesn't send speed over its WebSocket, so streamed speech
+// keeps the voice's normal speed; only Synthesize honors it. Speaker boost
+// is only sent over the WebSocket, and only when on.
+type voicedTTS struct {
+	*elevenvoice.Provider
+	client   *elevenlabs.Client
+	settings *elevenlabs.VoiceSettings
+	emotions map[string]config.Emotion
+}
+
+var _ tts.StreamingProvider = (*voicedTTS)(nil)
+
+// settingsFor returns the voice settings for ctx's emotion: the emotion's
+// stability and style over the configured settings, or ElevenLabs'
+// defaults if none are configured. Without an emotion that changes them
+// they are the configured settings, nil leaving the voice's own.
+func (p *voicedTTS) settingsFor(ctx context.Context) *elevenlabs.VoiceSettings {
+	e, ok := p.emotions[emotionFrom(ctx)]
+	if !ok || (e.Stability == nil && e.Style == nil) {
+		return p.settings
+	}
+	s := elevenlabs.DefaultVoiceSettings()
+	if p.settings != nil {
+		settings := *p.settings
+		s = &settings
+	}
+	if e.Stability != nil {
+		s.Stability = *e.Stability
+	}
+	if e.Style != nil {
+		s.Style = *e.Style
+	}
+	return s
+}
+
+// Synthesize converts text to speech over HTTP.
+func (p *voicedTTS) Synthesize(ctx context.Context, text string, config tts.SynthesisConfig) (*tts.SynthesisResult, error) {
+	req := elevenomni.ConfigToTTSRequest(text, config)
+	req.VoiceSettings = p.settingsFor(ctx)
+	resp, err := p.client.TextToSpeech().Generate(ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("elevenlabs tts failed: %w", err)
+	}
+	audio, err := io.ReadAll(resp.Audio)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read audio: %w", err)
+	}
+	return &tts.SynthesisResult{
+		Audio:          audio,
+		Format:         firstNonEmpty(config.OutputFormat, "mp3"),
+		SampleRate:     cmp.Or(config.SampleRate, 44100),
+		CharacterCount: len(text),
+	}, nil
+}
+
+// SynthesizeStream streams the speech of text over a WebSocket.
+func (p *voicedTTS) SynthesizeStream(ctx context.Context, text string, config tts.SynthesisConfig) (<-chan tts.StreamChunk, error) {
+	return p.stream(ctx, config, func(conn *elevenlabs.WebSocketTTSConnection) error {
+		if err := conn.SendText(text); err != nil {
+			return fmt.Errorf("failed to send text: %w", err)
+		}
+		return nil
+	})
+}
+
+// SynthesizeFromReader streams the speech of text read from reader over a
+// WebSocket as it is read.
+func (p *voicedTTS) SynthesizeFromReader(ctx context.Context, reader io.Reader, config tts.SynthesisConfig) (<-chan tts.StreamChunk, error) {
+	return p.stream(ctx, config, func(conn *elevenlabs.WebSocketTTSConnection) error {
+		buf := make([]byte, 1024)
+		for {
+			n, err := reader.Read(buf)
+			if n > 0 {
+				if err := conn.SendText(string(buf[:n])); err != nil {
+					return fmt.Errorf("failed to send text: %w", err)
+				}
+			}
+			if err == io.EOF {
+				return nil
+			}
+			if err != nil {
+				return fmt.Errorf("failed to read text: %w", err)
+			}
+		}
+	})
+}
+
+// stream opens a WebSocket with the voice settings, has send write the
+// text to it and streams back the audio.
+func (p *voicedTTS) stream(ctx context.Context, config tts.SynthesisConfig, send func(*elevenlabs.WebSocketTTSConnection) error) (<-chan tts.StreamChunk, error) {
+	opts := elevenomni.ConfigToWebSocketTTSOptions(config)
+	opts.VoiceSettings = p.settingsFor(ctx)
+	conn, err := p.client.WebSocketTTS().Connect(ctx, config.VoiceID, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect WebSocket TTS: %w", err)
+	}
+
+	out := make(chan tts.StreamChunk, 100)
+	go func() {
+		defer close(out)
+		defer func() { _ = conn.Close() }()
+
+		if err := send(conn); err != nil {
+			out <- tts.StreamChunk{Error: err}
+			return
+		}
+		if err := conn.Flush(); err != nil {
+			out <- tts.StreamChunk{Error: fmt.Errorf("failed to flush: %w", err)}
+			return
+		}
+		for audio := range conn.Audio() {
+			select {
+			case out <- tts.StreamChunk{Audio: audio}:
+			case <-ctx.Done():
+				out <- tts.StreamChunk{Error: ctx.Err()}
+				return
+			}
+		}
+		select {
+		case err := <-conn.Errors():
+			if err != nil {
+				out <- tts.StreamChunk{Error: err}
+			}
+		default:
+		}
+		out <- tts.StreamChunk{IsFinal: true}
+	}()
+	return out, nil
+}
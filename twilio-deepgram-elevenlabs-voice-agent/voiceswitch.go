@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"io"
+
+	"github.com/agentplexus/omnivoice-examples/kit/config"
+	"github.com/agentplexus/omnivoice/tts"
+)
+
+type voiceContextKey struct{}
+
+// withVoice returns a context whose speech is synthesized in voiceID
+// rather than the TTS pipeline's voice.
+func withVoice(ctx context.Context, voiceID string) context.Context {
+	if voiceID == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, voiceContextKey{}, voiceID)
+}
+
+// voiceFrom returns the voice ctx's speech is synthesized in, or "" for
+// the pipeline's.
+func voiceFrom(ctx context.Context) string {
+	voiceID, _ := ctx.Value(voiceContextKey{}).(string)
+	return voiceID
+}
+
+// switchedTTS synthesizes in the voice set on each request's context (see
+// withVoice), so a call can change voices mid-call without its TTS
+// pipeline, which holds one voice, being built again. It wraps the
+// session's whole TTS chain, so the cache and fallbacks see the voice
+// switched to.
+type switchedTTS struct {
+	tts.StreamingProvider
+}
+
+// Synthesize converts text to speech in the context's voice.
+func (p *switchedTTS) Synthesize(ctx context.Context, text string, config tts.SynthesisConfig) (*tts.SynthesisResult, error) {
+	return p.StreamingProvider.Synthesize(ctx, text, switchedConfig(ctx, config))
+}
+
+// SynthesizeStream streams the speech of text in the context's voice.
+func (p *switchedTTS) SynthesizeStream(ctx context.Context, text string, config tts.SynthesisConfig) (<-chan tts.StreamChunk, error) {
+	return p.StreamingProvider.SynthesizeStream(ctx, text, switchedConfig(ctx, config))
+}
+
+// SynthesizeFromReader streams the speech of text read from reader in the
+// context's voice.
+func (p *switchedTTS) SynthesizeFromReader(ctx context.Context, reader io.Reader, config tts.SynthesisConfig) (<-chan tts.StreamChunk, error) {
+	return p.StreamingProvider.SynthesizeFromReader(ctx, reader, switchedConfig(ctx, config))
+}
+
+// switchedConfig returns config in the voice set on ctx, if any.
+func switchedConfig(ctx context.Context, config tts.SynthesisConfig) tts.SynthesisConfig {
+	if voiceID := voiceFrom(ctx); voiceID != "" {
+		config.VoiceID = voiceID
+	}
+	return config
+}
+
+// teamVoices maps the specialists of team that speak in a voice of their
+// own to it, or returns nil if none do.
+func teamVoices(team []config.Specialist) map[string]string {
+	var voices map[string]string
+	for _, s := range team {
+		if s.VoiceID == "" {
+			continue
+		}
+		if voices == nil {
+			voices = make(map[string]string)
+		}
+		voices[s.Name] = s.VoiceID
+	}
+	return voices
+}
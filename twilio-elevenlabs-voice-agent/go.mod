@@ -9,6 +9,7 @@ go 1.24.11
 require (
 	github.com/agentplexus/go-elevenlabs v0.6.0
 	github.com/agentplexus/omnivoice v0.2.0
+	github.com/agentplexus/omnivoice-examples/kit v0.0.0
 	github.com/agentplexus/omnivoice-twilio v0.1.1
 )
 
@@ -42,4 +43,7 @@ require (
 	golang.org/x/sys v0.40.0 // indirect
 	golang.org/x/text v0.33.0 // indirect
 	gopkg.in/yaml.v2 v2.4.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
+
+replace github.com/agentplexus/omnivoice-examples/kit => ../kit
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/agentplexus/omnivoice-examples/kit/golden"
+	"github.com/agentplexus/omnivoice-examples/kit/twiml"
+)
+
+// update rewrites golden files with what the tests produce instead of
+// comparing them.
+var update = flag.Bool("update", false, "rewrite golden files")
+
+// TestGolden compares the TwiML returned by the voice webhook with the
+// files in testdata/golden. Run it with -update to rewrite them after an
+// intended change.
+func TestGolden(t *testing.T) {
+	connectMessage := twiml.Say{Text: "Hello, connecting you to our AI assistant."}
+	golden.Check(t, "testdata/golden", map[string]string{
+		"inbound.xml": inbound(t, &Server{connectMessage: connectMessage}),
+		"inbound-parameters.xml": inbound(t, &Server{
+			connectMessage: twiml.Say{
+				Text:     "Thanks for calling Acme & Co. One moment…",
+				Voice:    "Polly.Amy",
+				Language: "en-GB",
+			},
+			streamParameters: map[string]string{"accountId": "acct-42", "campaignId": `spring "sale"`},
+		}),
+	}, *update)
+}
+
+// inbound returns the TwiML s answers an incoming call with.
+func inbound(t *testing.T, s *Server) string {
+	t.Helper()
+	form := url.Values{"CallSid": {"CA00000000000000000000000000000000"}, "From": {"+15551230001"}, "To": {"+15551230002"}}
+	r := httptest.NewRequest(http.MethodPost, "https://voice.example.com/voice/inbound", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	s.handleInboundCall(w, r)
+	return w.Body.String()
+}
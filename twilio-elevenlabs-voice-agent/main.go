@@ -5,7 +5,9 @@
 //
 // This example demonstrates how to build a voice agent using:
 // - Twilio Media Streams for telephony transport (mu-law audio)
+// - ElevenLabs WebSocket STT for transcription (native mu-law input)
 // - ElevenLabs WebSocket TTS for voice synthesis (native ulaw_8000 output)
+// - kit/session to run the conversation between them and the agent
 //
 // Architecture (Option B from omnivoice TRD):
 //
@@ -24,7 +26,8 @@
 //	                                                └───────────────────────────────┘
 //
 // Key feature: ElevenLabs supports native ulaw_8000 output, so no audio conversion
-// is needed for the outbound (TTS → Twilio) path.
+// is needed for the outbound (TTS → Twilio) path, and transcribes mu-law as
+// Twilio sends it.
 package main
 
 import (
@@ -32,6 +35,8 @@ import (
 	"fmt"
 	"log"
 	"log/slog"
+	"maps"
+	"math"
 	"net/http"
 	"os"
 	"os/signal"
@@ -39,39 +44,109 @@ import (
 	"time"
 
 	elevenlabs "github.com/agentplexus/go-elevenlabs"
+	elevenstt "github.com/agentplexus/go-elevenlabs/omnivoice/stt"
 	elevenvoice "github.com/agentplexus/go-elevenlabs/omnivoice/tts"
+	"github.com/agentplexus/omnivoice-examples/kit/agent"
+	"github.com/agentplexus/omnivoice-examples/kit/config"
+	"github.com/agentplexus/omnivoice-examples/kit/llm"
+	"github.com/agentplexus/omnivoice-examples/kit/mediastream"
+	"github.com/agentplexus/omnivoice-examples/kit/mock"
+	"github.com/agentplexus/omnivoice-examples/kit/session"
+	"github.com/agentplexus/omnivoice-examples/kit/twilioauth"
+	"github.com/agentplexus/omnivoice-examples/kit/twiml"
 	twiliotransport "github.com/agentplexus/omnivoice-twilio/transport"
 	"github.com/agentplexus/omnivoice/pipeline"
+	"github.com/agentplexus/omnivoice/stt"
 	"github.com/agentplexus/omnivoice/transport"
+	"github.com/agentplexus/omnivoice/tts"
 )
 
 func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Get API keys from environment
-	elevenLabsAPIKey := os.Getenv("ELEVENLABS_API_KEY")
-	if elevenLabsAPIKey == "" {
-		log.Fatal("ELEVENLABS_API_KEY environment variable required")
+	// Load settings from CONFIG_FILE, if set, overridden by the environment
+	cfg, err := config.Load(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
 	}
 
-	twilioAccountSID := os.Getenv("TWILIO_ACCOUNT_SID")
-	twilioAuthToken := os.Getenv("TWILIO_AUTH_TOKEN")
-	if twilioAccountSID == "" || twilioAuthToken == "" {
-		log.Fatal("TWILIO_ACCOUNT_SID and TWILIO_AUTH_TOKEN environment variables required")
+	// OFFLINE=1 hears a script and speaks a tone instead of calling
+	// ElevenLabs, and needs no Twilio account
+	offline, err := offlineFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if offline {
+		slog.Warn("running offline: speech is simulated and nothing is sent to Twilio")
+		cfg.Twilio.AccountSID = offlineAccountSID
+		cfg.Twilio.AuthToken = "offline"
+		cfg.Twilio.ValidateSignatures = false
+	} else {
+		if cfg.ElevenLabs.APIKey == "" {
+			log.Fatal("ELEVENLABS_API_KEY (elevenlabs.api_key) required")
+		}
+		if cfg.Twilio.AccountSID == "" || cfg.Twilio.AuthToken == "" {
+			log.Fatal("TWILIO_ACCOUNT_SID and TWILIO_AUTH_TOKEN (twilio.account_sid and twilio.auth_token) required")
+		}
+	}
+	greeting := cfg.Prompts.Greeting
+	if greeting == "" {
+		greeting = "Hello! How can I help you today?"
+	}
+	connectMessage := cfg.Twilio.ConnectMessage
+	if connectMessage == "" {
+		connectMessage = "Hello, connecting you to our AI assistant."
+	}
+	for name := range cfg.Twilio.StreamParameters {
+		if name == paramCallSID || name == paramCaller || name == paramCalled {
+			log.Fatalf("Invalid TWILIO_STREAM_PARAMETERS: parameter name %q is reserved", name)
+		}
 	}
 
-	// Create ElevenLabs TTS provider
-	elevenClient, err := elevenlabs.NewClient(elevenlabs.WithAPIKey(elevenLabsAPIKey))
+	// What the agent does when the caller talks over it
+	doubleTalk, err := session.ParseDoubleTalk(cfg.DoubleTalk.Policy)
 	if err != nil {
-		log.Fatalf("Failed to create ElevenLabs client: %v", err)
+		log.Fatalf("Invalid DOUBLE_TALK: %v", err)
+	}
+	if cfg.DoubleTalk.DuckDB > 0 {
+		log.Fatalf("Invalid DOUBLE_TALK_DUCK_DB %v (want a gain <= 0)", cfg.DoubleTalk.DuckDB)
+	}
+
+	// Create ElevenLabs STT and TTS providers, or mock ones offline
+	var sttProvider stt.StreamingProvider
+	var ttsProvider tts.StreamingProvider
+	if offline {
+		sttProvider = &mock.STT{Interval: offlineTurnInterval}
+		ttsProvider = &mock.TTS{Tone: offlineTone}
+	} else {
+		elevenClient, err := elevenlabs.NewClient(elevenlabs.WithAPIKey(cfg.ElevenLabs.APIKey))
+		if err != nil {
+			log.Fatalf("Failed to create ElevenLabs client: %v", err)
+		}
+		sttProvider = elevenstt.NewWithClient(elevenClient)
+		ttsProvider = elevenvoice.NewWithClient(elevenClient)
+	}
+
+	// The agent answers with a language model when LLM_PROVIDER is set,
+	// otherwise it echoes
+	var brain agent.Agent = agent.NewEcho()
+	if cfg.LLM.Provider != "" {
+		provider, err := llm.FromEnv(cfg.LLM.Provider, cfg.LLM.Model)
+		if err != nil {
+			log.Fatalf("Failed to create LLM provider: %v", err)
+		}
+		system := cfg.Prompts.System
+		if system == "" {
+			system = agent.DefaultSystemPrompt
+		}
+		brain = agent.NewLLM(provider, system, "")
 	}
-	ttsProvider := elevenvoice.NewWithClient(elevenClient)
 
 	// Create Twilio Media Streams transport
 	twilioTransport, err := twiliotransport.New(
-		twiliotransport.WithAccountSID(twilioAccountSID),
-		twiliotransport.WithAuthToken(twilioAuthToken),
+		twiliotransport.WithAccountSID(cfg.Twilio.AccountSID),
+		twiliotransport.WithAuthToken(cfg.Twilio.AuthToken),
 	)
 	if err != nil {
 		log.Fatalf("Failed to create Twilio transport: %v", err)
@@ -92,15 +167,42 @@ func main() {
 
 	// Create server with handlers
 	server := &Server{
-		ttsProvider:     ttsProvider,
-		twilioTransport: twilioTransport,
+		sttProvider: sttProvider,
+		ttsProvider: ttsProvider,
+		agent:       brain,
+		voice:       cfg.ElevenLabs,
+		greeting:    greeting,
+		connectMessage: twiml.Say{
+			Text:     connectMessage,
+			Voice:    cfg.Twilio.SayVoice,
+			Language: cfg.Twilio.SayLanguage,
+		},
+		streamParameters: cfg.Twilio.StreamParameters,
+		doubleTalk:       doubleTalk,
+		duckGain:         math.Pow(10, cfg.DoubleTalk.DuckDB/20),
 	}
 
-	// Start HTTP server
-	http.HandleFunc("/voice/inbound", server.handleInboundCall)
-	http.HandleFunc("/media-stream", server.handleMediaStream)
+	// Media Streams are handed over once their start message, with the
+	// call's custom parameters, has arrived
+	streams, err := mediastream.NewServer(ctx, twilioTransport, "/media-stream")
+	if err != nil {
+		log.Fatalf("Failed to start Media Streams listener: %v", err)
+	}
 
-	addr := ":8080"
+	// Start HTTP server, serving only requests signed by Twilio
+	inbound := http.Handler(http.HandlerFunc(server.handleInboundCall))
+	mediaStream := http.Handler(streams)
+	if cfg.Twilio.ValidateSignatures {
+		signatures := &twilioauth.Validator{AuthToken: cfg.Twilio.AuthToken, PublicHost: cfg.Server.PublicHost}
+		inbound = signatures.Middleware(inbound)
+		mediaStream = signatures.Middleware(mediaStream)
+	} else {
+		slog.Warn("Twilio signature validation disabled; anyone can place calls through this server")
+	}
+	http.Handle("/voice/inbound", inbound)
+	http.Handle("/media-stream", mediaStream)
+
+	addr := cfg.Server.Addr
 	log.Printf("Starting server on %s", addr)
 
 	httpServer := &http.Server{
@@ -108,14 +210,13 @@ func main() {
 		ReadHeaderTimeout: 10 * time.Second,
 	}
 
-	// Start listening for Media Streams connections
-	connCh, err := twilioTransport.Listen(ctx, "/media-stream")
-	if err != nil {
-		log.Fatalf("Failed to start Media Streams listener: %v", err)
-	}
-
 	// Handle incoming connections
-	go server.handleConnections(ctx, connCh)
+	go server.handleConnections(ctx, streams.Connections())
+
+	// Offline, place a simulated call to show the pipeline at work
+	if offline {
+		go runOfflineCall(ctx, server)
+	}
 
 	go func() {
 		if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
@@ -130,8 +231,25 @@ func main() {
 
 // Server handles voice agent connections.
 type Server struct {
-	ttsProvider     *elevenvoice.Provider
-	twilioTransport *twiliotransport.Provider
+	sttProvider stt.StreamingProvider
+	ttsProvider tts.StreamingProvider
+	agent       agent.Agent
+	voice       config.ElevenLabs
+	greeting    string
+
+	// connectMessage is said while the call connects, and streamParameters
+	// are passed to every stream alongside the call's own details.
+	connectMessage   twiml.Say
+	streamParameters map[string]string
+
+	// greetingFor, if set, chooses each call's greeting from its context,
+	// e.g. by account or campaign; otherwise every call gets greeting.
+	greetingFor func(sc SessionContext) string
+
+	// doubleTalk is what the agent does when the caller talks over it, and
+	// duckGain how far its voice is lowered if it carries on.
+	doubleTalk session.DoubleTalk
+	duckGain   float64
 }
 
 // handleInboundCall returns TwiML to connect the call to Media Streams.
@@ -146,27 +264,21 @@ func (s *Server) handleInboundCall(w http.ResponseWriter, r *http.Request) {
 	// Note: Using <Stream> for raw audio, not <ConversationRelay>
 	wsURL := fmt.Sprintf("wss://%s/media-stream", r.Host)
 
-	twiml := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
-<Response>
-    <Say>Hello, connecting you to our AI assistant.</Say>
-    <Connect>
-        <Stream url="%s">
-            <Parameter name="callSid" value="%s"/>
-            <Parameter name="caller" value="%s"/>
-        </Stream>
-    </Connect>
-</Response>`, wsURL, callSID, from)
-
-	w.Header().Set("Content-Type", "application/xml")
-	if _, err := w.Write([]byte(twiml)); err != nil {
-		slog.Error("failed to write TwiML", "error", err)
+	params := maps.Clone(s.streamParameters)
+	if params == nil {
+		params = make(map[string]string)
+	}
+	params[paramCallSID] = callSID
+	params[paramCaller] = from
+	params[paramCalled] = to
+	doc := twiml.Response{
+		s.connectMessage,
+		twiml.Connect{Stream: twiml.Stream{URL: wsURL, Parameters: params}},
 	}
-}
 
-// handleMediaStream upgrades HTTP to WebSocket and handles Media Streams.
-func (s *Server) handleMediaStream(w http.ResponseWriter, r *http.Request) {
-	if err := s.twilioTransport.HandleWebSocket(w, r, "/media-stream"); err != nil {
-		slog.Error("WebSocket handling failed", "error", err)
+	w.Header().Set("Content-Type", twiml.ContentType)
+	if _, err := w.Write([]byte(doc.String())); err != nil {
+		slog.Error("failed to write TwiML", "error", err)
 	}
 }
 
@@ -182,44 +294,50 @@ func (s *Server) handleConnections(ctx context.Context, connCh <-chan transport.
 	}
 }
 
-// handleSession manages a single voice session.
+// handleSession runs the conversation on a single Media Stream.
 func (s *Server) handleSession(ctx context.Context, conn transport.Connection) {
-	log.Printf("New session: %s", conn.ID())
-
-	// Create TTS pipeline configured for telephony
-	// Using "ulaw" format so ElevenLabs outputs mu-law directly - no conversion needed!
-	ttsConfig := pipeline.TTSPipelineConfig{
-		VoiceID:      "Rachel",            // ElevenLabs voice
-		OutputFormat: "ulaw",              // Native mu-law output for Twilio
-		SampleRate:   8000,                // Telephony sample rate
-		Model:        "eleven_turbo_v2_5", // Low-latency model
-		OnError: func(err error) {
-			slog.Error("TTS error", "error", err, "session", conn.ID())
-		},
-		OnComplete: func() {
-			slog.Info("TTS complete", "session", conn.ID())
-		},
-	}
+	// What the webhook passed to the stream: caller, account, campaign, ...
+	sc := sessionContextOf(conn)
+	log.Printf("New session: %s (call SID: %s, caller: %s, account: %q, campaign: %q)",
+		conn.ID(), sc.CallSID, sc.Caller, sc.AccountID, sc.CampaignID)
 
-	ttsPipeline := pipeline.NewTTSPipeline(s.ttsProvider, ttsConfig)
-
-	// Synthesize a greeting
-	// In a real agent, this would be triggered by STT transcripts + LLM responses
-	err := ttsPipeline.SynthesizeToConnection(ctx, "Hello! How can I help you today?", conn)
-	if err != nil {
-		slog.Error("TTS synthesis failed", "error", err)
+	greeting := s.greeting
+	if s.greetingFor != nil {
+		greeting = s.greetingFor(sc)
 	}
+	metadata := maps.Clone(sc.Custom)
+	if metadata == nil {
+		metadata = make(map[string]string)
+	}
+	metadata[paramCallSID] = sc.CallSID
+	metadata[paramCaller] = sc.Caller
+	metadata[paramCalled] = sc.Called
 
-	// TODO: Implement full STT → LLM → TTS loop
-	// 1. Read audio from conn.AudioOut() (mu-law from caller)
-	// 2. Convert mu-law to PCM using omnivoice/audio/codec
-	// 3. Send PCM to Deepgram STT for transcription
-	// 4. Send transcript to Claude LLM
-	// 5. Send LLM response to ElevenLabs TTS (via pipeline)
-	// 6. TTS audio (ulaw) goes directly to Twilio via pipeline
-
-	// Keep session alive until context is cancelled
-	<-ctx.Done()
+	// Both directions stay in mu-law: ElevenLabs transcribes what Twilio
+	// sends and speaks what Twilio plays, so nothing is converted
+	voice := session.NewVoiceSession(conn, s.sttProvider, s.ttsProvider, s.agent, session.Options{
+		Metadata:   metadata,
+		Greeting:   greeting,
+		DoubleTalk: s.doubleTalk,
+		DuckGain:   s.duckGain,
+		STT: pipeline.STTPipelineConfig{
+			Encoding:   "mulaw",
+			SampleRate: 8000,
+			Channels:   1,
+		},
+		TTS: pipeline.TTSPipelineConfig{
+			VoiceID:      s.voice.VoiceID, // ElevenLabs voice
+			OutputFormat: "ulaw",          // Native mu-law output for Twilio
+			SampleRate:   8000,            // Telephony sample rate
+			Model:        s.voice.Model,   // Low-latency model by default
+			OnError: func(err error) {
+				slog.Error("TTS error", "error", err, "session", conn.ID())
+			},
+		},
+	})
+	if err := voice.Run(ctx); err != nil {
+		slog.Error("session failed", "error", err, "session", conn.ID())
+	}
 	_ = conn.Close()
-	log.Printf("Session ended: %s", conn.ID())
+	log.Printf("Session ended: %s (%d transcript lines)", conn.ID(), len(voice.Transcript()))
 }
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/mock"
+)
+
+const (
+	// offlineAccountSID stands in for the Twilio account when offline.
+	offlineAccountSID = "AC00000000000000000000000000000000"
+	// offlineCallSID is the call SID of the simulated call.
+	offlineCallSID = "CA00000000000000000000000000000000"
+	// offlineTone is the pitch in Hz of the mock voice.
+	offlineTone = 440
+	// offlineTurnInterval is the time between the simulated caller's
+	// utterances.
+	offlineTurnInterval = 3 * time.Second
+	// offlineCallGrace is how long the simulated caller stays on the line
+	// after its last utterance, for the agent to answer.
+	offlineCallGrace = 3 * time.Second
+)
+
+// offlineFromEnv reports whether OFFLINE is set. Offline, the server needs
+// no API keys or Twilio account: a mock STT provider hears the caller say
+// mock.DefaultScript, the agent's replies are spoken as a tone by a mock
+// TTS provider, and a simulated call is placed at startup. Media Streams
+// clients can still connect to /media-stream.
+func offlineFromEnv() (bool, error) {
+	v := os.Getenv("OFFLINE")
+	if v == "" {
+		return false, nil
+	}
+	offline, err := strconv.ParseBool(v)
+	if err != nil {
+		return false, fmt.Errorf("invalid OFFLINE: %q", v)
+	}
+	return offline, nil
+}
+
+// runOfflineCall places a simulated call over an in-memory connection and
+// hangs up once the caller's script has been heard and answered, unless
+// the agent hangs up first.
+func runOfflineCall(ctx context.Context, server *Server) {
+	conn := mock.NewConn(offlineCallSID, map[string]string{
+		paramCallSID: offlineCallSID,
+		paramCaller:  "+15555550100",
+		paramCalled:  "+15555550199",
+		"accountId":  "acct-offline",
+	})
+	log.Printf("Placing simulated call (SID: %s)", offlineCallSID)
+
+	ended := make(chan struct{})
+	go func() {
+		defer close(ended)
+		server.handleSession(ctx, conn)
+	}()
+
+	// Count what the agent said; the caller has no ears
+	heard := make(chan int64, 1)
+	go func() {
+		n, _ := io.Copy(io.Discard, conn.Received())
+		heard <- n
+	}()
+
+	timeout := time.Duration(len(mock.DefaultScript)+1)*offlineTurnInterval + offlineCallGrace
+	select {
+	case <-conn.Done():
+	case <-time.After(timeout):
+		log.Printf("Simulated caller hanging up")
+	case <-ctx.Done():
+	}
+	conn.Hangup()
+	<-ended
+	// The agent speaks 8kHz mu-law: 8000 bytes a second
+	log.Printf("Simulated call ended: agent spoke for %s", time.Duration(<-heard)*time.Second/8000)
+}
@@ -0,0 +1,72 @@
+package main
+
+import "github.com/agentplexus/omnivoice/transport"
+
+// Stream parameter names for the call's own details, set by
+// handleInboundCall.
+const (
+	paramCallSID = "callSid"
+	paramCaller  = "caller"
+	paramCalled  = "called"
+)
+
+// SessionContext is what a call's Media Stream was started with: the
+// call's own details and any other <Parameter>s, whether added with
+// TWILIO_STREAM_PARAMETERS or by TwiML of your own. It lets the agent
+// greet a caller by account or route a call by campaign.
+type SessionContext struct {
+	CallSID string
+	Caller  string
+	Called  string
+
+	// AccountID comes from an accountId parameter, and CampaignID from a
+	// campaignId parameter.
+	AccountID  string
+	CampaignID string
+
+	// Custom holds every other parameter.
+	Custom map[string]string
+}
+
+// sessionContextFromParameters builds a session context from Media
+// Streams custom parameters.
+func sessionContextFromParameters(params map[string]string) SessionContext {
+	sc := SessionContext{Custom: make(map[string]string)}
+	for name, value := range params {
+		switch name {
+		case paramCallSID:
+			sc.CallSID = value
+		case paramCaller:
+			sc.Caller = value
+		case paramCalled:
+			sc.Called = value
+		case "accountId":
+			sc.AccountID = value
+		case "campaignId":
+			sc.CampaignID = value
+		default:
+			sc.Custom[name] = value
+		}
+	}
+	return sc
+}
+
+// sessionContextOf returns the context of the call on conn, read from the
+// custom parameters of the start message, which mediastream connections
+// carry. The call SID falls back to the connection's own.
+func sessionContextOf(conn transport.Connection) SessionContext {
+	var params map[string]string
+	if c, ok := conn.(interface{ CustomParameters() map[string]string }); ok {
+		params = c.CustomParameters()
+	}
+	sc := sessionContextFromParameters(params)
+	if sc.CallSID == "" {
+		if c, ok := conn.(interface{ CallSID() string }); ok {
+			sc.CallSID = c.CallSID()
+		}
+	}
+	if sc.CallSID == "" {
+		sc.CallSID = conn.ID()
+	}
+	return sc
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/golden"
+	"github.com/agentplexus/omnivoice-examples/kit/twiml"
+)
+
+// update rewrites golden files with what the tests produce instead of
+// comparing them.
+var update = flag.Bool("update", false, "rewrite golden files")
+
+// Fixed inputs for the snapshots.
+const (
+	goldenCallSID = "CA00000000000000000000000000000000"
+	goldenCaller  = "+15551230001"
+)
+
+// TestGolden compares the TwiML returned by the voice webhook, and an
+// order as read back and as stored, with the files in testdata/golden. Run
+// it with -update to rewrite them after an intended change.
+func TestGolden(t *testing.T) {
+	t.Setenv("MENU_FILE", "menu.yaml")
+	menu, err := menuFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	order := Order{
+		Number:     7,
+		CallSID:    goldenCallSID,
+		Caller:     goldenCaller,
+		Restaurant: menu.Name,
+		Currency:   menu.Currency,
+		Confirmed:  time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC),
+	}
+	for _, l := range []struct {
+		item    string
+		choices lineChoices
+	}{
+		{"cheeseburger", lineChoices{Quantity: 2, Without: []string{"Onions"}, Extras: []string{"bacon"}}},
+		{"fries", lineChoices{Quantity: 1, Size: "large"}},
+	} {
+		item, ok := menu.Item(l.item)
+		if !ok {
+			t.Fatalf("%s isn't on the menu", l.item)
+		}
+		line := OrderLine{Line: len(order.Lines) + 1, Item: item.ID, Name: item.Name}
+		if problem := line.apply(item, l.choices, nil, nil); problem != "" {
+			t.Fatal(problem)
+		}
+		order.Lines = append(order.Lines, line)
+	}
+	order.Total = order.total()
+	data, err := json.MarshalIndent(order, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	golden.Check(t, "testdata/golden", map[string]string{
+		"inbound.xml": inbound(t, &Server{}),
+		"inbound-connect-message.xml": inbound(t, &Server{connectMessage: twiml.Say{
+			Text:     "Thanks for calling Burger Barn & Grill.",
+			Voice:    "Polly.Amy",
+			Language: "en-GB",
+		}}),
+		"order-summary.txt": order.summary() + "\n",
+		"order.json":        string(data) + "\n",
+	}, *update)
+}
+
+// inbound returns the TwiML s answers an incoming call with.
+func inbound(t *testing.T, s *Server) string {
+	t.Helper()
+	form := url.Values{"CallSid": {goldenCallSID}, "From": {goldenCaller}, "To": {"+15551230002"}}
+	r := httptest.NewRequest(http.MethodPost, "https://voice.example.com/voice/inbound", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	s.handleInboundCall(w, r)
+	return w.Body.String()
+}
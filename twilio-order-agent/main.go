@@ -0,0 +1,317 @@
+// Example: Drive-through ordering agent using Twilio Media Streams
+//
+// This example takes food orders by voice, as at a drive-through speaker
+// or a kiosk, from a structured catalog:
+//   - The menu is a YAML file of items, sizes, removable ingredients and
+//     priced extras, given to the language model with its system prompt
+//   - The model builds the order with tools (add_item, change_item,
+//     remove_item) that check every choice against the menu, and asks for
+//     whatever a tool reports missing, such as a size
+//   - Each change is confirmed back to the caller; the whole order is read
+//     back before it is confirmed, then emitted as JSON
+//   - ElevenLabs STT and TTS over Twilio Media Streams, run by kit/session
+//
+// Architecture:
+//
+//	┌──────────┐        ┌─────────────────┐         ┌──────────────────────────────┐
+//	│  Caller  │◄──────►│      Twilio     │◄───────►│  kit/session                 │
+//	│  (PSTN)  │  PSTN  │      Media      │WebSocket│  STT ─► LLM agent ─► TTS     │
+//	└──────────┘        │      Streams    │ (μ-law) │           │ tools            │
+//	                    └─────────────────┘         │           ▼                  │
+//	                                                │  ┌─────────────────┐         │
+//	                                                │  │  Order (menu)   │─► JSON  │
+//	                                                │  └─────────────────┘         │
+//	                                                └──────────────────────────────┘
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	elevenlabs "github.com/agentplexus/go-elevenlabs"
+	elevenstt "github.com/agentplexus/go-elevenlabs/omnivoice/stt"
+	elevenvoice "github.com/agentplexus/go-elevenlabs/omnivoice/tts"
+	"github.com/agentplexus/omnivoice-examples/kit/agent"
+	"github.com/agentplexus/omnivoice-examples/kit/config"
+	"github.com/agentplexus/omnivoice-examples/kit/llm"
+	"github.com/agentplexus/omnivoice-examples/kit/mediastream"
+	"github.com/agentplexus/omnivoice-examples/kit/mock"
+	"github.com/agentplexus/omnivoice-examples/kit/session"
+	"github.com/agentplexus/omnivoice-examples/kit/storage"
+	"github.com/agentplexus/omnivoice-examples/kit/twilioauth"
+	"github.com/agentplexus/omnivoice-examples/kit/twiml"
+	twiliotransport "github.com/agentplexus/omnivoice-twilio/transport"
+	"github.com/agentplexus/omnivoice/pipeline"
+	"github.com/agentplexus/omnivoice/stt"
+	"github.com/agentplexus/omnivoice/transport"
+	"github.com/agentplexus/omnivoice/tts"
+)
+
+// orderSystemPrompt is how the agent takes orders; the menu follows it.
+const orderSystemPrompt = "You are taking food orders at the %s drive-through. " +
+	"Reply in one or two short spoken sentences, without lists, markdown or emoji. " +
+	"Only sell what is on the menu below. Add each item the customer orders with add_item; " +
+	"if the result asks for a choice, such as a size, ask the customer for it. " +
+	"Use change_item for changes like \"no onions\" or \"make that a large\", and remove_item to take something off. " +
+	"After each change, confirm just what changed, not the whole order. " +
+	"When the customer has everything, call read_order, read the order and total back, and ask if it's right. " +
+	"Call confirm_order only once they say yes, then give them their order number and total and ask them to drive to the window."
+
+// Stream parameter names for the call's own details, set by
+// handleInboundCall.
+const (
+	paramCallSID = "callSid"
+	paramCaller  = "caller"
+)
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Load settings from CONFIG_FILE, if set, overridden by the environment
+	cfg, err := config.Load(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	menu, err := menuFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if cfg.LLM.Provider == "" {
+		log.Fatal("LLM_PROVIDER (llm.provider) required: orders are taken by a language model")
+	}
+
+	// OFFLINE=1 hears a customer order from a script and speaks a tone
+	// instead of calling ElevenLabs, and needs no Twilio account
+	offline, err := offlineFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	if offline {
+		slog.Warn("running offline: speech is simulated and nothing is sent to Twilio")
+		cfg.Twilio.AccountSID = offlineAccountSID
+		cfg.Twilio.AuthToken = "offline"
+		cfg.Twilio.ValidateSignatures = false
+	} else {
+		if cfg.ElevenLabs.APIKey == "" {
+			log.Fatal("ELEVENLABS_API_KEY (elevenlabs.api_key) required")
+		}
+		if cfg.Twilio.AccountSID == "" || cfg.Twilio.AuthToken == "" {
+			log.Fatal("TWILIO_ACCOUNT_SID and TWILIO_AUTH_TOKEN (twilio.account_sid and twilio.auth_token) required")
+		}
+	}
+	greeting := cfg.Prompts.Greeting
+	if greeting == "" {
+		greeting = fmt.Sprintf("Welcome to %s! What can I get for you today?", firstNonEmpty(menu.Name, "our drive-through"))
+	}
+
+	// Create ElevenLabs STT and TTS providers, or mock ones offline
+	var sttProvider stt.StreamingProvider
+	var ttsProvider tts.StreamingProvider
+	if offline {
+		sttProvider = &mock.STT{Script: offlineScript, Interval: offlineTurnInterval}
+		ttsProvider = &mock.TTS{Tone: offlineTone}
+	} else {
+		elevenClient, err := elevenlabs.NewClient(elevenlabs.WithAPIKey(cfg.ElevenLabs.APIKey))
+		if err != nil {
+			log.Fatalf("Failed to create ElevenLabs client: %v", err)
+		}
+		sttProvider = elevenstt.NewWithClient(elevenClient)
+		ttsProvider = elevenvoice.NewWithClient(elevenClient)
+	}
+
+	// Confirmed orders are stored as JSON under ORDERS_DIR
+	orders := NewOrders(menu, &storage.Dir{Root: firstNonEmpty(os.Getenv("ORDERS_DIR"), "orders")})
+
+	// The model takes the order with the order tools, knowing the menu
+	provider, err := llm.FromEnv(cfg.LLM.Provider, cfg.LLM.Model)
+	if err != nil {
+		log.Fatalf("Failed to create LLM provider: %v", err)
+	}
+	system := cfg.Prompts.System
+	if system == "" {
+		system = fmt.Sprintf(orderSystemPrompt, firstNonEmpty(menu.Name, "restaurant"))
+	}
+	brain := agent.NewLLM(provider, system+"\n\n"+menu.Prompt(), "", orders.Tools()...)
+
+	// Create Twilio Media Streams transport
+	twilioTransport, err := twiliotransport.New(
+		twiliotransport.WithAccountSID(cfg.Twilio.AccountSID),
+		twiliotransport.WithAuthToken(cfg.Twilio.AuthToken),
+	)
+	if err != nil {
+		log.Fatalf("Failed to create Twilio transport: %v", err)
+	}
+	defer func() {
+		if err := twilioTransport.Close(); err != nil {
+			slog.Error("failed to close Twilio transport", "error", err)
+		}
+	}()
+
+	// Handle shutdown
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		cancel()
+	}()
+
+	server := &Server{
+		sttProvider: sttProvider,
+		ttsProvider: ttsProvider,
+		agent:       brain,
+		orders:      orders,
+		voice:       cfg.ElevenLabs,
+		greeting:    greeting,
+		connectMessage: twiml.Say{
+			Text:     cfg.Twilio.ConnectMessage,
+			Voice:    cfg.Twilio.SayVoice,
+			Language: cfg.Twilio.SayLanguage,
+		},
+		logLines: offline,
+	}
+
+	// Media Streams are handed over once their start message, with the
+	// call's custom parameters, has arrived
+	streams, err := mediastream.NewServer(ctx, twilioTransport, "/media-stream")
+	if err != nil {
+		log.Fatalf("Failed to start Media Streams listener: %v", err)
+	}
+
+	// Start HTTP server, serving only requests signed by Twilio
+	inbound := http.Handler(http.HandlerFunc(server.handleInboundCall))
+	mediaStream := http.Handler(streams)
+	if cfg.Twilio.ValidateSignatures {
+		signatures := &twilioauth.Validator{AuthToken: cfg.Twilio.AuthToken, PublicHost: cfg.Server.PublicHost}
+		inbound = signatures.Middleware(inbound)
+		mediaStream = signatures.Middleware(mediaStream)
+	} else {
+		slog.Warn("Twilio signature validation disabled; anyone can place calls through this server")
+	}
+	http.Handle("/voice/inbound", inbound)
+	http.Handle("/media-stream", mediaStream)
+
+	addr := cfg.Server.Addr
+	log.Printf("Starting server on %s with %d menu items", addr, len(menu.Items))
+
+	httpServer := &http.Server{
+		Addr:              addr,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go server.handleConnections(ctx, streams.Connections())
+
+	// Offline, place a simulated order to show the agent at work
+	if offline {
+		go runOfflineCall(ctx, server)
+	}
+
+	go func() {
+		if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatalf("Server error: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("Shutting down...")
+	_ = httpServer.Close()
+}
+
+// Server handles ordering calls.
+type Server struct {
+	sttProvider stt.StreamingProvider
+	ttsProvider tts.StreamingProvider
+	agent       agent.Agent
+	orders      *Orders
+	voice       config.ElevenLabs
+	greeting    string
+
+	// connectMessage, if it has text, is said while the call connects.
+	connectMessage twiml.Say
+
+	// logLines logs the conversation as it goes, e.g. offline, where there
+	// is nobody listening.
+	logLines bool
+}
+
+// handleInboundCall returns TwiML to connect the call to Media Streams.
+func (s *Server) handleInboundCall(w http.ResponseWriter, r *http.Request) {
+	from, callSID := r.FormValue("From"), r.FormValue("CallSid")
+	log.Printf("Incoming call: %s -> %s (SID: %s)", from, r.FormValue("To"), callSID)
+
+	var doc twiml.Response
+	if s.connectMessage.Text != "" {
+		doc = append(doc, s.connectMessage)
+	}
+	doc = append(doc, twiml.Connect{Stream: twiml.Stream{
+		URL:        fmt.Sprintf("wss://%s/media-stream", r.Host),
+		Parameters: map[string]string{paramCallSID: callSID, paramCaller: from},
+	}})
+
+	w.Header().Set("Content-Type", twiml.ContentType)
+	if _, err := w.Write([]byte(doc.String())); err != nil {
+		slog.Error("failed to write TwiML", "error", err)
+	}
+}
+
+// handleConnections processes incoming Media Streams connections.
+func (s *Server) handleConnections(ctx context.Context, connCh <-chan transport.Connection) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case conn := <-connCh:
+			go s.handleSession(ctx, conn)
+		}
+	}
+}
+
+// handleSession takes an order on a single Media Stream.
+func (s *Server) handleSession(ctx context.Context, conn transport.Connection) {
+	var params map[string]string
+	if c, ok := conn.(interface{ CustomParameters() map[string]string }); ok {
+		params = c.CustomParameters()
+	}
+	callSID, caller := params[paramCallSID], params[paramCaller]
+	log.Printf("New session: %s (call SID: %s, caller: %s)", conn.ID(), callSID, caller)
+
+	s.orders.Begin(conn.ID(), callSID, caller)
+	defer s.orders.End(conn.ID())
+
+	opts := session.Options{
+		Metadata: params,
+		Greeting: s.greeting,
+		STT: pipeline.STTPipelineConfig{
+			Encoding:   "mulaw",
+			SampleRate: 8000,
+			Channels:   1,
+		},
+		TTS: pipeline.TTSPipelineConfig{
+			VoiceID:      s.voice.VoiceID,
+			OutputFormat: "ulaw",
+			SampleRate:   8000,
+			Model:        s.voice.Model,
+			OnError: func(err error) {
+				slog.Error("TTS error", "error", err, "session", conn.ID())
+			},
+		},
+	}
+	if s.logLines {
+		opts.OnLine = func(line session.Line) {
+			log.Printf("%s: %s", line.Speaker, line.Text)
+		}
+	}
+	voice := session.NewVoiceSession(conn, s.sttProvider, s.ttsProvider, s.agent, opts)
+	if err := voice.Run(ctx); err != nil {
+		slog.Error("session failed", "error", err, "session", conn.ID())
+	}
+	_ = conn.Close()
+	log.Printf("Session ended: %s (%d transcript lines)", conn.ID(), len(voice.Transcript()))
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Menu is the catalog callers order from.
+type Menu struct {
+	// Name is the restaurant's name, e.g. in the greeting.
+	Name string `yaml:"name"`
+	// Currency is the ISO 4217 code prices are in, e.g. "USD".
+	Currency string `yaml:"currency"`
+	Items    []Item `yaml:"items"`
+}
+
+// Item is something on the menu.
+type Item struct {
+	// ID names the item in tool calls and orders, e.g. "cheeseburger".
+	ID       string `yaml:"id"`
+	Name     string `yaml:"name"`
+	Category string `yaml:"category"`
+	// Price is the item's price, unless it comes in Sizes.
+	Price Price `yaml:"price"`
+	// Sizes, if set, are the sizes the item comes in, each with its own
+	// price; the caller must choose one.
+	Sizes []Option `yaml:"sizes"`
+	// Ingredients can be left out at no charge ("no onions").
+	Ingredients []string `yaml:"ingredients"`
+	// Extras can be added, each at its price ("add bacon").
+	Extras []Option `yaml:"extras"`
+}
+
+// Option is a size or extra and its price.
+type Option struct {
+	Name  string `yaml:"name"`
+	Price Price  `yaml:"price"`
+}
+
+// Price is an amount in minor units (cents), written in the menu as a
+// decimal such as 5.49.
+type Price int64
+
+// UnmarshalYAML reads a price written as a decimal.
+func (p *Price) UnmarshalYAML(value *yaml.Node) error {
+	amount, err := parsePrice(value.Value)
+	if err != nil {
+		return err
+	}
+	*p = amount
+	return nil
+}
+
+// String writes the price with two decimal places.
+func (p Price) String() string {
+	return fmt.Sprintf("%d.%02d", p/100, p%100)
+}
+
+// MarshalJSON writes the price as a decimal string, so no amount is
+// rounded through a float.
+func (p Price) MarshalJSON() ([]byte, error) {
+	return []byte(strconv.Quote(p.String())), nil
+}
+
+// parsePrice reads a decimal amount with at most two decimal places.
+func parsePrice(s string) (Price, error) {
+	whole, frac, _ := strings.Cut(strings.TrimSpace(s), ".")
+	if whole == "" || len(frac) > 2 || strings.Trim(whole+frac, "0123456789") != "" {
+		return 0, fmt.Errorf("invalid price %q: want e.g. 5.49", s)
+	}
+	units, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || units > 1e9 {
+		return 0, fmt.Errorf("invalid price %q", s)
+	}
+	frac += strings.Repeat("0", 2-len(frac))
+	cents, _ := strconv.ParseInt(frac, 10, 64)
+	return Price(units*100 + cents), nil
+}
+
+// menuFromEnv loads the menu from the YAML file named by MENU_FILE
+// (default menu.yaml).
+func menuFromEnv() (*Menu, error) {
+	path := os.Getenv("MENU_FILE")
+	if path == "" {
+		path = "menu.yaml"
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MENU_FILE: %w", err)
+	}
+	m := &Menu{Currency: "USD"}
+	if err := yaml.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("invalid MENU_FILE %s: %w", path, err)
+	}
+	if err := m.validate(); err != nil {
+		return nil, fmt.Errorf("invalid MENU_FILE %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// validate checks that every item can be named and priced.
+func (m *Menu) validate() error {
+	if len(m.Items) == 0 {
+		return errors.New("menu has no items")
+	}
+	seen := make(map[string]bool)
+	for i, it := range m.Items {
+		switch {
+		case it.ID == "" || it.Name == "":
+			return fmt.Errorf("item %d needs an id and a name", i+1)
+		case seen[it.ID]:
+			return fmt.Errorf("item %q is listed twice", it.ID)
+		case len(it.Sizes) == 0 && it.Price == 0:
+			return fmt.Errorf("item %q has no price or sizes", it.ID)
+		}
+		seen[it.ID] = true
+		for _, opts := range [][]Option{it.Sizes, it.Extras} {
+			for _, o := range opts {
+				if o.Name == "" {
+					return fmt.Errorf("item %q has a size or extra with no name", it.ID)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Item returns the item with id, or the one named name, ignoring case.
+func (m *Menu) Item(name string) (*Item, bool) {
+	for i := range m.Items {
+		if it := &m.Items[i]; strings.EqualFold(it.ID, name) || strings.EqualFold(it.Name, name) {
+			return it, true
+		}
+	}
+	return nil, false
+}
+
+// option returns the option named name, ignoring case.
+func option(opts []Option, name string) (Option, bool) {
+	i := slices.IndexFunc(opts, func(o Option) bool { return strings.EqualFold(o.Name, name) })
+	if i < 0 {
+		return Option{}, false
+	}
+	return opts[i], true
+}
+
+// names lists the options' names.
+func names(opts []Option) []string {
+	var out []string
+	for _, o := range opts {
+		out = append(out, o.Name)
+	}
+	return out
+}
+
+// Prompt describes the menu for the agent's system prompt, one item a
+// line.
+func (m *Menu) Prompt() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Menu (prices in %s):", m.Currency)
+	for _, it := range m.Items {
+		fmt.Fprintf(&b, "\n- %s (id %s", it.Name, it.ID)
+		if it.Category != "" {
+			fmt.Fprintf(&b, ", %s", it.Category)
+		}
+		b.WriteString("): ")
+		if len(it.Sizes) > 0 {
+			var sizes []string
+			for _, s := range it.Sizes {
+				sizes = append(sizes, s.Name+" "+s.Price.String())
+			}
+			b.WriteString("sizes " + strings.Join(sizes, ", "))
+		} else {
+			b.WriteString(it.Price.String())
+		}
+		if len(it.Ingredients) > 0 {
+			b.WriteString("; comes with " + strings.Join(it.Ingredients, ", "))
+		}
+		if len(it.Extras) > 0 {
+			var extras []string
+			for _, e := range it.Extras {
+				extras = append(extras, e.Name+" +"+e.Price.String())
+			}
+			b.WriteString("; extras " + strings.Join(extras, ", "))
+		}
+	}
+	return b.String()
+}
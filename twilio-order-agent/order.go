@@ -0,0 +1,459 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/agent"
+	"github.com/agentplexus/omnivoice-examples/kit/llm"
+	"github.com/agentplexus/omnivoice-examples/kit/storage"
+)
+
+// Order tools the agent builds each call's order with.
+const (
+	toolAddItem      = "add_item"
+	toolChangeItem   = "change_item"
+	toolRemoveItem   = "remove_item"
+	toolReadOrder    = "read_order"
+	toolConfirmOrder = "confirm_order"
+)
+
+// orderSaveTimeout bounds storing a confirmed order.
+const orderSaveTimeout = 30 * time.Second
+
+// maxQuantity caps how many of an item one line may have, so a misheard
+// "eighty" for "eight" is questioned rather than ordered.
+const maxQuantity = 20
+
+// Order is a call's order, emitted as JSON once the caller confirms it.
+type Order struct {
+	// Number is what the caller is told to collect the order by.
+	Number     int         `json:"number"`
+	CallSID    string      `json:"call_sid,omitempty"`
+	Caller     string      `json:"caller,omitempty"`
+	Restaurant string      `json:"restaurant,omitempty"`
+	Currency   string      `json:"currency"`
+	Lines      []OrderLine `json:"lines"`
+	Total      Price       `json:"total"`
+	Confirmed  time.Time   `json:"confirmed"`
+}
+
+// OrderLine is one item of an order, with its choices.
+type OrderLine struct {
+	// Line numbers the order's lines from 1, for changing them.
+	Line     int    `json:"line"`
+	Item     string `json:"item"`
+	Name     string `json:"name"`
+	Size     string `json:"size,omitempty"`
+	Quantity int    `json:"quantity"`
+	// Without are ingredients left out, and Extras those added.
+	Without   []string `json:"without,omitempty"`
+	Extras    []string `json:"extras,omitempty"`
+	UnitPrice Price    `json:"unit_price"`
+	Total     Price    `json:"total"`
+}
+
+// orderCall is the order being taken on a call.
+type orderCall struct {
+	order     Order
+	confirmed bool
+	nextLine  int
+}
+
+// Orders takes each call's order with the agent's tools: items are added,
+// changed and removed one tool call at a time, each checked against the
+// menu, and the order so far is read back after every change. A confirmed
+// order is stored as JSON.
+type Orders struct {
+	menu  *Menu
+	store storage.Storage
+
+	mu     sync.Mutex
+	calls  map[string]*orderCall
+	number int
+}
+
+// NewOrders returns orders taken from menu and stored in store.
+func NewOrders(menu *Menu, store storage.Storage) *Orders {
+	return &Orders{menu: menu, store: store, calls: make(map[string]*orderCall)}
+}
+
+// Begin starts an empty order for a session.
+func (o *Orders) Begin(sessionID, callSID, caller string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.calls[sessionID] = &orderCall{
+		order:    Order{CallSID: callSID, Caller: caller, Restaurant: o.menu.Name, Currency: o.menu.Currency},
+		nextLine: 1,
+	}
+}
+
+// End forgets a session's order, logging one the caller hung up on before
+// confirming.
+func (o *Orders) End(sessionID string) {
+	o.mu.Lock()
+	c := o.calls[sessionID]
+	delete(o.calls, sessionID)
+	o.mu.Unlock()
+	if c != nil && !c.confirmed && len(c.order.Lines) > 0 {
+		slog.Info("order abandoned", "session", sessionID, "call_sid", c.order.CallSID, "lines", len(c.order.Lines))
+	}
+}
+
+// Tools returns the tools the agent takes orders with.
+func (o *Orders) Tools() []agent.Tool {
+	choices := `"size":{"type":"string","description":"The size, for items that come in sizes."},` +
+		`"without":{"type":"array","items":{"type":"string"},"description":"Ingredients to leave out, e.g. onions for \"no onions\"."},` +
+		`"extras":{"type":"array","items":{"type":"string"},"description":"Extras to add, e.g. bacon."}`
+	return []agent.Tool{
+		{
+			Tool: llm.Tool{
+				Name:        toolAddItem,
+				Description: "Add an item to the order. If the result asks for a choice, such as a size, ask the customer and call again.",
+				Parameters: json.RawMessage(`{"type":"object","properties":{` +
+					`"item":{"type":"string","description":"The item's id from the menu."},` +
+					`"quantity":{"type":"integer","description":"How many, default 1."},` + choices + `},"required":["item"]}`),
+			},
+			Call: o.addItem,
+		},
+		{
+			Tool: llm.Tool{
+				Name:        toolChangeItem,
+				Description: "Change a line of the order: its quantity or size, ingredients to leave out or put back, or extras to add or take off.",
+				Parameters: json.RawMessage(`{"type":"object","properties":{` +
+					`"line":{"type":"integer","description":"The line number from the order."},` +
+					`"quantity":{"type":"integer","description":"The new quantity."},` + choices + `,` +
+					`"restore":{"type":"array","items":{"type":"string"},"description":"Ingredients left out to put back."},` +
+					`"remove_extras":{"type":"array","items":{"type":"string"},"description":"Extras to take off."}},"required":["line"]}`),
+			},
+			Call: o.changeItem,
+		},
+		{
+			Tool: llm.Tool{
+				Name:        toolRemoveItem,
+				Description: "Remove a line from the order.",
+				Parameters:  json.RawMessage(`{"type":"object","properties":{"line":{"type":"integer","description":"The line number from the order."}},"required":["line"]}`),
+			},
+			Call: o.removeItem,
+		},
+		{
+			Tool: llm.Tool{
+				Name:        toolReadOrder,
+				Description: "Get the whole order and its total, to read back to the customer before they confirm it.",
+				Parameters:  json.RawMessage(`{"type":"object","properties":{}}`),
+			},
+			Call:       o.readOrder,
+			Idempotent: true,
+		},
+		{
+			Tool: llm.Tool{
+				Name:        toolConfirmOrder,
+				Description: "Send the order to the kitchen, only after reading it back and the customer confirming it is right. Then tell them their order number and total.",
+				Parameters:  json.RawMessage(`{"type":"object","properties":{}}`),
+			},
+			Call: o.confirmOrder,
+		},
+	}
+}
+
+// call returns the order of the session a tool runs for, with o.mu held.
+// It returns an error if there is none, and a message for the agent if it
+// was already confirmed.
+func (o *Orders) call(ctx context.Context) (*orderCall, string, error) {
+	sessionID, _ := agent.SessionID(ctx)
+	o.mu.Lock()
+	c := o.calls[sessionID]
+	if c == nil {
+		o.mu.Unlock()
+		return nil, "", errors.New("no order is being taken on this call")
+	}
+	if c.confirmed {
+		return c, fmt.Sprintf("Order %d has already been sent to the kitchen and can't be changed on this call.", c.order.Number), nil
+	}
+	return c, "", nil
+}
+
+type lineChoices struct {
+	Quantity int      `json:"quantity"`
+	Size     string   `json:"size"`
+	Without  []string `json:"without"`
+	Extras   []string `json:"extras"`
+}
+
+// addItem adds an item, or asks for what's missing or not on the menu.
+func (o *Orders) addItem(ctx context.Context, args json.RawMessage) (string, error) {
+	var params struct {
+		Item string `json:"item"`
+		lineChoices
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+	item, ok := o.menu.Item(params.Item)
+	if !ok {
+		return fmt.Sprintf("%q isn't on the menu. Offer something that is.", params.Item), nil
+	}
+	if params.Quantity == 0 {
+		params.Quantity = 1
+	}
+	line := OrderLine{Item: item.ID, Name: item.Name}
+	if problem := line.apply(item, params.lineChoices, nil, nil); problem != "" {
+		return problem, nil
+	}
+
+	c, done, err := o.call(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer o.mu.Unlock()
+	if done != "" {
+		return done, nil
+	}
+	line.Line = c.nextLine
+	c.nextLine++
+	c.order.Lines = append(c.order.Lines, line)
+	return fmt.Sprintf("Added line %d: %s. %s", line.Line, line.describe(), c.order.summary()), nil
+}
+
+// changeItem changes a line's choices.
+func (o *Orders) changeItem(ctx context.Context, args json.RawMessage) (string, error) {
+	var params struct {
+		Line int `json:"line"`
+		lineChoices
+		Restore      []string `json:"restore"`
+		RemoveExtras []string `json:"remove_extras"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+	c, done, err := o.call(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer o.mu.Unlock()
+	if done != "" {
+		return done, nil
+	}
+	i := c.order.line(params.Line)
+	if i < 0 {
+		return fmt.Sprintf("There is no line %d. %s", params.Line, c.order.summary()), nil
+	}
+	line := c.order.Lines[i]
+	item, _ := o.menu.Item(line.Item)
+	// Choices not mentioned stay as they were
+	if params.Quantity == 0 {
+		params.Quantity = line.Quantity
+	}
+	if params.Size == "" {
+		params.Size = line.Size
+	}
+	params.Without = append(slices.Clone(line.Without), params.Without...)
+	params.Extras = append(slices.Clone(line.Extras), params.Extras...)
+	if problem := line.apply(item, params.lineChoices, params.Restore, params.RemoveExtras); problem != "" {
+		return problem, nil
+	}
+	c.order.Lines[i] = line
+	return fmt.Sprintf("Changed line %d to %s. %s", line.Line, line.describe(), c.order.summary()), nil
+}
+
+// removeItem removes a line.
+func (o *Orders) removeItem(ctx context.Context, args json.RawMessage) (string, error) {
+	var params struct {
+		Line int `json:"line"`
+	}
+	if err := json.Unmarshal(args, &params); err != nil {
+		return "", fmt.Errorf("invalid arguments: %w", err)
+	}
+	c, done, err := o.call(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer o.mu.Unlock()
+	if done != "" {
+		return done, nil
+	}
+	i := c.order.line(params.Line)
+	if i < 0 {
+		return fmt.Sprintf("There is no line %d. %s", params.Line, c.order.summary()), nil
+	}
+	removed := c.order.Lines[i]
+	c.order.Lines = slices.Delete(c.order.Lines, i, i+1)
+	return fmt.Sprintf("Removed %s. %s", removed.describe(), c.order.summary()), nil
+}
+
+// readOrder returns the order for reading back.
+func (o *Orders) readOrder(ctx context.Context, _ json.RawMessage) (string, error) {
+	c, _, err := o.call(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer o.mu.Unlock()
+	return c.order.summary(), nil
+}
+
+// confirmOrder numbers the order, emits it as JSON and stores it.
+func (o *Orders) confirmOrder(ctx context.Context, _ json.RawMessage) (string, error) {
+	c, done, err := o.call(ctx)
+	if err != nil {
+		return "", err
+	}
+	if done != "" {
+		o.mu.Unlock()
+		return done, nil
+	}
+	if len(c.order.Lines) == 0 {
+		o.mu.Unlock()
+		return "The order is empty; there is nothing to confirm.", nil
+	}
+	o.number++
+	c.confirmed = true
+	c.order.Number = o.number
+	c.order.Total = c.order.total()
+	c.order.Confirmed = time.Now()
+	order := c.order
+	o.mu.Unlock()
+
+	data, err := json.MarshalIndent(order, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	slog.Info("order confirmed", "number", order.Number, "call_sid", order.CallSID, "total", order.Total.String(), "order", string(data))
+	if o.store != nil {
+		saveCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), orderSaveTimeout)
+		defer cancel()
+		key := fmt.Sprintf("%s/order-%d.json", firstNonEmpty(order.CallSID, "unknown"), order.Number)
+		if err := o.store.Put(saveCtx, key, data, "application/json"); err != nil {
+			// The kitchen already has it in the log; the caller shouldn't
+			// be told the order failed
+			slog.Error("failed to store order", "number", order.Number, "error", err)
+		}
+	}
+	return fmt.Sprintf("Order %d confirmed, total %s %s.", order.Number, order.Total, order.Currency), nil
+}
+
+// apply sets l's quantity and choices for item, with the ingredients in
+// restore put back and the extras in removeExtras taken off, and prices
+// it. It returns what to ask the caller if a choice is missing or not on
+// the menu, leaving l unchanged.
+func (l *OrderLine) apply(item *Item, choices lineChoices, restore, removeExtras []string) string {
+	next := *l
+	if choices.Quantity < 1 || choices.Quantity > maxQuantity {
+		return fmt.Sprintf("Check the quantity with the customer: %d %s is more than can be ordered at once (at most %d).", choices.Quantity, item.Name, maxQuantity)
+	}
+	next.Quantity = choices.Quantity
+
+	next.UnitPrice = item.Price
+	next.Size = ""
+	if len(item.Sizes) > 0 {
+		if choices.Size == "" {
+			return fmt.Sprintf("Ask which size of %s: %s.", item.Name, strings.Join(names(item.Sizes), ", "))
+		}
+		size, ok := option(item.Sizes, choices.Size)
+		if !ok {
+			return fmt.Sprintf("%s doesn't come in %s; ask for %s.", item.Name, choices.Size, strings.Join(names(item.Sizes), ", "))
+		}
+		next.Size, next.UnitPrice = size.Name, size.Price
+	}
+
+	next.Without = nil
+	for _, ingredient := range choices.Without {
+		i := slices.IndexFunc(item.Ingredients, func(s string) bool { return strings.EqualFold(s, ingredient) })
+		if i < 0 {
+			return fmt.Sprintf("%s doesn't come with %s; it comes with %s.", item.Name, ingredient, listOrNothing(item.Ingredients))
+		}
+		if !slices.Contains(next.Without, item.Ingredients[i]) && !containsFold(restore, item.Ingredients[i]) {
+			next.Without = append(next.Without, item.Ingredients[i])
+		}
+	}
+
+	next.Extras = nil
+	for _, name := range choices.Extras {
+		extra, ok := option(item.Extras, name)
+		if !ok {
+			return fmt.Sprintf("%s can't have %s added; the extras are %s.", item.Name, name, listOrNothing(names(item.Extras)))
+		}
+		if !slices.Contains(next.Extras, extra.Name) && !containsFold(removeExtras, extra.Name) {
+			next.Extras = append(next.Extras, extra.Name)
+			next.UnitPrice += extra.Price
+		}
+	}
+
+	next.Total = next.UnitPrice * Price(next.Quantity)
+	*l = next
+	return ""
+}
+
+// describe says what a line is, e.g. "2 large Fries" or "1 Cheeseburger,
+// no onions, add bacon".
+func (l OrderLine) describe() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d ", l.Quantity)
+	if l.Size != "" {
+		b.WriteString(l.Size + " ")
+	}
+	b.WriteString(l.Name)
+	for _, w := range l.Without {
+		b.WriteString(", no " + w)
+	}
+	for _, e := range l.Extras {
+		b.WriteString(", add " + e)
+	}
+	fmt.Fprintf(&b, " (%s)", l.Total)
+	return b.String()
+}
+
+// summary reads back the whole order and its total.
+func (o Order) summary() string {
+	if len(o.Lines) == 0 {
+		return "The order is empty."
+	}
+	var lines []string
+	for _, l := range o.Lines {
+		lines = append(lines, fmt.Sprintf("line %d: %s", l.Line, l.describe()))
+	}
+	return fmt.Sprintf("Order so far: %s. Total %s %s.", strings.Join(lines, "; "), o.total(), o.Currency)
+}
+
+// total adds up the order's lines.
+func (o Order) total() Price {
+	var total Price
+	for _, l := range o.Lines {
+		total += l.Total
+	}
+	return total
+}
+
+// line returns the index of the line numbered n, or -1.
+func (o Order) line(n int) int {
+	return slices.IndexFunc(o.Lines, func(l OrderLine) bool { return l.Line == n })
+}
+
+// containsFold reports whether list holds s, ignoring case.
+func containsFold(list []string, s string) bool {
+	return slices.ContainsFunc(list, func(v string) bool { return strings.EqualFold(v, s) })
+}
+
+// listOrNothing joins names, or says there are none.
+func listOrNothing(names []string) string {
+	if len(names) == 0 {
+		return "nothing"
+	}
+	return strings.Join(names, ", ")
+}
+
+// firstNonEmpty returns the first of values that isn't empty.
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/agentplexus/omnivoice-examples/kit/agent"
+)
+
+// Stream parameter names for the call's own details, set by
+// handleInboundCall.
+const (
+	paramCallSID = "callSid"
+	paramCaller  = "caller"
+)
+
+// Surveyor is the agent on the line: it takes each answer, said or keyed
+// in, and asks what the survey asks next, hanging up after the closing.
+type Surveyor struct {
+	survey  *Survey
+	results *Results
+}
+
+// OnUserTurn answers the question being asked. An empty turn is a caller
+// who didn't answer, which counts as an attempt.
+func (a *Surveyor) OnUserTurn(ctx context.Context, turn agent.Turn) (<-chan agent.Response, error) {
+	callSID := turn.Metadata[paramCallSID]
+	input := "speech"
+	if turn.DTMF {
+		input = "dtmf"
+	}
+	slog.Info("survey answer", "call_sid", callSID, "input", input, "answer", turn.Text)
+	return agent.Reply(a.responses(a.results.Answer(a.survey, callSID, input, turn.Text))...), nil
+}
+
+// responses says what a turn says and asks its question. A turn with no
+// question ends the call.
+func (a *Surveyor) responses(t turn) []agent.Response {
+	var responses []agent.Response
+	for _, line := range t.say {
+		if line != "" {
+			responses = append(responses, agent.Say(line))
+		}
+	}
+	if t.ask == nil {
+		return append(responses, agent.Do(agent.ActionHangup, ""))
+	}
+	return append(responses, agent.Say(t.ask.Text))
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/agentplexus/omnivoice-examples/kit/agent"
+	"github.com/agentplexus/omnivoice-examples/kit/storage"
+)
+
+func TestSurveyor(t *testing.T) {
+	dir := t.TempDir()
+	survey := defaultSurvey()
+	results := NewResults(&storage.Dir{Root: dir})
+	a := &Surveyor{survey: survey, results: results}
+	metadata := map[string]string{paramCallSID: "CA1", paramCaller: "+15555550123"}
+
+	start := a.responses(results.Start(survey, "CA1", "+15555550123"))
+	if len(start) != 2 || start[0].Text != survey.Intro || start[1].Text != survey.Questions[0].Text {
+		t.Fatalf("start = %+v, want the intro and first question", start)
+	}
+	for _, tt := range []struct {
+		turn agent.Turn
+		want string
+	}{
+		// Silence asks again, after the retry line
+		{agent.Turn{}, survey.Questions[0].Text},
+		{agent.Turn{Text: "I'd say a five"}, "Sorry to hear that. What's the one thing we could do better?"},
+		{agent.Turn{Text: "Shorter queues."}, survey.Questions[4].Text},
+		{agent.Turn{Text: "4", DTMF: true}, survey.Closing},
+	} {
+		tt.turn.Metadata = metadata
+		responses, err := a.OnUserTurn(context.Background(), tt.turn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var said []string
+		hangup := false
+		for r := range responses {
+			if r.Text != "" {
+				said = append(said, r.Text)
+			}
+			hangup = hangup || r.Action != nil && r.Action.Kind == agent.ActionHangup
+		}
+		if len(said) == 0 || said[len(said)-1] != tt.want {
+			t.Errorf("answer %q: said %q, want %q last", tt.turn.Text, said, tt.want)
+		}
+		if hangup != (tt.want == survey.Closing) {
+			t.Errorf("answer %q: hung up %v", tt.turn.Text, hangup)
+		}
+	}
+	results.Close()
+
+	data, err := os.ReadFile(filepath.Join(dir, "CA1", "survey.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result Result
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatal(err)
+	}
+	if !result.Completed || len(result.Answers) != 3 {
+		t.Fatalf("result = %+v", result)
+	}
+	if a := result.Answers[0]; a.Rating == nil || *a.Rating != 5 || a.Attempts != 2 {
+		t.Errorf("nps answer = %+v", a)
+	}
+	if a := result.Answers[2]; a.Rating == nil || *a.Rating != 4 || a.Input != "dtmf" {
+		t.Errorf("satisfaction answer = %+v", a)
+	}
+}
+
+func TestResultsEndStoresIncomplete(t *testing.T) {
+	dir := t.TempDir()
+	survey := defaultSurvey()
+	results := NewResults(&storage.Dir{Root: dir})
+	results.Start(survey, "CA1", "")
+	results.End("CA1")
+	results.Close()
+
+	data, err := os.ReadFile(filepath.Join(dir, "CA1", "survey.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result Result
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatal(err)
+	}
+	if result.Completed {
+		t.Error("survey ended part-way stored as completed")
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/golden"
+	"github.com/agentplexus/omnivoice-examples/kit/storage"
+)
+
+// update rewrites golden files with what the tests produce instead of
+// comparing them.
+var update = flag.Bool("update", false, "rewrite golden files")
+
+// Fixed inputs for the snapshots.
+const (
+	goldenCallSID = "CA00000000000000000000000000000000"
+	goldenCaller  = "+15551230001"
+)
+
+// goldenTime stands in for every time in a stored result.
+var goldenTime = time.Date(2025, 1, 2, 15, 4, 5, 0, time.UTC)
+
+// TestGolden compares the TwiML returned by the voice webhook, and the
+// results stored for a completed and an abandoned survey, with the files
+// in testdata/golden. Run it with -update to rewrite them after an
+// intended change.
+func TestGolden(t *testing.T) {
+	s := &Server{}
+	form := url.Values{"CallSid": {goldenCallSID}, "From": {goldenCaller}, "To": {"+15551230002"}}
+	r := httptest.NewRequest(http.MethodPost, "https://voice.example.com/voice/inbound", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	w := httptest.NewRecorder()
+	s.handleInboundCall(w, r)
+
+	golden.Check(t, "testdata/golden", map[string]string{
+		"inbound.xml": w.Body.String(),
+		"result-completed.json": surveyResult(t, []answer{
+			{"speech", "I'd say a five"},
+			{"speech", "Shorter queues."},
+			{"dtmf", "4"},
+		}),
+		"result-skipped.json": surveyResult(t, []answer{
+			{"speech", ""},
+			{"speech", "eleven"},
+			{"dtmf", "9"},
+			{"dtmf", "3"},
+		}),
+		"result-incomplete.json": surveyResult(t, []answer{{"dtmf", "10"}}),
+	}, *update)
+}
+
+type answer struct{ input, text string }
+
+// surveyResult takes the default survey with answers, ending the call
+// after the last, and returns the result stored, with its times fixed.
+func surveyResult(t *testing.T, answers []answer) string {
+	t.Helper()
+	dir := t.TempDir()
+	survey := defaultSurvey()
+	results := NewResults(&storage.Dir{Root: dir})
+	results.Start(survey, goldenCallSID, goldenCaller)
+	for _, a := range answers {
+		results.Answer(survey, goldenCallSID, a.input, a.text)
+	}
+	results.End(goldenCallSID)
+	results.Close()
+
+	data, err := os.ReadFile(filepath.Join(dir, goldenCallSID, "survey.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result Result
+	if err := json.Unmarshal(data, &result); err != nil {
+		t.Fatal(err)
+	}
+	result.Started, result.Ended = goldenTime, goldenTime
+	for i := range result.Transcript {
+		result.Transcript[i].At = goldenTime
+	}
+	data, err = json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data) + "\n"
+}
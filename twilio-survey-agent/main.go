@@ -0,0 +1,239 @@
+// Example: Survey and NPS collection agent using Twilio Media Streams
+//
+// This example walks callers through a configurable script of questions:
+// ratings, said or keyed in, and open-ended follow-ups in their own words,
+// which can depend on an earlier rating. Each answer is checked before
+// the survey moves on, and each call's answers are written as structured
+// results, with the call's transcript, to storage:
+//
+//	┌──────────┐        ┌─────────────────┐         ┌──────────────────────────────┐
+//	│  Caller  │◄──────►│      Twilio     │◄───────►│  kit/session                 │
+//	│  (PSTN)  │  PSTN  │      Media      │WebSocket│  STT/keys ─► Surveyor ─► TTS │
+//	└──────────┘        │      Streams    │ (μ-law) │                 │            │
+//	                    └─────────────────┘         └─────────────────┼────────────┘
+//	                                                                  │ survey.json
+//	                                                                  ▼
+//	                                                           ┌──────────────┐
+//	                                                           │ kit/storage  │
+//	                                                           └──────────────┘
+//
+// The conversation is run by kit/session, with ElevenLabs speech-to-text
+// and text-to-speech; keys the caller presses arrive on the stream.
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	elevenlabs "github.com/agentplexus/go-elevenlabs"
+	elevenstt "github.com/agentplexus/go-elevenlabs/omnivoice/stt"
+	elevenvoice "github.com/agentplexus/go-elevenlabs/omnivoice/tts"
+	"github.com/agentplexus/omnivoice-examples/kit/config"
+	"github.com/agentplexus/omnivoice-examples/kit/mediastream"
+	"github.com/agentplexus/omnivoice-examples/kit/session"
+	"github.com/agentplexus/omnivoice-examples/kit/twilioauth"
+	"github.com/agentplexus/omnivoice-examples/kit/twiml"
+	twiliotransport "github.com/agentplexus/omnivoice-twilio/transport"
+	"github.com/agentplexus/omnivoice/pipeline"
+	"github.com/agentplexus/omnivoice/stt"
+	"github.com/agentplexus/omnivoice/transport"
+	"github.com/agentplexus/omnivoice/tts"
+)
+
+// answerTimeout is how long a caller may stay silent after a question
+// before it counts as unanswered.
+const answerTimeout = 5 * time.Second
+
+func main() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Load settings from CONFIG_FILE, if set, overridden by the environment
+	cfg, err := config.Load(os.Getenv("CONFIG_FILE"))
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	if cfg.ElevenLabs.APIKey == "" {
+		log.Fatal("ELEVENLABS_API_KEY (elevenlabs.api_key) required")
+	}
+	if cfg.Twilio.AccountSID == "" || cfg.Twilio.AuthToken == "" {
+		log.Fatal("TWILIO_ACCOUNT_SID and TWILIO_AUTH_TOKEN (twilio.account_sid and twilio.auth_token) required")
+	}
+	survey, err := surveyFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	store, err := storageFromEnv()
+	if err != nil {
+		log.Fatal(err)
+	}
+	results := NewResults(store)
+
+	// Create ElevenLabs STT and TTS providers
+	elevenClient, err := elevenlabs.NewClient(elevenlabs.WithAPIKey(cfg.ElevenLabs.APIKey))
+	if err != nil {
+		log.Fatalf("Failed to create ElevenLabs client: %v", err)
+	}
+
+	// Create Twilio Media Streams transport
+	twilioTransport, err := twiliotransport.New(
+		twiliotransport.WithAccountSID(cfg.Twilio.AccountSID),
+		twiliotransport.WithAuthToken(cfg.Twilio.AuthToken),
+	)
+	if err != nil {
+		log.Fatalf("Failed to create Twilio transport: %v", err)
+	}
+	defer func() {
+		if err := twilioTransport.Close(); err != nil {
+			slog.Error("failed to close Twilio transport", "error", err)
+		}
+	}()
+
+	server := &Server{
+		survey:      survey,
+		results:     results,
+		surveyor:    &Surveyor{survey: survey, results: results},
+		sttProvider: elevenstt.NewWithClient(elevenClient),
+		ttsProvider: elevenvoice.NewWithClient(elevenClient),
+		voice:       cfg.ElevenLabs,
+	}
+
+	// Media Streams are handed over once their start message, with the
+	// call's custom parameters, has arrived
+	streams, err := mediastream.NewServer(ctx, twilioTransport, "/media-stream")
+	if err != nil {
+		log.Fatalf("Failed to start Media Streams listener: %v", err)
+	}
+
+	// Serve only requests signed by Twilio
+	inbound := http.Handler(http.HandlerFunc(server.handleInboundCall))
+	mediaStream := http.Handler(streams)
+	if cfg.Twilio.ValidateSignatures {
+		signatures := &twilioauth.Validator{AuthToken: cfg.Twilio.AuthToken, PublicHost: cfg.Server.PublicHost}
+		inbound = signatures.Middleware(inbound)
+		mediaStream = signatures.Middleware(mediaStream)
+	} else {
+		slog.Warn("Twilio signature validation disabled; anyone can submit survey answers")
+	}
+	http.Handle("/voice/inbound", inbound)
+	http.Handle("/media-stream", mediaStream)
+
+	// Handle shutdown
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		cancel()
+	}()
+
+	go server.handleConnections(ctx, streams.Connections())
+
+	addr := cfg.Server.Addr
+	log.Printf("Starting server on %s with survey %q (%d questions)", addr, survey.Name, len(survey.Questions))
+	httpServer := &http.Server{
+		Addr:              addr,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	go func() {
+		if err := httpServer.ListenAndServe(); err != http.ErrServerClosed {
+			log.Fatalf("Server error: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	log.Println("Shutting down...")
+	_ = httpServer.Close()
+	// Surveys cut short are stored as incomplete
+	results.Close()
+}
+
+// Server answers Twilio's webhooks and Media Streams for the survey
+// number.
+type Server struct {
+	survey      *Survey
+	results     *Results
+	surveyor    *Surveyor
+	sttProvider stt.StreamingProvider
+	ttsProvider tts.StreamingProvider
+	voice       config.ElevenLabs
+}
+
+// handleInboundCall returns TwiML to connect the call to Media Streams.
+func (s *Server) handleInboundCall(w http.ResponseWriter, r *http.Request) {
+	from, callSID := r.FormValue("From"), r.FormValue("CallSid")
+	log.Printf("Incoming call: %s -> %s (SID: %s)", from, r.FormValue("To"), callSID)
+
+	doc := twiml.Response{twiml.Connect{Stream: twiml.Stream{
+		URL:        fmt.Sprintf("wss://%s/media-stream", r.Host),
+		Parameters: map[string]string{paramCallSID: callSID, paramCaller: from},
+	}}}
+	w.Header().Set("Content-Type", twiml.ContentType)
+	if _, err := w.Write([]byte(doc.String())); err != nil {
+		slog.Error("failed to write TwiML", "error", err)
+	}
+}
+
+// handleConnections processes incoming Media Streams connections.
+func (s *Server) handleConnections(ctx context.Context, connCh <-chan transport.Connection) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case conn := <-connCh:
+			go s.handleSession(ctx, conn)
+		}
+	}
+}
+
+// handleSession takes the survey on a single Media Stream. A caller who
+// hangs up part-way has their answers stored as incomplete.
+func (s *Server) handleSession(ctx context.Context, conn transport.Connection) {
+	var params map[string]string
+	if c, ok := conn.(interface{ CustomParameters() map[string]string }); ok {
+		params = c.CustomParameters()
+	}
+	callSID, caller := params[paramCallSID], params[paramCaller]
+	log.Printf("New session: %s (call SID: %s, caller: %s)", conn.ID(), callSID, caller)
+
+	// The intro and first question greet the caller
+	var greeting []string
+	for _, r := range s.surveyor.responses(s.results.Start(s.survey, callSID, caller)) {
+		if r.Text != "" {
+			greeting = append(greeting, r.Text)
+		}
+	}
+	voice := session.NewVoiceSession(conn, s.sttProvider, s.ttsProvider, s.surveyor, session.Options{
+		Metadata: params,
+		Greeting: strings.Join(greeting, " "),
+		NoInput:  answerTimeout,
+		DTMF:     true,
+		STT: pipeline.STTPipelineConfig{
+			Encoding:   "mulaw",
+			SampleRate: 8000,
+			Channels:   1,
+		},
+		TTS: pipeline.TTSPipelineConfig{
+			VoiceID:      s.voice.VoiceID,
+			OutputFormat: "ulaw",
+			SampleRate:   8000,
+			Model:        s.voice.Model,
+			OnError: func(err error) {
+				slog.Error("TTS error", "error", err, "session", conn.ID())
+			},
+		},
+	})
+	if err := voice.Run(ctx); err != nil {
+		slog.Error("session failed", "error", err, "session", conn.ID())
+	}
+	s.results.End(callSID)
+	_ = conn.Close()
+	log.Printf("Session ended: %s", conn.ID())
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/agentplexus/omnivoice-examples/kit/storage"
+)
+
+// resultsSaveTimeout bounds storing one call's results.
+const resultsSaveTimeout = 30 * time.Second
+
+// Transcript speakers.
+const (
+	speakerAgent  = "agent"
+	speakerCaller = "caller"
+)
+
+// Result is what a caller answered in a survey, stored as JSON.
+type Result struct {
+	CallSID string    `json:"call_sid"`
+	Caller  string    `json:"caller,omitempty"`
+	Survey  string    `json:"survey"`
+	Started time.Time `json:"started"`
+	Ended   time.Time `json:"ended"`
+	// Completed is false for a caller who hung up before the end.
+	Completed  bool             `json:"completed"`
+	Answers    []Answer         `json:"answers"`
+	Transcript []TranscriptLine `json:"transcript"`
+}
+
+// Answer is the answer to one question.
+type Answer struct {
+	Question string `json:"question"`
+	// Rating is set for a rating question, and Text for an open one.
+	Rating *int   `json:"rating,omitempty"`
+	Text   string `json:"text,omitempty"`
+	// Input is how the answer was given: "speech" or "dtmf".
+	Input string `json:"input,omitempty"`
+	// Attempts counts the times the question was asked.
+	Attempts int `json:"attempts"`
+	// Skipped is set when no valid answer was given in the survey's
+	// attempts.
+	Skipped bool `json:"skipped,omitempty"`
+}
+
+// TranscriptLine is one utterance of the call.
+type TranscriptLine struct {
+	Speaker string    `json:"speaker"`
+	Text    string    `json:"text"`
+	At      time.Time `json:"at"`
+}
+
+// storageFromEnv builds the store survey results are written to.
+// STORAGE_URL is a directory (default "surveys"), s3://bucket[/prefix] or
+// gs://bucket[/prefix], reached as the full example's call archive is.
+func storageFromEnv() (storage.Storage, error) {
+	raw := os.Getenv("STORAGE_URL")
+	if raw == "" {
+		raw = "surveys"
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid STORAGE_URL: %w", err)
+	}
+	prefix := strings.TrimPrefix(u.Path, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	switch u.Scheme {
+	case "s3":
+		s := &storage.S3{
+			Bucket:       u.Host,
+			Prefix:       prefix,
+			Region:       firstNonEmpty(os.Getenv("AWS_REGION"), os.Getenv("AWS_DEFAULT_REGION"), "us-east-1"),
+			Endpoint:     os.Getenv("AWS_ENDPOINT_URL_S3"),
+			AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		}
+		if s.Bucket == "" {
+			return nil, fmt.Errorf("invalid STORAGE_URL: %q names no bucket", raw)
+		}
+		if s.AccessKey == "" || s.SecretKey == "" {
+			return nil, errors.New("invalid STORAGE_URL: S3 needs AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+		}
+		return s, nil
+	case "gs":
+		if u.Host == "" {
+			return nil, fmt.Errorf("invalid STORAGE_URL: %q names no bucket", raw)
+		}
+		path := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS")
+		if path == "" {
+			return nil, errors.New("invalid STORAGE_URL: Google Cloud Storage needs GOOGLE_APPLICATION_CREDENTIALS")
+		}
+		gcs, err := storage.NewGCS(u.Host, prefix, path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid GOOGLE_APPLICATION_CREDENTIALS: %w", err)
+		}
+		return gcs, nil
+	case "", "file":
+		return &storage.Dir{Root: u.Path}, nil
+	default:
+		return nil, fmt.Errorf("invalid STORAGE_URL: unknown scheme %q (want a directory, s3:// or gs://)", u.Scheme)
+	}
+}
+
+// surveyCall is a survey in progress.
+type surveyCall struct {
+	result Result
+	// question is the index of the question being asked, and attempt how
+	// many times it has been.
+	question int
+	attempt  int
+}
+
+// Results tracks the surveys in progress, keyed by call SID, and stores
+// each call's result once it ends.
+type Results struct {
+	store storage.Storage
+
+	mu    sync.Mutex
+	calls map[string]*surveyCall
+	saves sync.WaitGroup
+}
+
+// NewResults returns results stored in store.
+func NewResults(store storage.Storage) *Results {
+	return &Results{store: store, calls: make(map[string]*surveyCall)}
+}
+
+// save stores a call's result in the background, as survey.json under the
+// call's SID, beside the transcripts and recordings the full example
+// archives there.
+func (r *Results) save(result Result) {
+	r.saves.Add(1)
+	go func() {
+		defer r.saves.Done()
+		ctx, cancel := context.WithTimeout(context.Background(), resultsSaveTimeout)
+		defer cancel()
+		data, err := json.MarshalIndent(result, "", "  ")
+		if err == nil {
+			err = r.store.Put(ctx, result.CallSID+"/survey.json", data, "application/json")
+		}
+		if err != nil {
+			slog.Error("failed to store survey result", "call_sid", result.CallSID, "error", err)
+			return
+		}
+		slog.Info("survey result stored", "call_sid", result.CallSID, "completed", result.Completed, "answers", len(result.Answers))
+	}()
+}
+
+// End stores the survey of a caller who hung up before the end as
+// incomplete.
+func (r *Results) End(callSID string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c, ok := r.calls[callSID]; ok {
+		delete(r.calls, callSID)
+		c.result.Ended = time.Now()
+		r.save(c.result)
+	}
+}
+
+// Close stores the surveys still in progress as incomplete and waits for
+// every result to be stored.
+func (r *Results) Close() {
+	r.mu.Lock()
+	for callSID, c := range r.calls {
+		delete(r.calls, callSID)
+		c.result.Ended = time.Now()
+		r.save(c.result)
+	}
+	r.mu.Unlock()
+	r.saves.Wait()
+}
+
+// firstNonEmpty returns the first of values that isn't empty.
+func firstNonEmpty(values ...string) string {
+	for _, v := range values {
+		if v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// turn is what the caller hears next: lines said, then a question asked,
+// or, once the survey is over, nothing more.
+type turn struct {
+	say []string
+	ask *Question
+}
+
+// Start begins the survey for a call, or, for a call already taking it,
+// e.g. because its stream reconnected, asks its question again.
+func (r *Results) Start(s *Survey, callSID, caller string) turn {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if c, ok := r.calls[callSID]; ok {
+		return c.ask(s, nil)
+	}
+	c := &surveyCall{
+		result:   Result{CallSID: callSID, Caller: caller, Survey: s.Name, Started: now},
+		question: s.next(0, nil),
+		attempt:  1,
+	}
+	r.calls[callSID] = c
+	var intro []string
+	if s.Intro != "" {
+		intro = append(intro, s.Intro)
+	}
+	return c.ask(s, intro)
+}
+
+// Answer takes a call's answer to the question being asked, given as
+// input ("speech" or "dtmf"), and returns what the caller hears next: the
+// next question, the same one again if the answer isn't valid, or the
+// closing once the last has been answered or skipped. An empty answer is
+// one the caller didn't give.
+func (r *Results) Answer(s *Survey, callSID, input, answer string) turn {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	c, ok := r.calls[callSID]
+	if !ok {
+		// Already over
+		return turn{say: []string{s.Closing}}
+	}
+	q := s.Questions[c.question]
+	answer = strings.TrimSpace(answer)
+	if answer != "" {
+		c.result.Transcript = append(c.result.Transcript, TranscriptLine{Speaker: speakerCaller, Text: answer, At: now})
+	}
+
+	a := Answer{Question: q.ID, Input: input, Attempts: c.attempt}
+	valid := false
+	switch q.Type {
+	case questionRating:
+		if rating, ok := parseRating(q, answer); ok {
+			a.Rating, valid = &rating, true
+		}
+	case questionOpen:
+		a.Text, valid = answer, answer != "" && input == "speech"
+	}
+	if !valid {
+		if c.attempt < s.Attempts {
+			c.attempt++
+			var retry []string
+			if s.Retry != "" {
+				retry = append(retry, s.Retry)
+			}
+			return c.ask(s, retry)
+		}
+		a = Answer{Question: q.ID, Attempts: c.attempt, Skipped: true}
+	}
+	c.result.Answers = append(c.result.Answers, a)
+
+	c.question, c.attempt = s.next(c.question+1, c.result.Answers), 1
+	if c.question < len(s.Questions) {
+		return c.ask(s, nil)
+	}
+	delete(r.calls, callSID)
+	c.result.Completed, c.result.Ended = true, now
+	c.said(s.Closing)
+	r.save(c.result)
+	return turn{say: []string{s.Closing}}
+}
+
+// ask asks the call's current question after lines, recording both in
+// its transcript.
+func (c *surveyCall) ask(s *Survey, lines []string) turn {
+	for _, line := range lines {
+		c.said(line)
+	}
+	q := &s.Questions[c.question]
+	c.said(q.Text)
+	return turn{say: lines, ask: q}
+}
+
+// said records a line the agent said.
+func (c *surveyCall) said(text string) {
+	c.result.Transcript = append(c.result.Transcript, TranscriptLine{Speaker: speakerAgent, Text: text, At: time.Now()})
+}
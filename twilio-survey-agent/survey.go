@@ -0,0 +1,206 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Question types.
+const (
+	// questionRating asks for a whole number on a scale, said or keyed in.
+	questionRating = "rating"
+	// questionOpen asks for an answer in the caller's own words.
+	questionOpen = "open"
+)
+
+// Survey is a script of questions a caller is walked through.
+type Survey struct {
+	// Name identifies the survey in its results.
+	Name string `yaml:"name"`
+	// Intro is said before the first question, and Closing once the last
+	// has been answered.
+	Intro   string `yaml:"intro"`
+	Closing string `yaml:"closing"`
+	// Retry is said before asking again after an answer that isn't valid,
+	// such as a rating off the scale.
+	Retry string `yaml:"retry"`
+	// Attempts is how many times a question is asked before it is skipped.
+	Attempts  int        `yaml:"attempts"`
+	Questions []Question `yaml:"questions"`
+}
+
+// Question is one question of a survey.
+type Question struct {
+	ID   string `yaml:"id"`
+	Text string `yaml:"text"`
+	// Type is "rating" or "open".
+	Type string `yaml:"type"`
+	// Min and Max bound a rating, inclusive.
+	Min int `yaml:"min"`
+	Max int `yaml:"max"`
+	// When, if set, asks the question only after an earlier rating in a
+	// range, e.g. a follow-up for callers who scored low.
+	When *Condition `yaml:"when"`
+}
+
+// Condition holds when an earlier rating question was answered with a
+// rating from Min to Max.
+type Condition struct {
+	Question string `yaml:"question"`
+	Min      int    `yaml:"min"`
+	Max      int    `yaml:"max"`
+}
+
+// defaultSurvey is a Net Promoter Score survey: the 0 to 10 "would you
+// recommend us" question, a follow-up that depends on the score, and a
+// satisfaction rating.
+func defaultSurvey() *Survey {
+	return &Survey{
+		Name:     "nps",
+		Intro:    "Thanks for taking our short survey. It has three questions.",
+		Closing:  "That's everything. Thank you for your feedback, goodbye.",
+		Retry:    "Sorry, I didn't get that.",
+		Attempts: 2,
+		Questions: []Question{
+			{
+				ID:   "nps",
+				Text: "On a scale of zero to ten, how likely are you to recommend us to a friend or colleague? Say a number, or key it in and press pound.",
+				Type: questionRating,
+				Min:  0,
+				Max:  10,
+			},
+			{
+				ID:   "improve",
+				Text: "Sorry to hear that. What's the one thing we could do better?",
+				Type: questionOpen,
+				When: &Condition{Question: "nps", Min: 0, Max: 6},
+			},
+			{
+				ID:   "perfect",
+				Text: "Thanks. What would make it a ten for you?",
+				Type: questionOpen,
+				When: &Condition{Question: "nps", Min: 7, Max: 8},
+			},
+			{
+				ID:   "like",
+				Text: "Great to hear. What do you like most about us?",
+				Type: questionOpen,
+				When: &Condition{Question: "nps", Min: 9, Max: 10},
+			},
+			{
+				ID:   "satisfaction",
+				Text: "Last one. On a scale of one to five, how satisfied were you with your most recent visit? Say or press a number.",
+				Type: questionRating,
+				Min:  1,
+				Max:  5,
+			},
+		},
+	}
+}
+
+// surveyFromEnv loads the survey from the YAML file named by SURVEY_FILE,
+// or returns the default NPS survey if it isn't set.
+func surveyFromEnv() (*Survey, error) {
+	path := os.Getenv("SURVEY_FILE")
+	if path == "" {
+		return defaultSurvey(), nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SURVEY_FILE: %w", err)
+	}
+	s := &Survey{Attempts: 2}
+	if err := yaml.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("invalid SURVEY_FILE %s: %w", path, err)
+	}
+	if err := s.validate(); err != nil {
+		return nil, fmt.Errorf("invalid SURVEY_FILE %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// validate checks that every question can be asked and answered, and that
+// conditions refer to rating questions asked before them.
+func (s *Survey) validate() error {
+	if len(s.Questions) == 0 {
+		return errors.New("survey has no questions")
+	}
+	if s.Attempts < 1 {
+		return fmt.Errorf("attempts %d (want at least 1)", s.Attempts)
+	}
+	asked := make(map[string]Question)
+	for i, q := range s.Questions {
+		switch {
+		case q.ID == "":
+			return fmt.Errorf("question %d has no id", i+1)
+		case q.Text == "":
+			return fmt.Errorf("question %q has no text", q.ID)
+		case asked[q.ID].ID != "":
+			return fmt.Errorf("question %q is listed twice", q.ID)
+		}
+		switch q.Type {
+		case questionRating:
+			if q.Min < 0 || q.Max <= q.Min {
+				return fmt.Errorf("question %q: invalid scale %d to %d", q.ID, q.Min, q.Max)
+			}
+		case questionOpen:
+		default:
+			return fmt.Errorf("question %q: unknown type %q (want rating or open)", q.ID, q.Type)
+		}
+		if c := q.When; c != nil {
+			if earlier, ok := asked[c.Question]; !ok || earlier.Type != questionRating {
+				return fmt.Errorf("question %q: when refers to %q, not an earlier rating question", q.ID, c.Question)
+			}
+		}
+		asked[q.ID] = q
+	}
+	return nil
+}
+
+// next returns the index of the first question from i on that is asked
+// given the answers so far, or len(s.Questions) if none is.
+func (s *Survey) next(i int, answers []Answer) int {
+	for ; i < len(s.Questions); i++ {
+		c := s.Questions[i].When
+		if c == nil {
+			return i
+		}
+		for _, a := range answers {
+			if a.Question == c.Question && a.Rating != nil && *a.Rating >= c.Min && *a.Rating <= c.Max {
+				return i
+			}
+		}
+	}
+	return len(s.Questions)
+}
+
+// numberWords are the numbers a rating is said as.
+var numberWords = map[string]int{
+	"zero": 0, "nought": 0, "one": 1, "two": 2, "three": 3, "four": 4, "five": 5,
+	"six": 6, "seven": 7, "eight": 8, "nine": 9, "ten": 10,
+}
+
+// parseRating reads a rating from the first number in an answer, keyed in
+// ("7") or said ("I'd give it an eight", "9 out of 10"), and checks that
+// it is on q's scale.
+func parseRating(q Question, answer string) (int, bool) {
+	for _, w := range strings.FieldsFunc(strings.ToLower(answer), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		n, ok := numberWords[w]
+		if !ok {
+			var err error
+			if n, err = strconv.Atoi(w); err != nil {
+				continue
+			}
+		}
+		return n, n >= q.Min && n <= q.Max
+	}
+	return 0, false
+}